@@ -0,0 +1,134 @@
+// Package clidoc generates shell completions and man pages from the command definitions of a
+// command line interface built with urfave/cli, so that they cannot get out of date with it.
+// The completions call the program itself with --generate-bash-completion to list the
+// commands and flags, hence the program needs to set EnableBashCompletion
+package clidoc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Shells for which Completion can generate completions
+var Shells = []string{"bash", "zsh", "fish"}
+
+// Like the autocomplete scripts of urfave/cli, with PROG replaced by the name of the program
+const bashCompletion = `#! /bin/bash
+
+_PROG_bash_autocomplete() {
+  if [[ "${COMP_WORDS[0]}" != "source" ]]; then
+    local cur opts base
+    COMPREPLY=()
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    if [[ "$cur" == "-"* ]]; then
+      opts=$( ${COMP_WORDS[@]:0:$COMP_CWORD} ${cur} --generate-bash-completion )
+    else
+      opts=$( ${COMP_WORDS[@]:0:$COMP_CWORD} --generate-bash-completion )
+    fi
+    COMPREPLY=( $(compgen -W "${opts}" -- ${cur}) )
+    return 0
+  fi
+}
+
+complete -o bashdefault -o default -o nospace -F _PROG_bash_autocomplete PROG
+`
+
+const zshCompletion = `#compdef PROG
+
+_PROG_zsh_autocomplete() {
+
+  local -a opts
+  local cur
+  cur=${words[-1]}
+  if [[ "$cur" == "-"* ]]; then
+    opts=("${(@f)$(_CLI_ZSH_AUTOCOMPLETE_HACK=1 ${words[@]:0:#words[@]-1} ${cur} --generate-bash-completion)}")
+  else
+    opts=("${(@f)$(_CLI_ZSH_AUTOCOMPLETE_HACK=1 ${words[@]:0:#words[@]-1} --generate-bash-completion)}")
+  fi
+
+  if [[ "${opts[1]}" != "" ]]; then
+    _describe 'values' opts
+  else
+    _files
+  fi
+
+  return
+}
+
+compdef _PROG_zsh_autocomplete PROG
+`
+
+// Completion returns the completion script of app for shell, which is one of Shells
+func Completion(app *cli.App, shell string) (string, error) {
+	// Shell function names cannot contain all characters that program names can
+	function := strings.NewReplacer("-", "_", ".", "_").Replace(app.Name)
+	switch shell {
+	case "bash":
+		return strings.NewReplacer("_PROG_", "_"+function+"_", "PROG", app.Name).Replace(bashCompletion), nil
+	case "zsh":
+		return strings.NewReplacer("_PROG_", "_"+function+"_", "PROG", app.Name).Replace(zshCompletion), nil
+	case "fish":
+		return app.ToFishCompletion()
+	}
+	return "", fmt.Errorf("unsupported shell %q, use one of %s", shell, strings.Join(Shells, ", "))
+}
+
+// ManPage returns the man page of app in roff format, in section 1 for user commands
+func ManPage(app *cli.App) (string, error) {
+	page, err := app.ToMan()
+	if err != nil {
+		return "", err
+	}
+	// urfave/cli puts all man pages into section 8
+	lines := strings.Split(page, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".TH ") {
+			lines[i] = strings.TrimSuffix(line, " 8") + " 1"
+			break
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Commands returns the "completion" and "man" commands, which print the completion script
+// and the man page of the app they are added to
+func Commands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:      "completion",
+			Usage:     "Print the shell completion script, e.g., for ~/.local/share/bash-completion/completions/",
+			ArgsUsage: strings.Join(Shells, "|"),
+			BashComplete: func(c *cli.Context) {
+				for _, shell := range Shells {
+					fmt.Fprintln(c.App.Writer, shell)
+				}
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return errors.New("Please specify one of " + strings.Join(Shells, ", "))
+				}
+				script, err := Completion(c.App, c.Args().First())
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(c.App.Writer, script)
+				return nil
+			},
+		},
+		{
+			Name:  "man",
+			Usage: "Print the man page in roff format, e.g., for /usr/local/share/man/man1/",
+			Action: func(c *cli.Context) error {
+				page, err := ManPage(c.App)
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(c.App.Writer, page)
+				return nil
+			},
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package clidoc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func testApp(out *bytes.Buffer) *cli.App {
+	app := &cli.App{
+		Name:                 "my-tool",
+		Usage:                "Does things",
+		EnableBashCompletion: true,
+		Writer:               out,
+		Flags:                []cli.Flag{&cli.BoolFlag{Name: "verbose", Usage: "Print more"}},
+		Commands:             []*cli.Command{{Name: "build", Usage: "Build something"}},
+	}
+	app.Commands = append(app.Commands, Commands()...)
+	return app
+}
+
+func TestCompletion(t *testing.T) {
+	app := testApp(nil)
+	for _, shell := range Shells {
+		script, err := Completion(app, shell)
+		if err != nil {
+			t.Fatal(shell, err)
+		}
+		if strings.Contains(script, "my-tool") == false {
+			t.Error(shell, "completion does not mention the program:", script)
+		}
+		if shell != "fish" && (strings.Contains(script, "PROG") || strings.Contains(script, "_my-tool_")) {
+			t.Error(shell, "completion has not been adapted to the program:", script)
+		}
+	}
+	if _, err := Completion(app, "tcsh"); err == nil {
+		t.Error("Expected an error for an unsupported shell")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	var out bytes.Buffer
+	err := testApp(&out).Run([]string{"my-tool", "man"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{".TH my\\-tool 1", "build", "verbose", "completion"} {
+		if strings.Contains(out.String(), want) == false {
+			t.Error("Man page does not contain", want)
+		}
+	}
+
+	out.Reset()
+	err = testApp(&out).Run([]string{"my-tool", "completion", "fish"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "complete -c my-tool") == false {
+		t.Error("Unexpected fish completion:", out.String())
+	}
+
+	if testApp(&out).Run([]string{"my-tool", "completion"}) == nil {
+		t.Error("Expected an error without a shell")
+	}
+}
@@ -0,0 +1,246 @@
+// Package delta makes binary deltas between two versions of a file, e.g., of an AppImage,
+// so that mirrors and users who have the old version only need to download what changed.
+// Like rsync and zsync, blocks of the old file are found in the new one at any offset using
+// a rolling checksum, so that content that merely moved, as happens in squashfs images
+// when a file before it changes, is not sent again. The delta is compressed with zstd
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic number at the beginning of deltas, followed by the compressed header and instructions
+const magic = "AIDELTA1"
+
+// Size of the blocks of the old file that are looked for in the new one. Matches are extended beyond them
+const blockSize = 1024
+
+// Instructions for rebuilding the new file
+const (
+	opCopy = 'C' // Copy a range of the old file: offset and length as uvarints
+	opData = 'D' // Insert the following bytes: length as uvarint, then the bytes
+	opEnd  = 'E'
+)
+
+// How much memory is reserved for the new file up front, since its size in the delta cannot be trusted yet
+const maxPreallocation = 64 << 20
+
+// Stats tell how much of the new file a delta takes from the old one and how much it contains
+type Stats struct {
+	Copied  int64
+	Literal int64
+}
+
+// Create writes the delta that turns old into new to w
+func Create(old []byte, new []byte, w io.Writer) (Stats, error) {
+	var stats Stats
+	_, err := w.Write([]byte(magic))
+	if err != nil {
+		return stats, err
+	}
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return stats, err
+	}
+	out := &writer{w: bufio.NewWriter(zw)}
+	out.header(old, new)
+
+	index := make(map[uint32]int)
+	for offset := 0; offset+blockSize <= len(old); offset += blockSize {
+		checksum := rollingChecksum(old[offset : offset+blockSize])
+		if _, ok := index[checksum.sum()]; ok == false {
+			index[checksum.sum()] = offset
+		}
+	}
+
+	literalStart := 0
+	var checksum rolling
+	computed := false
+	for position := 0; position+blockSize <= len(new); {
+		if computed == false {
+			checksum = rollingChecksum(new[position : position+blockSize])
+			computed = true
+		}
+		if offset, ok := index[checksum.sum()]; ok && bytes.Equal(old[offset:offset+blockSize], new[position:position+blockSize]) {
+			// Extend the match backwards into the pending literal data and forwards beyond the block
+			start, oldStart := position, offset
+			for start > literalStart && oldStart > 0 && new[start-1] == old[oldStart-1] {
+				start--
+				oldStart--
+			}
+			end, oldEnd := position+blockSize, offset+blockSize
+			for end < len(new) && oldEnd < len(old) && new[end] == old[oldEnd] {
+				end++
+				oldEnd++
+			}
+			stats.Literal += out.data(new[literalStart:start])
+			stats.Copied += out.copy(oldStart, end-start)
+			position, literalStart = end, end
+			computed = false
+			continue
+		}
+		if position+blockSize < len(new) {
+			checksum.roll(new[position], new[position+blockSize])
+		}
+		position++
+	}
+	stats.Literal += out.data(new[literalStart:])
+	out.op(opEnd)
+	if out.err != nil {
+		return stats, out.err
+	}
+	err = out.w.Flush()
+	if err != nil {
+		return stats, err
+	}
+	return stats, zw.Close()
+}
+
+// Apply returns the new file that the delta read from r makes from old.
+// Returns an error if old is not the file the delta was made from, or if the result is not the new file
+func Apply(old []byte, r io.Reader) ([]byte, error) {
+	prefix := make([]byte, len(magic))
+	_, err := io.ReadFull(r, prefix)
+	if err != nil || string(prefix) != magic {
+		return nil, errors.New("not a delta")
+	}
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	in := bufio.NewReader(zr)
+
+	var oldSize, newSize uint64
+	var oldDigest, newDigest [sha256.Size]byte
+	for _, field := range []interface{}{&oldSize, &oldDigest, &newSize, &newDigest} {
+		err = binary.Read(in, binary.LittleEndian, field)
+		if err != nil {
+			return nil, errors.New("truncated delta")
+		}
+	}
+	if uint64(len(old)) != oldSize || sha256.Sum256(old) != oldDigest {
+		return nil, errors.New("the delta was made from a different file")
+	}
+	if newSize > uint64(^uint(0)>>1) {
+		return nil, errors.New("the new file is too large")
+	}
+
+	var new bytes.Buffer
+	if newSize < maxPreallocation {
+		new.Grow(int(newSize))
+	} else {
+		new.Grow(maxPreallocation)
+	}
+	for {
+		op, err := in.ReadByte()
+		if err != nil {
+			return nil, errors.New("truncated delta")
+		}
+		switch op {
+		case opCopy:
+			offset, err1 := binary.ReadUvarint(in)
+			length, err2 := binary.ReadUvarint(in)
+			if err1 != nil || err2 != nil || length > uint64(len(old)) || offset > uint64(len(old))-length ||
+				length > newSize-uint64(new.Len()) {
+				return nil, errors.New("invalid copy instruction in delta")
+			}
+			new.Write(old[offset : offset+length])
+		case opData:
+			length, err := binary.ReadUvarint(in)
+			if err != nil || length > newSize-uint64(new.Len()) {
+				return nil, errors.New("invalid data instruction in delta")
+			}
+			// Copied rather than read into a buffer of the given length, so that a truncated delta cannot make it allocate much
+			_, err = io.CopyN(&new, in, int64(length))
+			if err != nil {
+				return nil, errors.New("truncated delta")
+			}
+		case opEnd:
+			if uint64(new.Len()) != newSize || sha256.Sum256(new.Bytes()) != newDigest {
+				return nil, errors.New("the result of applying the delta is corrupt")
+			}
+			return new.Bytes(), nil
+		default:
+			return nil, errors.New("invalid instruction in delta")
+		}
+	}
+}
+
+// writer writes instructions, remembering the first error
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *writer) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *writer) op(op byte, values ...int) {
+	buf := []byte{op}
+	var varint [binary.MaxVarintLen64]byte
+	for _, value := range values {
+		n := binary.PutUvarint(varint[:], uint64(value))
+		buf = append(buf, varint[:n]...)
+	}
+	w.write(buf)
+}
+
+func (w *writer) header(old []byte, new []byte) {
+	var buf bytes.Buffer
+	oldDigest, newDigest := sha256.Sum256(old), sha256.Sum256(new)
+	binary.Write(&buf, binary.LittleEndian, uint64(len(old)))
+	buf.Write(oldDigest[:])
+	binary.Write(&buf, binary.LittleEndian, uint64(len(new)))
+	buf.Write(newDigest[:])
+	w.write(buf.Bytes())
+}
+
+func (w *writer) copy(offset int, length int) int64 {
+	w.op(opCopy, offset, length)
+	return int64(length)
+}
+
+func (w *writer) data(data []byte) int64 {
+	if len(data) > 0 {
+		w.op(opData, len(data))
+		w.write(data)
+	}
+	return int64(len(data))
+}
+
+// rolling is the rolling checksum of rsync and zsync, which can be moved by one byte cheaply
+type rolling struct {
+	a, b uint16
+}
+
+func rollingChecksum(block []byte) rolling {
+	var r rolling
+	l := uint16(len(block))
+	for _, v := range block {
+		r.a += uint16(v)
+		r.b += l * uint16(v)
+		l--
+	}
+	return r
+}
+
+// roll moves the checksum one byte further, from a block starting with out to one ending with in
+func (r *rolling) roll(out byte, in byte) {
+	r.a = r.a - uint16(out) + uint16(in)
+	r.b = r.b - uint16(blockSize)*uint16(out) + r.a
+}
+
+func (r rolling) sum() uint32 {
+	return uint32(r.b)<<16 | uint32(r.a)
+}
@@ -0,0 +1,88 @@
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCreateAndApply(t *testing.T) {
+	// The new version has a changed region that shifts everything after it, and has grown
+	old := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(old)
+	new := append([]byte(nil), old[:100000]...)
+	new = append(new, []byte("something that has changed")...)
+	new = append(new, old[100500:]...)
+	new = append(new, []byte("appended")...)
+
+	var buf bytes.Buffer
+	stats, err := Create(old, new, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Copied+stats.Literal != int64(len(new)) || stats.Literal > 100 {
+		t.Error("Unexpected stats:", stats)
+	}
+	if buf.Len() > 1000 {
+		t.Error("Delta is too large:", buf.Len())
+	}
+	result, err := Apply(old, bytes.NewReader(buf.Bytes()))
+	if err != nil || bytes.Equal(result, new) == false {
+		t.Fatal("Applying the delta did not give the new file:", err)
+	}
+
+	// A delta only applies to the file it was made from
+	if _, err := Apply(new, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Delta applied to the wrong file")
+	}
+}
+
+// craftDelta returns a delta for old that claims to make a file of newSize bytes with the given instructions
+func craftDelta(t *testing.T, old []byte, newSize uint64, instructions []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDigest := sha256.Sum256(old)
+	binary.Write(zw, binary.LittleEndian, uint64(len(old)))
+	zw.Write(oldDigest[:])
+	binary.Write(zw, binary.LittleEndian, newSize)
+	zw.Write(make([]byte, sha256.Size))
+	zw.Write(instructions)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestApplyInvalidDelta(t *testing.T) {
+	old := []byte("0123456789")
+	uvarints := func(op byte, values ...uint64) []byte {
+		b := []byte{op}
+		var varint [binary.MaxVarintLen64]byte
+		for _, value := range values {
+			b = append(b, varint[:binary.PutUvarint(varint[:], value)]...)
+		}
+		return b
+	}
+	for _, tc := range []struct {
+		description  string
+		newSize      uint64
+		instructions []byte
+	}{
+		{"a huge new file", 1 << 63, uvarints(opEnd)},
+		{"a copy whose end overflows", 100, uvarints(opCopy, 1<<64-1, 2)},
+		{"a copy beyond the old file", 100, uvarints(opCopy, 5, 6)},
+		{"a copy beyond the new file", 5, uvarints(opCopy, 0, 6)},
+		{"data beyond the new file", 1 << 40, uvarints(opData, 1<<64-1)},
+		{"truncated data", 1 << 40, uvarints(opData, 1<<39)},
+	} {
+		if _, err := Apply(old, bytes.NewReader(craftDelta(t, old, tc.newSize, tc.instructions))); err == nil {
+			t.Error("Applied a delta with", tc.description)
+		}
+	}
+}
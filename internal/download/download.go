@@ -0,0 +1,191 @@
+// Package download downloads files into a content-addressed cache, so that files that are
+// needed again, such as the ingredients of repeated builds, are not downloaded again.
+// Files can be pinned to their SHA-256 digest, and interrupted downloads are resumed
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Cache is a directory with downloaded files, stored under sha256/ by their digest
+type Cache struct {
+	Dir    string
+	Client *http.Client
+}
+
+// urlEntry is what the cache remembers about a URL, stored under urls/ by the digest of the URL
+type urlEntry struct {
+	SHA256       string `json:"sha256"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// DefaultDir returns the directory of the cache in the user's cache directory
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "appimagetool", "downloads"), nil
+}
+
+// NewCache returns the cache in dir that downloads through the HTTP proxy at proxy, e.g., http://proxy:3128,
+// or, if proxy is empty, through the one given in $HTTPS_PROXY, $HTTP_PROXY and $NO_PROXY
+func NewCache(dir string, proxy string) (*Cache, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, errors.New("invalid proxy " + proxy + ", expected a URL such as http://proxy:3128")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &Cache{Dir: dir, Client: &http.Client{Transport: transport}}, nil
+}
+
+// Fetch returns the path of the file at rawURL in the cache, downloading it if needed.
+// If digest, the hex-encoded SHA-256 digest of the file, is given, a file with that digest is used without
+// accessing the network, and a download with another digest is an error. Otherwise, a file downloaded from
+// rawURL before is used if the server says that it has not been modified since.
+// The file must not be modified, since other downloads with the same digest share it
+func (c *Cache) Fetch(rawURL string, digest string) (string, error) {
+	digest = strings.ToLower(digest)
+	if digest != "" && exists(c.contentPath(digest)) {
+		return c.contentPath(digest), nil
+	}
+	for _, dir := range []string{"sha256", "urls", "partial"} {
+		if err := os.MkdirAll(filepath.Join(c.Dir, dir), 0755); err != nil {
+			return "", err
+		}
+	}
+	key := sha256.Sum256([]byte(rawURL))
+	entryPath := filepath.Join(c.Dir, "urls", hex.EncodeToString(key[:])+".json")
+	partPath := filepath.Join(c.Dir, "partial", hex.EncodeToString(key[:]))
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	var cached urlEntry
+	if data, err := ioutil.ReadFile(entryPath); err == nil && json.Unmarshal(data, &cached) == nil &&
+		digest == "" && exists(c.contentPath(cached.SHA256)) {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	// Resume an interrupted download if the file on the server is still the same
+	var resumeFrom int64
+	validator, _ := ioutil.ReadFile(partPath + ".validator")
+	if info, err := os.Stat(partPath); err == nil && info.Size() > 0 && len(validator) > 0 {
+		resumeFrom = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+		req.Header.Set("If-Range", string(validator))
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	// The partial file cannot be resumed if it is complete already, e.g., if the process died after
+	// downloading all of it, or if the server sends another range. Start over rather than failing each time
+	if resumeFrom > 0 && (resp.StatusCode == http.StatusRequestedRangeNotSatisfiable ||
+		resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp.Header.Get("Content-Range")) != resumeFrom) {
+		resp.Body.Close()
+		os.Remove(partPath)
+		os.Remove(partPath + ".validator")
+		req.Header.Del("Range")
+		req.Header.Del("If-Range")
+		resp, err = c.Client.Do(req)
+		if err != nil {
+			return "", err
+		}
+	}
+	defer resp.Body.Close()
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return c.contentPath(cached.SHA256), nil
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+		validator := resp.Header.Get("ETag")
+		if validator == "" || strings.HasPrefix(validator, "W/") {
+			validator = resp.Header.Get("Last-Modified")
+		}
+		os.Remove(partPath + ".validator")
+		if validator != "" {
+			ioutil.WriteFile(partPath+".validator", []byte(validator), 0644)
+		}
+	default:
+		return "", errors.New(rawURL + ": " + resp.Status)
+	}
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	f.Close()
+	if err != nil {
+		// Keep what was downloaded so far to resume the next time
+		return "", errors.New(rawURL + ": " + err.Error())
+	}
+
+	actual, err := fsys.SHA256(fsys.OS, partPath)
+	if err != nil {
+		return "", err
+	}
+	if digest != "" && actual != digest {
+		os.Remove(partPath)
+		os.Remove(partPath + ".validator")
+		return "", errors.New(rawURL + " has the SHA-256 digest " + actual + " instead of " + digest)
+	}
+	err = os.Rename(partPath, c.contentPath(actual))
+	if err != nil {
+		return "", err
+	}
+	os.Remove(partPath + ".validator")
+	data, _ := json.Marshal(urlEntry{SHA256: actual, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")})
+	ioutil.WriteFile(entryPath, data, 0644)
+	return c.contentPath(actual), nil
+}
+
+// contentRangeStart returns the first byte of the range in the Content-Range header value,
+// e.g., 100 for "bytes 100-199/200", or -1 if there is none
+func contentRangeStart(contentRange string) int64 {
+	if strings.HasPrefix(contentRange, "bytes ") == false {
+		return -1
+	}
+	start, err := strconv.ParseInt(strings.SplitN(contentRange[len("bytes "):], "-", 2)[0], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// contentPath returns where the file with the given digest is stored
+func (c *Cache) contentPath(digest string) string {
+	return filepath.Join(c.Dir, "sha256", digest)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,138 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	content := bytes.Repeat([]byte("ingredient"), 10000)
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "ingredient", time.Unix(0, 0), bytes.NewReader(content))
+	}))
+	defer server.Close()
+	cache, err := NewCache(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := cache.Fetch(server.URL+"/ingredient", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); bytes.Equal(data, content) == false || filepath.Base(path) != digest {
+		t.Error("Unexpected download", path)
+	}
+	// Revalidated without downloading it again
+	if again, err := cache.Fetch(server.URL+"/ingredient", ""); err != nil || again != path || requests != 2 {
+		t.Error("Not revalidated:", again, err, requests)
+	}
+	// Pinned files are used without accessing the network
+	if pinned, err := cache.Fetch(server.URL+"/elsewhere", digest); err != nil || pinned != path || requests != 2 {
+		t.Error("Pinned file was not used from the cache:", pinned, err, requests)
+	}
+	if _, err := cache.Fetch(server.URL+"/other", "0123"); err == nil {
+		t.Error("Accepted a download with another digest")
+	}
+}
+
+func TestFetchResumes(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "big", time.Unix(0, 0), bytes.NewReader(content))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	cache, _ := NewCache(dir, "")
+
+	// An interrupted download of the first half
+	url := server.URL + "/big"
+	key := sha256.Sum256([]byte(url))
+	partPath := filepath.Join(dir, "partial", hex.EncodeToString(key[:]))
+	os.MkdirAll(filepath.Dir(partPath), 0755)
+	ioutil.WriteFile(partPath, content[:5000], 0644)
+	ioutil.WriteFile(partPath+".validator", []byte(`"v1"`), 0644)
+
+	path, err := cache.Fetch(url, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); bytes.Equal(data, content) == false {
+		t.Error("Resumed download differs")
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=5000-" {
+		t.Error("Not resumed, requested ranges", ranges)
+	}
+}
+
+func TestFetchRestartsUnresumableDownloads(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	ignoreRangeStart := false
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if ignoreRangeStart && r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", "bytes 0-9999/10000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content)
+			return
+		}
+		http.ServeContent(w, r, "big", time.Unix(0, 0), bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		description      string
+		partial          []byte
+		ignoreRangeStart bool
+	}{
+		{"a complete partial file, to which the server replies 416", content, false},
+		{"a server that sends another range", content[:5000], true},
+	} {
+		dir := t.TempDir()
+		cache, _ := NewCache(dir, "")
+		url := server.URL + "/big"
+		key := sha256.Sum256([]byte(url))
+		partPath := filepath.Join(dir, "partial", hex.EncodeToString(key[:]))
+		os.MkdirAll(filepath.Dir(partPath), 0755)
+		ioutil.WriteFile(partPath, tc.partial, 0644)
+		ioutil.WriteFile(partPath+".validator", []byte(`"v1"`), 0644)
+		ignoreRangeStart = tc.ignoreRangeStart
+		ranges = nil
+
+		path, err := cache.Fetch(url, "")
+		if err != nil {
+			t.Error("Failed with", tc.description+":", err)
+			continue
+		}
+		if data, _ := ioutil.ReadFile(path); bytes.Equal(data, content) == false {
+			t.Error("Unexpected download with", tc.description)
+		}
+		if len(ranges) != 2 || ranges[1] != "" {
+			t.Error("Not started over with", tc.description+", requested ranges", ranges)
+		}
+	}
+}
+
+func TestNewCacheRejectsInvalidProxy(t *testing.T) {
+	if _, err := NewCache(t.TempDir(), "proxy"); err == nil {
+		t.Error("Accepted an invalid proxy")
+	}
+}
@@ -0,0 +1,103 @@
+// Package elfsection reads and writes the contents of named sections of an existing ELF in place,
+// without relinking it. This is how the update information (.upd_info), the signature (.sha256_sig),
+// the signing key (.sig_key) and the digest (.digest_md5) get into the runtime of an AppImage,
+// which reserves space for them. Sections cannot grow, hence the data must fit into them
+package elfsection
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Section is where the contents of a section are in the file
+type Section struct {
+	Name   string
+	Offset int64
+	Size   int64
+}
+
+// List returns the sections of the ELF at path that have contents in the file
+func List(path string) ([]Section, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sections []Section
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_NOBITS || s.Type == elf.SHT_NULL {
+			continue
+		}
+		sections = append(sections, Section{Name: s.Name, Offset: int64(s.Offset), Size: int64(s.Size)})
+	}
+	return sections, nil
+}
+
+// Find returns the section called name of the ELF at path
+func Find(path string, name string) (Section, error) {
+	sections, err := List(path)
+	if err != nil {
+		return Section{}, err
+	}
+	for _, s := range sections {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Section{}, errors.New("no section " + name + " in " + path)
+}
+
+// Read returns the contents of the section called name of the ELF at path
+func Read(path string, name string) ([]byte, error) {
+	s, err := Find(path, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, s.Size)
+	_, err = f.ReadAt(data, s.Offset)
+	return data, err
+}
+
+// ReadString returns the contents of the section called name of the ELF at path
+// as a string, without the zeros it is padded with and surrounding whitespace
+func ReadString(path string, name string) (string, error) {
+	data, err := Read(path, name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bytes.Trim(data, "\x00"))), nil
+}
+
+// Write replaces the contents of the section called name of the ELF at path by data,
+// padded with zeros to the size of the section, so that nothing of what was there before remains
+func Write(path string, name string, data []byte) error {
+	s, err := Find(path, name)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > s.Size {
+		return errors.New(strconv.Itoa(len(data)) + " bytes do not fit into section " + name +
+			" of " + path + ", which has " + strconv.FormatInt(s.Size, 10) + " bytes")
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	padded := make([]byte, s.Size)
+	copy(padded, data)
+	_, err = f.WriteAt(padded, s.Offset)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,44 @@
+package elfsection
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy is needed to add the section")
+	}
+	dir, err := ioutil.TempDir("", "elfsection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	self, _ := os.Executable()
+	ioutil.WriteFile(dir+"/zeros", make([]byte, 32), 0644)
+	path := dir + "/Some.AppImage"
+	err = exec.Command("objcopy", "--add-section", ".upd_info="+dir+"/zeros", self, path).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Write(path, ".upd_info", []byte("zsync|https://example.org/Some-x86_64.AppImage.zsync"))
+	if err == nil {
+		t.Error("Data that does not fit was written")
+	}
+	for _, s := range []string{"gh-releases-zsync", "zsync|x"} {
+		err = Write(path, ".upd_info", []byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadString(path, ".upd_info")
+		if err != nil || read != s {
+			t.Errorf("Expected %q, got %q, %v", s, read, err)
+		}
+	}
+	if _, err := Find(path, ".sig_key"); err == nil {
+		t.Error("Found a section that does not exist")
+	}
+}
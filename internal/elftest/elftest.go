@@ -0,0 +1,227 @@
+// Package elftest builds synthetic mini-ELFs for tests. They have nothing but the dynamic
+// section with the entries that dependency resolution and rpath rewriting look at, and
+// the interpreter of executables,
+// so that these can be tested against in-memory fixtures without a compiler, e.g.,
+//
+//	fs.WriteFile("/app/usr/bin/foo", elftest.Build(elftest.Spec{Needed: []string{"libbar.so.1"}, Runpath: "$ORIGIN/../lib"}), 0755)
+package elftest
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"sort"
+)
+
+// Spec describes the dynamic section of a mini-ELF
+type Spec struct {
+	Needed  []string // DT_NEEDED
+	Soname  string   // DT_SONAME, if not empty
+	Rpath   string   // DT_RPATH, if not empty
+	Runpath string   // DT_RUNPATH, if not empty
+	Flags1  uint64   // DT_FLAGS_1, if not zero, e.g., DF_1_NODEFLIB
+	// Symbol versions needed from each library, by its name (.gnu.version_r), e.g., {"libc.so.6": {"GLIBC_2.34"}}
+	VersionNeeds map[string][]string
+	// Versions in VersionNeeds that are marked with VER_FLG_WEAK
+	WeakVersions []string
+	// Symbol versions defined by the ELF (.gnu.version_d), e.g., {"GLIBC_2.2.5", "GLIBC_2.34"}
+	VersionDefinitions []string
+	Machine            elf.Machine // EM_X86_64 if zero; the ELF is 64-bit little-endian in any case
+	Type               elf.Type    // ET_DYN if zero, e.g., ET_REL for an object file
+	Interpreter        string      // PT_INTERP, if not empty, which makes an ET_DYN a PIE rather than a shared library
+}
+
+// Sizes of the ELF64 structures
+const (
+	headerSize        = 64
+	progHeaderSize    = 56
+	sectionHeaderSize = 64
+	dynSize           = 16
+)
+
+// Build returns a 64-bit little-endian shared object with the dynamic section described by spec
+func Build(spec Spec) []byte {
+	if spec.Machine == 0 {
+		spec.Machine = elf.EM_X86_64
+	}
+	if spec.Type == 0 {
+		spec.Type = elf.ET_DYN
+	}
+	// String table of the dynamic section
+	dynstr := []byte{0}
+	addString := func(s string) uint64 {
+		offset := uint64(len(dynstr))
+		dynstr = append(append(dynstr, s...), 0)
+		return offset
+	}
+	var dynamic []elf.Dyn64
+	for _, name := range spec.Needed {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_NEEDED), Val: addString(name)})
+	}
+	if spec.Soname != "" {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_SONAME), Val: addString(spec.Soname)})
+	}
+	if spec.Rpath != "" {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_RPATH), Val: addString(spec.Rpath)})
+	}
+	if spec.Runpath != "" {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_RUNPATH), Val: addString(spec.Runpath)})
+	}
+	if spec.Flags1 != 0 {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_FLAGS_1), Val: spec.Flags1})
+	}
+	dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_NULL)})
+
+	// Sections after .dynstr (index 1), in this order
+	type section struct {
+		name    string
+		typ     elf.SectionType
+		data    []byte
+		link    uint32
+		info    uint32
+		entsize uint64
+	}
+	var dynamicData bytes.Buffer
+	binary.Write(&dynamicData, binary.LittleEndian, dynamic)
+	sections := []section{{name: ".dynamic", typ: elf.SHT_DYNAMIC, data: dynamicData.Bytes(), link: 1, entsize: dynSize}}
+	if len(spec.VersionNeeds) > 0 {
+		data, count := versionNeeds(spec.VersionNeeds, spec.WeakVersions, addString)
+		sections = append(sections, section{name: ".gnu.version_r", typ: elf.SHT_GNU_VERNEED, data: data, link: 1, info: count})
+	}
+	if len(spec.VersionDefinitions) > 0 {
+		data, count := versionDefinitions(spec.VersionDefinitions, addString)
+		sections = append(sections, section{name: ".gnu.version_d", typ: elf.SHT_GNU_VERDEF, data: data, link: 1, info: count})
+	}
+
+	shstrtab := []byte("\x00.dynstr\x00")
+	names := make([]uint32, len(sections))
+	for i, sec := range sections {
+		names[i] = uint32(len(shstrtab))
+		shstrtab = append(append(shstrtab, sec.name...), 0)
+	}
+	shstrtabName := uint32(len(shstrtab))
+	shstrtab = append(shstrtab, ".shstrtab\x00"...)
+
+	// Layout: header, PT_INTERP program header and the interpreter (if any), .dynstr,
+	// the sections (8-byte aligned), .shstrtab, section headers (8-byte aligned)
+	var progs []elf.Prog64
+	interp := []byte{}
+	if spec.Interpreter != "" {
+		interp = append([]byte(spec.Interpreter), 0)
+		progs = append(progs, elf.Prog64{Type: uint32(elf.PT_INTERP), Flags: uint32(elf.PF_R), Off: headerSize + progHeaderSize,
+			Filesz: uint64(len(interp)), Memsz: uint64(len(interp)), Align: 1})
+	}
+	dynstrOffset := uint64(headerSize+progHeaderSize*len(progs)) + uint64(len(interp))
+	offset := dynstrOffset + uint64(len(dynstr))
+	offsets := make([]uint64, len(sections))
+	for i, sec := range sections {
+		offsets[i] = align(offset, 8)
+		offset = offsets[i] + uint64(len(sec.data))
+	}
+	shstrtabOffset := offset
+	sectionHeadersOffset := align(shstrtabOffset+uint64(len(shstrtab)), 8)
+
+	var buf bytes.Buffer
+	header := elf.Header64{
+		Type:      uint16(spec.Type),
+		Machine:   uint16(spec.Machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     sectionHeadersOffset,
+		Ehsize:    headerSize,
+		Phentsize: progHeaderSize,
+		Shentsize: sectionHeaderSize,
+		Shnum:     uint16(len(sections) + 3),
+		Shstrndx:  uint16(len(sections) + 2),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	if len(progs) > 0 {
+		header.Phoff = headerSize
+		header.Phnum = uint16(len(progs))
+	}
+	binary.Write(&buf, binary.LittleEndian, header)
+	binary.Write(&buf, binary.LittleEndian, progs)
+	buf.Write(interp)
+
+	buf.Write(dynstr)
+	for i, sec := range sections {
+		pad(&buf, offsets[i])
+		buf.Write(sec.data)
+	}
+	buf.Write(shstrtab)
+	pad(&buf, sectionHeadersOffset)
+
+	headers := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_STRTAB), Flags: uint64(elf.SHF_ALLOC), Off: dynstrOffset, Size: uint64(len(dynstr)), Addralign: 1},
+	}
+	for i, sec := range sections {
+		headers = append(headers, elf.Section64{Name: names[i], Type: uint32(sec.typ), Flags: uint64(elf.SHF_ALLOC),
+			Off: offsets[i], Size: uint64(len(sec.data)), Link: sec.link, Info: sec.info, Addralign: 8, Entsize: sec.entsize})
+	}
+	headers = append(headers, elf.Section64{Name: shstrtabName, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOffset, Size: uint64(len(shstrtab)), Addralign: 1})
+	binary.Write(&buf, binary.LittleEndian, headers)
+	return buf.Bytes()
+}
+
+// versionNeeds returns the contents of .gnu.version_r with the given needs, and the number of Elf64_Verneed entries in it
+func versionNeeds(needs map[string][]string, weak []string, addString func(string) uint64) ([]byte, uint32) {
+	var files []string
+	for file := range needs {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	var buf bytes.Buffer
+	for i, file := range files {
+		next := uint32(16 + 16*len(needs[file]))
+		if i == len(files)-1 {
+			next = 0
+		}
+		binary.Write(&buf, binary.LittleEndian, []uint16{1, uint16(len(needs[file]))})
+		binary.Write(&buf, binary.LittleEndian, []uint32{uint32(addString(file)), 16, next})
+		for j, version := range needs[file] {
+			auxNext := uint32(16)
+			if j == len(needs[file])-1 {
+				auxNext = 0
+			}
+			flags := uint16(0)
+			for _, w := range weak {
+				if w == version {
+					flags = 2 // VER_FLG_WEAK
+				}
+			}
+			binary.Write(&buf, binary.LittleEndian, []uint32{0})
+			binary.Write(&buf, binary.LittleEndian, []uint16{flags, uint16(j + 2)})
+			binary.Write(&buf, binary.LittleEndian, []uint32{uint32(addString(version)), auxNext})
+		}
+	}
+	return buf.Bytes(), uint32(len(files))
+}
+
+// versionDefinitions returns the contents of .gnu.version_d with the given definitions, and the number of Elf64_Verdef entries in it
+func versionDefinitions(definitions []string, addString func(string) uint64) ([]byte, uint32) {
+	var buf bytes.Buffer
+	for i, version := range definitions {
+		next := uint32(28)
+		if i == len(definitions)-1 {
+			next = 0
+		}
+		binary.Write(&buf, binary.LittleEndian, []uint16{1, 0, uint16(i + 1), 1})
+		binary.Write(&buf, binary.LittleEndian, []uint32{0, 20, next})
+		binary.Write(&buf, binary.LittleEndian, []uint32{uint32(addString(version)), 0})
+	}
+	return buf.Bytes(), uint32(len(definitions))
+}
+
+func align(offset uint64, alignment uint64) uint64 {
+	return (offset + alignment - 1) / alignment * alignment
+}
+
+// pad writes zeros to buf until it has the given length
+func pad(buf *bytes.Buffer, length uint64) {
+	for uint64(buf.Len()) < length {
+		buf.WriteByte(0)
+	}
+}
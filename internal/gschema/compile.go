@@ -0,0 +1,443 @@
+// Package gschema compiles GSettings schemas into gschemas.compiled
+// for build machines on which glib-compile-schemas is not available
+package gschema
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+type xmlSchemaList struct {
+	GettextDomain string      `xml:"gettext-domain,attr"`
+	Schemas       []xmlSchema `xml:"schema"`
+	Enums         []xmlEnum   `xml:"enum"`
+	Flags         []xmlEnum   `xml:"flags"`
+}
+
+type xmlEnum struct {
+	ID     string `xml:"id,attr"`
+	Values []struct {
+		Nick  string `xml:"nick,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"value"`
+}
+
+type xmlSchema struct {
+	ID            string `xml:"id,attr"`
+	Path          string `xml:"path,attr"`
+	GettextDomain string `xml:"gettext-domain,attr"`
+	Extends       string `xml:"extends,attr"`
+	ListOf        string `xml:"list-of,attr"`
+	Keys          []struct {
+		Name    string `xml:"name,attr"`
+		Type    string `xml:"type,attr"`
+		Enum    string `xml:"enum,attr"`
+		Flags   string `xml:"flags,attr"`
+		Default struct {
+			Text    string `xml:",chardata"`
+			L10n    string `xml:"l10n,attr"`
+			Context string `xml:"context,attr"`
+		} `xml:"default"`
+		Range *struct {
+			Min string `xml:"min,attr"`
+			Max string `xml:"max,attr"`
+		} `xml:"range"`
+		Choices []struct {
+			Value string `xml:"value,attr"`
+		} `xml:"choices>choice"`
+		Aliases []struct {
+			Value  string `xml:"value,attr"`
+			Target string `xml:"target,attr"`
+		} `xml:"aliases>alias"`
+	} `xml:"key"`
+	Children []struct {
+		Name   string `xml:"name,attr"`
+		Schema string `xml:"schema,attr"`
+	} `xml:"child"`
+	Overrides []struct {
+		Name string `xml:"name,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"override"`
+}
+
+// key is a compiled key of a schema
+type key struct {
+	typ          *gvType
+	defaultValue gvValue
+	extras       []gvValue // Localization, choices, enum or flags information and range
+}
+
+// value returns the (default, extras...) tuple stored in gschemas.compiled
+func (k *key) value() gvValue {
+	return newTuple(append([]gvValue{k.defaultValue}, k.extras...), false)
+}
+
+// schema is a compiled schema
+type schema struct {
+	file    string
+	xml     xmlSchema
+	domain  string
+	keys    map[string]*key
+	names   []string
+	done    bool
+	working bool
+}
+
+// isLocalized returns true if the default value of any key is translated
+func (s *schema) isLocalized() bool {
+	for _, k := range s.keys {
+		for _, extra := range k.extras {
+			if extra.data[0] == 'l' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// strinfo builds the table that maps strings to integers for
+// choices, aliases, enums and flags
+type strinfo struct {
+	data []byte
+}
+
+func (s *strinfo) append(str string, value uint32, marker byte) {
+	s.data = append(s.data, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+	start := len(s.data)
+	s.data = append(s.data, marker)
+	s.data = append(s.data, str...)
+	s.data = append(s.data, 0)
+	for (len(s.data)-start)%4 != 3 || len(s.data)-start < 7 {
+		s.data = append(s.data, 0)
+	}
+	s.data = append(s.data, 0xff)
+}
+
+// appendAlias adds alias for target, which must have been appended before
+func (s *strinfo) appendAlias(alias string, target string) error {
+	needle := append(append([]byte{0xff}, target...), 0)
+	for word := 0; 4*(word+1)+len(needle) <= len(s.data); word++ {
+		if string(s.data[4*(word+1):4*(word+1)+len(needle)]) == string(needle) {
+			s.append(alias, uint32(word), 0xfe)
+			return nil
+		}
+	}
+	return errors.New("alias target '" + target + "' is not a valid choice")
+}
+
+func (s *strinfo) value() gvValue {
+	var words []gvValue
+	for i := 0; i+4 <= len(s.data); i = i + 4 {
+		words = append(words, gvValue{&gvType{str: "u"}, s.data[i : i+4]})
+	}
+	return newArray(&gvType{str: "u"}, words)
+}
+
+// readSchemaLists reads all *.gschema.xml files in dir, sorted by name
+func readSchemaLists(dir string) (map[string]xmlSchemaList, []string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.gschema.xml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(files)
+	lists := make(map[string]xmlSchemaList)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		var list xmlSchemaList
+		if err = xml.Unmarshal(data, &list); err != nil {
+			return nil, nil, errors.New(filepath.Base(file) + ": " + err.Error())
+		}
+		lists[file] = list
+	}
+	return lists, files, nil
+}
+
+// SchemaIDs returns the files in dir that define each schema ID,
+// so that callers can detect schemas that are defined more than once
+func SchemaIDs(dir string) (map[string][]string, error) {
+	lists, files, err := readSchemaLists(dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string][]string)
+	for _, file := range files {
+		for _, s := range lists[file].Schemas {
+			ids[s.ID] = append(ids[s.ID], file)
+		}
+	}
+	return ids, nil
+}
+
+// Compile compiles the *.gschema.xml and *.gschema.override files in dir
+// into dir/gschemas.compiled, like glib-compile-schemas does
+func Compile(dir string) error {
+	lists, files, err := readSchemaLists(dir)
+	if err != nil {
+		return err
+	}
+
+	enums := make(map[string]*strinfo)
+	flags := make(map[string]*strinfo)
+	schemas := make(map[string]*schema)
+	var ids []string
+	for _, file := range files {
+		list := lists[file]
+		for _, e := range list.Enums {
+			info, err := enumStrinfo(e, false)
+			if err != nil {
+				return errors.New(filepath.Base(file) + ": " + err.Error())
+			}
+			enums[e.ID] = info
+		}
+		for _, f := range list.Flags {
+			info, err := enumStrinfo(f, true)
+			if err != nil {
+				return errors.New(filepath.Base(file) + ": " + err.Error())
+			}
+			flags[f.ID] = info
+		}
+		for _, s := range list.Schemas {
+			if _, ok := schemas[s.ID]; ok {
+				return errors.New(filepath.Base(file) + ": schema '" + s.ID + "' is already defined")
+			}
+			domain := s.GettextDomain
+			if domain == "" {
+				domain = list.GettextDomain
+			}
+			schemas[s.ID] = &schema{file: file, xml: s, domain: domain, keys: make(map[string]*key)}
+			ids = append(ids, s.ID)
+		}
+	}
+
+	for _, id := range ids {
+		if err = compileSchema(schemas, id, enums, flags); err != nil {
+			return err
+		}
+	}
+
+	if err = applyOverrides(dir, schemas); err != nil {
+		return err
+	}
+
+	root := newGvdbTable()
+	for _, id := range ids {
+		s := schemas[id]
+		table := newGvdbTable()
+		for _, name := range s.names {
+			table.insertValue(name, s.keys[name].value())
+		}
+		if s.xml.Path != "" {
+			table.insertValue(".path", newString(s.xml.Path))
+		}
+		if s.domain != "" && s.isLocalized() {
+			table.insertValue(".gettext-domain", newString(s.domain))
+		}
+		if s.xml.Extends != "" {
+			table.insertValue(".extends", newString(s.xml.Extends))
+		}
+		if s.xml.ListOf != "" {
+			table.insertValue(".list-of", newString(s.xml.ListOf))
+		}
+		for _, child := range s.xml.Children {
+			table.insertValue(child.Name+"/", newString(child.Schema))
+		}
+		root.insertTable(id, table)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "gschemas.compiled"), serializeGvdb(root), 0644)
+}
+
+// enumStrinfo returns the strinfo for an <enum> or <flags> element
+func enumStrinfo(e xmlEnum, isFlags bool) (*strinfo, error) {
+	info := &strinfo{}
+	for _, v := range e.Values {
+		n, err := strconv.ParseInt(v.Value, 0, 64)
+		if err != nil {
+			return nil, errors.New("invalid value '" + v.Value + "' for '" + v.Nick + "' in " + e.ID)
+		}
+		if isFlags && (n <= 0 || n&(n-1) != 0) {
+			return nil, errors.New("flags value '" + v.Value + "' for '" + v.Nick + "' in " + e.ID + " is not a single bit")
+		}
+		info.append(v.Nick, uint32(n), 0xff)
+	}
+	return info, nil
+}
+
+// compileSchema compiles the keys of schema id, after the schema it extends
+func compileSchema(schemas map[string]*schema, id string, enums map[string]*strinfo, flags map[string]*strinfo) error {
+	s := schemas[id]
+	if s.done {
+		return nil
+	}
+	if s.working {
+		return errors.New("schema '" + id + "' extends itself")
+	}
+	s.working = true
+	where := filepath.Base(s.file) + ": schema '" + id + "': "
+
+	if s.xml.Extends != "" {
+		parent, ok := schemas[s.xml.Extends]
+		if ok == false {
+			return errors.New(where + "extends missing schema '" + s.xml.Extends + "'")
+		}
+		if err := compileSchema(schemas, s.xml.Extends, enums, flags); err != nil {
+			return err
+		}
+		for _, name := range parent.names {
+			k := *parent.keys[name]
+			s.keys[name] = &k
+			s.names = append(s.names, name)
+		}
+	}
+
+	for _, x := range s.xml.Keys {
+		k := &key{}
+		var info *strinfo
+		var marker byte
+		var err error
+		switch {
+		case x.Enum != "":
+			info, marker = enums[x.Enum], 'e'
+			k.typ, _ = parseType("s")
+		case x.Flags != "":
+			info, marker = flags[x.Flags], 'f'
+			k.typ, _ = parseType("as")
+		default:
+			k.typ, err = parseType(x.Type)
+			if err != nil {
+				return errors.New(where + "key '" + x.Name + "': " + err.Error())
+			}
+		}
+		if (x.Enum != "" || x.Flags != "") && info == nil {
+			return errors.New(where + "key '" + x.Name + "' uses an undefined enum or flags type")
+		}
+		k.defaultValue, err = parseText(x.Default.Text, k.typ)
+		if err != nil {
+			return errors.New(where + "key '" + x.Name + "': invalid default value: " + err.Error())
+		}
+
+		if x.Default.L10n != "" {
+			unparsed := strings.TrimSpace(x.Default.Text)
+			if x.Default.Context != "" {
+				// GSettings looks up translations with the context prepended, like pgettext does
+				unparsed = x.Default.Context + "\004" + unparsed
+			}
+			k.extras = append(k.extras, newTuple([]gvValue{newByte('l'),
+				newTuple([]gvValue{newByte(x.Default.L10n[0]), newString(unparsed)}, false)}, false))
+		}
+		if len(x.Choices) > 0 {
+			info, marker = &strinfo{}, 'c'
+			for _, choice := range x.Choices {
+				info.append(choice.Value, 0, 0xff)
+			}
+		}
+		if len(x.Aliases) > 0 {
+			if info == nil {
+				return errors.New(where + "key '" + x.Name + "': aliases need choices or an enum")
+			}
+			aliased := &strinfo{data: append([]byte{}, info.data...)}
+			for _, alias := range x.Aliases {
+				if err = aliased.appendAlias(alias.Value, alias.Target); err != nil {
+					return errors.New(where + "key '" + x.Name + "': " + err.Error())
+				}
+			}
+			info = aliased
+		}
+		if info != nil {
+			k.extras = append(k.extras, newTuple([]gvValue{newByte(marker), info.value()}, false))
+		}
+		if x.Range != nil {
+			min, err := parseText(x.Range.Min, k.typ)
+			if err != nil {
+				return errors.New(where + "key '" + x.Name + "': invalid range: " + err.Error())
+			}
+			max, err := parseText(x.Range.Max, k.typ)
+			if err != nil {
+				return errors.New(where + "key '" + x.Name + "': invalid range: " + err.Error())
+			}
+			k.extras = append(k.extras, newTuple([]gvValue{newByte('r'), newTuple([]gvValue{min, max}, false)}, false))
+		}
+
+		if _, ok := s.keys[x.Name]; ok == false {
+			s.names = append(s.names, x.Name)
+		}
+		s.keys[x.Name] = k
+	}
+
+	for _, override := range s.xml.Overrides {
+		if err := overrideDefault(s, override.Name, override.Text); err != nil {
+			return errors.New(where + err.Error())
+		}
+	}
+
+	s.done = true
+	return nil
+}
+
+// overrideDefault replaces the default value of a key in s
+func overrideDefault(s *schema, name string, text string) error {
+	k, ok := s.keys[name]
+	if ok == false {
+		return errors.New("cannot override missing key '" + name + "'")
+	}
+	value, err := parseText(text, k.typ)
+	if err != nil {
+		return errors.New("invalid override for key '" + name + "': " + err.Error())
+	}
+	overridden := *k
+	overridden.defaultValue = value
+	// The localized default no longer applies
+	overridden.extras = nil
+	for _, extra := range k.extras {
+		if extra.data[0] != 'l' {
+			overridden.extras = append(overridden.extras, extra)
+		}
+	}
+	s.keys[name] = &overridden
+	return nil
+}
+
+// applyOverrides applies the *.gschema.override files in dir in order of their names,
+// so that later files take precedence
+func applyOverrides(dir string, schemas map[string]*schema) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.gschema.override"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, file)
+		if err != nil {
+			return errors.New(filepath.Base(file) + ": " + err.Error())
+		}
+		for _, section := range cfg.Sections() {
+			if section.Name() == ini.DefaultSection {
+				continue
+			}
+			if strings.Contains(section.Name(), ":") {
+				// Desktop-specific overrides are not supported by the native compiler
+				continue
+			}
+			s, ok := schemas[section.Name()]
+			if ok == false {
+				// Like glib-compile-schemas, ignore overrides for schemas that are not installed
+				continue
+			}
+			for _, k := range section.Keys() {
+				if err = overrideDefault(s, k.Name(), k.Value()); err != nil {
+					return errors.New(filepath.Base(file) + ": [" + section.Name() + "]: " + err.Error())
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package gschema
+
+import (
+	"testing"
+)
+
+func TestParseText(t *testing.T) {
+	tests := []struct {
+		typ  string
+		text string
+		want string
+	}{
+		{"as", "['over']", "over\x00\x05"},
+		{"aay", "[b'abc', b'']", "abc\x00\x00\x04\x05"},
+		{"(sib)", "('q', -3, true)", "q\x00\x00\x00\xfd\xff\xff\xff\x01\x02"},
+		{"mi", "5", "\x05\x00\x00\x00"},
+		{"ms", "nothing", ""},
+		{"u", "uint32 9", "\x09\x00\x00\x00"},
+		{"v", "<'z'>", "z\x00\x00s"},
+	}
+	for _, test := range tests {
+		typ, err := parseType(test.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := parseText(test.text, typ)
+		if err != nil {
+			t.Errorf("%s %s: %v", test.typ, test.text, err)
+			continue
+		}
+		if string(value.data) != test.want {
+			t.Errorf("%s %s: got %q, want %q", test.typ, test.text, value.data, test.want)
+		}
+	}
+}
+
+func TestStrinfo(t *testing.T) {
+	// The example from GLib's strinfo.c: foo = 1, bar = 2, baz is an alias for bar
+	info := &strinfo{}
+	info.append("foo", 1, 0xff)
+	info.append("bar", 2, 0xff)
+	if err := info.appendAlias("baz", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x01\x00\x00\x00\xfffoo\x00\x00\x00\xff\x02\x00\x00\x00\xffbar\x00\x00\x00\xff\x03\x00\x00\x00\xfebaz\x00\x00\x00\xff"
+	if string(info.data) != want {
+		t.Errorf("got %q, want %q", info.data, want)
+	}
+}
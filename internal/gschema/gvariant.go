@@ -0,0 +1,629 @@
+package gschema
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// gvType is a GVariant type, e.g., "s", "as" or "(ii)"
+type gvType struct {
+	str      string    // Type string
+	elem     *gvType   // Element type of arrays and maybes
+	children []*gvType // Types of the members of tuples and dictionary entries
+}
+
+// parseType parses a complete GVariant type string
+func parseType(s string) (*gvType, error) {
+	t, rest, err := parseTypePrefix(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.New("invalid type " + s)
+	}
+	return t, nil
+}
+
+// parseTypePrefix parses one GVariant type from the beginning of s
+// and returns it together with the rest of s
+func parseTypePrefix(s string) (*gvType, string, error) {
+	if s == "" {
+		return nil, "", errors.New("missing type")
+	}
+	switch s[0] {
+	case 'b', 'y', 'n', 'q', 'i', 'u', 'x', 't', 'h', 'd', 's', 'o', 'g', 'v':
+		return &gvType{str: s[:1]}, s[1:], nil
+	case 'a', 'm':
+		elem, rest, err := parseTypePrefix(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		return &gvType{str: s[:1] + elem.str, elem: elem}, rest, nil
+	case '(', '{':
+		closing := byte(')')
+		if s[0] == '{' {
+			closing = '}'
+		}
+		t := &gvType{}
+		rest := s[1:]
+		for rest != "" && rest[0] != closing {
+			child, r, err := parseTypePrefix(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			t.children = append(t.children, child)
+			rest = r
+		}
+		if rest == "" {
+			return nil, "", errors.New("unterminated type " + s)
+		}
+		if s[0] == '{' && len(t.children) != 2 {
+			return nil, "", errors.New("dictionary entries need exactly two members: " + s)
+		}
+		t.str = s[:len(s)-len(rest)+1]
+		return t, rest[1:], nil
+	}
+	return nil, "", errors.New("invalid type " + s)
+}
+
+// isContainer returns true for tuples and dictionary entries
+func (t *gvType) isContainer() bool {
+	return t.str[0] == '(' || t.str[0] == '{'
+}
+
+// alignment returns the alignment of the serialized form of values of the type
+func (t *gvType) alignment() int {
+	switch t.str[0] {
+	case 'n', 'q':
+		return 2
+	case 'i', 'u', 'h':
+		return 4
+	case 'x', 't', 'd', 'v':
+		return 8
+	case 'a', 'm':
+		return t.elem.alignment()
+	case '(', '{':
+		alignment := 1
+		for _, child := range t.children {
+			if child.alignment() > alignment {
+				alignment = child.alignment()
+			}
+		}
+		return alignment
+	}
+	return 1
+}
+
+// fixedSize returns the size of the serialized form of values of the type,
+// or 0 if the size depends on the value
+func (t *gvType) fixedSize() int {
+	switch t.str[0] {
+	case 'b', 'y':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'i', 'u', 'h':
+		return 4
+	case 'x', 't', 'd':
+		return 8
+	case '(', '{':
+		offset := 0
+		for _, child := range t.children {
+			size := child.fixedSize()
+			if size == 0 {
+				return 0
+			}
+			offset = align(offset, child.alignment()) + size
+		}
+		offset = align(offset, t.alignment())
+		if offset == 0 {
+			return 1 // The unit type
+		}
+		return offset
+	}
+	return 0
+}
+
+// gvValue is a GVariant value in serialized form
+type gvValue struct {
+	typ  *gvType
+	data []byte
+}
+
+func align(offset int, alignment int) int {
+	return (offset + alignment - 1) / alignment * alignment
+}
+
+func pad(data []byte, alignment int) []byte {
+	for len(data)%alignment != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// offsetSize returns the size of the framing offsets in a container with
+// a body of bodySize bytes and n framing offsets
+func offsetSize(bodySize int, n int) int {
+	switch {
+	case bodySize+n == 0:
+		return 0
+	case bodySize+n <= math.MaxUint8:
+		return 1
+	case bodySize+2*n <= math.MaxUint16:
+		return 2
+	case bodySize+4*n <= math.MaxUint32:
+		return 4
+	}
+	return 8
+}
+
+// appendOffsets appends the framing offsets to data
+func appendOffsets(data []byte, offsets []int) []byte {
+	size := offsetSize(len(data), len(offsets))
+	for _, offset := range offsets {
+		for i := 0; i < size; i++ {
+			data = append(data, byte(uint64(offset)>>(8*uint(i))))
+		}
+	}
+	return data
+}
+
+// newString returns a value of type s
+func newString(s string) gvValue {
+	return gvValue{&gvType{str: "s"}, append([]byte(s), 0)}
+}
+
+// newByte returns a value of type y
+func newByte(b byte) gvValue {
+	return gvValue{&gvType{str: "y"}, []byte{b}}
+}
+
+// newArray returns an array of the values of type elem
+func newArray(elem *gvType, values []gvValue) gvValue {
+	t := &gvType{str: "a" + elem.str, elem: elem}
+	var data []byte
+	if elem.fixedSize() != 0 {
+		for _, value := range values {
+			data = append(data, value.data...)
+		}
+		return gvValue{t, data}
+	}
+	var offsets []int
+	for _, value := range values {
+		data = pad(data, elem.alignment())
+		data = append(data, value.data...)
+		offsets = append(offsets, len(data))
+	}
+	return gvValue{t, appendOffsets(data, offsets)}
+}
+
+// newMaybe returns a maybe of type m<elem>, containing value unless it is nil
+func newMaybe(elem *gvType, value *gvValue) gvValue {
+	t := &gvType{str: "m" + elem.str, elem: elem}
+	if value == nil {
+		return gvValue{t, nil}
+	}
+	data := append([]byte{}, value.data...)
+	if elem.fixedSize() == 0 {
+		data = append(data, 0)
+	}
+	return gvValue{t, data}
+}
+
+// newTuple returns a tuple, or a dictionary entry if isEntry is true, of the values
+func newTuple(values []gvValue, isEntry bool) gvValue {
+	t := &gvType{}
+	for _, value := range values {
+		t.children = append(t.children, value.typ)
+	}
+	if isEntry {
+		t.str = "{" + t.children[0].str + t.children[1].str + "}"
+	} else {
+		t.str = "("
+		for _, child := range t.children {
+			t.str = t.str + child.str
+		}
+		t.str = t.str + ")"
+	}
+	var data []byte
+	var offsets []int
+	for i, value := range values {
+		data = pad(data, value.typ.alignment())
+		data = append(data, value.data...)
+		if value.typ.fixedSize() == 0 && i != len(values)-1 {
+			offsets = append([]int{len(data)}, offsets...) // Stored in reverse order
+		}
+	}
+	if t.fixedSize() != 0 {
+		data = pad(data, t.alignment())
+		if len(data) == 0 {
+			data = []byte{0}
+		}
+		return gvValue{t, data}
+	}
+	return gvValue{t, appendOffsets(data, offsets)}
+}
+
+// newVariant returns a value of type v containing value
+func newVariant(value gvValue) gvValue {
+	data := append(append([]byte{}, value.data...), 0)
+	return gvValue{&gvType{str: "v"}, append(data, value.typ.str...)}
+}
+
+// Type keywords that can precede values in the text format, e.g., "uint32 5"
+var typeKeywords = map[string]string{
+	"boolean": "b", "byte": "y", "int16": "n", "uint16": "q", "int32": "i", "uint32": "u",
+	"int64": "x", "uint64": "t", "handle": "h", "double": "d", "string": "s",
+	"objectpath": "o", "signature": "g",
+}
+
+// textParser parses the GVariant text format as used for default values in schemas
+type textParser struct {
+	s   string
+	pos int
+}
+
+// parseText parses the text representation of a value of type t
+func parseText(text string, t *gvType) (gvValue, error) {
+	p := &textParser{s: text}
+	value, err := p.parseValue(t)
+	if err != nil {
+		return value, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return value, errors.New("unexpected '" + p.s[p.pos:] + "' after value")
+	}
+	return value, nil
+}
+
+func (p *textParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// peek returns the next character that is not whitespace, or 0 at the end
+func (p *textParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *textParser) expect(c byte) error {
+	if p.peek() != c {
+		return errors.New("expected '" + string(c) + "' at '" + p.s[p.pos:] + "'")
+	}
+	p.pos++
+	return nil
+}
+
+// word returns the next identifier or number without consuming it
+func (p *textParser) word() string {
+	p.skipSpace()
+	end := p.pos
+	for end < len(p.s) && (strings.IndexByte("+-.", p.s[end]) >= 0 ||
+		(p.s[end] >= '0' && p.s[end] <= '9') || (p.s[end] >= 'a' && p.s[end] <= 'z') || (p.s[end] >= 'A' && p.s[end] <= 'Z')) {
+		end++
+	}
+	return p.s[p.pos:end]
+}
+
+func (p *textParser) parseValue(t *gvType) (gvValue, error) {
+	// Explicit type annotations
+	if p.peek() == '@' {
+		p.pos++
+		annotated, rest, err := parseTypePrefix(p.s[p.pos:])
+		if err != nil {
+			return gvValue{}, err
+		}
+		if t != nil && annotated.str != t.str {
+			return gvValue{}, errors.New("expected type " + t.str + " but got " + annotated.str)
+		}
+		p.pos = len(p.s) - len(rest)
+		t = annotated
+	} else if keyword, ok := typeKeywords[p.word()]; ok {
+		if t != nil && keyword != t.str {
+			return gvValue{}, errors.New("expected type " + t.str + " but got " + keyword)
+		}
+		p.pos = p.pos + len(p.word())
+		t, _ = parseType(keyword)
+	}
+	if t == nil {
+		var err error
+		t, err = p.inferType()
+		if err != nil {
+			return gvValue{}, err
+		}
+	}
+
+	switch t.str[0] {
+	case 'b':
+		switch p.word() {
+		case "true":
+			p.pos = p.pos + 4
+			return gvValue{t, []byte{1}}, nil
+		case "false":
+			p.pos = p.pos + 5
+			return gvValue{t, []byte{0}}, nil
+		}
+		return gvValue{}, errors.New("expected a boolean at '" + p.s[p.pos:] + "'")
+	case 'y', 'n', 'q', 'i', 'u', 'x', 't', 'h':
+		return p.parseInteger(t)
+	case 'd':
+		word := p.word()
+		f, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return gvValue{}, errors.New("expected a double at '" + p.s[p.pos:] + "'")
+		}
+		p.pos = p.pos + len(word)
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, math.Float64bits(f))
+		return gvValue{t, data}, nil
+	case 's', 'o', 'g':
+		s, err := p.parseString()
+		if err != nil {
+			return gvValue{}, err
+		}
+		return gvValue{t, append([]byte(s), 0)}, nil
+	case 'v':
+		if err := p.expect('<'); err != nil {
+			return gvValue{}, err
+		}
+		value, err := p.parseValue(nil)
+		if err != nil {
+			return gvValue{}, err
+		}
+		if err = p.expect('>'); err != nil {
+			return gvValue{}, err
+		}
+		return newVariant(value), nil
+	case 'm':
+		switch p.word() {
+		case "nothing":
+			p.pos = p.pos + len("nothing")
+			return newMaybe(t.elem, nil), nil
+		case "just":
+			p.pos = p.pos + len("just")
+		}
+		value, err := p.parseValue(t.elem)
+		if err != nil {
+			return gvValue{}, err
+		}
+		return newMaybe(t.elem, &value), nil
+	case 'a':
+		return p.parseArray(t)
+	case '(':
+		return p.parseTuple(t)
+	case '{':
+		return p.parseEntry(t, '{', '}')
+	}
+	return gvValue{}, errors.New("unsupported type " + t.str)
+}
+
+// inferType returns the type of the value that follows if it has no type annotation
+func (p *textParser) inferType() (*gvType, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		return parseType("s")
+	case c == '<':
+		return parseType("v")
+	case c == '[':
+		// Infer the type from the first element
+		saved := p.pos
+		p.pos++
+		if p.peek() == ']' {
+			p.pos = saved
+			return nil, errors.New("cannot infer the type of an empty array, use a type annotation like @as []")
+		}
+		elem, err := p.inferType()
+		p.pos = saved
+		if err != nil {
+			return nil, err
+		}
+		return parseType("a" + elem.str)
+	}
+	switch word := p.word(); {
+	case word == "true" || word == "false":
+		return parseType("b")
+	case strings.ContainsAny(word, ".eE") || word == "inf" || word == "nan":
+		return parseType("d")
+	case word != "":
+		return parseType("i")
+	}
+	return nil, errors.New("cannot infer the type of '" + p.s[p.pos:] + "', use a type annotation")
+}
+
+func (p *textParser) parseInteger(t *gvType) (gvValue, error) {
+	word := p.word()
+	bits := map[byte]int{'y': 8, 'n': 16, 'q': 16, 'i': 32, 'u': 32, 'h': 32, 'x': 64, 't': 64}[t.str[0]]
+	var n uint64
+	var err error
+	if strings.IndexByte("yqut", t.str[0]) >= 0 {
+		n, err = strconv.ParseUint(word, 0, bits)
+	} else {
+		var i int64
+		i, err = strconv.ParseInt(word, 0, bits)
+		n = uint64(i)
+	}
+	if err != nil {
+		return gvValue{}, errors.New("expected a number of type " + t.str + " at '" + p.s[p.pos:] + "'")
+	}
+	p.pos = p.pos + len(word)
+	data := make([]byte, bits/8)
+	for i := range data {
+		data[i] = byte(n >> (8 * uint(i)))
+	}
+	return gvValue{t, data}, nil
+}
+
+func (p *textParser) parseString() (string, error) {
+	quote := p.peek()
+	if quote != '\'' && quote != '"' {
+		return "", errors.New("expected a string at '" + p.s[p.pos:] + "'")
+	}
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		if c == quote {
+			return b.String(), nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if p.pos >= len(p.s) {
+			break
+		}
+		c = p.s[p.pos]
+		p.pos++
+		switch c {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case 'u', 'U':
+			length := 4
+			if c == 'U' {
+				length = 8
+			}
+			if p.pos+length > len(p.s) {
+				return "", errors.New("invalid unicode escape in string")
+			}
+			r, err := strconv.ParseUint(p.s[p.pos:p.pos+length], 16, 32)
+			if err != nil || utf8.ValidRune(rune(r)) == false {
+				return "", errors.New("invalid unicode escape in string")
+			}
+			b.WriteRune(rune(r))
+			p.pos = p.pos + length
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated string")
+}
+
+func (p *textParser) parseArray(t *gvType) (gvValue, error) {
+	var values []gvValue
+	// Dictionaries can be written as {key: value, ...}
+	if t.elem.str[0] == '{' && p.peek() == '{' {
+		p.pos++
+		for p.peek() != '}' {
+			entry, err := p.parseEntryMembers(t.elem, ':')
+			if err != nil {
+				return gvValue{}, err
+			}
+			values = append(values, entry)
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+		}
+		if err := p.expect('}'); err != nil {
+			return gvValue{}, err
+		}
+		return newArray(t.elem, values), nil
+	}
+	if t.elem.str == "y" && (strings.HasPrefix(p.s[p.pos:], "b'") || strings.HasPrefix(p.s[p.pos:], "b\"")) {
+		p.pos++
+		s, err := p.parseString()
+		if err != nil {
+			return gvValue{}, err
+		}
+		for _, c := range append([]byte(s), 0) {
+			values = append(values, newByte(c))
+		}
+		return newArray(t.elem, values), nil
+	}
+	if err := p.expect('['); err != nil {
+		return gvValue{}, err
+	}
+	for p.peek() != ']' {
+		value, err := p.parseValue(t.elem)
+		if err != nil {
+			return gvValue{}, err
+		}
+		values = append(values, value)
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if err := p.expect(']'); err != nil {
+		return gvValue{}, err
+	}
+	return newArray(t.elem, values), nil
+}
+
+func (p *textParser) parseTuple(t *gvType) (gvValue, error) {
+	if err := p.expect('('); err != nil {
+		return gvValue{}, err
+	}
+	var values []gvValue
+	for i, child := range t.children {
+		value, err := p.parseValue(child)
+		if err != nil {
+			return gvValue{}, err
+		}
+		values = append(values, value)
+		// A tuple with one member is written as (value,)
+		if i < len(t.children)-1 || len(t.children) == 1 {
+			if err = p.expect(','); err != nil {
+				return gvValue{}, err
+			}
+		}
+	}
+	if err := p.expect(')'); err != nil {
+		return gvValue{}, err
+	}
+	return newTuple(values, false), nil
+}
+
+// parseEntry parses a dictionary entry written as {key, value} or {key: value}
+func (p *textParser) parseEntry(t *gvType, opening byte, closing byte) (gvValue, error) {
+	if err := p.expect(opening); err != nil {
+		return gvValue{}, err
+	}
+	entry, err := p.parseEntryMembers(t, 0)
+	if err != nil {
+		return gvValue{}, err
+	}
+	return entry, p.expect(closing)
+}
+
+// parseEntryMembers parses the key and value of a dictionary entry separated by separator,
+// or by either ':' or ',' if separator is 0
+func (p *textParser) parseEntryMembers(t *gvType, separator byte) (gvValue, error) {
+	key, err := p.parseValue(t.children[0])
+	if err != nil {
+		return gvValue{}, err
+	}
+	c := p.peek()
+	if (separator != 0 && c != separator) || (separator == 0 && c != ':' && c != ',') {
+		return gvValue{}, errors.New("expected a separator between key and value at '" + p.s[p.pos:] + "'")
+	}
+	p.pos++
+	value, err := p.parseValue(t.children[1])
+	if err != nil {
+		return gvValue{}, err
+	}
+	return newTuple([]gvValue{key, value}, true), nil
+}
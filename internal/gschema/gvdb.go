@@ -0,0 +1,139 @@
+package gschema
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+)
+
+// gvdbTable is a hash table in a GVariant database (GVDB), the file format of gschemas.compiled.
+// Each item either holds a value or another table
+type gvdbTable struct {
+	keys   []string
+	values map[string]*gvValue
+	tables map[string]*gvdbTable
+}
+
+func newGvdbTable() *gvdbTable {
+	return &gvdbTable{values: make(map[string]*gvValue), tables: make(map[string]*gvdbTable)}
+}
+
+func (table *gvdbTable) insertValue(key string, value gvValue) {
+	if _, ok := table.values[key]; ok == false {
+		table.keys = append(table.keys, key)
+	}
+	table.values[key] = &value
+}
+
+func (table *gvdbTable) insertTable(key string, child *gvdbTable) {
+	table.keys = append(table.keys, key)
+	table.tables[key] = child
+}
+
+// gvdbHash is the hash function used by GVDB
+func gvdbHash(key string) uint32 {
+	hash := uint32(5381)
+	for i := 0; i < len(key); i++ {
+		hash = hash*33 + uint32(int32(int8(key[i])))
+	}
+	return hash
+}
+
+// gvdbWriter lays out the chunks of a GVDB file
+type gvdbWriter struct {
+	data []byte
+}
+
+// allocate appends size zero bytes at the given alignment and returns their start and end
+func (w *gvdbWriter) allocate(alignment int, size int) (int, int) {
+	w.data = pad(w.data, alignment)
+	start := len(w.data)
+	w.data = append(w.data, make([]byte, size)...)
+	return start, len(w.data)
+}
+
+func (w *gvdbWriter) putUint32(offset int, v uint32) {
+	binary.LittleEndian.PutUint32(w.data[offset:], v)
+}
+
+// writeTable writes table and everything it contains and returns where it is.
+// Like glib-compile-schemas, it adds a list with the empty key that contains all items
+// not starting with ".", which is how GSettings enumerates schemas and their keys
+func (w *gvdbWriter) writeTable(table *gvdbTable) (int, int) {
+	keys := append([]string{""}, table.keys...)
+	n := len(keys)
+	buckets := make(map[string]int)
+	for _, key := range keys {
+		buckets[key] = int(gvdbHash(key) % uint32(n))
+	}
+	// The items of each bucket need to be next to each other
+	sort.SliceStable(keys, func(i, j int) bool { return buckets[keys[i]] < buckets[keys[j]] })
+	list := 0
+	var listed []int
+	for i, key := range keys {
+		if key == "" {
+			list = i
+		} else if strings.HasPrefix(key, ".") == false {
+			listed = append(listed, i)
+		}
+	}
+
+	// Header without bloom filter, bucket table, items
+	start, end := w.allocate(4, 8+4*n+24*n)
+	w.putUint32(start, 0)
+	w.putUint32(start+4, uint32(n))
+	for bucket := 0; bucket < n; bucket++ {
+		first := 0
+		for first < n && buckets[keys[first]] < bucket {
+			first++
+		}
+		w.putUint32(start+8+4*bucket, uint32(first))
+	}
+
+	for i, key := range keys {
+		item := start + 8 + 4*n + 24*i
+		keyStart, _ := w.allocate(1, len(key))
+		copy(w.data[keyStart:], key)
+		var itemType byte = 'v'
+		var valueStart, valueEnd int
+		parent := uint32(0xffffffff) // No parent, the key is complete
+		if key == "" {
+			itemType = 'L'
+			valueStart, valueEnd = w.allocate(4, 4*len(listed))
+			for j, index := range listed {
+				w.putUint32(valueStart+4*j, uint32(index))
+			}
+		} else if child, ok := table.tables[key]; ok {
+			itemType = 'H'
+			valueStart, valueEnd = w.writeTable(child)
+		} else {
+			value := newVariant(*table.values[key])
+			valueStart, valueEnd = w.allocate(8, len(value.data))
+			copy(w.data[valueStart:], value.data)
+		}
+		if key != "" && strings.HasPrefix(key, ".") == false {
+			// The key relative to the empty parent key is the key itself
+			parent = uint32(list)
+		}
+		w.putUint32(item, gvdbHash(key))
+		w.putUint32(item+4, parent)
+		w.putUint32(item+8, uint32(keyStart))
+		binary.LittleEndian.PutUint16(w.data[item+12:], uint16(len(key)))
+		w.data[item+14] = itemType
+		w.putUint32(item+16, uint32(valueStart))
+		w.putUint32(item+20, uint32(valueEnd))
+	}
+	return start, end
+}
+
+// serializeGvdb returns the GVDB file with root as the root table
+func serializeGvdb(root *gvdbTable) []byte {
+	w := &gvdbWriter{}
+	w.allocate(1, 24)
+	copy(w.data, "GVariant")
+	// Version and options are 0
+	start, end := w.writeTable(root)
+	w.putUint32(16, uint32(start))
+	w.putUint32(20, uint32(end))
+	return w.data
+}
@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// annotate is true if warnings and errors are also written as GitHub Actions annotations, see EnableAnnotations
+var annotate bool
+
+// logPrefixRegexp matches the date and time that the standard logger puts before each message
+var logPrefixRegexp = regexp.MustCompile(`^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d `)
+
+// EnableAnnotations makes PrintError, and the log messages that start with WARNING or ERROR, also write
+// workflow commands that GitHub Actions shows as annotations in the summary of the workflow run, see
+// https://docs.github.com/en/actions/using-workflow-commands-for-github-actions
+func EnableAnnotations() {
+	annotate = true
+	log.SetOutput(annotatingWriter{w: log.Writer()})
+}
+
+// Annotate writes a GitHub Actions annotation with the level error, warning or notice
+// if annotations are enabled
+func Annotate(level string, message string) {
+	if annotate {
+		fmt.Println("::" + level + "::" + escapeWorkflowCommand(message))
+	}
+}
+
+// escapeWorkflowCommand escapes the characters that end the message of a workflow command
+func escapeWorkflowCommand(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(strings.TrimSpace(s))
+}
+
+// SetOutput sets an output of the current step of a GitHub Actions workflow,
+// which later steps can use as ${{ steps.<id>.outputs.<name> }}. Does nothing outside of GitHub Actions
+func SetOutput(name string, value string) error {
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s=%s\n", name, value)
+	return err
+}
+
+// annotatingWriter writes log messages to w and annotates those that start with WARNING or ERROR
+type annotatingWriter struct {
+	w io.Writer
+}
+
+func (a annotatingWriter) Write(p []byte) (int, error) {
+	n, err := a.w.Write(p)
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		message := logPrefixRegexp.ReplaceAllString(string(line), "")
+		switch {
+		case strings.HasPrefix(message, "WARNING"):
+			Annotate("warning", strings.TrimLeft(strings.TrimPrefix(message, "WARNING"), ": "))
+		case strings.HasPrefix(message, "ERROR"):
+			Annotate("error", strings.TrimLeft(strings.TrimPrefix(message, "ERROR"), ": "))
+		}
+	}
+	return n, err
+}
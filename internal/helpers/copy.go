@@ -0,0 +1,115 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// CopyTree copies src to dst, recursively if src is a directory, merging it into dst if that exists.
+// Unlike CopyFile, symlinks are copied as symlinks (including src itself), and the permissions,
+// timestamps and extended attributes (e.g., security.capability) of everything are preserved, as is the
+// sparseness of files, see copyContents. Extended attributes that the filesystem of dst does not support
+// or that the user may not set, e.g., trusted.* ones for non-root users, are left out.
+// Special files such as device nodes, FIFOs and sockets are skipped
+func CopyTree(src string, dst string) error {
+	return CopyTreeExcept(src, dst, nil)
+}
+
+// CopyTreeExcept is like CopyTree but leaves out the files and directories for whose paths in src skip returns true
+func CopyTreeExcept(src string, dst string, skip func(path string) bool) error {
+	if skip != nil && skip(src) {
+		return nil
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+		if existing, err := os.Lstat(dst); err == nil && existing.IsDir() == false {
+			os.Remove(dst)
+		}
+		err = os.Symlink(target, dst)
+		if err != nil {
+			return err
+		}
+	case info.IsDir():
+		// Writable until everything is copied into it, even if the original is not
+		err = os.MkdirAll(dst, 0755)
+		if err != nil {
+			return err
+		}
+		entries, err := ioutil.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err = CopyTreeExcept(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), skip)
+			if err != nil {
+				return err
+			}
+		}
+		err = os.Chmod(dst, preservedMode(info))
+		if err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		err = copyRegularFile(src, dst, info)
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	err = copyXattrs(src, dst)
+	if err != nil {
+		return err
+	}
+	return copyTimes(dst, info)
+}
+
+// copyRegularFile copies the contents and permissions of the regular file at src to dst
+func copyRegularFile(src string, dst string, info os.FileInfo) error {
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// An existing symlink would be followed
+	if existing, err := os.Lstat(dst); err == nil && existing.Mode()&os.ModeSymlink != 0 {
+		os.Remove(dst)
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	err = copyContents(out, in)
+	if err != nil {
+		return err
+	}
+	// Not using the permissions when creating the file since the umask would apply to them
+	err = out.Chmod(preservedMode(info))
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// preservedMode returns the permissions of info including the setuid, setgid and sticky bits
+func preservedMode(info os.FileInfo) os.FileMode {
+	return info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
@@ -0,0 +1,143 @@
+//go:build linux
+// +build linux
+
+package helpers
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Size of the chunks copied at once with copy_file_range(2)
+const copyChunkSize = 1 << 30
+
+// Values of whence for lseek(2) that find the next data or hole at or after an offset
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// copyContents copies the contents of in to out, which must be empty, without reading
+// them into memory. If both are on a filesystem that supports it (e.g., Btrfs or XFS),
+// out becomes a reflink of in that shares its data until either is modified, which takes
+// no time and space. Otherwise copy_file_range(2) lets the kernel copy the data, which also
+// works across filesystems on recent kernels. If neither is possible, the data is streamed.
+// Either way, only the regions of in that contain data are copied, so that holes stay holes
+// and sparse files do not take up more space as copies.
+// Hardlinks are deliberately not used since the copies get patched, which would change the originals
+func copyContents(out *os.File, in *os.File) error {
+	err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	if err == nil {
+		return nil
+	}
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	for offset := int64(0); offset < size; {
+		start, end := offset, size
+		dataStart, err := in.Seek(offset, seekData)
+		if errors.Is(err, unix.ENXIO) {
+			// Only a hole is left
+			break
+		} else if err == nil {
+			start = dataStart
+			if holeStart, err := in.Seek(start, seekHole); err == nil {
+				end = holeStart
+			}
+		}
+		// If the filesystem cannot tell where the holes are, everything is copied
+		err = copyRange(out, in, start, end)
+		if err != nil {
+			return err
+		}
+		offset = end
+	}
+	// Holes at the end are left by extending out to the size of in
+	return out.Truncate(size)
+}
+
+// copyRange copies the bytes from start to end of in to the same offsets in out
+func copyRange(out *os.File, in *os.File, start int64, end int64) error {
+	for start < end {
+		chunk := end - start
+		if chunk > copyChunkSize {
+			chunk = copyChunkSize
+		}
+		inOffset, outOffset := start, start
+		n, err := unix.CopyFileRange(int(in.Fd()), &inOffset, int(out.Fd()), &outOffset, int(chunk), 0)
+		if err != nil || n == 0 {
+			break
+		}
+		start += int64(n)
+	}
+	if start == end {
+		return nil
+	}
+
+	// Continue where copy_file_range(2) stopped, if it worked at all
+	_, err := out.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.NewSectionReader(in, start, end-start))
+	return err
+}
+
+// copyTimes sets the access and modification times of dst, which is not followed if it is a symlink, to those in info
+func copyTimes(dst string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok == false {
+		return os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+	times := []unix.Timespec{
+		unix.NsecToTimespec(syscall.TimespecToNsec(stat.Atim)),
+		unix.NsecToTimespec(info.ModTime().UnixNano()),
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// copyXattrs copies the extended attributes of src to dst, neither of which is followed if it is a symlink
+func copyXattrs(src string, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil || size == 0 {
+		return ignoreXattrError(err)
+	}
+	list := make([]byte, size)
+	size, err = unix.Llistxattr(src, list)
+	if err != nil {
+		return ignoreXattrError(err)
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00") {
+		size, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			return ignoreXattrError(err)
+		}
+		value := make([]byte, size)
+		size, err = unix.Lgetxattr(src, name, value)
+		if err != nil {
+			return ignoreXattrError(err)
+		}
+		err = ignoreXattrError(unix.Lsetxattr(dst, name, value[:size], 0))
+		if err != nil {
+			return errors.New("could not copy extended attribute " + name + " of " + src + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// ignoreXattrError returns nil for errors that mean that extended attributes, or the ones in question,
+// are not supported or may not be set
+func ignoreXattrError(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) || errors.Is(err, unix.ENODATA) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package helpers
+
+import (
+	"io"
+	"os"
+)
+
+// copyContents copies the contents of in to out. Only Linux can share or sparsely copy the data, see copy_linux.go
+func copyContents(out *os.File, in *os.File) error {
+	_, err := io.Copy(out, in)
+	return err
+}
+
+// copyTimes sets the modification time of dst to the one in info, symlinks are left alone
+func copyTimes(dst string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// copyXattrs does nothing, extended attributes are only copied on Linux
+func copyXattrs(src string, dst string) error {
+	return nil
+}
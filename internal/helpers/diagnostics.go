@@ -0,0 +1,154 @@
+package helpers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Diagnostic is an error, warning or note that was logged, together with the phase of the work
+// it was logged in, so that it can be summarized at the end rather than being lost among the log lines
+type Diagnostic struct {
+	Level   string // ERROR, WARNING or NOTE
+	Phase   string
+	Message string
+}
+
+// Levels of diagnostics, which are also the prefixes of the log messages that are collected
+const (
+	LevelError   = "ERROR"
+	LevelWarning = "WARNING"
+	LevelNote    = "NOTE"
+)
+
+var (
+	diagnosticsMutex sync.Mutex
+	diagnostics      []Diagnostic
+	diagnosticsPhase string
+	collecting       bool
+)
+
+// Color modes, see SetColorMode
+const (
+	ColorAuto   = "auto"   // Colors if writing to a terminal and $NO_COLOR is not set
+	ColorAlways = "always" // E.g., for CI systems that show colors
+	ColorNever  = "never"
+)
+
+var ColorModes = []string{ColorAuto, ColorAlways, ColorNever}
+
+var colorMode = ColorAuto
+
+// Escape sequences for the levels of diagnostics
+var levelColors = map[string]string{
+	LevelError:   "\x1b[1;31m", // Bold red
+	LevelWarning: "\x1b[1;33m", // Bold yellow
+	LevelNote:    "\x1b[1;36m", // Bold cyan
+}
+
+const colorReset = "\x1b[0m"
+
+// levelRegexp matches the level at the start of a log message, after the date and time
+var levelRegexp = regexp.MustCompile(`(?m)^(\d{4}/\d\d/\d\d \d\d:\d\d:\d\d )?(ERROR|WARNING|NOTE)\b`)
+
+// SetColorMode selects whether the output is colored, one of ColorModes
+func SetColorMode(mode string) error {
+	if SliceContains(ColorModes, mode) == false {
+		return errors.New("unknown color mode " + mode + ", available: " + strings.Join(ColorModes, ", "))
+	}
+	colorMode = mode
+	return nil
+}
+
+// UseColor returns true if escape sequences for colors should be written to f, see SetColorMode.
+// https://no-color.org
+func UseColor(f *os.File) bool {
+	switch colorMode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Colorize returns s in the color of the level if colors are used for f
+func Colorize(f *os.File, level string, s string) string {
+	if levelColors[level] == "" || UseColor(f) == false {
+		return s
+	}
+	return levelColors[level] + s + colorReset
+}
+
+// CollectDiagnostics makes the log messages that start with ERROR, WARNING or NOTE, and PrintError,
+// be recorded together with the current phase (see SetPhase) until TakeDiagnostics is called.
+// Their levels are colored if the log goes to a terminal
+func CollectDiagnostics() {
+	collecting = true
+	log.SetOutput(diagnosticsWriter{w: log.Writer()})
+}
+
+// SetPhase sets the phase of the work that the diagnostics logged from now on belong to, e.g., "Bundling libraries"
+func SetPhase(phase string) {
+	diagnosticsMutex.Lock()
+	diagnosticsPhase = phase
+	diagnosticsMutex.Unlock()
+}
+
+// Phase returns the current phase, e.g., to restore it after a nested one
+func Phase() string {
+	diagnosticsMutex.Lock()
+	defer diagnosticsMutex.Unlock()
+	return diagnosticsPhase
+}
+
+// RecordDiagnostic records a diagnostic in the current phase without logging it,
+// e.g., for every item of a list that is printed under one log message
+func RecordDiagnostic(level string, message string) {
+	if collecting == false {
+		return
+	}
+	diagnosticsMutex.Lock()
+	diagnostics = append(diagnostics, Diagnostic{Level: level, Phase: diagnosticsPhase, Message: strings.TrimSpace(message)})
+	diagnosticsMutex.Unlock()
+}
+
+// TakeDiagnostics returns the diagnostics recorded so far in the order in which they were logged, and forgets them
+func TakeDiagnostics() []Diagnostic {
+	diagnosticsMutex.Lock()
+	defer diagnosticsMutex.Unlock()
+	taken := diagnostics
+	diagnostics = nil
+	return taken
+}
+
+// diagnosticsWriter writes log messages to w with colored levels and records those that start with a level
+type diagnosticsWriter struct {
+	w io.Writer
+}
+
+func (d diagnosticsWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		match := levelRegexp.FindStringSubmatch(line)
+		if match != nil {
+			RecordDiagnostic(match[2], strings.TrimLeft(strings.TrimPrefix(line, match[0]), ": "))
+		}
+	}
+	colored := p
+	if f, ok := d.w.(*os.File); ok && UseColor(f) {
+		colored = []byte(levelRegexp.ReplaceAllStringFunc(string(p), func(s string) string {
+			level := s[strings.LastIndex(s, " ")+1:]
+			return strings.TrimSuffix(s, level) + Colorize(f, level, level)
+		}))
+	}
+	_, err := d.w.Write(colored)
+	return len(p), err
+}
@@ -60,7 +60,7 @@ func hashRange(f *os.File, h hash.Hash, offset int64, length int64) {
 	if length == 0 {
 		return
 	}
-	fmt.Println("...hashing", strconv.FormatInt(length, 10), "bytes")
+	log.Println("...hashing", strconv.FormatInt(length, 10), "bytes")
 	s := io.NewSectionReader(f, offset, length)
 	if _, err := io.Copy(h, s); err != nil {
 		log.Fatal(err)
@@ -71,7 +71,7 @@ func hashDummyRange(h hash.Hash, length int) {
 	if length == 0 {
 		return
 	}
-	fmt.Println("...hashing", strconv.Itoa(length), "bytes as if they were 0x00")
+	log.Println("...hashing", strconv.Itoa(length), "bytes as if they were 0x00")
 	h.Write(bytes.Repeat([]byte{0x00}, length))
 }
 
@@ -85,7 +85,7 @@ func CalculateSHA256Digest(path string) string {
 	// need to be skipped, although I think
 	// .upd_info
 	// ought to be skipped, too
-	fmt.Println("Calculating the sha256 digest...")
+	log.Println("Calculating the sha256 digest...")
 	var byteRangesToBeAssumedEmpty []ByteRange
 
 	// TheAssassin's implementation of the signature checking only zeros ".sha256_sig", ".sig_key"
@@ -97,7 +97,7 @@ func CalculateSHA256Digest(path string) string {
 			if length == 0 {
 				continue
 			}
-			fmt.Println("Assuming section", s, "offset", offset, "length", length, "to contain only '0x00's")
+			log.Println("Assuming section", s, "offset", offset, "length", length, "to contain only '0x00's")
 			br := ByteRange{int64(offset), int64(length)}
 			byteRangesToBeAssumedEmpty = append(byteRangesToBeAssumedEmpty, br)
 		}
@@ -0,0 +1,36 @@
+package helpers
+
+import (
+	"debug/elf"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// ReadElfRpath returns the DT_RUNPATH of the ELF at path, or its DT_RPATH if it has no DT_RUNPATH,
+// like patchelf --print-rpath does
+func ReadElfRpath(path string) (string, error) {
+	return ReadElfRpathFS(fsys.OS, path)
+}
+
+// ReadElfRpathFS is like ReadElfRpath but reads the ELF from fs
+func ReadElfRpathFS(fs fsys.FS, path string) (string, error) {
+	m, err := fsys.Map(fs, path)
+	if err != nil {
+		return "", err
+	}
+	defer m.Close()
+	f, err := elf.NewFile(m)
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		values, err := f.DynString(tag)
+		if err != nil {
+			return "", err
+		}
+		if len(values) > 0 {
+			return values[0], nil
+		}
+	}
+	return "", nil
+}
@@ -10,6 +10,8 @@ import (
 	"io"
 	"log"
 	"os"
+
+	"github.com/probonopd/go-appimage/internal/elfsection"
 )
 
 // CalculateElfSize returns the size of an ELF binary as an int64 based on the information in the ELF header
@@ -102,56 +104,11 @@ func CalculateElfSize(file string) int64 {
 
 // EmbedStringInSegment embeds a string in an ELF segment, returns error
 func EmbedStringInSegment(path string, section string, s string) error {
-	fmt.Println("")
-	// Find Offset and Length of section
-	uidata, err := GetSectionData(path, section)
-	// PrintError("GetSectionData for '"+section+"'", err)
-	if err != nil {
-		os.Stderr.WriteString("Could not find section " + section + " in runtime, exiting\n")
-		return (err)
-	}
-	fmt.Println("")
-	// fmt.Println("Section " + section + " before embedding:")
-	// fmt.Println(uidata)
-	fmt.Println("")
-	uioffset, uilength, err := GetSectionOffsetAndLength(path, section)
-	PrintError("GetSectionData for '"+section+"'", err)
-	if err != nil {
-		// only show the before embedding part as its too verbose in case of errors
-		fmt.Println("Section " + section + " before embedding:")
-		fmt.Println(uidata)
-		os.Stderr.WriteString("Could not determine Offset and Length of " + section + " in runtime, exiting\n")
-		return (err)
-	}
-	fmt.Println("Embedded " + section + " section Offset:", uioffset)
-	fmt.Println("Embedded " + section + " section Length:", uilength)
-	fmt.Println("")
-	// Exit if data exceeds available space in section
-	if len(s) > len(uidata) {
-		os.Stderr.WriteString("does not fit into " + section + " section, exiting\n")
-		return (err)
-	}
-	fmt.Println("Writing into "+section+" section...", uilength)
-	// Seek file to ui_offset and write it there
-	WriteStringIntoOtherFileAtOffset(s, path, uioffset)
-	PrintError("GetSectionData for '"+section+"'", err)
-	if err != nil {
-		os.Stderr.WriteString("Could write into " + section + " section, exiting\n")
-		return (err)
-	}
-	uidata, err = GetSectionData(path, section)
-	PrintError("GetSectionData for '" + section + "'", err)
+	err := elfsection.Write(path, section, []byte(s))
 	if err != nil {
-		os.Stderr.WriteString("Could not find section " + section + " in runtime, exiting\n")
 		return err
 	}
-	fmt.Println("")
-	// FIXME: Might be too verbose? I am removing it
-	// fmt.Println("Embedded " + section + " section after embedding:")
-	// fmt.Println(uidata)
-	fmt.Println("")
 	fmt.Println("Embedded " + section + " section now contains:")
-	fmt.Println(string(uidata))
-	fmt.Println("")
+	fmt.Println(s)
 	return nil
 }
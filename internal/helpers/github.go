@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/google/go-github/github" // with go modules disabled
@@ -70,6 +71,40 @@ func GetReleaseURL(ui UpdateInformation) (string, error) {
 	return "", errors.New("GetReleaseURL: Could not get URL")
 }
 
+// GetZsyncURL gets the URL of the zsync file for the most recent AppImage
+// matching the given UpdateInformation, and its name without the .zsync suffix.
+// For GitHub Releases, this is the first release asset matching the filename pattern. Returns err
+func GetZsyncURL(ui UpdateInformation) (string, string, error) {
+
+	if ui.transportmechanism == "zsync" {
+		return ui.fileurl, strings.TrimSuffix(path.Base(ui.fileurl), ".zsync"), nil
+	}
+
+	if ui.transportmechanism == "gh-releases-zsync" {
+
+		client := github.NewClient(nil)
+
+		var release *github.RepositoryRelease
+		var err error
+		if ui.releasename == "latest" {
+			release, _, err = client.Repositories.GetLatestRelease(context.Background(), ui.username, ui.repository)
+		} else {
+			release, _, err = client.Repositories.GetReleaseByTag(context.Background(), ui.username, ui.repository, ui.releasename)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		for _, asset := range release.Assets {
+			if matched, _ := path.Match(ui.filename, asset.GetName()); matched {
+				return asset.GetBrowserDownloadURL(), strings.TrimSuffix(asset.GetName(), ".zsync"), nil
+			}
+		}
+		return "", "", errors.New("No asset matching " + ui.filename + " in release " + release.GetTagName())
+	}
+
+	return "", "", errors.New("Not yet implemented for this transport mechanism")
+}
+
 // GetCommitMessageForThisCommitOnTravis returns a string with the most
 // recent commit message for the commit in the TRAVIS_COMMIT environment variable, and error
 func GetCommitMessageForThisCommitOnTravis() (string, error) {
@@ -99,7 +134,3 @@ func GetCommitMessageForThisCommitOnTravis() (string, error) {
 	}
 
 }
-
-// TODO: uploadtool functionality
-// Create continuous release, upload files to it
-// See the existing Go tools for GitHub Releases
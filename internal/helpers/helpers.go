@@ -33,7 +33,9 @@ const UpdateInformationKey = "X-AppImage-UpdateInformation"
 // PrintError prints error, prefixed by a string that explains the context
 func PrintError(context string, e error) {
 	if e != nil {
-		os.Stderr.WriteString("ERROR " + context + ": " + e.Error() + "\n")
+		os.Stderr.WriteString(Colorize(os.Stderr, LevelError, "ERROR") + " " + context + ": " + e.Error() + "\n")
+		Annotate("error", context+": "+e.Error())
+		RecordDiagnostic(LevelError, context+": "+e.Error())
 	}
 }
 
@@ -42,6 +44,7 @@ func LogError(context string, e error) {
 	if e != nil {
 		l := log.New(os.Stderr, "", 1)
 		l.Println("ERROR " + context + ": " + e.Error())
+		Annotate("error", context+": "+e.Error())
 	}
 }
 
@@ -102,7 +105,11 @@ func AddHereToPath() {
 // FilesWithSuffixInDirectoryRecursive returns the files in a given directory with the given filename extension, and err
 func FilesWithSuffixInDirectoryRecursive(directory string, extension string) []string {
 	var foundfiles []string
-	err := filepath.Walk(directory, func(path string, info os.FileInfo, _ error) error {
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// E.g., the directory does not exist
+			return nil
+		}
 		if strings.HasSuffix(info.Name(), extension) {
 			foundfiles = append(foundfiles, path)
 		}
@@ -239,11 +246,19 @@ func GetValuesForAllDesktopFiles(key string) []string {
 	return results
 }
 
-// ValidateDesktopFile validates a desktop file using the desktop-file-validate tool on the $PATH
+// ValidateDesktopFile validates a desktop file using the desktop-file-validate tool on the $PATH,
+// or using the less thorough ValidateDesktopFileNatively if the tool is not available.
 // Returns error if validation fails and prints any errors to stderr
 func ValidateDesktopFile(desktopfile string) error {
-	cmd := exec.Command("desktop-file-validate", desktopfile)
-	out, err := cmd.CombinedOutput()
+	var out []byte
+	var err error
+	if IsCommandAvailable("desktop-file-validate") {
+		cmd := exec.Command("desktop-file-validate", desktopfile)
+		out, err = cmd.CombinedOutput()
+	} else {
+		log.Println("desktop-file-validate not found, validating the desktop file with basic built-in checks")
+		err = ValidateDesktopFileNatively(desktopfile)
+	}
 	if err != nil {
 		PrintError("desktop-file-validate", err)
 		fmt.Printf("%s", string(out))
@@ -272,6 +287,7 @@ func ValidateAppStreamMetainfoFile(appdirpath string) error {
 // CopyFile copies the src file to dst.
 // Any existing file will be overwritten and will not
 // copy file attributes.
+// The contents are reflinked or copied by the kernel if possible, see copyContents.
 // Unclear why such basic functionality is not in the standard library.
 func CopyFile(src string, dst string) error {
 
@@ -298,7 +314,7 @@ func CopyFile(src string, dst string) error {
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, in)
+	err = copyContents(out, in)
 	if err != nil {
 		return err
 	}
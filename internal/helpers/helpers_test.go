@@ -1,9 +1,18 @@
 package helpers_test
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"gopkg.in/ini.v1"
 )
 
 var err error
@@ -44,3 +53,226 @@ func TestValidateUpdateInformation(t *testing.T) {
 	}
 
 }
+
+func TestNormalizeDesktopFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	desktopfile := dir + "/myapp.desktop"
+	err = ioutil.WriteFile(desktopfile, []byte(`[Desktop Entry]
+Type=Application
+Name=My App
+Exec=/usr/bin/myapp %U
+TryExec=/usr/bin/myapp
+Icon=/usr/share/icons/hicolor/256x256/apps/myapp.png
+Categories=Utility;
+Actions=new-window;missing;
+
+[Desktop Action new-window]
+Name=New Window
+Exec=/usr/bin/myapp --new-window
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = helpers.NormalizeDesktopFile(desktopfile, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, desktopfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"Exec":    "myapp %U",
+		"Icon":    "myapp",
+		"TryExec": "",
+		"Actions": "new-window;",
+	}
+	for key, value := range expected {
+		if d.Section("Desktop Entry").Key(key).String() != value {
+			t.Errorf("Expected " + key + "=" + value + " but got " + key + "=" + d.Section("Desktop Entry").Key(key).String())
+		}
+	}
+	if d.Section("Desktop Action new-window").Key("Exec").String() != "myapp --new-window" {
+		t.Errorf("Path was not removed from Exec= of action: " + d.Section("Desktop Action new-window").Key("Exec").String())
+	}
+}
+
+func TestValidateDesktopFileNatively(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := map[string]bool{
+		"[Desktop Entry]\nType=Application\nName=My App\nName[de]=Meine App\nExec=myapp\nCategories=Utility;\n": true,
+		"[Desktop Entry]\nType=Application\nName=My App\nExec=myapp\nCategories=Utility\n":                      false,
+		"[Desktop Entry]\nType=Application\nName=My App\nName=Other\nExec=myapp\n":                              false,
+		"[Desktop Entry]\nType=Application\nName=My App\n":                                                      false,
+		"[Desktop Entry]\nType=App\nName=My App\nExec=myapp\n":                                                  false,
+		"[Desktop Action new]\nName=New\n\n[Desktop Entry]\nType=Application\nName=My App\nExec=myapp\n":        false,
+		"[Desktop Entry]\nType=Application\nName=My App\nExec=myapp\nMy_Key=1\n":                                false,
+	}
+	for contents, valid := range tests {
+		desktopfile := dir + "/myapp.desktop"
+		err = ioutil.WriteFile(desktopfile, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = helpers.ValidateDesktopFileNatively(desktopfile)
+		if (err == nil) != valid {
+			t.Errorf("Expected valid=%v for %q, got error %v", valid, contents, err)
+		}
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := make([]byte, 3*1024*1024+17)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	err = ioutil.WriteFile(dir+"/src", data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overwrites existing files
+	os.MkdirAll(dir+"/dst", 0755)
+	err = ioutil.WriteFile(dir+"/dst/file", make([]byte, 4*1024*1024), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = helpers.CopyFile(dir+"/src", dir+"/dst/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, _ := ioutil.ReadFile(dir + "/dst/file")
+	if string(copied) != string(data) {
+		t.Error("Copy differs from the original")
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copytree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir+"/src/usr/lib", 0755)
+	// A sparse file with data at the beginning and at 8 MiB
+	f, err := os.Create(dir + "/src/usr/lib/sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("start"))
+	f.WriteAt([]byte("end"), 8*1024*1024)
+	f.Close()
+	os.Chmod(dir+"/src/usr/lib/sparse", 0750)
+	os.Symlink("sparse", dir+"/src/usr/lib/link")
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(dir+"/src/usr/lib/sparse", modTime, modTime)
+	os.Chtimes(dir+"/src/usr/lib", modTime, modTime)
+
+	err = helpers.CopyTree(dir+"/src", dir+"/dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, _ := ioutil.ReadFile(dir + "/dst/usr/lib/sparse")
+	if len(copied) != 8*1024*1024+3 || string(copied[:5]) != "start" || string(copied[8*1024*1024:]) != "end" {
+		t.Error("Copy differs from the original")
+	}
+	info, err := os.Stat(dir + "/dst/usr/lib/sparse")
+	if err != nil || info.Mode().Perm() != 0750 || info.ModTime().Equal(modTime) == false {
+		t.Error("Permissions or modification time not preserved:", info.Mode(), info.ModTime())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Blocks*512 >= 8*1024*1024 {
+		t.Error("Holes were filled:", stat.Blocks, "blocks")
+	}
+	if target, err := os.Readlink(dir + "/dst/usr/lib/link"); err != nil || target != "sparse" {
+		t.Error("Symlink not preserved:", target, err)
+	}
+	if info, err := os.Stat(dir + "/dst/usr/lib"); err != nil || info.ModTime().Equal(modTime) == false {
+		t.Error("Modification time of directory not preserved")
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	helpers.EnableAnnotations()
+	log.Println("WARNING: Something is 100% odd")
+	log.Println("Nothing to annotate")
+	helpers.PrintError("Validation", errors.New("line one\nline two"))
+	w.Close()
+	os.Stdout = stdout
+	log.SetOutput(os.Stderr)
+	annotations, _ := ioutil.ReadAll(r)
+	expected := "::warning::Something is 100%25 odd\n::error::Validation: line one%0Aline two\n"
+	if string(annotations) != expected {
+		t.Errorf("Expected annotations %q, got %q", expected, annotations)
+	}
+
+	output := t.TempDir() + "/output"
+	os.Setenv("GITHUB_OUTPUT", output)
+	defer os.Unsetenv("GITHUB_OUTPUT")
+	helpers.SetOutput("appimage", "/out/Foo-1.0-x86_64.AppImage")
+	helpers.SetOutput("version", "1.0")
+	if data, _ := ioutil.ReadFile(output); string(data) != "appimage=/out/Foo-1.0-x86_64.AppImage\nversion=1.0\n" {
+		t.Errorf("Unexpected outputs %q", data)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	helpers.CollectDiagnostics()
+	helpers.SetPhase("Bundling libraries")
+	log.Println("WARNING: libfoo.so could not be found")
+	log.Println("Nothing to record")
+	helpers.SetPhase("Plugin gdk-pixbuf")
+	log.Println("NOTE: Not adding libpixbufloader-svg.so to loaders.cache because it is not bundled")
+	helpers.RecordDiagnostic(helpers.LevelError, "Something failed")
+
+	diagnostics := helpers.TakeDiagnostics()
+	expected := []helpers.Diagnostic{
+		{Level: "WARNING", Phase: "Bundling libraries", Message: "libfoo.so could not be found"},
+		{Level: "NOTE", Phase: "Plugin gdk-pixbuf", Message: "Not adding libpixbufloader-svg.so to loaders.cache because it is not bundled"},
+		{Level: "ERROR", Phase: "Plugin gdk-pixbuf", Message: "Something failed"},
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expected), diagnostics)
+	}
+	for i := range expected {
+		if diagnostics[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], diagnostics[i])
+		}
+	}
+	if len(helpers.TakeDiagnostics()) != 0 {
+		t.Error("The diagnostics were not forgotten")
+	}
+	// Not colored since the log does not go to a terminal
+	if strings.Contains(buf.String(), "\x1b[") || strings.Contains(buf.String(), "WARNING: libfoo.so") == false {
+		t.Errorf("Unexpected log output %q", buf.String())
+	}
+	if helpers.SetColorMode("sometimes") == nil {
+		t.Error("Unknown color mode was accepted")
+	}
+	helpers.SetColorMode(helpers.ColorAlways)
+	defer helpers.SetColorMode(helpers.ColorAuto)
+	if colored := helpers.Colorize(os.Stderr, helpers.LevelWarning, "WARNING"); colored != "\x1b[1;33mWARNING\x1b[0m" {
+		t.Errorf("Unexpected colored level %q", colored)
+	}
+}
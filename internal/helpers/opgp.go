@@ -11,7 +11,6 @@ import (
 	"fmt"
 	"golang.org/x/crypto/openpgp/packet"
 	"io/ioutil"
-	"log"
 	"strings"
 	"time"
 
@@ -21,29 +20,33 @@ import (
 	"golang.org/x/crypto/openpgp"
 )
 
-func CreateAndValidateKeyPair() {
-	createKeyPair()
-
-	b, err := ioutil.ReadFile("privkey")
+// CreateAndValidateKeyPair writes a new key pair to PubkeyFileName and PrivkeyFileName
+// and checks that the private key can be read back, returns error
+func CreateAndValidateKeyPair() error {
+	err := createKeyPair()
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	hexstring, _ := readPGP(b)
+
+	b, err := ioutil.ReadFile(PrivkeyFileName)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = validate(hexstring)
+	hexstring, err := readPGP(b)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	return validate(hexstring)
 }
 
 func readPGP(armoredKey []byte) (string, error) {
 	keyReader := bytes.NewReader(armoredKey)
 	entityList, err := openpgp.ReadArmoredKeyRing(keyReader)
 	if err != nil {
-		log.Fatalf("error reading armored key %s", err)
+		return "", fmt.Errorf("error reading armored key %s", err)
+	}
+	if len(entityList) == 0 {
+		return "", errors.New("no key found in the armored key ring")
 	}
 	serializedEntity := bytes.NewBuffer(nil)
 	err = entityList[0].Serialize(serializedEntity)
@@ -67,28 +70,28 @@ func validate(keystring string) error {
 	return nil
 }
 
-func createKeyPair() {
+func createKeyPair() error {
 	config := gpgeez.Config{Expiry: 0 * time.Hour}
 	config.RSABits = 4096
 	key, err := gpgeez.CreateKey("Signing key", "", "", &config) // TODO: Better name, comment, email
 	if err != nil {
-		fmt.Printf("Something went wrong while creating key pair: %v", err)
-		return
+		return fmt.Errorf("something went wrong while creating key pair: %v", err)
 	}
 	pubkeyascdata, err := key.Armor()
 	if err != nil {
-		fmt.Printf("Something went wrong while armoding public key: %v", err)
-		return
+		return fmt.Errorf("something went wrong while armoring public key: %v", err)
 	}
 
 	privkeyascdata, err := key.ArmorPrivate(&config)
 	if err != nil {
-		fmt.Printf("Something went wrong while armoding private key: %v", err)
-		return
+		return fmt.Errorf("something went wrong while armoring private key: %v", err)
 	}
 
-	ioutil.WriteFile(PubkeyFileName, []byte(pubkeyascdata), 0666)
-	ioutil.WriteFile(PrivkeyFileName, []byte(privkeyascdata), 0600)
+	err = ioutil.WriteFile(PubkeyFileName, []byte(pubkeyascdata), 0666)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(PrivkeyFileName, []byte(privkeyascdata), 0600)
 }
 
 // CheckSignature checks the signature embedded in an AppImage at path,
@@ -0,0 +1,198 @@
+package i18n
+
+import "golang.org/x/text/language"
+
+// The translations of the messages, keyed by the English messages as passed to Sprintf.
+// When adding a message, add its translations to all languages; a missing one is shown in English.
+// Arguments can be reordered in a translation using %[n]s
+var catalogs = map[language.Tag]map[string]string{
+	language.German: {
+		// Desktop notifications
+		"Added application":     "Anwendung hinzugefügt",
+		"Added %d applications": "%d Anwendungen hinzugefügt",
+		"Moved":                 "Verschoben",
+		"Removed":               "Entfernt",
+		"Quarantined %s":        "%s in Quarantäne",
+		"It was not integrated because %s.\nRun 'appimaged trust %s' or click below to make it executable and integrate it": "Es wurde nicht integriert, weil %s.\nFühren Sie 'appimaged trust %s' aus oder klicken Sie unten, um es ausführbar zu machen und zu integrieren",
+		"Make executable":                       "Ausführbar machen",
+		"Update available":                      "Aktualisierung verfügbar",
+		"Update":                                "Aktualisieren",
+		"%s can be updated to version %s. \n%s": "%s kann auf Version %s aktualisiert werden. \n%s",
+		"An update for the AppImage daemon is available; I could update myself now...": "Eine Aktualisierung des AppImage-Dienstes ist verfügbar",
+		"Updated":                  "Aktualisiert",
+		"%s was updated to %s":     "%s wurde auf %s aktualisiert",
+		"Up to date":               "Aktuell",
+		"%s is already up to date": "%s ist bereits aktuell",
+		"AppImageUpdater missing":  "AppImageUpdater fehlt",
+		"Please download the AppImageUpdater\nAppImage and try again": "Bitte laden Sie das AppImageUpdater-\nAppImage herunter und versuchen Sie es erneut",
+		"Cannot open %s":                       "%s kann nicht geöffnet werden",
+		"Missing library %s":                   "Fehlende Bibliothek %s",
+		"%s is not a proper AppImage":          "%s ist kein ordnungsgemäßes AppImage",
+		"%s\nPlease ask the author to fix it.": "%s\nBitten Sie den Autor, dies zu beheben.",
+		"%s is defective, AppRun is missing. \nPlease ask the author to fix it.":                                                                                                   "%s ist fehlerhaft, AppRun fehlt. \nBitten Sie den Autor, dies zu beheben.",
+		"%s is defective, could not load the Qt platform plugin. \nPlease run on the command line with 'QT_DEBUG_PLUGINS=1' \nto see error messages and ask the author to fix it.": "%s ist fehlerhaft, das Qt-Plattform-Plugin konnte nicht geladen werden. \nBitte führen Sie es auf der Kommandozeile mit 'QT_DEBUG_PLUGINS=1' aus, \num die Fehlermeldungen zu sehen, und bitten Sie den Autor, dies zu beheben.",
+		"Invalid desktop file":                                                                  "Ungültige Desktop-Datei",
+		"UDisks showexec issue":                                                                 "UDisks-showexec-Problem",
+		"Applications cannot run from \n%s. \nSee \n%s":                                         "Anwendungen können nicht von \n%s ausgeführt werden. \nSiehe \n%s",
+		"Not running from an AppImage":                                                          "Läuft nicht aus einem AppImage",
+		"This is discouraged because some functionality may not be available":                   "Davon wird abgeraten, da einige Funktionen möglicherweise nicht verfügbar sind",
+		"Other AppImage integration daemon detected":                                            "Anderer AppImage-Integrationsdienst gefunden",
+		"Please uninstall appimagelauncher first, then try again":                               "Bitte deinstallieren Sie zuerst appimagelauncher und versuchen Sie es dann erneut",
+		"Not running on one of the supported Live systems":                                      "Läuft nicht auf einem der unterstützten Live-Systeme",
+		"This configuration is currently unsupported but may still work, please give feedback.": "Diese Konfiguration wird derzeit nicht unterstützt, funktioniert aber möglicherweise trotzdem. Bitte geben Sie Rückmeldung.",
+		"Cannot see volumes come and go":                                                        "Datenträger können nicht überwacht werden",
+		"Not implemented yet for this kind of system":                                           "Für diese Art von System noch nicht umgesetzt",
+
+		// Dialogs
+		"Run once":           "Einmal ausführen",
+		"Integrate and run":  "Integrieren und ausführen",
+		"Move to %s and run": "Nach %s verschieben und ausführen",
+		"%s is not integrated into the system yet. Integrating it adds it to the menu.\nWhat do you want to do?": "%s ist noch nicht in das System integriert. Durch die Integration wird es dem Menü hinzugefügt.\nWas möchten Sie tun?",
+		"Choice [1]: ": "Auswahl [1]: ",
+
+		// Errors on the command line
+		"Argument missing":                                               "Argument fehlt",
+		"No AppImage supplied":                                           "Kein AppImage angegeben",
+		"No name supplied":                                               "Kein Name angegeben",
+		"No search term supplied":                                        "Kein Suchbegriff angegeben",
+		"No updateinformation supplied":                                  "Keine Aktualisierungsinformationen angegeben",
+		"Invalid updateinformation string supplied":                      "Ungültige Aktualisierungsinformationen angegeben",
+		"No AppImage found for %s":                                       "Kein AppImage für %s gefunden",
+		"No integrated AppImage matches %s":                              "Kein integriertes AppImage passt zu %s",
+		"Trusted %s; it will be integrated the next time appimaged runs": "%s wird vertraut; es wird integriert, wenn appimaged das nächste Mal läuft",
+		"Changing the integration for all users needs root rights, please run this as root": "Das Ändern der Integration für alle Benutzer erfordert Root-Rechte, bitte führen Sie dies als root aus",
+		"%s is not an AppImage":                                          "%s ist kein AppImage",
+		"%s is not integrated":                                           "%s ist nicht integriert",
+		"could not integrate %s":                                         "%s konnte nicht integriert werden",
+		"no integrated AppImage called %s":                               "kein integriertes AppImage namens %s",
+		"%s exists already":                                              "%s existiert bereits",
+		"%s does not contain update information":                         "%s enthält keine Aktualisierungsinformationen",
+		"%s is damaged or has been modified, its signature status is %s": "%s ist beschädigt oder wurde verändert, der Status seiner Signatur ist %s",
+	},
+	language.French: {
+		// Desktop notifications
+		"Added application":     "Application ajoutée",
+		"Added %d applications": "%d applications ajoutées",
+		"Moved":                 "Déplacée",
+		"Removed":               "Supprimée",
+		"Quarantined %s":        "%s mise en quarantaine",
+		"It was not integrated because %s.\nRun 'appimaged trust %s' or click below to make it executable and integrate it": "Elle n'a pas été intégrée car %s.\nExécutez 'appimaged trust %s' ou cliquez ci-dessous pour la rendre exécutable et l'intégrer",
+		"Make executable":                       "Rendre exécutable",
+		"Update available":                      "Mise à jour disponible",
+		"Update":                                "Mettre à jour",
+		"%s can be updated to version %s. \n%s": "%s peut être mise à jour vers la version %s. \n%s",
+		"An update for the AppImage daemon is available; I could update myself now...": "Une mise à jour du service AppImage est disponible",
+		"Updated":                  "Mise à jour effectuée",
+		"%s was updated to %s":     "%s a été mise à jour vers %s",
+		"Up to date":               "À jour",
+		"%s is already up to date": "%s est déjà à jour",
+		"AppImageUpdater missing":  "AppImageUpdater manquant",
+		"Please download the AppImageUpdater\nAppImage and try again": "Veuillez télécharger l'AppImage\nAppImageUpdater et réessayer",
+		"Cannot open %s":                       "Impossible d'ouvrir %s",
+		"Missing library %s":                   "Bibliothèque manquante %s",
+		"%s is not a proper AppImage":          "%s n'est pas une AppImage valide",
+		"%s\nPlease ask the author to fix it.": "%s\nVeuillez demander à l'auteur de le corriger.",
+		"%s is defective, AppRun is missing. \nPlease ask the author to fix it.":                                                                                                   "%s est défectueuse, AppRun est manquant. \nVeuillez demander à l'auteur de le corriger.",
+		"%s is defective, could not load the Qt platform plugin. \nPlease run on the command line with 'QT_DEBUG_PLUGINS=1' \nto see error messages and ask the author to fix it.": "%s est défectueuse, le plugin de plateforme Qt n'a pas pu être chargé. \nVeuillez l'exécuter en ligne de commande avec 'QT_DEBUG_PLUGINS=1' \npour voir les messages d'erreur et demander à l'auteur de le corriger.",
+		"Invalid desktop file":                                                                  "Fichier desktop invalide",
+		"UDisks showexec issue":                                                                 "Problème showexec d'UDisks",
+		"Applications cannot run from \n%s. \nSee \n%s":                                         "Les applications ne peuvent pas s'exécuter depuis \n%s. \nVoir \n%s",
+		"Not running from an AppImage":                                                          "Ne s'exécute pas depuis une AppImage",
+		"This is discouraged because some functionality may not be available":                   "Ceci est déconseillé car certaines fonctionnalités peuvent ne pas être disponibles",
+		"Other AppImage integration daemon detected":                                            "Autre service d'intégration d'AppImage détecté",
+		"Please uninstall appimagelauncher first, then try again":                               "Veuillez d'abord désinstaller appimagelauncher, puis réessayer",
+		"Not running on one of the supported Live systems":                                      "Ne s'exécute pas sur l'un des systèmes Live pris en charge",
+		"This configuration is currently unsupported but may still work, please give feedback.": "Cette configuration n'est actuellement pas prise en charge mais peut fonctionner, merci de nous faire part de vos retours.",
+		"Cannot see volumes come and go":                                                        "Impossible de surveiller les volumes",
+		"Not implemented yet for this kind of system":                                           "Pas encore implémenté pour ce type de système",
+
+		// Dialogs
+		"Run once":           "Exécuter une fois",
+		"Integrate and run":  "Intégrer et exécuter",
+		"Move to %s and run": "Déplacer vers %s et exécuter",
+		"%s is not integrated into the system yet. Integrating it adds it to the menu.\nWhat do you want to do?": "%s n'est pas encore intégrée au système. L'intégrer l'ajoute au menu.\nQue voulez-vous faire ?",
+		"Choice [1]: ": "Choix [1] : ",
+
+		// Errors on the command line
+		"Argument missing":                                               "Argument manquant",
+		"No AppImage supplied":                                           "Aucune AppImage indiquée",
+		"No name supplied":                                               "Aucun nom indiqué",
+		"No search term supplied":                                        "Aucun terme de recherche indiqué",
+		"No updateinformation supplied":                                  "Aucune information de mise à jour indiquée",
+		"Invalid updateinformation string supplied":                      "Information de mise à jour indiquée invalide",
+		"No AppImage found for %s":                                       "Aucune AppImage trouvée pour %s",
+		"No integrated AppImage matches %s":                              "Aucune AppImage intégrée ne correspond à %s",
+		"Trusted %s; it will be integrated the next time appimaged runs": "%s est approuvée ; elle sera intégrée la prochaine fois qu'appimaged s'exécutera",
+		"Changing the integration for all users needs root rights, please run this as root": "Modifier l'intégration pour tous les utilisateurs nécessite les droits root, veuillez exécuter ceci en tant que root",
+		"%s is not an AppImage":                                          "%s n'est pas une AppImage",
+		"%s is not integrated":                                           "%s n'est pas intégrée",
+		"could not integrate %s":                                         "impossible d'intégrer %s",
+		"no integrated AppImage called %s":                               "aucune AppImage intégrée nommée %s",
+		"%s exists already":                                              "%s existe déjà",
+		"%s does not contain update information":                         "%s ne contient pas d'information de mise à jour",
+		"%s is damaged or has been modified, its signature status is %s": "%s est endommagée ou a été modifiée, l'état de sa signature est %s",
+	},
+	language.Spanish: {
+		// Desktop notifications
+		"Added application":     "Aplicación añadida",
+		"Added %d applications": "%d aplicaciones añadidas",
+		"Moved":                 "Movida",
+		"Removed":               "Eliminada",
+		"Quarantined %s":        "%s en cuarentena",
+		"It was not integrated because %s.\nRun 'appimaged trust %s' or click below to make it executable and integrate it": "No se integró porque %s.\nEjecute 'appimaged trust %s' o haga clic abajo para hacerla ejecutable e integrarla",
+		"Make executable":                       "Hacer ejecutable",
+		"Update available":                      "Actualización disponible",
+		"Update":                                "Actualizar",
+		"%s can be updated to version %s. \n%s": "%s se puede actualizar a la versión %s. \n%s",
+		"An update for the AppImage daemon is available; I could update myself now...": "Hay una actualización del servicio de AppImage disponible",
+		"Updated":                  "Actualizada",
+		"%s was updated to %s":     "%s se actualizó a %s",
+		"Up to date":               "Actualizada",
+		"%s is already up to date": "%s ya está actualizada",
+		"AppImageUpdater missing":  "Falta AppImageUpdater",
+		"Please download the AppImageUpdater\nAppImage and try again": "Descargue la AppImage de\nAppImageUpdater e inténtelo de nuevo",
+		"Cannot open %s":                       "No se puede abrir %s",
+		"Missing library %s":                   "Falta la biblioteca %s",
+		"%s is not a proper AppImage":          "%s no es una AppImage válida",
+		"%s\nPlease ask the author to fix it.": "%s\nPida al autor que lo corrija.",
+		"%s is defective, AppRun is missing. \nPlease ask the author to fix it.":                                                                                                   "%s es defectuosa, falta AppRun. \nPida al autor que lo corrija.",
+		"%s is defective, could not load the Qt platform plugin. \nPlease run on the command line with 'QT_DEBUG_PLUGINS=1' \nto see error messages and ask the author to fix it.": "%s es defectuosa, no se pudo cargar el plugin de plataforma de Qt. \nEjecútela en la línea de comandos con 'QT_DEBUG_PLUGINS=1' \npara ver los mensajes de error y pida al autor que lo corrija.",
+		"Invalid desktop file":                                                                  "Archivo desktop no válido",
+		"UDisks showexec issue":                                                                 "Problema de showexec de UDisks",
+		"Applications cannot run from \n%s. \nSee \n%s":                                         "Las aplicaciones no se pueden ejecutar desde \n%s. \nVéase \n%s",
+		"Not running from an AppImage":                                                          "No se ejecuta desde una AppImage",
+		"This is discouraged because some functionality may not be available":                   "No se recomienda porque algunas funciones pueden no estar disponibles",
+		"Other AppImage integration daemon detected":                                            "Se detectó otro servicio de integración de AppImage",
+		"Please uninstall appimagelauncher first, then try again":                               "Desinstale primero appimagelauncher e inténtelo de nuevo",
+		"Not running on one of the supported Live systems":                                      "No se ejecuta en uno de los sistemas Live compatibles",
+		"This configuration is currently unsupported but may still work, please give feedback.": "Esta configuración no es compatible actualmente pero puede funcionar, envíenos sus comentarios.",
+		"Cannot see volumes come and go":                                                        "No se pueden vigilar los volúmenes",
+		"Not implemented yet for this kind of system":                                           "Aún no implementado para este tipo de sistema",
+
+		// Dialogs
+		"Run once":           "Ejecutar una vez",
+		"Integrate and run":  "Integrar y ejecutar",
+		"Move to %s and run": "Mover a %s y ejecutar",
+		"%s is not integrated into the system yet. Integrating it adds it to the menu.\nWhat do you want to do?": "%s aún no está integrada en el sistema. Al integrarla se añade al menú.\n¿Qué desea hacer?",
+		"Choice [1]: ": "Opción [1]: ",
+
+		// Errors on the command line
+		"Argument missing":                                               "Falta un argumento",
+		"No AppImage supplied":                                           "No se indicó ninguna AppImage",
+		"No name supplied":                                               "No se indicó ningún nombre",
+		"No search term supplied":                                        "No se indicó ningún término de búsqueda",
+		"No updateinformation supplied":                                  "No se indicó información de actualización",
+		"Invalid updateinformation string supplied":                      "La información de actualización indicada no es válida",
+		"No AppImage found for %s":                                       "No se encontró ninguna AppImage para %s",
+		"No integrated AppImage matches %s":                              "Ninguna AppImage integrada coincide con %s",
+		"Trusted %s; it will be integrated the next time appimaged runs": "Se confía en %s; se integrará la próxima vez que se ejecute appimaged",
+		"Changing the integration for all users needs root rights, please run this as root": "Cambiar la integración para todos los usuarios requiere derechos de root, ejecute esto como root",
+		"%s is not an AppImage":                                          "%s no es una AppImage",
+		"%s is not integrated":                                           "%s no está integrada",
+		"could not integrate %s":                                         "no se pudo integrar %s",
+		"no integrated AppImage called %s":                               "ninguna AppImage integrada se llama %s",
+		"%s exists already":                                              "%s ya existe",
+		"%s does not contain update information":                         "%s no contiene información de actualización",
+		"%s is damaged or has been modified, its signature status is %s": "%s está dañada o ha sido modificada, el estado de su firma es %s",
+	},
+}
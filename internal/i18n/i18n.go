@@ -0,0 +1,97 @@
+// Package i18n translates what appimaged and appimagetool show to users, such as desktop notifications,
+// dialogs and errors, into the language of the user. The translations are in the message catalog in
+// catalog.go, keyed by the English messages; messages without a translation are shown in English.
+// Log messages are not translated, so that they can be searched for in bug reports
+package i18n
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// English, the language of the keys, is used if there is no translation into the language of the user
+var messages = catalog.NewBuilder(catalog.Fallback(language.English))
+
+var printer = newPrinter()
+
+func newPrinter() *message.Printer {
+	for tag, translations := range catalogs {
+		for key, translation := range translations {
+			messages.SetString(language.English, key, key)
+			messages.SetString(tag, key, translation)
+		}
+	}
+	return message.NewPrinter(Language(), message.Catalog(messages))
+}
+
+// Language returns the language of the catalog that matches the preferences of the user best,
+// taken from $LANGUAGE, $LC_ALL, $LC_MESSAGES and $LANG like gettext does
+func Language() language.Tag {
+	_, index, _ := messages.Matcher().Match(parseTags(Preferred())...)
+	return messages.Languages()[index]
+}
+
+func parseTags(preferred []string) []language.Tag {
+	var tags []language.Tag
+	for _, value := range preferred {
+		if tag, err := language.Parse(value); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Preferred returns the languages the user prefers as BCP 47 tags, the most preferred first
+func Preferred() []string {
+	var preferred []string
+	// $LANGUAGE is a list like "de_AT:de:en", but only used if a locale is set, as in gettext
+	locale := ""
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if locale = os.Getenv(name); locale != "" {
+			break
+		}
+	}
+	if locale == "" || locale == "C" || locale == "POSIX" {
+		return []string{"en"}
+	}
+	for _, value := range strings.Split(os.Getenv("LANGUAGE"), ":") {
+		if tag := localeToTag(value); tag != "" {
+			preferred = append(preferred, tag)
+		}
+	}
+	if tag := localeToTag(locale); tag != "" {
+		preferred = append(preferred, tag)
+	}
+	return preferred
+}
+
+// localeToTag converts a POSIX locale like "de_DE.UTF-8@euro" into a BCP 47 tag like "de-DE"
+func localeToTag(locale string) string {
+	if i := strings.IndexAny(locale, ".@"); i >= 0 {
+		locale = locale[:i]
+	}
+	if locale == "C" || locale == "POSIX" {
+		return ""
+	}
+	return strings.Replace(locale, "_", "-", -1)
+}
+
+// Sprintf formats like fmt.Sprintf, using the translation of format if there is one
+func Sprintf(format string, a ...interface{}) string {
+	return printer.Sprintf(format, a...)
+}
+
+// Errorf returns an error with the translated message, see Sprintf
+func Errorf(format string, a ...interface{}) error {
+	return errors.New(Sprintf(format, a...))
+}
+
+// SetLanguage changes the language into which is translated, e.g., for tests
+func SetLanguage(tag language.Tag) {
+	printer = message.NewPrinter(tag, message.Catalog(messages))
+}
@@ -0,0 +1,69 @@
+package i18n
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestLanguage(t *testing.T) {
+	for _, name := range []string{"LANGUAGE", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+	tests := []struct {
+		language, lang string
+		want           language.Tag
+	}{
+		{"", "", language.English},
+		{"", "C.UTF-8", language.English},
+		{"", "de_DE.UTF-8", language.German},
+		{"", "de_AT.UTF-8@euro", language.German},
+		{"fr_CA:fr", "de_DE.UTF-8", language.French},
+		{"fr", "C", language.English},
+		{"", "ja_JP.UTF-8", language.English},
+	}
+	for _, test := range tests {
+		os.Setenv("LANGUAGE", test.language)
+		os.Setenv("LANG", test.lang)
+		if got := Language(); got != test.want {
+			t.Errorf("LANGUAGE=%q LANG=%q: got %v, want %v", test.language, test.lang, got, test.want)
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	defer SetLanguage(Language())
+
+	SetLanguage(language.German)
+	if got := Sprintf("Added %d applications", 3); got != "3 Anwendungen hinzugefügt" {
+		t.Error("Unexpected translation:", got)
+	}
+	if got := Sprintf("Not in the catalog %s", "x"); got != "Not in the catalog x" {
+		t.Error("Unexpected message without translation:", got)
+	}
+
+	SetLanguage(language.English)
+	if got := Sprintf("Cannot open %s", "Foo"); got != "Cannot open Foo" {
+		t.Error("Unexpected message:", got)
+	}
+}
+
+func TestCatalogsComplete(t *testing.T) {
+	for tag, translations := range catalogs {
+		for other, otherTranslations := range catalogs {
+			for key := range otherTranslations {
+				if _, ok := translations[key]; ok == false {
+					t.Errorf("%v lacks the translation of %q that %v has", tag, key, other)
+				}
+			}
+		}
+		for key, translation := range translations {
+			if strings.Count(key, "%") != strings.Count(translation, "%") {
+				t.Errorf("%v translation of %q has other arguments: %q", tag, key, translation)
+			}
+		}
+	}
+}
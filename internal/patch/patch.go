@@ -0,0 +1,270 @@
+// Package patch applies unified diffs, as produced by diff -u, git diff or quilt,
+// to files without needing the patch command
+package patch
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// How many lines away from the position given in the hunk header a hunk is looked for
+const maxOffset = 100
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// FilePatch contains the changes to one file
+type FilePatch struct {
+	Path   string // Path of the file to be changed, with the a/ or b/ prefix removed
+	Hunks  []Hunk
+	Create bool // The old file is /dev/null
+	Delete bool // The new file is /dev/null
+}
+
+// Hunk is a contiguous change in a file
+type Hunk struct {
+	OldStart int      // Line number (starting at 1) in the original file
+	Old      []string // Context and removed lines
+	New      []string // Context and added lines
+}
+
+// Parse parses a unified diff that may change several files,
+// removing the a/ or b/ prefix of git and quilt from the paths
+func Parse(diff string) ([]FilePatch, error) {
+	return ParseStrip(diff, -1)
+}
+
+// ParseStrip parses a unified diff like Parse, but removes strip leading
+// components from the paths like patch -pN does, unless strip is negative
+func ParseStrip(diff string, strip int) ([]FilePatch, error) {
+	var patches []FilePatch
+	lines := strings.Split(diff, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "+++ ") {
+			if i == 0 || strings.HasPrefix(lines[i-1], "--- ") == false {
+				return nil, errors.New("+++ without --- in line " + strconv.Itoa(i+1))
+			}
+			oldPath := parsePath(lines[i-1][4:], strip)
+			path := parsePath(line[4:], strip)
+			filePatch := FilePatch{Path: path, Create: oldPath == "/dev/null", Delete: path == "/dev/null"}
+			if filePatch.Delete {
+				// The file gets deleted, use the old name
+				filePatch.Path = oldPath
+			}
+			if filePatch.Create && filePatch.Delete {
+				return nil, errors.New("/dev/null on both sides in line " + strconv.Itoa(i+1))
+			}
+			if filePatch.Path == "" {
+				return nil, errors.New("no path left after stripping " + strconv.Itoa(strip) + " components in line " + strconv.Itoa(i+1))
+			}
+			patches = append(patches, filePatch)
+			continue
+		}
+		match := hunkHeaderRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if len(patches) == 0 {
+			return nil, errors.New("hunk without file header in line " + strconv.Itoa(i+1))
+		}
+		oldCount, newCount := 1, 1
+		if match[2] != "" {
+			oldCount, _ = strconv.Atoi(match[2])
+		}
+		if match[4] != "" {
+			newCount, _ = strconv.Atoi(match[4])
+		}
+		hunk := Hunk{}
+		hunk.OldStart, _ = strconv.Atoi(match[1])
+		for len(hunk.Old) < oldCount || len(hunk.New) < newCount {
+			i++
+			if i >= len(lines) {
+				return nil, errors.New("hunk ends prematurely in " + patches[len(patches)-1].Path)
+			}
+			body := lines[i]
+			if body == "" {
+				// Some editors strip the space of empty context lines
+				body = " "
+			}
+			switch body[0] {
+			case ' ':
+				hunk.Old = append(hunk.Old, body[1:])
+				hunk.New = append(hunk.New, body[1:])
+			case '-':
+				hunk.Old = append(hunk.Old, body[1:])
+			case '+':
+				hunk.New = append(hunk.New, body[1:])
+			case '\\':
+				// \ No newline at end of file
+			default:
+				return nil, errors.New("unexpected line in hunk: " + body)
+			}
+		}
+		patches[len(patches)-1].Hunks = append(patches[len(patches)-1].Hunks, hunk)
+	}
+	if len(patches) == 0 {
+		return nil, errors.New("no changes found")
+	}
+	return patches, nil
+}
+
+// parsePath returns the path from a --- or +++ line without the timestamp that diff -u appends,
+// and without strip leading components, or if strip is negative, without the a/ or b/ prefix of git and quilt
+func parsePath(s string, strip int) string {
+	s = strings.SplitN(s, "\t", 2)[0]
+	if s == "/dev/null" {
+		return s
+	}
+	if strip < 0 {
+		if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+			s = s[2:]
+		}
+		return s
+	}
+	for i := 0; i < strip; i++ {
+		slash := strings.Index(s, "/")
+		if slash < 0 {
+			return ""
+		}
+		s = strings.TrimLeft(s[slash+1:], "/")
+	}
+	return s
+}
+
+// Apply applies the changes to the file below dir, creating or deleting it if the patch says so.
+// Hunks whose context has moved are found up to maxOffset lines away. Returns an error without
+// changing the file if a hunk does not apply, or if a file to be deleted has other contents
+func (p FilePatch) Apply(dir string) error {
+	path, err := p.pathBelow(dir)
+	if err != nil {
+		return err
+	}
+	data := []byte{}
+	mode := os.FileMode(0644)
+	if p.Create {
+		if _, err := os.Lstat(path); err == nil {
+			return errors.New(p.Path + " already exists")
+		}
+	} else {
+		data, err = ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		mode = info.Mode()
+	}
+	lines, err := p.applyToLines(strings.Split(string(data), "\n"))
+	if err != nil {
+		return errors.New(p.Path + ": " + err.Error())
+	}
+	if p.Delete {
+		if len(lines) != 1 || lines[0] != "" {
+			return errors.New(p.Path + " has more contents than the patch deletes")
+		}
+		return os.Remove(path)
+	}
+	if p.Create {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), mode)
+}
+
+// IsApplied returns true if the file below dir already contains the changes,
+// i.e., if the patch applies in reverse, or if a file to be deleted does not exist.
+// This is only meaningful if Apply fails, since a patch that only removes lines always applies in reverse
+func (p FilePatch) IsApplied(dir string) bool {
+	path, err := p.pathBelow(dir)
+	if err != nil {
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	if p.Delete {
+		return os.IsNotExist(err)
+	}
+	if err != nil {
+		return false
+	}
+	lines, err := p.reverse().applyToLines(strings.Split(string(data), "\n"))
+	if p.Create {
+		// Reversing the creation must leave nothing
+		return err == nil && len(lines) == 1 && lines[0] == ""
+	}
+	return err == nil
+}
+
+// pathBelow returns the path of the file to be changed below dir, refusing paths that lead out of dir
+func (p FilePatch) pathBelow(dir string) (string, error) {
+	if filepath.IsAbs(p.Path) || p.Path == ".." || strings.HasPrefix(filepath.Clean(p.Path), "../") {
+		return "", errors.New(p.Path + " is not below the directory the patch is applied to")
+	}
+	return filepath.Join(dir, p.Path), nil
+}
+
+func (p FilePatch) reverse() FilePatch {
+	reversed := FilePatch{Path: p.Path, Create: p.Delete, Delete: p.Create}
+	offset := 0
+	for _, hunk := range p.Hunks {
+		reversed.Hunks = append(reversed.Hunks, Hunk{OldStart: hunk.OldStart + offset, Old: hunk.New, New: hunk.Old})
+		offset += len(hunk.New) - len(hunk.Old)
+	}
+	return reversed
+}
+
+func (p FilePatch) applyToLines(lines []string) ([]string, error) {
+	offset := 0 // How much earlier hunks have moved the lines
+	for n, hunk := range p.Hunks {
+		expected := hunk.OldStart - 1 + offset
+		if len(hunk.Old) == 0 {
+			// Pure addition; the line number is that of the line after which to insert
+			expected++
+		}
+		position := findHunk(lines, hunk.Old, expected)
+		if position < 0 {
+			return nil, errors.New("hunk " + strconv.Itoa(n+1) + " does not apply")
+		}
+		var result []string
+		result = append(result, lines[:position]...)
+		result = append(result, hunk.New...)
+		result = append(result, lines[position+len(hunk.Old):]...)
+		lines = result
+		offset = position - (hunk.OldStart - 1) + len(hunk.New) - len(hunk.Old)
+		if len(hunk.Old) == 0 {
+			offset--
+		}
+	}
+	return lines, nil
+}
+
+// findHunk returns the position of old in lines closest to expected, or -1
+func findHunk(lines []string, old []string, expected int) int {
+	for distance := 0; distance <= maxOffset; distance++ {
+		for _, position := range []int{expected - distance, expected + distance} {
+			if matchesAt(lines, old, position) {
+				return position
+			}
+		}
+	}
+	return -1
+}
+
+func matchesAt(lines []string, old []string, position int) bool {
+	if position < 0 || position+len(old) > len(lines) {
+		return false
+	}
+	for i := range old {
+		if lines[position+i] != old[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package patch
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const original = `prefix=/usr
+exec_prefix=${prefix}
+libdir=/usr/lib
+includedir=/usr/include
+
+Name: foo
+Description: Foo
+Version: 1.0
+Libs: -L${libdir} -lfoo
+Cflags: -I${includedir}
+`
+
+const diff = `diff -ru a/usr/lib/pkgconfig/foo.pc b/usr/lib/pkgconfig/foo.pc
+--- a/usr/lib/pkgconfig/foo.pc	2020-10-17 20:35:11.239892062 +0000
++++ b/usr/lib/pkgconfig/foo.pc	2020-10-17 20:35:11.242429934 +0000
+@@ -1,4 +1,4 @@
+-prefix=/usr
++prefix=${pcfiledir}/../..
+ exec_prefix=${prefix}
+ libdir=/usr/lib
+ includedir=/usr/include
+@@ -6,5 +6,6 @@
+ Name: foo
+ Description: Foo
+ Version: 1.0
++URL: https://example.org
+ Libs: -L${libdir} -lfoo
+ Cflags: -I${includedir}
+`
+
+const expected = `prefix=${pcfiledir}/../..
+exec_prefix=${prefix}
+libdir=/usr/lib
+includedir=/usr/include
+
+Name: foo
+Description: Foo
+Version: 1.0
+URL: https://example.org
+Libs: -L${libdir} -lfoo
+Cflags: -I${includedir}
+`
+
+func TestApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir+"/usr/lib/pkgconfig", 0755)
+	// An extra line at the top moves all hunks
+	err = ioutil.WriteFile(dir+"/usr/lib/pkgconfig/foo.pc", []byte("# Generated\n"+original), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patches, err := Parse(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 || patches[0].Path != "usr/lib/pkgconfig/foo.pc" || len(patches[0].Hunks) != 2 {
+		t.Fatalf("Unexpected result of parsing: %+v", patches)
+	}
+	if patches[0].IsApplied(dir) {
+		t.Error("Patch is considered applied before it was applied")
+	}
+	err = patches[0].Apply(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(dir + "/usr/lib/pkgconfig/foo.pc")
+	if string(data) != "# Generated\n"+expected {
+		t.Errorf("Unexpected result of applying:\n%s", data)
+	}
+	if patches[0].IsApplied(dir) == false {
+		t.Error("Patch is not considered applied after it was applied")
+	}
+	if patches[0].Apply(dir) == nil {
+		t.Error("Patch applied twice")
+	}
+}
+
+const createAndDelete = `--- /dev/null
++++ b/etc/foo.conf
+@@ -0,0 +1,2 @@
++[foo]
++bar=1
+--- a/etc/old.conf
++++ /dev/null
+@@ -1,2 +0,0 @@
+-[old]
+-baz=2
+`
+
+func TestCreateAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/etc", 0755)
+	ioutil.WriteFile(dir+"/etc/old.conf", []byte("[old]\nbaz=2\n"), 0644)
+	patches, err := Parse(createAndDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 2 || patches[0].Create == false || patches[1].Delete == false || patches[1].Path != "etc/old.conf" {
+		t.Fatalf("Unexpected result of parsing: %+v", patches)
+	}
+	for _, p := range patches {
+		if p.IsApplied(dir) {
+			t.Error(p.Path, "is considered patched before the patch was applied")
+		}
+		if err := p.Apply(dir); err != nil {
+			t.Fatal(err)
+		}
+		if p.IsApplied(dir) == false {
+			t.Error(p.Path, "is not considered patched after the patch was applied")
+		}
+		if p.Apply(dir) == nil {
+			t.Error("Patch applied twice to", p.Path)
+		}
+	}
+	if data, err := ioutil.ReadFile(dir + "/etc/foo.conf"); err != nil || string(data) != "[foo]\nbar=1\n" {
+		t.Errorf("Unexpected contents of the created file: %q %v", data, err)
+	}
+	if _, err := os.Stat(dir + "/etc/old.conf"); os.IsNotExist(err) == false {
+		t.Error("The file was not deleted")
+	}
+
+	// A file with contents that the patch does not delete is kept
+	ioutil.WriteFile(dir+"/etc/old.conf", []byte("[old]\nbaz=2\nqux=3\n"), 0644)
+	if patches[1].Apply(dir) == nil || patches[1].IsApplied(dir) {
+		t.Error("Deleted a file with other contents")
+	}
+}
+
+func TestParseStrip(t *testing.T) {
+	patches, err := ParseStrip(strings.Replace(diff, "a/usr/lib/", "foo-1.0/usr/lib/", 1), 2)
+	if err != nil || patches[0].Path != "lib/pkgconfig/foo.pc" {
+		t.Errorf("Unexpected result of parsing with -p2: %+v %v", patches, err)
+	}
+	patches, err = ParseStrip(diff, 0)
+	if err != nil || patches[0].Path != "b/usr/lib/pkgconfig/foo.pc" {
+		t.Errorf("Unexpected result of parsing with -p0: %+v %v", patches, err)
+	}
+	if _, err := ParseStrip(diff, 9); err == nil {
+		t.Error("Accepted a path without components left")
+	}
+	patches[0].Path = "../foo.pc"
+	if patches[0].Apply(t.TempDir()) == nil {
+		t.Error("Applied a patch to a file outside of the directory")
+	}
+}
@@ -0,0 +1,225 @@
+package pkgrepo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// decompress returns a reader that decompresses r, recognizing gzip, bzip2, xz and zstd by their magic numbers.
+// Data that is not compressed in any of these formats is returned as it is
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return ioutil.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		xr, err := xz.NewReader(br)
+		return ioutil.NopCloser(xr), err
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// Longest path of a symlink target and of a file name in a cpio archive; longer ones are rejected
+// rather than allocated, since the sizes come from the archive
+const maxPathLength = 4096
+
+// safeJoin returns the path of name in dir, or an error if name points outside of dir
+func safeJoin(dir string, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if isInside(filepath.Clean(dir), path) == false {
+		return "", errors.New(name + " points outside of the directory it is extracted to")
+	}
+	return path, nil
+}
+
+// isInside returns true if path is dir or inside of it
+func isInside(dir string, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// resolveInside returns the path of name in dir with the symlinks in its existing parent directories resolved,
+// or an error if name or one of these symlinks points outside of dir. Earlier entries of the same archive
+// may have created such symlinks, e.g., usr/x -> /home/user, and usr/x/.bashrc must not be written through them
+func resolveInside(dir string, name string) (string, error) {
+	dir = filepath.Clean(dir)
+	path, err := safeJoin(dir, name)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	// Directories that do not exist yet are created by extractEntry and cannot be symlinks
+	existing := filepath.Dir(path)
+	for existing != dir && isInside(dir, existing) {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if isInside(root, resolved) == false {
+		return "", errors.New(name + " is inside of a symlink that points outside of the directory it is extracted to")
+	}
+	return resolved + strings.TrimPrefix(path, existing), nil
+}
+
+// extractTar extracts the tar archive r into dir. Existing files are replaced,
+// since packages share directories and may replace each other's symlinks
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = extractEntry(dir, header.Name, mode|os.ModeDir, "", nil)
+		case tar.TypeReg:
+			err = extractEntry(dir, header.Name, mode, "", tr)
+		case tar.TypeSymlink:
+			err = extractEntry(dir, header.Name, os.ModeSymlink, header.Linkname, nil)
+		case tar.TypeLink:
+			var target string
+			target, err = resolveInside(dir, header.Linkname)
+			if err == nil {
+				err = extractEntry(dir, header.Name, 0, target, nil)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractCpio extracts the cpio archive r in the newc format, as used in .rpm files, into dir
+func extractCpio(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 110)
+	offset := int64(0)
+	skip := func(n int64) error {
+		_, err := io.CopyN(ioutil.Discard, br, n)
+		offset += n
+		return err
+	}
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return err
+		}
+		offset += 110
+		if string(header[:6]) != "070701" && string(header[:6]) != "070702" {
+			return errors.New("invalid cpio header")
+		}
+		field := func(i int) int64 {
+			v, _ := strconv.ParseInt(string(header[6+i*8:14+i*8]), 16, 64)
+			return v
+		}
+		mode, size, nameSize := field(1), field(6), field(11)
+		if nameSize > maxPathLength {
+			return errors.New("invalid cpio header: name too long")
+		}
+		name := make([]byte, nameSize)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return err
+		}
+		offset += nameSize
+		if err := skip((4 - offset%4) % 4); err != nil {
+			return err
+		}
+		path := strings.TrimSuffix(string(name), "\x00")
+		if path == "TRAILER!!!" {
+			return nil
+		}
+		perm := os.FileMode(mode).Perm()
+		var err error
+		switch mode & 0170000 {
+		case 0040000:
+			err = extractEntry(dir, path, perm|os.ModeDir, "", nil)
+		case 0100000:
+			err = extractEntry(dir, path, perm, "", io.LimitReader(br, size))
+		case 0120000:
+			if size > maxPathLength {
+				return errors.New("the target of the symlink " + path + " is too long")
+			}
+			target := make([]byte, size)
+			_, err = io.ReadFull(br, target)
+			if err == nil {
+				err = extractEntry(dir, path, os.ModeSymlink, string(target), nil)
+			}
+		default:
+			_, err = io.CopyN(ioutil.Discard, br, size)
+		}
+		if err != nil {
+			return err
+		}
+		offset += size
+		if err := skip((4 - offset%4) % 4); err != nil {
+			return err
+		}
+	}
+}
+
+// extractEntry creates the directory, file, symlink or hard link (if link is given with mode 0)
+// name in dir, reading the contents of a file from r. Never writes through symlinks pointing outside of dir
+func extractEntry(dir string, name string, mode os.FileMode, link string, r io.Reader) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	path, err := resolveInside(dir, name)
+	if err != nil {
+		return err
+	}
+	if mode.IsDir() {
+		return os.MkdirAll(path, mode.Perm()|0700)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	os.Remove(path)
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return os.Symlink(link, path)
+	case link != "":
+		return os.Link(link, path)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0200)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	f.Close()
+	return err
+}
@@ -0,0 +1,428 @@
+package pkgrepo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// DebSource is a repository as given in a line of /etc/apt/sources.list,
+// e.g., "deb http://archive.ubuntu.com/ubuntu jammy main universe"
+type DebSource struct {
+	URI        string
+	Suite      string   // e.g., jammy; a path ending in a slash for flat repositories
+	Components []string // e.g., main, universe; none for flat repositories
+	SignedBy   []string // Keyrings given in the signed-by option
+	// Keyring holds the keys the InRelease file of the repository must be signed with.
+	// Without keys, only https repositories are accepted
+	Keyring openpgp.EntityList
+}
+
+// ParseDebSource parses a line of /etc/apt/sources.list. Of the options in brackets, only signed-by is used
+func ParseDebSource(line string) (DebSource, error) {
+	var source DebSource
+	fields := strings.Fields(line)
+	if len(fields) > 0 && fields[0] == "deb" {
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "[") {
+		for len(fields) > 0 {
+			field := fields[0]
+			fields = fields[1:]
+			option := strings.Trim(field, "[]")
+			if strings.HasPrefix(option, "signed-by=") {
+				// Fingerprints are not supported, only paths of keyrings
+				for _, keyring := range strings.Split(strings.TrimPrefix(option, "signed-by="), ",") {
+					if strings.HasPrefix(keyring, "/") {
+						source.SignedBy = append(source.SignedBy, keyring)
+					}
+				}
+			}
+			if strings.HasSuffix(field, "]") {
+				break
+			}
+		}
+	}
+	if len(fields) < 2 || (strings.HasSuffix(fields[1], "/") == false && len(fields) < 3) {
+		return source, errors.New("invalid deb source " + line + ", expected deb URI SUITE COMPONENT...")
+	}
+	source.URI = strings.TrimSuffix(fields[0], "/")
+	source.Suite = fields[1]
+	source.Components = fields[2:]
+	return source, nil
+}
+
+// Fetch downloads the index of the packages for the architecture arch (e.g., amd64) and those for all
+// architectures from the repository. If the source has a keyring, the index must be listed with its digest
+// in the InRelease file of the repository, which must be signed with one of its keys. Otherwise, the
+// repository must be accessed using https
+func (source DebSource) Fetch(client *http.Client, arch string) ([]*Package, error) {
+	releaseURL := source.URI + "/dists/" + source.Suite + "/"
+	var paths []string
+	if len(source.Components) == 0 {
+		releaseURL = source.URI + "/" + source.Suite
+		paths = append(paths, "Packages.gz")
+	}
+	for _, component := range source.Components {
+		paths = append(paths, component+"/binary-"+arch+"/Packages.gz")
+	}
+	var digests map[string]string
+	if len(source.Keyring) > 0 {
+		var err error
+		digests, err = fetchDebRelease(client, releaseURL+"InRelease", source.Keyring)
+		if err != nil {
+			return nil, err
+		}
+	} else if strings.HasPrefix(source.URI, "https://") == false {
+		return nil, errors.New("cannot verify " + source.URI + " without a keyring, use https or add its key to the keyrings of apt")
+	}
+	var packages []*Package
+	for _, path := range paths {
+		url := releaseURL + path
+		data, err := fetch(client, url)
+		if err != nil {
+			return nil, err
+		}
+		if digests != nil {
+			sum := sha256.Sum256(data)
+			if digests[path] == "" {
+				return nil, errors.New(url + " is not listed in the InRelease file")
+			}
+			if hex.EncodeToString(sum[:]) != digests[path] {
+				return nil, errors.New(url + " does not match the digest in the InRelease file")
+			}
+		}
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.New(url + ": " + err.Error())
+		}
+		found, err := ParseDebPackages(r, source.URI, arch)
+		if err != nil {
+			return nil, errors.New(url + ": " + err.Error())
+		}
+		packages = append(packages, found...)
+	}
+	return packages, nil
+}
+
+// fetchDebRelease downloads the clearsigned InRelease file at url, checks its signature against keyring,
+// and returns the SHA256 digests of the indexes it lists by their paths relative to it
+func fetchDebRelease(client *http.Client, url string, keyring openpgp.EntityList) (map[string]string, error) {
+	data, err := fetch(client, url)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, errors.New(url + " is not signed")
+	}
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+		return nil, errors.New(url + ": " + err.Error())
+	}
+	digests := make(map[string]string)
+	inSHA256 := false
+	scanner := bufio.NewScanner(bytes.NewReader(block.Plaintext))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, " ") == false {
+			inSHA256 = strings.TrimSpace(line) == "SHA256:"
+			continue
+		}
+		if fields := strings.Fields(line); inSHA256 && len(fields) == 3 {
+			digests[fields[2]] = strings.ToLower(fields[0])
+		}
+	}
+	return digests, scanner.Err()
+}
+
+// ReadKeyring reads the OpenPGP keys in the files at paths, which are either binary or,
+// if their names end in .asc, ASCII-armored, such as those in /etc/apt/trusted.gpg.d
+func ReadKeyring(paths []string) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		var keys openpgp.EntityList
+		if strings.HasSuffix(path, ".asc") {
+			keys, err = openpgp.ReadArmoredKeyRing(f)
+		} else {
+			keys, err = openpgp.ReadKeyRing(f)
+		}
+		f.Close()
+		if err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+		keyring = append(keyring, keys...)
+	}
+	return keyring, nil
+}
+
+// ParseDebPackages parses a Packages index of a repository at uri,
+// keeping the packages for the architecture arch and those for all architectures
+func ParseDebPackages(r io.Reader, uri string, arch string) ([]*Package, error) {
+	stanzas, err := parseDebStanzas(r)
+	if err != nil {
+		return nil, err
+	}
+	var packages []*Package
+	for _, stanza := range stanzas {
+		if stanza["Architecture"] == arch || stanza["Architecture"] == "all" {
+			p := debPackageFromStanza(stanza)
+			p.URL = strings.TrimSuffix(uri, "/") + "/" + stanza["Filename"]
+			packages = append(packages, p)
+		}
+	}
+	return packages, nil
+}
+
+// parseDebStanzas parses paragraphs of fields in the format of Debian control files,
+// keeping those that describe a package
+func parseDebStanzas(r io.Reader) ([]map[string]string, error) {
+	var stanzas []map[string]string
+	stanza := make(map[string]string)
+	key := ""
+	flush := func() {
+		if stanza["Package"] != "" {
+			stanzas = append(stanzas, stanza)
+		}
+		stanza = make(map[string]string)
+		key = ""
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			// Continuation of a multi-line field such as Description
+			if key != "" {
+				stanza[key] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid line " + line)
+		}
+		key = parts[0]
+		stanza[key] = strings.TrimSpace(parts[1])
+	}
+	flush()
+	return stanzas, scanner.Err()
+}
+
+// debPackageFromStanza returns the package described by the fields of a stanza
+func debPackageFromStanza(stanza map[string]string) *Package {
+	p := &Package{
+		Name:      stanza["Package"],
+		Version:   stanza["Version"],
+		Arch:      stanza["Architecture"],
+		Format:    FormatDeb,
+		SHA256:    stanza["SHA256"],
+		Essential: stanza["Essential"] == "yes" || stanza["Priority"] == "required",
+	}
+	p.Depends = append(parseDebRelations(stanza["Pre-Depends"]), parseDebRelations(stanza["Depends"])...)
+	for _, alternatives := range parseDebRelations(stanza["Provides"]) {
+		p.Provides = append(p.Provides, alternatives...)
+	}
+	return p
+}
+
+// parseDebRelations parses a field such as Depends, e.g., "libc6 (>= 2.34), libfoo1 | libbar1, perl:any",
+// into lists of alternatives, dropping versions and architecture qualifiers
+func parseDebRelations(field string) [][]string {
+	var relations [][]string
+	for _, relation := range strings.Split(field, ",") {
+		var alternatives []string
+		for _, alternative := range strings.Split(relation, "|") {
+			name := strings.TrimSpace(alternative)
+			if i := strings.IndexAny(name, " ([<"); i >= 0 {
+				name = name[:i]
+			}
+			name = strings.SplitN(name, ":", 2)[0]
+			if name != "" {
+				alternatives = append(alternatives, name)
+			}
+		}
+		if len(alternatives) > 0 {
+			relations = append(relations, alternatives)
+		}
+	}
+	return relations
+}
+
+// CompareDebVersions compares two Debian package versions like dpkg does,
+// returning a negative number if a is older than b, 0 if they are equal, and a positive number otherwise
+func CompareDebVersions(a string, b string) int {
+	epochA, upstreamA, revisionA := splitDebVersion(a)
+	epochB, upstreamB, revisionB := splitDebVersion(b)
+	if epochA != epochB {
+		return epochA - epochB
+	}
+	if c := compareDebVersionPart(upstreamA, upstreamB); c != 0 {
+		return c
+	}
+	return compareDebVersionPart(revisionA, revisionB)
+}
+
+// splitDebVersion splits a version into epoch, upstream version, and Debian revision
+func splitDebVersion(version string) (int, string, string) {
+	epoch := 0
+	if i := strings.Index(version, ":"); i >= 0 {
+		epoch, _ = strconv.Atoi(version[:i])
+		version = version[i+1:]
+	}
+	revision := ""
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		revision = version[i+1:]
+		version = version[:i]
+	}
+	return epoch, version, revision
+}
+
+// compareDebVersionPart compares alternating non-digit and digit parts, in which
+// letters sort before other characters and ~ sorts before everything, even the end
+func compareDebVersionPart(a string, b string) int {
+	order := func(c byte) int {
+		switch {
+		case c == '~':
+			return -1
+		case c >= '0' && c <= '9':
+			return 0
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			return int(c)
+		default:
+			return int(c) + 256
+		}
+	}
+	for a != "" || b != "" {
+		for (a != "" && (a[0] < '0' || a[0] > '9')) || (b != "" && (b[0] < '0' || b[0] > '9')) {
+			ca, cb := 0, 0
+			if a != "" {
+				ca = order(a[0])
+			}
+			if b != "" {
+				cb = order(b[0])
+			}
+			if ca != cb {
+				return ca - cb
+			}
+			a, b = a[1:], b[1:]
+		}
+		na, nb := 0, 0
+		for a != "" && a[0] >= '0' && a[0] <= '9' {
+			na = na*10 + int(a[0]-'0')
+			a = a[1:]
+		}
+		for b != "" && b[0] >= '0' && b[0] <= '9' {
+			nb = nb*10 + int(b[0]-'0')
+			b = b[1:]
+		}
+		if na != nb {
+			return na - nb
+		}
+	}
+	return 0
+}
+
+// ReadDeb returns the package described by the control file of the .deb at path,
+// which is used from there rather than downloaded
+func ReadDeb(path string) (*Package, error) {
+	var p *Package
+	err := readDebMember(path, "control.tar", func(r io.Reader) error {
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return errors.New("no control file")
+			}
+			if err != nil {
+				return err
+			}
+			if strings.TrimPrefix(header.Name, "./") != "control" {
+				continue
+			}
+			stanzas, err := parseDebStanzas(tr)
+			if err != nil {
+				return err
+			}
+			if len(stanzas) != 1 {
+				return errors.New("invalid control file")
+			}
+			p = debPackageFromStanza(stanzas[0])
+			p.Path = path
+			return nil
+		}
+	})
+	return p, err
+}
+
+// ExtractDeb extracts the files of the .deb at path into dir
+func ExtractDeb(path string, dir string) error {
+	return readDebMember(path, "data.tar", func(r io.Reader) error {
+		return extractTar(r, dir)
+	})
+}
+
+// readDebMember calls fn with the decompressed contents of the member of the .deb at path whose name
+// starts with prefix. A .deb is an ar archive with the members control.tar and data.tar, which may be compressed
+func readDebMember(path string, prefix string, fn func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "!<arch>\n" {
+		return errors.New(path + " is not a .deb")
+	}
+	header := make([]byte, 60)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return errors.New(path + " has no " + prefix)
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return errors.New(path + " has an invalid ar header")
+		}
+		if strings.HasPrefix(name, prefix) {
+			member, err := decompress(io.LimitReader(r, size))
+			if err != nil {
+				return errors.New(path + ": " + err.Error())
+			}
+			defer member.Close()
+			if err := fn(member); err != nil {
+				return errors.New(path + ": " + err.Error())
+			}
+			return nil
+		}
+		// Members are padded to an even size
+		if _, err := io.CopyN(ioutil.Discard, r, size+size%2); err != nil {
+			return err
+		}
+	}
+}
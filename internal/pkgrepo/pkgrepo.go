@@ -0,0 +1,203 @@
+// Package pkgrepo downloads binary packages of Linux distributions together with their dependencies
+// from deb and rpm repositories, and extracts them without needing dpkg or rpm
+package pkgrepo
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/download"
+)
+
+// Package formats
+const (
+	FormatDeb = "deb"
+	FormatRpm = "rpm"
+)
+
+// Package is a binary package in a repository
+type Package struct {
+	Name      string
+	Version   string
+	Arch      string
+	Format    string     // FormatDeb or FormatRpm
+	URL       string     // Where the package is downloaded from
+	Path      string     // Local file of the package, which is used rather than downloading it
+	SHA256    string     // Of the package file, as given in the repository index
+	Depends   [][]string // Each entry lists alternatives, one of which is needed; versions are not taken into account
+	Provides  []string   // Virtual packages, capabilities and files that the package provides
+	Essential bool       // Part of every installation of the distribution, hence never bundled
+}
+
+// Index contains the packages of one or more repositories of the same format
+type Index struct {
+	packages map[string][]*Package // Key: name
+	provides map[string][]*Package // Key: something a package provides
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{packages: make(map[string][]*Package), provides: make(map[string][]*Package)}
+}
+
+// Add adds packages to the index
+func (idx *Index) Add(packages ...*Package) {
+	for _, p := range packages {
+		idx.packages[p.Name] = append(idx.packages[p.Name], p)
+		for _, name := range p.Provides {
+			idx.provides[name] = append(idx.provides[name], p)
+		}
+	}
+}
+
+// Len returns the number of packages in the index
+func (idx *Index) Len() int {
+	n := 0
+	for _, packages := range idx.packages {
+		n += len(packages)
+	}
+	return n
+}
+
+// Find returns the newest package with the given name or, if there is none,
+// the newest one providing it. Returns nil if there is neither.
+// Local packages are preferred over those in repositories regardless of their versions
+func (idx *Index) Find(name string) *Package {
+	if p := newest(idx.packages[name]); p != nil {
+		return p
+	}
+	return newest(idx.provides[name])
+}
+
+// newest returns the local package with the highest version or, if there is none,
+// the package with the highest version. Returns nil if there are no packages
+func newest(packages []*Package) *Package {
+	var best *Package
+	for _, p := range packages {
+		isLocal, bestIsLocal := p.Path != "", best != nil && best.Path != ""
+		if best == nil || (isLocal && bestIsLocal == false) ||
+			(isLocal == bestIsLocal && compareVersions(p.Format, p.Version, best.Version) > 0) {
+			best = p
+		}
+	}
+	return best
+}
+
+// compareVersions compares two versions according to the rules of the package format
+func compareVersions(format string, a string, b string) int {
+	if format == FormatRpm {
+		return CompareRpmVersions(a, b)
+	}
+	return CompareDebVersions(a, b)
+}
+
+// Resolve returns the packages with the given names together with all packages they depend on,
+// each only once, in the order in which they were found. Packages for which excluded returns true
+// are left out together with their dependencies, and so are essential packages, which are part of
+// every installation of the distribution. Returns the dependencies that could not be found, and an
+// error if one of the given packages cannot be found
+func (idx *Index) Resolve(names []string, excluded func(name string) bool) ([]*Package, []string, error) {
+	var resolved []*Package
+	var missing []string
+	selected := make(map[string]bool) // Key: name of a package or something it provides
+	isSatisfied := func(name string) bool {
+		return selected[name] || excluded(name)
+	}
+	var todo []*Package
+	add := func(p *Package) {
+		resolved = append(resolved, p)
+		todo = append(todo, p)
+		selected[p.Name] = true
+		for _, name := range p.Provides {
+			selected[name] = true
+		}
+	}
+	for _, name := range names {
+		p := idx.Find(name)
+		if p == nil {
+			return nil, nil, errors.New("package " + name + " not found")
+		}
+		if selected[p.Name] == false {
+			add(p)
+		}
+	}
+	for len(todo) > 0 {
+		p := todo[0]
+		todo = todo[1:]
+	dependencies:
+		for _, alternatives := range p.Depends {
+			for _, name := range alternatives {
+				if isSatisfied(name) {
+					continue dependencies
+				}
+				if found := idx.Find(name); found != nil && (found.Essential || excluded(found.Name)) {
+					continue dependencies
+				}
+			}
+			for _, name := range alternatives {
+				if found := idx.Find(name); found != nil {
+					if selected[found.Name] == false {
+						add(found)
+					}
+					selected[name] = true
+					continue dependencies
+				}
+			}
+			missing = appendIfMissing(missing, strings.Join(alternatives, " | "))
+		}
+	}
+	sort.Strings(missing)
+	return resolved, missing, nil
+}
+
+// Download returns the path of the package file in the cache, downloading it if needed
+// and checking it against the SHA-256 digest in the repository index.
+// Returns the path of local packages as it is
+func Download(cache *download.Cache, p *Package) (string, error) {
+	if p.Path != "" {
+		return p.Path, nil
+	}
+	return cache.Fetch(p.URL, p.SHA256)
+}
+
+// Extract extracts the files of the package file at path, a .deb or an .rpm, into dir
+func Extract(path string, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, 8)
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err == nil && string(magic) == "!<arch>\n" {
+		return ExtractDeb(path, dir)
+	}
+	return ExtractRpm(path, dir)
+}
+
+// fetch returns the contents of url
+func fetch(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(url + ": " + resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func appendIfMissing(slice []string, s string) []string {
+	for _, e := range slice {
+		if e == s {
+			return slice
+		}
+	}
+	return append(slice, s)
+}
@@ -0,0 +1,360 @@
+package pkgrepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		format string
+		a, b   string
+		sign   int
+	}{
+		{FormatDeb, "1.0", "1.0", 0},
+		{FormatDeb, "1.0~rc1", "1.0", -1},
+		{FormatDeb, "1.0+b1", "1.0", 1},
+		{FormatDeb, "1.0a", "1.0", 1},
+		{FormatDeb, "1.0-10", "1.0-2", 1},
+		{FormatDeb, "1:0.9", "2.0", 1},
+		{FormatRpm, "1.0-1", "1.0-1", 0},
+		{FormatRpm, "1.0~rc1-1", "1.0-1", -1},
+		{FormatRpm, "1.0^git1-1", "1.0-1", 1},
+		{FormatRpm, "1.0^git1-1", "1.0.1-1", -1},
+		{FormatRpm, "1.10-1", "1.9-1", 1},
+		{FormatRpm, "1.0a-1", "1.0.1-1", -1},
+		{FormatRpm, "2:1.0-1", "1:2.0-1", 1},
+	} {
+		got := compareVersions(c.format, c.a, c.b)
+		if (got < 0 && c.sign >= 0) || (got > 0 && c.sign <= 0) || (got == 0 && c.sign != 0) {
+			t.Errorf("Comparing %s versions %s and %s gave %d", c.format, c.a, c.b, got)
+		}
+	}
+}
+
+const packagesIndex = `Package: foo
+Version: 1.0-1
+Architecture: amd64
+Depends: libc6 (>= 2.34), libbar1 | libbar-alt1, www-browser, libmissing2
+Filename: pool/main/f/foo/foo_1.0-1_amd64.deb
+SHA256: 0123
+Description: Foo
+ Multi-line description
+
+Package: foo
+Version: 1.0~rc1-1
+Architecture: amd64
+Filename: pool/main/f/foo/foo_1.0~rc1-1_amd64.deb
+
+Package: libc6
+Version: 2.35
+Architecture: amd64
+Priority: required
+Filename: pool/main/g/glibc/libc6_2.35_amd64.deb
+
+Package: libbar1
+Version: 2
+Architecture: amd64
+Depends: libc6
+Filename: pool/main/b/bar/libbar1_2_amd64.deb
+
+Package: lynx
+Version: 2.9
+Architecture: all
+Provides: www-browser
+Filename: pool/main/l/lynx/lynx_2.9_all.deb
+
+Package: armonly
+Version: 1
+Architecture: armhf
+Filename: pool/main/a/armonly/armonly_1_armhf.deb
+`
+
+func TestResolveDebPackages(t *testing.T) {
+	packages, err := ParseDebPackages(strings.NewReader(packagesIndex), "https://example.org/debian/", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := NewIndex()
+	idx.Add(packages...)
+	if idx.Len() != 5 {
+		t.Errorf("Expected 5 packages for amd64, got %d", idx.Len())
+	}
+	resolved, missing, err := idx.Resolve([]string{"foo"}, func(name string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range resolved {
+		names = append(names, p.Name+"="+p.Version)
+	}
+	if strings.Join(names, " ") != "foo=1.0-1 libbar1=2 lynx=2.9" {
+		t.Error("Resolved", names)
+	}
+	if resolved[0].URL != "https://example.org/debian/pool/main/f/foo/foo_1.0-1_amd64.deb" || resolved[0].SHA256 != "0123" {
+		t.Error("Unexpected URL or digest", resolved[0].URL, resolved[0].SHA256)
+	}
+	if strings.Join(missing, ",") != "libmissing2" {
+		t.Error("Expected libmissing2 to be missing, got", missing)
+	}
+	resolved, _, _ = idx.Resolve([]string{"foo"}, func(name string) bool { return name == "libbar1" || name == "www-browser" })
+	if len(resolved) != 1 {
+		t.Error("Excluded packages were resolved:", resolved)
+	}
+}
+
+func TestParseDebSource(t *testing.T) {
+	source, err := ParseDebSource("deb [arch=amd64 signed-by=/usr/share/keyrings/foo.gpg] http://archive.ubuntu.com/ubuntu/ jammy main universe")
+	if err != nil || source.URI != "http://archive.ubuntu.com/ubuntu" || source.Suite != "jammy" || strings.Join(source.Components, " ") != "main universe" {
+		t.Error("Parsed", source, err)
+	}
+	if _, err := ParseDebSource("deb http://example.org/ jammy"); err == nil {
+		t.Error("Accepted a source without components")
+	}
+	if source, err := ParseDebSource("deb https://example.org/repo ./"); err != nil || len(source.Components) != 0 {
+		t.Error("Did not accept a flat repository", err)
+	}
+	if strings.Join(source.SignedBy, ",") != "/usr/share/keyrings/foo.gpg" {
+		t.Error("Expected the keyring of signed-by, got", source.SignedBy)
+	}
+}
+
+func TestFetchSignedDebSource(t *testing.T) {
+	key, err := openpgp.NewEntity("Test", "", "test@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("Other", "", "other@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index bytes.Buffer
+	gw := gzip.NewWriter(&index)
+	gw.Write([]byte(packagesIndex))
+	gw.Close()
+	sum := sha256.Sum256(index.Bytes())
+	release := func(digest string) []byte {
+		var buf bytes.Buffer
+		w, err := clearsign.Encode(&buf, key.PrivateKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(w, "Suite: jammy\nSHA256:\n %s %d main/binary-amd64/Packages.gz\n", digest, index.Len())
+		w.Close()
+		return buf.Bytes()
+	}
+	inRelease := release(hex.EncodeToString(sum[:]))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ubuntu/dists/jammy/InRelease":
+			w.Write(inRelease)
+		case "/ubuntu/dists/jammy/main/binary-amd64/Packages.gz":
+			w.Write(index.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	source, err := ParseDebSource("deb " + server.URL + "/ubuntu jammy main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Fetch(server.Client(), "amd64"); err == nil {
+		t.Error("Accepted a repository without a keyring over http")
+	}
+	source.Keyring = openpgp.EntityList{other}
+	if _, err := source.Fetch(server.Client(), "amd64"); err == nil {
+		t.Error("Accepted an InRelease file signed with an unknown key")
+	}
+	source.Keyring = openpgp.EntityList{key}
+	if packages, err := source.Fetch(server.Client(), "amd64"); err != nil || len(packages) != 5 {
+		t.Error("Expected 5 packages, got", len(packages), err)
+	}
+	inRelease = release(strings.Repeat("0", 64))
+	if _, err := source.Fetch(server.Client(), "amd64"); err == nil {
+		t.Error("Accepted an index that does not match the digest in the InRelease file")
+	}
+}
+
+// tarGz returns a gzip-compressed tar archive with a file, a symlink, and a file outside of the archive if evil
+func tarGz(evil bool) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "./usr/bin/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "./usr/bin/foo", Typeflag: tar.TypeReg, Mode: 0755, Size: 3})
+	tw.Write([]byte("foo"))
+	tw.WriteHeader(&tar.Header{Name: "./usr/bin/bar", Typeflag: tar.TypeSymlink, Linkname: "foo"})
+	if evil {
+		tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644})
+	}
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+// controlTarGz returns a gzip-compressed tar archive with the control file of a .deb
+func controlTarGz(control string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "./control", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(control))})
+	tw.Write([]byte(control))
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+func writeDeb(t *testing.T, data []byte) string {
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{{"debian-binary", []byte("2.0\n")}, {"control.tar.gz", controlTarGz("Package: foo\nVersion: 1.0-1\nArchitecture: amd64\nDepends: libbar1 (>= 2)\nDescription: Foo\n Does foo\n")}, {"data.tar.gz", data}} {
+		fmt.Fprintf(&buf, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", member.name, "0", "0", "0", "100644", len(member.data))
+		buf.Write(member.data)
+		if len(member.data)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	path := t.TempDir() + "/foo.deb"
+	ioutil.WriteFile(path, buf.Bytes(), 0644)
+	return path
+}
+
+func checkExtracted(t *testing.T, dir string) {
+	data, err := ioutil.ReadFile(dir + "/usr/bin/foo")
+	if err != nil || string(data) != "foo" {
+		t.Error("usr/bin/foo was not extracted:", err)
+	}
+	if info, err := os.Stat(dir + "/usr/bin/foo"); err != nil || info.Mode().Perm() != 0755 {
+		t.Error("usr/bin/foo does not have its permissions")
+	}
+	if target, err := os.Readlink(dir + "/usr/bin/bar"); err != nil || target != "foo" {
+		t.Error("usr/bin/bar is not a symlink to foo:", err)
+	}
+}
+
+func TestExtractDeb(t *testing.T) {
+	dir := t.TempDir() + "/AppDir"
+	err := ExtractDeb(writeDeb(t, tarGz(false)), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExtracted(t, dir)
+	if ExtractDeb(writeDeb(t, tarGz(true)), t.TempDir()+"/AppDir") == nil {
+		t.Error("Extracted a file outside of the directory")
+	}
+}
+
+func TestExtractThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+	for _, typeflag := range []byte{tar.TypeReg, tar.TypeLink} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "./usr/lib/", Typeflag: tar.TypeDir, Mode: 0755})
+		tw.WriteHeader(&tar.Header{Name: "./usr/lib64", Typeflag: tar.TypeSymlink, Linkname: "lib"})
+		tw.WriteHeader(&tar.Header{Name: "./usr/lib64/libfoo.so", Typeflag: tar.TypeReg, Mode: 0644})
+		tw.WriteHeader(&tar.Header{Name: "./usr/x", Typeflag: tar.TypeSymlink, Linkname: outside})
+		if typeflag == tar.TypeLink {
+			ioutil.WriteFile(outside+"/secret", []byte("secret"), 0644)
+			tw.WriteHeader(&tar.Header{Name: "./usr/secret", Typeflag: tar.TypeLink, Linkname: "./usr/x/secret"})
+		} else {
+			tw.WriteHeader(&tar.Header{Name: "./usr/x/.bashrc", Typeflag: tar.TypeReg, Mode: 0644})
+		}
+		tw.Close()
+		dir := t.TempDir() + "/AppDir"
+		if err := extractTar(&buf, dir); err == nil {
+			t.Error("Extracted through a symlink pointing outside of the directory")
+		}
+		if _, err := os.Lstat(outside + "/.bashrc"); err == nil {
+			t.Error("Wrote a file outside of the directory")
+		}
+		if _, err := os.Lstat(dir + "/usr/secret"); err == nil {
+			t.Error("Linked a file outside of the directory")
+		}
+		// Symlinks within the directory are fine
+		if _, err := os.Lstat(dir + "/usr/lib/libfoo.so"); err != nil {
+			t.Error("Did not extract through a symlink within the directory:", err)
+		}
+	}
+}
+
+func TestReadDeb(t *testing.T) {
+	path := writeDeb(t, tarGz(false))
+	p, err := ReadDeb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "foo" || p.Version != "1.0-1" || p.Path != path || len(p.Depends) != 1 || p.Depends[0][0] != "libbar1" {
+		t.Error("Read", p)
+	}
+	// Local packages are preferred over newer ones in repositories
+	idx := NewIndex()
+	idx.Add(&Package{Name: "foo", Version: "2.0-1", Format: FormatDeb}, p)
+	if found := idx.Find("foo"); found != p {
+		t.Error("Found", found, "instead of the local package")
+	}
+	if file, err := Download(nil, p); err != nil || file != path {
+		t.Error("Downloaded a local package:", file, err)
+	}
+}
+
+func TestExtractRpm(t *testing.T) {
+	var cpio bytes.Buffer
+	entry := func(name string, mode int64, data string) {
+		fmt.Fprintf(&cpio, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			0, mode, 0, 0, 1, 0, len(data), 0, 0, 0, 0, len(name)+1, 0)
+		cpio.WriteString(name + "\x00")
+		for cpio.Len()%4 != 0 {
+			cpio.WriteByte(0)
+		}
+		cpio.WriteString(data)
+		for cpio.Len()%4 != 0 {
+			cpio.WriteByte(0)
+		}
+	}
+	entry("./usr/bin", 0040755, "")
+	entry("./usr/bin/foo", 0100755, "foo")
+	entry("./usr/bin/bar", 0120777, "foo")
+	entry("TRAILER!!!", 0, "")
+
+	var rpm bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb})
+	rpm.Write(lead)
+	// Signature header with one entry of 4 bytes, padded to a multiple of 8, and an empty header
+	rpm.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&rpm, binary.BigEndian, []uint32{1, 4})
+	rpm.Write(make([]byte, 16+4+4))
+	rpm.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&rpm, binary.BigEndian, []uint32{0, 0})
+	gw := gzip.NewWriter(&rpm)
+	gw.Write(cpio.Bytes())
+	gw.Close()
+
+	path := t.TempDir() + "/foo.rpm"
+	ioutil.WriteFile(path, rpm.Bytes(), 0644)
+	dir := t.TempDir() + "/AppDir"
+	err := Extract(path, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExtracted(t, dir)
+}
@@ -0,0 +1,286 @@
+package pkgrepo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// RpmSource is an rpm-md repository, e.g., "https://dl.fedoraproject.org/pub/fedora/linux/releases/39/Everything/x86_64/os"
+type RpmSource struct {
+	BaseURL string
+}
+
+// repomd is the part of repodata/repomd.xml that says where the index of the packages is
+type repomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+// rpmPrimary is the part of the primary index of an rpm-md repository that is needed
+type rpmPrimary struct {
+	Packages []struct {
+		Name    string `xml:"name"`
+		Arch    string `xml:"arch"`
+		Version struct {
+			Epoch string `xml:"epoch,attr"`
+			Ver   string `xml:"ver,attr"`
+			Rel   string `xml:"rel,attr"`
+		} `xml:"version"`
+		Checksum struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"checksum"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+		Provides []rpmEntry `xml:"format>provides>entry"`
+		Requires []rpmEntry `xml:"format>requires>entry"`
+		Files    []string   `xml:"format>file"`
+	} `xml:"package"`
+}
+
+type rpmEntry struct {
+	Name string `xml:"name,attr"`
+}
+
+// Fetch downloads the index of the packages for the architecture arch (e.g., x86_64) and those for all
+// architectures from the repository. Since the index is not checked against the signature of the repository,
+// the repository must be accessed using https
+func (source RpmSource) Fetch(client *http.Client, arch string) ([]*Package, error) {
+	base := strings.TrimSuffix(source.BaseURL, "/")
+	if strings.HasPrefix(base, "https://") == false {
+		return nil, errors.New("cannot verify " + base + ", use https")
+	}
+	data, err := fetch(client, base+"/repodata/repomd.xml")
+	if err != nil {
+		return nil, err
+	}
+	var md repomd
+	err = xml.Unmarshal(data, &md)
+	if err != nil {
+		return nil, errors.New(base + "/repodata/repomd.xml: " + err.Error())
+	}
+	for _, d := range md.Data {
+		if d.Type != "primary" {
+			continue
+		}
+		url := base + "/" + d.Location.Href
+		data, err := fetch(client, url)
+		if err != nil {
+			return nil, err
+		}
+		r, err := decompress(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.New(url + ": " + err.Error())
+		}
+		defer r.Close()
+		packages, err := ParseRpmPrimary(r, base, arch)
+		if err != nil {
+			return nil, errors.New(url + ": " + err.Error())
+		}
+		return packages, nil
+	}
+	return nil, errors.New(base + "/repodata/repomd.xml lists no primary index")
+}
+
+// ParseRpmPrimary parses the primary index of an rpm-md repository at baseURL,
+// keeping the packages for the architecture arch and those for all architectures
+func ParseRpmPrimary(r io.Reader, baseURL string, arch string) ([]*Package, error) {
+	var primary rpmPrimary
+	err := xml.NewDecoder(r).Decode(&primary)
+	if err != nil {
+		return nil, err
+	}
+	var packages []*Package
+	for _, entry := range primary.Packages {
+		if entry.Arch != arch && entry.Arch != "noarch" {
+			continue
+		}
+		version := entry.Version.Ver + "-" + entry.Version.Rel
+		if entry.Version.Epoch != "" && entry.Version.Epoch != "0" {
+			version = entry.Version.Epoch + ":" + version
+		}
+		p := &Package{
+			Name:    entry.Name,
+			Version: version,
+			Arch:    entry.Arch,
+			Format:  FormatRpm,
+			URL:     strings.TrimSuffix(baseURL, "/") + "/" + entry.Location.Href,
+		}
+		if entry.Checksum.Type == "sha256" {
+			p.SHA256 = strings.TrimSpace(entry.Checksum.Value)
+		}
+		for _, provide := range entry.Provides {
+			p.Provides = appendIfMissing(p.Provides, provide.Name)
+		}
+		p.Provides = append(p.Provides, entry.Files...)
+		for _, require := range entry.Requires {
+			// Features of rpm itself rather than packages
+			if strings.HasPrefix(require.Name, "rpmlib(") == false {
+				p.Depends = append(p.Depends, []string{require.Name})
+			}
+		}
+		packages = append(packages, p)
+	}
+	return packages, nil
+}
+
+// CompareRpmVersions compares two versions of the form [EPOCH:]VERSION-RELEASE like rpm does,
+// returning a negative number if a is older than b, 0 if they are equal, and a positive number otherwise
+func CompareRpmVersions(a string, b string) int {
+	epochA, versionA, releaseA := splitRpmVersion(a)
+	epochB, versionB, releaseB := splitRpmVersion(b)
+	if c := rpmvercmp(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := rpmvercmp(versionA, versionB); c != 0 {
+		return c
+	}
+	return rpmvercmp(releaseA, releaseB)
+}
+
+// splitRpmVersion splits a version into epoch, version, and release
+func splitRpmVersion(version string) (string, string, string) {
+	epoch := "0"
+	if i := strings.Index(version, ":"); i >= 0 {
+		epoch = version[:i]
+		version = version[i+1:]
+	}
+	release := ""
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		release = version[i+1:]
+		version = version[:i]
+	}
+	return epoch, version, release
+}
+
+// rpmvercmp compares alphanumeric segments, in which numbers are newer than letters and
+// ~ sorts before everything, even the end, and ^ after the end but before anything else
+func rpmvercmp(a string, b string) int {
+	isAlnum := func(c byte) bool {
+		return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+	}
+	for a != "" || b != "" {
+		for a != "" && isAlnum(a[0]) == false && a[0] != '~' && a[0] != '^' {
+			a = a[1:]
+		}
+		for b != "" && isAlnum(b[0]) == false && b[0] != '~' && b[0] != '^' {
+			b = b[1:]
+		}
+		for _, special := range []byte{'~', '^'} {
+			sa := a != "" && a[0] == special
+			sb := b != "" && b[0] == special
+			if sa || sb {
+				if sa && sb {
+					a, b = a[1:], b[1:]
+					continue
+				}
+				if special == '~' {
+					if sa {
+						return -1
+					}
+					return 1
+				}
+				// ^ sorts after the end but before anything else
+				if sa {
+					if b == "" {
+						return 1
+					}
+					return -1
+				}
+				if a == "" {
+					return -1
+				}
+				return 1
+			}
+		}
+		if a == "" || b == "" {
+			break
+		}
+		isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+		numeric := isDigit(a[0])
+		segment := func(s string) (string, string) {
+			i := 0
+			for i < len(s) && isAlnum(s[i]) && isDigit(s[i]) == numeric {
+				i++
+			}
+			return s[:i], s[i:]
+		}
+		segA, restA := segment(a)
+		segB, restB := segment(b)
+		if segB == "" {
+			// Numbers are newer than letters
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				return len(segA) - len(segB)
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+		a, b = restA, restB
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// ExtractRpm extracts the files of the .rpm at path into dir. After the lead, the signature header and the
+// header follows the payload, a cpio archive in the newc format, which may be compressed
+func ExtractRpm(path string, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	lead := make([]byte, 96)
+	if _, err := io.ReadFull(r, lead); err != nil || bytes.Equal(lead[:4], []byte{0xed, 0xab, 0xee, 0xdb}) == false {
+		return errors.New(path + " is not an .rpm")
+	}
+	for i := 0; i < 2; i++ {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil || bytes.Equal(header[:3], []byte{0x8e, 0xad, 0xe8}) == false {
+			return errors.New(path + " has an invalid header")
+		}
+		entries := binary.BigEndian.Uint32(header[8:12])
+		size := int64(entries)*16 + int64(binary.BigEndian.Uint32(header[12:16]))
+		// The signature header is padded to a multiple of 8 bytes
+		if i == 0 {
+			size += (8 - (16+size)%8) % 8
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+			return err
+		}
+	}
+	payload, err := decompress(r)
+	if err != nil {
+		return errors.New(path + ": " + err.Error())
+	}
+	defer payload.Close()
+	return extractCpio(payload, dir)
+}
@@ -0,0 +1,325 @@
+// Package zsync updates a file using a .zsync control file, as published alongside AppImages
+// on GitHub Releases. Blocks that the old version (the seed) already contains are reused,
+// and only the missing ones are downloaded using HTTP range requests
+package zsync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Control is the contents of a .zsync file
+type Control struct {
+	Filename  string // Suggested name of the target file
+	MTime     string
+	Blocksize int
+	Length    int64
+	URL       string // Of the target file, resolved against the URL of the .zsync file
+	SHA1      string // Hex-encoded SHA-1 digest of the target file
+
+	weakLength   int
+	strongLength int
+	blocks       []blockChecksum
+}
+
+// blockChecksum holds the checksums of a block of the target file, truncated as in the .zsync file
+type blockChecksum struct {
+	weak   uint32
+	strong []byte
+}
+
+// Fetch downloads and parses the .zsync file at zsyncURL
+func Fetch(zsyncURL string) (*Control, error) {
+	resp, err := http.Get(zsyncURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("could not download " + zsyncURL + ": " + resp.Status)
+	}
+	base, err := url.Parse(zsyncURL)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(resp.Body, base)
+}
+
+// Parse reads a .zsync file from r. The URL of the target file is resolved against base
+func Parse(r io.Reader, base *url.URL) (*Control, error) {
+	c := &Control{}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("incomplete zsync header")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "Filename":
+			c.Filename = parts[1]
+		case "MTime":
+			c.MTime = parts[1]
+		case "Blocksize":
+			c.Blocksize, err = strconv.Atoi(parts[1])
+		case "Length":
+			c.Length, err = strconv.ParseInt(parts[1], 10, 64)
+		case "Hash-Lengths":
+			lengths := strings.Split(parts[1], ",")
+			if len(lengths) != 3 {
+				return nil, errors.New("invalid Hash-Lengths " + parts[1])
+			}
+			c.weakLength, err = strconv.Atoi(lengths[1])
+			if err == nil {
+				c.strongLength, err = strconv.Atoi(lengths[2])
+			}
+		case "URL":
+			var u *url.URL
+			u, err = url.Parse(parts[1])
+			if err == nil && base != nil {
+				u = base.ResolveReference(u)
+			}
+			if err == nil {
+				c.URL = u.String()
+			}
+		case "SHA-1":
+			c.SHA1 = strings.ToLower(parts[1])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid zsync header %q: %v", line, err)
+		}
+	}
+	if c.Blocksize <= 0 || c.Length < 0 || c.URL == "" || c.SHA1 == "" ||
+		c.weakLength < 1 || c.weakLength > 4 || c.strongLength < 1 || c.strongLength > md4.Size {
+		return nil, errors.New("incomplete zsync header")
+	}
+
+	numberOfBlocks := int((c.Length + int64(c.Blocksize) - 1) / int64(c.Blocksize))
+	entry := make([]byte, c.weakLength+c.strongLength)
+	for i := 0; i < numberOfBlocks; i++ {
+		_, err := io.ReadFull(br, entry)
+		if err != nil {
+			return nil, errors.New("incomplete zsync block checksums")
+		}
+		weak := make([]byte, 4)
+		copy(weak[4-c.weakLength:], entry[:c.weakLength])
+		c.blocks = append(c.blocks, blockChecksum{
+			weak:   binary.BigEndian.Uint32(weak),
+			strong: append([]byte(nil), entry[c.weakLength:]...),
+		})
+	}
+	return c, nil
+}
+
+// UpToDate returns true if the file at path is the target file
+func (c *Control) UpToDate(path string) bool {
+	digest, err := sha1File(path)
+	return err == nil && digest == c.SHA1
+}
+
+// Update writes the target file to target, reusing the blocks it has in common with the file at seed,
+// and returns how many bytes were reused. The target file is only written if its SHA-1 digest matches
+func (c *Control) Update(seed string, target string) (int64, error) {
+	data := make([]byte, c.Length)
+	have := make([]bool, len(c.blocks))
+	var reused int64
+
+	seedData, err := ioutil.ReadFile(seed)
+	if err == nil {
+		reused = c.reuseBlocks(seedData, data, have)
+	}
+
+	err = c.downloadMissingBlocks(data, have)
+	if err != nil {
+		return reused, err
+	}
+	if digest := sha1.Sum(data); hex.EncodeToString(digest[:]) != c.SHA1 {
+		// A block was wrongly matched because of the truncated checksums, so download everything
+		reused = 0
+		have = make([]bool, len(c.blocks))
+		err = c.downloadMissingBlocks(data, have)
+		if err != nil {
+			return reused, err
+		}
+		if digest := sha1.Sum(data); hex.EncodeToString(digest[:]) != c.SHA1 {
+			return reused, errors.New("the SHA-1 digest of the downloaded file does not match")
+		}
+	}
+
+	temp := target + ".zs-part"
+	err = ioutil.WriteFile(temp, data, 0755)
+	if err != nil {
+		return reused, err
+	}
+	return reused, os.Rename(temp, target)
+}
+
+// reuseBlocks copies the blocks of the target file that can be found in seedData into data
+func (c *Control) reuseBlocks(seedData []byte, data []byte, have []bool) int64 {
+	blocksize := c.Blocksize
+	mask := uint32(0xffffffff)
+	if c.weakLength < 4 {
+		mask = 1<<(8*uint(c.weakLength)) - 1
+	}
+	candidates := make(map[uint32][]int)
+	for i, b := range c.blocks {
+		candidates[b.weak] = append(candidates[b.weak], i)
+	}
+	// The last block of the target file is padded with zeros, hence the seed is, too
+	seedData = append(seedData, make([]byte, blocksize)...)
+	end := len(seedData) - blocksize
+
+	var reused int64
+	var a, b uint16
+	computed := false
+	for offset := 0; offset < end; {
+		window := seedData[offset : offset+blocksize]
+		if !computed {
+			a, b = rsum(window)
+			computed = true
+		}
+		matched := false
+		if indexes, ok := candidates[(uint32(a)<<16|uint32(b))&mask]; ok {
+			strong := md4.New()
+			strong.Write(window)
+			sum := strong.Sum(nil)[:c.strongLength]
+			for _, i := range indexes {
+				if !have[i] && bytes.Equal(sum, c.blocks[i].strong) {
+					start := int64(i) * int64(blocksize)
+					n := copy(data[start:], window)
+					reused += int64(n)
+					have[i] = true
+					matched = true
+				}
+			}
+		}
+		if matched {
+			offset += blocksize
+			computed = false
+			continue
+		}
+		// Roll the checksum one byte further
+		out, in := uint16(seedData[offset]), uint16(seedData[offset+blocksize])
+		a = a - out + in
+		b = b - uint16(blocksize)*out + a
+		offset++
+	}
+	return reused
+}
+
+// rsum calculates the rolling checksum of a block the way zsync does
+func rsum(block []byte) (uint16, uint16) {
+	var a, b uint16
+	l := uint16(len(block))
+	for _, v := range block {
+		a += uint16(v)
+		b += l * uint16(v)
+		l--
+	}
+	return a, b
+}
+
+// downloadMissingBlocks downloads the blocks that are not marked in have into data,
+// using one range request for each run of missing blocks
+func (c *Control) downloadMissingBlocks(data []byte, have []bool) error {
+	for i := 0; i < len(have); {
+		if have[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < len(have) && !have[j] {
+			j++
+		}
+		start := int64(i) * int64(c.Blocksize)
+		end := int64(j) * int64(c.Blocksize)
+		if end > c.Length {
+			end = c.Length
+		}
+		complete, err := c.downloadRange(data, start, end)
+		if err != nil {
+			return err
+		}
+		if complete {
+			// The server ignored the range and sent the whole file
+			return nil
+		}
+		for k := i; k < j; k++ {
+			have[k] = true
+		}
+		i = j
+	}
+	return nil
+}
+
+// downloadRange downloads the bytes from start to end of the target file into data.
+// It returns true if the server sent the whole file instead
+func (c *Control) downloadRange(data []byte, start int64, end int64) (bool, error) {
+	req, err := http.NewRequest("GET", c.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end-1, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, err = io.ReadFull(resp.Body, data[start:end])
+		return false, err
+	case http.StatusOK:
+		_, err = io.ReadFull(resp.Body, data)
+		return true, err
+	default:
+		return false, errors.New("could not download " + c.URL + ": " + resp.Status)
+	}
+}
+
+// sha1File returns the hex-encoded SHA-1 digest of the file at path
+func sha1File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// TargetPath returns where the target file should be written when updating the file at seed,
+// which is next to it under the name suggested by the .zsync file
+func (c *Control) TargetPath(seed string) string {
+	name := filepath.Base(c.Filename)
+	if name == "." || name == "/" || name == "" {
+		name = filepath.Base(seed)
+	}
+	return filepath.Join(filepath.Dir(seed), name)
+}
@@ -0,0 +1,62 @@
+package zsync
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/probonopd/go-zsyncmake/zsync"
+)
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	served := filepath.Join(dir, "served")
+	os.Mkdir(served, 0755)
+
+	// The new version differs from the old one in the middle, and has grown
+	old := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(old)
+	updated := append([]byte(nil), old[:40000]...)
+	updated = append(updated, []byte("something that has changed")...)
+	updated = append(updated, old[45000:]...)
+	updated = append(updated, []byte("appended")...)
+	ioutil.WriteFile(filepath.Join(dir, "Some-1.AppImage"), old, 0755)
+	ioutil.WriteFile(filepath.Join(served, "Some-2.AppImage"), updated, 0755)
+	zsync.ZsyncMake(filepath.Join(served, "Some-2.AppImage"), zsync.Options{Url: "Some-2.AppImage"})
+
+	server := httptest.NewServer(http.FileServer(http.Dir(served)))
+	defer server.Close()
+
+	c, err := Fetch(server.URL + "/Some-2.AppImage.zsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := filepath.Join(dir, "Some-1.AppImage")
+	if c.UpToDate(seed) {
+		t.Error("The old version is considered up to date")
+	}
+	target := c.TargetPath(seed)
+	if target != filepath.Join(dir, "Some-2.AppImage") {
+		t.Errorf("Unexpected target %s", target)
+	}
+	reused, err := c.Update(seed, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused < 80000 {
+		t.Errorf("Only %d bytes were reused", reused)
+	}
+	data, _ := ioutil.ReadFile(target)
+	if !bytes.Equal(data, updated) || !c.UpToDate(target) {
+		t.Error("The updated file differs from the new version")
+	}
+}
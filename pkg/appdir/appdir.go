@@ -0,0 +1,423 @@
+// Package appdir creates, populates, validates and lints AppDirs,
+// the directories from which AppImages are made.
+// It can be used by build tools that want to generate AppDirs
+// without shelling out to appimagetool, e.g.,
+//
+//	ad, err := appdir.Create("MyApp.AppDir")
+//	ad.AddExecutable("build/myapp")
+//	ad.AddDesktopEntry(appdir.DesktopEntry{Name: "My App", Exec: "myapp", Icon: "myapp", Categories: []string{"Utility"}})
+//	ad.AddIcon(appdir.Icon{Name: "myapp", Path: "myapp.png"})
+//	err = ad.Validate()
+package appdir
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// AppDir is a directory with an FHS-like structure that contains
+// an application and everything it needs to run, plus a top-level
+// desktop file, icon and AppRun
+type AppDir struct {
+	Path            string
+	DesktopFilePath string
+	MainExecutable  string
+	Warnings        []string // What was changed or needs to be changed in the AppDir, for the caller to show
+}
+
+// New returns the AppDir that contains the desktop file at desktopFilePath,
+// which must be in <AppDir>/usr/share/applications. Nothing is written: the desktop file
+// is checked as it will be once AddTopLevelFiles has made it suitable for use in an AppImage,
+// and what this will change is put into Warnings
+func New(desktopFilePath string) (AppDir, error) {
+	var ad AppDir
+
+	// Check if desktop file exists
+	if exists(desktopFilePath) == false {
+		return ad, errors.New("Desktop file not found")
+	}
+	ad.DesktopFilePath = desktopFilePath
+
+	// Determine root directory of the AppImage
+	pathToBeChecked := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(ad.DesktopFilePath)))) + "/usr/bin"
+	if exists(pathToBeChecked) {
+		ad.Path = filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(ad.DesktopFilePath))))
+	} else {
+		return ad, errors.New("AppDir could not be identified: " + pathToBeChecked + " does not exist")
+	}
+
+	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, // Do not cripple lines hat contain ";"
+		ad.DesktopFilePath)
+	if err != nil {
+		return ad, err
+	}
+	ad.Warnings = normalizeDesktopEntry(cfg, ad.Path)
+
+	// Desktop file verification
+	err = checkDesktopEntry(cfg)
+	if err != nil {
+		return ad, err
+	}
+	sect := cfg.Section("Desktop Entry")
+
+	// Do not allow paths in the Exec= key
+	executable, _ := splitExec(sect.Key("Exec").String())
+	if executable != filepath.Base(executable) {
+		err = errors.New("Exec= contains a path, please remove it")
+		return ad, err
+	}
+
+	ad.MainExecutable = findMainExecutable(ad.Path, executable)
+
+	// Do not allow paths in the Icon= key
+	iconName := strings.Split(sect.Key("Icon").String(), " ")[0]
+	if iconName != filepath.Base(iconName) {
+		err = errors.New("Icon= contains a path, please remove it")
+		return ad, err
+	}
+
+	return ad, nil
+}
+
+// AddTopLevelFiles copies the desktop file of the AppDir and the main icon to the top level of the AppDir,
+// where AppImages need them, makes the copy of the desktop file suitable for use in an AppImage,
+// and makes it the desktop file of the AppDir. Returns an error if there is another top-level desktop file
+func (ad *AppDir) AddTopLevelFiles() error {
+	topLevel := ad.Path + "/" + filepath.Base(ad.DesktopFilePath)
+	if ad.DesktopFilePath != topLevel {
+		err := copyFile(ad.DesktopFilePath, topLevel)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := NormalizeDesktopFile(topLevel, ad.Path)
+	if err != nil {
+		return err
+	}
+	ad.DesktopFilePath, err = findTopLevelDesktopFile(ad.Path)
+	if err != nil {
+		return err
+	}
+	entry, err := ReadDesktopEntry(ad.DesktopFilePath)
+	if err != nil {
+		return err
+	}
+	return ad.CopyMainIconToRoot(entry.Icon)
+}
+
+// Create creates an empty AppDir at path with the directories
+// that most applications need, and returns it
+func Create(path string) (AppDir, error) {
+	ad := AppDir{Path: path}
+	for _, dir := range []string{"usr/bin", "usr/lib", "usr/share/applications"} {
+		err := os.MkdirAll(filepath.Join(path, dir), 0755)
+		if err != nil {
+			return ad, err
+		}
+	}
+	return ad, ad.CreateIconDirectories()
+}
+
+// Open returns the existing AppDir at path, which must contain exactly one
+// top-level desktop file whose Exec= key names the main executable
+func Open(path string) (AppDir, error) {
+	ad := AppDir{Path: path}
+	var err error
+	ad.DesktopFilePath, err = findTopLevelDesktopFile(path)
+	if err != nil {
+		return ad, err
+	}
+	entry, err := ReadDesktopEntry(ad.DesktopFilePath)
+	if err != nil {
+		return ad, err
+	}
+	executable, _ := splitExec(entry.Exec)
+	ad.MainExecutable = findMainExecutable(path, filepath.Base(executable))
+	return ad, nil
+}
+
+// AddExecutable copies the executable at src into usr/bin of the AppDir
+// and returns its path in the AppDir
+func (appdir AppDir) AddExecutable(src string) (string, error) {
+	dst := appdir.Path + "/usr/bin/" + filepath.Base(src)
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return dst, err
+	}
+	err = copyFile(src, dst)
+	if err != nil {
+		return dst, err
+	}
+	return dst, os.Chmod(dst, 0755)
+}
+
+// AddDesktopEntry writes entry to usr/share/applications/<name of the executable>.desktop
+// and to the top level of the AppDir, and makes it the desktop file of the AppDir
+func (appdir *AppDir) AddDesktopEntry(entry DesktopEntry) error {
+	executable, _ := splitExec(entry.Exec)
+	if executable == "" {
+		return errors.New("the desktop entry has no Exec= key")
+	}
+	name := filepath.Base(executable) + ".desktop"
+	err := os.MkdirAll(appdir.Path+"/usr/share/applications", 0755)
+	if err != nil {
+		return err
+	}
+	err = entry.Write(appdir.Path + "/usr/share/applications/" + name)
+	if err != nil {
+		return err
+	}
+	changes, err := NormalizeDesktopFile(appdir.Path+"/usr/share/applications/"+name, appdir.Path)
+	if err != nil {
+		return err
+	}
+	appdir.Warnings = append(appdir.Warnings, changes...)
+	err = copyFile(appdir.Path+"/usr/share/applications/"+name, appdir.Path+"/"+name)
+	if err != nil {
+		return err
+	}
+	appdir.DesktopFilePath = appdir.Path + "/" + name
+	appdir.MainExecutable = findMainExecutable(appdir.Path, filepath.Base(executable))
+	return nil
+}
+
+// AddIcon installs icon into the hicolor icon theme of the AppDir and,
+// if the icon is the one named in the Icon= key of the desktop file,
+// also to the top level of the AppDir
+func (appdir AppDir) AddIcon(icon Icon) error {
+	dst, err := icon.installPath()
+	if err != nil {
+		return err
+	}
+	dst = appdir.Path + "/" + dst
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	err = copyFile(icon.Path, dst)
+	if err != nil {
+		return err
+	}
+	if appdir.DesktopFilePath == "" {
+		return nil
+	}
+	entry, err := ReadDesktopEntry(appdir.DesktopFilePath)
+	if err != nil || entry.Icon != icon.Name || exists(appdir.Path+"/"+icon.Name+filepath.Ext(icon.Path)) {
+		return err
+	}
+	return copyFile(icon.Path, appdir.Path+"/"+icon.Name+filepath.Ext(icon.Path))
+}
+
+// Validate returns an error if the AppDir cannot be turned into a working AppImage:
+// it needs exactly one valid top-level desktop file, the main executable, a top-level
+// icon named like the Icon= key of the desktop file, and an executable AppRun
+func (appdir AppDir) Validate() error {
+	desktopFile, err := findTopLevelDesktopFile(appdir.Path)
+	if err != nil {
+		return err
+	}
+	err = ValidateDesktopFile(desktopFile)
+	if err != nil {
+		return err
+	}
+	err = CheckDesktopFile(desktopFile)
+	if err != nil {
+		return err
+	}
+	entry, err := ReadDesktopEntry(desktopFile)
+	if err != nil {
+		return err
+	}
+	executable, _ := splitExec(entry.Exec)
+	if isExecutable(findMainExecutable(appdir.Path, executable)) == false {
+		return errors.New("the main executable " + executable + " is missing in " + appdir.Path)
+	}
+	var icons []string
+	for _, suffix := range iconSuffixes {
+		if exists(appdir.Path + "/" + entry.Icon + suffix) {
+			icons = append(icons, entry.Icon+suffix)
+		}
+	}
+	if len(icons) == 0 {
+		return errors.New("the icon " + entry.Icon + " is missing in the top level of " + appdir.Path)
+	}
+	if isExecutable(appdir.Path+"/AppRun") == false {
+		return errors.New(appdir.Path + "/AppRun is missing or not executable")
+	}
+	return nil
+}
+
+// Lint returns problems with the AppDir that do not prevent it from being
+// turned into an AppImage, but that degrade how it integrates into desktops
+func (appdir AppDir) Lint() []string {
+	var problems []string
+	desktopFile, err := findTopLevelDesktopFile(appdir.Path)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	entry, err := ReadDesktopEntry(desktopFile)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	if entry.Comment == "" {
+		problems = append(problems, "The desktop file has no Comment=, which desktops show as a tooltip")
+	}
+	if len(entry.Categories) == 0 {
+		problems = append(problems, "The desktop file has no Categories=, so menus cannot sort the application")
+	}
+	id := strings.TrimSuffix(filepath.Base(desktopFile), ".desktop")
+	if exists(appdir.Path+"/usr/share/metainfo/"+id+".appdata.xml") == false &&
+		exists(appdir.Path+"/usr/share/metainfo/"+id+".metainfo.xml") == false {
+		problems = append(problems, "There is no AppStream metainfo file in usr/share/metainfo/, which software centers need")
+	}
+	if exists(appdir.Path+"/usr/share/icons/hicolor/scalable/apps/"+entry.Icon+".svg") == false {
+		var sizes []string
+		for _, size := range []int{256, 128, 48} {
+			if exists(appdir.Path+"/usr/share/icons/hicolor/"+strconv.Itoa(size)+"x"+strconv.Itoa(size)+"/apps/"+entry.Icon+".png") == false {
+				sizes = append(sizes, strconv.Itoa(size)+"x"+strconv.Itoa(size))
+			}
+		}
+		if len(sizes) > 0 {
+			problems = append(problems, "The icon "+entry.Icon+" is missing in the sizes "+strings.Join(sizes, ", ")+" and there is no scalable version")
+		}
+	}
+	if exists(appdir.Path+"/.DirIcon") == false {
+		problems = append(problems, "There is no .DirIcon, which file managers use as the icon of the AppImage")
+	}
+	return problems
+}
+
+// ElfInterpreter returns the ELF interpreter (e.g., ld-linux) of the main executable
+func (appdir AppDir) ElfInterpreter() (string, error) {
+	// It might be that we have a script there that starts with a shebang
+	// TODO: get binary from shebang (resolve to ELF absolute path)
+	// and determine its ELF interpreter instead (or use the next best ELF binary in the AppDir)
+	f, err := elf.Open(appdir.MainExecutable)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return "", err
+		}
+		return string(bytes.TrimRight(data, "\x00")), nil
+	}
+	return "", errors.New(appdir.MainExecutable + " has no ELF interpreter")
+}
+
+// CreateIconDirectories creates empty directories
+// in <AppDir>/usr/share/icons/<size>/apps
+func (appdir AppDir) CreateIconDirectories() error {
+	// Only use the most common sizes in the hope that at least
+	// those will work on all target systems
+	var err error = nil
+	for _, iconSize := range iconSizes {
+		err = os.MkdirAll(appdir.Path+"/usr/share/icons/hicolor/"+strconv.Itoa(iconSize)+"x"+strconv.Itoa(iconSize)+"/apps", 0755)
+	}
+	return err
+}
+
+// CopyMainIconToRoot copies the most suitable icon for the
+// Icon= entry in DesktopFilePath to the root of the AppDir, unless there is one already
+func (appdir AppDir) CopyMainIconToRoot(iconName string) error {
+	iconPreferenceOrder := []int{128, 256, 512, 48, 32, 24, 22, 16, 8}
+	if exists(appdir.Path + "/" + iconName + ".png") {
+		return nil
+	}
+	for _, iconSize := range iconPreferenceOrder {
+		candidate := appdir.Path + "/usr/share/icons/hicolor/" + strconv.Itoa(iconSize) + "x" + strconv.Itoa(iconSize) + "/apps/" + iconName + ".png"
+		if exists(candidate) {
+			return copyFile(candidate, appdir.Path+"/"+iconName+".png")
+		}
+	}
+	return nil
+}
+
+// findTopLevelDesktopFile returns the path to the only desktop file in the top level of the AppDir at appdirPath
+func findTopLevelDesktopFile(appdirPath string) (string, error) {
+	infos, err := ioutil.ReadDir(appdirPath)
+	if err != nil {
+		return "", err
+	}
+	var desktopFiles []string
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".desktop") == true {
+			desktopFiles = append(desktopFiles, appdirPath+"/"+info.Name())
+		}
+	}
+
+	// Return if we have too few or too many top-level desktop files now
+	if len(desktopFiles) < 1 {
+		return "", errors.New("No desktop file was found, please place one into " + appdirPath)
+	}
+	if len(desktopFiles) > 1 {
+		return "", errors.New("More than one desktop file was found in " + appdirPath)
+	}
+	return desktopFiles[0], nil
+}
+
+// findMainExecutable returns the path to the executable with the given name in the AppDir at
+// appdirPath. It is usually in usr/bin, but may be elsewhere, e.g., in the top-level directory.
+// Returns the path in usr/bin if it is not found, since it may not have been put there yet
+func findMainExecutable(appdirPath string, name string) string {
+	if exists(appdirPath + "/usr/bin/" + name) {
+		return appdirPath + "/usr/bin/" + name
+	}
+	found := ""
+	filepath.Walk(appdirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || found != "" {
+			return nil
+		}
+		if info.Name() == name && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			found = path
+		}
+		return nil
+	})
+	if found == "" {
+		return appdirPath + "/usr/bin/" + name
+	}
+	return found
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0
+}
+
+// copyFile copies the file src to dst, overwriting dst if it exists
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
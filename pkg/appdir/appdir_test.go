@@ -0,0 +1,171 @@
+package appdir_test
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/probonopd/go-appimage/pkg/appdir"
+)
+
+func TestCreateAndPopulate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An executable and a 48x48 icon to put into the AppDir
+	err = ioutil.WriteFile(dir+"/myapp", []byte("#!/bin/sh\necho hello\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(dir + "/myapp.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewRGBA(image.Rect(0, 0, 48, 48)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ad, err := appdir.Create(dir + "/MyApp.AppDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ad.AddExecutable(dir + "/myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ad.AddDesktopEntry(appdir.DesktopEntry{Name: "My App", Exec: "myapp %F", Icon: "myapp", Categories: []string{"Utility"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ad.AddIcon(appdir.Icon{Name: "myapp", Path: dir + "/myapp.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(ad.Path + "/usr/share/icons/hicolor/48x48/apps/myapp.png"); err != nil {
+		t.Error("Icon was not installed in the right size:", err)
+	}
+
+	if ad.Validate() == nil {
+		t.Error("AppDir without AppRun was accepted")
+	}
+	err = ioutil.WriteFile(ad.Path+"/AppRun", []byte("#!/bin/sh\nexec \"$(dirname \"$0\")/usr/bin/myapp\" \"$@\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ad.Validate()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(ad.Lint()) == 0 {
+		t.Error("Expected lint warnings about the missing Comment=, metainfo and .DirIcon")
+	}
+
+	opened, err := appdir.Open(ad.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.MainExecutable != ad.Path+"/usr/bin/myapp" {
+		t.Error("Wrong main executable " + opened.MainExecutable)
+	}
+	entry, err := appdir.ReadDesktopEntry(opened.DesktopFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != "My App" || entry.Exec != "myapp %F" || len(entry.Categories) != 1 {
+		t.Errorf("Desktop entry was not written correctly: %+v", entry)
+	}
+}
+
+func TestAddIconInAllSizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Not square, hence needs to be padded
+	f, err := os.Create(dir + "/myapp.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewRGBA(image.Rect(0, 0, 100, 60)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ad, err := appdir.Create(dir + "/MyApp.AppDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ad.AddIconInAllSizes("myapp", dir+"/myapp.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []string{"48x48", "32x32", "16x16"} {
+		if _, err = os.Stat(ad.Path + "/usr/share/icons/hicolor/" + size + "/apps/myapp.png"); err != nil {
+			t.Error("Icon was not installed in size", size)
+		}
+	}
+	if _, err = os.Stat(ad.Path + "/usr/share/icons/hicolor/128x128/apps/myapp.png"); err == nil {
+		t.Error("Icon was scaled up")
+	}
+}
+
+func TestNewIsReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, d := range []string{"/usr/bin", "/usr/share/applications"} {
+		err = os.MkdirAll(dir+d, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	desktopFile := dir + "/usr/share/applications/myapp.desktop"
+	contents := []byte("[Desktop Entry]\nType=Application\nName=My App\nExec=/usr/bin/myapp %F\nIcon=myapp.png\nCategories=Utility;\n")
+	err = ioutil.WriteFile(desktopFile, contents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ad, err := appdir.New(desktopFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ad.Warnings) != 2 {
+		t.Error("Expected warnings about Exec= and Icon=, got", ad.Warnings)
+	}
+	if _, err = os.Stat(dir + "/myapp.desktop"); err == nil {
+		t.Error("New wrote a top-level desktop file")
+	}
+	written, err := ioutil.ReadFile(desktopFile)
+	if err != nil || string(written) != string(contents) {
+		t.Error("New changed the desktop file:", string(written), err)
+	}
+
+	err = ad.AddTopLevelFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ad.DesktopFilePath != dir+"/myapp.desktop" {
+		t.Error("Desktop file of the AppDir is", ad.DesktopFilePath)
+	}
+	entry, err := appdir.ReadDesktopEntry(ad.DesktopFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Exec != "myapp %F" || entry.Icon != "myapp" {
+		t.Error("Top-level desktop file was not normalized:", entry.Exec, entry.Icon)
+	}
+}
@@ -0,0 +1,308 @@
+package appdir
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// CheckDesktopFile checks that the desktop file has the keys that AppImages need,
+// and that Icon= contains neither a path nor a suffix
+func CheckDesktopFile(desktopfile string) error {
+	d, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, // Do not cripple lines hat contain ";"
+		desktopfile)
+	if err != nil {
+		return err
+	}
+	return checkDesktopEntry(d)
+}
+
+// checkDesktopEntry checks the loaded desktop file like CheckDesktopFile
+func checkDesktopEntry(d *ini.File) error {
+	// Check for presence of required keys and abort otherwise
+	neededKeys := []string{"Categories", "Name", "Exec", "Type", "Icon"}
+	for _, k := range neededKeys {
+		if d.Section("Desktop Entry").HasKey(k) == false {
+			return errors.New(".desktop file is missing a '" + k + "'= key\n")
+		}
+	}
+
+	val, _ := d.Section("Desktop Entry").GetKey("Icon")
+	iconname := val.String()
+	if strings.Contains(iconname, "/") {
+		return errors.New("Desktop file contains Icon= entry with a path")
+	}
+
+	if strings.HasSuffix(filepath.Base(iconname), ".png") ||
+		strings.HasSuffix(filepath.Base(iconname), ".svg") ||
+		strings.HasSuffix(filepath.Base(iconname), ".svgz") ||
+		strings.HasSuffix(filepath.Base(iconname), ".xpm") {
+		return errors.New("Desktop file contains Icon= entry with a suffix, please remove the suffix")
+	}
+
+	return nil
+}
+
+// NormalizeDesktopFile rewrites the desktop file so that it works from within an AppImage:
+// absolute paths are stripped from the Exec= keys of the main entry and of all actions,
+// Icon= is reduced to the icon name without path and suffix, TryExec= is dropped if it
+// points outside of the AppDir at appdirPath, and actions without a matching
+// 'Desktop Action' group or without Name= are dropped from Actions=.
+// Returns what was changed, for the caller to show, and an error if the desktop file cannot be read or written
+func NormalizeDesktopFile(desktopfile string, appdirPath string) ([]string, error) {
+	ini.PrettyFormat = false
+	d, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, // Do not cripple lines hat contain ";"
+		desktopfile)
+	if err != nil {
+		return nil, err
+	}
+	changes := normalizeDesktopEntry(d, appdirPath)
+	return changes, d.SaveTo(desktopfile)
+}
+
+// normalizeDesktopEntry makes the loaded desktop file work from within an AppImage
+// like NormalizeDesktopFile, and returns what was changed
+func normalizeDesktopEntry(d *ini.File, appdirPath string) []string {
+	var changes []string
+	sect := d.Section("Desktop Entry")
+
+	normalizeExecKey := func(key *ini.Key) {
+		if exec := normalizeExec(key.String()); exec != key.String() {
+			changes = append(changes, "Removing the path from Exec="+key.String())
+			key.SetValue(exec)
+		}
+	}
+	if sect.HasKey("Exec") {
+		normalizeExecKey(sect.Key("Exec"))
+	}
+
+	if sect.HasKey("Icon") {
+		icon := filepath.Base(sect.Key("Icon").String())
+		for _, suffix := range []string{".png", ".svg", ".svgz", ".xpm"} {
+			icon = strings.TrimSuffix(icon, suffix)
+		}
+		if icon != sect.Key("Icon").String() {
+			changes = append(changes, "Rewriting Icon="+sect.Key("Icon").String()+" to Icon="+icon)
+			sect.Key("Icon").SetValue(icon)
+		}
+	}
+
+	if sect.HasKey("TryExec") {
+		tryExec := sect.Key("TryExec").String()
+		if (filepath.IsAbs(tryExec) && strings.HasPrefix(tryExec, appdirPath) == false) ||
+			(filepath.IsAbs(tryExec) == false && exists(appdirPath+"/usr/bin/"+tryExec) == false) {
+			changes = append(changes, "Removing TryExec="+tryExec+" because it points outside of the AppDir")
+			sect.DeleteKey("TryExec")
+		}
+	}
+
+	if sect.HasKey("Actions") {
+		var actions []string
+		for _, action := range strings.Split(sect.Key("Actions").String(), ";") {
+			if action == "" {
+				continue
+			}
+			actionSect, err := d.GetSection("Desktop Action " + action)
+			if err != nil || actionSect.HasKey("Name") == false {
+				changes = append(changes, "Removing action "+action+" because it has no 'Desktop Action "+action+"' group with a Name= key")
+				continue
+			}
+			if actionSect.HasKey("Exec") {
+				normalizeExecKey(actionSect.Key("Exec"))
+			}
+			actions = append(actions, action)
+		}
+		if len(actions) > 0 {
+			sect.Key("Actions").SetValue(strings.Join(actions, ";") + ";")
+		} else {
+			sect.DeleteKey("Actions")
+		}
+	}
+	return changes
+}
+
+// normalizeExec returns the value of an Exec= key with the path
+// removed from the executable, keeping any arguments
+func normalizeExec(exec string) string {
+	executable, arguments := splitExec(exec)
+	if executable == filepath.Base(executable) {
+		return exec
+	}
+	executable = filepath.Base(executable)
+	if strings.Contains(executable, " ") {
+		executable = "\"" + executable + "\""
+	}
+	return executable + arguments
+}
+
+// splitExec splits the value of an Exec= key into the executable, which may be quoted,
+// and the arguments including the leading space
+func splitExec(exec string) (string, string) {
+	if strings.HasPrefix(exec, "\"") && strings.Index(exec[1:], "\"") > 0 {
+		end := strings.Index(exec[1:], "\"") + 1
+		return exec[1:end], exec[end+1:]
+	}
+	parts := strings.SplitN(exec, " ", 2)
+	if len(parts) > 1 {
+		return parts[0], " " + parts[1]
+	}
+	return parts[0], ""
+}
+
+// Types of desktop entries defined by the Desktop Entry Specification
+var desktopEntryTypes = []string{"Application", "Link", "Directory"}
+
+// Keys that hold a list of strings, which must be terminated by ';'
+var desktopEntryListKeys = []string{"Categories", "MimeType", "Keywords", "Actions", "OnlyShowIn", "NotShowIn", "Implements"}
+
+// ValidateDesktopFile checks the parts of the Desktop Entry Specification
+// that most often go wrong, without needing desktop-file-validate.
+// Returns an error describing the first problem found
+func ValidateDesktopFile(desktopfile string) error {
+	d, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true, AllowShadows: true}, desktopfile)
+	if err != nil {
+		return err
+	}
+	sections := d.SectionStrings()
+	if len(sections) < 2 || sections[1] != "Desktop Entry" {
+		// The first section is the default section of the ini library
+		return errors.New(desktopfile + ": the first group must be 'Desktop Entry'")
+	}
+	if len(d.Section(ini.DefaultSection).Keys()) > 0 {
+		return errors.New(desktopfile + ": keys must not appear before the 'Desktop Entry' group")
+	}
+	for _, name := range sections[1:] {
+		sect := d.Section(name)
+		for _, key := range sect.Keys() {
+			if len(key.ValueWithShadows()) > 1 {
+				return errors.New(desktopfile + ": key '" + key.Name() + "' appears more than once in group '" + name + "'")
+			}
+			if isValidDesktopEntryKey(key.Name()) == false {
+				return errors.New(desktopfile + ": invalid key name '" + key.Name() + "' in group '" + name + "'")
+			}
+			base := strings.SplitN(key.Name(), "[", 2)[0]
+			if sliceContains(desktopEntryListKeys, base) && key.String() != "" && strings.HasSuffix(key.String(), ";") == false {
+				return errors.New(desktopfile + ": value of key '" + key.Name() + "' must end with ';'")
+			}
+		}
+	}
+	sect := d.Section("Desktop Entry")
+	if sliceContains(desktopEntryTypes, sect.Key("Type").String()) == false {
+		return errors.New(desktopfile + ": invalid or missing Type= '" + sect.Key("Type").String() + "'")
+	}
+	if sect.HasKey("Name") == false {
+		return errors.New(desktopfile + ": missing Name= key")
+	}
+	if sect.Key("Type").String() == "Application" && sect.HasKey("Exec") == false && sect.Key("DBusActivatable").String() != "true" {
+		return errors.New(desktopfile + ": applications need an Exec= key")
+	}
+	return nil
+}
+
+// isValidDesktopEntryKey returns true if name consists of A-Za-z0-9- with an optional [locale] suffix
+func isValidDesktopEntryKey(name string) bool {
+	if i := strings.Index(name, "["); i > 0 && strings.HasSuffix(name, "]") {
+		name = name[:i]
+	}
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func sliceContains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+// DesktopEntry holds the most commonly used keys of the 'Desktop Entry' group of a desktop file
+type DesktopEntry struct {
+	Name       string
+	Exec       string // Name of the executable without path, followed by arguments such as %F
+	Icon       string // Icon name without path and suffix
+	Comment    string
+	Categories []string
+	Terminal   bool
+	Extra      map[string]string // Any other keys, e.g., MimeType or X-AppImage-Version
+}
+
+// ReadDesktopEntry reads the 'Desktop Entry' group of the desktop file at path
+func ReadDesktopEntry(path string) (DesktopEntry, error) {
+	var entry DesktopEntry
+	d, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, path)
+	if err != nil {
+		return entry, err
+	}
+	sect, err := d.GetSection("Desktop Entry")
+	if err != nil {
+		return entry, err
+	}
+	entry.Extra = make(map[string]string)
+	for _, key := range sect.Keys() {
+		switch key.Name() {
+		case "Type":
+		case "Name":
+			entry.Name = key.String()
+		case "Exec":
+			entry.Exec = key.String()
+		case "Icon":
+			entry.Icon = key.String()
+		case "Comment":
+			entry.Comment = key.String()
+		case "Categories":
+			for _, category := range strings.Split(key.String(), ";") {
+				if category != "" {
+					entry.Categories = append(entry.Categories, category)
+				}
+			}
+		case "Terminal":
+			entry.Terminal = key.String() == "true"
+		default:
+			entry.Extra[key.Name()] = key.String()
+		}
+	}
+	return entry, nil
+}
+
+// Write writes the entry as a desktop file of Type=Application to path
+func (entry DesktopEntry) Write(path string) error {
+	ini.PrettyFormat = false
+	d := ini.Empty()
+	sect, err := d.NewSection("Desktop Entry")
+	if err != nil {
+		return err
+	}
+	sect.NewKey("Type", "Application")
+	sect.NewKey("Name", entry.Name)
+	sect.NewKey("Exec", entry.Exec)
+	sect.NewKey("Icon", entry.Icon)
+	if entry.Comment != "" {
+		sect.NewKey("Comment", entry.Comment)
+	}
+	if len(entry.Categories) > 0 {
+		sect.NewKey("Categories", strings.Join(entry.Categories, ";")+";")
+	}
+	sect.NewKey("Terminal", strconv.FormatBool(entry.Terminal))
+	var keys []string
+	for key := range entry.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sect.NewKey(key, entry.Extra[key])
+	}
+	return d.SaveTo(path)
+}
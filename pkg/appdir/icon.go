@@ -0,0 +1,144 @@
+package appdir
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Register the JPEG format for image.Decode
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Sizes of the hicolor icon theme directories that CreateIconDirectories creates.
+// Only the most common sizes, in the hope that at least those will work on all target systems
+var iconSizes = []int{512, 256, 128, 48, 32, 24, 22, 16, 8}
+
+// Suffixes of icon files, in order of preference
+var iconSuffixes = []string{".png", ".svg", ".svgz", ".xpm"}
+
+// Icon is an icon file of the application
+type Icon struct {
+	Name string // Icon name as used in the Icon= key of desktop files, without suffix
+	Path string // Path to the .png, .svg, .svgz or .xpm file
+	Size int    // Width and height of a .png or .xpm icon in pixels, determined from .png files if 0
+}
+
+// installPath returns the path of the icon in the hicolor icon theme, relative to the AppDir
+func (icon Icon) installPath() (string, error) {
+	suffix := filepath.Ext(icon.Path)
+	if suffix == ".svg" || suffix == ".svgz" {
+		return "usr/share/icons/hicolor/scalable/apps/" + icon.Name + suffix, nil
+	}
+	size := icon.Size
+	if size == 0 && suffix == ".png" {
+		f, err := os.Open(icon.Path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		config, _, err := image.DecodeConfig(f)
+		if err != nil {
+			return "", errors.New(icon.Path + ": " + err.Error())
+		}
+		if config.Width != config.Height {
+			return "", errors.New(icon.Path + " is not square")
+		}
+		size = config.Width
+	}
+	if size == 0 {
+		return "", errors.New("the size of " + icon.Path + " is unknown")
+	}
+	return "usr/share/icons/hicolor/" + strconv.Itoa(size) + "x" + strconv.Itoa(size) + "/apps/" + icon.Name + suffix, nil
+}
+
+// AddIconInAllSizes installs the PNG or JPEG image at path as the icon with the given name
+// in all iconSizes up to the size of the image, scaling it down and padding it to a square
+// as needed. The largest size also goes to the top level of the AppDir if the desktop file
+// uses the icon. SVG icons are installed as they are, since they scale by themselves
+func (appdir AppDir) AddIconInAllSizes(name string, path string) error {
+	suffix := filepath.Ext(path)
+	if suffix == ".svg" || suffix == ".svgz" {
+		return appdir.AddIcon(Icon{Name: name, Path: path})
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return errors.New(path + ": " + err.Error())
+	}
+
+	tmp, err := ioutil.TempDir("", "icon")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	side := src.Bounds().Dx()
+	if src.Bounds().Dy() > side {
+		side = src.Bounds().Dy()
+	}
+	var added bool
+	for _, size := range iconSizes {
+		if size > side {
+			continue
+		}
+		scaled := tmp + "/" + strconv.Itoa(size) + ".png"
+		f, err := os.Create(scaled)
+		if err != nil {
+			return err
+		}
+		err = png.Encode(f, scaleIcon(src, size))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		err = appdir.AddIcon(Icon{Name: name, Path: scaled, Size: size})
+		if err != nil {
+			return err
+		}
+		added = true
+	}
+	if added == false {
+		return errors.New(path + " is smaller than the smallest icon size")
+	}
+	return nil
+}
+
+// scaleIcon returns img padded to a square with transparent pixels and scaled down to size,
+// averaging the pixels of the image that make up each pixel of the icon
+func scaleIcon(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() > side {
+		side = bounds.Dy()
+	}
+	offset := image.Pt((side-bounds.Dx())/2, (side-bounds.Dy())/2)
+	icon := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			y0, y1 := y*side/size, (y+1)*side/size
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					n++
+					p := image.Pt(sx, sy).Sub(offset).Add(bounds.Min)
+					if p.In(bounds) == false {
+						continue // Padding
+					}
+					pr, pg, pb, pa := img.At(p.X, p.Y).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+				}
+			}
+			if n > 0 {
+				icon.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+			}
+		}
+	}
+	return icon
+}
@@ -0,0 +1,33 @@
+package elfdeps
+
+import (
+	"debug/elf"
+	"fmt"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Arch is the architecture of an ELF as far as ld.so is concerned: it only loads libraries
+// with the same class and machine as the ELF that needs them, and skips others with the right name
+type Arch struct {
+	Class   elf.Class
+	Machine elf.Machine
+}
+
+func (a Arch) String() string {
+	bits := 64
+	if a.Class == elf.ELFCLASS32 {
+		bits = 32
+	}
+	return fmt.Sprintf("%s (%d-bit)", a.Machine, bits)
+}
+
+// ReadArch returns the architecture of the ELF at path in fs
+func ReadArch(fs fsys.FS, path string) (Arch, error) {
+	e, closer, err := openELF(fs, path)
+	if err != nil {
+		return Arch{}, err
+	}
+	defer closer.Close()
+	return Arch{Class: e.Class, Machine: e.Machine}, nil
+}
@@ -0,0 +1,72 @@
+package elfdeps
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Directories that glibc ld.so searches by default, after RPATH, LD_LIBRARY_PATH, RUNPATH and ld.so.cache.
+// Multiarch directories such as /usr/lib/x86_64-linux-gnu are system directories as well, see IsSystemDirectory
+var SystemDirectories = []string{"/lib", "/usr/lib", "/lib64", "/usr/lib64", "/lib32", "/usr/lib32", "/libx32", "/usr/libx32"}
+
+// IsSystemDirectory returns true if ld.so searches dir by default, i.e., if it is one of the SystemDirectories
+// or a multiarch directory in /lib or /usr/lib
+func IsSystemDirectory(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, system := range SystemDirectories {
+		if dir == system {
+			return true
+		}
+	}
+	parent := filepath.Dir(dir)
+	return (parent == "/lib" || parent == "/usr/lib") && strings.Contains(filepath.Base(dir), "-linux-")
+}
+
+// SearchConstraints restrict where ld.so looks for the libraries an ELF needs
+type SearchConstraints struct {
+	// The ELF was linked with -z nodeflib: the system directories and the entries of ld.so.cache in them are not searched
+	NoDefaultLib bool
+	// The process runs in secure-execution mode, as setuid or setgid executables and their libraries do:
+	// LD_LIBRARY_PATH is ignored
+	Secure bool
+}
+
+// ReadSearchConstraints returns the constraints for looking up the libraries needed by the ELF at path in fs.
+// Secure is only set for setuid and setgid files, not for the libraries loaded by them, see Walker
+func ReadSearchConstraints(fs fsys.FS, path string) SearchConstraints {
+	var c SearchConstraints
+	if path == "" {
+		return c
+	}
+	if info, err := fs.Stat(path); err == nil && info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		c.Secure = true
+	}
+	e, closer, err := openELF(fs, path)
+	if err != nil {
+		return c
+	}
+	defer closer.Close()
+	c.NoDefaultLib = readFlags1(e)&df1NoDefLib != 0
+	return c
+}
+
+// ConstrainedResolver is a LibraryResolver that can restrict its search like ld.so does
+// for ELFs with the given constraints
+type ConstrainedResolver interface {
+	LibraryResolver
+	ResolveConstrained(name string, needer string, c SearchConstraints) (path string, rule string, err error)
+}
+
+// allows returns false if ld.so would not search location, added due to rule, under the constraints c
+func (c SearchConstraints) allows(location string, rule string) bool {
+	if c.Secure && rule == "LD_LIBRARY_PATH" {
+		return false
+	}
+	if c.NoDefaultLib && IsSystemDirectory(location) && strings.HasPrefix(rule, "RPATH/RUNPATH") == false {
+		return false
+	}
+	return true
+}
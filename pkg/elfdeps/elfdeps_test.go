@@ -0,0 +1,484 @@
+package elfdeps_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/probonopd/go-appimage/internal/elftest"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+func TestSearchPathResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elfdeps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"first", "second"} {
+		os.MkdirAll(filepath.Join(dir, sub), 0755)
+		ioutil.WriteFile(filepath.Join(dir, sub, "libfoo.so.1"), nil, 0644)
+	}
+
+	r := elfdeps.NewSearchPathResolver()
+	r.AddLocation(filepath.Join(dir, "first"), "first rule")
+	r.AddLocation(filepath.Join(dir, "second"), "second rule")
+	r.AddLocation(filepath.Join(dir, "first"), "ignored because the location is already there")
+	path, rule, err := r.Resolve("libfoo.so.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "first", "libfoo.so.1") || rule != "first rule" {
+		t.Errorf("Resolved to %s by %s", path, rule)
+	}
+	if _, _, err = r.Resolve("libbar.so.1", ""); err == nil {
+		t.Error("Resolved a library that does not exist")
+	}
+
+	chain := elfdeps.ChainResolver{r, &elfdeps.CacheResolver{Entries: map[string]string{"libbar.so.1": "/cache/libbar.so.1"}}}
+	if path, _, err = chain.Resolve("libbar.so.1", ""); err != nil || path != "/cache/libbar.so.1" {
+		t.Errorf("Chain resolved libbar.so.1 to %s, %v", path, err)
+	}
+}
+
+func TestWalker(t *testing.T) {
+	executable := "/bin/ls"
+	if _, err := os.Stat(executable); err != nil {
+		t.Skip(executable, "not available")
+	}
+	walker := elfdeps.NewWalker(elfdeps.NewDefaultResolver())
+	var walked []string
+	walker.OnELF = func(path string) { walked = append(walked, path) }
+	err := walker.Walk(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, dependency := range walker.Graph.Dependencies[executable] {
+		if filepath.Base(dependency) == "libc.so.6" {
+			found = true
+			if len(walker.Graph.NeededBy(dependency)) == 0 || walker.Graph.ResolvedBy[dependency] == "" {
+				t.Error("libc.so.6 is not recorded as needed by anything or has no rule")
+			}
+		}
+	}
+	if found == false {
+		t.Error("libc.so.6 is not a dependency of", executable)
+	}
+	if len(walked) != len(walker.Graph.ELFs) {
+		t.Error("OnELF was not called once for each ELF")
+	}
+}
+
+func TestWalkerInMemory(t *testing.T) {
+	fs := fsys.NewMemFS()
+	fs.WriteFile("/app/bin/foo", elftest.Build(elftest.Spec{Needed: []string{"libbar.so.1", "libmissing.so.3", "$ORIGIN/../lib/libqux.so", "/opt/vendor/libquux.so", "libfast.so.3"}}), 0755)
+	fs.WriteFile("/app/lib/libqux.so", elftest.Build(elftest.Spec{}), 0644)
+	fs.WriteFile("/opt/vendor/libquux.so", elftest.Build(elftest.Spec{}), 0644)
+	// Variants for newer CPUs are only used if there is no baseline variant
+	fs.WriteFile("/usr/lib/glibc-hwcaps/x86-64-v3/libbar.so.1", elftest.Build(elftest.Spec{}), 0644)
+	fs.WriteFile("/usr/lib/glibc-hwcaps/x86-64-v3/libfast.so.3", elftest.Build(elftest.Spec{}), 0644)
+	fs.WriteFile("/usr/lib/glibc-hwcaps/x86-64-v2/libfast.so.3", elftest.Build(elftest.Spec{}), 0644)
+	fs.WriteFile("/usr/lib/libbar.so.1.0", elftest.Build(elftest.Spec{Needed: []string{"libbaz.so.2"}, Soname: "libbar.so.1"}), 0644)
+	fs.Symlink("libbar.so.1.0", "/usr/lib/libbar.so.1")
+	fs.WriteFile("/opt/lib/libbaz.so.2", elftest.Build(elftest.Spec{}), 0644)
+	fs.WriteFile("/etc/ld.so.conf", []byte("include /etc/ld.so.conf.d/*.conf\n"), 0644)
+	fs.WriteFile("/etc/ld.so.conf.d/opt.conf", []byte("# Comment\n/opt/lib\n"), 0644)
+
+	r := elfdeps.NewSearchPathResolver()
+	r.FS = fs
+	r.AddLocation("/usr/lib", "default path")
+	for _, location := range elfdeps.ReadLdSoConfFS(fs, "/etc/ld.so.conf") {
+		r.AddLocation(location, "/etc/ld.so.conf")
+	}
+	walker := elfdeps.NewWalker(r)
+	walker.FS = fs
+	err := walker.Walk("/app/bin/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walker.Graph.ELFs) != 6 {
+		t.Error("Walked", walker.Graph.ELFs)
+	}
+	if walker.Graph.ResolvedBy["/opt/lib/libbaz.so.2"] != "/etc/ld.so.conf" {
+		t.Error("libbaz.so.2 was not found using the included ld.so.conf file")
+	}
+	if walker.Graph.ResolvedBy["/app/lib/libqux.so"] != "path in DT_NEEDED of /app/bin/foo" {
+		t.Error("libqux.so was not found by its path relative to $ORIGIN")
+	}
+	if walker.Graph.Dependencies["/app/bin/foo"][0] != "/usr/lib/libbar.so.1" {
+		t.Error("The baseline variant of libbar.so.1 was not preferred:", walker.Graph.Dependencies["/app/bin/foo"])
+	}
+	if walker.Graph.ResolvedBy["/usr/lib/glibc-hwcaps/x86-64-v2/libfast.so.3"] != "default path (glibc-hwcaps/x86-64-v2)" {
+		t.Error("The variant of libfast.so.3 for the oldest CPUs was not used in the absence of a baseline variant")
+	}
+	if len(walker.Graph.Missing["libmissing.so.3"]) != 1 {
+		t.Error("libmissing.so.3 is not recorded as missing")
+	}
+}
+
+func TestSearchConstraints(t *testing.T) {
+	fs := fsys.NewMemFS()
+	fs.WriteFile("/app/bin/foo", elftest.Build(elftest.Spec{Needed: []string{"libbar.so.1"}, Flags1: 0x800}), 0755)
+	fs.WriteFile("/usr/lib/x86_64-linux-gnu/libbar.so.1", elftest.Build(elftest.Spec{}), 0644)
+	fs.WriteFile("/opt/lib/libbar.so.1", elftest.Build(elftest.Spec{}), 0644)
+	r := elfdeps.NewSearchPathResolver()
+	r.FS = fs
+	r.AddLocation("/usr/lib/x86_64-linux-gnu", "default path")
+	r.AddLocation("/opt/lib", "/etc/ld.so.conf")
+
+	// Linked with -z nodeflib, hence the system directories are skipped
+	if path, _, err := r.Resolve("libbar.so.1", "/app/bin/foo"); err != nil || path != "/opt/lib/libbar.so.1" {
+		t.Error("DF_1_NODEFLIB was not honored:", path, err)
+	}
+	if path, _, err := r.Resolve("libbar.so.1", ""); err != nil || path != "/usr/lib/x86_64-linux-gnu/libbar.so.1" {
+		t.Error("Unexpected library without constraints:", path, err)
+	}
+	cache := &elfdeps.CacheResolver{Entries: map[string]string{"libbar.so.1": "/usr/lib/libbar.so.1"}}
+	if _, _, err := cache.ResolveConstrained("libbar.so.1", "", elfdeps.SearchConstraints{NoDefaultLib: true}); err == nil {
+		t.Error("Used an entry of ld.so.cache in a system directory despite DF_1_NODEFLIB")
+	}
+
+	// Setuid executables and the libraries they load ignore LD_LIBRARY_PATH
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/bin", 0755)
+	os.MkdirAll(dir+"/lib", 0755)
+	ioutil.WriteFile(dir+"/bin/su", elftest.Build(elftest.Spec{Needed: []string{"libpam.so.0"}}), 0755)
+	os.Chmod(dir+"/bin/su", 0755|os.ModeSetuid)
+	ioutil.WriteFile(dir+"/lib/libpam.so.0", elftest.Build(elftest.Spec{}), 0644)
+	secure := elfdeps.NewSearchPathResolver()
+	secure.AddLocation(dir+"/lib", "LD_LIBRARY_PATH")
+	walker := elfdeps.NewWalker(secure)
+	if err := walker.Walk(dir + "/bin/su"); err != nil {
+		t.Fatal(err)
+	}
+	if len(walker.Graph.Missing["libpam.so.0"]) != 1 {
+		t.Error("LD_LIBRARY_PATH was used in secure-execution mode:", walker.Graph.Dependencies)
+	}
+}
+
+func TestSymbolVersions(t *testing.T) {
+	fs := fsys.NewMemFS()
+	fs.WriteFile("/app/bin/foo", elftest.Build(elftest.Spec{Needed: []string{"libQt5Core.so.5"},
+		VersionNeeds: map[string][]string{"libQt5Core.so.5": {"Qt_5", "Qt_5.15"}}}), 0755)
+	// The first candidate is too old, the second one has all versions foo needs
+	fs.WriteFile("/opt/old/lib/libQt5Core.so.5", elftest.Build(elftest.Spec{VersionDefinitions: []string{"Qt_5", "Qt_5.9"}}), 0644)
+	fs.WriteFile("/usr/lib/libQt5Core.so.5", elftest.Build(elftest.Spec{VersionDefinitions: []string{"Qt_5", "Qt_5.9", "Qt_5.15"}}), 0644)
+
+	needs, err := elfdeps.ReadVersionNeeds(fs, "/app/bin/foo")
+	if err != nil || strings.Join(needs["libQt5Core.so.5"], ",") != "Qt_5,Qt_5.15" {
+		t.Error("Unexpected version needs:", needs, err)
+	}
+	r := elfdeps.NewSearchPathResolver()
+	r.FS = fs
+	r.AddLocation("/opt/old/lib", "/etc/ld.so.conf")
+	r.AddLocation("/usr/lib", "default path")
+	walker := elfdeps.NewWalker(r)
+	walker.FS = fs
+	if err := walker.Walk("/app/bin/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if walker.Graph.Dependencies["/app/bin/foo"][0] != "/usr/lib/libQt5Core.so.5" || len(walker.Graph.MissingVersions) != 0 {
+		t.Error("The library with the needed versions was not preferred:", walker.Graph.Dependencies, walker.Graph.MissingVersions)
+	}
+
+	// Without a candidate that has all versions, the first one is used and the versions are reported
+	fs.Remove("/usr/lib/libQt5Core.so.5")
+	walker = elfdeps.NewWalker(elfdeps.NewSearchPathResolver())
+	walker.Resolver.(*elfdeps.SearchPathResolver).FS = fs
+	walker.Resolver.(*elfdeps.SearchPathResolver).AddLocation("/opt/old/lib", "/etc/ld.so.conf")
+	walker.FS = fs
+	if err := walker.Walk("/app/bin/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(walker.Graph.MissingVersions["/app/bin/foo"], ",") != "libQt5Core.so.5: Qt_5.15" {
+		t.Error("Missing version not reported:", walker.Graph.MissingVersions)
+	}
+
+	// Like ld.so, weak needs and libraries without version definitions are not reported
+	fs.WriteFile("/app/bin/bar", elftest.Build(elftest.Spec{Needed: []string{"libweak.so.1", "libnover.so.1"},
+		VersionNeeds: map[string][]string{"libweak.so.1": {"WEAK_1", "WEAK_2"}, "libnover.so.1": {"NOVER_1"}},
+		WeakVersions: []string{"WEAK_2"}}), 0755)
+	fs.WriteFile("/usr/lib/libweak.so.1", elftest.Build(elftest.Spec{VersionDefinitions: []string{"WEAK_1"}}), 0644)
+	fs.WriteFile("/usr/lib/libnover.so.1", elftest.Build(elftest.Spec{}), 0644)
+	needs, err = elfdeps.ReadVersionNeeds(fs, "/app/bin/bar")
+	if err != nil || strings.Join(needs["libweak.so.1"], ",") != "WEAK_1" {
+		t.Error("Unexpected version needs:", needs, err)
+	}
+	walker = elfdeps.NewWalker(elfdeps.NewSearchPathResolver())
+	walker.Resolver.(*elfdeps.SearchPathResolver).FS = fs
+	walker.Resolver.(*elfdeps.SearchPathResolver).AddLocation("/usr/lib", "default path")
+	walker.FS = fs
+	if err := walker.Walk("/app/bin/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if len(walker.Graph.Dependencies["/app/bin/bar"]) != 2 || len(walker.Graph.MissingVersions) != 0 {
+		t.Error("Unexpected missing versions:", walker.Graph.Dependencies, walker.Graph.MissingVersions)
+	}
+}
+
+func TestConflictsAndPins(t *testing.T) {
+	fs := fsys.NewMemFS()
+	fs.WriteFile("/app/bin/foo", elftest.Build(elftest.Spec{Needed: []string{"libssl.so.3", "libz.so.1"}}), 0755)
+	fs.WriteFile("/opt/ssl/lib/libssl.so.3", elftest.Build(elftest.Spec{VersionDefinitions: []string{"OPENSSL_3.0.0", "OPENSSL_3.2.0"}}), 0644)
+	fs.WriteFile("/usr/lib/libssl.so.3", elftest.Build(elftest.Spec{VersionDefinitions: []string{"OPENSSL_3.0.0"}}), 0644)
+	// Copies of the same library do not conflict
+	fs.WriteFile("/opt/ssl/lib/libz.so.1", elftest.Build(elftest.Spec{}), 0644)
+	fs.WriteFile("/usr/lib/libz.so.1", elftest.Build(elftest.Spec{}), 0644)
+
+	r := elfdeps.NewSearchPathResolver()
+	r.FS = fs
+	r.AddLocation("/opt/ssl/lib", "LD_LIBRARY_PATH")
+	r.AddLocation("/usr/lib", "default path")
+	walker := elfdeps.NewWalker(r)
+	walker.FS = fs
+	if err := walker.Walk("/app/bin/foo"); err != nil {
+		t.Fatal(err)
+	}
+	conflicts := r.Conflicts()
+	if strings.Join(conflicts["libssl.so.3"], ",") != "/opt/ssl/lib/libssl.so.3,/usr/lib/libssl.so.3" || len(conflicts) != 1 {
+		t.Error("Unexpected conflicts:", conflicts)
+	}
+
+	r = elfdeps.NewSearchPathResolver()
+	r.FS = fs
+	r.AddLocation("/opt/ssl/lib", "LD_LIBRARY_PATH")
+	r.Pin("libssl.so.3", "/usr/lib/libssl.so.3", "pinned")
+	path, rule, err := r.Resolve("libssl.so.3", "/app/bin/foo")
+	if path != "/usr/lib/libssl.so.3" || rule != "pinned" || err != nil {
+		t.Error("Pin not honored:", path, rule, err)
+	}
+
+	// Pins only apply to ELFs of the same architecture
+	fs.WriteFile("/opt/arm64/lib/libssl.so.3", elftest.Build(elftest.Spec{Machine: elf.EM_AARCH64}), 0644)
+	r = elfdeps.NewSearchPathResolver()
+	r.FS = fs
+	r.AddLocation("/opt/ssl/lib", "LD_LIBRARY_PATH")
+	r.Pin("libssl.so.3", "/opt/arm64/lib/libssl.so.3", "pinned")
+	path, _, err = r.Resolve("libssl.so.3", "/app/bin/foo")
+	if path != "/opt/ssl/lib/libssl.so.3" || err != nil {
+		t.Error("Pin for another architecture was used:", path, err)
+	}
+	r.Pin("libssl.so.3", "/usr/lib/libssl.so.3", "pinned")
+	path, _, err = r.Resolve("libssl.so.3", "/app/bin/foo")
+	if path != "/usr/lib/libssl.so.3" || err != nil {
+		t.Error("Pin for the architecture of the ELF not honored:", path, err)
+	}
+
+	// Files of the same size are only the same if their contents are
+	fs.WriteFile("/opt/ssl/lib/libcrypto.so.3", elftest.Build(elftest.Spec{VersionDefinitions: []string{"OPENSSL_3.0.1"}}), 0644)
+	fs.WriteFile("/usr/lib/libcrypto.so.3", elftest.Build(elftest.Spec{VersionDefinitions: []string{"OPENSSL_3.0.2"}}), 0644)
+	r.AddLocation("/usr/lib", "default path")
+	r.Resolve("libcrypto.so.3", "/app/bin/foo")
+	if len(r.Conflicts()["libcrypto.so.3"]) != 2 {
+		t.Error("Different files of the same size do not conflict:", r.Conflicts())
+	}
+}
+
+func TestMuslLocations(t *testing.T) {
+	fs := fsys.NewMemFS()
+	fs.WriteFile("/lib/ld-musl-x86_64.so.1", []byte(""), 0755)
+	locations := elfdeps.MuslLocations(fs, "/lib/ld-musl-x86_64.so.1")
+	if strings.Join(locations, ":") != "/lib:/usr/local/lib:/usr/lib" {
+		t.Error("Unexpected default locations", locations)
+	}
+	fs.WriteFile("/etc/ld-musl-x86_64.path", []byte("/lib:/usr/lib\n/opt/lib\n"), 0644)
+	locations = elfdeps.MuslLocations(fs, "/lib/ld-musl-x86_64.so.1")
+	if strings.Join(locations, ":") != "/lib:/usr/lib:/opt/lib" {
+		t.Error("Unexpected locations from the path file", locations)
+	}
+
+	// On glibc systems, only the musl sysroot is searched if there is no path file
+	fs = fsys.NewMemFS()
+	fs.WriteFile("/etc/ld.so.cache", []byte(""), 0644)
+	fs.WriteFile("/usr/lib/x86_64-linux-musl/libc.so", []byte(""), 0755)
+	fs.Symlink("/usr/lib/x86_64-linux-musl/libc.so", "/lib/ld-musl-x86_64.so.1")
+	fs.MkdirAll("/usr/lib/musl/lib", 0755)
+	locations = elfdeps.MuslLocations(fs, "/lib/ld-musl-x86_64.so.1")
+	if strings.Join(locations, ":") != "/usr/lib/x86_64-linux-musl:/usr/lib/musl/lib" {
+		t.Error("Unexpected locations on a glibc system", locations)
+	}
+	fs = fsys.NewMemFS()
+	fs.WriteFile("/lib64/ld-linux-x86-64.so.2", []byte(""), 0755)
+	fs.WriteFile("/usr/lib/libc.musl.so", []byte(""), 0755)
+	fs.Symlink("/usr/lib/libc.musl.so", "/lib/ld-musl-x86_64.so.1")
+	if locations = elfdeps.MuslLocations(fs, "/lib/ld-musl-x86_64.so.1"); len(locations) != 0 {
+		t.Error("The glibc library directories are searched for musl libraries", locations)
+	}
+
+	// Alpine Linux with gcompat, which installs the dynamic linker of glibc
+	fs = fsys.NewMemFS()
+	fs.WriteFile("/lib/ld-musl-x86_64.so.1", []byte(""), 0755)
+	fs.Symlink("ld-musl-x86_64.so.1", "/lib/libc.musl-x86_64.so.1")
+	fs.WriteFile("/lib/ld-linux-x86-64.so.2", []byte(""), 0755)
+	locations = elfdeps.MuslLocations(fs, "/lib/ld-musl-x86_64.so.1")
+	if strings.Join(locations, ":") != "/lib:/usr/local/lib:/usr/lib" {
+		t.Error("Unexpected locations on a musl system with gcompat", locations)
+	}
+}
+
+func TestResolveArchitecture(t *testing.T) {
+	fs := fsys.NewMemFS()
+	fs.WriteFile("/app/bin/foo", elftest.Build(elftest.Spec{Needed: []string{"libbar.so.1"}}), 0755)
+	fs.WriteFile("/app/bin/helper", elftest.Build(elftest.Spec{Needed: []string{"libbar.so.1"}, Machine: elf.EM_AARCH64}), 0755)
+	fs.WriteFile("/usr/lib/aarch64-linux-gnu/libbar.so.1", elftest.Build(elftest.Spec{Machine: elf.EM_AARCH64}), 0644)
+	fs.WriteFile("/usr/lib/x86_64-linux-gnu/libbar.so.1", elftest.Build(elftest.Spec{}), 0644)
+
+	r := elfdeps.NewSearchPathResolver()
+	r.FS = fs
+	r.AddLocation("/usr/lib/aarch64-linux-gnu", "/etc/ld.so.conf")
+	r.AddLocation("/usr/lib/x86_64-linux-gnu", "/etc/ld.so.conf")
+	for needer, expected := range map[string]string{
+		"/app/bin/foo":    "/usr/lib/x86_64-linux-gnu/libbar.so.1",
+		"/app/bin/helper": "/usr/lib/aarch64-linux-gnu/libbar.so.1",
+	} {
+		path, _, err := r.Resolve("libbar.so.1", needer)
+		if err != nil || path != expected {
+			t.Errorf("Resolved libbar.so.1 for %s to %s (%v), expected %s", needer, path, err, expected)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	mem := fsys.NewMemFS()
+	mem.WriteFile("/lib/libfoo.so.1", elftest.Build(elftest.Spec{Soname: "libfoo.so.1"}), 0644)
+	info, err := elfdeps.Classify(mem, "/lib/libfoo.so.1")
+	if err != nil || info.Kind != elfdeps.KindSharedLibrary || info.Go || info.Kind.Static() {
+		t.Error("Unexpected classification of a shared library:", info, err)
+	}
+	if info.Soname != "libfoo.so.1" {
+		t.Error("Unexpected soname:", info.Soname)
+	}
+	// Named like a library, but an executable
+	mem.WriteFile("/usr/bin/foo.so", elftest.Build(elftest.Spec{Interpreter: "/lib64/ld-linux-x86-64.so.2"}), 0755)
+	info, err = elfdeps.Classify(mem, "/usr/bin/foo.so")
+	if err != nil || info.Kind != elfdeps.KindPIE || info.Kind.Executable() == false || info.Interpreter != "/lib64/ld-linux-x86-64.so.2" {
+		t.Error("Unexpected classification of a PIE:", info, err)
+	}
+	mem.WriteFile("/usr/lib/foo.o", elftest.Build(elftest.Spec{Type: elf.ET_REL}), 0644)
+	info, err = elfdeps.Classify(mem, "/usr/lib/foo.o")
+	if err != nil || info.Kind != elfdeps.KindObject || info.Kind.Executable() {
+		t.Error("Unexpected classification of an object file:", info, err)
+	}
+	mem.WriteFile("/usr/lib/libfoo.so.source", []byte("not an ELF"), 0644)
+	for path, loadable := range map[string]bool{"/lib/libfoo.so.1": true, "/usr/bin/foo.so": true, "/usr/lib/foo.o": false, "/usr/lib/libfoo.so.source": false} {
+		if elfdeps.IsLoadable(mem, path) != loadable {
+			t.Error("IsLoadable is wrong for", path)
+		}
+	}
+
+	// The test binary is built by the Go toolchain
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err = elfdeps.Classify(fsys.OS, executable); err != nil || info.Go == false {
+		t.Error("Go binary not recognized:", info, err)
+	}
+
+	if _, err := os.Stat("/bin/ls"); err == nil {
+		info, err = elfdeps.Classify(fsys.OS, "/bin/ls")
+		if err != nil || info.Interpreter == "" || info.Kind.Static() {
+			t.Error("Unexpected classification of /bin/ls:", info, err)
+		}
+	}
+}
+
+// buildLdSoCache returns an ld.so.cache with the entries in the old format, the new format, or both
+func buildLdSoCache(oldFormat bool, newFormat bool, entries []elfdeps.LdSoCacheEntry) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	// The strings follow the entries, the first one at offset
+	writeEntries := func(offset int, entrySize int) {
+		var strtab bytes.Buffer
+		for _, entry := range entries {
+			binary.Write(&buf, le, entry.Flags)
+			binary.Write(&buf, le, uint32(offset+strtab.Len()))
+			strtab.WriteString(entry.Name + "\x00")
+			binary.Write(&buf, le, uint32(offset+strtab.Len()))
+			strtab.WriteString(entry.Path + "\x00")
+			if entrySize == 24 {
+				binary.Write(&buf, le, uint32(0))
+				binary.Write(&buf, le, entry.Hwcap)
+			}
+		}
+		buf.Write(strtab.Bytes())
+	}
+	if oldFormat {
+		buf.WriteString("ld.so-1.7.0\x00")
+		binary.Write(&buf, le, uint32(len(entries)))
+		if newFormat {
+			// Only the new format is read then
+			buf.Write(make([]byte, len(entries)*12))
+			buf.Write(make([]byte, (8-buf.Len()%8)%8))
+		} else {
+			// Its strings are at offsets from the end of the entries
+			writeEntries(0, 12)
+		}
+	}
+	if newFormat {
+		buf.WriteString("glibc-ld.so.cache1.1")
+		binary.Write(&buf, le, uint32(len(entries)))
+		binary.Write(&buf, le, uint32(0))
+		buf.Write(make([]byte, 20))
+		// Its strings are at offsets from the start of its header
+		writeEntries(48+len(entries)*24, 24)
+	}
+	return buf.Bytes()
+}
+
+func TestLdSoCache(t *testing.T) {
+	entries := []elfdeps.LdSoCacheEntry{
+		{Name: "libz.so.1", Path: "/usr/lib/x86_64-linux-gnu/glibc-hwcaps/x86-64-v3/libz.so.1", Flags: 0x0303, Hwcap: 1 << 62},
+		{Name: "libz.so.1", Path: "/usr/lib/x86_64-linux-gnu/libz.so.1", Flags: 0x0303},
+		{Name: "libz.so.1", Path: "/usr/lib/i386-linux-gnu/libz.so.1", Flags: 0x0003},
+		{Name: "libc.so.6", Path: "/lib/x86_64-linux-gnu/libc.so.6", Flags: 0x0303},
+	}
+	fs := fsys.NewMemFS()
+	for i, format := range []struct{ old, new bool }{{false, true}, {true, true}, {true, false}} {
+		fs.WriteFile("/etc/ld.so.cache", buildLdSoCache(format.old, format.new, entries), 0644)
+		read, err := elfdeps.ReadLdSoCache(fs, "/etc/ld.so.cache")
+		if err != nil || len(read) != len(entries) {
+			t.Fatal("Could not read cache", i, read, err)
+		}
+		for j, entry := range read {
+			if format.new == false {
+				// The old format has no hwcaps
+				entry.Hwcap = entries[j].Hwcap
+			}
+			if entry != entries[j] {
+				t.Errorf("Unexpected entry %d of cache %d: %+v", j, i, entry)
+			}
+		}
+	}
+
+	fs.WriteFile("/etc/ld.so.cache", buildLdSoCache(true, true, entries)[:100], 0644)
+	if _, err := elfdeps.ReadLdSoCache(fs, "/etc/ld.so.cache"); err == nil {
+		t.Error("Truncated cache was accepted")
+	}
+
+	// The cache of this system, if it has one
+	libraries, err := elfdeps.ParseLdSoCache("/etc/ld.so.cache")
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil || len(libraries) == 0 {
+		t.Fatal("Could not parse /etc/ld.so.cache:", err)
+	}
+	r, err := elfdeps.NewCacheResolver(elfdeps.ParseLdSoCache, "/etc/ld.so.cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, path := range libraries {
+		if found, rule, err := r.Resolve(name, ""); found != path || rule != "/etc/ld.so.cache" || err != nil {
+			t.Error("Unexpected resolution of", name, found, rule, err)
+		}
+		break
+	}
+}
@@ -0,0 +1,159 @@
+// Package elfdeps determines the libraries that ELF files need, like ldd does
+// but without running them, using a pluggable LibraryResolver.
+// The result is a dependency Graph, e.g.,
+//
+//	walker := elfdeps.NewWalker(elfdeps.NewDefaultResolver())
+//	err := walker.Walk("/usr/bin/foo")
+//	fmt.Println(walker.Graph.Dependencies["/usr/bin/foo"], walker.Graph.Missing)
+package elfdeps
+
+import (
+	"strings"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Graph is the graph of ELFs and the libraries they need
+type Graph struct {
+	// All ELFs that were walked, in the order in which they were walked
+	ELFs []string
+	// Key: Path of an ELF, value: names of the libraries it needs (DT_NEEDED)
+	Needed map[string][]string
+	// Key: Path of an ELF, value: paths of the libraries it needs as they were resolved
+	Dependencies map[string][]string
+	// Key: Name of a library that could not be found, value: paths of the ELFs that need it
+	Missing map[string][]string
+	// Key: Path of a library as it was resolved, value: the rule by which it was found
+	ResolvedBy map[string]string
+	// Key: Path of an ELF, value: the symbol versions it needs that the libraries it was resolved to
+	// do not define, as "libfoo.so.1: FOO_1.2", which make ld.so refuse to load it
+	MissingVersions map[string][]string
+}
+
+// NewGraph returns an empty graph
+func NewGraph() *Graph {
+	return &Graph{
+		Needed:          make(map[string][]string),
+		Dependencies:    make(map[string][]string),
+		Missing:         make(map[string][]string),
+		ResolvedBy:      make(map[string]string),
+		MissingVersions: make(map[string][]string),
+	}
+}
+
+// Contains returns true if the ELF at path was walked
+func (g *Graph) Contains(path string) bool {
+	_, ok := g.Needed[path]
+	return ok
+}
+
+// NeededBy returns the paths of the ELFs that need the library at path
+func (g *Graph) NeededBy(path string) []string {
+	var neededBy []string
+	for _, elfPath := range g.ELFs {
+		for _, dependency := range g.Dependencies[elfPath] {
+			if dependency == path {
+				neededBy = append(neededBy, elfPath)
+			}
+		}
+	}
+	return neededBy
+}
+
+// Walker adds ELFs and, recursively, the libraries they need to a Graph
+type Walker struct {
+	// FS is the filesystem from which the ELFs are read; fsys.OS if nil.
+	// The resolver needs to look for libraries in the same filesystem
+	FS       fsys.FS
+	Resolver LibraryResolver
+	Graph    *Graph
+	// OnELF, if set, is called for each ELF before the libraries it needs are resolved,
+	// e.g., to add the directories in its RPATH to the resolver
+	OnELF func(path string)
+	// OnError, if set, is called for libraries that cannot be read while walking
+	OnError func(path string, err error)
+	// Whether the ELF being walked is loaded by a setuid or setgid executable
+	secure bool
+}
+
+// NewWalker returns a walker that resolves libraries with resolver into a new Graph
+func NewWalker(resolver LibraryResolver) *Walker {
+	return &Walker{Resolver: resolver, Graph: NewGraph()}
+}
+
+// Walk adds the ELF at path and all libraries it needs to the graph.
+// Libraries that cannot be resolved are recorded in Graph.Missing.
+// Returns an error if the ELF at path cannot be read
+func (w *Walker) Walk(path string) error {
+	if w.Graph.Contains(path) {
+		return nil
+	}
+	fs := w.FS
+	if fs == nil {
+		fs = fsys.OS
+	}
+	e, closer, err := openELF(fs, path)
+	if err != nil {
+		return err
+	}
+	// ImportedLibraries returns the names of all libraries
+	// referred to by the binary f that are expected to be
+	// linked with the binary at dynamic link time.
+	needed, err := e.ImportedLibraries()
+	closer.Close()
+	if err != nil {
+		return err
+	}
+	w.Graph.ELFs = append(w.Graph.ELFs, path)
+	w.Graph.Needed[path] = needed
+	if w.OnELF != nil {
+		w.OnELF(path)
+	}
+
+	// Secure-execution mode applies to the whole process, i.e., to the libraries of setuid executables, too
+	constraints := ReadSearchConstraints(fs, path)
+	if constraints.Secure && w.secure == false {
+		w.secure = true
+		defer func() { w.secure = false }()
+	}
+	constraints.Secure = w.secure
+	versionNeeds, _ := ReadVersionNeeds(fs, path)
+
+	for _, name := range needed {
+		var lib, rule string
+		if strings.Contains(name, "/") {
+			lib, err = ResolvePath(fs, name, path)
+			rule = "path in DT_NEEDED of " + path
+		} else {
+			lib, rule, err = resolveConstrained(w.Resolver, name, path, constraints)
+		}
+		if err != nil {
+			// Do not give up on the first missing library; all of them can be reported at the end
+			w.Graph.Missing[name] = appendIfMissing(w.Graph.Missing[name], path)
+			continue
+		}
+		w.Graph.ResolvedBy[lib] = rule
+		if len(versionNeeds[name]) > 0 {
+			// A library whose definitions cannot be read is taken to have none, hence to satisfy all needs
+			definitions, _ := ReadVersionDefinitions(fs, lib)
+			for _, version := range missingVersions(versionNeeds[name], definitions) {
+				w.Graph.MissingVersions[path] = appendIfMissing(w.Graph.MissingVersions[path], name+": "+version)
+			}
+		}
+		w.Graph.Dependencies[path] = appendIfMissing(w.Graph.Dependencies[path], lib)
+		err = w.Walk(lib)
+		if err != nil && w.OnError != nil {
+			w.OnError(lib, err)
+		}
+	}
+	return nil
+}
+
+func appendIfMissing(slice []string, s string) []string {
+	for _, existing := range slice {
+		if existing == s {
+			return slice
+		}
+	}
+	return append(slice, s)
+}
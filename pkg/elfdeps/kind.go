@@ -0,0 +1,163 @@
+package elfdeps
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Kind is how an ELF is linked, which decides whether it has libraries to resolve and an interpreter to deploy
+type Kind string
+
+const (
+	KindStatic        Kind = "static"         // Executable without interpreter and libraries, loaded at a fixed address
+	KindStaticPIE     Kind = "static-pie"     // Position-independent executable without interpreter that relocates itself
+	KindDynamic       Kind = "dynamic"        // Executable with an interpreter, loaded at a fixed address
+	KindPIE           Kind = "pie"            // Position-independent executable with an interpreter
+	KindSharedLibrary Kind = "shared-library" // Shared object without interpreter
+	KindObject        Kind = "object"         // Relocatable object (.o), core dump or other ELF that can be neither run nor loaded
+)
+
+// Flags in DT_FLAGS_1, not defined by debug/elf before Go 1.21
+const (
+	df1NoDefLib = 0x00000800 // DF_1_NODEFLIB, set by ld -z nodeflib
+	df1PIE      = 0x08000000 // DF_1_PIE
+)
+
+// Static returns true if the ELF does not use the dynamic linker
+func (k Kind) Static() bool {
+	return k == KindStatic || k == KindStaticPIE
+}
+
+// Executable returns true if the ELF can be run, as opposed to a shared library or an object
+func (k Kind) Executable() bool {
+	return k == KindStatic || k == KindStaticPIE || k == KindDynamic || k == KindPIE
+}
+
+// Info describes how an ELF is linked
+type Info struct {
+	Kind        Kind
+	Interpreter string // PT_INTERP, if any
+	Soname      string // DT_SONAME, if any
+	Go          bool   // Built by the Go toolchain
+}
+
+func (i Info) String() string {
+	s := string(i.Kind)
+	if i.Go {
+		s += " (Go)"
+	}
+	return s
+}
+
+// Classify returns how the ELF at path in fs is linked. Unlike ImportedLibraries, which
+// does not tell an ELF without libraries from one without a dynamic section, this distinguishes
+// static executables, static PIEs (which have a dynamic section only to relocate themselves),
+// executables with an interpreter with and without PIE, shared libraries, and objects that can be neither
+func Classify(fs fsys.FS, path string) (Info, error) {
+	e, closer, err := openELF(fs, path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer closer.Close()
+	var info Info
+	for _, prog := range e.Progs {
+		if prog.Type == elf.PT_INTERP {
+			data, err := ioutil.ReadAll(prog.Open())
+			if err != nil {
+				return Info{}, err
+			}
+			info.Interpreter = string(bytes.TrimRight(data, "\x00"))
+		}
+	}
+	for _, name := range []string{".go.buildinfo", ".note.go.buildid", ".gopclntab"} {
+		if e.Section(name) != nil {
+			info.Go = true
+		}
+	}
+	// Some libraries, such as libc.so.6, can also be run and hence have an interpreter
+	soname, _ := e.DynString(elf.DT_SONAME)
+	if len(soname) > 0 {
+		info.Soname = soname[0]
+	}
+	switch {
+	case e.Type != elf.ET_EXEC && e.Type != elf.ET_DYN:
+		info.Kind = KindObject
+	case e.Type == elf.ET_EXEC && info.Interpreter == "":
+		info.Kind = KindStatic
+	case e.Type == elf.ET_EXEC:
+		info.Kind = KindDynamic
+	case info.Interpreter != "" && info.Soname == "":
+		info.Kind = KindPIE
+	case hasPIEFlag(e):
+		info.Kind = KindStaticPIE
+	default:
+		info.Kind = KindSharedLibrary
+	}
+	return info, nil
+}
+
+// IsLoadable returns true if the file at path in fs is an ELF executable or shared object
+// rather than, e.g., a relocatable object or a core dump. Only reads the ELF header,
+// hence it is much cheaper than Classify for telling ELFs from other files
+func IsLoadable(fs fsys.FS, path string) bool {
+	f, err := fs.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	// e_ident followed by e_type
+	var header [elf.EI_NIDENT + 2]byte
+	_, err = io.ReadFull(f, header[:])
+	if err != nil || string(header[:4]) != elf.ELFMAG {
+		return false
+	}
+	var typ elf.Type
+	switch elf.Data(header[elf.EI_DATA]) {
+	case elf.ELFDATA2LSB:
+		typ = elf.Type(binary.LittleEndian.Uint16(header[elf.EI_NIDENT:]))
+	case elf.ELFDATA2MSB:
+		typ = elf.Type(binary.BigEndian.Uint16(header[elf.EI_NIDENT:]))
+	default:
+		return false
+	}
+	return typ == elf.ET_EXEC || typ == elf.ET_DYN
+}
+
+// hasPIEFlag returns true if DF_1_PIE is set in DT_FLAGS_1 of the ELF, as linkers do for PIEs
+func hasPIEFlag(e *elf.File) bool {
+	return readFlags1(e)&df1PIE != 0
+}
+
+// readFlags1 returns DT_FLAGS_1 of the ELF, or 0 if it has none
+func readFlags1(e *elf.File) uint64 {
+	ds := e.SectionByType(elf.SHT_DYNAMIC)
+	if ds == nil {
+		return 0
+	}
+	data, err := ds.Data()
+	if err != nil {
+		return 0
+	}
+	size := 8
+	if e.Class == elf.ELFCLASS64 {
+		size = 16
+	}
+	for len(data) >= size {
+		var tag, val uint64
+		if e.Class == elf.ELFCLASS64 {
+			tag, val = e.ByteOrder.Uint64(data[0:8]), e.ByteOrder.Uint64(data[8:16])
+		} else {
+			tag, val = uint64(e.ByteOrder.Uint32(data[0:4])), uint64(e.ByteOrder.Uint32(data[4:8]))
+		}
+		if elf.DynTag(tag) == elf.DT_FLAGS_1 {
+			return val
+		}
+		data = data[size:]
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+package elfdeps
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Magic strings of the formats of ld.so.cache. glibc before 2.32 writes the old format followed by the new one,
+// later versions only the new one
+const (
+	ldSoCacheOldMagic = "ld.so-1.7.0"
+	ldSoCacheNewMagic = "glibc-ld.so.cache1.1"
+)
+
+// Sizes of the headers and entries of both formats, see sysdeps/generic/dl-cache.h in glibc
+const (
+	ldSoCacheOldHeaderSize = 16 // magic, padded to 12 bytes, and nlibs
+	ldSoCacheOldEntrySize  = 12 // flags, key, value
+	ldSoCacheNewHeaderSize = 48 // magic and version, nlibs, len_strings, flags, padding, extension_offset, unused
+	ldSoCacheNewEntrySize  = 24 // flags, key, value, osversion, hwcap
+)
+
+// Values of the flags of the new format that say the cache is big-endian
+const ldSoCacheBigEndian = 3
+
+// The bits of the flags of an entry that say which architecture the library is for, e.g., 0x0300 for x86-64
+const ldSoCacheRequiredMask = 0xff00
+
+// The architecture flags of the libraries that ld.so uses on each architecture, for the architectures that have them.
+// On others, all entries are used
+var ldSoCacheRequiredFlags = map[string]int32{
+	"386":     0x0000,
+	"amd64":   0x0300,
+	"arm":     0x0900,
+	"arm64":   0x0a00,
+	"ppc64":   0x0500,
+	"ppc64le": 0x0500,
+	"s390x":   0x0400,
+}
+
+// LdSoCacheEntry is a library listed in ld.so.cache
+type LdSoCacheEntry struct {
+	Name  string // Soname, e.g., libz.so.1
+	Path  string
+	Flags int32  // Kind of the library in the low byte, e.g., 3 for glibc, and its architecture in the high byte
+	Hwcap uint64 // Not zero for the variants of libraries for newer CPUs, e.g., in glibc-hwcaps subdirectories
+}
+
+// ReadLdSoCache returns the entries of the ld.so.cache at path in fs, in the order of preference of ld.so.
+// Both the format of glibc 2.32 and later ("glibc-ld.so.cache1.1") and the old one ("ld.so-1.7.0"),
+// which older versions write before the new one, are supported
+func ReadLdSoCache(fs fsys.FS, path string) ([]LdSoCacheEntry, error) {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseLdSoCache(data)
+}
+
+// ParseLdSoCache is a CacheParser for the ld.so.cache at path, e.g., /etc/ld.so.cache.
+// Like ld.so, it maps each library to the first entry for the architecture of this system,
+// leaving out the variants for newer CPUs
+func ParseLdSoCache(path string) (map[string]string, error) {
+	entries, err := ReadLdSoCache(fsys.OS, path)
+	if err != nil {
+		return nil, err
+	}
+	required, filter := ldSoCacheRequiredFlags[runtime.GOARCH]
+	libraries := make(map[string]string)
+	for _, entry := range entries {
+		if _, ok := libraries[entry.Name]; ok || entry.Hwcap != 0 {
+			continue
+		}
+		if filter && entry.Flags&ldSoCacheRequiredMask != required {
+			continue
+		}
+		libraries[entry.Name] = entry.Path
+	}
+	return libraries, nil
+}
+
+// parseLdSoCache returns the entries of the ld.so.cache in data
+func parseLdSoCache(data []byte) ([]LdSoCacheEntry, error) {
+	if bytes.HasPrefix(data, []byte(ldSoCacheNewMagic)) {
+		return parseNewLdSoCache(data)
+	}
+	if bytes.HasPrefix(data, []byte(ldSoCacheOldMagic)) == false || len(data) < ldSoCacheOldHeaderSize {
+		return nil, errors.New("not an ld.so.cache")
+	}
+	count := int(binary.LittleEndian.Uint32(data[12:]))
+	if count > (len(data)-ldSoCacheOldHeaderSize)/ldSoCacheOldEntrySize {
+		return nil, errors.New("ld.so.cache is truncated")
+	}
+	// The new format follows the entries of the old one, aligned like its 64-bit fields,
+	// which only need 4 bytes on some 32-bit architectures
+	end := ldSoCacheOldHeaderSize + count*ldSoCacheOldEntrySize
+	for _, newStart := range []int{(end + 7) &^ 7, end} {
+		if newStart <= len(data) && bytes.HasPrefix(data[newStart:], []byte(ldSoCacheNewMagic)) {
+			return parseNewLdSoCache(data[newStart:])
+		}
+	}
+	// The strings of the old format follow its entries
+	strtab := data[end:]
+	entries := make([]LdSoCacheEntry, 0, count)
+	for i := 0; i < count; i++ {
+		entry := data[ldSoCacheOldHeaderSize+i*ldSoCacheOldEntrySize:]
+		entries = append(entries, LdSoCacheEntry{
+			Flags: int32(binary.LittleEndian.Uint32(entry)),
+			Name:  cString(strtab, binary.LittleEndian.Uint32(entry[4:])),
+			Path:  cString(strtab, binary.LittleEndian.Uint32(entry[8:])),
+		})
+	}
+	return entries, nil
+}
+
+// parseNewLdSoCache returns the entries of the ld.so.cache in the new format in data,
+// whose strings are at offsets from the start of data
+func parseNewLdSoCache(data []byte) ([]LdSoCacheEntry, error) {
+	if len(data) < ldSoCacheNewHeaderSize {
+		return nil, errors.New("ld.so.cache is truncated")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[28]&3 == ldSoCacheBigEndian {
+		order = binary.BigEndian
+	}
+	count := int(order.Uint32(data[20:]))
+	if count > (len(data)-ldSoCacheNewHeaderSize)/ldSoCacheNewEntrySize {
+		return nil, errors.New("ld.so.cache is truncated")
+	}
+	entries := make([]LdSoCacheEntry, 0, count)
+	for i := 0; i < count; i++ {
+		entry := data[ldSoCacheNewHeaderSize+i*ldSoCacheNewEntrySize:]
+		entries = append(entries, LdSoCacheEntry{
+			Flags: int32(order.Uint32(entry)),
+			Name:  cString(data, order.Uint32(entry[4:])),
+			Path:  cString(data, order.Uint32(entry[8:])),
+			Hwcap: order.Uint64(entry[16:]),
+		})
+	}
+	return entries, nil
+}
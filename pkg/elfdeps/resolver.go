@@ -0,0 +1,482 @@
+package elfdeps
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// LibraryResolver finds the file of a library that an ELF needs
+type LibraryResolver interface {
+	// Resolve returns the path of the library with the given name (as in DT_NEEDED)
+	// needed by the ELF at needer, which may be empty, and the rule by which it was found,
+	// e.g., "default path" or "RPATH/RUNPATH of /usr/bin/foo"
+	Resolve(name string, needer string) (path string, rule string, err error)
+}
+
+// Directories in which libraries are commonly found
+var DefaultLocations = []string{"/usr/lib64", "/lib64", "/usr/lib", "/lib",
+	"/usr/lib/x86_64-linux-gnu/libfakeroot",
+	"/usr/local/lib",
+	"/usr/local/lib/x86_64-linux-gnu",
+	"/lib/x86_64-linux-gnu",
+	"/usr/lib/x86_64-linux-gnu",
+	"/lib32",
+	"/usr/lib32"}
+
+// Subdirectories of library directories with variants of libraries for newer CPUs, which ld.so
+// prefers if the CPU supports them, in the order of the CPU level they need.
+// Since the AppImage may run on an older CPU than the build system, the baseline variant
+// directly in the directories is bundled, and these are only used if there is none
+var HwcapsSubdirectories = []string{
+	"glibc-hwcaps/x86-64-v2", "glibc-hwcaps/x86-64-v3", "glibc-hwcaps/x86-64-v4",
+	"glibc-hwcaps/power9", "glibc-hwcaps/power10",
+	"glibc-hwcaps/z13", "glibc-hwcaps/z14", "glibc-hwcaps/z15", "glibc-hwcaps/z16",
+}
+
+// SearchPathResolver looks for libraries in a list of directories, in order
+type SearchPathResolver struct {
+	// FS is the filesystem in which the libraries are looked for; fsys.OS if nil
+	FS          fsys.FS
+	locations   []string
+	rules       map[string]string
+	addDefaults bool
+	archs       map[string]Arch                // Key: path of an ELF whose architecture has been read
+	needs       map[string]map[string][]string // Key: path of an ELF whose version needs have been read
+	definitions map[string][]string            // Key: path of a library whose version definitions have been read
+	pins        map[string][]string            // Key: name of a pinned library, value: its paths, e.g., one per architecture
+	conflicts   map[string][]string            // Key: name of a library, value: the different files found for it
+}
+
+// NewSearchPathResolver returns a resolver without any directories to search
+func NewSearchPathResolver() *SearchPathResolver {
+	return &SearchPathResolver{rules: make(map[string]string), archs: make(map[string]Arch),
+		needs: make(map[string]map[string][]string), definitions: make(map[string][]string),
+		pins: make(map[string][]string), conflicts: make(map[string][]string)}
+}
+
+// NewDefaultResolver returns a resolver that searches the directories added to it,
+// then DefaultLocations, the directories in /etc/ld.so.conf, and those in $LD_LIBRARY_PATH.
+// The default directories are added when the first library is resolved, so that
+// directories added before, e.g., those of the ELFs being deployed, take precedence
+func NewDefaultResolver() *SearchPathResolver {
+	r := NewSearchPathResolver()
+	r.addDefaults = true
+	return r
+}
+
+// AddLocation adds a directory to search, remembering the rule due to which it was added
+// if it was not there yet
+func (r *SearchPathResolver) AddLocation(location string, rule string) {
+	location = filepath.Clean(location)
+	if _, ok := r.rules[location]; ok {
+		return
+	}
+	r.rules[location] = rule
+	r.locations = append(r.locations, location)
+}
+
+// AddDefaultLocations adds DefaultLocations, the directories in /etc/ld.so.conf,
+// and those in $LD_LIBRARY_PATH
+func (r *SearchPathResolver) AddDefaultLocations() {
+	for _, loc := range DefaultLocations {
+		r.AddLocation(loc, "default path")
+	}
+
+	// Additionally, look for libraries in the same locations in which glibc ld.so looks for libraries
+	for _, loc := range ReadLdSoConfFS(r.fs(), "/etc/ld.so.conf") {
+		r.AddLocation(loc, "/etc/ld.so.conf")
+	}
+
+	// Also look for libraries in in LD_LIBRARY_PATH
+	for _, ldp := range strings.Split(os.Getenv("LD_LIBRARY_PATH"), ":") {
+		if ldp != "" {
+			r.AddLocation(ldp, "LD_LIBRARY_PATH")
+		}
+	}
+	r.addDefaults = false
+}
+
+// Pin makes the resolver return path for the library with the given name, without searching for it,
+// remembering the rule due to which it was pinned. Like the files found by searching, path is only
+// returned for ELFs of its architecture, so that a library can be pinned once for each architecture
+func (r *SearchPathResolver) Pin(name string, path string, rule string) {
+	r.pins[name] = append(r.pins[name], path)
+	r.rules[path] = rule
+}
+
+// Conflicts returns the libraries for which different files were found in the directories of the resolver,
+// by their name, with the files in the order of preference. Only the first one is used unless it is pinned
+func (r *SearchPathResolver) Conflicts() map[string][]string {
+	return r.conflicts
+}
+
+// Locations returns the directories that are searched, in order.
+// For resolvers returned by NewDefaultResolver, the default directories
+// are only included once a library has been resolved
+func (r *SearchPathResolver) Locations() []string {
+	return r.locations
+}
+
+// Rule returns the rule due to which location is searched
+func (r *SearchPathResolver) Rule(location string) string {
+	return r.rules[filepath.Clean(location)]
+}
+
+// Resolve returns the first file with the given name in the directories of the resolver,
+// or in their tls subdirectories, which ld.so searches as well, like ld.so does on a CPU
+// that supports none of the HwcapsSubdirectories. Only if there is no such file, the one in the first of
+// the HwcapsSubdirectories is returned, with a rule that says which one.
+// Like ld.so, files of a different architecture than the ELF at needer are skipped,
+// so that 32-bit and 64-bit ELFs in the same AppDir each get their own libraries.
+// Files that do not define all symbol versions that needer needs from the library are only
+// returned if there is no other file, since ld.so would refuse to load them.
+// The search is restricted by the SearchConstraints of needer
+func (r *SearchPathResolver) Resolve(name string, needer string) (string, string, error) {
+	return r.ResolveConstrained(name, needer, ReadSearchConstraints(r.fs(), needer))
+}
+
+// ResolveConstrained is like Resolve, but leaves out the directories that ld.so does not search under the constraints c
+func (r *SearchPathResolver) ResolveConstrained(name string, needer string, c SearchConstraints) (string, string, error) {
+	for _, path := range r.pins[name] {
+		if r.isCompatible(path, needer) {
+			return path, r.rules[path], nil
+		}
+	}
+	if r.addDefaults {
+		r.AddDefaultLocations()
+	}
+	var locations []string
+	for _, location := range r.locations {
+		if c.allows(location, r.rules[location]) {
+			locations = append(locations, location)
+		}
+	}
+	// Candidates in the order of preference, with their rules
+	var candidates, rules []string
+	for _, location := range locations {
+		for _, path := range []string{location + "/" + name, location + "/tls/" + name} {
+			if r.isCompatible(path, needer) {
+				candidates = append(candidates, path)
+				rules = append(rules, r.rules[location])
+			}
+		}
+	}
+	r.recordConflict(name, candidates)
+	for _, subdirectory := range HwcapsSubdirectories {
+		for _, location := range locations {
+			path := location + "/" + subdirectory + "/" + name
+			if r.isCompatible(path, needer) {
+				candidates = append(candidates, path)
+				rules = append(rules, r.rules[location]+" ("+subdirectory+")")
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", "", errors.New("did not find library " + name)
+	}
+	for i, path := range candidates {
+		if len(r.MissingVersions(name, path, needer)) == 0 {
+			return path, rules[i], nil
+		}
+	}
+	return candidates[0], rules[0], nil
+}
+
+// recordConflict remembers the library with the given name as a conflict if there are different files among candidates.
+// Candidates that are the same file, e.g., in /lib and /usr/lib on systems where /lib is a symlink to /usr/lib,
+// or copies of it, do not conflict
+func (r *SearchPathResolver) recordConflict(name string, candidates []string) {
+	if _, ok := r.conflicts[name]; ok || len(candidates) < 2 {
+		return
+	}
+	var different []string
+	for _, candidate := range candidates {
+		same := false
+		for _, other := range different {
+			if r.sameContents(candidate, other) {
+				same = true
+				break
+			}
+		}
+		if same == false {
+			different = append(different, candidate)
+		}
+	}
+	if len(different) > 1 {
+		r.conflicts[name] = different
+	}
+}
+
+// sameContents returns true if a and b are the same file or files with the same contents,
+// comparing their digests only if they have the same size
+func (r *SearchPathResolver) sameContents(a string, b string) bool {
+	infoA, errA := r.fs().Stat(a)
+	infoB, errB := r.fs().Stat(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	if os.SameFile(infoA, infoB) {
+		// Reached through different paths
+		return true
+	}
+	if infoA.Size() != infoB.Size() {
+		return false
+	}
+	hashA, errA := fsys.SHA256(r.fs(), a)
+	hashB, errB := fsys.SHA256(r.fs(), b)
+	return errA == nil && errB == nil && hashA == hashB
+}
+
+// MissingVersions returns the symbol versions that the ELF at needer needs from the library with the given name
+// that the library at path does not define
+func (r *SearchPathResolver) MissingVersions(name string, path string, needer string) []string {
+	if needer == "" {
+		return nil
+	}
+	needs, ok := r.needs[needer]
+	if ok == false {
+		needs, _ = ReadVersionNeeds(r.fs(), needer)
+		r.needs[needer] = needs
+	}
+	if len(needs[name]) == 0 {
+		return nil
+	}
+	definitions, ok := r.definitions[path]
+	if ok == false {
+		definitions, _ = ReadVersionDefinitions(r.fs(), path)
+		r.definitions[path] = definitions
+	}
+	return missingVersions(needs[name], definitions)
+}
+
+// isCompatible returns true if there is a file at path that has the same architecture as the ELF at needer.
+// If needer is empty or its architecture cannot be read, any file will do
+func (r *SearchPathResolver) isCompatible(path string, needer string) bool {
+	if _, err := r.fs().Stat(path); err != nil {
+		return false
+	}
+	wanted, err := r.arch(needer)
+	if needer == "" || err != nil {
+		return true
+	}
+	arch, err := r.arch(path)
+	return err == nil && arch == wanted
+}
+
+// arch returns the architecture of the ELF at path, reading it only once
+func (r *SearchPathResolver) arch(path string) (Arch, error) {
+	if arch, ok := r.archs[path]; ok {
+		return arch, nil
+	}
+	arch, err := ReadArch(r.fs(), path)
+	if err == nil {
+		r.archs[path] = arch
+	}
+	return arch, err
+}
+
+// ResolvePath returns the path of a library whose name in DT_NEEDED contains a slash, which ld.so
+// takes as a path to the library rather than searching for it: $ORIGIN is replaced by the directory
+// of the ELF at needer, and relative paths are relative to the working directory
+func ResolvePath(fs fsys.FS, name string, needer string) (string, error) {
+	path := strings.Replace(strings.Replace(name, "${ORIGIN}", "$ORIGIN", -1), "$ORIGIN", filepath.Dir(needer), -1)
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fs.Stat(path); err != nil {
+		return "", errors.New("did not find library " + name)
+	}
+	return path, nil
+}
+
+func (r *SearchPathResolver) fs() fsys.FS {
+	if r.FS == nil {
+		return fsys.OS
+	}
+	return r.FS
+}
+
+// Directories in which the musl dynamic linker looks for libraries if there is no path file
+var MuslDefaultLocations = []string{"/lib", "/usr/local/lib", "/usr/lib"}
+
+// Directories in which glibc systems install musl and the libraries built against it, e.g., by the musl
+// packages of Debian and Arch Linux. <arch> is replaced by the architecture of the musl dynamic linker
+var MuslSysrootLocations = []string{"/usr/lib/<arch>-linux-musl", "/usr/lib/musl/lib", "/usr/local/musl/lib"}
+
+// MuslLocations returns the directories in which the musl dynamic linker at interpreter,
+// e.g., /lib/ld-musl-x86_64.so.1, looks for libraries. musl has neither ld.so.conf nor ld.so.cache
+// but reads them from /etc/ld-musl-<arch>.path, separated by colons or newlines,
+// and uses MuslDefaultLocations if there is no such file. Since these contain the glibc libraries
+// on systems whose native libc is glibc, only the musl sysroot is used there instead: the directory
+// of the file the interpreter links to and those of MuslSysrootLocations that exist
+func MuslLocations(fs fsys.FS, interpreter string) []string {
+	arch := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(interpreter), "ld-musl-"), ".so.1")
+	data, err := fs.ReadFile("/etc/ld-musl-" + arch + ".path")
+	if err != nil {
+		if isMuslSystem(fs, arch) {
+			return MuslDefaultLocations
+		}
+		var locations []string
+		if dir := filepath.Dir(resolveSymlinks(fs, interpreter)); IsSystemDirectory(dir) == false && isDefaultMuslLocation(dir) == false {
+			locations = append(locations, dir)
+		}
+		for _, location := range MuslSysrootLocations {
+			location = strings.Replace(location, "<arch>", arch, 1)
+			if fsys.IsDirectory(fs, location) {
+				locations = appendIfMissing(locations, location)
+			}
+		}
+		return locations
+	}
+	var locations []string
+	for _, location := range strings.FieldsFunc(string(data), func(r rune) bool { return r == ':' || r == '\n' }) {
+		if location = strings.TrimSpace(location); location != "" {
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
+
+// isMuslSystem returns true if the native libc of fs is musl, which is the case if the musl dynamic linker
+// for arch is a file in /lib rather than a symlink to a musl installed next to glibc.
+// The dynamic linker of glibc says nothing, since musl systems may have it for glibc executables, e.g., gcompat
+func isMuslSystem(fs fsys.FS, arch string) bool {
+	if fsys.Exists(fs, "/etc/alpine-release") {
+		return true
+	}
+	info, err := fs.Lstat("/lib/ld-musl-" + arch + ".so.1")
+	return err == nil && info.Mode().IsRegular()
+}
+
+// isDefaultMuslLocation returns true if dir is one of MuslDefaultLocations
+func isDefaultMuslLocation(dir string) bool {
+	for _, location := range MuslDefaultLocations {
+		if dir == location {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSymlinks returns the path that path points to after following the symlinks to it, if any
+func resolveSymlinks(fs fsys.FS, path string) string {
+	for i := 0; i < 40; i++ {
+		target, err := fs.Readlink(path)
+		if err != nil {
+			return path
+		}
+		if filepath.IsAbs(target) == false {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = filepath.Clean(target)
+	}
+	return path
+}
+
+// ReadLdSoConf returns the directories specified in the ld config file at path,
+// usually '/etc/ld.so.conf', and in its included config files
+func ReadLdSoConf(path string) []string {
+	return ReadLdSoConfFS(fsys.OS, path)
+}
+
+// ReadLdSoConfFS is like ReadLdSoConf but reads the config files from fs
+func ReadLdSoConfFS(fs fsys.FS, path string) []string {
+	var out []string
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		} else if strings.HasPrefix(line, "include ") {
+			p := strings.Split(line, " ")[1]
+			files, err := fs.Glob(p)
+			if err != nil {
+				return out
+			}
+			for _, file := range files {
+				out = append(out, ReadLdSoConfFS(fs, file)...)
+			}
+			continue
+		}
+		out = append(out, strings.TrimSpace(line))
+	}
+	return out
+}
+
+// CacheParser reads a cache of libraries, such as /etc/ld.so.cache,
+// and returns a map from library names to paths
+type CacheParser func(path string) (map[string]string, error)
+
+// CacheResolver resolves libraries using the entries read by a CacheParser
+type CacheResolver struct {
+	Entries map[string]string
+	rule    string
+}
+
+// NewCacheResolver returns a resolver for the cache at path read with parser
+func NewCacheResolver(parser CacheParser, path string) (*CacheResolver, error) {
+	entries, err := parser(path)
+	if err != nil {
+		return nil, err
+	}
+	return &CacheResolver{Entries: entries, rule: path}, nil
+}
+
+// Resolve returns the path of the library in the cache
+func (r *CacheResolver) Resolve(name string, needer string) (string, string, error) {
+	return r.ResolveConstrained(name, needer, SearchConstraints{})
+}
+
+// ResolveConstrained is like Resolve, but with c.NoDefaultLib, like ld.so, it ignores the entries in the system directories
+func (r *CacheResolver) ResolveConstrained(name string, needer string, c SearchConstraints) (string, string, error) {
+	path, ok := r.Entries[name]
+	if ok == false || (c.NoDefaultLib && IsSystemDirectory(filepath.Dir(path))) {
+		return "", "", errors.New("did not find library " + name + " in " + r.rule)
+	}
+	return path, r.rule, nil
+}
+
+// ChainResolver asks each of its resolvers in order and returns the first library found
+type ChainResolver []LibraryResolver
+
+// Resolve returns the library found by the first resolver that finds it
+func (resolvers ChainResolver) Resolve(name string, needer string) (string, string, error) {
+	for _, r := range resolvers {
+		path, rule, err := r.Resolve(name, needer)
+		if err == nil {
+			return path, rule, nil
+		}
+	}
+	return "", "", errors.New("did not find library " + name)
+}
+
+// ResolveConstrained returns the library found by the first resolver that finds it under the constraints c.
+// Resolvers that are not ConstrainedResolvers are asked without them
+func (resolvers ChainResolver) ResolveConstrained(name string, needer string, c SearchConstraints) (string, string, error) {
+	for _, r := range resolvers {
+		path, rule, err := resolveConstrained(r, name, needer, c)
+		if err == nil {
+			return path, rule, nil
+		}
+	}
+	return "", "", errors.New("did not find library " + name)
+}
+
+// resolveConstrained resolves name with r under the constraints c if r supports them
+func resolveConstrained(r LibraryResolver, name string, needer string, c SearchConstraints) (string, string, error) {
+	if cr, ok := r.(ConstrainedResolver); ok {
+		return cr.ResolveConstrained(name, needer, c)
+	}
+	return r.Resolve(name, needer)
+}
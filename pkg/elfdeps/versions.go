@@ -0,0 +1,173 @@
+package elfdeps
+
+import (
+	"debug/elf"
+	"errors"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// verFlagWeak is VER_FLG_WEAK in vna_flags
+const verFlagWeak = 0x2
+
+// ReadVersionNeeds returns the symbol versions, e.g., GLIBC_2.34 or Qt_5.15, that the ELF at path in fs
+// needs from each library, by the name of the library in DT_NEEDED, as listed in .gnu.version_r.
+// ld.so refuses to load the ELF if a library does not define one of them. Weak needs (VER_FLG_WEAK),
+// which ld.so only warns about, are left out
+func ReadVersionNeeds(fs fsys.FS, path string) (map[string][]string, error) {
+	e, closer, err := openELF(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	needs := make(map[string][]string)
+	section := e.SectionByType(elf.SHT_GNU_VERNEED)
+	if section == nil {
+		return needs, nil
+	}
+	data, strtab, err := versionSectionData(e, section)
+	if err != nil {
+		return nil, err
+	}
+	// Elf_Verneed: vn_version, vn_cnt (16 bits each), vn_file, vn_aux, vn_next (32 bits each),
+	// followed by vn_cnt Elf_Vernaux: vna_hash, vna_flags, vna_other, vna_name, vna_next
+	for offset, i := 0, 0; i < int(section.Info) && offset+16 <= len(data); i++ {
+		count := int(e.ByteOrder.Uint16(data[offset+2:]))
+		file := cString(strtab, e.ByteOrder.Uint32(data[offset+4:]))
+		aux := offset + int(e.ByteOrder.Uint32(data[offset+8:]))
+		for j := 0; j < count && aux+16 <= len(data); j++ {
+			if e.ByteOrder.Uint16(data[aux+4:])&verFlagWeak == 0 {
+				needs[file] = appendIfMissing(needs[file], cString(strtab, e.ByteOrder.Uint32(data[aux+8:])))
+			}
+			next := int(e.ByteOrder.Uint32(data[aux+12:]))
+			if next == 0 {
+				break
+			}
+			aux += next
+		}
+		next := int(e.ByteOrder.Uint32(data[offset+12:]))
+		if next == 0 {
+			break
+		}
+		offset += next
+	}
+	return needs, nil
+}
+
+// ReadVersionDefinitions returns the symbol versions that the library at path in fs defines in .gnu.version_d,
+// or none if the library has no version information
+func ReadVersionDefinitions(fs fsys.FS, path string) ([]string, error) {
+	e, closer, err := openELF(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	section := e.SectionByType(elf.SHT_GNU_VERDEF)
+	if section == nil {
+		return nil, nil
+	}
+	data, strtab, err := versionSectionData(e, section)
+	if err != nil {
+		return nil, err
+	}
+	var definitions []string
+	// Elf_Verdef: vd_version, vd_flags, vd_ndx, vd_cnt (16 bits each), vd_hash, vd_aux, vd_next (32 bits each),
+	// whose first Elf_Verdaux (vda_name, vda_next) names the version
+	for offset, i := 0, 0; i < int(section.Info) && offset+20 <= len(data); i++ {
+		aux := offset + int(e.ByteOrder.Uint32(data[offset+12:]))
+		if aux+8 <= len(data) {
+			definitions = appendIfMissing(definitions, cString(strtab, e.ByteOrder.Uint32(data[aux:])))
+		}
+		next := int(e.ByteOrder.Uint32(data[offset+16:]))
+		if next == 0 {
+			break
+		}
+		offset += next
+	}
+	return definitions, nil
+}
+
+// missingVersions returns the versions in needed that are not among defined. Like ld.so, which
+// only warns about it, a library without version definitions is taken to satisfy all needs
+func missingVersions(needed []string, defined []string) []string {
+	if len(defined) == 0 {
+		return nil
+	}
+	var missing []string
+	for _, version := range needed {
+		found := false
+		for _, definition := range defined {
+			if definition == version {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			missing = append(missing, version)
+		}
+	}
+	return missing
+}
+
+type closer interface{ Close() error }
+
+// openELF opens the ELF at path in fs, which is memory-mapped so that only the headers and the sections
+// that are accessed get read, even for huge binaries. The closer must be closed once e is no longer used
+func openELF(fs fsys.FS, path string) (e *elf.File, c closer, err error) {
+	m, err := fsys.Map(fs, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err = elf.NewFile(m)
+	if err != nil {
+		m.Close()
+		return nil, nil, err
+	}
+	return e, m, nil
+}
+
+// versionSectionData returns the contents of a version section and of the string table it refers to
+func versionSectionData(e *elf.File, section *elf.Section) ([]byte, []byte, error) {
+	data, err := section.Data()
+	if err != nil {
+		return nil, nil, err
+	}
+	if int(section.Link) >= len(e.Sections) {
+		return nil, nil, errors.New("invalid string table of " + section.Name)
+	}
+	strtab, err := e.Sections[section.Link].Data()
+	return data, strtab, err
+}
+
+// cString returns the NUL-terminated string at offset in table
+func cString(table []byte, offset uint32) string {
+	if int(offset) >= len(table) {
+		return ""
+	}
+	end := int(offset)
+	for end < len(table) && table[end] != 0 {
+		end++
+	}
+	return string(table[offset:end])
+}
+
+// ReadExportedSymbols returns the names of the dynamic symbols that the library at path in fs defines
+func ReadExportedSymbols(fs fsys.FS, path string) ([]string, error) {
+	e, closer, err := openELF(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	symbols, err := e.DynamicSymbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	var names []string
+	for _, symbol := range symbols {
+		binding := elf.ST_BIND(symbol.Info)
+		if symbol.Section != elf.SHN_UNDEF && (binding == elf.STB_GLOBAL || binding == elf.STB_WEAK) {
+			names = append(names, symbol.Name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,107 @@
+// Package fsys abstracts the filesystem, similar to afero, so that code that resolves,
+// copies and patches the files of AppDirs can be tested against in-memory fixtures, e.g.,
+//
+//	fs := fsys.NewMemFS()
+//	fs.WriteFile("/usr/lib/libfoo.so.1", data, 0644)
+//	fs.Symlink("libfoo.so.1", "/usr/lib/libfoo.so")
+//	resolver := elfdeps.NewSearchPathResolver()
+//	resolver.FS = fs
+package fsys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// File is an open file
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// FS is a filesystem. Paths are absolute or relative to the working directory
+// as with the functions of the os package, which the methods correspond to
+type FS interface {
+	Open(name string) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+	Symlink(oldname string, newname string) error
+	Readlink(name string) (string, error)
+	Glob(pattern string) ([]string, error)
+}
+
+// OS is the filesystem of the operating system
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error)               { return os.Open(name) }
+func (osFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+func (osFS) Lstat(name string) (os.FileInfo, error)       { return os.Lstat(name) }
+func (osFS) ReadFile(name string) ([]byte, error)         { return ioutil.ReadFile(name) }
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (osFS) Remove(name string) error                     { return os.Remove(name) }
+func (osFS) Symlink(oldname string, newname string) error { return os.Symlink(oldname, newname) }
+func (osFS) Readlink(name string) (string, error)         { return os.Readlink(name) }
+func (osFS) Glob(pattern string) ([]string, error)        { return filepath.Glob(pattern) }
+
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+// Exists returns true if something exists at path in fs, following symlinks
+func Exists(fs FS, path string) bool {
+	_, err := fs.Stat(path)
+	return err == nil
+}
+
+// IsDirectory returns true if path is a directory in fs, following symlinks
+func IsDirectory(fs FS, path string) bool {
+	info, err := fs.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// SHA256 returns the hex-encoded SHA-256 digest of the file at path in fs
+func SHA256(fs FS, path string) (string, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CopyFile copies the file at src to dst in fs, creating the directories dst is in,
+// and keeps its permissions. For copies on the filesystem of the operating system
+// use helpers.CopyFile, which can make reflinks
+func CopyFile(fs FS, src string, dst string) error {
+	info, err := fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := fs.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	err = fs.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	return fs.WriteFile(dst, data, info.Mode().Perm())
+}
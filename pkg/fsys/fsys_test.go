@@ -0,0 +1,104 @@
+package fsys_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+func TestMemFS(t *testing.T) {
+	fs := fsys.NewMemFS()
+	err := fs.WriteFile("/usr/lib/x86_64-linux-gnu/libfoo.so.1.2.3", []byte("foo"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Symlink("libfoo.so.1.2.3", "/usr/lib/x86_64-linux-gnu/libfoo.so.1")
+	fs.Symlink("/usr/lib", "/lib")
+
+	data, err := fs.ReadFile("/lib/x86_64-linux-gnu/libfoo.so.1")
+	if err != nil || string(data) != "foo" {
+		t.Errorf("Read %q, %v through symlinks", data, err)
+	}
+	info, err := fs.Lstat("/usr/lib/x86_64-linux-gnu/libfoo.so.1")
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Lstat followed the symlink")
+	}
+	if fsys.IsDirectory(fs, "/lib/x86_64-linux-gnu") == false {
+		t.Error("Directory was not created implicitly or the symlink to it was not followed")
+	}
+	if _, err = fs.Stat("/usr/lib/libbar.so"); os.IsNotExist(err) == false {
+		t.Error("Stat of a missing file returned", err)
+	}
+	if err = fs.Remove("/usr/lib"); err == nil {
+		t.Error("Removed a directory that is not empty")
+	}
+
+	err = fsys.CopyFile(fs, "/lib/x86_64-linux-gnu/libfoo.so.1", "/app/usr/lib/libfoo.so.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = fs.Lstat("/app/usr/lib/libfoo.so.1")
+	if err != nil || info.Mode().IsRegular() == false || info.Mode().Perm() != 0644 || info.Size() != 3 {
+		t.Error("The copy is not a regular file with the contents and permissions of the original")
+	}
+	digest, err := fsys.SHA256(fs, "/app/usr/lib/libfoo.so.1")
+	if err != nil || digest != "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" {
+		t.Error("Unexpected digest of the copy:", digest, err)
+	}
+	matches, _ := fs.Glob("/usr/lib/*/libfoo.so.*")
+	if len(matches) != 2 {
+		t.Error("Glob returned", matches)
+	}
+
+	fs.Symlink("loop", "/loop")
+	if _, err = fs.Stat("/loop"); err == nil {
+		t.Error("Stat of a symlink loop did not fail")
+	}
+}
+
+func TestMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/file", []byte("\x7fELF contents"), 0644)
+	ioutil.WriteFile(dir+"/empty", nil, 0644)
+	mem := fsys.NewMemFS()
+	mem.WriteFile(dir+"/file", []byte("\x7fELF contents"), 0644)
+
+	for _, fs := range []fsys.FS{fsys.OS, mem} {
+		m, err := fsys.Map(fs, dir+"/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Bytes()) != "\x7fELF contents" || m.Len() != 13 {
+			t.Errorf("Mapped %q", m.Bytes())
+		}
+		buf := make([]byte, 8)
+		n, err := m.ReadAt(buf, 5)
+		if n != 8 || err != nil || string(buf) != "contents" {
+			t.Errorf("Read %q, %v at offset 5", buf[:n], err)
+		}
+		n, err = m.ReadAt(buf, 9)
+		if n != 4 || err != io.EOF {
+			t.Errorf("Read %d bytes, %v at the end", n, err)
+		}
+		if err = m.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	m, err := fsys.Map(fsys.OS, dir+"/empty")
+	if err != nil || m.Len() != 0 || m.Close() != nil {
+		t.Error("Could not map an empty file:", err)
+	}
+	if _, err = fsys.Map(fsys.OS, dir+"/missing"); os.IsNotExist(err) == false {
+		t.Error("Mapped a missing file:", err)
+	}
+	if _, err = fsys.Map(fsys.OS, dir); err == nil {
+		t.Error("Mapped a directory")
+	}
+}
@@ -0,0 +1,281 @@
+package fsys
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How many symlinks are followed before giving up, like Linux does
+const maxSymlinks = 40
+
+// MemFS is a filesystem in memory for tests. Like in afero's MemMapFs, the directories
+// that files and symlinks are put into are created implicitly. Relative paths are relative to /
+type MemFS struct {
+	mutex sync.Mutex
+	nodes map[string]*memNode // Key: cleaned absolute path
+}
+
+type memNode struct {
+	data    []byte
+	mode    os.FileMode // Including os.ModeDir or os.ModeSymlink
+	target  string      // Of a symlink
+	modTime time.Time
+}
+
+// NewMemFS returns an empty filesystem in memory
+func NewMemFS() *MemFS {
+	return &MemFS{nodes: map[string]*memNode{"/": {mode: os.ModeDir | 0755, modTime: time.Now()}}}
+}
+
+func clean(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// resolve returns name with all symlinks in its directories resolved,
+// and the symlink at name itself if follow is true
+func (m *MemFS) resolve(name string, follow bool) (string, error) {
+	name = clean(name)
+	for hops := 0; hops <= maxSymlinks; hops++ {
+		parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+		resolved := "/"
+		followed := false
+		for i, part := range parts {
+			path := filepath.Join(resolved, part)
+			node, ok := m.nodes[path]
+			if ok && node.mode&os.ModeSymlink != 0 && (i < len(parts)-1 || follow) {
+				target := node.target
+				if filepath.IsAbs(target) == false {
+					target = filepath.Join(resolved, target)
+				}
+				name = clean(filepath.Join(append([]string{target}, parts[i+1:]...)...))
+				followed = true
+				break
+			}
+			resolved = path
+		}
+		if followed == false {
+			return resolved, nil
+		}
+	}
+	return "", &os.PathError{Op: "resolve", Path: name, Err: syscall.ELOOP}
+}
+
+// lookup returns the node at name and its resolved path
+func (m *MemFS) lookup(op string, name string, follow bool) (*memNode, string, error) {
+	path, err := m.resolve(name, follow)
+	if err != nil {
+		return nil, "", err
+	}
+	node, ok := m.nodes[path]
+	if ok == false {
+		return nil, path, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return node, path, nil
+}
+
+func (m *MemFS) mkdirAll(op string, path string, perm os.FileMode) error {
+	path, err := m.resolve(path, true)
+	if err != nil {
+		return err
+	}
+	dir := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		node, ok := m.nodes[dir]
+		if ok == false {
+			m.nodes[dir] = &memNode{mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+		} else if node.mode.IsDir() == false {
+			return &os.PathError{Op: op, Path: dir, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+// Open opens the file at name for reading
+func (m *MemFS) Open(name string) (File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, _, err := m.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	return &memFile{Reader: bytes.NewReader(node.data), info: node.info(name)}, nil
+}
+
+// Stat returns information about the file at name, following symlinks
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, _, err := m.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(name), nil
+}
+
+// Lstat returns information about the file at name without following a symlink at name
+func (m *MemFS) Lstat(name string) (os.FileInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, _, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(name), nil
+}
+
+// ReadFile returns the contents of the file at name
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, _, err := m.lookup("read", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	return append([]byte{}, node.data...), nil
+}
+
+// WriteFile writes data to the file at name, creating it and the directories it is in if needed.
+// Like ioutil.WriteFile, it keeps the permissions of an existing file
+func (m *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	path, err := m.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	node, ok := m.nodes[path]
+	if ok && node.mode.IsDir() {
+		return &os.PathError{Op: "write", Path: name, Err: syscall.EISDIR}
+	}
+	err = m.mkdirAll("write", filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	if ok == false {
+		node = &memNode{mode: perm.Perm()}
+		m.nodes[path] = node
+	}
+	node.data = append([]byte{}, data...)
+	node.modTime = time.Now()
+	return nil
+}
+
+// MkdirAll creates the directory at path and all directories it is in
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.mkdirAll("mkdir", path, perm)
+}
+
+// Remove removes the file, symlink or empty directory at name
+func (m *MemFS) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, path, err := m.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if node.mode.IsDir() {
+		for other := range m.nodes {
+			if strings.HasPrefix(other, path+"/") {
+				return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+			}
+		}
+	}
+	delete(m.nodes, path)
+	return nil
+}
+
+// Symlink creates newname as a symlink to oldname, creating the directories it is in if needed
+func (m *MemFS) Symlink(oldname string, newname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	path, err := m.resolve(newname, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.nodes[path]; ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EEXIST}
+	}
+	err = m.mkdirAll("symlink", filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	m.nodes[path] = &memNode{mode: os.ModeSymlink | 0777, target: oldname, modTime: time.Now()}
+	return nil
+}
+
+// Readlink returns the target of the symlink at name
+func (m *MemFS) Readlink(name string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, _, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return node.target, nil
+}
+
+// Glob returns the paths matching pattern, see filepath.Match.
+// Unlike filepath.Glob, it does not match paths through symlinks to directories
+func (m *MemFS) Glob(pattern string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var matches []string
+	for path := range m.nodes {
+		matched, err := filepath.Match(clean(pattern), path)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matches = append(matches, path)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (n *memNode) info(name string) os.FileInfo {
+	return memFileInfo{name: filepath.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memFile struct {
+	*bytes.Reader
+	info os.FileInfo
+}
+
+func (f *memFile) Close() error               { return nil }
+func (f *memFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() os.FileMode  { return i.mode }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,88 @@
+package fsys
+
+import (
+	"errors"
+	"io"
+	"runtime/debug"
+)
+
+// Mapped is the read-only contents of a file. Files of the filesystem of the operating system
+// are memory-mapped where possible, so that only the pages that are accessed are read. E.g., finding
+// the dependencies of a multi-hundred-MB Electron binary or game only reads its headers and a few small
+// sections, rather than all of it into memory. Other filesystems hold the files in memory anyway.
+// No slices of the contents may be used after Close.
+//
+// The mapping is shared with the file, so if the file is truncated while it is mapped, e.g., by a build
+// that rewrites it while the AppDir is being deployed again in watch mode, accessing the pages beyond its
+// new end raises SIGBUS, which crashes the program. ReadAt and Access turn this into an error instead;
+// Bytes is only safe for files that nothing else modifies
+type Mapped struct {
+	data  []byte
+	unmap func() error
+}
+
+// Map returns the contents of the file at name in fs, memory-mapped if fs is OS
+func Map(fs FS, name string) (*Mapped, error) {
+	if fs == OS {
+		return mapFile(name)
+	}
+	data, err := fs.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapped{data: data}, nil
+}
+
+// Bytes returns the contents of the file, see Access
+func (m *Mapped) Bytes() []byte {
+	return m.data
+}
+
+// Access calls f with the contents of the file and returns an error rather than crashing
+// if the file was truncated so that f accessed pages that are no longer backed by it.
+// f must not keep data
+func (m *Mapped) Access(f func(data []byte)) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			// Only faults have the address, other panics are bugs in f
+			if _, ok := r.(interface{ Addr() uintptr }); ok == false {
+				panic(r)
+			}
+			err = errors.New("the file was modified while it was mapped")
+		}
+	}()
+	f(m.data)
+	return nil
+}
+
+// Len returns the size of the file
+func (m *Mapped) Len() int {
+	return len(m.data)
+}
+
+// ReadAt implements io.ReaderAt, e.g., for debug/elf.NewFile
+func (m *Mapped) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	var n int
+	err := m.Access(func(data []byte) { n = copy(p, data[off:]) })
+	if err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close unmaps the file
+func (m *Mapped) Close() error {
+	data, unmap := m.data, m.unmap
+	m.data, m.unmap = nil, nil
+	if unmap == nil || len(data) == 0 {
+		return nil
+	}
+	return unmap()
+}
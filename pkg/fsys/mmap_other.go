@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package fsys
+
+import (
+	"io/ioutil"
+)
+
+// mapFile reads the file at name, since memory-mapping is not implemented on this system
+func mapFile(name string) (*Mapped, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapped{data: data}, nil
+}
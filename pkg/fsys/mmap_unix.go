@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package fsys
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mapFile memory-maps the file at name, which stays valid after the file is closed
+func mapFile(name string) (*Mapped, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().IsRegular() == false {
+		return nil, errors.New(name + " is not a regular file")
+	}
+	size := info.Size()
+	if size == 0 {
+		// Cannot be mapped
+		return &Mapped{}, nil
+	}
+	if int64(int(size)) != size {
+		return nil, errors.New(name + " is too large to be mapped")
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapped{data: data, unmap: func() error { return unix.Munmap(data) }}, nil
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package fsys_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+func TestMapTruncated(t *testing.T) {
+	path := t.TempDir() + "/file"
+	if err := ioutil.WriteFile(path, make([]byte, 3*os.Getpagesize()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := fsys.Map(fsys.OS, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err = os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	var last byte
+	if err = m.Access(func(data []byte) { last = data[len(data)-1] }); err == nil {
+		t.Error("Accessing a truncated file did not fail", last)
+	}
+	if _, err = m.ReadAt(make([]byte, 16), int64(m.Len()-16)); err == nil {
+		t.Error("Reading a truncated file did not fail")
+	}
+}
@@ -0,0 +1,178 @@
+// Package signature calculates the digest of AppImages and verifies their signatures,
+// so that updaters and launchers can check the integrity of an AppImage before running it, e.g.,
+//
+//	result, err := signature.Verify("Some.AppImage")
+//	if err == nil && result.Status == signature.StatusValid {
+//		fmt.Println("Signed by", result.Identities)
+//	}
+package signature
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/elfsection"
+	"golang.org/x/crypto/openpgp"
+)
+
+// Sections of the runtime that hold the signature and the key. They are assumed to contain only
+// zeros when calculating the digest, so that they can be filled in after it has been calculated
+var SkippedSections = []string{".sha256_sig", ".sig_key"}
+
+// Results of Verify
+const (
+	// Signed, and the signature matches the digest
+	StatusValid = "valid"
+	// Signed, but the signature does not match the digest or cannot be checked
+	StatusInvalid = "invalid"
+	// Not signed, but the digest embedded instead of a signature matches
+	StatusDigestMatches = "digest-matches"
+	// Not signed, and the digest embedded instead of a signature does not match
+	StatusDigestMismatch = "digest-mismatch"
+	// Neither signed nor containing a digest
+	StatusNone = "none"
+)
+
+// Result is the outcome of Verify
+type Result struct {
+	Digest      string   // Hex-encoded SHA-256 digest of the AppImage
+	Status      string   // One of the Status constants
+	Identities  []string // Of the key that made a valid signature
+	Fingerprint string   // Hex-encoded fingerprint of the key that made a valid signature
+}
+
+var digestRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// byteRange is a part of a file that is hashed as if it contained only zeros
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+// Digest returns the hex-encoded SHA-256 digest of the AppImage at path,
+// with the SkippedSections hashed as if they contained only zeros
+func Digest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	e, err := elf.NewFile(f)
+	if err != nil {
+		return "", err
+	}
+	var skipped []byteRange
+	for _, name := range SkippedSections {
+		section := e.Section(name)
+		if section != nil && section.Type != elf.SHT_NOBITS && section.Size > 0 {
+			skipped = append(skipped, byteRange{int64(section.Offset), int64(section.Size)})
+		}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return digestSkippingRanges(f, info.Size(), skipped)
+}
+
+// digestSkippingRanges hashes size bytes of r with the skipped ranges replaced by zeros
+func digestSkippingRanges(r io.ReaderAt, size int64, skipped []byteRange) (string, error) {
+	sort.Slice(skipped, func(i, j int) bool {
+		return skipped[i].offset < skipped[j].offset
+	})
+	h := sha256.New()
+	var position int64
+	for _, s := range skipped {
+		if s.offset < position || s.offset+s.length > size {
+			return "", errors.New("sections to be skipped overlap or exceed the file")
+		}
+		_, err := io.Copy(h, io.NewSectionReader(r, position, s.offset-position))
+		if err != nil {
+			return "", err
+		}
+		_, err = io.CopyN(h, zeros{}, s.length)
+		if err != nil {
+			return "", err
+		}
+		position = s.offset + s.length
+	}
+	_, err := io.Copy(h, io.NewSectionReader(r, position, size-position))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// zeros is an endless stream of zeros
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Verify calculates the digest of the AppImage at path and checks it against the signature
+// in the .sha256_sig section made with the key in the .sig_key section, or against the digest
+// that unsigned AppImages may contain in the .sha256_sig section instead.
+// An error is only returned if the AppImage cannot be read
+func Verify(path string) (Result, error) {
+	var result Result
+	var err error
+	result.Digest, err = Digest(path)
+	if err != nil {
+		return result, err
+	}
+	sig, err := readSection(path, ".sha256_sig")
+	if err != nil {
+		return result, err
+	}
+	switch {
+	case strings.Contains(sig, "BEGIN PGP SIGNATURE"):
+		key, err := readSection(path, ".sig_key")
+		if err != nil {
+			return result, err
+		}
+		result.Status = StatusInvalid
+		keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+		if err != nil {
+			return result, nil
+		}
+		entity, err := openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(result.Digest), strings.NewReader(sig))
+		if err != nil || entity == nil {
+			return result, nil
+		}
+		result.Status = StatusValid
+		for name := range entity.Identities {
+			result.Identities = append(result.Identities, name)
+		}
+		sort.Strings(result.Identities)
+		result.Fingerprint = hex.EncodeToString(entity.PrimaryKey.Fingerprint[:])
+	case digestRegexp.MatchString(sig):
+		if sig == result.Digest {
+			result.Status = StatusDigestMatches
+		} else {
+			result.Status = StatusDigestMismatch
+		}
+	default:
+		result.Status = StatusNone
+	}
+	return result, nil
+}
+
+// readSection returns the contents of the named section of the ELF at path without the padding,
+// or an empty string if there is no such section
+func readSection(path string, name string) (string, error) {
+	if _, err := elfsection.Find(path, name); err != nil {
+		return "", nil
+	}
+	return elfsection.ReadString(path, name)
+}
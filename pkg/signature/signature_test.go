@@ -0,0 +1,77 @@
+package signature
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/probonopd/go-appimage/internal/elfsection"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// writeSection writes data into the named section of the ELF at path
+func writeSection(t *testing.T, path string, name string, data []byte) {
+	err := elfsection.Write(path, name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy is needed to add the sections")
+	}
+	dir, err := ioutil.TempDir("", "signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	self, _ := os.Executable()
+	ioutil.WriteFile(dir+"/zeros", make([]byte, 8192), 0644)
+	path := dir + "/Some.AppImage"
+	err = exec.Command("objcopy", "--add-section", ".sha256_sig="+dir+"/zeros", "--add-section", ".sig_key="+dir+"/zeros", self, path).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Verify(path)
+	if err != nil || result.Status != StatusNone {
+		t.Fatalf("Expected no signature, got %+v, %v", result, err)
+	}
+	digest := result.Digest
+
+	writeSection(t, path, ".sha256_sig", []byte(digest))
+	result, _ = Verify(path)
+	if result.Status != StatusDigestMatches || result.Digest != digest {
+		t.Errorf("Expected the embedded digest to match, got %+v", result)
+	}
+
+	entity, err := openpgp.NewEntity("Some Developer", "", "some@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig, key bytes.Buffer
+	openpgp.ArmoredDetachSign(&sig, entity, strings.NewReader(digest), nil)
+	w, _ := armor.Encode(&key, openpgp.PublicKeyType, nil)
+	entity.Serialize(w)
+	w.Close()
+	writeSection(t, path, ".sha256_sig", sig.Bytes())
+	writeSection(t, path, ".sig_key", key.Bytes())
+	result, _ = Verify(path)
+	if result.Status != StatusValid || result.Digest != digest || len(result.Identities) != 1 {
+		t.Errorf("Expected a valid signature, got %+v", result)
+	}
+
+	// Change something outside of the skipped sections
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte("tampered"))
+	f.Close()
+	result, _ = Verify(path)
+	if result.Status != StatusInvalid {
+		t.Errorf("Expected an invalid signature, got %+v", result)
+	}
+}
@@ -37,7 +37,7 @@ fi
 # Get pinned version of Go directly from upstream
 if [ "aarch64" == "$TRAVIS_ARCH" ] ; then export ARCH=arm64 ; fi
 if [ "amd64" == "$TRAVIS_ARCH" ] ; then export ARCH=amd64 ; fi
-wget -c -nv https://dl.google.com/go/go1.15.6.linux-$ARCH.tar.gz
+wget -c -nv https://dl.google.com/go/go1.16.15.linux-$ARCH.tar.gz
 mkdir path || true
 tar -C $PWD/path -xzf go*.tar.gz
 export PATH=$PWD/path/go/bin:$PATH
@@ -60,16 +60,25 @@ go get -d -v ./...
 go build -o $GOPATH/src -v -trimpath -ldflags="-s -w -X main.commit=$COMMIT" ./src/...
 mv $GOPATH/src/appimaged $GOPATH/src/appimaged-$(go env GOHOSTARCH)
 mv $GOPATH/src/appimagetool $GOPATH/src/appimagetool-$(go env GOHOSTARCH)
+mv $GOPATH/src/apprun $GOPATH/src/apprun-$(go env GOHOSTARCH)
 
 # 32-bit
 if [ $(go env GOHOSTARCH) == "amd64" ] ; then 
   env CGO_ENABLED=1 GOOS=linux GOARCH=386 go build -o $GOPATH/src -v -trimpath -ldflags="-s -w -X main.commit=$COMMIT" ./src/...
   mv $GOPATH/src/appimaged $GOPATH/src/appimaged-386
   mv $GOPATH/src/appimagetool $GOPATH/src/appimagetool-386
+  mv $GOPATH/src/apprun $GOPATH/src/apprun-386
 elif [ $(go env GOHOSTARCH) == "arm64" ] ; then
   env CC=arm-linux-gnueabi-gcc CGO_ENABLED=1 GOOS=linux GOARCH=arm GOARM=6 go build -o $GOPATH/src -v -trimpath -ldflags="-s -w -X main.commit=$COMMIT" ./src/...
   mv $GOPATH/src/appimaged $GOPATH/src/appimaged-arm
   mv $GOPATH/src/appimagetool $GOPATH/src/appimagetool-arm
+  mv $GOPATH/src/apprun $GOPATH/src/apprun-arm
+fi
+
+# appimagetool for maintainers on macOS and Windows, which can only inspect and verify AppImages
+if [ $(go env GOHOSTARCH) == "amd64" ] ; then
+  env CGO_ENABLED=0 GOOS=darwin GOARCH=amd64 go build -o $GOPATH/src/appimagetool-darwin-amd64 -v -trimpath -ldflags="-s -w -X main.commit=$COMMIT" ./src/appimagetool
+  env CGO_ENABLED=0 GOOS=windows GOARCH=amd64 go build -o $GOPATH/src/appimagetool-windows-amd64.exe -v -trimpath -ldflags="-s -w -X main.commit=$COMMIT" ./src/appimagetool
 fi
 
 ##############################################################
@@ -97,6 +106,7 @@ mkdir -p appimagetool.AppDir/usr/bin
 ( cd appimagetool.AppDir/usr/bin/ ; wget -c https://github.com/probonopd/static-tools/releases/download/continuous/patchelf-$ARCHITECTURE -O patchelf )
 ( cd appimagetool.AppDir/usr/bin/ ; wget -c https://github.com/AppImage/AppImageKit/releases/download/continuous/runtime-$ARCHITECTURE )
 ( cd appimagetool.AppDir/usr/bin/ ; wget -c https://github.com/probonopd/uploadtool/raw/master/upload.sh -O uploadtool )
+cp apprun-* appimagetool.AppDir/usr/bin/ # Compiled AppRun launchers for --compiled-apprun
 chmod +x appimagetool.AppDir/usr/bin/*
 cp appimagetool-$(go env GOHOSTARCH) appimagetool.AppDir/usr/bin/appimagetool
 ( cd appimagetool.AppDir/ ; ln -s usr/bin/appimagetool AppRun)
@@ -156,6 +166,7 @@ mkdir -p appimagetool.AppDir/usr/bin
 ( cd appimagetool.AppDir/usr/bin/ ; wget -c https://github.com/probonopd/static-tools/releases/download/continuous/patchelf-$ARCHITECTURE -O patchelf )
 ( cd appimagetool.AppDir/usr/bin/ ; wget -c https://github.com/AppImage/AppImageKit/releases/download/continuous/runtime-$ARCHITECTURE )
 ( cd appimagetool.AppDir/usr/bin/ ; wget -c https://github.com/probonopd/uploadtool/raw/master/upload.sh -O uploadtool )
+cp apprun-* appimagetool.AppDir/usr/bin/ # Compiled AppRun launchers for --compiled-apprun
 chmod +x appimagetool.AppDir/usr/bin/*
 
 # 32-bit
@@ -18,6 +18,8 @@ import (
 
 	"github.com/adrg/xdg"
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+	"github.com/probonopd/go-appimage/pkg/signature"
 	"github.com/probonopd/go-appimage/src/goappimage"
 	"go.lsp.dev/uri"
 )
@@ -45,8 +47,8 @@ type AppImage struct {
 func NewAppImage(path string) (ai *AppImage, err error) {
 	ai = new(AppImage)
 	ai.AppImage, err = goappimage.NewAppImage(path)
-	if err != nil {
-		return ai, err
+	if ai.AppImage == nil {
+		ai.AppImage = &goappimage.AppImage{Path: path}
 	}
 
 	ai.uri = strings.TrimSpace(string(uri.File(filepath.Clean(ai.Path))))
@@ -59,6 +61,9 @@ func NewAppImage(path string) (ai *AppImage, err error) {
 	} else {
 		ai.thumbnailfilepath = ThumbnailsDirNormal + "/" + ai.thumbnailfilename
 	}
+	if err != nil {
+		return ai, err
+	}
 	ui, err := ai.ReadUpdateInformation()
 	if err == nil && ui != "" {
 		ai.updateinformation = ui
@@ -98,12 +103,13 @@ func runCommand(cmd *exec.Cmd) (bytes.Buffer, error) {
 }
 
 func (ai AppImage) setExecBit() {
-
-	err := os.Chmod(ai.Path, 0755)
+	info, err := os.Stat(ai.Path)
+	if err != nil || info.Mode()&0111 == 0111 {
+		return
+	}
+	err = os.Chmod(ai.Path, info.Mode()|0111)
 	if err == nil {
-		if *verbosePtr == true {
-			log.Println("appimage: Set executable bit on", ai.Path)
-		}
+		log.Println("appimage: Set executable bit on", ai.Path)
 	}
 	// printError("appimage", err) // Do not print error since AppImages on read-only media are common
 }
@@ -123,6 +129,18 @@ func (ai AppImage) Validate() error {
 			return err
 		}
 	}
+	// Check the integrity of type-2 AppImages, which can be signed or contain their digest
+	if ai.Type() == 2 {
+		result, err := signature.Verify(ai.Path)
+		if err != nil {
+			return err
+		}
+		if result.Status == signature.StatusInvalid || result.Status == signature.StatusDigestMismatch {
+			err = i18n.Errorf("%s is damaged or has been modified, its signature status is %s", ai.Path, result.Status)
+			helpers.PrintError("appimage: signature verification", err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -139,7 +157,18 @@ func (ai AppImage) _integrate() {
 		return
 	}
 
+	// Only integrate what the user allows, see policy.go
+	p := loadPolicy()
+	if allowed, reason := p.allows(&ai); !allowed {
+		quarantine(&ai, p, reason)
+		return
+	}
+
 	ai.setExecBit()
+	if adoptMovedIntegration(&ai) {
+		sendDesktopNotification(i18n.Sprintf("Moved"), ai.Path, 3000)
+	}
+	updateSearchIndex(&ai)
 
 	// For performance reasons, we stop working immediately
 	// in case a desktop file already exists at that location
@@ -202,6 +231,7 @@ func (ai AppImage) _integrate() {
 // Do not call this directly. Instead, call IntegrateOrUnintegrate
 func (ai AppImage) _removeIntegration() {
 	log.Println("appimage: Remove integration", ai.Path)
+	removeFromSearchIndex(ai.Path)
 	err := os.Remove(ai.thumbnailfilepath)
 	if err == nil {
 		log.Println("appimage: Deleted", ai.thumbnailfilepath)
@@ -217,7 +247,7 @@ func (ai AppImage) _removeIntegration() {
 	err = os.Remove(ai.desktopfilepath)
 	if err == nil {
 		log.Println("appimage: Deleted", ai.desktopfilepath)
-		sendDesktopNotification("Removed", ai.Path, 3000)
+		sendDesktopNotification(i18n.Sprintf("Removed"), ai.Path, 3000)
 
 	}
 }
@@ -238,7 +268,14 @@ func (ai AppImage) IntegrateOrUnintegrate() {
 // ReadUpdateInformation reads updateinformation from an AppImage
 // Returns updateinformation string and error
 func (ai AppImage) ReadUpdateInformation() (string, error) {
-	aibytes, err := helpers.GetSectionData(ai.Path, ".upd_info")
+	var aibytes []byte
+	var err error
+	if ai.Type() == 1 {
+		// Type-1 AppImages have it in the ISO9660 primary volume descriptor rather than in an ELF section
+		aibytes, err = readBytesAtOffset(ai.Path, goappimage.Type1UpdateInformationOffset, goappimage.Type1UpdateInformationLength)
+	} else {
+		aibytes, err = helpers.GetSectionData(ai.Path, ".upd_info")
+	}
 	ui := strings.TrimSpace(string(bytes.Trim(aibytes, "\x00")))
 	if err != nil {
 		return "", err
@@ -248,6 +285,18 @@ func (ai AppImage) ReadUpdateInformation() (string, error) {
 	return ui, nil
 }
 
+// readBytesAtOffset reads length bytes at offset from the file at path
+func readBytesAtOffset(path string, offset int64, length int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	_, err = f.ReadAt(data, offset)
+	return data, err
+}
+
 // LaunchMostRecentAppImage launches an the most recent application for a given
 // updateinformation that we found among the integrated AppImages.
 // Kinda like poor man's Launch Services. Probably we should make as much use of it as possible.
@@ -280,6 +329,44 @@ func FindMostRecentAppImageWithMatchingUpdateInformation(updateinformation strin
 // FindAppImagesWithMatchingUpdateInformation finds registered AppImages
 // that have matching upate information embedded
 func FindAppImagesWithMatchingUpdateInformation(updateinformation string) []string {
+	var results []string
+	for _, path := range FindIntegratedAppImages() {
+		ai, err := NewAppImage(path)
+		if err != nil {
+			continue
+		}
+		ui, err := ai.ReadUpdateInformation()
+		if err == nil && ui != "" {
+			//log.Println("updateinformation:", ui)
+			// log.Println("updateinformation:", url.QueryEscape(ui))
+			unescapedui, _ := url.QueryUnescape(ui)
+			// log.Println("updateinformation:", unescapedui)
+			if updateinformation == unescapedui {
+				results = append(results, ai.Path)
+			}
+		}
+	}
+	return results
+}
+
+// FindIntegratedAppImages returns the paths of the AppImages that have desktop files
+// written by us and still exist
+func FindIntegratedAppImages() []string {
+	var results []string
+	for _, dst := range findDesktopFileTargets() {
+		_, err := os.Stat(dst)
+		if os.IsNotExist(err) {
+			log.Println(dst, "does not exist, it is mentioned in a desktop file in", xdg.DataHome+"/applications/")
+			continue
+		}
+		results = append(results, dst)
+	}
+	return results
+}
+
+// findDesktopFileTargets returns the paths of the AppImages that have desktop files
+// written by us, whether they still exist or not
+func findDesktopFileTargets() []string {
 	files, err := ioutil.ReadDir(xdg.DataHome + "/applications/")
 	helpers.LogError("desktop", err)
 	var results []string
@@ -292,28 +379,13 @@ func FindAppImagesWithMatchingUpdateInformation(updateinformation string) []stri
 			cfg, e := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, // Do not cripple lines hat contain ";"
 				xdg.DataHome+"/applications/"+file.Name())
 			helpers.LogError("desktop", e)
-			dst := cfg.Section("Desktop Entry").Key(ExecLocationKey).String()
-			_, err = os.Stat(dst)
-			if os.IsNotExist(err) {
-				log.Println(dst, "does not exist, it is mentioned in", xdg.DataHome+"/applications/"+file.Name())
+			if e != nil {
 				continue
 			}
-			ai, err := NewAppImage(dst)
-			if err != nil {
-				continue
-			}
-			ui, err := ai.ReadUpdateInformation()
-			if err == nil && ui != "" {
-				//log.Println("updateinformation:", ui)
-				// log.Println("updateinformation:", url.QueryEscape(ui))
-				unescapedui, _ := url.QueryUnescape(ui)
-				// log.Println("updateinformation:", unescapedui)
-				if updateinformation == unescapedui {
-					results = append(results, ai.Path)
-				}
+			dst := cfg.Section("Desktop Entry").Key(ExecLocationKey).String()
+			if dst != "" {
+				results = append(results, dst)
 			}
-
-			continue
 		}
 	}
 	return results
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -9,7 +8,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,7 +16,9 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
 	"github.com/prometheus/procfs"
+	"github.com/urfave/cli/v2"
 )
 
 // TODO: Understand whether we can make clever use of
@@ -34,7 +34,8 @@ import (
 
 var quit = make(chan struct{})
 
-var verbosePtr = flag.Bool("v", false, "Print verbose log messages")
+// Set by the flags, see newApp
+var verbosePtr = new(bool)
 
 // The following are disabled for now, because the path to this program can
 // change (e.g., when the user updates it). Lacking a system-wide Launch Services
@@ -42,11 +43,12 @@ var verbosePtr = flag.Bool("v", false, "Print verbose log messages")
 // TODO: Instead of overwriting the desktop files and getting all
 // information from AppImages (slow), we could just rewrite the path to this
 // program in all desktop files. That should be much faster.
-var overwritePtr = flag.Bool("o", false, "Overwrite existing desktop integration files (slower)")
-var cleanPtr = flag.Bool("c", true, "Clean pre-existing desktop files")
+var overwritePtr = new(bool)
+var cleanPtr = new(bool)
 
-var quietPtr = flag.Bool("q", false, "Do not send desktop notifications")
-var noZeroconfPtr = flag.Bool("nz", false, "Do not announce this service on the network using Zeroconf")
+var quietPtr = new(bool)
+var noZeroconfPtr = new(bool)
+var systemPtr = new(bool)
 
 var ToBeIntegratedOrUnintegrated []string
 
@@ -87,42 +89,18 @@ var candidateDirectories = []string{
 }
 
 func main() {
-	thisai, _ = NewAppImage(helpers.Args0())
-
-	// As quickly as possible go there if we are invoked from the command line with a command
-	takeCareOfCommandlineCommands()
-
-	var version string
-	if commit != "" {
-		version = commit
-	} else {
-		version = "unsupported custom build"
-	}
-
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, filepath.Base(os.Args[0])+" "+version+"\n")
-		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, "Optional daemon that registers AppImages and integrates them with the system.\n")
-		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, "Sets the executable bit on AppImages, adds them to the system menu,\n")
-		fmt.Fprintf(os.Stderr, "and makes it possible to launch the most recent AppImage\n that isregistered on the system for a given application.\n")
-		fmt.Fprintf(os.Stderr, "\n")
-
-		// FIXME: Someone please tell me how to do this using flag
-		fmt.Fprintf(os.Stderr, "Commands: \n")
-		fmt.Fprintf(os.Stderr, "run <updateinformation>:\n\tRun the most recent AppImage registered\n\tfor the updateinformation provided\n")
-		fmt.Fprintf(os.Stderr, "start <updateinformation>:\n\tStart the most recent AppImage registered\n\tfor the updateinformation provided and exit immediately\n")
-		fmt.Fprintf(os.Stderr, "update <path to AppImage>:\n\tUpdate the AppImage using the most recent\n\tAppImageUpdate registered\n")
-		fmt.Fprintf(os.Stderr, "wrap <path to executable>:\n\tExecute the exeutable and send\n\tdesktop notifications for any errors\n")
-		fmt.Fprintf(os.Stderr, "\n")
-
-		flag.PrintDefaults()
+	err := newApp().Run(takeSystemFlag(os.Args))
+	if err != nil {
+		helpers.PrintError("appimaged", err)
+		os.Exit(1)
 	}
-	flag.Parse()
+}
 
+// runDaemon is what appimaged does unless it is invoked with a command:
+// integrate the AppImages in the watched directories and keep watching them
+func runDaemon(c *cli.Context) error {
 	// Always show version
-	fmt.Println(filepath.Base(os.Args[0]), version)
+	fmt.Println(filepath.Base(os.Args[0]), c.App.Version)
 
 	for _, dir := range candidateDirectories {
 		if helpers.Exists(dir) {
@@ -132,7 +110,9 @@ func main() {
 
 	checkPrerequisites()
 
-	setupToRunThroughSystemd()
+	if *systemPtr == false {
+		setupToRunThroughSystemd()
+	}
 	// fmt.Println("Setting as autostart...")
 	// setMyselfAsAutostart()
 
@@ -140,6 +120,7 @@ func main() {
 	// FANotifyMonitor() // fanotifymonitor error: operation not permitted
 
 	installFilemanagerContextMenus()
+	installLauncher()
 
 	// ptrue := true // Nasty trick from https://code-review.googlesource.com/c/gocloud/+/26730/3/bigquery/query.go
 	// overwritePtr = &ptrue
@@ -159,6 +140,17 @@ func main() {
 
 	}
 
+	// Let others ask us to trust quarantined AppImages.
+	// In system mode, trusting is done with "appimaged --system trust", authorized by polkit
+	if *systemPtr == false {
+		go exportPolicyService()
+	}
+
+	// Check GitHub Releases for updates of the integrated AppImages
+	if *noUpdateCheckPtr == false {
+		go checkForUpdatesPeriodically()
+	}
+
 	// go monitorDbusSessionBus() // If used, then nothing else can use DBus anymore? FIXME #####################
 
 	// SimpleNotify("Starting", helpers.Here(), 5000)
@@ -166,7 +158,7 @@ func main() {
 	log.Println("main: Running from", helpers.Here())
 	log.Println("main: xdg.DataHome =", xdg.DataHome)
 
-	helpers.DeleteDesktopFilesWithNonExistingTargets()
+	queueDeadIntegrations()
 
 	log.Println("Overwrite:", *overwritePtr)
 	log.Println("Clean:", *overwritePtr)
@@ -194,7 +186,7 @@ func main() {
 	// but perhaps it is why KDE ignores our nice thumbnails
 
 	// React to partitions being mounted and unmounted
-	go monitorUdisks()
+	go monitorRemovableMedia()
 
 	watchDirectories()
 
@@ -232,7 +224,7 @@ func main() {
 	}()
 
 	<-quit
-
+	return nil
 }
 
 // checkMQTTConnected checks whether the MQTT client is
@@ -286,22 +278,29 @@ func moveDesktopFiles() {
 	// so that we won't get "too many files open" errors
 	var sem = make(chan int, 1024)
 
-	for _, path := range ToBeIntegratedOrUnintegrated {
-		ai, err := NewAppImage(path)
-		if err != nil {
-			continue
+	// Integrate before removing integrations, so that the integration of an AppImage
+	// that was moved can follow it (see moved.go) before it would be removed
+	for _, existing := range []bool{true, false} {
+		for _, path := range ToBeIntegratedOrUnintegrated {
+			if helpers.Exists(path) != existing {
+				continue
+			}
+			ai, err := NewAppImage(path)
+			if err != nil && helpers.Exists(path) {
+				continue
+			}
+			sem <- 1
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ai.IntegrateOrUnintegrate()
+				ToBeIntegratedOrUnintegrated = RemoveFromSlice(ToBeIntegratedOrUnintegrated, ai.Path)
+			}()
+			<-sem
 		}
-		sem <- 1
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			ai.IntegrateOrUnintegrate()
-			ToBeIntegratedOrUnintegrated = RemoveFromSlice(ToBeIntegratedOrUnintegrated, ai.Path)
-		}()
-		<-sem
-	}
 
-	wg.Wait() // Wait until all go functions have completed
+		wg.Wait() // Wait until all go functions have completed
+	}
 
 	// If this wait is too short, then we may be running into race conditions which can lead to crashes?
 
@@ -333,10 +332,10 @@ func moveDesktopFiles() {
 			// e.g., has downloaded it.
 			// TODO: Find out which application was added, and show its icon, make the notification clickable
 			// to open the application
-			sendDesktopNotification("Added application", "", 5000)
+			sendDesktopNotification(i18n.Sprintf("Added application"), "", 5000)
 		} else {
 			// If more than one has been integrated, then let's just display the number (or even nothing?)
-			sendDesktopNotification("Added "+strconv.Itoa(len(files))+" applications", "", 5000)
+			sendDesktopNotification(i18n.Sprintf("Added %d applications", len(files)), "", 5000)
 		}
 
 		// Run the various tools that make sure that the added desktop files really show up in the menu.
@@ -393,7 +392,11 @@ func watchDirectories() {
 	// Register AppImages from well-known locations
 	// https://github.com/AppImage/appimaged#monitored-directories
 	home, _ := os.UserHomeDir()
-	err := os.MkdirAll(home+"/Applications", 0755)
+	applicationsDir := home + "/Applications"
+	if *systemPtr {
+		applicationsDir = systemAppImagesDir
+	}
+	err := os.MkdirAll(applicationsDir, 0755)
 	if err != nil {
 		helpers.PrintError("main", err)
 	}
@@ -408,6 +411,10 @@ func watchDirectories() {
 	// FIXME: This breaks when the partition label has "-", see https://github.com/prometheus/procfs/issues/227
 
 	for _, mount := range mounts {
+		// Removable media belong to the user who mounted them
+		if *systemPtr {
+			break
+		}
 		if *verbosePtr == true {
 			log.Println("main: MountPoint", mount.MountPoint)
 		}
@@ -418,7 +425,7 @@ func watchDirectories() {
 			fmt.Println(mount.SuperOptions)
 			if helpers.Exists(mount.MountPoint + "/Applications") {
 				if _, ok := mount.SuperOptions["showexec"]; ok {
-					go sendErrorDesktopNotification("UDisks showexec issue", "Applications cannot run from \n"+mount.MountPoint+". \nSee \nhttps://github.com/storaged-project/udisks/issues/707")
+					go sendErrorDesktopNotification(i18n.Sprintf("UDisks showexec issue"), i18n.Sprintf("Applications cannot run from \n%s. \nSee \n%s", mount.MountPoint, "https://github.com/storaged-project/udisks/issues/707"))
 					printUdisksShowexecHint()
 				} else {
 					watchedDirectories = helpers.AppendIfMissing(watchedDirectories, mount.MountPoint+"/Applications")
@@ -431,7 +438,7 @@ func watchDirectories() {
 
 	watchDirectoriesReally(watchedDirectories)
 
-	helpers.DeleteDesktopFilesWithNonExistingTargets()
+	queueDeadIntegrations()
 	// So this should also catch AppImages which were formerly hidden in some subdirectory
 	// where the whole directory was deleted
 }
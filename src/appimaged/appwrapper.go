@@ -16,32 +16,34 @@ import (
 	"github.com/adrg/xdg"
 	"github.com/godbus/dbus/v5"
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
 	"gopkg.in/ini.v1"
 )
 
-func appwrap() {
+// appwrap runs the executable args[0] with the arguments args[1:]
+func appwrap(args []string) {
 
-	if len(os.Args) < 3 {
+	if len(args) < 1 {
 		log.Println("Argument missing")
 		os.Exit(1)
 	}
 
-	cmd := exec.Command(os.Args[2], os.Args[3:]...)
+	cmd := exec.Command(args[0], args[1:]...)
 
 	var out bytes.Buffer
 	cmd.Stderr = &out
 
-	// Find desktop file(s) that point to the executable in os.Args[2],
+	// Find desktop file(s) that point to the executable in args[0],
 	// and check them with desktop-file-verify; display notification if verification fails
-	go checkDesktopFiles(os.Args[2])
+	go checkDesktopFiles(args[0])
 
-	ai, err := NewAppImage(os.Args[2])
+	ai, err := NewAppImage(args[0])
 
 	if err == nil {
 		// TODO: If we have an AppImage, then check the updateinformation inside the AppImage (or better: lint the AppImage)
 		err := ai.Validate()
 		if err != nil {
-			sendDesktopNotification(ai.Name+" is not a proper AppImage", err.Error()+"\nPlease ask the author to fix it.", 30000)
+			sendDesktopNotification(i18n.Sprintf("%s is not a proper AppImage", ai.Name), i18n.Sprintf("%s\nPlease ask the author to fix it.", err.Error()), 30000)
 		}
 		// TODO: If we have an AppImage, then check the desktop file inside the AppImage (or better: lint the AppDir, reuse code from appimagetool)
 		// TODO: If we have an AppImage, then check that the .DirIcon  inside the AppImage exists (or better: lint the AppDir, reuse code from appimagetool)
@@ -61,31 +63,31 @@ func appwrap() {
 				// If what we launched (and failed) was an AppImage, then use its nice (short) name
 				// to display the error message
 				var appname string
-				ai, err := NewAppImage(os.Args[2])
+				ai, err := NewAppImage(args[0])
 				if err == nil {
 					appname = ai.Name
 				} else {
-					appname = filepath.Base(os.Args[2])
+					appname = filepath.Base(args[0])
 				}
 
-				summary := "Cannot open " + appname
+				summary := i18n.Sprintf("Cannot open %s", appname)
 				body := strings.TrimSpace(out.String())
 
 				if strings.Contains(out.String(), "cannot open shared object file: No such file or directory") == true {
 					parts := strings.Split(out.String(), ":")
-					body = "Missing library " + strings.TrimSpace(parts[2])
+					body = i18n.Sprintf("Missing library %s", strings.TrimSpace(parts[2]))
 					// summary = "Error: Missing library " + strings.TrimSpace(parts[2])
-					// body = filepath.Base(os.Args[2]) + " could not be started because " + strings.TrimSpace(parts[2]) + " is missing"
+					// body = filepath.Base(args[0]) + " could not be started because " + strings.TrimSpace(parts[2]) + " is missing"
 				}
 
 				// https://github.com/AppImage/AppImageKit/issues/1004
 				if strings.Contains(out.String(), "execv error") == true && err == nil {
-					body = filepath.Base(os.Args[2]) + " is defective, AppRun is missing. \nPlease ask the author to fix it."
+					body = i18n.Sprintf("%s is defective, AppRun is missing. \nPlease ask the author to fix it.", filepath.Base(args[0]))
 				}
 
 				// https://github.com/pinnaculum/galacteek/issues/6
 				if strings.Contains(out.String(), "Could not load the Qt platform plugin") == true && err == nil {
-					body = filepath.Base(os.Args[2]) + " is defective, could not load the Qt platform plugin. \nPlease run on the command line with 'QT_DEBUG_PLUGINS=1' \nto see error messages and ask the author to fix it."
+					body = i18n.Sprintf("%s is defective, could not load the Qt platform plugin. \nPlease run on the command line with 'QT_DEBUG_PLUGINS=1' \nto see error messages and ask the author to fix it.", filepath.Base(args[0]))
 				}
 
 				sendErrorDesktopNotification(summary, body)
@@ -105,7 +107,6 @@ func sendErrorDesktopNotification(title string, body string) {
 	log.Println(body)
 
 	conn, err := dbus.SessionBusPrivate() // When using SessionBusPrivate(), need to follow with Auth(nil) and Hello()
-	defer conn.Close()
 	if err != nil {
 		helpers.PrintError("SessionBusPrivate", err)
 		return
@@ -114,6 +115,7 @@ func sendErrorDesktopNotification(title string, body string) {
 		helpers.PrintError("No conn", err)
 		return
 	}
+	defer conn.Close()
 
 	if err = conn.Auth(nil); err != nil {
 		helpers.PrintError("Auth", err)
@@ -176,7 +178,7 @@ func checkDesktopFiles(executablefilepath string) {
 		// log.Println(dfile)
 		err := helpers.ValidateDesktopFile(xdg.DataHome + "/applications/" + dfile)
 		if err != nil {
-			sendErrorDesktopNotification("Invalid desktop file", executablefilepath+"\n\n"+err.Error())
+			sendErrorDesktopNotification(i18n.Sprintf("Invalid desktop file"), executablefilepath+"\n\n"+err.Error())
 		}
 	}
 }
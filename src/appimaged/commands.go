@@ -5,69 +5,327 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 
+	"github.com/probonopd/go-appimage/internal/clidoc"
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+	"github.com/urfave/cli/v2"
 )
 
-func takeCareOfCommandlineCommands() {
+// newApp defines the flags and the commands of appimaged. Without a command, it runs as the daemon
+func newApp() *cli.App {
+	version := commit
+	if version == "" {
+		version = "unsupported custom build"
+	}
+
+	// -v is taken by --verbose
+	cli.VersionFlag = &cli.BoolFlag{Name: "version", Usage: "print the version"}
 
-	if len(os.Args) < 2 {
-		return
+	app := &cli.App{
+		Name:    "appimaged",
+		Version: version,
+		Usage:   "Optional daemon that registers AppImages and integrates them with the system",
+		Description: "Sets the executable bit on AppImages, adds them to the system menu,\n" +
+			"and makes it possible to launch the most recent AppImage\n" +
+			"that is registered on the system for a given application.",
+		Authors:              []*cli.Author{{Name: "AppImage Project"}},
+		Copyright:            "MIT License",
+		EnableBashCompletion: true,
+		Before:               beforeCommands,
+		Action:               runDaemon,
 	}
 
-	// As quickly as possible go there if we are invoked with the "wrap" command
-	if os.Args[1] == "wrap" {
-		appwrap()
-		os.Exit(0)
+	app.Flags = []cli.Flag{
+		&cli.BoolFlag{Name: "v", Usage: "Print verbose log messages", Destination: verbosePtr},
+		&cli.BoolFlag{Name: "o", Usage: "Overwrite existing desktop integration files (slower)", Destination: overwritePtr},
+		&cli.BoolFlag{Name: "c", Value: true, Usage: "Clean pre-existing desktop files", Destination: cleanPtr},
+		&cli.BoolFlag{Name: "q", Usage: "Do not send desktop notifications", Destination: quietPtr},
+		&cli.BoolFlag{Name: "nz", Usage: "Do not announce this service on the network using Zeroconf", Destination: noZeroconfPtr},
+		&cli.BoolFlag{Name: "nu", Usage: "Do not periodically check GitHub Releases for updates", Destination: noUpdateCheckPtr},
+		&cli.BoolFlag{Name: "system", Usage: "Integrate the AppImages in " + systemAppImagesDir + " for all users, see \"System-wide integration\" in the README", Destination: systemPtr},
 	}
 
-	// As quickly as possible go there if we are invoked with the "update" command
-	if os.Args[1] == "update" {
-		update()
-		os.Exit(0)
+	// The commands that run other programs pass all of their arguments on
+	app.Commands = []*cli.Command{
+		{
+			Name:            "run",
+			Usage:           "Run the most recent AppImage registered for the updateinformation provided",
+			ArgsUsage:       "<updateinformation> [arguments]",
+			SkipFlagParsing: true,
+			Action:          runMostRecent,
+		},
+		{
+			Name:            "start",
+			Usage:           "Start the most recent AppImage registered for the updateinformation provided and exit immediately",
+			ArgsUsage:       "<updateinformation> [arguments]",
+			SkipFlagParsing: true,
+			Action:          runMostRecent,
+		},
+		{
+			Name:      "update",
+			Usage:     "Update the AppImage using zsync, or the most recent AppImageUpdater registered",
+			ArgsUsage: "<path to AppImage>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					fmt.Println(i18n.Sprintf("Argument missing"))
+					os.Exit(1)
+				}
+				runUpdate(c.Args().First())
+				return nil
+			},
+		},
+		{
+			Name:   "install",
+			Usage:  "Install into ~/.local/bin and run at login using systemd or XDG autostart (with --system: into /usr/local/bin for all users)",
+			Action: installCommand,
+		},
+		{
+			Name:   "uninstall",
+			Usage:  "Reverse install and remove all integration",
+			Action: uninstallCommand,
+		},
+		{
+			// Used by the desktop file that opens AppImages, see launcher.go
+			Name:            "open",
+			Usage:           "Make the AppImage executable, ask whether to integrate it, and run it",
+			ArgsUsage:       "<path to AppImage> [arguments]",
+			SkipFlagParsing: true,
+			Action:          openCommand,
+		},
+		{
+			Name:  "list",
+			Usage: "List the integrated and quarantined AppImages",
+			Action: func(c *cli.Context) error {
+				listIntegrated()
+				return nil
+			},
+		},
+		{
+			Name:      "integrate",
+			Usage:     "Integrate the AppImage right away",
+			ArgsUsage: "<path to AppImage>",
+			Action:    integrateCommand,
+		},
+		{
+			Name:      "unintegrate",
+			Usage:     "Remove the integration of the AppImage",
+			ArgsUsage: "<path to AppImage>",
+			Action:    integrateCommand,
+		},
+		{
+			Name:      "search",
+			Usage:     "Search the integrated AppImages by name, keywords, and description",
+			ArgsUsage: "<term>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					fmt.Println(i18n.Sprintf("No search term supplied"))
+					os.Exit(1)
+				}
+				searchIntegrated(strings.Join(c.Args().Slice(), " "))
+				return nil
+			},
+		},
+		{
+			Name:            "launch",
+			Usage:           "Run the most recent integrated AppImage with the name provided",
+			ArgsUsage:       "<name> [arguments]",
+			SkipFlagParsing: true,
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					fmt.Println(i18n.Sprintf("No name supplied"))
+					os.Exit(1)
+				}
+				err := launchByName(c.Args().First(), c.Args().Tail())
+				if err != nil {
+					helpers.PrintError("launch", err)
+					os.Exit(1)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "trust",
+			Usage:     "Integrate a quarantined AppImage and trust it from now on",
+			ArgsUsage: "<path to AppImage>",
+			Action:    trustCommand,
+		},
+		{
+			Name:  "quarantined",
+			Usage: "List the AppImages that were not integrated because of the policy in " + policyFile,
+			Action: func(c *cli.Context) error {
+				for _, path := range readQuarantined() {
+					fmt.Println(path)
+				}
+				return nil
+			},
+		},
+		{
+			Name:            "wrap",
+			Usage:           "Execute the executable and send desktop notifications for any errors",
+			ArgsUsage:       "<path to executable> [arguments]",
+			SkipFlagParsing: true,
+			Action: func(c *cli.Context) error {
+				appwrap(c.Args().Slice())
+				return nil
+			},
+		},
 	}
+	// 'completion' and 'man', generated from the definitions above
+	app.Commands = append(app.Commands, clidoc.Commands()...)
 
-	// As quickly as possible run the most recent AppImage we can find if we are
-	// invoked with the "run" command and updateinformation as arguments
-	// appimaged run <updateinformation>: Waits for the process to exit
-	// appimaged start <updateinformation>: Does not wait and exits immediately after having tried to launch
-	if os.Args[1] == "run" || os.Args[1] == "start" {
-		if len(os.Args) < 3 {
-			fmt.Println("No updateinformation supplied")
-			os.Exit(1)
-		}
+	return app
+}
+
+// beforeCommands runs after the flags were parsed, before the command or the daemon
+func beforeCommands(c *cli.Context) error {
+	// Needs to be known before the commands run
+	if *systemPtr {
+		enableSystemMode()
+	}
+	thisai, _ = NewAppImage(helpers.Args0())
+	return nil
+}
 
-		err := helpers.ValidateUpdateInformation(os.Args[2])
-		var ui string
+// Trust a quarantined AppImage, preferably through the running daemon so that it gets integrated right away
+func trustCommand(c *cli.Context) error {
+	if c.NArg() < 1 {
+		fmt.Println(i18n.Sprintf("No AppImage supplied"))
+		os.Exit(1)
+	}
+	path, err := filepath.Abs(c.Args().First())
+	if err != nil {
+		helpers.PrintError("trust", err)
+		os.Exit(1)
+	}
+	if *systemPtr {
+		requireRoot()
+		err = trust(path)
 		if err == nil {
-			ui = os.Args[2]
-		} else {
-			fmt.Println("Invalid updateinformation string supplied")
+			err = integrateNow(path)
+		}
+		if err != nil {
+			helpers.PrintError("trust", err)
 			os.Exit(1)
 		}
-		a := FindMostRecentAppImageWithMatchingUpdateInformation(ui)
-		if a == "" {
-			fmt.Println("No AppImage found for,")
-		} else {
-			comnd := []string{a}
-			comnd = append(comnd, os.Args[3:]...)
-
-			if os.Args[1] == "run" {
-				err = helpers.RunCmdTransparently(comnd)
-				if err != nil {
-					helpers.PrintError("LaunchMostRecentAppImage", err)
-				}
-			} else {
-				cmd := exec.Command(comnd[0], comnd[1:]...)
-				err := cmd.Start()
-				if err != nil {
-					fmt.Println(err)
-					os.Exit(1)
-				}
-				os.Exit(0)
-			}
+		return nil
+	}
+	reached, err := trustUsingDaemon(path)
+	if !reached {
+		err = trust(path)
+		if err == nil {
+			fmt.Println(i18n.Sprintf("Trusted %s; it will be integrated the next time appimaged runs", path))
 		}
+	}
+	if err != nil {
+		helpers.PrintError("trust", err)
+		os.Exit(1)
+	}
+	return nil
+}
+
+func installCommand(c *cli.Context) error {
+	var err error
+	if *systemPtr {
+		requireRoot()
+		err = installSystemWide()
+	} else {
+		err = install()
+	}
+	if err != nil {
+		helpers.PrintError("install", err)
+		os.Exit(1)
+	}
+	return nil
+}
+
+func uninstallCommand(c *cli.Context) error {
+	if *systemPtr {
+		requireRoot()
+		uninstallSystemWide()
+		return nil
+	}
+	uninstall()
+	return nil
+}
+
+func openCommand(c *cli.Context) error {
+	if c.NArg() < 1 {
+		fmt.Println(i18n.Sprintf("No AppImage supplied"))
+		os.Exit(1)
+	}
+	err := openAppImage(c.Args().First(), c.Args().Tail())
+	if err != nil {
+		helpers.PrintError("open", err)
+		sendErrorDesktopNotification(i18n.Sprintf("Cannot open %s", filepath.Base(c.Args().First())), err.Error())
+		os.Exit(1)
+	}
+	return nil
+}
+
+// Manage the integration without a running daemon, for both "integrate" and "unintegrate"
+func integrateCommand(c *cli.Context) error {
+	if c.NArg() < 1 {
+		fmt.Println(i18n.Sprintf("No AppImage supplied"))
+		os.Exit(1)
+	}
+	if *systemPtr {
+		requireRoot()
+	}
+	var err error
+	if c.Command.Name == "integrate" {
+		err = integrateNow(c.Args().First())
+	} else {
+		err = unintegrateNow(c.Args().First())
+	}
+	if err != nil {
+		helpers.PrintError(c.Command.Name, err)
+		os.Exit(1)
+	}
+	return nil
+}
+
+// Run the most recent AppImage we can find for the updateinformation given
+// appimaged run <updateinformation>: Waits for the process to exit
+// appimaged start <updateinformation>: Does not wait and exits immediately after having tried to launch
+func runMostRecent(c *cli.Context) error {
+	if c.NArg() < 1 {
+		fmt.Println(i18n.Sprintf("No updateinformation supplied"))
 		os.Exit(1)
 	}
 
+	err := helpers.ValidateUpdateInformation(c.Args().First())
+	var ui string
+	if err == nil {
+		ui = c.Args().First()
+	} else {
+		fmt.Println(i18n.Sprintf("Invalid updateinformation string supplied"))
+		os.Exit(1)
+	}
+	a := FindMostRecentAppImageWithMatchingUpdateInformation(ui)
+	if a == "" {
+		fmt.Println(i18n.Sprintf("No AppImage found for %s", ui))
+	} else {
+		comnd := []string{a}
+		comnd = append(comnd, c.Args().Tail()...)
+
+		if c.Command.Name == "run" {
+			err = helpers.RunCmdTransparently(comnd)
+			if err != nil {
+				helpers.PrintError("LaunchMostRecentAppImage", err)
+			}
+		} else {
+			cmd := exec.Command(comnd[0], comnd[1:]...)
+			err := cmd.Start()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+	os.Exit(1)
+	return nil
 }
@@ -53,7 +53,6 @@ func removeDuplicatesUnordered(elements []string) []string {
 func monitorDbusSessionBus() {
 
 	conn, err := dbus.SessionBusPrivate() // When using SessionBusPrivate(), need to follow with Auth(nil) and Hello()
-	defer conn.Close()
 	if err != nil {
 		helpers.PrintError("SessionBusPrivate", err)
 		return
@@ -62,6 +61,7 @@ func monitorDbusSessionBus() {
 		helpers.PrintError("No conn", err)
 		return
 	}
+	defer conn.Close()
 
 	if err = conn.Auth(nil); err != nil {
 		helpers.PrintError("Auth", err)
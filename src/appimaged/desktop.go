@@ -93,7 +93,9 @@ func writeDesktopFile(ai AppImage) {
 	// so that renaming the file in the file manager results in a changed name in the menu
 	// FIXME: If the thumbnail is not generated here but by another external thumbnailer, it may not be fast enough
 	time.Sleep(1 * time.Second)
-	cfg.Section("Desktop Entry").Key("Exec").SetValue(arg0abs + " wrap \"" + ai.Path + "\"") // Resolve to a full path
+	// Keep the arguments of the original Exec= line, such as %F, so that the application can still open files
+	originalExec := cfg.Section("Desktop Entry").Key("Exec").String()
+	cfg.Section("Desktop Entry").Key("Exec").SetValue(rewriteExec(originalExec, arg0abs, ai.Path)) // Resolve to a full path
 	cfg.Section("Desktop Entry").Key(ExecLocationKey).SetValue(ai.Path)
 	cfg.Section("Desktop Entry").Key("TryExec").SetValue(arg0abs) // Resolve to a full path
 	// For icons, use absolute paths. This way icons start working
@@ -115,15 +117,32 @@ func writeDesktopFile(ai AppImage) {
 	*/
 	cfg.Section("Desktop Entry").Key("Comment").SetValue(ai.Path)
 	cfg.Section("Desktop Entry").Key("X-AppImage-Identifier").SetValue(ai.md5)
+	setIdentity(cfg, ai)
 	ui := ai.updateinformation
 	if ui != "" {
 		cfg.Section("Desktop Entry").Key(helpers.UpdateInformationKey).SetValue("\"" + ui + "\"")
 	}
 	// Actions
 
+	// Keep the actions of the AppImage itself, e.g., "New Window", and make them run the AppImage.
+	// MimeType, Keywords, Categories and the like are kept as they are anyway
 	var actions []string
+	for _, action := range strings.Split(cfg.Section("Desktop Entry").Key("Actions").String(), "；") {
+		action = strings.TrimSpace(action)
+		if action == "" {
+			continue
+		}
+		section, err := cfg.GetSection("Desktop Action " + action)
+		if err != nil {
+			continue
+		}
+		if section.HasKey("Exec") {
+			section.Key("Exec").SetValue(rewriteExec(section.Key("Exec").String(), arg0abs, ai.Path))
+		}
+		actions = helpers.AppendIfMissing(actions, action)
+	}
 
-	if isWritable(ai.Path) {
+	if isWritable(ai.Path) && *systemPtr == false {
 		// Add "Move to Trash" action
 		// if the AppImage is writeable (= the user can remove it)
 		//
@@ -135,7 +154,7 @@ func writeDesktopFile(ai AppImage) {
 		// ~/.local/share/Trash/ → on your local file system.
 		// /root/.local/share/Trash/ → if you are root, on your local file system.
 		// /media/PENDRIVE/.Trash-1000/ → on a USB drive.
-		actions = append(actions, "Trash")
+		actions = helpers.AppendIfMissing(actions, "Trash")
 		cfg.Section("Desktop Action Trash").Key("Name").SetValue("Move to Trash")
 		if helpers.IsCommandAvailable("gio") {
 			// A command line tool to move files to the Trash. However, GNOME-specific
@@ -149,12 +168,12 @@ func writeDesktopFile(ai AppImage) {
 		}
 
 		// Add OpenPortableHome action
-		actions = append(actions, "OpenPortableHome")
+		actions = helpers.AppendIfMissing(actions, "OpenPortableHome")
 		cfg.Section("Desktop Action OpenPortableHome").Key("Name").SetValue("Open Portable Home in File Manager")
 		cfg.Section("Desktop Action OpenPortableHome").Key("Exec").SetValue("xdg-open \"" + ai.Path + ".home\"")
 
 		// Add CreatePortableHome action
-		actions = append(actions, "CreatePortableHome")
+		actions = helpers.AppendIfMissing(actions, "CreatePortableHome")
 		cfg.Section("Desktop Action CreatePortableHome").Key("Name").SetValue("Create Portable Home")
 		cfg.Section("Desktop Action CreatePortableHome").Key("Exec").SetValue("mkdir -p \"" + ai.Path + ".home\"")
 
@@ -168,12 +187,22 @@ func writeDesktopFile(ai AppImage) {
 
 	// Add "Extract" action
 	// TODO: Actually, we could do the extraction ourselves since we have the extraction logic on board anyways
-	// then we could have a better name for the extracted location, and could handle type-1 as well
+	// then we could have a better name for the extracted location
 	// TODO: Maybe have a dbus action for extracting AppImages that could be invoked?
-	if ai.Type() > 1 {
-		actions = append(actions, "Extract")
+	if ai.Type() == 1 {
+		// The runtime of type-1 AppImages cannot extract them, but bsdtar can read the ISO9660 image
+		actions = helpers.AppendIfMissing(actions, "Extract")
+		cfg.Section("Desktop Action Extract").Key("Name").SetValue("Extract to AppDir")
+		extract := " && mkdir -p squashfs-root && bsdtar -C squashfs-root -xf '" + ai.Path + "'"
+		if isWritable(ai.Path) && *systemPtr == false {
+			cfg.Section("Desktop Action Extract").Key("Exec").SetValue("bash -c \"cd '" + filepath.Clean(ai.Path+"/../") + "'" + extract + " && xdg-open '" + filepath.Clean(ai.Path+"/../squashfs-root") + "'\"")
+		} else {
+			cfg.Section("Desktop Action Extract").Key("Exec").SetValue("bash -c \"cd ~" + extract + " && xdg-open ~/squashfs-root\"")
+		}
+	} else if ai.Type() > 1 {
+		actions = helpers.AppendIfMissing(actions, "Extract")
 		cfg.Section("Desktop Action Extract").Key("Name").SetValue("Extract to AppDir")
-		if isWritable(ai.Path) {
+		if isWritable(ai.Path) && *systemPtr == false {
 			cfg.Section("Desktop Action Extract").Key("Exec").SetValue("bash -c \"cd '" + filepath.Clean(ai.Path+"/../") + "' && '" + ai.Path + "' --appimage-extract" + " && xdg-open '" + filepath.Clean(ai.Path+"/../squashfs-root") + "'\"")
 		} else {
 			cfg.Section("Desktop Action Extract").Key("Exec").SetValue("bash -c \"cd ~ && '" + ai.Path + "' --appimage-extract" + " && xdg-open ~/squashfs-root\"")
@@ -184,14 +213,14 @@ func writeDesktopFile(ai AppImage) {
 
 	// Add "Update" action
 	if ai.updateinformation != "" {
-		actions = append(actions, "Update")
+		actions = helpers.AppendIfMissing(actions, "Update")
 		cfg.Section("Desktop Action Update").Key("Name").SetValue("Update")
 		cfg.Section("Desktop Action Update").Key("Exec").SetValue(os.Args[0] + " update \"" + ai.Path + "\"")
 	}
 
 	// Add "Open Containing Folder" action
 	if helpers.IsCommandAvailable("xdg-open") {
-		actions = append(actions, "Show")
+		actions = helpers.AppendIfMissing(actions, "Show")
 		cfg.Section("Desktop Action Show").Key("Name").SetValue("Open Containing Folder")
 		cfg.Section("Desktop Action Show").Key("Exec").SetValue("xdg-open \"" + filepath.Clean(ai.Path+"/../") + "\"")
 	}
@@ -213,19 +242,19 @@ func writeDesktopFile(ai AppImage) {
 	// TODO: Based on what the AppImage author has specified, run AppImages by default
 	// with the matching subsets of rights, e.g., without network access
 	if helpers.IsCommandAvailable("firejail") {
-		actions = append(actions, "Firejail")
+		actions = helpers.AppendIfMissing(actions, "Firejail")
 		cfg.Section("Desktop Action Firejail").Key("Name").SetValue("Run in Firejail")
 		cfg.Section("Desktop Action Firejail").Key("Exec").SetValue("firejail --env=DESKTOPINTEGRATION=appimaged --noprofile --appimage \"" + ai.Path + "\"")
 
-		actions = append(actions, "FirejailNoNetwork")
+		actions = helpers.AppendIfMissing(actions, "FirejailNoNetwork")
 		cfg.Section("Desktop Action FirejailNoNetwork").Key("Name").SetValue("Run in Firejail Without Network Access")
 		cfg.Section("Desktop Action FirejailNoNetwork").Key("Exec").SetValue("firejail --env=DESKTOPINTEGRATION=appimaged --noprofile --net=none --appimage \"" + ai.Path + "\"")
 
-		actions = append(actions, "FirejailPrivate")
+		actions = helpers.AppendIfMissing(actions, "FirejailPrivate")
 		cfg.Section("Desktop Action FirejailPrivate").Key("Name").SetValue("Run in Private Firejail Sandbox")
 		cfg.Section("Desktop Action FirejailPrivate").Key("Exec").SetValue("firejail --env=DESKTOPINTEGRATION=appimaged --noprofile --private --appimage \"" + ai.Path + "\"")
 
-		actions = append(actions, "FirejailOverlayTmpfs")
+		actions = helpers.AppendIfMissing(actions, "FirejailOverlayTmpfs")
 		cfg.Section("Desktop Action FirejailOverlayTmpfs").Key("Name").SetValue("Run in Firejail with Temporary Overlay Filesystem")
 		cfg.Section("Desktop Action FirejailOverlayTmpfs").Key("Exec").SetValue("firejail --env=DESKTOPINTEGRATION=appimaged --noprofile --overlay-tmpfs --appimage \"" + ai.Path + "\"")
 	}
@@ -251,6 +280,29 @@ func writeDesktopFile(ai AppImage) {
 	}
 }
 
+// rewriteExec returns an Exec= value that runs the AppImage at path through "appimaged wrap"
+// with the arguments of exec, which is an Exec= value from inside the AppImage
+func rewriteExec(exec string, arg0abs string, path string) string {
+	exec = strings.TrimSpace(exec)
+	// Remove the executable, which may be quoted
+	if strings.HasPrefix(exec, "\"") {
+		if i := strings.Index(exec[1:], "\""); i >= 0 {
+			exec = exec[i+2:]
+		} else {
+			exec = ""
+		}
+	} else if i := strings.IndexAny(exec, " \t"); i >= 0 {
+		exec = exec[i:]
+	} else {
+		exec = ""
+	}
+	args := strings.TrimSpace(exec)
+	if args != "" {
+		args = " " + args
+	}
+	return arg0abs + " wrap \"" + path + "\"" + args
+}
+
 // Return true if a path to a file is writable
 func isWritable(path string) bool {
 	return unix.Access(path, unix.W_OK) == nil
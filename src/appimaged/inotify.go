@@ -49,8 +49,9 @@ func inotifyWatch(path string) {
 		// Block until an event is received.
 		switch ei := <-c; ei.Event() {
 		case notify.InDeleteSelf:
-			log.Println("TODO:", ei.Path(), "was deleted, un-integrate all AppImages that were conteined herein")
+			log.Println("inotifyWatch:", ei.Path(), "was deleted, removing the integration of the AppImages in it")
 			ToBeIntegratedOrUnintegrated = helpers.AppendIfMissing(ToBeIntegratedOrUnintegrated, ei.Path())
+			queueDeadIntegrations()
 			// log.Println("ToBeIntegratedOrUnintegrated now contains:", ToBeIntegratedOrUnintegrated)
 		default:
 			log.Println("inotifyWatch:", ei.Path(), ei.Event())
@@ -0,0 +1,139 @@
+// Installs appimaged for the current user so that it runs at login,
+// and removes it again including everything it has integrated.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/adrg/xdg"
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Where appimaged gets installed to
+var installedPath = home + "/.local/bin/appimaged"
+
+// The systemd user unit written by installServiceFileInHome
+var serviceFilePath = xdg.ConfigHome + "/systemd/user/appimaged.service"
+
+// The XDG autostart desktop file used on systems without systemd
+var autostartFilePath = xdg.ConfigHome + "/autostart/appimaged.desktop"
+
+// install copies appimaged into ~/.local/bin, or updates the copy there,
+// and makes it run at login using a systemd user unit or, without systemd, XDG autostart
+func install() error {
+	// When running from an AppImage, install the AppImage rather than the binary inside of it
+	source := os.Getenv("APPIMAGE")
+	if source == "" {
+		var err error
+		source, err = os.Executable()
+		if err != nil {
+			return err
+		}
+	}
+	err := os.MkdirAll(filepath.Dir(installedPath), 0755)
+	if err != nil {
+		return err
+	}
+	if source != installedPath {
+		// Copy next to it and rename, so that a running appimaged can be replaced
+		err = helpers.CopyFile(source, installedPath+".new")
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(installedPath+".new", 0755)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(installedPath+".new", installedPath)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Installed", source, "to", installedPath)
+	}
+
+	if CheckIfRunningSystemd() == true {
+		installServiceFileInHome(installedPath)
+		for _, args := range [][]string{{"enable", "appimaged"}, {"restart", "appimaged"}} {
+			prc := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+			out, err := prc.CombinedOutput()
+			if err != nil {
+				return errors.New(prc.String() + ": " + err.Error() + "\n" + string(out))
+			}
+		}
+		fmt.Println("Enabled and started the systemd user unit", serviceFilePath)
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(autostartFilePath), 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(autostartFilePath, []byte(`[Desktop Entry]
+Type=Application
+Name=appimaged
+Comment=AppImage system integration daemon
+Exec=`+installedPath+`
+NoDisplay=true
+X-GNOME-Autostart-enabled=true
+`), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Println("This system is not running systemd, installed", autostartFilePath, "instead; appimaged will run the next time you log in")
+	return nil
+}
+
+// uninstall stops appimaged, removes what install has set up, and removes
+// the desktop files, thumbnails and file manager context menus of all integrated AppImages
+func uninstall() {
+	if helpers.Exists(serviceFilePath) {
+		prc := exec.Command("systemctl", "--user", "disable", "--now", "appimaged")
+		out, err := prc.CombinedOutput()
+		if err != nil {
+			log.Println(prc.String(), err, string(out))
+		}
+		removeAndReport(serviceFilePath)
+		prc = exec.Command("systemctl", "--user", "daemon-reload")
+		_, err = prc.CombinedOutput()
+		helpers.LogError("uninstall", err)
+	}
+	removeAndReport(autostartFilePath)
+
+	for _, path := range findDesktopFileTargets() {
+		ai, _ := NewAppImage(path)
+		removeAndReport(ai.desktopfilepath)
+		removeAndReport(ai.thumbnailfilepath)
+	}
+	removeAndReport(xdg.DataHome + "/applications/" + launcherDesktopFileName)
+	removeAndReport(xdg.DataHome + "/file-manager/actions/appimaged.desktop")
+	removeAndReport(xdg.DataHome + "/kservices5/ServiceMenus/appimaged.desktop")
+	removeAndReport(quarantinedFile)
+
+	if helpers.IsCommandAvailable("update-desktop-database") {
+		err := exec.Command("update-desktop-database", xdg.DataHome+"/applications/").Run()
+		helpers.LogError("uninstall", err)
+	}
+
+	// Last, so that the steps above can still be repeated if something went wrong
+	removeAndReport(installedPath)
+}
+
+// removeAndReport removes the file at path if it exists and says so
+func removeAndReport(path string) {
+	if !helpers.Exists(path) {
+		return
+	}
+	err := os.Remove(path)
+	if err != nil {
+		helpers.PrintError("uninstall", err)
+		return
+	}
+	fmt.Println("Removed", path)
+}
@@ -0,0 +1,117 @@
+// Registers appimaged as the application that opens AppImages, so that double-clicking
+// an AppImage that was just downloaded and is not executable yet runs it
+// instead of doing nothing or opening it in an archive manager.
+// binfmt_misc would make this work in the terminal too, but needs root rights.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrg/xdg"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+)
+
+const launcherDesktopFileName = "appimaged-launcher.desktop"
+
+var appImageMimeTypes = []string{"application/vnd.appimage", "application/x-iso9660-appimage"}
+
+// installLauncher writes the desktop file for "appimaged open" and makes it the default application
+// for AppImages, unless the user has chosen another one
+func installLauncher() {
+	arg0abs, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		helpers.PrintError("launcher", err)
+		return
+	}
+	if os.Getenv("APPIMAGE") != "" {
+		arg0abs = os.Getenv("APPIMAGE")
+	}
+	err = os.MkdirAll(xdg.DataHome+"/applications/", 0755)
+	if err == nil {
+		err = ioutil.WriteFile(xdg.DataHome+"/applications/"+launcherDesktopFileName, []byte(`[Desktop Entry]
+Type=Application
+Name=AppImage Launcher
+Comment=Run AppImages even if they are not executable yet
+Exec=`+arg0abs+` open %f
+MimeType=`+strings.Join(appImageMimeTypes, ";")+`;
+NoDisplay=true
+Terminal=false
+`), 0644)
+	}
+	if err != nil {
+		helpers.PrintError("launcher", err)
+		return
+	}
+
+	if !helpers.IsCommandAvailable("xdg-mime") {
+		return
+	}
+	for _, mimeType := range appImageMimeTypes {
+		out, err := exec.Command("xdg-mime", "query", "default", mimeType).Output()
+		if err != nil || strings.TrimSpace(string(out)) != "" {
+			continue
+		}
+		err = exec.Command("xdg-mime", "default", launcherDesktopFileName, mimeType).Run()
+		if err == nil {
+			log.Println("launcher: Registered", launcherDesktopFileName, "for", mimeType)
+		} else {
+			helpers.LogError("launcher", err)
+		}
+	}
+}
+
+// openAppImage runs the AppImage at path after making it executable, which the user
+// wants since they opened it explicitly. If it is not integrated yet, the user is asked
+// whether to integrate it, or to move it into the Applications directory, first (see prompt.go)
+func openAppImage(path string, args []string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	ai, err := NewAppImage(path)
+	if err != nil {
+		return err
+	}
+	if ai.Type() < 1 {
+		return i18n.Errorf("%s is not an AppImage", path)
+	}
+	ai.setExecBit()
+	allowed, _ := loadPolicy().allows(ai)
+	if allowed || helpers.Exists(ai.desktopfilepath) || os.Getenv("APPIMAGELAUNCHER_DISABLE") != "" {
+		return helpers.RunCmdTransparently(append([]string{path}, args...))
+	}
+
+	destination, offerMove := appImageLauncherSettings()
+	switch askIntegration(ai, destination, offerMove && filepath.Dir(path) != filepath.Clean(destination)) {
+	case choiceCancel:
+		return nil
+	case choiceMove:
+		path, err = moveAppImage(path, destination)
+		if err != nil {
+			return err
+		}
+		ai, err = NewAppImage(path)
+		if err != nil {
+			return err
+		}
+		if allowed, _ := loadPolicy().allows(ai); allowed {
+			break
+		}
+		fallthrough
+	case choiceIntegrate:
+		err = trust(path)
+		helpers.LogError("launcher", err)
+		// Let a running daemon integrate it right away, or do it ourselves
+		if reached, _ := trustUsingDaemon(path); !reached {
+			helpers.LogError("launcher", integrateNow(path))
+		}
+	}
+	return helpers.RunCmdTransparently(append([]string{path}, args...))
+}
@@ -0,0 +1,112 @@
+// Lets the integration be managed from the command line, without a running daemon,
+// e.g., on headless machines or in scripts.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/adrg/xdg"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+)
+
+// listIntegrated prints the integrated and the quarantined AppImages with their metadata
+func listIntegrated() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tTYPE\tSTATUS\tPATH\tUPDATE INFORMATION")
+	paths := FindIntegratedAppImages()
+	sort.Strings(paths)
+	for _, path := range paths {
+		printAppImageLine(w, path, "integrated")
+	}
+	for _, path := range readQuarantined() {
+		printAppImageLine(w, path, "quarantined")
+	}
+	w.Flush()
+}
+
+func printAppImageLine(w *tabwriter.Writer, path string, status string) {
+	ai, err := NewAppImage(path)
+	if err != nil {
+		return
+	}
+	version := ""
+	if ai.Desktop != nil {
+		version = ai.Desktop.Section("Desktop Entry").Key("X-AppImage-Version").String()
+	}
+	fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", ai.Name, version, ai.Type(), status, ai.Path, ai.updateinformation)
+}
+
+// integrateNow integrates the AppImage at path right away. Since the user asks for it explicitly,
+// the AppImage is trusted so that the policy does not quarantine it later on
+func integrateNow(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	ai, err := NewAppImage(path)
+	if err != nil {
+		return err
+	}
+	if allowed, _ := loadPolicy().allows(ai); !allowed {
+		err = trust(path)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(xdg.DataHome+"/applications/", 0755)
+	if err != nil {
+		return err
+	}
+	ai.IntegrateOrUnintegrate()
+	moveDesktopFiles()
+	if !helpers.Exists(ai.desktopfilepath) {
+		return i18n.Errorf("could not integrate %s", path)
+	}
+	fmt.Println("Integrated", path)
+	return nil
+}
+
+// unintegrateNow removes the integration of the AppImage at path right away, even if it still exists
+func unintegrateNow(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	ai, err := NewAppImage(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !helpers.Exists(ai.desktopfilepath) {
+		return i18n.Errorf("%s is not integrated", path)
+	}
+	ai._removeIntegration()
+	fmt.Println("Unintegrated", path)
+	return nil
+}
+
+// launchByName runs the most recent integrated AppImage whose name matches name,
+// ignoring case, and waits for it to exit
+func launchByName(name string, args []string) error {
+	var candidates []string
+	for _, path := range FindIntegratedAppImages() {
+		ai, err := NewAppImage(path)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(ai.Name, name) {
+			candidates = append(candidates, path)
+		}
+	}
+	if len(candidates) == 0 {
+		return i18n.Errorf("no integrated AppImage called %s", name)
+	}
+	cmd := append([]string{helpers.FindMostRecentFile(candidates)}, args...)
+	return helpers.RunCmdTransparently(cmd)
+}
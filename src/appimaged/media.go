@@ -0,0 +1,221 @@
+// Integrates the AppImages on removable media and network shares while they are mounted.
+// Block devices are mounted by UDisks2, which tells us about it on the system bus.
+// Network shares are not handled by UDisks2, so we look for them in /proc/self/mounts.
+
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+	"github.com/prometheus/procfs"
+)
+
+// Filesystem types of network shares
+var networkFilesystems = []string{"cifs", "smb3", "smbfs", "nfs", "nfs4", "davfs", "fuse.sshfs", "fuse.rclone"}
+
+// How often to look for network shares that have been mounted or unmounted
+const networkSharePollInterval = 30 * time.Second
+
+// The mount points of the media whose AppImages are integrated, and whether they are network shares
+var mountedMedia = make(map[string]bool)
+var mountedMediaMutex sync.Mutex
+
+// monitorRemovableMedia integrates and unintegrates the AppImages on media as they come and go.
+// If UDisks2 is not available on the system bus, falls back to monitorUdisks
+func monitorRemovableMedia() {
+	go monitorNetworkShares()
+
+	conn, err := dbus.SystemBusPrivate() // When using SystemBusPrivate(), need to follow with Auth(nil) and Hello()
+	if err != nil {
+		helpers.PrintError("SystemBusPrivate", err)
+		monitorUdisks()
+		return
+	}
+	defer conn.Close()
+	if err = conn.Auth(nil); err != nil {
+		helpers.PrintError("Auth", err)
+		monitorUdisks()
+		return
+	}
+	if err = conn.Hello(); err != nil {
+		helpers.PrintError("Hello", err)
+		monitorUdisks()
+		return
+	}
+
+	// Media that are already mounted
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	err = conn.Object("org.freedesktop.UDisks2", "/org/freedesktop/UDisks2").Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).Store(&objects)
+	if err != nil {
+		log.Println("Notice: Cannot get the filesystems from UDisks2 on this system", err)
+		monitorUdisks()
+		return
+	}
+	mountPoints := make(map[dbus.ObjectPath][]string)
+	for path, interfaces := range objects {
+		filesystem, ok := interfaces["org.freedesktop.UDisks2.Filesystem"]
+		if !ok || isSystemBlockDevice(interfaces["org.freedesktop.UDisks2.Block"]) {
+			continue
+		}
+		mountPoints[path] = decodeMountPoints(filesystem["MountPoints"])
+		for _, mountPoint := range mountPoints[path] {
+			mediumMounted(mountPoint, false)
+		}
+	}
+
+	err = conn.AddMatchSignal(
+		dbus.WithMatchSender("org.freedesktop.UDisks2"),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchOption("arg0", "org.freedesktop.UDisks2.Filesystem"),
+	)
+	if err != nil {
+		helpers.PrintError("AddMatchSignal", err)
+		monitorUdisks()
+		return
+	}
+	c := make(chan *dbus.Signal, 10)
+	conn.Signal(c)
+	log.Println("media: Watching UDisks2 for media being mounted and unmounted")
+
+	for signal := range c {
+		if len(signal.Body) < 3 {
+			continue
+		}
+		changed, _ := signal.Body[1].(map[string]dbus.Variant)
+		invalidated, _ := signal.Body[2].([]string)
+		variant, ok := changed["MountPoints"]
+		if !ok {
+			if !helpers.SliceContains(invalidated, "MountPoints") {
+				continue
+			}
+			variant, err = conn.Object("org.freedesktop.UDisks2", signal.Path).GetProperty("org.freedesktop.UDisks2.Filesystem.MountPoints")
+			if err != nil {
+				continue
+			}
+		}
+		hintSystem, err := conn.Object("org.freedesktop.UDisks2", signal.Path).GetProperty("org.freedesktop.UDisks2.Block.HintSystem")
+		if err == nil && hintSystem.Value() == true {
+			continue
+		}
+		current := decodeMountPoints(variant)
+		for _, mountPoint := range current {
+			if !helpers.SliceContains(mountPoints[signal.Path], mountPoint) {
+				mediumMounted(mountPoint, false)
+			}
+		}
+		for _, mountPoint := range mountPoints[signal.Path] {
+			if !helpers.SliceContains(current, mountPoint) {
+				mediumUnmounted(mountPoint)
+			}
+		}
+		mountPoints[signal.Path] = current
+	}
+}
+
+// isSystemBlockDevice returns true if UDisks2 considers the block device with the given properties
+// part of the system rather than removable, e.g., the partition the root filesystem is on
+func isSystemBlockDevice(block map[string]dbus.Variant) bool {
+	hintSystem, ok := block["HintSystem"]
+	return ok && hintSystem.Value() == true
+}
+
+// decodeMountPoints converts the MountPoints property of org.freedesktop.UDisks2.Filesystem,
+// which holds zero-terminated byte arrays, into strings
+func decodeMountPoints(variant dbus.Variant) []string {
+	var mountPoints []string
+	values, _ := variant.Value().([][]byte)
+	for _, value := range values {
+		mountPoints = append(mountPoints, strings.TrimRight(string(value), "\x00"))
+	}
+	return mountPoints
+}
+
+// monitorNetworkShares periodically compares the mounted network shares with the ones we know
+func monitorNetworkShares() {
+	ticker := time.NewTicker(networkSharePollInterval)
+	for {
+		mounts, err := procfs.GetMounts()
+		if err == nil {
+			current := make(map[string]bool)
+			for _, mount := range mounts {
+				if helpers.SliceContains(networkFilesystems, mount.FSType) {
+					current[mount.MountPoint] = true
+					mediumMounted(mount.MountPoint, true)
+				}
+			}
+			mountedMediaMutex.Lock()
+			var gone []string
+			for mountPoint, isNetworkShare := range mountedMedia {
+				if isNetworkShare && !current[mountPoint] {
+					gone = append(gone, mountPoint)
+				}
+			}
+			mountedMediaMutex.Unlock()
+			for _, mountPoint := range gone {
+				mediumUnmounted(mountPoint)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// mediumMounted integrates the AppImages at the top level and in the Applications directory
+// of the medium mounted at mountPoint, unless we already know about it
+func mediumMounted(mountPoint string, isNetworkShare bool) {
+	mountedMediaMutex.Lock()
+	_, known := mountedMedia[mountPoint]
+	mountedMedia[mountPoint] = isNetworkShare
+	mountedMediaMutex.Unlock()
+	if known {
+		return
+	}
+	log.Println("media: Mounted", mountPoint)
+
+	if mounts, err := procfs.GetMounts(); err == nil {
+		for _, mount := range mounts {
+			if _, ok := mount.SuperOptions["showexec"]; ok && mount.MountPoint == mountPoint {
+				go sendErrorDesktopNotification(i18n.Sprintf("UDisks showexec issue"), i18n.Sprintf("Applications cannot run from \n%s. \nSee \n%s", mountPoint, "https://github.com/storaged-project/udisks/issues/707"))
+				printUdisksShowexecHint()
+				return
+			}
+		}
+	}
+
+	dirs := []string{mountPoint}
+	if helpers.Exists(mountPoint + "/Applications") {
+		dirs = append(dirs, mountPoint+"/Applications")
+	}
+	for _, dir := range dirs {
+		watchedDirectories = helpers.AppendIfMissing(watchedDirectories, dir)
+	}
+	watchDirectoriesReally(dirs)
+}
+
+// mediumUnmounted unintegrates the AppImages that were integrated from the medium that was mounted at mountPoint
+func mediumUnmounted(mountPoint string) {
+	mountedMediaMutex.Lock()
+	delete(mountedMedia, mountPoint)
+	mountedMediaMutex.Unlock()
+	log.Println("media: Unmounted", mountPoint)
+
+	watchedDirectories = RemoveFromSlice(watchedDirectories, mountPoint)
+	watchedDirectories = RemoveFromSlice(watchedDirectories, mountPoint+"/Applications")
+	for _, path := range findDesktopFileTargets() {
+		if strings.HasPrefix(path, strings.TrimSuffix(mountPoint, "/")+"/") {
+			// The AppImage no longer exists, so it is unintegrated
+			ToBeIntegratedOrUnintegrated = helpers.AppendIfMissing(ToBeIntegratedOrUnintegrated, path)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Recognizes integrated AppImages that were moved or renamed, so that their integration follows them
+// instead of leaving a launcher behind that does not work anymore. AppImages are recognized by
+// their inode, and, when moved to another filesystem, by a fingerprint of their contents.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/adrg/xdg"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"gopkg.in/ini.v1"
+)
+
+// Keys in the desktop files written by us that identify the AppImage independently of its path
+const (
+	inodeKey       = "X-AppImage-Inode"       // Device and inode, e.g., "2049:1234567"
+	fingerprintKey = "X-AppImage-Fingerprint" // See contentFingerprint
+)
+
+// How much of the beginning and of the end of an AppImage goes into its fingerprint.
+// The squashfs superblock is near the beginning and its tables are at the end, hence
+// they differ between AppImages even if they have the same runtime
+const fingerprintChunkSize = 1024 * 1024
+
+// inodeOf returns the device and inode of the file at path, or an empty string if it does not exist
+func inodeOf(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok == false {
+		return ""
+	}
+	return strconv.FormatUint(uint64(stat.Dev), 10) + ":" + strconv.FormatUint(stat.Ino, 10)
+}
+
+// contentFingerprint returns the hex-encoded SHA-256 digest of the size and of the first
+// and last fingerprintChunkSize bytes of the file at path, which is much faster than hashing all of it
+func contentFingerprint(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, info.Size())
+	_, err = io.Copy(h, io.NewSectionReader(f, 0, fingerprintChunkSize))
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > fingerprintChunkSize {
+		start := info.Size() - fingerprintChunkSize
+		if start < fingerprintChunkSize {
+			start = fingerprintChunkSize
+		}
+		_, err = io.Copy(h, io.NewSectionReader(f, start, info.Size()-start))
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// setIdentity records the inode and the fingerprint of the AppImage in its desktop file
+func setIdentity(cfg *ini.File, ai AppImage) {
+	cfg.Section("Desktop Entry").Key(inodeKey).SetValue(inodeOf(ai.Path))
+	fingerprint, err := contentFingerprint(ai.Path)
+	helpers.LogError("desktop", err)
+	cfg.Section("Desktop Entry").Key(fingerprintKey).SetValue(fingerprint)
+}
+
+// adoptMovedIntegration looks for the integration of an AppImage that no longer exists where it was integrated
+// but is the AppImage ai, i.e., has the same inode or fingerprint, and moves its thumbnail to where ai expects it,
+// removing the old desktop file, so that _integrate writes the desktop file for the new path.
+// Returns false if ai was not integrated under another path
+func adoptMovedIntegration(ai *AppImage) bool {
+	if helpers.Exists(ai.desktopfilepath) {
+		return false
+	}
+	files, err := ioutil.ReadDir(xdg.DataHome + "/applications/")
+	if err != nil {
+		return false
+	}
+	inode := inodeOf(ai.Path)
+	fingerprint := ""
+	for _, file := range files {
+		if file.Name() == ai.desktopfilename || isOurDesktopFile(file.Name()) == false {
+			continue
+		}
+		path := xdg.DataHome + "/applications/" + file.Name()
+		cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, path)
+		if err != nil {
+			continue
+		}
+		section := cfg.Section("Desktop Entry")
+		oldPath := section.Key(ExecLocationKey).String()
+		if oldPath == "" || oldPath == ai.Path || helpers.Exists(oldPath) {
+			continue
+		}
+		same := inode != "" && section.Key(inodeKey).String() == inode
+		if same == false && section.Key(fingerprintKey).String() != "" {
+			if fingerprint == "" {
+				fingerprint, _ = contentFingerprint(ai.Path)
+			}
+			same = section.Key(fingerprintKey).String() == fingerprint
+		}
+		if same == false {
+			continue
+		}
+		log.Println("moved:", oldPath, "was moved to", ai.Path)
+		// The thumbnail does not need to be extracted again
+		old, _ := NewAppImage(oldPath)
+		if err := os.Rename(old.thumbnailfilepath, ai.thumbnailfilepath); err != nil && os.IsNotExist(err) == false {
+			helpers.LogError("moved", err)
+		}
+		helpers.LogError("moved", os.Remove(path))
+		removeFromSearchIndex(oldPath)
+		return true
+	}
+	return false
+}
+
+// isOurDesktopFile returns true if the desktop file with the given name was written by us
+func isOurDesktopFile(name string) bool {
+	return strings.HasPrefix(name, "appimagekit_") && strings.HasSuffix(name, ".desktop")
+}
+
+// queueDeadIntegrations queues the integrated AppImages that no longer exist for removing their integration.
+// Since moveDesktopFiles integrates before it removes, the integration of an AppImage that was moved
+// to a watched directory follows it rather than being removed
+func queueDeadIntegrations() {
+	for _, path := range findDesktopFileTargets() {
+		if helpers.Exists(path) == false {
+			ToBeIntegratedOrUnintegrated = helpers.AppendIfMissing(ToBeIntegratedOrUnintegrated, path)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
 )
 
 func connect(clientId string, uri *url.URL) mqtt.Client {
@@ -39,6 +40,9 @@ func createClientOptions(clientId string, uri *url.URL) *mqtt.ClientOptions {
 // UnSubscribeMQTT unubscribe from receiving update notifications for updateinformation
 // TODO: Keep track of what we have already subscribed, and remove from that list
 func UnSubscribeMQTT(client mqtt.Client, updateinformation string) {
+	if client == nil {
+		return // Not connected, e.g., when invoked from the command line
+	}
 	queryEscapedUpdateInformation := url.QueryEscape(updateinformation)
 	if queryEscapedUpdateInformation == "" {
 		return
@@ -49,6 +53,9 @@ func UnSubscribeMQTT(client mqtt.Client, updateinformation string) {
 // SubscribeMQTT subscribes to receive update notifications for updateinformation
 // TODO: Keep track of what we have already subscribed, and don't subscribe again
 func SubscribeMQTT(client mqtt.Client, updateinformation string) {
+	if client == nil {
+		return // Not connected, e.g., when invoked from the command line
+	}
 
 	if helpers.SliceContains(subscribedMQTTTopics, updateinformation) == true {
 		// We have already subscribed to this; so nothing to do here
@@ -102,7 +109,7 @@ func SubscribeMQTT(client mqtt.Client, updateinformation string) {
 				log.Println("+ Something special should happen here: Selfupdate")
 				log.Println("+ To be imlpemented.")
 				log.Println("++++++++++++++++++++++++++++++++++++++++++++++++++")
-				sendDesktopNotification("Update available", "An update for the AppImage daemon is available; I could update myself now...", 0)
+				sendDesktopNotification(i18n.Sprintf("Update available"), i18n.Sprintf("An update for the AppImage daemon is available; I could update myself now..."), 0)
 			}
 
 			mostRecent := FindMostRecentAppImageWithMatchingUpdateInformation(unescapedui)
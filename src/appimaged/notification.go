@@ -9,10 +9,12 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/esiqveland/notify"
 	"github.com/godbus/dbus/v5"
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
 )
 
 // sendUpdateDesktopNotification sends a desktop notification for an update.
@@ -23,7 +25,6 @@ func sendUpdateDesktopNotification(ai *AppImage, version string, changelog strin
 	wg := &sync.WaitGroup{}
 
 	conn, err := dbus.SessionBusPrivate() // When using SessionBusPrivate(), need to follow with Auth(nil) and Hello()
-	defer conn.Close()
 	if err != nil {
 		helpers.PrintError("SessionBusPrivate", err)
 		return
@@ -32,6 +33,7 @@ func sendUpdateDesktopNotification(ai *AppImage, version string, changelog strin
 		helpers.PrintError("No conn", err)
 		return
 	}
+	defer conn.Close()
 
 	if err = conn.Auth(nil); err != nil {
 		helpers.PrintError("Auth", err)
@@ -50,9 +52,9 @@ func sendUpdateDesktopNotification(ai *AppImage, version string, changelog strin
 		AppName:       ai.Name,
 		ReplacesID:    uint32(0),
 		AppIcon:       iconName,
-		Summary:       "Update available",
-		Body:          ai.Name + " can be updated to version " + version + ". \nchangelog",
-		Actions:       []string{"update", "Update"}, // tuples of (action_key, label)
+		Summary:       i18n.Sprintf("Update available"),
+		Body:          i18n.Sprintf("%s can be updated to version %s. \n%s", ai.Name, version, changelog),
+		Actions:       []string{"update", i18n.Sprintf("Update")}, // tuples of (action_key, label)
 		Hints:         map[string]dbus.Variant{},
 		ExpireTimeout: int32(120000),
 	}
@@ -127,10 +129,78 @@ func sendUpdateDesktopNotification(ai *AppImage, version string, changelog strin
 	wg.Wait()
 }
 
+// sendActionDesktopNotification sends a desktop notification with a single action
+// and calls onAction if the user clicks on it.
+// Use this with "go" prefixed to it so that it runs in the background, because it waits
+// until the user clicks on the action, closes the notification or the timeout occurs
+func sendActionDesktopNotification(title string, body string, actionLabel string, durationms int32, onAction func()) {
+	conn, err := dbus.SessionBusPrivate() // When using SessionBusPrivate(), need to follow with Auth(nil) and Hello()
+	if err != nil {
+		helpers.PrintError("SessionBusPrivate", err)
+		return
+	}
+	defer conn.Close()
+	if err = conn.Auth(nil); err != nil {
+		helpers.PrintError("Auth", err)
+		return
+	}
+	if err = conn.Hello(); err != nil {
+		helpers.PrintError("Hello", err)
+		return
+	}
+
+	log.Println("Desktop notification: ", title, body)
+	n := notify.Notification{
+		AppName:       "appimaged",
+		ReplacesID:    uint32(0),
+		Summary:       title,
+		Body:          body,
+		Actions:       []string{"action", actionLabel}, // tuples of (action_key, label)
+		Hints:         map[string]dbus.Variant{},
+		ExpireTimeout: durationms,
+	}
+
+	done := make(chan bool, 1)
+	var id uint32
+	notifier, err := notify.New(
+		conn,
+		notify.WithOnAction(func(action *notify.ActionInvokedSignal) {
+			// Only act on the notification we sent
+			if action != nil && action.ID == id && action.ActionKey == "action" {
+				onAction()
+			}
+			select {
+			case done <- true:
+			default:
+			}
+		}),
+		notify.WithOnClosed(func(closer *notify.NotificationClosedSignal) {
+			select {
+			case done <- true:
+			default:
+			}
+		}),
+	)
+	if err != nil {
+		helpers.PrintError("notify", err)
+		return
+	}
+	defer notifier.Close()
+
+	id, err = notifier.SendNotification(n)
+	if err != nil {
+		helpers.PrintError("notify", err)
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Duration(durationms)*time.Millisecond + time.Minute):
+	}
+}
+
 func sendDesktopNotification(title string, body string, durationms int32) {
 
 	conn, err := dbus.SessionBusPrivate() // When using SessionBusPrivate(), need to follow with Auth(nil) and Hello()
-	defer conn.Close()
 	if err != nil {
 		helpers.PrintError("SessionBusPrivate", err)
 		return
@@ -139,6 +209,7 @@ func sendDesktopNotification(title string, body string, durationms int32) {
 		helpers.PrintError("No conn", err)
 		return
 	}
+	defer conn.Close()
 
 	if err = conn.Auth(nil); err != nil {
 		helpers.PrintError("Auth", err)
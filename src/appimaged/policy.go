@@ -0,0 +1,309 @@
+// Decides which AppImages get integrated automatically, so that executables
+// that merely were downloaded do not show up in the menu and become executable.
+// AppImages that are neither in an allowed directory nor signed by a trusted key
+// are quarantined until the user trusts them explicitly using "appimaged trust <file>"
+// or the Trust D-Bus method.
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adrg/xdg"
+	"github.com/godbus/dbus/v5"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+	"github.com/probonopd/go-appimage/pkg/signature"
+	"gopkg.in/ini.v1"
+)
+
+// The policy file, e.g.,
+//
+//	[Policy]
+//	AllowedDirectories=~/Applications;/opt;/media/me/USB
+//	TrustedKeys=385DED8974B046442364E9FC7DE7BDEBBA4A5868
+//	MakeExecutable=true
+var policyFile = xdg.ConfigHome + "/appimaged/policy.ini"
+
+// The SHA-256 digests of the AppImages the user has trusted, one per line, followed by the path
+var trustedFile = xdg.ConfigHome + "/appimaged/trusted"
+
+// The paths of the quarantined AppImages, one per line, so that they can be listed without asking the daemon
+var quarantinedFile = xdg.DataHome + "/appimaged/quarantined"
+
+// Directories in which AppImages are integrated automatically unless the policy file says otherwise.
+// The places in which files end up without the user having put them there deliberately,
+// like the Downloads directory, are missing on purpose
+var defaultAllowedDirectories = []string{
+	home + "/Applications",
+	home + "/.local/bin",
+	home + "/bin",
+	"/opt",
+	"/usr/local/bin",
+}
+
+// The D-Bus name, object path and interface under which the daemon can be asked to trust AppImages
+const (
+	policyBusName   = "io.github.probonopd.appimaged"
+	policyPath      = "/io/github/probonopd/appimaged"
+	policyInterface = "io.github.probonopd.appimaged"
+)
+
+// policy holds what the user allows
+type policy struct {
+	allowedDirectories []string
+	trustedKeys        []string // Upper-case fingerprints without spaces
+	trustedDigests     map[string]bool
+	makeExecutable     bool // Also set the executable bit on quarantined AppImages, without integrating them
+}
+
+var quarantinedMutex sync.Mutex
+
+// loadPolicy reads the policy file and the trusted digests
+func loadPolicy() policy {
+	p := policy{allowedDirectories: defaultAllowedDirectories, trustedDigests: make(map[string]bool)}
+	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, policyFile)
+	if err == nil {
+		section := cfg.Section("Policy")
+		if section.HasKey("AllowedDirectories") {
+			p.allowedDirectories = nil
+			for _, dir := range section.Key("AllowedDirectories").Strings(";") {
+				if strings.HasPrefix(dir, "~/") {
+					dir = home + dir[1:]
+				}
+				p.allowedDirectories = append(p.allowedDirectories, filepath.Clean(dir))
+			}
+		}
+		p.makeExecutable = section.Key("MakeExecutable").MustBool(false)
+		for _, key := range section.Key("TrustedKeys").Strings(";") {
+			p.trustedKeys = append(p.trustedKeys, strings.ToUpper(strings.Replace(key, " ", "", -1)))
+		}
+	} else if !os.IsNotExist(err) {
+		helpers.PrintError("policy", err)
+	}
+	f, err := os.Open(trustedFile)
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 0 {
+				p.trustedDigests[fields[0]] = true
+			}
+		}
+	}
+	return p
+}
+
+// allows returns whether the AppImage may be integrated, and if not, why
+func (p policy) allows(ai *AppImage) (bool, string) {
+	for _, dir := range p.allowedDirectories {
+		if dir == "/" || strings.HasPrefix(filepath.Clean(ai.Path), dir+"/") {
+			return true, ""
+		}
+	}
+	result, err := signature.Verify(ai.Path)
+	if err != nil {
+		// Not an ELF with sections, e.g., a type-1 AppImage
+		result.Digest, err = contentDigest(ai.Path)
+		if err != nil {
+			return false, "it cannot be read"
+		}
+	}
+	if p.trustedDigests[result.Digest] {
+		return true, ""
+	}
+	if result.Status == signature.StatusValid {
+		if helpers.SliceContains(p.trustedKeys, strings.ToUpper(result.Fingerprint)) {
+			return true, ""
+		}
+		return false, "it is signed by " + strings.Join(result.Identities, ", ") + " with the untrusted key " + strings.ToUpper(result.Fingerprint)
+	}
+	return false, "it is not in an allowed directory and not signed by a trusted key"
+}
+
+// quarantine records that the AppImage was not integrated and offers the user to trust it
+func quarantine(ai *AppImage, p policy, reason string) {
+	quarantinedMutex.Lock()
+	quarantined := readQuarantined()
+	known := helpers.SliceContains(quarantined, ai.Path)
+	if !known {
+		writeQuarantined(append(quarantined, ai.Path))
+	}
+	quarantinedMutex.Unlock()
+
+	// It may have been integrated before the policy was in place
+	if helpers.Exists(ai.desktopfilepath) {
+		err := os.Remove(ai.desktopfilepath)
+		helpers.LogError("policy", err)
+	}
+	if p.makeExecutable {
+		ai.setExecBit()
+	}
+	if known {
+		return
+	}
+	log.Println("policy: Quarantined", ai.Path, "because", reason)
+	if *quietPtr == false {
+		path := ai.Path
+		go sendActionDesktopNotification(i18n.Sprintf("Quarantined %s", filepath.Base(path)),
+			i18n.Sprintf("It was not integrated because %s.\nRun 'appimaged trust %s' or click below to make it executable and integrate it", reason, path),
+			i18n.Sprintf("Make executable"), 30000, func() {
+				err := trust(path)
+				if err != nil {
+					helpers.PrintError("policy", err)
+					return
+				}
+				ToBeIntegratedOrUnintegrated = helpers.AppendIfMissing(ToBeIntegratedOrUnintegrated, path)
+			})
+	}
+}
+
+// trust records the digest of the AppImage at path as trusted and removes it from the quarantine
+func trust(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	digest, err := contentDigest(path)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(trustedFile), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(trustedFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(digest + " " + path + "\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	quarantinedMutex.Lock()
+	writeQuarantined(RemoveFromSlice(readQuarantined(), path))
+	quarantinedMutex.Unlock()
+	log.Println("policy: Trusted", path)
+	return nil
+}
+
+// contentDigest returns the digest that trust is bound to, which is the one signatures are made for,
+// or the SHA-256 digest of the whole file if the AppImage has no ELF sections
+func contentDigest(path string) (string, error) {
+	digest, err := signature.Digest(path)
+	if err == nil {
+		return digest, nil
+	}
+	return fsys.SHA256(fsys.OS, path)
+}
+
+// readQuarantined returns the paths of the quarantined AppImages that still exist
+func readQuarantined() []string {
+	var quarantined []string
+	data, err := ioutil.ReadFile(quarantinedFile)
+	if err != nil {
+		return quarantined
+	}
+	for _, path := range strings.Split(string(data), "\n") {
+		if path != "" && helpers.Exists(path) {
+			quarantined = append(quarantined, path)
+		}
+	}
+	sort.Strings(quarantined)
+	return quarantined
+}
+
+func writeQuarantined(quarantined []string) {
+	err := os.MkdirAll(filepath.Dir(quarantinedFile), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(quarantinedFile, []byte(strings.Join(quarantined, "\n")+"\n"), 0644)
+	}
+	helpers.LogError("policy", err)
+}
+
+// policyService is exported on the session bus so that other applications,
+// e.g., a file manager, can trust AppImages
+type policyService struct{}
+
+// Trust trusts the AppImage at path and integrates it
+func (policyService) Trust(path string) *dbus.Error {
+	err := trust(path)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	abs, _ := filepath.Abs(path)
+	ToBeIntegratedOrUnintegrated = helpers.AppendIfMissing(ToBeIntegratedOrUnintegrated, abs)
+	return nil
+}
+
+// Quarantined returns the paths of the quarantined AppImages
+func (policyService) Quarantined() ([]string, *dbus.Error) {
+	quarantinedMutex.Lock()
+	defer quarantinedMutex.Unlock()
+	return readQuarantined(), nil
+}
+
+// exportPolicyService makes the Trust, Quarantined and Search methods available on the session bus.
+// Run this with "go" prefixed to it
+func exportPolicyService() {
+	conn, err := dbus.SessionBusPrivate() // When using SessionBusPrivate(), need to follow with Auth(nil) and Hello()
+	if err != nil {
+		helpers.PrintError("SessionBusPrivate", err)
+		return
+	}
+	if err = conn.Auth(nil); err != nil {
+		conn.Close()
+		helpers.PrintError("Auth", err)
+		return
+	}
+	if err = conn.Hello(); err != nil {
+		conn.Close()
+		helpers.PrintError("Hello", err)
+		return
+	}
+	reply, err := conn.RequestName(policyBusName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		log.Println("policy: Cannot own", policyBusName, "on the session bus, is another instance running?")
+		return
+	}
+	err = conn.Export(policyService{}, policyPath, policyInterface)
+	if err != nil {
+		conn.Close()
+		helpers.PrintError("Export", err)
+		return
+	}
+	<-quit
+	conn.Close()
+}
+
+// trustUsingDaemon asks the running daemon to trust and integrate the AppImage at path,
+// and returns false if no daemon could be reached
+func trustUsingDaemon(path string) (bool, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return false, nil
+	}
+	call := conn.Object(policyBusName, policyPath).Call(policyInterface+".Trust", 0, path)
+	if call.Err != nil {
+		if dbusErr, ok := call.Err.(dbus.Error); ok && dbusErr.Name == "org.freedesktop.DBus.Error.Failed" {
+			return true, call.Err
+		}
+		return false, nil
+	}
+	return true, nil
+}
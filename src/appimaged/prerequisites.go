@@ -18,6 +18,7 @@ import (
 
 	systemddbus "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
 	"github.com/shirou/gopsutil/process"
 )
 
@@ -61,7 +62,7 @@ func checkPrerequisites() {
 			os.Exit(1)
 		} else {
 			// Note that this exception is for use during development of this tool only and may go away at any time.
-			sendDesktopNotification("Not running from an AppImage", "This is discouraged because some functionality may not be available", 5000)
+			sendDesktopNotification(i18n.Sprintf("Not running from an AppImage"), i18n.Sprintf("This is discouraged because some functionality may not be available"), 5000)
 		}
 	}
 
@@ -73,7 +74,7 @@ func checkPrerequisites() {
 	// Stop any other AppImage system integration daemon
 	// so that they won't interfere with each other
 	if checkIfSystemdServiceRunning([]string{"appimagelauncher*"}) == true {
-		sendErrorDesktopNotification("Other AppImage integration daemon detected", "Please uninstall appimagelauncher first, then try again")
+		sendErrorDesktopNotification(i18n.Sprintf("Other AppImage integration daemon detected"), i18n.Sprintf("Please uninstall appimagelauncher first, then try again"))
 		os.Exit(1)
 		// log.Println("Trying to stop interfering AppImage system integration daemons")
 		// stopSystemdService("appimagelauncherd")
@@ -251,7 +252,7 @@ func ensureRunningFromLiveSystem() {
 
 	if found == false && gcEnvIsThere == false {
 		// The following temporarily relaxes the Live system restriction
-		sendDesktopNotification("Not running on one of the supported Live systems", "This configuration is currently unsupported but may still work, please give feedback.", -1)
+		sendDesktopNotification(i18n.Sprintf("Not running on one of the supported Live systems"), i18n.Sprintf("This configuration is currently unsupported but may still work, please give feedback."), -1)
 		// We may want to go back to the more restrictive behavior in the future
 		// sendDesktopNotification("Not running on one of the supported Live systems", "Grab a Ubuntu, Debian, Fedora, openSUSE,... Live ISO (or a derivative, like Deepin, elementary OS, GeckoLinux, KDE neon, Linux Mint, Pop!_OS...) and try from there.", -1)
 		// os.Exit(1)
@@ -344,7 +345,7 @@ func setupToRunThroughSystemd() {
 
 		if _, err := os.Stat("/etc/systemd/user/appimaged.service"); os.IsNotExist(err) {
 			log.Println("/etc/systemd/user/appimaged.service does not exist")
-			installServiceFileInHome()
+			installServiceFileInHome(thisai.Path)
 		}
 
 		prc := exec.Command("systemctl", "--user", "status", "appimaged")
@@ -458,9 +459,9 @@ func CheckIfInvokedBySystemd() bool {
 	return false
 }
 
-// installServiceFileInHome installs a service file for the currently running
-// AppImage in $XDG_DATA_HOME/systemd/user or $HOME/.local/share/systemd/user
-func installServiceFileInHome() {
+// installServiceFileInHome installs a service file that runs execPath
+// in $XDG_CONFIG_HOME/systemd/user or $HOME/.config/systemd/user
+func installServiceFileInHome(execPath string) {
 	var err error
 	home, _ := os.UserHomeDir()
 	// Note that https://www.freedesktop.org/software/systemd/man/systemd.unit.html
@@ -488,14 +489,14 @@ func installServiceFileInHome() {
 		}
 	}
 
-	log.Println("thisai.path:", thisai.Path)
+	log.Println("ExecStart:", execPath)
 	d1 := []byte(`[Unit]
 Description=AppImage system integration daemon
 After=syslog.target network.target
 
 [Service]
 Type=simple
-ExecStart=` + thisai.Path + `
+ExecStart=` + execPath + `
 
 LimitNOFILE=65536
 
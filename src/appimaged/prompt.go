@@ -0,0 +1,153 @@
+// Asks the user what to do with an AppImage that is opened but not integrated yet, the way AppImageLauncher does:
+// run it once, integrate it and run it, or move it into the Applications directory and run it from there.
+// The settings of AppImageLauncher in ~/.config/appimagelauncher.cfg and $APPIMAGELAUNCHER_DISABLE are respected,
+// so that users get the same behavior whichever of the two they have installed.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrg/xdg"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+	"golang.org/x/sys/unix"
+	"gopkg.in/ini.v1"
+)
+
+// What the user wants to do with an AppImage that is not integrated
+type integrationChoice int
+
+const (
+	choiceCancel integrationChoice = iota
+	choiceRunOnce
+	choiceIntegrate
+	choiceMove
+)
+
+// The configuration file of AppImageLauncher, e.g.,
+//
+//	[AppImageLauncher]
+//	destination = ~/Applications
+//	ask_to_move = true
+var appImageLauncherConfigFile = xdg.ConfigHome + "/appimagelauncher.cfg"
+
+// appImageLauncherSettings returns the directory into which AppImages are moved
+// and whether moving them is offered at all, as configured for AppImageLauncher
+func appImageLauncherSettings() (string, bool) {
+	destination := home + "/Applications"
+	askToMove := true
+	cfg, err := ini.Load(appImageLauncherConfigFile)
+	if err != nil {
+		return destination, askToMove
+	}
+	section := cfg.Section("AppImageLauncher")
+	if value := section.Key("destination").String(); value != "" {
+		if strings.HasPrefix(value, "~/") {
+			value = home + value[1:]
+		}
+		destination = value
+	}
+	if section.HasKey("ask_to_move") {
+		askToMove = section.Key("ask_to_move").MustBool(true)
+	}
+	return destination, askToMove
+}
+
+// askIntegration asks the user what to do with the AppImage ai, using zenity or kdialog,
+// or on the terminal if neither is available. Without any way to ask, it is integrated
+func askIntegration(ai *AppImage, destination string, offerMove bool) integrationChoice {
+	labels := map[integrationChoice]string{
+		choiceRunOnce:   i18n.Sprintf("Run once"),
+		choiceIntegrate: i18n.Sprintf("Integrate and run"),
+		choiceMove:      i18n.Sprintf("Move to %s and run", strings.Replace(destination, home, "~", 1)),
+	}
+	choices := []integrationChoice{choiceIntegrate, choiceRunOnce}
+	if offerMove {
+		choices = append(choices, choiceMove)
+	}
+	title := "AppImage Launcher"
+	text := i18n.Sprintf("%s is not integrated into the system yet. Integrating it adds it to the menu.\nWhat do you want to do?", ai.Name)
+
+	choiceForLabel := func(label string) integrationChoice {
+		for _, choice := range choices {
+			if labels[choice] == strings.TrimSpace(label) {
+				return choice
+			}
+		}
+		return choiceCancel
+	}
+	if os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != "" {
+		var cmd *exec.Cmd
+		if helpers.IsCommandAvailable("zenity") {
+			args := []string{"--list", "--title", title, "--text", text, "--column", "Action", "--hide-header"}
+			for _, choice := range choices {
+				args = append(args, labels[choice])
+			}
+			cmd = exec.Command("zenity", args...)
+		} else if helpers.IsCommandAvailable("kdialog") {
+			args := []string{"--title", title, "--menu", text}
+			for _, choice := range choices {
+				args = append(args, labels[choice], labels[choice])
+			}
+			cmd = exec.Command("kdialog", args...)
+		}
+		if cmd != nil {
+			// Exits with an error if the dialog is closed without choosing
+			out, err := cmd.Output()
+			if err != nil {
+				return choiceCancel
+			}
+			return choiceForLabel(string(out))
+		}
+	}
+
+	if _, err := unix.IoctlGetTermios(int(os.Stdin.Fd()), unix.TCGETS); err == nil {
+		fmt.Println(strings.Replace(text, "\n", " ", -1))
+		for i, choice := range choices {
+			fmt.Printf("  %d) %s\n", i+1, labels[choice])
+		}
+		fmt.Print(i18n.Sprintf("Choice [1]: "))
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return choices[0]
+		}
+		for i, choice := range choices {
+			if line == fmt.Sprint(i+1) {
+				return choice
+			}
+		}
+		return choiceCancel
+	}
+	return choiceIntegrate
+}
+
+// moveAppImage moves the AppImage at path into the directory destination and returns its new path
+func moveAppImage(path string, destination string) (string, error) {
+	err := os.MkdirAll(destination, 0755)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(destination, filepath.Base(path))
+	if helpers.Exists(target) {
+		return "", i18n.Errorf("%s exists already", target)
+	}
+	err = os.Rename(path, target)
+	if err != nil {
+		// E.g., from a USB stick to the home directory
+		err = helpers.CopyFile(path, target)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return target, os.Chmod(target, 0755)
+}
@@ -0,0 +1,273 @@
+// Keeps an index of the integrated AppImages so that they can be searched like installed packages,
+// using "appimaged search <term>" or the Search D-Bus method, without opening each AppImage.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/adrg/xdg"
+	"github.com/godbus/dbus/v5"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+)
+
+// The search index, which can be rebuilt from the integrated AppImages at any time
+var searchIndexFile = xdg.CacheHome + "/appimaged/index.json"
+
+// Version of the format of the search index. An index with another version is rebuilt
+const searchIndexVersion = 1
+
+var searchIndexMutex sync.Mutex
+
+// searchIndex contains what is searched of each integrated AppImage
+type searchIndex struct {
+	Version int                   `json:"version"`
+	Entries map[string]indexEntry `json:"entries"` // Key: path of the AppImage
+}
+
+// indexEntry is what is searched of an AppImage, taken from its desktop file and AppStream metainfo
+type indexEntry struct {
+	Path        string   `json:"path"`
+	ModTime     int64    `json:"modTime"` // Of the AppImage when it was indexed, in Unix nanoseconds
+	Name        string   `json:"name"`
+	Version     string   `json:"version,omitempty"`
+	GenericName string   `json:"genericName,omitempty"`
+	Comment     string   `json:"comment,omitempty"`
+	Summary     string   `json:"summary,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Categories  []string `json:"categories,omitempty"`
+}
+
+// searchResult is a match of a search as returned by the Search D-Bus method
+type searchResult struct {
+	Path    string
+	Name    string
+	Version string
+	Summary string
+}
+
+// appStreamText is the part of an AppStream metainfo file that is searched
+type appStreamText struct {
+	Summaries []struct {
+		Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"summary"`
+	Description struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"description"`
+	Keywords []string `xml:"keywords>keyword"`
+}
+
+var xmlTags = regexp.MustCompile(`<[^>]*>`)
+
+func loadSearchIndex() searchIndex {
+	index := searchIndex{Version: searchIndexVersion, Entries: make(map[string]indexEntry)}
+	data, err := ioutil.ReadFile(searchIndexFile)
+	if err != nil {
+		return index
+	}
+	var loaded searchIndex
+	if json.Unmarshal(data, &loaded) != nil || loaded.Version != searchIndexVersion || loaded.Entries == nil {
+		return index
+	}
+	return loaded
+}
+
+func (index searchIndex) save() {
+	data, err := json.Marshal(index)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(searchIndexFile), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(searchIndexFile, data, 0644)
+	}
+	helpers.LogError("search", err)
+}
+
+// newIndexEntry reads what is searched from the desktop file and the AppStream metainfo of the AppImage
+func newIndexEntry(ai *AppImage) indexEntry {
+	entry := indexEntry{Path: ai.Path, Name: ai.Name}
+	if info, err := os.Stat(ai.Path); err == nil {
+		entry.ModTime = info.ModTime().UnixNano()
+	}
+	if ai.Desktop != nil {
+		section := ai.Desktop.Section("Desktop Entry")
+		entry.Version = section.Key("X-AppImage-Version").String()
+		entry.GenericName = section.Key("GenericName").String()
+		entry.Comment = section.Key("Comment").String()
+		// goappimage replaces semicolons so that the desktop file can be parsed
+		entry.Keywords = splitDesktopList(section.Key("Keywords").String())
+		entry.Categories = splitDesktopList(section.Key("Categories").String())
+	}
+	if r, err := ai.ExtractFileReader("usr/share/metainfo/*.xml"); err == nil {
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		var metainfo appStreamText
+		if err == nil && xml.Unmarshal(data, &metainfo) == nil {
+			for _, summary := range metainfo.Summaries {
+				if summary.Lang == "" {
+					entry.Summary = strings.TrimSpace(summary.Text)
+				}
+			}
+			entry.Description = strings.Join(strings.Fields(xmlTags.ReplaceAllString(metainfo.Description.Inner, " ")), " ")
+			for _, keyword := range metainfo.Keywords {
+				entry.Keywords = helpers.AppendIfMissing(entry.Keywords, strings.TrimSpace(keyword))
+			}
+		}
+	}
+	return entry
+}
+
+func splitDesktopList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '；' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// updateSearchIndex adds the AppImage to the search index, unless it is there already and has not changed since
+func updateSearchIndex(ai *AppImage) {
+	searchIndexMutex.Lock()
+	defer searchIndexMutex.Unlock()
+	index := loadSearchIndex()
+	if entry, ok := index.Entries[ai.Path]; ok {
+		if info, err := os.Stat(ai.Path); err == nil && info.ModTime().UnixNano() == entry.ModTime {
+			return
+		}
+	}
+	index.Entries[ai.Path] = newIndexEntry(ai)
+	index.save()
+}
+
+// removeFromSearchIndex removes the AppImage at path from the search index
+func removeFromSearchIndex(path string) {
+	searchIndexMutex.Lock()
+	defer searchIndexMutex.Unlock()
+	index := loadSearchIndex()
+	if _, ok := index.Entries[path]; ok {
+		delete(index.Entries, path)
+		index.save()
+	}
+}
+
+// rebuildSearchIndex indexes the integrated AppImages, e.g., if there is no index yet
+func rebuildSearchIndex() searchIndex {
+	searchIndexMutex.Lock()
+	defer searchIndexMutex.Unlock()
+	index := searchIndex{Version: searchIndexVersion, Entries: make(map[string]indexEntry)}
+	for _, path := range FindIntegratedAppImages() {
+		ai, err := NewAppImage(path)
+		if err != nil {
+			continue
+		}
+		index.Entries[path] = newIndexEntry(ai)
+	}
+	index.save()
+	return index
+}
+
+// search returns the entries of the index that contain all words of term, the best matches first.
+// Matches in the name count most, then matches in the keywords and the generic name, then the rest
+func (index searchIndex) search(term string) []indexEntry {
+	words := strings.Fields(strings.ToLower(term))
+	if len(words) == 0 {
+		return nil
+	}
+	scores := make(map[string]int)
+	var matches []indexEntry
+	for path, entry := range index.Entries {
+		if helpers.Exists(path) == false {
+			continue
+		}
+		score := 0
+		for _, word := range words {
+			s := 0
+			name := strings.ToLower(entry.Name)
+			if strings.HasPrefix(name, word) {
+				s += 8
+			} else if strings.Contains(name, word) {
+				s += 4
+			}
+			if strings.Contains(strings.ToLower(entry.GenericName+"\n"+strings.Join(entry.Keywords, "\n")), word) {
+				s += 2
+			}
+			if strings.Contains(strings.ToLower(strings.Join([]string{entry.Comment, entry.Summary, entry.Description, strings.Join(entry.Categories, "\n")}, "\n")), word) {
+				s++
+			}
+			if s == 0 {
+				score = 0
+				break
+			}
+			score += s
+		}
+		if score > 0 {
+			scores[path] = score
+			matches = append(matches, entry)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if scores[matches[i].Path] != scores[matches[j].Path] {
+			return scores[matches[i].Path] > scores[matches[j].Path]
+		}
+		return strings.ToLower(matches[i].Name) < strings.ToLower(matches[j].Name)
+	})
+	return matches
+}
+
+func (entry indexEntry) summary() string {
+	if entry.Summary != "" {
+		return entry.Summary
+	}
+	if entry.Comment != "" {
+		return entry.Comment
+	}
+	return entry.GenericName
+}
+
+// searchIntegrated prints the integrated AppImages that match term, building the index first if there is none
+func searchIntegrated(term string) {
+	searchIndexMutex.Lock()
+	index := loadSearchIndex()
+	searchIndexMutex.Unlock()
+	if len(index.Entries) == 0 {
+		index = rebuildSearchIndex()
+	}
+	matches := index.search(term)
+	if len(matches) == 0 {
+		fmt.Println(i18n.Sprintf("No integrated AppImage matches %s", term))
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tPATH\tSUMMARY")
+	for _, entry := range matches {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Name, entry.Version, entry.Path, entry.summary())
+	}
+	w.Flush()
+}
+
+// Search returns the integrated AppImages that match term, the best matches first
+func (policyService) Search(term string) ([]searchResult, *dbus.Error) {
+	searchIndexMutex.Lock()
+	index := loadSearchIndex()
+	searchIndexMutex.Unlock()
+	results := []searchResult{}
+	for _, entry := range index.search(term) {
+		results = append(results, searchResult{Path: entry.Path, Name: entry.Name, Version: entry.Version, Summary: entry.summary()})
+	}
+	return results, nil
+}
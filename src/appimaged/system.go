@@ -0,0 +1,209 @@
+// Integrates the AppImages in one directory for all users of the machine, e.g., on shared workstations
+// and kiosks, when appimaged is run with --system. The daemon then runs as root using a systemd system unit
+// and writes the desktop files and icons into /usr/local/share. Commands that change the integration
+// ask for authorization using polkit (pkexec) when run by other users.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/adrg/xdg"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+)
+
+// The directory whose AppImages are integrated for all users
+const systemAppImagesDir = "/opt/appimages"
+
+// Where the desktop files and icons for all users are written to
+const systemDataDir = "/usr/local/share"
+
+// The polkit action that allows changing the integration for all users, see polkitPolicy
+const polkitAction = "io.github.probonopd.appimaged.manage"
+
+var polkitPolicyPath = "/usr/share/polkit-1/actions/" + polkitAction + ".policy"
+
+// The polkit policy installed by installSystemWide. Administrators can change
+// who is allowed to manage the AppImages with polkit rules for polkitAction
+var polkitPolicy = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE policyconfig PUBLIC "-//freedesktop//DTD PolicyKit Policy Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/PolicyKit/1/policyconfig.dtd">
+<policyconfig>
+  <action id="` + polkitAction + `">
+    <description>Manage the AppImages available to all users</description>
+    <message>Authentication is required to change the AppImages available to all users</message>
+    <defaults>
+      <allow_any>auth_admin</allow_any>
+      <allow_inactive>auth_admin</allow_inactive>
+      <allow_active>auth_admin_keep</allow_active>
+    </defaults>
+    <annotate key="org.freedesktop.policykit.exec.path">/usr/local/bin/appimaged</annotate>
+  </action>
+</policyconfig>
+`
+
+// takeSystemFlag moves --system in front of the command in args, where it is parsed as a global flag,
+// so that it can also be given after the command, e.g., "appimaged install --system".
+// The arguments of commands that run other programs are left alone
+func takeSystemFlag(args []string) []string {
+	if len(args) > 1 && helpers.SliceContains([]string{"wrap", "open", "launch", "run", "start"}, args[1]) {
+		return args
+	}
+	for i, arg := range args {
+		if i > 1 && (arg == "--system" || arg == "-system") {
+			moved := append([]string{args[0], "--system"}, args[1:i]...)
+			return append(moved, args[i+1:]...)
+		}
+	}
+	return args
+}
+
+// enableSystemMode makes appimaged integrate the AppImages in systemAppImagesDir for all users
+func enableSystemMode() {
+	*systemPtr = true
+	xdg.DataHome = systemDataDir
+	xdg.CacheHome = "/var/cache/appimaged"
+	ThumbnailsDirNormal = systemDataDir + "/appimaged/icons/"
+	policyFile = "/etc/appimaged/policy.ini"
+	trustedFile = "/etc/appimaged/trusted"
+	quarantinedFile = "/var/lib/appimaged/quarantined"
+	searchIndexFile = xdg.CacheHome + "/index.json"
+	defaultAllowedDirectories = []string{systemAppImagesDir}
+	candidateDirectories = []string{systemAppImagesDir}
+	installedPath = "/usr/local/bin/appimaged"
+	serviceFilePath = "/etc/systemd/system/appimaged.service"
+	// There is no session bus to send notifications to
+	*quietPtr = true
+}
+
+// requireRoot runs appimaged again with the same arguments using pkexec unless it runs as root already,
+// so that polkit decides whether the user may change the integration for all users, and exits with its exit code
+func requireRoot() {
+	if os.Geteuid() == 0 {
+		return
+	}
+	if helpers.IsCommandAvailable("pkexec") == false {
+		fmt.Println(i18n.Sprintf("Changing the integration for all users needs root rights, please run this as root"))
+		os.Exit(1)
+	}
+	self := os.Getenv("APPIMAGE")
+	if self == "" {
+		self, _ = os.Executable()
+	}
+	// pkexec resets the environment, hence --system is given again
+	cmd := exec.Command("pkexec", append([]string{self, "--system"}, os.Args[1:]...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		helpers.PrintError("pkexec", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// installSystemWide installs appimaged into /usr/local/bin together with the polkit policy,
+// and makes it integrate the AppImages in systemAppImagesDir for all users using a systemd system unit
+func installSystemWide() error {
+	source := os.Getenv("APPIMAGE")
+	if source == "" {
+		var err error
+		source, err = os.Executable()
+		if err != nil {
+			return err
+		}
+	}
+	if source != installedPath {
+		err := helpers.CopyFile(source, installedPath+".new")
+		if err == nil {
+			err = os.Chmod(installedPath+".new", 0755)
+		}
+		if err == nil {
+			err = os.Rename(installedPath+".new", installedPath)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("Installed", source, "to", installedPath)
+	}
+	err := os.MkdirAll(systemAppImagesDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(polkitPolicyPath), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(polkitPolicyPath, []byte(polkitPolicy), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Installed the polkit policy", polkitPolicyPath)
+
+	if CheckIfRunningSystemd() == false {
+		return errors.New("this system is not running systemd, please make " + installedPath + " --system run as root at boot")
+	}
+	err = ioutil.WriteFile(serviceFilePath, []byte(`[Unit]
+Description=AppImage system integration daemon for all users
+After=local-fs.target network.target
+
+[Service]
+Type=simple
+ExecStart=`+installedPath+` --system
+Restart=always
+RestartSec=3
+Environment=LAUNCHED_BY_SYSTEMD=1
+
+[Install]
+WantedBy=multi-user.target
+`), 0644)
+	if err != nil {
+		return err
+	}
+	for _, args := range [][]string{{"daemon-reload"}, {"enable", "appimaged"}, {"restart", "appimaged"}} {
+		prc := exec.Command("systemctl", args...)
+		out, err := prc.CombinedOutput()
+		if err != nil {
+			return errors.New(prc.String() + ": " + err.Error() + "\n" + string(out))
+		}
+	}
+	fmt.Println("Enabled and started the systemd unit", serviceFilePath+"; AppImages in", systemAppImagesDir, "are now integrated for all users")
+	return nil
+}
+
+// uninstallSystemWide reverses installSystemWide and removes the integration of all AppImages
+func uninstallSystemWide() {
+	if helpers.Exists(serviceFilePath) {
+		prc := exec.Command("systemctl", "disable", "--now", "appimaged")
+		out, err := prc.CombinedOutput()
+		if err != nil {
+			log.Println(prc.String(), err, string(out))
+		}
+		removeAndReport(serviceFilePath)
+		helpers.LogError("uninstall", exec.Command("systemctl", "daemon-reload").Run())
+	}
+	for _, path := range findDesktopFileTargets() {
+		ai, _ := NewAppImage(path)
+		removeAndReport(ai.desktopfilepath)
+		removeAndReport(ai.thumbnailfilepath)
+	}
+	removeAndReport(xdg.DataHome + "/applications/" + launcherDesktopFileName)
+	removeAndReport(xdg.DataHome + "/file-manager/actions/appimaged.desktop")
+	removeAndReport(xdg.DataHome + "/kservices5/ServiceMenus/appimaged.desktop")
+	removeAndReport(quarantinedFile)
+	removeAndReport(searchIndexFile)
+	removeAndReport(polkitPolicyPath)
+	if helpers.IsCommandAvailable("update-desktop-database") {
+		helpers.LogError("uninstall", exec.Command("update-desktop-database", xdg.DataHome+"/applications/").Run())
+	}
+	// The AppImages in systemAppImagesDir are left alone
+	removeAndReport(installedPath)
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
 
 	"github.com/godbus/dbus/v5"
 )
@@ -76,7 +77,6 @@ need to "eavesdrop"), independent of whether the GNOME or the KDE or another des
 func monitorUdisks() {
 
 	conn, err := dbus.SessionBusPrivate() // When using SessionBusPrivate(), need to follow with Auth(nil) and Hello()
-	defer conn.Close()
 	if err != nil {
 		helpers.PrintError("SessionBusPrivate", err)
 		return
@@ -85,6 +85,7 @@ func monitorUdisks() {
 		helpers.PrintError("No conn", err)
 		return
 	}
+	defer conn.Close()
 
 	if err = conn.Auth(nil); err != nil {
 		helpers.PrintError("Auth", err)
@@ -122,7 +123,7 @@ func monitorUdisks() {
 	}
 
 	if satisfied == false {
-		sendErrorDesktopNotification("Cannot see volumes come and go", "Not implemented yet for this kind of system")
+		sendErrorDesktopNotification(i18n.Sprintf("Cannot see volumes come and go"), i18n.Sprintf("Not implemented yet for this kind of system"))
 		log.Println("ERROR: Don't know how to get notified about mounted and unmounted devices on this system", e)
 		log.Println("using dbus. Every system seems to do it differently.", e)
 		// os.Exit(1)
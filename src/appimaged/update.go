@@ -8,20 +8,15 @@
 package main
 
 import (
-	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/i18n"
+	"github.com/probonopd/go-appimage/internal/zsync"
 )
 
-func update() {
-	if len(os.Args) < 2 {
-		fmt.Println("Argument missing")
-		os.Exit(1)
-	}
-	runUpdate(os.Args[2])
-}
-
 func runUpdate(path string) {
 	// I think this way of doing things is really clever because
 	// this way we can even put the update action into menus if
@@ -31,9 +26,14 @@ func runUpdate(path string) {
 	// a static location on the $PATH but can be put into any location
 	// from which it gets integrated.
 
-	// For now we don't implement updating functionality ourselves
-	// but merely launch an updater we found among the integrated
-	// AppImages. In the future we may do the updating ourselves.
+	// We update AppImages that point to a zsync file ourselves,
+	// and only launch an updater we found among the integrated
+	// AppImages if that is not possible.
+	err := updateUsingZsync(path)
+	if err == nil {
+		return
+	}
+	helpers.PrintError("update", err)
 
 	aiur := "gh-releases-zsync|antony-jr|AppImageUpdater|continuous|AppImageUpdater*-x86_64.AppImage.zsync"
 
@@ -45,7 +45,7 @@ func runUpdate(path string) {
 
 	a := FindMostRecentAppImageWithMatchingUpdateInformation(aiur)
 	if a == "" {
-		sendDesktopNotification("AppImageUpdater missing", "Please download the AppImageUpdater\nAppImage and try again", 30000)
+		sendDesktopNotification(i18n.Sprintf("AppImageUpdater missing"), i18n.Sprintf("Please download the AppImageUpdater\nAppImage and try again"), 30000)
 		// Tried making a hyperlink but when I click it in Xfce, nothing happens.
 	} else {
 		os.Unsetenv("INVOCATION_ID") // This is a variable that systemd sets; we use it to determine whether we were launched through systemd
@@ -58,3 +58,45 @@ func runUpdate(path string) {
 	}
 
 }
+
+// updateUsingZsync downloads the most recent version of the AppImage at path
+// using the zsync file its update information points to, reusing what is unchanged.
+// The old version is deleted once the new one is complete, like AppImageUpdate -d does
+func updateUsingZsync(path string) error {
+	ai, err := NewAppImage(path)
+	if err != nil {
+		return err
+	}
+	if ai.updateinformation == "" {
+		return i18n.Errorf("%s does not contain update information", path)
+	}
+	ui, err := helpers.NewUpdateInformationFromString(ai.updateinformation)
+	if err != nil {
+		return err
+	}
+	zsyncURL, version, err := helpers.GetZsyncURL(ui)
+	if err != nil {
+		return err
+	}
+	control, err := zsync.Fetch(zsyncURL)
+	if err != nil {
+		return err
+	}
+	if control.UpToDate(path) {
+		sendDesktopNotification(i18n.Sprintf("Up to date"), i18n.Sprintf("%s is already up to date", filepath.Base(path)), 5000)
+		return nil
+	}
+	target := control.TargetPath(path)
+	log.Println("update: Updating", path, "to", target)
+	reused, err := control.Update(path, target)
+	if err != nil {
+		return err
+	}
+	log.Println("update: Reused", reused, "of", control.Length, "bytes of", path)
+	if target != path {
+		err = os.Remove(path)
+		helpers.LogError("update", err)
+	}
+	sendDesktopNotification(i18n.Sprintf("Updated"), i18n.Sprintf("%s was updated to %s", ai.Name, version), 5000)
+	return nil
+}
@@ -0,0 +1,108 @@
+// Periodically checks whether newer versions of the integrated AppImages
+// have been published, independent of the MQTT messages that only
+// AppImages built with appimagetool on CI send.
+
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/zsync"
+)
+
+var noUpdateCheckPtr = new(bool) // Set by -nu, see newApp
+
+// How often to check for updates. Unauthenticated requests to the GitHub API
+// are limited to 60 per hour, so this must not be too often
+const updateCheckInterval = 6 * time.Hour
+
+// The SHA-1 digests of the updates the user has already been notified about, by AppImage path,
+// so that the same update is not offered again every time we check
+var notifiedUpdates = make(map[string]string)
+var notifiedUpdatesMutex sync.Mutex
+
+// checkForUpdatesPeriodically checks for updates shortly after startup and then every updateCheckInterval.
+// Run this with "go" prefixed to it
+func checkForUpdatesPeriodically() {
+	// Give the integration of AppImages at startup some time to complete
+	time.Sleep(2 * time.Minute)
+	checkForUpdates()
+	ticker := time.NewTicker(updateCheckInterval)
+	for {
+		select {
+		case <-ticker.C:
+			checkForUpdates()
+		case <-quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// checkForUpdates checks the most recent integrated AppImage for each update information
+// that points to GitHub Releases, and sends a desktop notification with an "Update" action
+// if the zsync file published there describes a different AppImage
+func checkForUpdates() {
+	if CheckIfConnectedToNetwork() == false {
+		return
+	}
+	checked := make(map[string]bool)
+	for _, path := range FindIntegratedAppImages() {
+		ai, err := NewAppImage(path)
+		if err != nil || !strings.HasPrefix(ai.updateinformation, "gh-releases-zsync|") || checked[ai.updateinformation] {
+			continue
+		}
+		checked[ai.updateinformation] = true
+		checkForUpdate(FindMostRecentAppImageWithMatchingUpdateInformation(ai.updateinformation))
+	}
+}
+
+// checkForUpdate checks whether an update is available for the AppImage at path
+// and sends a desktop notification if it is
+func checkForUpdate(path string) {
+	ai, err := NewAppImage(path)
+	if err != nil {
+		return
+	}
+	ui, err := helpers.NewUpdateInformationFromString(ai.updateinformation)
+	if err != nil {
+		helpers.PrintError("updatecheck: NewUpdateInformationFromString", err)
+		return
+	}
+	zsyncURL, version, err := helpers.GetZsyncURL(ui)
+	if err != nil {
+		helpers.PrintError("updatecheck: GetZsyncURL", err)
+		return
+	}
+	control, err := zsync.Fetch(zsyncURL)
+	if err != nil {
+		helpers.PrintError("updatecheck: zsync", err)
+		return
+	}
+	if control.UpToDate(ai.Path) {
+		if *verbosePtr == true {
+			log.Println("updatecheck:", ai.Path, "is up to date")
+		}
+		return
+	}
+	notifiedUpdatesMutex.Lock()
+	alreadyNotified := notifiedUpdates[ai.Path] == control.SHA1
+	notifiedUpdates[ai.Path] = control.SHA1
+	notifiedUpdatesMutex.Unlock()
+	if alreadyNotified {
+		return
+	}
+	log.Println("updatecheck:", ai.Path, "can be updated to", version)
+	if *quietPtr == true {
+		return
+	}
+	changelog, err := helpers.GetCommitMessageForLatestCommit(ui)
+	if err != nil {
+		changelog = ""
+	}
+	go sendUpdateDesktopNotification(ai, version, changelog)
+}
@@ -1,3 +1,6 @@
+//go:build linux
+// +build linux
+
 package main
 
 import (
@@ -7,20 +10,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"path"
-	"strconv"
 	"syscall"
 
-	"debug/elf"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
-	"github.com/otiai10/copy"
 	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+	"github.com/probonopd/go-appimage/pkg/fsys"
 )
 
 type QMLImport struct {
@@ -33,37 +36,113 @@ type QMLImport struct {
 	Version      string `json:"version"`
 }
 
-var allELFs []string
-var libraryLocations []string // All directories in the host system that may contain libraries
+// The filesystem in which the ELFs are resolved, copied and patched; replaced by an in-memory one in tests.
+// The resolver and the walker of a deployContext need to use the same one
+var appdirFS = fsys.OS
+
+// Sets the rpath of the ELF at path; replaced in tests so that no patchelf is needed
+var setRpath = setRpathUsingPatchelf
+
+// deployContext is the state of the deployment of one AppDir. It is passed explicitly
+// rather than kept in package-level variables, so that deployments do not share state
+type deployContext struct {
+	appdir helpers.AppDir
+	// The ELFs to be deployed, as paths in the host system or in the AppDir
+	elfs []string
+	// How the ELFs are linked, which tells the executables from the shared libraries, see classifyELF
+	elfInfos map[string]elfdeps.Info
+	// Resolves the libraries that ELFs need. Its locations are all directories in the host system that may contain libraries
+	resolver *elfdeps.SearchPathResolver
+	// Walks the ELFs and the libraries they need into a dependency graph,
+	// which records the libraries that could not be found and the rules by which the others were found
+	walker *elfdeps.Walker
+	// Directories in the AppDir with libraries that no ELF needs, computed once per deployment by dlopenLocationsInAppDir
+	dlopenLocations         []string
+	dlopenLocationsComputed bool
+	// Architectures of the ELFs deployed to each directory in the AppDir, computed once per deployment by architecturesInAppDir
+	locationArchitectures map[string][]elfdeps.Arch
+	// Paths relative to the AppDir of the files that were in it before it was first deployed, see recordOriginalFiles
+	originalFiles map[string]bool
+	// The ELFs the deployment patched as they were before, see backUpELF. Key: path relative to the AppDir
+	elfBackups map[string]elfBackup
+	// The ELFs that were backed up by this deployment rather than by a previous one. Key: path relative to the AppDir
+	backedUpELFs map[string]bool
+	// The patches that were applied during this deployment, see applyAppDirPatches
+	appliedPatches []appliedPatch
+	// The names of the deployers that have run, for the provenance
+	deployersRun []string
+	// Key: name of the package, value: location of the copyright file
+	copyrightFiles map[string]string
+	// Key: Path of the file, value: name of the package
+	packagesContainingFiles map[string]string
+	// The sections that get added to AppRun depending on what has been bundled into the AppDir, see addAppRunSection
+	appRunSections []string
+	// The patterns from .appdirignore and --ignore, see loadIgnorePatterns
+	ignored appDirIgnore
+	// Key: name of a theme that was bundled, value: paths of the files in the AppDir that belong to it
+	bundledThemes map[string][]string
+	// Whether qt_prfxpath is left as it is because it does not point to the Qt plugins, see getQtPrfxpath
+	quirksModePatchQtPrfxPath bool
+}
 
-var quirksModePatchQtPrfxPath = false
+// newDeployContext returns the context for deploying into appdir, which resolves libraries
+// in the default locations of the host system and in those given with options.libraryLocations
+func newDeployContext(appdir helpers.AppDir) *deployContext {
+	ctx := &deployContext{appdir: appdir, resolver: elfdeps.NewDefaultResolver(), elfInfos: make(map[string]elfdeps.Info),
+		copyrightFiles: make(map[string]string), packagesContainingFiles: make(map[string]string), bundledThemes: make(map[string][]string)}
+	ctx.resolver.FS = appdirFS
+	ctx.walker = newDependencyWalker(ctx)
+	for _, location := range options.libraryLocations {
+		addLibraryLocation(ctx, location.path, location.rule)
+	}
+	return ctx
+}
 
 var AppRunData = `#!/bin/sh
 
 HERE="$(dirname "$(readlink -f "${0}")")"
 
-MAIN=$(grep -r "^Exec=.*" "$HERE"/*.desktop | head -n 1 | cut -d "=" -f 2 | cut -d " " -f 1)
+############################################################################################
+# Set environment variables according to policies
+# Usage: apprun_export NAME VALUE DEFAULT_POLICY
+# The policy can be overridden at runtime by setting APPDIR_<NAME>_POLICY, e.g.,
+# APPDIR_PATH_POLICY=append, to one of prepend, append, replace, skip-if-set
+############################################################################################
+
+apprun_export() {
+  eval "current=\"\${$1}\""
+  eval "policy=\"\${APPDIR_$1_POLICY:-$3}\""
+  case "${policy}" in
+    append) value="${current:+${current}:}$2" ;;
+    replace) value="$2" ;;
+    skip-if-set) if [ ! -z "${current}" ] ; then return ; fi ; value="$2" ;;
+    *) value="$2${current:+:${current}}" ;;
+  esac
+  export "$1=${value}"
+}
+
+# Policies for environment variables determined at deployment time are inserted here
 
 ############################################################################################
 # Use bundled paths
 ############################################################################################
 
-export PATH="${HERE}"/usr/bin/:"${HERE}"/usr/sbin/:"${HERE}"/usr/games/:"${HERE}"/bin/:"${HERE}"/sbin/:"${PATH}"
-export XDG_DATA_DIRS="${HERE}"/usr/share/:"${XDG_DATA_DIRS}"
+apprun_export PATH "${HERE}/usr/bin/:${HERE}/usr/sbin/:${HERE}/usr/games/:${HERE}/bin/:${HERE}/sbin/" prepend
+apprun_export XDG_DATA_DIRS "${HERE}/usr/share/" prepend
 
 ############################################################################################
 # Use bundled Python
 ############################################################################################
 
-export PYTHONHOME="${HERE}"/usr/
+apprun_export PYTHONHOME "${HERE}/usr/" replace
 
 ############################################################################################
 # Use bundled Tcl/Tk
 ############################################################################################
 
 if [ -e "${HERE}"/usr/share/tcltk/tcl8.6 ] ; then
-  export TCL_LIBRARY="${HERE}"/usr/share/tcltk/tcl8.6:$TCL_LIBRARY:$TK_LIBRARY
-  export TK_LIBRARY="${HERE}"/usr/share/tcltk/tk8.6:$TK_LIBRARY:$TCL_LIBRARY
+  export TCL_LIBRARY="${HERE}/usr/share/tcltk/tcl8.6:${TCL_LIBRARY}:${TK_LIBRARY}"
+  export TK_LIBRARY="${HERE}/usr/share/tcltk/tk8.6:${TK_LIBRARY}:${TCL_LIBRARY}"
 fi
 
 ############################################################################################
@@ -72,57 +151,47 @@ fi
 
 case "${XDG_CURRENT_DESKTOP}" in
     *GNOME*|*gnome*)
-        export QT_QPA_PLATFORMTHEME=gtk2
+        apprun_export QT_QPA_PLATFORMTHEME gtk2 replace
 esac
 
-############################################################################################
-# If .ui files are in the AppDir, then chances are that we need to cd into usr/
-# because we may have had to patch the absolute paths away in the binary
-############################################################################################
-
-UIFILES=$(find "$HERE" -name "*.ui")
-if [ ! -z "$UIFILES" ] ; then
-  cd "$HERE/usr"
-fi
-
-############################################################################################
-# Use bundled GStreamer
-# NOTE: May need to remove libgstvaapi.so
-############################################################################################
-
-if [ ! -z $(find "${HERE}" -name "libgstcoreelements.so" -type f) ] ; then
-  export GST_PLUGIN_PATH=$(dirname $(readlink -f $(find "${HERE}" -name "libgstcoreelements.so" -type f | head -n 1)))
-  export GST_PLUGIN_SCANNER=$(find "${HERE}" -name "gst-plugin-scanner" -type f | head -n 1)
-  export GST_PLUGIN_SYSTEM_PATH=$GST_PLUGIN_PATH
-  env | grep GST
-fi
+# Additional sections determined at deployment time are inserted here
 
 ############################################################################################
 # Run experimental bundle that bundles everything if a private ld-linux-x86-64.so.2 is there
 # This allows the bundle to run even on older systems than the one it was built on
 ############################################################################################
 
-cd "$HERE/usr" # Not all applications will need this; TODO: Make this opt-in
-MAIN_BIN=$(find "$HERE/usr/bin" -name "$MAIN" | head -n 1)
-LD_LINUX=$(find "$HERE" -name 'ld-*.so.*' | head -n 1)
+MAIN_BIN="@MAIN_EXECUTABLE@" # Determined at deployment time from the desktop file
+LD_LINUX="@LD_LINUX@" # Empty unless a private ld-linux was found at deployment time
 if [ -e "$LD_LINUX" ] ; then
   echo "Run experimental self-contained bundle"
-  export GCONV_PATH="$HERE/usr/lib/gconv"
-  export FONTCONFIG_FILE="$HERE/etc/fonts/fonts.conf"
-  export GTK_EXE_PREFIX="$HERE/usr"
-  export GTK_THEME=Default # This one should be bundled so that it can work on systems without Gtk
-  export GDK_PIXBUF_MODULEDIR=$(find "$HERE" -name loaders -type d -path '*gdk-pixbuf*')
-  export GDK_PIXBUF_MODULE_FILE=$(find "$HERE" -name loaders.cache -type f -path '*gdk-pixbuf*') # Patched to contain no paths
+  apprun_export GCONV_PATH "$HERE/usr/lib/gconv" replace
+  if [ -d "$HERE/usr/lib/locale/C.UTF-8" ] || [ -d "$HERE/usr/lib/locale/C.utf8" ] ; then
+    apprun_export LOCPATH "$HERE/usr/lib/locale" replace
+  fi
+  apprun_export FONTCONFIG_FILE "$HERE/etc/fonts/fonts.conf" replace
+  apprun_export GTK_EXE_PREFIX "$HERE/usr" replace
+  apprun_export GTK_THEME Default replace # This one should be bundled so that it can work on systems without Gtk
+  apprun_export GDK_PIXBUF_MODULEDIR "@GDK_PIXBUF_MODULEDIR@" replace
+  apprun_export GDK_PIXBUF_MODULE_FILE "@GDK_PIXBUF_MODULE_FILE@" replace # Patched to contain no paths
   # export LIBRARY_PATH=$GDK_PIXBUF_MODULEDIR # Otherwise getting "Unable to load image-loading module"
-  export XDG_DATA_DIRS="${HERE}"/usr/share/:"${XDG_DATA_DIRS}"
-  export PERLLIB="${HERE}"/usr/share/perl5/:"${HERE}"/usr/lib/perl5/:"${PERLLIB}"
-  export GSETTINGS_SCHEMA_DIR="${HERE}"/usr/share/glib-2.0/runtime-schemas/:"${HERE}"/usr/share/glib-2.0/schemas/:"${GSETTINGS_SCHEMA_DIR}"
-  export QT_PLUGIN_PATH="${HERE}"/usr/lib/qt4/plugins/:"${HERE}"/usr/lib/i386-linux-gnu/qt4/plugins/:"${HERE}"/usr/lib/x86_64-linux-gnu/qt4/plugins/:"${HERE}"/usr/lib32/qt4/plugins/:"${HERE}"/usr/lib64/qt4/plugins/:"${HERE}"/usr/lib/qt5/plugins/:"${HERE}"/usr/lib/i386-linux-gnu/qt5/plugins/:"${HERE}"/usr/lib/x86_64-linux-gnu/qt5/plugins/:"${HERE}"/usr/lib32/qt5/plugins/:"${HERE}"/usr/lib64/qt5/plugins/:"${QT_PLUGIN_PATH}"
-  # exec "${LD_LINUX}" --inhibit-cache --library-path "${LIBRARY_PATH}" "${MAIN_BIN}" "$@"
-  case $line in
-    "ld-linux"*) exec "${LD_LINUX}" --inhibit-cache "${MAIN_BIN}" "$@" ;;
-    *) exec "${LD_LINUX}" "${MAIN_BIN}" "$@" ;;
-  esac
+  apprun_export XDG_DATA_DIRS "${HERE}/usr/share/" prepend
+  apprun_export GSETTINGS_SCHEMA_DIR "${HERE}/usr/share/glib-2.0/runtime-schemas/:${HERE}/usr/share/glib-2.0/schemas/" prepend
+  apprun_export QT_PLUGIN_PATH "${HERE}/usr/lib/qt4/plugins/:${HERE}/usr/lib/i386-linux-gnu/qt4/plugins/:${HERE}/usr/lib/x86_64-linux-gnu/qt4/plugins/:${HERE}/usr/lib32/qt4/plugins/:${HERE}/usr/lib64/qt4/plugins/:${HERE}/usr/lib/qt5/plugins/:${HERE}/usr/lib/i386-linux-gnu/qt5/plugins/:${HERE}/usr/lib/x86_64-linux-gnu/qt5/plugins/:${HERE}/usr/lib32/qt5/plugins/:${HERE}/usr/lib64/qt5/plugins/" prepend
+  # The bundled ld-linux looks for libraries in the directories listed in etc/ld.so.conf in the AppDir,
+  # then in its default directories, but not in the ld.so.cache of the system
+  APPRUN_LD_LIBRARY_DIRS=""
+  if [ -e "$HERE/etc/ld.so.conf" ] ; then
+    while IFS= read -r dir ; do
+      case "$dir" in
+        /*) APPRUN_LD_LIBRARY_DIRS="${APPRUN_LD_LIBRARY_DIRS:+${APPRUN_LD_LIBRARY_DIRS}:}${HERE}${dir}" ;;
+      esac
+    done < "$HERE/etc/ld.so.conf"
+  fi
+  if [ -n "$APPRUN_LD_LIBRARY_DIRS" ] ; then
+    exec "${LD_LINUX}" --inhibit-cache --library-path "${APPRUN_LD_LIBRARY_DIRS}" "${MAIN_BIN}" "$@"
+  fi
+  exec "${LD_LINUX}" "${MAIN_BIN}" "$@"
 else
   exec "${MAIN_BIN}" "$@"
 fi
@@ -134,12 +203,6 @@ type ELF struct {
 	rpath    string
 }
 
-// Key: name of the package, value: location of the copyright file
-var copyrightFiles = make(map[string]string) // Need to use 'make', otherwise we can't add to it
-
-// Key: Path of the file, value: name of the package
-var packagesContainingFiles = make(map[string]string) // Need to use 'make', otherwise we can't add to it
-
 /*
    man ld.so says:
 
@@ -163,50 +226,153 @@ var packagesContainingFiles = make(map[string]string) // Need to use 'make', oth
 
    o  In the default path /lib, and then /usr/lib.  (On some 64-bit architectures, the default paths for 64-bit shared  objects  are  /lib64,  and  then
       /usr/lib64.)  If the binary was linked with the -z nodeflib linker option, this step is skipped.
+
+   The -z nodeflib rules and ignoring LD_LIBRARY_PATH in secure-execution mode are simulated
+   by elfdeps.SearchConstraints, so that the same libraries are bundled as ld.so would load.
 */
 
 type DeployOptions struct {
-	standalone     bool
-	libAppRunHooks bool
+	standalone       bool
+	libAppRunHooks   bool
+	locales          []string
+	profile          string
+	relocations      []string
+	force            bool
+	missing          string
+	optional         []string
+	setuid           string
+	portal           string
+	debugAppRun      bool
+	compiledAppRun   bool
+	envPolicies      map[string]string
+	gstreamerPlugins []string
+	iconTheme        string
+	gschemaOverrides []string
+	hooks            []deployHook
+	patchesDir       string
+	ignore           []string
+	optimize         string
+	optimizeData     bool
+	dataDir          string
+	inPlace          bool
+	rpath            string
+	appVersion       string
+	provenanceNote   bool
+	pruneRpaths      bool
+	pins             []string
+	libraryLocations []libraryLocation // Outside of the AppDir, e.g., those of the runtime of a converted snap
+	noNetwork        bool
+	noBuildMetadata  bool
+	flags            []string // Names of the flags that were given, see usedFlagNames
 }
 
 // this is the public options instance
 // which need to be set before the function is called
 var options DeployOptions
 
-func AppDirDeploy(path string) {
-	appdir, err := helpers.NewAppDir(path)
+// AppDirDeploy deploys into the AppDir that contains the desktop file at path,
+// staging the deployment unless options.inPlace is set.
+// Returns the context of the deployment, e.g., for redeploying changed files
+func AppDirDeploy(path string) *deployContext {
+	if options.inPlace {
+		ctx := deployAppDir(path)
+		printDiagnosticsSummary()
+		return ctx
+	}
+	tx, err := beginDeployTransaction(path)
 	if err != nil {
-		helpers.PrintError("AppDir", err)
+		helpers.PrintError("Could not stage the deployment", err)
+		os.Exit(1)
+	}
+	ctx := deployAppDir(tx.stagedPath(path))
+	err = tx.commit()
+	if err != nil {
+		helpers.PrintError("Could not replace the AppDir with the staged deployment", err)
 		os.Exit(1)
 	}
+	printDiagnosticsSummary()
+	return ctx
+}
 
-	log.Println("Gathering all required libraries for the AppDir...")
-	determineELFsInDirTree(appdir, appdir.Path)
+// deployAppDir deploys into the AppDir that contains the desktop file at path
+func deployAppDir(path string) *deployContext {
+	appdir, err := helpers.OpenAppDir(path)
+	if err != nil {
+		helpers.PrintError("AppDir", err)
+		os.Exit(exitInvalidAppDir)
+	}
+	ctx := newDeployContext(appdir)
 
-	// Gdk
-	handleGdk(appdir)
+	// What was in the AppDir before, so that the deployment can be reverted with clean.
+	// This must come before anything is written into the AppDir, including the top-level files
+	err = recordOriginalFiles(ctx)
+	if err != nil {
+		helpers.PrintError("Could not list the files in the AppDir", err)
+		os.Exit(exitInvalidAppDir)
+	}
 
-	// GStreamer
-	handleGStreamer(appdir)
+	// The desktop file and the main icon at the top level
+	err = ctx.appdir.AddTopLevelFiles()
+	if err != nil {
+		helpers.PrintError("AppDir", err)
+		os.Exit(exitInvalidAppDir)
+	}
+	appdir = ctx.appdir
+
+	// Paths listed in .appdirignore or given with --ignore
+	ctx.ignored, err = loadIgnorePatterns(appdir.Path, options.ignore)
+	if err != nil {
+		helpers.PrintError("Could not read "+ignoreFileName, err)
+		os.Exit(exitInvalidAppDir)
+	}
 
-	// Gtk 3 modules/plugins
-	// If there is a .so with the name libgtk-3 inside the AppDir, then we need to
-	// bundle Gdk modules/plugins
-	deployGtkDirectory(appdir, 3)
+	// Libraries pinned in .appdirpins or with --pin
+	pins, err := loadPins(appdir.Path, options.pins)
+	if err != nil {
+		helpers.PrintError("Could not read the pinned libraries", err)
+		os.Exit(exitInvalidAppDir)
+	}
+	applyPins(ctx, pins)
+
+	if isStaticAppDir(ctx) {
+		deployStaticAppDir(ctx)
+		return ctx
+	}
 
-	// Gtk 2 modules/plugins
-	// Same as above, but for Gtk 2
-	deployGtkDirectory(appdir, 2)
+	// Executables linked against musl rather than glibc
+	handleMusl(ctx)
 
+	helpers.SetPhase("Gathering libraries")
+	log.Println("Gathering all required libraries for the AppDir...")
+	determineELFsInDirTree(ctx, appdir.Path)
+
+	// Profile selected with --profile
+	applyProfile(ctx)
+
+	// Gdk, GStreamer, Gtk 3 and Gtk 2 modules/plugins, and whatever else has a plugin
+	err = runDeployers(ctx, stageFrameworks)
+	if err != nil {
+		os.Exit(exitPatchFailure)
+	}
+
+	helpers.SetPhase("Deploying frameworks and data")
 	// ALSA
-	handleAlsa(appdir)
+	handleAlsa(ctx)
 
 	// PulseAudio
-	handlePulseAudio(appdir)
+	handlePulseAudio(ctx)
+
+	// XKB data and Compose tables
+	handleXkb(ctx)
+
+	// OpenSSL engines and providers
+	handleOpenSSL(ctx)
+
+	// Perl and Ruby modules
+	handleScriptingInterpreters(ctx)
 
 	// ld-linux interpreter
-	ldLinux, err := deployInterpreter(appdir)
+	ldLinux, err := deployInterpreter(ctx)
 
 	// Glib 2 schemas
 	if helpers.Exists(appdir.Path + "/usr/share/glib-2.0/schemas") {
@@ -215,59 +381,54 @@ func AppDirDeploy(path string) {
 			helpers.PrintError("Could not deploy GLib schemas", err)
 		}
 	}
+	// Translations
+	handleLocales(ctx)
+
 	// Fonts
 	err = deployFontconfig(appdir)
 	if err != nil {
 		helpers.PrintError("Could not deploy Fontconfig", err)
 	}
 
+	// Hardcoded absolute paths
+	handleAbsolutePaths(ctx)
+
+	// Files that need privileges
+	handleSetuidFiles(ctx)
+
+	// File dialogs from the host
+	handlePortals(ctx)
+
 	// AppRun
-	if options.libAppRunHooks == false {
-		// If libapprun_hooks is not used
-		log.Println("Adding AppRun...")
-		err = ioutil.WriteFile(appdir.Path+"/AppRun", []byte(AppRunData), 0755)
-		if err != nil {
-			helpers.PrintError("write AppRun", err)
-			os.Exit(1)
-		}
-	} else {
-		log.Println("TODO: Add AppRun suitable for libapprun_hooks...")
-	}
+	runHooks(ctx, hookBeforeAppRun)
+	writeAppRun(ctx)
 
 	log.Println("Find out whether Qt is a dependency of the application to be bundled...")
 
 	qtVersionDetected := 0
 
-	if containsString(allELFs, "libQt5Core.so.5") == true {
+	if containsString(ctx.elfs, "libQt5Core.so.5") == true {
 		log.Println("Detected Qt 5")
 		qtVersionDetected = 5
 	}
 
-	if containsString(allELFs, "libQtCore.so.4") == true {
+	if containsString(ctx.elfs, "libQtCore.so.4") == true {
 		log.Println("Detected Qt 4")
 		qtVersionDetected = 4
 	}
 
 	if qtVersionDetected > 0 {
-		handleQt(appdir, qtVersionDetected)
+		handleQt(ctx, qtVersionDetected)
 	}
 
 	fmt.Println("")
 	log.Println("libraryLocations:")
-	for _, lib := range libraryLocations {
+	for _, lib := range ctx.resolver.Locations() {
 		fmt.Println(lib)
 	}
 	fmt.Println("")
 
-	// This is used when calculating the rpath that gets written into the ELFs as they are copied into the AppDir
-	// and when modifying the ELFs that were pre-existing in the AppDir so that they become aware of the other locations
-	var libraryLocationsInAppDir []string
-	for _, lib := range libraryLocations {
-		if strings.HasPrefix(lib, appdir.Path) == false {
-			lib = appdir.Path + lib
-		}
-		libraryLocationsInAppDir = helpers.AppendIfMissing(libraryLocationsInAppDir, lib)
-	}
+	libraryLocationsInAppDir := getLibraryLocationsInAppDir(ctx)
 	fmt.Println("")
 
 	log.Println("libraryLocationsInAppDir:")
@@ -279,28 +440,123 @@ func AppDirDeploy(path string) {
 	/*
 		fmt.Println("")
 		log.Println("allELFs:")
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			fmt.Println(lib)
 		}
 	*/
 
+	helpers.SetPhase("Resolving libraries")
+	reportMissingLibraries(ctx)
+	reportMissingVersions(ctx)
+	reportLibraryConflicts(ctx)
+	reportCPUSpecificLibraries(ctx)
+	runHooks(ctx, hookAfterResolve)
+
 	log.Println("Only after this point should we start copying around any ELFs")
 
+	helpers.SetPhase("Copying and patching ELFs")
 	log.Println("Copying in and patching ELFs which are not already in the AppDir...")
 
-	handleNvidia()
+	handleNvidia(ctx)
 
-	for _, lib := range allELFs {
+	resetRpathPlanning(ctx)
+	cache := loadDeployCache(appdir)
+	for _, lib := range ctx.elfs {
+
+		if cache.isCurrent(ctx, libraryLocationsInAppDir, lib) {
+			continue
+		}
 
 		deployElf(lib, appdir, err)
-		patchRpathsInElf(appdir, libraryLocationsInAppDir, lib)
+		rewriteNeededPaths(ctx, lib)
+		patchRpathsInElf(ctx, libraryLocationsInAppDir, lib)
 
 		if strings.Contains(lib, "libQt5Core.so.5") {
-			patchQtPrfxpath(appdir, lib, libraryLocationsInAppDir, ldLinux)
+			patchQtPrfxpath(ctx, lib, libraryLocationsInAppDir, ldLinux)
+		}
+
+		cache.update(ctx, libraryLocationsInAppDir, lib)
+	}
+	for _, lib := range checkDeployedRpaths(ctx) {
+		cache.update(ctx, libraryLocationsInAppDir, lib)
+	}
+	log.Println("Skipped", cache.skipped, "ELFs that were already deployed and unchanged")
+	if options.libAppRunHooks && ldLinux != "" && isMuslInterpreter(ldLinux) == false {
+		err = writeLdSoConf(ctx, ldLinux)
+		if err != nil {
+			helpers.PrintError("Could not write "+ldSoConfPath, err)
+			os.Exit(exitPatchFailure)
+		}
+	}
+	err = cache.save(ctx)
+	if err != nil {
+		helpers.PrintError("Could not save the deployment cache", err)
+	}
+
+	helpers.SetPhase("Finishing the AppDir")
+	deployCopyrightFiles(ctx)
+	applyAppDirPatches(ctx)
+	optimizeAppDir(appdir)
+	if options.optimizeData {
+		optimizeData(appdir.Path, ctx.ignored)
+	}
+	runHooks(ctx, hookAfterCopy)
+
+	err = writeProvenance(ctx, cache)
+	if err != nil {
+		helpers.PrintError("Could not write "+provenanceName, err)
+	}
+	err = writeDeploymentManifest(ctx, cache)
+	if err != nil {
+		helpers.PrintError("Could not write "+deploymentManifestName, err)
+	}
+	return ctx
+}
+
+// writeAppRun writes AppRun, including the sections added during the deployment
+func writeAppRun(ctx *deployContext) {
+	appdir := ctx.appdir
+	var err error
+	if options.dataDir != "" {
+		addDataCompanionSection(ctx)
+	}
+	if options.libAppRunHooks == false {
+		// If libapprun_hooks is not used
+		if options.debugAppRun {
+			err = writeDebugAppRun(ctx)
+			if err != nil {
+				helpers.PrintError("write AppRun.debug", err)
+				os.Exit(1)
+			}
+		}
+		if options.compiledAppRun {
+			err = writeCompiledAppRun(ctx)
+		} else {
+			log.Println("Adding AppRun...")
+			err = ioutil.WriteFile(appdir.Path+"/AppRun", []byte(generateAppRun(ctx)), 0755)
 		}
+		if err != nil {
+			helpers.PrintError("write AppRun", err)
+			os.Exit(1)
+		}
+	} else {
+		log.Println("TODO: Add AppRun suitable for libapprun_hooks...")
 	}
+}
 
-	deployCopyrightFiles(appdir)
+// getLibraryLocationsInAppDir returns the locations inside the AppDir that correspond to libraryLocations.
+// This is used when calculating the rpath that gets written into the ELFs as they are copied into the AppDir
+// and when modifying the ELFs that were pre-existing in the AppDir so that they become aware of the other locations
+func getLibraryLocationsInAppDir(ctx *deployContext) []string {
+	appdir := ctx.appdir
+	var libraryLocationsInAppDir []string
+	for _, lib := range ctx.resolver.Locations() {
+		if strings.HasPrefix(lib, appdir.Path) == false {
+			lib = appdir.Path + lib
+		}
+		libraryLocationsInAppDir = helpers.AppendIfMissing(libraryLocationsInAppDir, lib)
+	}
+	return libraryLocationsInAppDir
 }
 
 func deployFontconfig(appdir helpers.AppDir) error {
@@ -321,11 +577,15 @@ func deployFontconfig(appdir helpers.AppDir) error {
 	return err
 }
 
-func deployInterpreter(appdir helpers.AppDir) (string, error) {
-	var ldLinux, err = appdir.GetElfInterpreter(appdir)
+func deployInterpreter(ctx *deployContext) (string, error) {
+	appdir := ctx.appdir
+	if checkMainExecutableLinking(appdir) == false {
+		return "", nil
+	}
+	var ldLinux, err = appdir.ElfInterpreter()
 	if err != nil {
 		helpers.PrintError("Could not determine ELF interpreter", err)
-		os.Exit(1)
+		os.Exit(exitInvalidAppDir)
 	}
 	if helpers.Exists(appdir.Path+"/"+ldLinux) == true {
 		log.Println("Removing pre-existing", ldLinux+"...")
@@ -336,7 +596,13 @@ func deployInterpreter(appdir helpers.AppDir) (string, error) {
 		}
 
 	}
-	if options.libAppRunHooks {
+	if isMuslInterpreter(ldLinux) {
+		err = deployMuslInterpreter(appdir, ldLinux)
+		if err != nil {
+			helpers.PrintError("Could not deploy the musl dynamic linker", err)
+			os.Exit(exitUnresolvedDependencies)
+		}
+	} else if options.libAppRunHooks {
 		var err error
 		// ld-linux might be a symlink; hence we first need to resolve it
 		src, err := filepath.EvalSymlinks(ldLinux)
@@ -357,42 +623,15 @@ func deployInterpreter(appdir helpers.AppDir) (string, error) {
 			log.Println(ldLinux, "is part of libc; copy to", LibcDir, "subdirectory")
 			ldTargetPath = appdir.Path + "/" + LibcDir + "/" + ldLinux // If libapprun_hooks is used
 		}
-		err = copy.Copy(src, ldTargetPath)
+		err = helpers.CopyTree(src, ldTargetPath)
 		if err != nil {
 			helpers.PrintError("Could not copy ld-linux", err)
 			return "", err
 		}
-		// Do what we do in the Scribus AppImage script, namely
-		// sed -i -e 's|/usr|/xxx|g' lib/x86_64-linux-gnu/ld-linux-x86-64.so.2
-		log.Println("Patching ld-linux...")
-		err = PatchFile(ldTargetPath, "/lib", "/XXX")
+		err = deployGlibc(ctx, ldLinux)
 		if err != nil {
-			helpers.PrintError("PatchFile", err)
-			return "", err
-		}
-		err = PatchFile(ldTargetPath, "/usr", "/xxx")
-		if err != nil {
-			helpers.PrintError("PatchFile", err)
-			return "", err
-		}
-		// --inhibit-cache is not working, it is still using /etc/ld.so.cache
-		err = PatchFile(ldTargetPath, "/etc", "/EEE")
-		if err != nil {
-			helpers.PrintError("PatchFile", err)
-			return "", err
-		}
-		log.Println("Determining gconv (for GCONV_PATH)...")
-		// Search in all of the system's library directories for a directory called gconv
-		// and put it into the a location which matches the GCONV_PATH we export in AppRun
-		gconvs, err := findWithPrefixInLibraryLocations("gconv")
-		if err == nil {
-			// Target location must match GCONV_PATH exported in AppRun
-			determineELFsInDirTree(appdir, gconvs[0])
-		}
-
-		if err != nil {
-			helpers.PrintError("Could not deploy the interpreter", err)
-			os.Exit(1)
+			helpers.PrintError("Could not deploy glibc", err)
+			os.Exit(exitUnresolvedDependencies)
 		}
 	} else {
 		log.Println("Not deploying", ldLinux, "because it was not requested or it is not needed")
@@ -403,6 +642,10 @@ func deployInterpreter(appdir helpers.AppDir) (string, error) {
 // deployElf deploys an ELF (executable or shared library) to the AppDir
 // if it is not on the exclude list and it is not yet at the target location
 func deployElf(lib string, appdir helpers.AppDir, err error) {
+	if isExcludedByProfile(lib) == true {
+		log.Println("Skipping", lib, "because it is excluded by the", options.profile, "profile")
+		return
+	}
 	for _, excludePrefix := range ExcludedLibraries {
 		if strings.HasPrefix(filepath.Base(lib), excludePrefix) == true && !options.standalone {
 			log.Println("Skipping", lib, "because it is on the excludelist")
@@ -424,7 +667,7 @@ func deployElf(lib string, appdir helpers.AppDir, err error) {
 		}
 		log.Println("Copying to libTargetPath:", libTargetPath, "(TODO: Remove this message)")
 
-		err = helpers.CopyFile(lib, libTargetPath) // If libapprun_hooks is not used
+		err = copyIntoAppDir(lib, libTargetPath) // If libapprun_hooks is not used
 
 		if err != nil {
 			log.Println(libTargetPath, "could not be copied:", err)
@@ -435,14 +678,15 @@ func deployElf(lib string, appdir helpers.AppDir, err error) {
 
 // patchQtPrfxpath patches qt_prfxpath of the libQt5Core.so.5 in an AppDir
 // so that the Qt installation finds its own components in the AppDir
-func patchQtPrfxpath(appdir helpers.AppDir, lib string, libraryLocationsInAppDir []string, ldLinux string) {
+func patchQtPrfxpath(ctx *deployContext, lib string, libraryLocationsInAppDir []string, ldLinux string) {
+	appdir := ctx.appdir
 	log.Println("Patching qt_prfxpath, otherwise can't load platform plugin...")
 	f, err := os.Open(appdir.Path + "/" + lib)
 	// Open file for reading/determining the offset
 	defer f.Close()
 	if err != nil {
 		helpers.PrintError("Could not open libQt5Core.so.5 for reading", err)
-		os.Exit(1)
+		os.Exit(exitPatchFailure)
 	}
 	f.Seek(0, 0)
 	// Search from the beginning of the file
@@ -469,14 +713,14 @@ func patchQtPrfxpath(appdir helpers.AppDir, lib string, libraryLocationsInAppDir
 	}
 	if qtPrefixDir == "" {
 		helpers.PrintError("Could not determine the the Qt prefix directory:", err)
-		os.Exit(1)
+		os.Exit(exitPatchFailure)
 	} else {
 		log.Println("Qt prefix directory in the AppDir:", qtPrefixDir)
 	}
 	relPathToQt, err := filepath.Rel(filepath.Dir(appdir.Path+ldLinux), qtPrefixDir)
 	if err != nil {
 		helpers.PrintError("Could not compute the location of the Qt plugins directory:", err)
-		os.Exit(1)
+		os.Exit(exitPatchFailure)
 	} else {
 		log.Println("Relative path from ld-linux to Qt prefix directory in the AppDir:", relPathToQt)
 	}
@@ -485,11 +729,11 @@ func patchQtPrfxpath(appdir helpers.AppDir, lib string, libraryLocationsInAppDir
 	defer f.Close()
 	if err != nil {
 		helpers.PrintError("Could not open libQt5Core.so.5 for writing", err)
-		os.Exit(1)
+		os.Exit(exitPatchFailure)
 	}
 	// Now that we know where in the file the information is, go write it
 	f.Seek(offset, 0)
-	if quirksModePatchQtPrfxPath == false {
+	if ctx.quirksModePatchQtPrfxPath == false {
 		log.Println("Patching qt_prfxpath in libQt5Core.so.5 to " + relPathToQt)
 		_, err = f.Write([]byte(relPathToQt + "\x00"))
 	} else {
@@ -502,10 +746,11 @@ func patchQtPrfxpath(appdir helpers.AppDir, lib string, libraryLocationsInAppDir
 }
 
 // deployCopyrightFiles deploys copyright files into the AppDir
-// for each ELF in allELFs that are inside the AppDir and have matching equivalents outside of the AppDir
-func deployCopyrightFiles(appdir helpers.AppDir) {
+// for each ELF in ctx.elfs that are inside the AppDir and have matching equivalents outside of the AppDir
+func deployCopyrightFiles(ctx *deployContext) {
+	appdir := ctx.appdir
 	log.Println("Copying in copyright files...")
-	for _, lib := range allELFs {
+	for _, lib := range ctx.elfs {
 
 		shouldDoIt := true
 		for _, excludePrefix := range ExcludedLibraries {
@@ -518,11 +763,11 @@ func deployCopyrightFiles(appdir helpers.AppDir) {
 
 		if shouldDoIt == true && strings.HasPrefix(lib, appdir.Path) == false {
 			// Copy copyright files into the AppImage
-			copyrightFile, err := getCopyrightFile(lib)
+			copyrightFile, err := getCopyrightFile(ctx, lib)
 			// It is perfectly fine for this to error - on non-dpkg systems, or if lib was not in a deb package
 			if err == nil {
 				os.MkdirAll(filepath.Dir(appdir.Path+copyrightFile), 0755)
-				copy.Copy(copyrightFile, appdir.Path+copyrightFile)
+				helpers.CopyTree(copyrightFile, appdir.Path+copyrightFile)
 			}
 
 		}
@@ -540,87 +785,18 @@ func deployCopyrightFiles(appdir helpers.AppDir) {
 
 }
 
-// handleGlibSchemas compiles GLib schemas if the subdirectory is present in the AppImage.
-// AppRun has to export GSETTINGS_SCHEMA_DIR for this to work
-func handleGlibSchemas(appdir helpers.AppDir) error {
-	var err error
-	if helpers.Exists(appdir.Path+"/usr/share/glib-2.0/schemas") && !helpers.Exists(appdir.Path+"/usr/share/glib-2.0/schemas/gschemas.compiled") {
-		log.Println("Compiling glib-2.0 schemas...")
-		cmd := exec.Command("glib-compile-schemas", ".")
-		cmd.Dir = appdir.Path + "/usr/share/glib-2.0/schemas"
-		err = cmd.Run()
-		if err != nil {
-			helpers.PrintError("Run glib-compile-schemas", err)
-			os.Exit(1)
-		}
-	}
-	return err
-}
-
-func handleGdk(appdir helpers.AppDir) {
-	// If there is a .so with the name libgdk_pixbuf inside the AppDir, then we need to
-	// bundle Gdk pixbuf loaders without which the bundled Gtk does not work
-	// cp /usr/lib/x86_64-linux-gnu/gdk-pixbuf-*/*/loaders/* usr/lib/x86_64-linux-gnu/gdk-pixbuf-*/*/loaders/
-	// cp /usr/lib/x86_64-linux-gnu/gdk-pixbuf-*/*/loaders.cache usr/lib/x86_64-linux-gnu/gdk-pixbuf-*/*/ -
-	// this file must also be patched not to contain paths to the libraries
-	for _, lib := range allELFs {
-		if strings.HasPrefix(filepath.Base(lib), "libgdk_pixbuf") {
-			log.Println("Determining Gdk pixbuf loaders (for GDK_PIXBUF_MODULEDIR and GDK_PIXBUF_MODULE_FILE)...")
-			locs, err := findWithPrefixInLibraryLocations("gdk-pixbuf")
-			if err != nil {
-				log.Println("Could not find Gdk pixbuf loaders")
-				os.Exit(1)
-			} else {
-				for _, loc := range locs {
-					determineELFsInDirTree(appdir, loc)
-
-					// We need to patch away the path to libpixbufloader-png.so from the file loaders.cache, similar to:
-					// sed -i -e 's|/usr/lib/x86_64-linux-gnu/gdk-pixbuf-2.0/2.10.0/loaders/||g' usr/lib/x86_64-linux-gnu/gdk-pixbuf-*/*/loaders.cache
-					// the patched file must not contain paths to the libraries
-					loadersCaches := helpers.FilesWithSuffixInDirectoryRecursive(loc, "loaders.cache")
-					if len(loadersCaches) < 1 {
-						helpers.PrintError("loadersCaches", errors.New("could not find loaders.cache"))
-						os.Exit(1)
-					}
-
-					err = copy.Copy(loadersCaches[0], appdir.Path+loadersCaches[0])
-					if err != nil {
-						helpers.PrintError("Could not copy loaders.cache", err)
-						os.Exit(1)
-					}
-
-					whatToPatchAway := helpers.FilesWithSuffixInDirectoryRecursive(loc, "libpixbufloader-png.so")
-					if len(whatToPatchAway) < 1 {
-						helpers.PrintError("whatToPatchAway", errors.New("could not find directory that contains libpixbufloader-png.so"))
-						break // os.Exit(1)
-					}
-
-					log.Println("Patching", appdir.Path+loadersCaches[0], "removing", filepath.Dir(whatToPatchAway[0])+"/")
-					err = PatchFile(appdir.Path+loadersCaches[0], filepath.Dir(whatToPatchAway[0])+"/", "")
-					if err != nil {
-						helpers.PrintError("PatchFile loaders.cache", err)
-						break // os.Exit(1)
-					}
-
-				}
-			}
-			break
-		}
-	}
-}
-
-func handlePulseAudio(appdir helpers.AppDir) {
+func handlePulseAudio(ctx *deployContext) {
 	// TODO: What about the `/usr/lib/pulse-*` directory?
-	for _, lib := range allELFs {
+	for _, lib := range ctx.elfs {
 		if strings.HasPrefix(filepath.Base(lib), "libpulse.so") {
 			log.Println("Bundling pulseaudio directory (for <tbd>)...")
-			locs, err := findWithPrefixInLibraryLocations("pulseaudio")
+			locs, err := findWithPrefixInLibraryLocations(ctx, "pulseaudio")
 			if err != nil {
 				log.Println("Could not find pulseaudio directory")
-				os.Exit(1)
+				os.Exit(exitUnresolvedDependencies)
 			} else {
 				log.Println("Bundling dependencies of pulseaudio directory...")
-				determineELFsInDirTree(appdir, locs[0])
+				determineELFsInDirTree(ctx, locs[0])
 			}
 
 			break
@@ -628,32 +804,32 @@ func handlePulseAudio(appdir helpers.AppDir) {
 	}
 }
 
-func handleNvidia() {
+func handleNvidia(ctx *deployContext) {
 	// As soon as we bundle libnvidia*, we get a segfault.
 	// Hence we exit whenever libGL.so.1 requires libnvidia*
-	for _, elf := range allELFs {
+	for _, elf := range ctx.elfs {
 		if strings.HasPrefix(filepath.Base(elf), "libnvidia") {
 			log.Println("System (most likely libGL) uses libnvidia*, please build on another system that does not use NVIDIA drivers, exiting")
-			os.Exit(1)
+			os.Exit(exitUnresolvedDependencies)
 		}
 	}
 }
 
-func handleAlsa(appdir helpers.AppDir) {
+func handleAlsa(ctx *deployContext) {
 	// FIXME: Doesn't seem to get loaded. Is ALSA_PLUGIN_DIR needed and working in ALSA?
 	// Is something like https://github.com/flatpak/freedesktop-sdk-images/blob/1.6/alsa-lib-plugin-path.patch needed in the bundled ALSA?
 	// TODO: What about the `share/alsa` subdirectory? libasound.so.* refers to it as well
-	for _, lib := range allELFs {
+	for _, lib := range ctx.elfs {
 		if strings.HasPrefix(filepath.Base(lib), "libasound.so") {
 			log.Println("Bundling alsa-lib directory (for <tbd>)...")
-			locs, err := findWithPrefixInLibraryLocations("alsa-lib")
+			locs, err := findWithPrefixInLibraryLocations(ctx, "alsa-lib")
 			if err != nil {
 				log.Println("Could not find alsa-lib directory")
 				log.Println("E.g., in Alpine Linux: apk add alsa-plugins alsa-plugins-pulse")
-				os.Exit(1)
+				os.Exit(exitUnresolvedDependencies)
 			} else {
 				log.Println("Bundling dependencies of alsa-lib directory...")
-				determineELFsInDirTree(appdir, locs[0])
+				determineELFsInDirTree(ctx, locs[0])
 			}
 
 			break
@@ -661,51 +837,11 @@ func handleAlsa(appdir helpers.AppDir) {
 	}
 }
 
-func handleGStreamer(appdir helpers.AppDir) {
-	for _, lib := range allELFs {
-		if strings.HasPrefix(filepath.Base(lib), "libgstreamer-1.0") {
-			log.Println("Bundling GStreamer 1.0 directory (for GST_PLUGIN_PATH)...")
-			locs, err := findWithPrefixInLibraryLocations("gstreamer-1.0")
-			if err != nil {
-				log.Println("Could not find GStreamer 1.0 directory")
-				os.Exit(1)
-			} else {
-				log.Println("Bundling dependencies of GStreamer 1.0 directory...")
-				determineELFsInDirTree(appdir, locs[0])
-			}
+func patchRpathsInElf(ctx *deployContext, libraryLocationsInAppDir []string, path string) {
+	appdir := ctx.appdir
 
-			// FIXME: This is not going to scale, every distribution is cooking their own soup,
-			// we need to determine the location of gst-plugin-scanner dynamically by parsing it out of libgstreamer-1.0
-			gstPluginScannerCandidates := []string{"/usr/libexec/gstreamer-1.0/gst-plugin-scanner", // Clear Linux* OS
-				"/usr/lib/x86_64-linux-gnu/gstreamer1.0/gstreamer-1.0/gst-plugin-scanner"} // sic! Ubuntu 18.04
-			for _, cand := range gstPluginScannerCandidates {
-				if helpers.Exists(cand) {
-					log.Println("Determining gst-plugin-scanner...")
-					determineELFsInDirTree(appdir, cand)
-					break
-				}
-			}
-
-			break
-		}
-	}
-}
-
-func patchRpathsInElf(appdir helpers.AppDir, libraryLocationsInAppDir []string, path string) {
-
-	if strings.HasPrefix(path, appdir.Path) == false {
-		path = filepath.Clean(appdir.Path + "/" + path)
-	}
-	var newRpathStringForElf string
-	var newRpathStrings []string
-	for _, libloc := range libraryLocationsInAppDir {
-		relpath, err := filepath.Rel(filepath.Dir(path), libloc)
-		if err != nil {
-			helpers.PrintError("Could not compute relative path", err)
-		}
-		newRpathStrings = append(newRpathStrings, "$ORIGIN/"+filepath.Clean(relpath))
-	}
-	newRpathStringForElf = strings.Join(newRpathStrings, ":")
+	newRpathStringForElf := computeRpath(ctx, libraryLocationsInAppDir, path)
+	path = getTargetPathInAppDir(appdir, path)
 	// fmt.Println("Computed newRpathStringForElf:", appdir.Path+"/"+lib, newRpathStringForElf)
 
 	if options.libAppRunHooks && checkWhetherPartOfLibc(path) {
@@ -718,79 +854,52 @@ func patchRpathsInElf(appdir helpers.AppDir, libraryLocationsInAppDir []string,
 		return
 	}
 
+	if isStaticELF(path) {
+		log.Println("Not writing rpath in", path, "because it is statically linked")
+		return
+	}
+
 	// Be sure that the file we want to patch exists
-	if helpers.Exists(path) == false {
+	if fsys.Exists(appdirFS, path) == false {
 		log.Println(path, "does not exist, hence we cannot set its rpath, exiting")
-		os.Exit(1)
+		os.Exit(exitPatchFailure)
 	}
 
-	// Call patchelf to set the rpath
-	if helpers.Exists(path) == true {
-		// log.Println("Rewriting rpath of", path)
-		cmd := exec.Command("patchelf", "--set-rpath", newRpathStringForElf, path)
-		// log.Println(cmd.Args)
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			fmt.Println(cmd.String())
-			helpers.PrintError("patchelf --set-rpath "+path+": "+string(out), err)
-			os.Exit(1)
-		}
+	validateRpath(appdir, path, newRpathStringForElf)
+	backUpELF(ctx, path)
+	err := setRpath(path, newRpathStringForElf)
+	if err != nil {
+		helpers.PrintError("Could not set the rpath of "+path, err)
+		os.Exit(exitPatchFailure)
 	}
 }
 
-func deployGtkDirectory(appdir helpers.AppDir, gtkVersion int) {
-	for _, lib := range allELFs {
-		if strings.HasPrefix(filepath.Base(lib), "libgtk-"+strconv.Itoa(gtkVersion)) {
-			log.Println("Bundling Gtk", strconv.Itoa(gtkVersion), "directory (for GTK_EXE_PREFIX)...")
-			locs, err := findWithPrefixInLibraryLocations("gtk-" + strconv.Itoa(gtkVersion))
-			if err != nil {
-				log.Println("Could not find Gtk", strconv.Itoa(gtkVersion), "directory")
-				os.Exit(1)
-			} else {
-				for _, loc := range locs {
-					log.Println("Bundling dependencies of Gtk", strconv.Itoa(gtkVersion), "directory...")
-					determineELFsInDirTree(appdir, loc)
-					log.Println("Bundling Default theme for Gtk", strconv.Itoa(gtkVersion), "(for GTK_THEME=Default)...")
-					err = copy.Copy("/usr/share/themes/Default/gtk-"+strconv.Itoa(gtkVersion)+".0", appdir.Path+"/usr/share/themes/Default/gtk-"+strconv.Itoa(gtkVersion)+".0")
-					if err != nil {
-						helpers.PrintError("Copy", err)
-						os.Exit(1)
-					}
-
-					/*
-						log.Println("Bundling icons for Default theme...")
-						err = copy.Copy("/usr/share/icons/Adwaita", appdir.Path+"/usr/share/icons/Adwaita")
-						if err != nil {
-							helpers.PrintError("Copy", err)
-							os.Exit(1)
-						}
-					*/
-				}
-			}
-			break
-		}
+// setRpathUsingPatchelf sets the rpath of the ELF at path using patchelf
+func setRpathUsingPatchelf(path string, rpath string) error {
+	requireTool("patchelf", "setting the rpath of the bundled ELF files")
+	cmd := exec.Command("patchelf", "--set-rpath", rpath, path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Println(cmd.String())
+		return errors.New("patchelf --set-rpath " + path + ": " + string(out) + err.Error())
 	}
+	return nil
+}
 
-	// Check for the presence of Gtk .ui files
-	uifiles := helpers.FilesWithSuffixInDirectoryRecursive(appdir.Path, ".ui")
-	if len(uifiles) > 0 {
-		log.Println("Gtk .ui files found. Need to take care to have them loaded from a relative rather than absolute path")
-		log.Println("TODO: Check if they are at hardcoded absolute paths in the application and if yes, patch")
-		var dirswithUiFiles []string
-		for _, uifile := range uifiles {
-			dirswithUiFiles = helpers.AppendIfMissing(dirswithUiFiles, filepath.Dir(uifile))
-			err := PatchFile(appdir.MainExecutable, "/usr", "././")
-			if err != nil {
-				helpers.PrintError("PatchFile", err)
-				os.Exit(1)
-			}
-		}
-		log.Println("Directories with .ui files:", dirswithUiFiles)
+// copyIntoAppDir copies the file at src to dst in appdirFS, as a reflink where possible
+func copyIntoAppDir(src string, dst string) error {
+	if appdirFS == fsys.OS {
+		return helpers.CopyFile(src, dst)
 	}
+	return fsys.CopyFile(appdirFS, src, dst)
 }
 
-// appendLib appends library in path to allELFs and adds its location as well as any pre-existing rpaths to libraryLocations
-func appendLib(path string) {
+// appendLib appends library in path to ctx.elfs and adds its location as well as any pre-existing rpaths to libraryLocations
+func appendLib(ctx *deployContext, path string) {
+
+	if isExcludedByProfile(path) == true {
+		return
+	}
 
 	for _, excludedlib := range ExcludedLibraries {
 		if filepath.Base(path) == excludedlib && !options.standalone {
@@ -799,6 +908,14 @@ func appendLib(path string) {
 		}
 	}
 
+	if info, err := classifyELF(ctx, path); err == nil && info.Kind.Static() {
+		if helpers.SliceContains(ctx.elfs, path) == false {
+			log.Println(path, "is statically linked, hence it needs no libraries")
+			ctx.elfs = append(ctx.elfs, path)
+		}
+		return
+	}
+
 	// Find out whether there are pre-existing rpaths and if so, add them to libraryLocations
 	// so that we can find libraries there, too
 	// See if the library had a pre-existing rpath that did not start with $. If so, replace it by one that
@@ -806,24 +923,24 @@ func appendLib(path string) {
 	rpaths, err := readRpaths(path)
 	if err != nil {
 		helpers.PrintError("Could not determine rpath in "+path, err)
-		os.Exit(1)
+		os.Exit(exitPatchFailure)
 	}
 
 	for _, rpath := range rpaths {
 		rpath = filepath.Clean(strings.Replace(rpath, "$ORIGIN", filepath.Dir(path), -1))
-		if helpers.SliceContains(libraryLocations, rpath) == false && rpath != "" {
+		if helpers.SliceContains(ctx.resolver.Locations(), rpath) == false && rpath != "" {
 			log.Println("Add", rpath, "to the libraryLocations directories we search for libraries")
-			libraryLocations = helpers.AppendIfMissing(libraryLocations, filepath.Clean(rpath))
+			addLibraryLocation(ctx, rpath, "RPATH/RUNPATH of "+path)
 		}
 	}
 
-	libraryLocations = helpers.AppendIfMissing(libraryLocations, filepath.Clean(filepath.Dir(path)))
+	addLibraryLocation(ctx, filepath.Dir(path), "directory of "+path)
 
-	allELFs = helpers.AppendIfMissing(allELFs, path)
+	ctx.elfs = helpers.AppendIfMissing(ctx.elfs, path)
 }
 
-func determineELFsInDirTree(appdir helpers.AppDir, pathToDirTreeToBeDeployed string) {
-	allelfs, err := findAllExecutablesAndLibraries(pathToDirTreeToBeDeployed)
+func determineELFsInDirTree(ctx *deployContext, pathToDirTreeToBeDeployed string) {
+	allelfs, err := findAllExecutablesAndLibraries(ctx, pathToDirTreeToBeDeployed)
 	if err != nil {
 		helpers.PrintError("findAllExecutablesAndLibraries", err)
 	}
@@ -831,7 +948,7 @@ func determineELFsInDirTree(appdir helpers.AppDir, pathToDirTreeToBeDeployed str
 	// Find the libraries determined by our ldd replacement and add them to
 	// allELFsUnderPath if they are not there yet
 	for _, lib := range allelfs {
-		appendLib(lib)
+		appendLib(ctx, lib)
 	}
 
 	var allELFsUnderPath []ELF
@@ -839,113 +956,118 @@ func determineELFsInDirTree(appdir helpers.AppDir, pathToDirTreeToBeDeployed str
 		elfobj := ELF{}
 		elfobj.path = elfpath
 		allELFsUnderPath = append(allELFsUnderPath, elfobj)
-		err = getDeps(elfpath)
+		err = getDeps(ctx, elfpath)
 		if err != nil {
 			helpers.PrintError("getDeps", err)
-			os.Exit(1)
+			os.Exit(exitUnresolvedDependencies)
 		}
 	}
 	log.Println("len(allELFsUnderPath):", len(allELFsUnderPath))
 
 	// Find out in which directories we now actually have libraries
-	log.Println("libraryLocations:", libraryLocations)
-	log.Println("len(allELFs):", len(allELFs))
+	log.Println("libraryLocations:", ctx.resolver.Locations())
+	log.Println("len(allELFs):", len(ctx.elfs))
 }
 
 func readRpaths(path string) ([]string, error) {
-	// Call patchelf to find out whether the ELF already has an rpath set
-	cmd := exec.Command("patchelf", "--print-rpath", path)
-	// log.Println("patchelf cmd.Args:", cmd.Args)
-	out, err := cmd.CombinedOutput()
+	// Find out whether the ELF already has an rpath set
+	rpathStringInELF, err := helpers.ReadElfRpathFS(appdirFS, path)
 	if err != nil {
-		fmt.Println(cmd.String())
-		helpers.PrintError("patchelf --print-rpath "+path+": "+string(out), err)
+		helpers.PrintError("Could not read the rpath of "+path, err)
 		log.Println("Perhaps it is not dynamically linked, or perhaps it is a script. Continuing...")
-		// os.Exit(1)
 		return []string{}, nil
 	}
-	rpathStringInELF := strings.TrimSpace(string(out))
 	if rpathStringInELF == "" {
-		return []string{}, err
+		return []string{}, nil
 	}
 	rpaths := strings.Split(rpathStringInELF, ":")
 	// log.Println("Determined", len(rpaths), "rpaths:", rpaths)
-	return rpaths, err
+	return rpaths, nil
 }
 
 // findAllExecutablesAndLibraries returns all ELF libraries and executables
-// found in directory, and error
-func findAllExecutablesAndLibraries(path string) ([]string, error) {
+// found in directory, and error. Files are recognized by their ELF header, see isELF,
+// regardless of their names
+func findAllExecutablesAndLibraries(ctx *deployContext, path string) ([]string, error) {
 	var allExecutablesAndLibraries []string
 
-	// fmt.Println(" findAllExecutablesAndLibrarieschecking", path)
-
 	// If we have a file, then there is nothing to walk and we can return it directly
 	if helpers.IsDirectory(path) != true {
 		allExecutablesAndLibraries = append(allExecutablesAndLibraries, path)
 		return allExecutablesAndLibraries, nil
 	}
 
-	filepath.Walk(path, func(path string, info os.FileInfo, e error) error {
+	// Each path is visited only once, hence there is no need to check for duplicates
+	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, e error) error {
 		if e != nil {
-			return e
+			// Skip what cannot be read rather than giving up on the whole tree
+			return nil
 		}
-
-		// Add ELF files
-		if info.Mode().IsRegular() {
-			f, err := os.Open(path)
-			defer f.Close()
-			if err == nil {
-				if helpers.CheckMagicAtOffset(f, "454c46", 1) == true {
-					allExecutablesAndLibraries = helpers.AppendIfMissing(allExecutablesAndLibraries, path)
-				}
+		if ctx.ignored.matches(path, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
 			}
+			return nil
+		}
+		if d.Type().IsRegular() && mayBeELF(path, d) && isELF(path) {
+			allExecutablesAndLibraries = append(allExecutablesAndLibraries, path)
 		}
-
 		return nil
 	})
-	return allExecutablesAndLibraries, nil
+	return allExecutablesAndLibraries, err
 }
 
-func getDeps(binaryOrLib string) error {
-	var libs []string
+// Suffixes of files that are never ELFs and that are plentiful in large AppDirs,
+// hence not opened by findAllExecutablesAndLibraries
+var nonELFSuffixes = []string{".png", ".svg", ".svgz", ".jpg", ".xpm", ".ico", ".mo", ".qm", ".qml", ".js",
+	".py", ".pyc", ".pl", ".pm", ".rb", ".h", ".hpp", ".txt", ".html", ".css", ".json", ".xml", ".desktop",
+	".ttf", ".otf", ".gz", ".xz", ".zip", ".jar", ".dll", ".pak", ".wav", ".ogg", ".mp3"}
 
-	if helpers.Exists(binaryOrLib) == false {
-		return errors.New("binary does not exist: " + binaryOrLib)
+// Size of the smallest possible ELF header (32-bit)
+const minELFSize = 52
+
+// mayBeELF returns false if the file at path cannot be an ELF judging by its name and size
+func mayBeELF(path string, d fs.DirEntry) bool {
+	for _, suffix := range nonELFSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return false
+		}
 	}
+	info, err := d.Info()
+	return err == nil && info.Size() >= minELFSize
+}
 
-	e, err := elf.Open(binaryOrLib)
-	// log.Println("getDeps", binaryOrLib)
-	helpers.PrintError("elf.Open", err)
+// isELF returns true if the file at path is an ELF executable or shared library, judging by its ELF header
+// rather than by its name. Object files and core dumps are not, since there is nothing to deploy for them
+func isELF(path string) bool {
+	return elfdeps.IsLoadable(appdirFS, path)
+}
 
-	// ImportedLibraries returns the names of all libraries
-	// referred to by the binary f that are expected to be
-	// linked with the binary at dynamic link time.
-	libs, err = e.ImportedLibraries()
-	helpers.PrintError("e.ImportedLibraries", err)
+// newDependencyWalker returns the walker for ctx, which adds every ELF it walks with appendLib
+func newDependencyWalker(ctx *deployContext) *elfdeps.Walker {
+	walker := elfdeps.NewWalker(ctx.resolver)
+	walker.FS = appdirFS
+	walker.OnELF = func(path string) {
+		appendLib(ctx, path)
+	}
+	walker.OnError = func(path string, err error) {
+		helpers.PrintError("getDeps "+path, err)
+	}
+	return walker
+}
 
-	for _, lib := range libs {
-		s, err := findLibrary(lib)
-		if err != nil {
-			return err
-		}
-		if helpers.SliceContains(allELFs, s) == true {
-			continue
-		} else {
-			libPath, err := findLibrary(lib)
-			helpers.PrintError("findLibrary", err)
-			appendLib(libPath)
-			err = getDeps(libPath)
-			helpers.PrintError("findLibrary", err)
-		}
+// getDeps adds binaryOrLib and all libraries it needs to the dependency graph and to ctx.elfs
+func getDeps(ctx *deployContext, binaryOrLib string) error {
+	if fsys.Exists(appdirFS, binaryOrLib) == false {
+		return errors.New("binary does not exist: " + binaryOrLib)
 	}
-	return nil
+	return ctx.walker.Walk(binaryOrLib)
 }
 
-func findWithPrefixInLibraryLocations(prefix string) ([]string, error) {
+func findWithPrefixInLibraryLocations(ctx *deployContext, prefix string) ([]string, error) {
 	var found []string
 	// Try to find the file or directory in one of those locations
-	for _, libraryLocation := range libraryLocations {
+	for _, libraryLocation := range ctx.resolver.Locations() {
 		found = helpers.FilesWithPrefixInDirectory(libraryLocation, prefix)
 		if len(found) > 0 {
 			return found, nil
@@ -954,81 +1076,20 @@ func findWithPrefixInLibraryLocations(prefix string) ([]string, error) {
 	return found, errors.New("did not find " + prefix)
 }
 
-// getDirsFromSoConf returns a []string with the directories specified
-// in the ld config file at path, usually '/etc/ld.so.conf',
-// and in its included config files. We need to search in those locations
-// for libraries as well
-func getDirsFromSoConf(path string) []string {
-	var out []string
-	f, err := os.Open(path)
+// findLibrary returns the path of the library with the given name in the libraryLocations
+func findLibrary(ctx *deployContext, filename string) (string, error) {
+	path, rule, err := ctx.resolver.Resolve(filename, "")
 	if err != nil {
-		return nil
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
-			continue
-		} else if strings.HasPrefix(line, "include ") {
-			p := strings.Split(line, " ")[1]
-			files, err := filepath.Glob(p)
-			if err != nil {
-				return out
-			}
-			for _, file := range files {
-				out = append(out, getDirsFromSoConf(file)...)
-			}
-			continue
-		}
-		out = append(out, strings.TrimSpace(line))
+		return "", err
 	}
-	return out
+	ctx.walker.Graph.ResolvedBy[path] = rule
+	return path, nil
 }
 
-func findLibrary(filename string) (string, error) {
-
-	// Look for libraries in commonly used default locations
-	locs := []string{"/usr/lib64", "/lib64", "/usr/lib", "/lib",
-		"/usr/lib/x86_64-linux-gnu/libfakeroot",
-		"/usr/local/lib",
-		"/usr/local/lib/x86_64-linux-gnu",
-		"/lib/x86_64-linux-gnu",
-		"/usr/lib/x86_64-linux-gnu",
-		"/lib32",
-		"/usr/lib32"}
-	for _, loc := range locs {
-		libraryLocations = helpers.AppendIfMissing(libraryLocations, filepath.Clean(loc))
-	}
-
-	// Additionally, look for libraries in the same locations in which glibc ld.so looks for libraries
-	if helpers.Exists("/etc/ld.so.conf") {
-		locs := getDirsFromSoConf("/etc/ld.so.conf")
-		for _, loc := range locs {
-			libraryLocations = helpers.AppendIfMissing(libraryLocations, filepath.Clean(loc))
-		}
-	}
-
-	// Also look for libraries in in LD_LIBRARY_PATH
-	ldpstr := os.Getenv("LD_LIBRARY_PATH")
-	ldps := strings.Split(ldpstr, ":")
-	for _, ldp := range ldps {
-		if ldp != "" {
-			libraryLocations = helpers.AppendIfMissing(libraryLocations, filepath.Clean(ldp))
-		}
-	}
-
-	// TODO: find ld.so.cache on the system and use the locations contained therein, too
-
-	// Somewhere else in this code we are parsing each elf for pre-existing rpath/runpath and consider those locations as well
-
-	// Try to find the library in one of those locations
-	for _, libraryLocation := range libraryLocations {
-		if helpers.Exists(libraryLocation + "/" + filename) {
-			return libraryLocation + "/" + filename, nil
-		}
-	}
-	return "", errors.New("did not find library " + filename)
+// addLibraryLocation adds location to the libraryLocations in which we search for libraries,
+// remembering the rule due to which it was added if it was not there yet
+func addLibraryLocation(ctx *deployContext, location string, rule string) {
+	ctx.resolver.AddLocation(location, rule)
 }
 
 func NewLibrary(path string) ELF {
@@ -1061,7 +1122,7 @@ func PatchFile(path string, search string, replace string) error {
 	return nil
 }
 
-func getCopyrightFile(path string) (string, error) {
+func getCopyrightFile(ctx *deployContext, path string) (string, error) {
 
 	var copyrightFile string
 
@@ -1075,7 +1136,7 @@ func getCopyrightFile(path string) (string, error) {
 
 	// Find out which package the file being deployed belongs to
 	var packageContainingTheSO string
-	pkg, ok := packagesContainingFiles[path]
+	pkg, ok := ctx.packagesContainingFiles[path]
 	if ok == true {
 		packageContainingTheSO = pkg
 	} else {
@@ -1092,7 +1153,7 @@ func getCopyrightFile(path string) (string, error) {
 	// Find out the copyright file in that package
 	// We are caching the results so that multiple packages belonging to the same package have to run dpkg-query only once
 	// So first we check whether we already know it
-	cf, ok := copyrightFiles[packageContainingTheSO]
+	cf, ok := ctx.copyrightFiles[packageContainingTheSO]
 	if ok == true {
 		return cf, nil
 	}
@@ -1106,7 +1167,7 @@ func getCopyrightFile(path string) (string, error) {
 
 	lines := strings.Split(string(output), "\n")
 	for _, line := range lines {
-		packagesContainingFiles[strings.TrimSpace(line)] = packageContainingTheSO
+		ctx.packagesContainingFiles[strings.TrimSpace(line)] = packageContainingTheSO
 		if strings.Contains(line, "usr/share/doc") && strings.Contains(line, "copyright") {
 			copyrightFile = strings.TrimSpace(line)
 		}
@@ -1115,47 +1176,48 @@ func getCopyrightFile(path string) (string, error) {
 		return copyrightFile, errors.New("could not determine the copyright file")
 	} else {
 		// log.Println("Copyright file:", copyrightFile)
-		copyrightFiles[packageContainingTheSO] = copyrightFile
+		ctx.copyrightFiles[packageContainingTheSO] = copyrightFile
 	}
 
 	return copyrightFile, nil
 }
 
 // Let's see in how many lines of code we can re-implement the guts of linuxdeployqt
-func handleQt(appdir helpers.AppDir, qtVersion int) {
+func handleQt(ctx *deployContext, qtVersion int) {
+	appdir := ctx.appdir
 
 	if qtVersion >= 5 {
 
 		// Actually the libQt5Core.so.5 contains (always?) qt_prfxpath=... which tells us the location in which 'plugins/' is located
 
-		library, err := findLibrary("libQt5Core.so.5")
+		library, err := findLibrary(ctx, "libQt5Core.so.5")
 		if err != nil {
 			helpers.PrintError("Could not find libQt5Core.so.5", err)
-			os.Exit(1)
+			os.Exit(exitUnresolvedDependencies)
 		}
 
 		f, err := os.Open(library)
 		defer f.Close()
 		if err != nil {
 			helpers.PrintError("Could not open libQt5Core.so.5", err)
-			os.Exit(1)
+			os.Exit(exitPatchFailure)
 		}
 
-		qtPrfxpath := getQtPrfxpath(f, err, qtVersion)
+		qtPrfxpath := getQtPrfxpath(ctx, f, err, qtVersion)
 
 		if qtPrfxpath == "" {
 			log.Println("Got empty qtPrfxpath, exiting")
-			os.Exit(1)
+			os.Exit(exitUnresolvedDependencies)
 		}
 
 		log.Println("Looking in", qtPrfxpath+"/plugins")
 
 		if helpers.Exists(qtPrfxpath+"/plugins/platforms/libqxcb.so") == false {
 			log.Println("Could not find 'plugins/platforms/libqxcb.so' in qtPrfxpath, exiting")
-			os.Exit(1)
+			os.Exit(exitUnresolvedDependencies)
 		}
 
-		determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/platforms/libqxcb.so")
+		determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/platforms/libqxcb.so")
 
 		// From here on, mark for deployment certain Qt components if certain conditions are true
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1250
@@ -1164,24 +1226,28 @@ func handleQt(appdir helpers.AppDir, qtVersion int) {
 		// GTK Theme, if it exists
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1244
 		wants := []string{"libqgtk2.so", "libqgtk2style.so"}
+		// Platform theme that uses XDG Desktop Portals, if available; see portal.go
+		if options.portal != portalPolicyNever {
+			wants = append(wants, qtPortalPlugin)
+		}
 		for _, want := range wants {
 			found := helpers.FilesWithSuffixInDirectoryRecursive(qtPrfxpath, want)
 			if len(found) > 0 {
-				determineELFsInDirTree(appdir, found[0])
+				determineELFsInDirTree(ctx, found[0])
 			}
 		}
 
 		// iconengines and imageformats, if Qt5Gui.so.5 is about to be deployed
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1259
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			if strings.HasSuffix(lib, "libQt5Gui.so.5") == true {
 				if helpers.Exists(qtPrfxpath + "/plugins/iconengines/") {
-					determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/iconengines/")
+					determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/iconengines/")
 				} else {
 					fmt.Println("Skipping", appdir, qtPrfxpath+"/plugins/iconengines/", "because it does not exist")
 				}
 				if helpers.Exists(qtPrfxpath + "/plugins/imageformats/") {
-					determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/imageformats/")
+					determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/imageformats/")
 				} else {
 					fmt.Println("Skipping", appdir, qtPrfxpath+"/plugins/imageformats/", "because it does not exist")
 				}
@@ -1191,13 +1257,13 @@ func handleQt(appdir helpers.AppDir, qtVersion int) {
 
 		// Platform OpenGL context, if one of several libraries is about to be deployed
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1282
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			if strings.HasSuffix(lib, "libQt5Gui.so.5") == true ||
 				strings.HasSuffix(lib, "libQt5OpenGL.so.5") == true ||
 				strings.HasSuffix(lib, "libQt5XcbQpa.so.5") == true ||
 				strings.HasSuffix(lib, "libxcb-glx.so") == true {
 				{
-					determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/xcbglintegrations/")
+					determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/xcbglintegrations/")
 					break
 				}
 			}
@@ -1205,53 +1271,53 @@ func handleQt(appdir helpers.AppDir, qtVersion int) {
 
 		// CUPS print support plugin, if libQt5PrintSupport.so.5 is about to be deployed
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1299
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			if strings.HasSuffix(lib, "libQt5PrintSupport.so.5") == true {
-				determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/printsupport/libcupsprintersupport.so")
+				determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/printsupport/libcupsprintersupport.so")
 				break
 			}
 		}
 
 		// Network bearers, if libQt5Network.so.5 is about to be deployed
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1304
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			if strings.HasSuffix(lib, "libQt5Network.so.5") == true {
-				determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/bearer/")
+				determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/bearer/")
 				break
 			}
 		}
 
 		// Sql drivers, if libQt5Sql.so.5 is about to be deployed
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1312
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			if strings.HasSuffix(lib, "libQt5Sql.so.5") == true {
-				determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/sqldrivers/")
+				determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/sqldrivers/")
 				break
 			}
 		}
 
 		// Positioning plugins, if libQt5Positioning.so.5 is about to be deployed
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1320
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			if strings.HasSuffix(lib, "libQt5Positioning.so.5") == true {
-				determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/position/")
+				determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/position/")
 				break
 			}
 		}
 
 		// Multimedia plugins, if libQt5Multimedia.so.5 is about to be deployed
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1328
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			if strings.HasSuffix(lib, "libQt5Multimedia.so.5") == true {
-				determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/mediaservice/")
-				determineELFsInDirTree(appdir, qtPrfxpath+"/plugins/audio/")
+				determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/mediaservice/")
+				determineELFsInDirTree(ctx, qtPrfxpath+"/plugins/audio/")
 				break
 			}
 		}
 
 		// WebEngine, if libQt5WebEngineCore.so.5 is about to be deployed
 		// similar to https://github.com/probonopd/linuxdeployqt/blob/42e51ea7c7a572a0aa1a21fc47d0f80032809d9d/tools/linuxdeployqt/shared.cpp#L1343
-		for _, lib := range allELFs {
+		for _, lib := range ctx.elfs {
 			if strings.HasSuffix(lib, "libQt5WebEngineCore.so.5") == true {
 				log.Println("TODO: Deploying Qt5WebEngine components...")
 				os.Exit(1)
@@ -1271,7 +1337,7 @@ func handleQt(appdir helpers.AppDir, qtVersion int) {
 							helpers.PrintError("could not create directory", err)
 							os.Exit(1)
 						}
-						err = copy.Copy(found[0], appdir.Path+"/"+found[0]) // TODO: Test. Not tested yet
+						err = helpers.CopyTree(found[0], appdir.Path+"/"+found[0]) // TODO: Test. Not tested yet
 						if err != nil {
 							helpers.PrintError("could not copy file or directory", err)
 							os.Exit(1)
@@ -1311,7 +1377,7 @@ func handleQt(appdir helpers.AppDir, qtVersion int) {
 		if err != nil {
 			fmt.Println(cmd.String())
 			helpers.PrintError("qmlscanner: "+string(out), err)
-			os.Exit(1)
+			os.Exit(exitUnresolvedDependencies)
 		}
 
 		// Parse the JSON from qmlimportscanner
@@ -1343,15 +1409,15 @@ func handleQt(appdir helpers.AppDir, qtVersion int) {
 				log.Println("qmlImport.Path:", qmlImport.Path)
 				log.Println("qmlImport.RelativePath:", qmlImport.RelativePath)
 				os.MkdirAll(filepath.Dir(path.Join(appdir.Path, qmlImport.Path)), 0755)
-				copy.Copy(qmlImport.Path, path.Join(appdir.Path, qmlImport.Path)) // FIXME: Ideally we would not copy here but only after the point where we start copying everything
+				helpers.CopyTree(qmlImport.Path, path.Join(appdir.Path, qmlImport.Path)) // FIXME: Ideally we would not copy here but only after the point where we start copying everything
 				path.Join("sss", "sss")
-				determineELFsInDirTree(appdir, path.Join(appdir.Path, qmlImport.Path))
+				determineELFsInDirTree(ctx, path.Join(appdir.Path, qmlImport.Path))
 			}
 		}
 	}
 }
 
-func getQtPrfxpath(f *os.File, err error, qtVersion int) string {
+func getQtPrfxpath(ctx *deployContext, f *os.File, err error, qtVersion int) string {
 	f.Seek(0, 0)
 	// Search from the beginning of the file
 	search := []byte("qt_prfxpath=")
@@ -1369,7 +1435,7 @@ func getQtPrfxpath(f *os.File, err error, qtVersion int) string {
 	_, err = io.ReadFull(f, buf)
 	if err != nil {
 		helpers.PrintError("Unable to read qt_prfxpath", err)
-		os.Exit(1)
+		os.Exit(exitPatchFailure)
 	}
 	qt_prfxpath := strings.TrimSpace(string(buf))
 	log.Println("qt_prfxpath:", qt_prfxpath)
@@ -1389,7 +1455,7 @@ func getQtPrfxpath(f *os.File, err error, qtVersion int) string {
 		for _, result := range results { // FIXME: Probably we should just pick the first one and go with it
 			qt_prfxpath = filepath.Dir(filepath.Dir(filepath.Dir(result)))
 			log.Println("Guessed qt_prfxpath to be", qt_prfxpath)
-			quirksModePatchQtPrfxPath = true
+			ctx.quirksModePatchQtPrfxPath = true
 		}
 	}
 
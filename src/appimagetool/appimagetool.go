@@ -1,3 +1,6 @@
+//go:build linux
+// +build linux
+
 // TODO: Use https://github.com/src-d/go-git or https://github.com/google/go-github to
 // * Get changelog history and publish it on PubSub
 
@@ -8,6 +11,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/probonopd/go-appimage/internal/clidoc"
 	"github.com/probonopd/go-appimage/internal/helpers"
 	"github.com/probonopd/go-zsyncmake/zsync"
 	"github.com/urfave/cli/v2"
@@ -26,11 +30,6 @@ import (
 // CONSTANTS
 // ============================
 
-// https://blog.kowalczyk.info/article/vEja/embedding-build-number-in-go-executable.html
-// The build script needs to set, e.g.,
-// go build -ldflags "-X main.commit=$TRAVIS_BUILD_NUMBER"
-var commit string
-
 // path to libc
 var LibcDir = "libc"
 
@@ -64,6 +63,73 @@ func checkRunningWithinDocker() bool {
 }
 
 
+// setDeployOptions sets the global options for AppDirDeploy from the flags in c
+func setDeployOptions(c *cli.Context) {
+	options = DeployOptions{
+		standalone:     c.Bool("standalone"),
+		libAppRunHooks: c.Bool("libapprun_hooks"),
+	}
+	options.force = c.Bool("force")
+	options.inPlace = c.Bool("in-place")
+	options.debugAppRun = c.Bool("debug-apprun")
+	options.compiledAppRun = c.Bool("compiled-apprun")
+	envPolicies, err := parseEnvPolicies(c.StringSlice("env-policy"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	options.envPolicies = envPolicies
+	options.missing = c.String("missing")
+	options.optional = c.StringSlice("optional")
+	if helpers.SliceContains(missingPolicies, options.missing) == false {
+		log.Fatal("Unknown policy --missing=" + options.missing + ", available policies: " + strings.Join(missingPolicies, ", "))
+	}
+	options.setuid = c.String("setuid")
+	if helpers.SliceContains(setuidPolicies, options.setuid) == false {
+		log.Fatal("Unknown policy --setuid=" + options.setuid + ", available policies: " + strings.Join(setuidPolicies, ", "))
+	}
+	options.portal = c.String("portal")
+	if helpers.SliceContains(portalPolicies, options.portal) == false {
+		log.Fatal("Unknown policy --portal=" + options.portal + ", available policies: " + strings.Join(portalPolicies, ", "))
+	}
+	options.rpath = c.String("rpath")
+	options.pruneRpaths = c.Bool("prune-rpaths")
+	if helpers.SliceContains(rpathPolicies, options.rpath) == false {
+		log.Fatal("Unknown policy --rpath=" + options.rpath + ", available policies: " + strings.Join(rpathPolicies, ", "))
+	}
+	options.profile = c.String("profile")
+	if options.profile != "" && helpers.SliceContains(getProfileNames(), options.profile) == false {
+		log.Fatal("Unknown profile " + options.profile + ", available profiles: " + strings.Join(getProfileNames(), ", "))
+	}
+	options.relocations = c.StringSlice("relocate")
+	options.gstreamerPlugins = c.StringSlice("gstreamer-plugins")
+	options.iconTheme = c.String("icon-theme")
+	if helpers.SliceContains(iconThemeModes, options.iconTheme) == false {
+		log.Fatal("Unknown --icon-theme=" + options.iconTheme + ", available: " + strings.Join(iconThemeModes, ", "))
+	}
+	options.gschemaOverrides = c.StringSlice("gschema-override")
+	setDeployersEnabled(c.StringSlice("enable-plugin"), c.StringSlice("disable-plugin"))
+	options.flags = usedFlagNames(c)
+	options.patchesDir = c.String("patches")
+	if options.patchesDir != "" && helpers.IsDirectory(options.patchesDir) == false {
+		log.Fatal("--patches " + options.patchesDir + " is not a directory")
+	}
+	options.ignore = c.StringSlice("ignore")
+	options.pins = c.StringSlice("pin")
+	options.optimize = c.String("optimize")
+	if options.optimize != "" && helpers.SliceContains(getOptimizeProfileNames(), options.optimize) == false {
+		log.Fatal("Unknown --optimize=" + options.optimize + ", available profiles: " + strings.Join(getOptimizeProfileNames(), ", "))
+	}
+	options.optimizeData = c.Bool("optimize-data")
+	options.dataDir = c.String("data-dir")
+	options.hooks, err = parseHooks(c.StringSlice("hook"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c.String("locales") != "" {
+		options.locales = strings.Split(c.String("locales"), ",")
+	}
+}
+
 // bootstrapAppImageDeploy wrapper function to deploy an AppImage
 // from Desktop file
 // 		Args: c: cli.Context
@@ -73,13 +139,27 @@ func bootstrapAppImageDeploy(c *cli.Context) error {
 		log.Println("Please supply the path to a desktop file in an FHS-like AppDir")
 		log.Println("a FHS-like structure, e.g.:")
 		log.Println(os.Args[0], "appdir/usr/share/applications/myapp.desktop")
+		log.Println("or the path to the AppDir or to the main executable in it")
 		log.Fatal("Terminated.")
 	}
-	options = DeployOptions{
-		standalone:     c.Bool("standalone"),
-		libAppRunHooks: c.Bool("libapprun_hooks"),
+	desktopFile, err := findDeployDesktopFile(c.Args().Get(0))
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitInvalidAppDir)
+	}
+	setDeployOptions(c)
+	if c.String("container") != "" {
+		err := deployInContainer(desktopFile, c.String("container"), c.String("container-engine"))
+		if err != nil {
+			helpers.PrintError("Could not deploy inside the container", err)
+			os.Exit(1)
+		}
+		return nil
+	}
+	ctx := AppDirDeploy(desktopFile)
+	if c.Bool("watch") || c.String("watch-dir") != "" {
+		watchAppDir(ctx, desktopFile, c.String("watch-dir"))
 	}
-	AppDirDeploy(c.Args().Get(0))
 	return nil
 }
 
@@ -108,7 +188,8 @@ func bootstrapValidateAppImage(c *cli.Context) error {
 
 	if err != nil {
 		// we encountered an error :(
-		log.Fatal("Could not validate the signature of", filePathToValidate)
+		log.Println("Could not validate the signature of", filePathToValidate)
+		os.Exit(exitSignatureFailure)
 	}
 
 	log.Println(filePathToValidate, "has a valid signature")
@@ -198,38 +279,43 @@ func bootstrapAppImageBuild(c *cli.Context) error {
 
 	// does the file exist? if not early-exit
 	if ! helpers.CheckIfFileOrFolderExists(fileToAppDir) {
-		log.Fatal("The specified directory does not exist")
+		log.Println("The specified directory does not exist")
+		os.Exit(exitInvalidAppDir)
 	}
 
 	// Add the location of the executable to the $PATH
 	helpers.AddHereToPath()
 
 
-	// Check for needed files on $PATH
-	tools := []string{"file", "mksquashfs", "desktop-file-validate", "uploadtool", "patchelf", "desktop-file-validate", "patchelf"} // "sh", "strings", "grep" no longer needed?; "curl" is needed for uploading only, "glib-compile-schemas" is needed in some cases only
-	// curl is needed by uploadtool; TODO: Replace uploadtool with native Go code
-	// "sh", "strings", "grep" are needed by appdirtool to parse qt_prfxpath; TODO: Replace with native Go code
-	for _, t := range tools {
-		_, err := exec.LookPath(t)
-		if err != nil {
-			log.Println("Required helper tool", t, "missing")
-			os.Exit(1)
-		}
-	}
+	// Check for needed files on $PATH. Other helper tools are only
+	// checked for by the features that need them, see requireTool
+	requireTool("mksquashfs", "creating the squashfs filesystem of the AppImage")
 
 	// Check whether we have a sufficient version of mksquashfs for -offset
 	if helpers.CheckIfSquashfsVersionSufficient("mksquashfs") == false {
-		os.Exit(1)
+		os.Exit(exitMissingTool)
 	}
 
 	// Check if is directory, then assume we want to convert an AppDir into an AppImage
 	fileToAppDir, _ = filepath.EvalSymlinks(fileToAppDir)
 	if info, err := os.Stat(fileToAppDir); err == nil && info.IsDir() {
+		options.ignore = c.StringSlice("ignore")
+		options.dataDir = c.String("data-dir")
+		options.appVersion = c.String("app-version")
+		options.provenanceNote = c.Bool("provenance-note")
+		options.noNetwork = c.Bool("no-network")
+		options.noBuildMetadata = c.Bool("no-build-metadata")
+		options.flags = usedFlagNames(c)
+		if c.Bool("optimize-data") {
+			ignored, _ := loadIgnorePatterns(fileToAppDir, options.ignore)
+			optimizeData(fileToAppDir, ignored)
+		}
 		GenerateAppImage(fileToAppDir)
 	} else {
 		// TODO: If it is a file, then check if it is an AppImage and if yes, extract it
-		log.Fatal("Supplied argument is not a directory \n" +
-			"To extract an AppImage, run it with --appimage-extract \n")
+		log.Println("Supplied argument is not a directory \n" +
+			"To extract an AppImage, run it with --appimage-extract")
+		os.Exit(exitInvalidAppDir)
 
 	}
 	return nil
@@ -269,71 +355,38 @@ func constructMQTTPayload(name string, version string, FSTime time.Time) (string
 
 // GenerateAppImage converts an AppDir into an AppImage
 func GenerateAppImage(appdir string) {
+	helpers.SetPhase("Checking the AppDir")
 	if _, err := os.Stat(appdir + "/AppRun"); os.IsNotExist(err) {
 		_, _ = os.Stderr.WriteString("AppRun is missing \n")
-		os.Exit(1)
-	}
-
-	// TODO: Append 7-digit commit sha after the build number
-
-	var version string
-	version = os.Getenv("VERSION")
-	travisBuildNumber := os.Getenv("TRAVIS_BUILD_NUMBER")
-	// On Travis use $TRAVIS_BUILD_NUMBER
-	if version == "" && travisBuildNumber != "" {
-		log.Println("NOTE: Using", travisBuildNumber, "from $TRAVIS_BUILD_NUMBER as the version")
-		log.Println("      Please set the $VERSION environment variable if this is not intended")
-		version = travisBuildNumber
-	}
-
-	githubRunNumber := os.Getenv("GITHUB_RUN_NUMBER")
-	// On GitHub Actions use $GITHUB_RUN_NUMBER
-	if version == "" && githubRunNumber != "" {
-		log.Println("NOTE: Using", githubRunNumber, "from $GITHUB_RUN_NUMBER as the version")
-		log.Println("      Please set the $VERSION environment variable if this is not intended")
-		version = githubRunNumber
+		os.Exit(exitInvalidAppDir)
 	}
 
 	gitRoot := ""
 	gitRepo, err := helpers.GetGitRepository()
 	if err != nil {
 		log.Println("Apparently not in a git repository")
+		gitRepo = nil
 	} else {
 		gitWt, err := gitRepo.Worktree()
 		if err == nil {
 			gitRoot = gitWt.Filesystem.Root()
 			log.Println("git root:", gitRoot)
-			if version == "" {
-				gitHead, err := gitRepo.Head()
-				if err != nil {
-					log.Fatal("Could not determine version automatically, " +
-						"please supply the application version as $VERSION " +
-						filepath.Base(os.Args[0]) + " ... \n")
-				} else {
-					version = gitHead.Hash().String()[:7] // This equals 'git rev-parse --short HEAD'
-					log.Println("NOTE: Using", version, "from 'git rev-parse --short HEAD' as the version")
-					log.Println("      Please set the $VERSION environment variable if this is not intended")
-				}
-			}
 		} else {
 			fmt.Println("Could not get root of git repository")
 		}
 	}
 
-	// If no version found, exit
-	if version == "" {
-		log.Fatal("Version not found, aborting. Set it with VERSION=... " + os.Args[0] + "\n")
-	}
-
 	// If no desktop file found, exit
 	n := len(helpers.FilesWithSuffixInDirectory(appdir, ".desktop"))
 	if n < 1 {
-		log.Fatal("No top-level desktop file found in " + appdir + ", aborting\n")
+		log.Println("No top-level desktop file found in " + appdir + ", aborting\n")
+		os.Exit(exitInvalidAppDir)
 	}
 
 	// If more than one desktop files found, exit
 	if n > 1 {
-		log.Fatal("Multiple top-level desktop files found in" + appdir + ", aborting\n")
+		log.Println("Multiple top-level desktop files found in" + appdir + ", aborting\n")
+		os.Exit(exitInvalidAppDir)
 	}
 
 	desktopfile := helpers.FilesWithSuffixInDirectory(appdir, ".desktop")[0]
@@ -341,15 +394,17 @@ func GenerateAppImage(appdir string) {
 	err = helpers.ValidateDesktopFile(desktopfile)
 	helpers.PrintError("ValidateDesktopFile", err)
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitInvalidAppDir)
 	}
 
+	version := determineVersion(appdir, desktopfile, gitRepo)
+
 	// Read information from .desktop file
 
 	err = helpers.CheckDesktopFile(desktopfile)
 	if err != nil {
 		helpers.PrintError("CheckDesktopFile", err)
-		os.Exit(1)
+		os.Exit(exitInvalidAppDir)
 	}
 
 	// Read "Name=" key and convert spaces into underscores
@@ -366,7 +421,7 @@ func GenerateAppImage(appdir string) {
 	iconname := val.String()
 
 	// Determine the architecture
-	// If no $ARCH variable is set check all .so that we can find to determine the architecture
+	// If no $ARCH variable is set check all ELFs that we can find to determine the architecture
 	var archs []string
 	if os.Getenv("ARCH") == "" {
 		res, err := helpers.GetElfArchitecture(appdir + "/AppRun")
@@ -378,7 +433,7 @@ func GenerateAppImage(appdir string) {
 				if err != nil {
 					helpers.PrintError("Determine architecture", err)
 					return err
-				} else if info.IsDir() == false && strings.Contains(info.Name(), ".so.") {
+				} else if info.Mode().IsRegular() && isELF(path) {
 					arch, err := helpers.GetElfArchitecture(path)
 					if err != nil {
 						// we received an error when analyzing the arch
@@ -404,7 +459,8 @@ func GenerateAppImage(appdir string) {
 	}
 
 	if len(archs) != 1 {
-		log.Fatal("Could not determine architecture automatically, please supply it as $ARCH " + filepath.Base(os.Args[0]) + " ... \n")
+		log.Println("Could not determine architecture automatically, please supply it as $ARCH " + filepath.Base(os.Args[0]) + " ... \n")
+		os.Exit(exitInvalidAppDir)
 	}
 	arch := archs[0]
 
@@ -432,8 +488,9 @@ func GenerateAppImage(appdir string) {
 	} else if helpers.CheckIfFileExists(appdir + "/usr/share/icons/hicolor/256x256/apps/" + iconname + ".png") {
 		iconfile = appdir + "/usr/share/icons/hicolor/256x256/apps/" + iconname + ".png"
 	} else {
-		log.Fatal("Could not find icon file at " + appdir + "/" + iconname + ".png" + "\n" +
+		log.Println("Could not find icon file at " + appdir + "/" + iconname + ".png" + "\n" +
 			"nor at " + appdir + "/usr/share/icons/hicolor/256x256/apps/" + iconname + ".png" + ", exiting\n")
+		os.Exit(exitInvalidAppDir)
 	}
 	log.Println("Icon file:", iconfile)
 
@@ -466,15 +523,17 @@ func GenerateAppImage(appdir string) {
 		_, err := exec.LookPath("appstreamcli")
 		if err != nil {
 			fmt.Println("Required helper tool appstreamcli missing")
-			os.Exit(1)
+			os.Exit(exitMissingTool)
 		}
 		err = helpers.ValidateAppStreamMetainfoFile(appdir)
 		if err != nil {
 			fmt.Println("In case of questions regarding the validation, please refer to https://github.com/ximion/appstream")
-			os.Exit(1)
+			os.Exit(exitInvalidAppDir)
 		}
 	}
 
+	reportAppStreamForCatalog(appdir, desktopfile, iconfile)
+
 	runtimedir := filepath.Clean(helpers.Here() + "/../share/AppImageKit/runtime/")
 	if _, err := os.Stat(runtimedir); os.IsNotExist(err) {
 		runtimedir = helpers.Here()
@@ -484,14 +543,50 @@ func GenerateAppImage(appdir string) {
 		log.Println("Cannot find " + runtimefilepath + ", exiting")
 		log.Println("It should have been bundled, but you can get it from https://github.com/AppImage/AppImageKit/releases/continuous")
 		// TODO: Download it from there?
-		os.Exit(1)
+		os.Exit(exitMissingTool)
+	}
+
+	notes := make(map[string][]byte) // Key: section
+	if options.provenanceNote {
+		if helpers.CheckIfFileExists(appdir+"/"+provenanceName) == false {
+			log.Println("WARNING:", provenanceName, "not found, deploy the AppDir first to embed it")
+		} else {
+			requireTool("objcopy", "adding the provenance note to the runtime")
+			notes[provenanceNoteSection], err = ioutil.ReadFile(appdir + "/" + provenanceName)
+			if err != nil {
+				helpers.PrintError("Could not read "+provenanceName, err)
+				os.Exit(exitPackingError)
+			}
+		}
+	}
+	if options.noBuildMetadata == false {
+		if helpers.IsCommandAvailable("objcopy") {
+			notes[buildInfoNoteSection], err = json.Marshal(newBuildInfo(appdir))
+			if err != nil {
+				helpers.PrintError("Could not encode the build metadata", err)
+				os.Exit(exitPackingError)
+			}
+		} else {
+			log.Println("WARNING: objcopy not found, not embedding the build metadata")
+		}
+	}
+	temporaryRuntime := ""
+	if len(notes) > 0 {
+		noted, err := addRuntimeNotes(runtimefilepath, notes)
+		if err != nil {
+			helpers.PrintError("Could not add the ELF notes to the runtime", err)
+			os.Exit(exitPackingError)
+		}
+		// Not deferred since GenerateAppImage may exit before returning
+		temporaryRuntime = noted
+		runtimefilepath = noted
 	}
 
 	// Find out the size of the binary runtime
 	fi, err := os.Stat(runtimefilepath)
 	if err != nil {
 		helpers.PrintError("runtime", err)
-		os.Exit(1)
+		os.Exit(exitPackingError)
 	}
 	offset := fi.Size()
 
@@ -517,27 +612,55 @@ func GenerateAppImage(appdir string) {
 	if m&(1<<2) == 0 {
 		// Other users don't have read permission, https://stackoverflow.com/a/45430141
 		log.Println("Wrong permissions on AppDir, please set it to 0755 and try again")
-		os.Exit(1)
+		os.Exit(exitInvalidAppDir)
+	}
+
+	helpers.SetPhase("Packing the AppImage")
+	// Paths listed in .appdirignore or given with --ignore are not shipped
+	ignore, err := loadIgnorePatterns(appdir, options.ignore)
+	if err != nil {
+		helpers.PrintError("Could not read "+ignoreFileName, err)
+		os.Exit(exitInvalidAppDir)
 	}
 
 	// "mksquashfs", source, destination, "-offset", offset, "-comp", "gzip", "-root-owned", "-noappend"
-	cmd := exec.Command("mksquashfs", appdir, target, "-offset", strconv.FormatInt(offset, 10), "-fstime", fstime, "-comp", "gzip", "-root-owned", "-noappend")
+	// The deployment cache and the ignore file are only needed while deploying, hence exclude them.
+	// -e takes all remaining arguments, hence it must come last
+	args := []string{appdir, target, "-offset", strconv.FormatInt(offset, 10), "-fstime", fstime, "-comp", "gzip", "-root-owned", "-noappend", "-wildcards", "-e", deployCacheFileName, ignoreFileName}
+	args = append(args, ignore.squashfsExcludes()...)
+
+	// Large data selected with --data-dir goes into a data companion instead
+	if options.dataDir != "" {
+		err = buildDataCompanion(appdir, target, runtimefilepath, offset, fstime)
+		if err != nil {
+			helpers.PrintError("Could not build the data companion", err)
+			os.Exit(exitPackingError)
+		}
+		args = append(args, squashfsLiteral(strings.Trim(filepath.Clean(options.dataDir), "/")))
+	} else {
+		// Left over from an earlier build with --data-dir
+		_ = os.Remove(appdir + "/" + dataManifestName)
+	}
+	cmd := exec.Command("mksquashfs", args...)
 	fmt.Println(cmd.String())
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		helpers.PrintError("mksquashfs", err)
 		fmt.Printf("%s", string(out))
-		os.Exit(1)
+		os.Exit(exitPackingError)
 	}
 
 	// Embed the binary runtime into the squashfs
 	fmt.Println("Embedding ELF...")
 
 	err = helpers.WriteFileIntoOtherFileAtOffset(runtimefilepath, target, 0)
+	if temporaryRuntime != "" {
+		os.Remove(temporaryRuntime)
+	}
 	if err != nil {
 		helpers.PrintError("Embedding runtime", err)
 		fmt.Printf("%s", string(out))
-		os.Exit(1)
+		os.Exit(exitPackingError)
 	}
 
 	fmt.Println("Marking the AppImage as executable...")
@@ -547,7 +670,7 @@ func GenerateAppImage(appdir string) {
 	fi, err = os.Stat(target)
 	if err != nil {
 		helpers.PrintError("Could not get size of AppImage", err)
-		os.Exit(1)
+		os.Exit(exitPackingError)
 	}
 
 	// Construct update information
@@ -615,6 +738,7 @@ func GenerateAppImage(appdir string) {
 		}
 	}
 
+	helpers.SetPhase("Signing the AppImage")
 	// declare an empty digest
 	// we will replace this digest with a sha256 signature if the appimage
 	// does not contain update information.
@@ -626,13 +750,13 @@ func GenerateAppImage(appdir string) {
 		err = helpers.ValidateUpdateInformation(updateinformation)
 		if err != nil {
 			helpers.PrintError("VerifyUpdateInformation", err)
-			os.Exit(1)
+			os.Exit(exitPackingError)
 		}
 
 		err = helpers.EmbedStringInSegment(target, ".upd_info", updateinformation)
 		if err != nil {
 			helpers.PrintError("EmbedStringInSegment", err)
-			os.Exit(1)
+			os.Exit(exitPackingError)
 		}
 	} else {
 		// Embed the SHA256 digest only for appimages which are not having
@@ -645,7 +769,7 @@ func GenerateAppImage(appdir string) {
 		err = helpers.EmbedStringInSegment(target, ".sha256_sig", digest)
 		if err != nil {
 			helpers.PrintError("EmbedStringInSegment", err)
-			os.Exit(1)
+			os.Exit(exitPackingError)
 		}
 	}
 
@@ -669,7 +793,7 @@ func GenerateAppImage(appdir string) {
 		_, ok := os.LookupEnv(helpers.EnvSuperSecret)
 		if ok != true {
 			fmt.Println("Environment variable", helpers.EnvSuperSecret, "not present, cannot sign")
-			os.Exit(1)
+			os.Exit(exitSignatureFailure)
 		}
 
 		fmt.Println("Attempting to decrypt the private key...")
@@ -677,17 +801,18 @@ func GenerateAppImage(appdir string) {
 		superSecret := os.Getenv(helpers.EnvSuperSecret)
 		if superSecret == "" {
 			fmt.Println("Could not get secure environment variable $" + helpers.EnvSuperSecret + ", exiting")
-			os.Exit(1)
+			os.Exit(exitSignatureFailure)
 		}
 		// Note: 06065064:digital envelope routines:EVP_DecryptFinal_ex:bad decrypt:evp_enc.c:539
 		// OpenSSL 1.1.0 changed from MD5 to SHA-256; they broke stuff (again). Adding '-md sha256' seems to solve it
 		// TODO: Replace OpenSSL call with native Go code
 		// https://stackoverflow.com/a/43847627
-		cmd := "openssl aes-256-cbc -pass pass:" + superSecret + " -in " + helpers.EncPrivkeyFileName + " -out " + helpers.PrivkeyFileName + " -d -a -md sha256"
-		err = helpers.RunCmdStringTransparently(cmd)
+		// Not RunCmdStringTransparently, which would split passwords that contain spaces
+		cmd := []string{"openssl", "aes-256-cbc", "-pass", "pass:" + superSecret, "-in", helpers.EncPrivkeyFileName, "-out", helpers.PrivkeyFileName, "-d", "-a", "-md", "sha256"}
+		err = helpers.RunCmdTransparently(cmd)
 		if err != nil {
 			fmt.Println("Could not decrypt the private key using the password in $" + helpers.EnvSuperSecret + ", exiting")
-			os.Exit(1)
+			os.Exit(exitSignatureFailure)
 		}
 	}
 
@@ -698,7 +823,7 @@ func GenerateAppImage(appdir string) {
 		if err != nil {
 			helpers.PrintError("SignAppImage", err)
 			_ = os.Remove(helpers.PrivkeyFileName)
-			os.Exit(1)
+			os.Exit(exitSignatureFailure)
 		}
 		_ = os.Remove(helpers.PrivkeyFileName)
 	}
@@ -711,13 +836,17 @@ func GenerateAppImage(appdir string) {
 		err = helpers.EmbedStringInSegment(target, ".sig_key", string(buf))
 		if err != nil {
 			helpers.PrintError("EmbedStringInSegment", err)
-			os.Exit(1)
+			os.Exit(exitSignatureFailure)
 		}
 	}
 
+	setCIOutput("appimage", target)
+	setCIOutput("version", version)
+
 	// No updateinformation was provided nor calculated, so the following steps make no sense.
 	// Hence we print an information message and exit.
 	if updateinformation == "" {
+		printDiagnosticsSummary()
 		fmt.Println("Almost a success")
 		fmt.Println("")
 		fmt.Println("The AppImage was created, but is lacking update information.")
@@ -737,40 +866,41 @@ func GenerateAppImage(appdir string) {
 		fi, err = os.Stat(target + ".zsync")
 		if err != nil {
 			helpers.PrintError("zsync file not generated", err)
-			os.Exit(1)
+			os.Exit(exitPackingError)
 		}
+		setCIOutput("zsync", target+".zsync")
 	}
 
 	// Create the payload the publishing
 	pl, _ := constructMQTTPayload(name, version, FSTime)
 	fmt.Println(pl)
 
-	// Upload and publish if we know this is a Travis CI build
-	// https://github.com/probonopd/uploadtool says
-	// Note that UPLOADTOOL* variables will be used in bash script to form a JSON request,
-	// that means some characters like double quotes and new lines need to be escaped
-	// TODO: Instead of trying to somehow force this into uploadtool, do it properly in Go.
-	body, err := helpers.GetCommitMessageForThisCommitOnTravis()
-	fmt.Println("Commit message for this commit:", body)
-
-	// If its a TRAVIS CI, then upload the release assets and zsync file
+	// If its a TRAVIS CI, then upload the release assets and zsync file like uploadtool does,
+	// see the publish subcommand for other CI systems
 	if os.Getenv("TRAVIS_REPO_SLUG") != "" {
-		cmd := exec.Command("uploadtool", target, target+".zsync")
-		fmt.Println(cmd.String())
-		out, err := cmd.CombinedOutput()
-		fmt.Printf("%s", string(out))
-		if err != nil {
-			helpers.PrintError("uploadtool", err)
-			os.Exit(1)
+		release, err := publishTargetFromEnvironment()
+		if err == nil && os.Getenv("GITHUB_TOKEN") == "" {
+			err = errors.New("$GITHUB_TOKEN is missing")
 		}
+		if err != nil {
+			log.Println("Not publishing because", err)
+		} else {
+			url, err := publishToGitHub(newGitHubClient(os.Getenv("GITHUB_TOKEN")), release, filesToPublish([]string{target}))
+			if err != nil {
+				helpers.PrintError("Publish to GitHub", err)
+				os.Exit(1)
+			}
+			log.Println("Published to", url)
 
-		// If upload succeeded, publish MQTT message
-		// TODO: Message AppImageHub instead, which in turn messages the clients
+			// If upload succeeded, publish MQTT message
+			// TODO: Message AppImageHub instead, which in turn messages the clients
 
-		helpers.PublishMQTTMessage(updateinformation, pl)
+			helpers.PublishMQTTMessage(updateinformation, pl)
+		}
 	}
 
 	// everything went well.
+	printDiagnosticsSummary()
 	fmt.Println("Success")
 	fmt.Println("")
 	fmt.Println("Please consider submitting your AppImage to AppImageHub, the crowd-sourced")
@@ -784,17 +914,6 @@ func GenerateAppImage(appdir string) {
 // which should be triggered when the subcommand is used
 func main() {
 
-	var version string
-
-	// Derive the commit message from -X main.commit=$YOUR_VALUE_HERE
-	// if the build does not have the commit variable set externally,
-	// fall back to unsupported custom build
-	if commit != "" {
-		version = commit
-	} else {
-		version = "unsupported custom build"
-	}
-
 	// let the user know that we are running within a docker container
 	checkRunningWithinDocker()
 
@@ -805,14 +924,15 @@ func main() {
 	app := &cli.App{
 		Name:                   "appimagetool",
 		Authors: 				[]*cli.Author{{Name: "AppImage Project"}},
-		Version:                version,
+		Version:                appimagetoolVersion(),
 		Usage:            		"An automatic tool to create AppImages",
-		EnableBashCompletion:   false,
+		EnableBashCompletion:   true,
 		HideHelp:               false,
 		HideVersion:            false,
 		Compiled:               time.Time{},
 		Copyright:              "MIT License",
 		Action: 				bootstrapAppImageBuild,
+		Before: 				enableCIMode,
 
 	}
 
@@ -820,9 +940,14 @@ func main() {
 	app.Commands = []*cli.Command{
 		{
 			Name:   "deploy",
-			Usage:  "Turns PREFIX directory into AppDir by deploying dependencies and AppRun file",
+			Usage:  "Turns PREFIX directory into AppDir by deploying dependencies and AppRun file (give a desktop file, the AppDir or its main executable)",
 			Action: bootstrapAppImageDeploy,
 		},
+		{
+			Name:   "clean",
+			Usage:  "Revert the deployment of an AppDir: remove the files deploy added and restore the original rpaths, using its deployment manifest",
+			Action: bootstrapClean,
+		},
 		{
 			Name:   "validate",
 			Usage:  "Calculate the sha256 digest and check whether the signature is valid",
@@ -833,12 +958,67 @@ func main() {
 			Usage:  "Prepare a git repository that is used with Travis CI for signing AppImages",
 			Action: bootstrapSetupSigning,
 		},
+		{
+			Name:   "why",
+			Usage:  "Explain through which dependencies and search rules a library was bundled into an AppDir",
+			Action: bootstrapWhy,
+		},
+		{
+			Name:   "init",
+			Usage:  "Create an AppDir from an executable, with a desktop file and icons",
+			Flags:  initFlags,
+			Action: bootstrapInit,
+		},
+		{
+			Name:   "convert",
+			Usage:  "Convert an installed Flatpak or a snap into an AppDir and deploy it",
+			Flags:  convertFlags,
+			Action: bootstrapConvert,
+		},
+		{
+			Name:   "packages",
+			Usage:  "Create an AppDir from deb or rpm packages downloaded with their dependencies, and deploy it",
+			Flags:  packagesFlags,
+			Action: bootstrapPackages,
+		},
+		{
+			Name:   "recipe",
+			Usage:  "Build the AppImage described by a pkg2appimage recipe",
+			Flags:  recipeFlags,
+			Action: bootstrapRecipe,
+		},
+		{
+			Name:   "sign",
+			Usage:  "Sign an existing AppImage in place with " + helpers.PrivkeyFileName + " and embed " + helpers.PubkeyFileName,
+			Action: bootstrapSign,
+		},
+		{
+			Name:   "publish",
+			Usage:  "Upload AppImages with their .zsync files and signatures to a GitHub release, the continuous pre-release for builds of branches",
+			Flags:  publishFlags,
+			Action: bootstrapPublish,
+		},
+		{
+			Name:   "diff",
+			Usage:  "Write the binary delta from an old to a new AppImage, e.g., for mirrors",
+			Flags:  diffFlags,
+			Action: bootstrapDiff,
+		},
+		{
+			Name:   "applydelta",
+			Usage:  "Make the new AppImage from the old one and a delta written by diff, or the URL of the new one's .zsync file",
+			Action: bootstrapApplyDelta,
+		},
 		{
 			Name: 	"sections",
 			Usage: 	"",
 			Action:	bootstrapAppImageSections,
 		},
 	}
+	// 'info', 'extract', 'verify', ..., which are also available on other systems
+	app.Commands = append(app.Commands, inspectionCommands()...)
+	// 'completion' and 'man', generated from the definitions above
+	app.Commands = append(app.Commands, clidoc.Commands()...)
 
 	// define flags, such as --libapprun_hooks, --standalone here ...
 	app.Flags = []cli.Flag{
@@ -857,6 +1037,156 @@ func main() {
 			Aliases: []string{"s"},
 			Usage: "Make standalone self-contained bundle",
 		},
+		&cli.StringFlag{
+			Name: "profile",
+			Usage: "Apply a preset for a certain kind of application (game, wine)",
+		},
+		&cli.StringSliceFlag{
+			Name: "relocate",
+			Usage: "Replace a hardcoded absolute path by one of the same length, e.g., /usr/share/foo=././/share/foo",
+		},
+		&cli.BoolFlag{
+			Name: "watch",
+			Usage: "After deploying, watch the AppDir and redeploy the dependencies of ELFs that change",
+		},
+		&cli.StringFlag{
+			Name: "watch-dir",
+			Usage: "Like --watch, but watch a build output directory laid out like usr/ and copy changes into the AppDir",
+		},
+		&cli.BoolFlag{
+			Name: "force",
+			Usage: "Copy and patch all ELFs even if they are unchanged since the last deployment",
+		},
+		&cli.BoolFlag{
+			Name: "in-place",
+			Usage: "Copy and patch directly in the AppDir rather than in a copy that replaces it when the deployment has succeeded; faster, but an interrupted deployment leaves the AppDir half-patched",
+		},
+		&cli.StringFlag{
+			Name: "container",
+			Usage: "Deploy inside a container made from this image (e.g., ubuntu:20.04) to get libraries from an older distribution",
+		},
+		&cli.StringFlag{
+			Name: "container-engine",
+			Usage: "Container engine to use with --container (podman or docker; default: whichever is available)",
+		},
+		&cli.StringFlag{
+			Name: "missing",
+			Value: missingPolicyFail,
+			Usage: "What to do if libraries cannot be found (fail, warn, ignore)",
+		},
+		&cli.StringSliceFlag{
+			Name: "optional",
+			Usage: "Do not fail if a library whose name starts with this cannot be found, e.g., libjack.so",
+		},
+		&cli.StringFlag{
+			Name: "setuid",
+			Value: setuidPolicyWarn,
+			Usage: "What to do about setuid/setgid files and files with capabilities (warn, fail, no-sandbox)",
+		},
+		&cli.StringFlag{
+			Name: "portal",
+			Value: portalPolicyAuto,
+			Usage: "When bundled Gtk 3 and Qt use XDG Desktop Portals for file dialogs (auto: on Wayland and in sandboxes, always, never); can be overridden at runtime with $APPDIR_PORTAL_POLICY",
+		},
+		&cli.StringFlag{
+			Name: "rpath",
+			Value: rpathPolicyMinimal,
+			Usage: "Which directories the rpaths of the bundled ELFs point to (minimal: those of the libraries they need and of libraries that may be loaded with dlopen(), full: all library locations in the AppDir)",
+		},
+		&cli.BoolFlag{
+			Name: "prune-rpaths",
+			Usage: "Remove entries that point outside of the AppDir or to directories that do not exist from the rpaths of the deployed ELFs",
+		},
+		&cli.BoolFlag{
+			Name: "debug-apprun",
+			Usage: "Add AppRun.debug which logs how libraries are loaded and a backtrace, used if $APPIMAGE_DEBUG is set",
+		},
+		&cli.BoolFlag{
+			Name: "compiled-apprun",
+			Usage: "Use a compiled AppRun launcher rather than a shell script, unless shell code is needed",
+		},
+		&cli.StringSliceFlag{
+			Name: "env-policy",
+			Usage: "How AppRun sets an environment variable, e.g., PATH=append (prepend, append, replace, skip-if-set)",
+		},
+		&cli.StringSliceFlag{
+			Name: "gstreamer-plugins",
+			Usage: "Bundle only these GStreamer plugins, e.g., good or png (base, good, bad, ugly, libav, or plugin names)",
+		},
+		&cli.StringFlag{
+			Name: "icon-theme",
+			Value: iconThemeMinimal,
+			Usage: "How much of the Adwaita icon theme to bundle for Gtk applications (none, minimal, full)",
+		},
+		&cli.StringSliceFlag{
+			Name: "gschema-override",
+			Usage: "Add a .gschema.override file that changes the default values of bundled GSettings schemas",
+		},
+		&cli.StringSliceFlag{
+			Name: "enable-plugin",
+			Usage: "Run a deployment plugin that is disabled by default (" + strings.Join(getDeployerNames(), ", ") + ")",
+		},
+		&cli.StringSliceFlag{
+			Name: "disable-plugin",
+			Usage: "Do not run a deployment plugin, e.g., gstreamer (" + strings.Join(getDeployerNames(), ", ") + ")",
+		},
+		&cli.StringSliceFlag{
+			Name: "hook",
+			Usage: "Run a command (or Go plugin .so) in the AppDir at a stage of the deployment, e.g., after-copy=./patch.sh (after-resolve, after-copy, before-apprun)",
+		},
+		&cli.StringFlag{
+			Name: "patches",
+			Usage: "Apply the unified diffs in this directory (in the order given in its series file) to the AppDir (default: appdir-patches if it exists)",
+		},
+		&cli.StringSliceFlag{
+			Name: "ignore",
+			Usage: "Skip paths matching this glob when looking for ELFs and leave them out of the AppImage, e.g., __pycache__ or usr/share/doc (in addition to the AppDir's .appdirignore file)",
+		},
+		&cli.StringSliceFlag{
+			Name: "pin",
+			Usage: "Bundle this file for a library that is found in several places, e.g., libssl.so.3=/opt/openssl/lib/libssl.so.3 (in addition to the AppDir's .appdirpins file)",
+		},
+		&cli.StringFlag{
+			Name: "optimize",
+			Usage: "Remove content not needed at runtime from the AppDir: build (static archives, headers, pkg-config files, ...) or full (also man pages and other documentation)",
+		},
+		&cli.BoolFlag{
+			Name: "optimize-data",
+			Usage: "Recompress PNG images losslessly before packing",
+		},
+		&cli.StringFlag{
+			Name: "data-dir",
+			Usage: "Pack this directory of the AppDir, e.g., usr/share/game, into a separate data companion next to the AppImage that AppRun mounts at $APPIMAGE_DATA_DIR (give it to both deploy and build)",
+		},
+		&cli.StringFlag{
+			Name: "app-version",
+			Usage: "Version of the application for the AppImage filename and X-AppImage-Version (default: $VERSION, the AppStream metainfo, git describe --tags, the CI build number, the git commit, or X-AppImage-Version)",
+		},
+		&cli.BoolFlag{
+			Name: "provenance-note",
+			Usage: "Also embed the .appimage-provenance of the AppDir as an ELF note in the runtime of the AppImage",
+		},
+		&cli.BoolFlag{
+			Name: "no-build-metadata",
+			Usage: "Do not embed the version of appimagetool and the names of the flags and plugins used as an ELF note in the runtime of the AppImage",
+		},
+		&cli.BoolFlag{
+			Name: "no-network",
+			Usage: "Do not check whether the screenshots and icons in the AppStream metainfo can be downloaded",
+		},
+		&cli.StringFlag{
+			Name: "color",
+			Value: helpers.ColorAuto,
+			Usage: "Whether to color warnings and errors: auto (if writing to a terminal and $NO_COLOR is not set), always, or never",
+		},
+		&cli.BoolFlag{
+			Name: "ci",
+			Usage: "Run unattended in CI: never ask questions, write warnings and errors as GitHub Actions annotations, and set the outputs appimage, version and zsync in $GITHUB_OUTPUT",
+		},
+		&cli.StringFlag{
+			Name: "locales",
+			Usage: "Comma-separated list of locales (e.g., de,fr,pt_BR) to bundle translations for",
+		},
 	}
 
 	// TODO: move travis based Sections to travis.go in future
@@ -864,6 +1194,9 @@ func main() {
 		log.Fatal("$TRAVIS_TEST_RESULT is 1, exiting...")
 	}
 
+	// Collect warnings and errors for the summary at the end
+	helpers.CollectDiagnostics()
+
 	errRuntime := app.Run(os.Args)
 	if errRuntime != nil {
 		log.Fatal(errRuntime)
@@ -0,0 +1,198 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Line in AppRunData after which the sections determined at deployment time are inserted
+const appRunSectionsMarker = "# Additional sections determined at deployment time are inserted here\n"
+
+// Placeholders in AppRunData for paths that are determined at deployment time,
+// so that AppRun does not need to search the AppDir each time it is launched
+const (
+	appRunMainExecutablePlaceholder      = "@MAIN_EXECUTABLE@"
+	appRunLdLinuxPlaceholder             = "@LD_LINUX@"
+	appRunGdkPixbufModuleDirPlaceholder  = "@GDK_PIXBUF_MODULEDIR@"
+	appRunGdkPixbufModuleFilePlaceholder = "@GDK_PIXBUF_MODULE_FILE@"
+)
+
+// Line in AppRunData after which the policies for environment variables selected with --env-policy are inserted
+const appRunPoliciesMarker = "# Policies for environment variables determined at deployment time are inserted here\n"
+
+// Policies for how AppRun sets an environment variable that may already be set on the system.
+// They can be selected at deployment time with --env-policy NAME=POLICY, and at runtime
+// by setting APPDIR_<NAME>_POLICY, e.g., APPDIR_PATH_POLICY=append
+const (
+	envPolicyPrepend   = "prepend"     // Bundled value first, then the one from the system
+	envPolicyAppend    = "append"      // Value from the system first, then the bundled one
+	envPolicyReplace   = "replace"     // Only the bundled value
+	envPolicySkipIfSet = "skip-if-set" // Only the value from the system if there is one
+)
+
+var envPolicies = []string{envPolicyPrepend, envPolicyAppend, envPolicyReplace, envPolicySkipIfSet}
+
+// parseEnvPolicies parses the NAME=POLICY arguments given with --env-policy
+func parseEnvPolicies(args []string) (map[string]string, error) {
+	policies := make(map[string]string)
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || helpers.SliceContains(envPolicies, parts[1]) == false {
+			return nil, errors.New("invalid --env-policy " + arg + ", expected NAME=POLICY with POLICY being one of " + strings.Join(envPolicies, ", "))
+		}
+		policies[parts[0]] = parts[1]
+	}
+	return policies, nil
+}
+
+// addAppRunSection adds a section with a title and shell code to AppRun.
+// Sections are written in the order in which they are added, before the
+// part of AppRun that launches the main executable
+func addAppRunSection(ctx *deployContext, title string, code string) {
+	section := formatAppRunSection(title, code)
+	for _, s := range ctx.appRunSections {
+		if s == section {
+			return
+		}
+	}
+	ctx.appRunSections = append(ctx.appRunSections, section)
+}
+
+// formatAppRunSection returns a section with a title and shell code for AppRun
+func formatAppRunSection(title string, code string) string {
+	return "############################################################################################\n" +
+		"# " + title + "\n" +
+		"############################################################################################\n\n" +
+		strings.TrimSpace(code) + "\n\n"
+}
+
+// generateAppRun returns the contents of AppRun for the AppDir including
+// the sections that were added using addAppRunSection
+func generateAppRun(ctx *deployContext) string {
+	appdir := ctx.appdir
+	var names []string
+	for name := range options.envPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	policies := ""
+	for _, name := range names {
+		// Only a default, can still be overridden at runtime
+		policies = policies + `: "${APPDIR_` + name + `_POLICY:=` + options.envPolicies[name] + "}\"\n"
+	}
+	gdkPixbufModuleDir := ""
+	gdkPixbufModuleFile := findFirstInAppDir(appdir, "loaders.cache")
+	if gdkPixbufModuleFile != "" {
+		gdkPixbufModuleDir = filepath.Dir(gdkPixbufModuleFile) + "/loaders"
+	}
+	appRun := strings.NewReplacer(
+		appRunMainExecutablePlaceholder, appRunPath(appdir, appdir.MainExecutable),
+		appRunLdLinuxPlaceholder, appRunPath(appdir, findFirstInAppDir(appdir, "ld-*.so.*")),
+		appRunGdkPixbufModuleDirPlaceholder, appRunPath(appdir, gdkPixbufModuleDir),
+		appRunGdkPixbufModuleFilePlaceholder, appRunPath(appdir, gdkPixbufModuleFile),
+		appRunPoliciesMarker, policies,
+	).Replace(AppRunData)
+
+	// Sections that depend on what is in the AppDir but are not needed by the compiled AppRun
+	var sections []string
+	gstCoreElements := findFirstInAppDir(appdir, "libgstcoreelements.so")
+	if gstCoreElements != "" {
+		// NOTE: May need to remove libgstvaapi.so
+		code := `apprun_export GST_PLUGIN_PATH "` + appRunPath(appdir, filepath.Dir(gstCoreElements)) + `" replace
+apprun_export GST_PLUGIN_SYSTEM_PATH "${GST_PLUGIN_PATH}" replace
+# Do not use the registry of the system, which describes other plugins
+apprun_export GST_REGISTRY "${XDG_CACHE_HOME:-${HOME}/.cache}/` + appRunQuote(getGstRegistryName(appdir)) + `" replace`
+		if gstPluginScanner := findFirstInAppDir(appdir, "gst-plugin-scanner"); gstPluginScanner != "" {
+			code = code + "\n" + `apprun_export GST_PLUGIN_SCANNER "` + appRunPath(appdir, gstPluginScanner) + `" replace`
+		}
+		sections = append(sections, formatAppRunSection("Use bundled GStreamer", code))
+	}
+	sections = append(sections, ctx.appRunSections...)
+	return strings.Replace(appRun, appRunSectionsMarker, strings.Join(sections, ""), 1)
+}
+
+// appRunPath returns the shell code for the path in the AppDir for use within double quotes in AppRun,
+// or an empty string if path is empty. path can be absolute or relative to the AppDir
+func appRunPath(appdir helpers.AppDir, path string) string {
+	if path == "" {
+		return ""
+	}
+	// filepath.Rel also copes with AppDir paths that are not clean, e.g., that end in a slash
+	if filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(appdir.Path, path); err == nil {
+			path = rel
+		}
+	}
+	return "${HERE}/" + appRunQuote(path)
+}
+
+// appRunQuote escapes the characters that are special within double quotes in the shell,
+// so that names with spaces, quotes or dollar signs can be used in AppRun
+func appRunQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s)
+}
+
+// AppRunDebugData is written to AppRun.debug if --debug-apprun is used. It runs AppRun
+// with the dynamic linker explaining how it resolves libraries, and under gdb if available,
+// logging everything into a file that end users can attach to bug reports
+var AppRunDebugData = `#!/bin/sh
+
+HERE="$(dirname "$(readlink -f "${0}")")"
+
+LOG="${APPIMAGE_DEBUG_LOG:-${TMPDIR:-/tmp}/$(basename "${APPIMAGE:-${HERE}}")-$(date +%Y%m%d-%H%M%S).log}"
+echo "Writing debug information to ${LOG}, please attach it to your bug report" >&2
+
+{
+  echo "# System"
+  uname -a
+  cat /etc/os-release 2>/dev/null
+  echo "# Environment"
+  env | sort
+  echo "# Running ${HERE}/AppRun $*"
+} > "${LOG}" 2>&1
+
+export APPRUN_DEBUGGING=1 # Prevent AppRun from launching us again
+export LD_DEBUG=libs
+export LD_DEBUG_OUTPUT="${LOG}.ld-debug" # Written as ${LOG}.ld-debug.<pid>
+
+if command -v gdb >/dev/null 2>&1 ; then
+  # gdb cannot load a script, hence a script AppRun is run by the shell,
+  # and gdb follows the exec from there into the main executable
+  if [ "$(head -c 2 "${HERE}/AppRun")" = "#!" ] ; then
+    set -- /bin/sh "${HERE}/AppRun" "$@"
+  else
+    set -- "${HERE}/AppRun" "$@"
+  fi
+  gdb -q -batch -ex "set pagination off" -ex "set follow-exec-mode new" -ex run -ex "info sharedlibrary" -ex "thread apply all bt full" \
+    --args "$@" >> "${LOG}" 2>&1
+else
+  echo "gdb not found, install it to get a backtrace if the application crashes" >> "${LOG}"
+  "${HERE}/AppRun" "$@" >> "${LOG}" 2>&1
+fi
+RESULT=$?
+
+echo "# Exited with ${RESULT}" >> "${LOG}"
+echo "Debug information written to ${LOG}" >&2
+exit ${RESULT}
+`
+
+// writeDebugAppRun writes AppRun.debug into the AppDir and makes AppRun
+// launch it instead of the application if $APPIMAGE_DEBUG is set
+func writeDebugAppRun(ctx *deployContext) error {
+	appdir := ctx.appdir
+	log.Println("Adding AppRun.debug...")
+	addAppRunSection(ctx, "Run AppRun.debug if requested, e.g., APPIMAGE_DEBUG=1 ./Some.AppImage", `
+if [ ! -z "${APPIMAGE_DEBUG}" ] && [ -z "${APPRUN_DEBUGGING}" ] ; then
+  exec "${HERE}/AppRun.debug" "$@"
+fi`)
+	return ioutil.WriteFile(appdir.Path+"/AppRun.debug", []byte(AppRunDebugData), 0755)
+}
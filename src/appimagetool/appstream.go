@@ -0,0 +1,174 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"image"
+	_ "image/png" // Register the PNG format for image.DecodeConfig
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Smallest icon that catalogs like AppImageHub accept, as appstream-generator needs to make the 64x64 cached icon from it
+const catalogMinimumIconSize = 64
+
+// How long to wait for the server of a screenshot or icon
+const remoteImageTimeout = 15 * time.Second
+
+// appStreamImages is the part of an AppStream metainfo file with the images that catalogs display
+type appStreamImages struct {
+	Screenshots []struct {
+		Images []struct {
+			Type string `xml:"type,attr"`
+			URL  string `xml:",chardata"`
+		} `xml:"image"`
+	} `xml:"screenshots>screenshot"`
+	Icons []struct {
+		Type string `xml:"type,attr"`
+		Name string `xml:",chardata"`
+	} `xml:"icon"`
+}
+
+// appStreamMetainfoCandidates returns the paths at which the AppStream metainfo file
+// of the application with the given desktop file may be, in order of preference
+func appStreamMetainfoCandidates(appdir string, desktopfile string) []string {
+	id := strings.TrimSuffix(filepath.Base(desktopfile), ".desktop")
+	var files []string
+	for _, name := range []string{id + ".metainfo.xml", id + ".appdata.xml", id + ".desktop.metainfo.xml", id + ".desktop.appdata.xml"} {
+		files = append(files, appdir+"/usr/share/metainfo/"+name)
+	}
+	if others, _ := filepath.Glob(appdir + "/usr/share/metainfo/*.xml"); len(others) == 1 {
+		files = append(files, others[0])
+	}
+	return files
+}
+
+// checkAppStreamForCatalog returns the problems that keep catalogs such as AppImageHub from ingesting the application
+// described by the AppStream metainfo file: screenshots that are missing or cannot be downloaded, and an icon that is too small.
+// The URLs of the screenshots and remote icons are only requested (using HEAD) if network is true
+func checkAppStreamForCatalog(metainfoFile string, iconfile string, network bool) []string {
+	var problems []string
+	data, err := ioutil.ReadFile(metainfoFile)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	var metainfo appStreamImages
+	err = xml.Unmarshal(data, &metainfo)
+	if err != nil {
+		return append(problems, filepath.Base(metainfoFile)+": "+err.Error())
+	}
+
+	var urls []string
+	for _, screenshot := range metainfo.Screenshots {
+		for _, image := range screenshot.Images {
+			// Thumbnails are made by the catalogs
+			if image.Type == "" || image.Type == "source" {
+				urls = append(urls, strings.TrimSpace(image.URL))
+			}
+		}
+	}
+	if len(urls) == 0 {
+		problems = append(problems, "There are no screenshots in "+filepath.Base(metainfoFile)+", which catalogs require")
+	}
+	for _, icon := range metainfo.Icons {
+		if icon.Type == "remote" {
+			urls = append(urls, strings.TrimSpace(icon.Name))
+		}
+	}
+	for _, url := range urls {
+		if strings.HasPrefix(url, "https://") == false && strings.HasPrefix(url, "http://") == false {
+			problems = append(problems, url+" is not an http(s) URL")
+		} else if network {
+			err = checkRemoteImage(url)
+			if err != nil {
+				problems = append(problems, url+": "+err.Error())
+			}
+		}
+	}
+
+	size, err := pngSize(iconfile)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if size < catalogMinimumIconSize {
+		problems = append(problems, "The icon "+filepath.Base(iconfile)+" is "+strconv.Itoa(size)+"x"+strconv.Itoa(size)+
+			" pixels, but needs to be at least "+strconv.Itoa(catalogMinimumIconSize)+"x"+strconv.Itoa(catalogMinimumIconSize))
+	}
+	return problems
+}
+
+// checkRemoteImage returns an error unless the server has an image at url.
+// Servers that do not allow HEAD requests are asked for the first byte of it instead
+func checkRemoteImage(url string) error {
+	client := &http.Client{Timeout: remoteImageTimeout}
+	response, err := client.Head(url)
+	if err == nil && (response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented) {
+		request, _ := http.NewRequest("GET", url, nil)
+		request.Header.Set("Range", "bytes=0-0")
+		response, err = client.Do(request)
+		if err == nil {
+			response.Body.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 300 {
+		return errors.New("the server answered " + response.Status)
+	}
+	contentType := response.Header.Get("Content-Type")
+	if contentType != "" && strings.HasPrefix(contentType, "image/") == false {
+		return errors.New("not an image but " + contentType)
+	}
+	return nil
+}
+
+// pngSize returns the width of the square PNG image at path
+func pngSize(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	config, format, err := image.DecodeConfig(f)
+	if err != nil || format != "png" {
+		return 0, errors.New(filepath.Base(path) + " is not a valid PNG image")
+	}
+	if config.Width != config.Height {
+		return 0, errors.New("The icon " + filepath.Base(path) + " is not square but " + strconv.Itoa(config.Width) + "x" + strconv.Itoa(config.Height) + " pixels")
+	}
+	return config.Width, nil
+}
+
+// reportAppStreamForCatalog warns about the problems found by checkAppStreamForCatalog, if the AppDir has a metainfo file
+func reportAppStreamForCatalog(appdir string, desktopfile string, iconfile string) {
+	for _, file := range appStreamMetainfoCandidates(appdir, desktopfile) {
+		if helpers.CheckIfFileExists(file) == false {
+			continue
+		}
+		if options.noNetwork {
+			log.Println("Not checking the screenshots in", filepath.Base(file), "because of --no-network")
+		} else {
+			log.Println("Checking the screenshots in", filepath.Base(file)+"...")
+		}
+		problems := checkAppStreamForCatalog(file, iconfile, options.noNetwork == false)
+		for _, problem := range problems {
+			log.Println("WARNING:", problem)
+			helpers.Annotate("warning", problem)
+		}
+		if len(problems) > 0 {
+			log.Println("Catalogs such as AppImageHub may not list the AppImage until this is fixed")
+		}
+		return
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/probonopd/go-appimage/internal/elfsection"
+	"github.com/urfave/cli/v2"
+)
+
+// https://blog.kowalczyk.info/article/vEja/embedding-build-number-in-go-executable.html
+// The build script needs to set, e.g.,
+// go build -ldflags "-X main.commit=$TRAVIS_BUILD_NUMBER"
+var commit string
+
+// appimagetoolVersion returns the version of appimagetool derived from -X main.commit=$YOUR_VALUE_HERE,
+// falling back to unsupported custom build if the build does not set the commit variable externally
+func appimagetoolVersion() string {
+	if commit != "" {
+		return commit
+	}
+	return "unsupported custom build"
+}
+
+// Name of the ELF note in the runtime of the AppImage that tells how it was built, see --no-build-metadata
+const buildInfoNoteSection = ".note.appimage.buildinfo"
+
+// Version of the format of the build metadata, increased when it changes incompatibly
+const buildInfoVersion = 1
+
+// buildInfo tells maintainers which version of appimagetool built an AppImage, and how, when users send them the file.
+// It is only embedded into the AppImage and never sent anywhere. Only the names of the flags are recorded, not their
+// values, as these may contain paths or other private information
+type buildInfo struct {
+	Version     int      `json:"version"`
+	Tool        string   `json:"tool"`
+	ToolVersion string   `json:"toolVersion"`
+	Go          string   `json:"go"`
+	Flags       []string `json:"flags,omitempty"`       // Given to build the AppImage
+	DeployFlags []string `json:"deployFlags,omitempty"` // Given to deploy the AppDir, from its provenance
+	Plugins     []string `json:"plugins,omitempty"`     // That ran while deploying the AppDir, from its provenance
+}
+
+// readBuildInfo returns the build metadata embedded in the AppImage at path, or nil if it has none
+func readBuildInfo(path string) (*buildInfo, error) {
+	section, err := elfsection.Read(path, buildInfoNoteSection)
+	if err != nil {
+		return nil, nil
+	}
+	owner, desc, err := parseELFNote(section)
+	if err != nil {
+		return nil, err
+	}
+	if owner != "AppImage" {
+		return nil, errors.New("unexpected owner " + owner + " of " + buildInfoNoteSection)
+	}
+	var info buildInfo
+	err = json.Unmarshal(desc, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// parseELFNote returns the owner name and the description of an ELF note as written by buildELFNote
+func parseELFNote(note []byte) (string, []byte, error) {
+	if len(note) < 12 {
+		return "", nil, errors.New("truncated ELF note")
+	}
+	nameSize := int(binary.LittleEndian.Uint32(note[0:]))
+	descSize := int(binary.LittleEndian.Uint32(note[4:]))
+	descStart := 12 + (nameSize+3)/4*4
+	if nameSize == 0 || descStart+descSize > len(note) {
+		return "", nil, errors.New("truncated ELF note")
+	}
+	return string(note[12 : 12+nameSize-1]), note[descStart : descStart+descSize], nil
+}
+
+// usedFlagNames returns the sorted names of the flags that were given on the command line, without their values
+func usedFlagNames(c *cli.Context) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range c.FlagNames() {
+		if seen[name] == false {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,138 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Name of the file in the top-level directory of the AppDir that holds the deployment cache.
+// It is not put into the AppImage
+const deployCacheFileName = ".deploy-cache.json"
+
+// deployCache remembers what was deployed into the AppDir by a previous run,
+// so that ELFs that have not changed since then are neither copied nor patched again
+type deployCache struct {
+	Files   map[string]deployCacheEntry `json:"files"` // Key: path of the ELF as in allELFs
+	skipped int
+}
+
+// deployCacheEntry describes one ELF that was deployed into the AppDir
+type deployCacheEntry struct {
+	SourceHash   string   `json:"sourceHash,omitempty"`   // SHA-256 of the ELF on the build system, if copied from there
+	TargetHash   string   `json:"targetHash"`             // SHA-256 of the ELF in the AppDir after it was patched
+	Rpath        string   `json:"rpath"`                  // rpath that was written into the ELF in the AppDir
+	Dependencies []string `json:"dependencies,omitempty"` // Paths of the libraries it needs as they were resolved
+	Rule         string   `json:"rule,omitempty"`         // Why the library was found where it was found
+}
+
+// loadDeployCache loads the deployment cache from the AppDir. Returns an empty cache
+// if there is none, if it cannot be read, or if --force was used
+func loadDeployCache(appdir helpers.AppDir) *deployCache {
+	if options.force {
+		log.Println("Not using the deployment cache because --force was used")
+		return &deployCache{Files: make(map[string]deployCacheEntry)}
+	}
+	return readDeployCache(appdir.Path)
+}
+
+// readDeployCache reads the deployment cache from the AppDir at appdirPath.
+// Returns an empty cache if there is none or if it cannot be read
+func readDeployCache(appdirPath string) *deployCache {
+	cache := &deployCache{Files: make(map[string]deployCacheEntry)}
+	data, err := ioutil.ReadFile(appdirPath + "/" + deployCacheFileName)
+	if err != nil {
+		return cache
+	}
+	err = json.Unmarshal(data, cache)
+	if err != nil || cache.Files == nil {
+		log.Println("Ignoring unreadable deployment cache", appdirPath+"/"+deployCacheFileName)
+		cache.Files = make(map[string]deployCacheEntry)
+	}
+	return cache
+}
+
+// isCurrent returns true if the ELF at path was deployed into the AppDir by a previous run with
+// the same rpath, and neither the ELF on the build system nor the one in the AppDir have changed since
+func (cache *deployCache) isCurrent(ctx *deployContext, libraryLocationsInAppDir []string, path string) bool {
+	appdir := ctx.appdir
+	entry, ok := cache.Files[path]
+	if ok == false {
+		return false
+	}
+	target := getTargetPathInAppDir(appdir, path)
+	if entry.Rpath != computeRpath(ctx, libraryLocationsInAppDir, path) {
+		return false
+	}
+	if target != path {
+		sourceHash, err := fsys.SHA256(fsys.OS, path)
+		if err != nil || sourceHash != entry.SourceHash {
+			return false
+		}
+	}
+	targetHash, err := fsys.SHA256(fsys.OS, target)
+	if err != nil || targetHash != entry.TargetHash {
+		return false
+	}
+	cache.skipped++
+	return true
+}
+
+// update records the ELF at path as having been deployed into the AppDir
+func (cache *deployCache) update(ctx *deployContext, libraryLocationsInAppDir []string, path string) {
+	appdir := ctx.appdir
+	target := getTargetPathInAppDir(appdir, path)
+	var entry deployCacheEntry
+	var err error
+	if target != path {
+		entry.SourceHash, err = fsys.SHA256(fsys.OS, path)
+		if err != nil {
+			return
+		}
+	}
+	entry.TargetHash, err = fsys.SHA256(fsys.OS, target)
+	if err != nil {
+		// E.g., because it is on the excludelist and hence was not deployed
+		return
+	}
+	entry.Rpath = computeRpath(ctx, libraryLocationsInAppDir, path)
+	entry.Dependencies = ctx.walker.Graph.Dependencies[path]
+	entry.Rule = ctx.walker.Graph.ResolvedBy[path]
+	cache.Files[path] = entry
+}
+
+// save writes the deployment cache into the AppDir, forgetting
+// about ELFs that are no longer being deployed
+func (cache *deployCache) save(ctx *deployContext) error {
+	appdir := ctx.appdir
+	for path := range cache.Files {
+		if helpers.SliceContains(ctx.elfs, path) == false {
+			delete(cache.Files, path)
+		}
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appdir.Path+"/"+deployCacheFileName, data, 0644)
+}
+
+// getTargetPathInAppDir returns the location inside the AppDir
+// to which the ELF at path gets deployed
+func getTargetPathInAppDir(appdir helpers.AppDir, path string) string {
+	if strings.HasPrefix(path, appdir.Path) {
+		return path
+	}
+	if options.libAppRunHooks && checkWhetherPartOfLibc(path) {
+		return filepath.Clean(appdir.Path + "/" + LibcDir + "/" + path)
+	}
+	return filepath.Clean(appdir.Path + "/" + path)
+}
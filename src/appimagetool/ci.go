@@ -0,0 +1,51 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/urfave/cli/v2"
+)
+
+// ciMode is true if appimagetool runs unattended in a CI system such as GitHub Actions, see --ci.
+// It then never asks questions, annotates warnings and errors, and sets outputs of the workflow step
+var ciMode bool
+
+// enableCIMode enables the CI mode if --ci is given, and selects the colors given with --color,
+// before any subcommand runs
+//
+//	Args: c: cli.Context
+func enableCIMode(c *cli.Context) error {
+	if c.IsSet("color") {
+		err := helpers.SetColorMode(c.String("color"))
+		if err != nil {
+			return err
+		}
+	}
+	if c.Bool("ci") {
+		ciMode = true
+		helpers.EnableAnnotations()
+	}
+	return nil
+}
+
+// setCIOutput sets an output of the GitHub Actions step, e.g., the path of the AppImage,
+// so that later steps such as uploading a release can use it
+func setCIOutput(name string, value string) {
+	if ciMode == false {
+		return
+	}
+	if name == "appimage" || name == "zsync" {
+		if abs, err := filepath.Abs(value); err == nil {
+			value = abs
+		}
+	}
+	err := helpers.SetOutput(name, value)
+	if err != nil {
+		log.Println("WARNING: Could not set the output", name+":", err)
+	}
+}
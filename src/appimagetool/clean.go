@@ -0,0 +1,145 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+	"github.com/urfave/cli/v2"
+)
+
+// bootstrapClean reverts the deployment of an AppDir using its deployment manifest
+//
+//	Args: c: cli.Context
+func bootstrapClean(c *cli.Context) error {
+	if c.NArg() != 1 {
+		log.Fatal("Please specify the path to a deployed AppDir")
+	}
+	path := c.Args().Get(0)
+	manifest, err := readDeploymentManifest(path)
+	if err == nil && manifest.Version < 2 {
+		err = errors.New(deploymentManifestName + " was written by an older version of appimagetool that did not record which files it added")
+	}
+	if err != nil {
+		helpers.PrintError("clean", err)
+		os.Exit(exitInvalidAppDir)
+	}
+	removed, restored, kept, err := cleanAppDir(path, manifest)
+	if err != nil {
+		helpers.PrintError("clean", err)
+		os.Exit(exitPatchFailure)
+	}
+	fmt.Println("Removed", len(removed), "files and restored the rpaths of", len(restored), "ELFs in", path)
+	if len(kept) > 0 {
+		fmt.Println("Kept", len(kept), "files that the deployment added but that were modified since")
+	}
+	return nil
+}
+
+// cleanAppDir reverts the deployment of the AppDir at appdirPath: it restores the rpaths and interpreters of the ELFs that
+// were in the AppDir before the deployment patched them, and removes the files and symlinks the deployment added,
+// the directories that only contained them, the deployment cache and the manifest. Files added after the deployment
+// are kept, and so are the files the deployment added that were modified since, with a warning, so that no work is lost.
+// Other changes to the original files, e.g., by --relocate or --patches, are not reverted.
+// Returns the paths relative to the AppDir of the removed files, of the ELFs whose rpaths were restored,
+// and of the modified files that were kept
+func cleanAppDir(appdirPath string, manifest deploymentManifest) (removed []string, restored []string, kept []string, err error) {
+	for _, entry := range manifest.Files {
+		if entry.OriginalRpath == nil || entry.Added {
+			continue
+		}
+		path := appdirPath + "/" + entry.Path
+		if helpers.Exists(path) == false {
+			continue
+		}
+		log.Println("Restoring the rpath of", entry.Path, "to", "'"+*entry.OriginalRpath+"'")
+		err = restoreRpath(path, *entry.OriginalRpath)
+		if err != nil {
+			return removed, restored, kept, err
+		}
+		info, err := elfdeps.Classify(fsys.OS, path)
+		if err == nil && entry.OriginalInterpreter != "" && info.Interpreter != entry.OriginalInterpreter {
+			log.Println("Restoring the ELF interpreter of", entry.Path, "to", entry.OriginalInterpreter)
+			out, err := exec.Command("patchelf", "--set-interpreter", entry.OriginalInterpreter, path).CombinedOutput()
+			if err != nil {
+				return removed, restored, kept, errors.New("patchelf --set-interpreter " + path + ": " + strings.TrimSpace(string(out)) + " " + err.Error())
+			}
+		}
+		restored = append(restored, entry.Path)
+	}
+
+	var dirs []string
+	for _, entry := range manifest.Files {
+		if entry.Added == false {
+			continue
+		}
+		if modifiedSinceDeployment(appdirPath+"/"+entry.Path, entry) {
+			log.Println("WARNING: Keeping", entry.Path, "because it was modified after the deployment")
+			kept = append(kept, entry.Path)
+			continue
+		}
+		err = os.Remove(appdirPath + "/" + entry.Path)
+		if err != nil && os.IsNotExist(err) == false {
+			return removed, restored, kept, err
+		}
+		removed = append(removed, entry.Path)
+		dirs = helpers.AppendIfMissing(dirs, filepath.Dir(entry.Path))
+	}
+	// Directories that became empty, walking up as long as they are
+	for _, dir := range dirs {
+		for dir != "." && os.Remove(appdirPath+"/"+dir) == nil {
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	for _, name := range []string{deployCacheFileName, deploymentManifestName} {
+		err = os.Remove(appdirPath + "/" + name)
+		if err != nil && os.IsNotExist(err) == false {
+			return removed, restored, kept, err
+		}
+	}
+	return removed, restored, kept, nil
+}
+
+// modifiedSinceDeployment returns true if the file or symlink at path exists and differs from the manifest entry,
+// i.e., if a regular file has other contents or a symlink another target
+func modifiedSinceDeployment(path string, entry deploymentManifestEntry) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		return err == nil && target != entry.Target
+	}
+	if entry.SHA256 == "" {
+		// Was a symlink when the manifest was written
+		return true
+	}
+	hash, err := fsys.SHA256(fsys.OS, path)
+	return err == nil && hash != entry.SHA256
+}
+
+// restoreRpath sets the rpath of the ELF at path to rpath, or removes it if rpath is empty
+// since the ELF did not have one before
+func restoreRpath(path string, rpath string) error {
+	if rpath != "" {
+		return setRpath(path, rpath)
+	}
+	requireTool("patchelf", "removing the rpath of the ELF files")
+	out, err := exec.Command("patchelf", "--remove-rpath", path).CombinedOutput()
+	if err != nil {
+		return errors.New("patchelf --remove-rpath " + path + ": " + strings.TrimSpace(string(out)) + " " + err.Error())
+	}
+	return nil
+}
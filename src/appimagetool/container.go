@@ -0,0 +1,98 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Container engines that can be used with --container, in order of preference
+var containerEngines = []string{"podman", "docker"}
+
+// deployInContainer runs the deployment of the AppDir for the desktop file at desktopFilePath
+// inside a container made from image, e.g., the oldest distribution release the AppImage should
+// run on. The libraries are resolved and copied from the container into the AppDir, which is
+// mounted into the container at the same path as on the host. This way, developers on
+// rolling-release distributions can produce backward-compatible AppDirs without a VM
+func deployInContainer(desktopFilePath string, image string, engine string) error {
+	if engine == "" {
+		for _, candidate := range containerEngines {
+			if helpers.IsCommandAvailable(candidate) {
+				engine = candidate
+				break
+			}
+		}
+	}
+	if engine == "" {
+		return errors.New("neither " + strings.Join(containerEngines, " nor ") + " found on the $PATH")
+	}
+
+	absDesktopFilePath, err := filepath.Abs(desktopFilePath)
+	if err != nil {
+		return err
+	}
+	// Same logic as in helpers.NewAppDir
+	appdirPath := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(absDesktopFilePath))))
+
+	// Run ourselves inside the container; the directory we run from is mounted
+	// too so that the helper tools that come with us (e.g., patchelf) are available
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	here := filepath.Dir(self)
+
+	args := []string{"run", "--rm",
+		"-v", appdirPath + ":" + appdirPath,
+		"-v", here + ":/appimagetool:ro",
+		"-e", "PATH=/appimagetool:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	}
+	if engine == "podman" {
+		// Keep the files written into the AppDir owned by the user
+		args = append(args, "--userns=keep-id")
+	} else {
+		args = append(args, "--user", strconv.Itoa(os.Getuid())+":"+strconv.Itoa(os.Getgid()))
+	}
+	args = append(args, image, "/appimagetool/"+filepath.Base(self))
+	for _, arg := range removeContainerArgs(os.Args[1:]) {
+		// The working directory is not available inside the container
+		if arg == desktopFilePath {
+			arg = absDesktopFilePath
+		}
+		args = append(args, arg)
+	}
+
+	cmd := exec.Command(engine, args...)
+	log.Println("Deploying inside a container:", cmd.String())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// removeContainerArgs returns args without --container and --container-engine
+// and their values, so that the deployment inside the container does not
+// try to start yet another container
+func removeContainerArgs(args []string) []string {
+	var result []string
+	for i := 0; i < len(args); i++ {
+		arg := strings.TrimLeft(args[i], "-")
+		if arg == "container" || arg == "container-engine" {
+			i++ // Skip the value, too
+			continue
+		}
+		if strings.HasPrefix(arg, "container=") || strings.HasPrefix(arg, "container-engine=") {
+			continue
+		}
+		result = append(result, args[i])
+	}
+	return result
+}
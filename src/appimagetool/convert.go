@@ -0,0 +1,332 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/appdir"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// Where installed snaps are mounted, e.g., /snap/core22/current
+const snapMountDir = "/snap"
+
+// convertedApp is what was extracted from a Flatpak or a snap into an AppDir
+type convertedApp struct {
+	entry            appdir.DesktopEntry
+	icon             string            // Image to use as the icon, if it is not in the AppDir yet
+	libraryLocations []libraryLocation // Directories outside of the AppDir with the libraries of the runtime
+}
+
+// libraryLocation is a directory with libraries and the rule due to which it is searched
+type libraryLocation struct {
+	path string
+	rule string
+}
+
+// snapMeta is the part of meta/snap.yaml in a snap that is needed to convert it
+type snapMeta struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Base    string `yaml:"base"`
+	Apps    map[string]struct {
+		Command string `yaml:"command"`
+	} `yaml:"apps"`
+	// Values are either the name of an interface or a map with its attributes
+	Plugs map[string]interface{} `yaml:"plugs"`
+}
+
+// Flags of the convert subcommand
+var convertFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "sha256",
+		Usage: "SHA-256 digest that a snap given by its URL must have",
+	},
+	proxyFlag,
+}
+
+// bootstrapConvert converts an installed Flatpak or a snap into an AppDir and deploys it
+// so that it is ready to be turned into an AppImage
+//
+//	Args: c: cli.Context
+func bootstrapConvert(c *cli.Context) error {
+	if c.NArg() != 2 {
+		log.Fatal("Please specify an installed Flatpak, a snap (or its URL), or the directory of an installed snap, and the AppDir to create, e.g., " +
+			filepath.Base(os.Args[0]) + " convert org.gnome.Calculator Calculator.AppDir")
+	}
+	source := c.Args().Get(0)
+	path := c.Args().Get(1)
+	if helpers.Exists(path) {
+		log.Fatal(path + " already exists")
+	}
+
+	if strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") {
+		log.Println("Downloading", source+"...")
+		file, err := newDownloadCache(c).Fetch(source, c.String("sha256"))
+		if err != nil {
+			helpers.PrintError("Could not download "+source, err)
+			os.Exit(1)
+		}
+		source = file
+	}
+
+	var app convertedApp
+	var err error
+	if isSnap(source) {
+		app, err = extractSnap(source, path)
+	} else {
+		app, err = extractFlatpak(source, path)
+	}
+	if err == nil {
+		ad := appdir.AppDir{Path: path}
+		err = ad.CreateIconDirectories()
+		if err == nil {
+			err = ad.AddDesktopEntry(app.entry)
+		}
+		if err == nil && app.icon != "" {
+			err = ad.AddIconInAllSizes(app.entry.Icon, app.icon)
+		}
+		path = ad.DesktopFilePath
+	}
+	if err != nil {
+		helpers.PrintError("Could not convert "+source, err)
+		os.Exit(1)
+	}
+
+	setDeployOptions(c)
+	options.libraryLocations = app.libraryLocations
+	AppDirDeploy(path)
+	return nil
+}
+
+// isSnap returns true if source is a snap, which is a squashfs image,
+// or the directory of an installed snap, which has meta/snap.yaml
+func isSnap(source string) bool {
+	if helpers.IsDirectory(source) {
+		return helpers.Exists(source + "/meta/snap.yaml")
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	_, err = f.Read(magic)
+	return err == nil && string(magic) == "hsqs"
+}
+
+// extractSnap copies the files of the snap at source, or of the installed snap in the directory source,
+// into the AppDir at path and returns what else it needs. Snaps are built for being mounted at $SNAP,
+// hence it corresponds to the top-level directory of the AppDir. The libraries of the base snap
+// and of the snaps that provide content to it are searched if they are installed
+func extractSnap(source string, path string) (convertedApp, error) {
+	var app convertedApp
+	root := source
+	if helpers.IsDirectory(source) == false {
+		requireTool("unsquashfs", "extracting snaps")
+		tmp, err := ioutil.TempDir("", "snap")
+		if err != nil {
+			return app, err
+		}
+		defer os.RemoveAll(tmp)
+		root = tmp + "/root"
+		log.Println("Extracting", source+"...")
+		out, err := exec.Command("unsquashfs", "-no-progress", "-d", root, source).CombinedOutput()
+		if err != nil {
+			return app, errors.New("unsquashfs " + source + ": " + string(out) + err.Error())
+		}
+	}
+	data, err := ioutil.ReadFile(root + "/meta/snap.yaml")
+	if err != nil {
+		return app, err
+	}
+	var meta snapMeta
+	err = yaml.Unmarshal(data, &meta)
+	if err != nil {
+		return app, errors.New("meta/snap.yaml: " + err.Error())
+	}
+	log.Println("Converting the snap", meta.Name, meta.Version+"...")
+
+	desktopFiles, _ := filepath.Glob(root + "/meta/gui/*.desktop")
+	if len(desktopFiles) == 0 {
+		return app, errors.New("the snap has no desktop file in meta/gui")
+	}
+	desktopFile := desktopFiles[0]
+	for _, f := range desktopFiles {
+		if filepath.Base(f) == meta.Name+".desktop" {
+			desktopFile = f
+		}
+	}
+	app.entry, err = appdir.ReadDesktopEntry(desktopFile)
+	if err != nil {
+		return app, err
+	}
+
+	// Exec= names the app, e.g., foo or foo.bar, which is run through its command in meta/snap.yaml
+	fields := strings.Fields(app.entry.Exec)
+	if len(fields) == 0 {
+		return app, errors.New(desktopFile + " has no Exec= key")
+	}
+	name := strings.TrimPrefix(filepath.Base(fields[0]), meta.Name+".")
+	snapApp, ok := meta.Apps[name]
+	if ok == false {
+		return app, errors.New("the app " + name + " from " + filepath.Base(desktopFile) + " is not in meta/snap.yaml")
+	}
+	executable, args := findSnapExecutable(root, snapApp.Command)
+	if executable == "" {
+		return app, errors.New("could not find the executable in the command " + snapApp.Command + " of the app " + name)
+	}
+	app.entry.Exec = strings.Join(append(append([]string{filepath.Base(executable)}, args...), fields[1:]...), " ")
+
+	// The icon is usually meta/gui/icon.png, referred to as ${SNAP}/meta/gui/icon.png
+	icon := strings.Replace(strings.Replace(app.entry.Icon, "${SNAP}", root, 1), "$SNAP", root, 1)
+	if helpers.Exists(icon) {
+		app.icon = icon
+	} else if matches, _ := filepath.Glob(root + "/meta/gui/icon.*"); len(matches) > 0 {
+		app.icon = matches[0]
+	}
+	if app.icon != "" {
+		app.entry.Icon = filepath.Base(executable)
+	}
+	if meta.Version != "" {
+		if app.entry.Extra == nil {
+			app.entry.Extra = make(map[string]string)
+		}
+		app.entry.Extra["X-AppImage-Version"] = meta.Version
+	}
+
+	log.Println("Copying the files of the snap...")
+	err = helpers.CopyTreeExcept(root, path, func(src string) bool {
+		relpath, _ := filepath.Rel(root, src)
+		return relpath == "meta" || relpath == "snap" || strings.HasPrefix(relpath, "command-")
+	})
+	if err != nil {
+		return app, err
+	}
+
+	base := meta.Base
+	if base == "" {
+		base = "core"
+	}
+	providers := []string{base}
+	for _, plug := range meta.Plugs {
+		if attributes, ok := plug.(map[interface{}]interface{}); ok {
+			if provider, ok := attributes["default-provider"].(string); ok {
+				providers = helpers.AppendIfMissing(providers, strings.Split(provider, ":")[0])
+			}
+		}
+	}
+	for _, provider := range providers {
+		dir := snapMountDir + "/" + provider + "/current"
+		if helpers.IsDirectory(dir) == false {
+			log.Println("WARNING: The snap", provider, "which", meta.Name, "uses is not installed, the libraries it provides are taken from this system")
+			continue
+		}
+		for _, location := range findLibraryDirs(dir) {
+			app.libraryLocations = append(app.libraryLocations, libraryLocation{location, "snap " + provider})
+		}
+	}
+	return app, nil
+}
+
+// findSnapExecutable returns the executable that the command of a snap app runs, relative to $SNAP,
+// and the arguments following it. Launchers that set up the environment are skipped,
+// e.g., in "desktop-launch $SNAP/usr/bin/foo --bar"
+func findSnapExecutable(root string, command string) (string, []string) {
+	fields := strings.Fields(command)
+	for i, field := range fields {
+		path := strings.TrimPrefix(strings.TrimPrefix(field, "${SNAP}/"), "$SNAP/")
+		if strings.Contains(path, "command-chain") || filepath.Base(path) == "desktop-launch" {
+			continue
+		}
+		if info, err := os.Stat(root + "/" + path); err == nil && info.Mode().IsRegular() {
+			return path, fields[i+1:]
+		}
+	}
+	return "", nil
+}
+
+// extractFlatpak copies the files of the installed Flatpak ref into usr in the AppDir at path
+// and returns what else it needs. Flatpak applications are installed to /app, which corresponds to usr,
+// and their runtime is mounted at /usr, whose libraries are searched
+func extractFlatpak(ref string, path string) (convertedApp, error) {
+	var app convertedApp
+	requireTool("flatpak", "converting Flatpaks")
+	location, err := flatpakInfo("--show-location", ref)
+	if err != nil {
+		return app, err
+	}
+	// Either an ID or app/ID/ARCH/BRANCH
+	id := ref
+	if parts := strings.Split(ref, "/"); len(parts) > 1 {
+		id = parts[1]
+	}
+	log.Println("Converting the Flatpak", id+"...")
+
+	desktopFile := location + "/files/share/applications/" + id + ".desktop"
+	app.entry, err = appdir.ReadDesktopEntry(desktopFile)
+	if err != nil {
+		return app, err
+	}
+	log.Println("Copying the files of the Flatpak...")
+	err = helpers.CopyTreeExcept(location+"/files", path+"/usr", func(src string) bool {
+		return src == desktopFile
+	})
+	if err != nil {
+		return app, err
+	}
+	log.Println("WARNING: Flatpak applications are built for the prefix /app; paths to /app compiled into the application",
+		"do not work in the AppImage unless they are patched, e.g., with --relocate")
+
+	runtime, err := flatpakInfo("--show-runtime", ref)
+	if err != nil {
+		return app, err
+	}
+	runtimeLocation, err := flatpakInfo("--show-location", "runtime/"+runtime)
+	if err != nil {
+		log.Println("WARNING: The runtime", runtime, "is not installed, the libraries it provides are taken from this system")
+		return app, nil
+	}
+	for _, location := range findLibraryDirs(runtimeLocation + "/files") {
+		app.libraryLocations = append(app.libraryLocations, libraryLocation{location, "Flatpak runtime " + runtime})
+	}
+	return app, nil
+}
+
+// flatpakInfo returns what flatpak info prints for ref with the given flag
+func flatpakInfo(flag string, ref string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("flatpak", "info", flag, ref)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.New("flatpak info " + flag + " " + ref + ": " + stderr.String() + err.Error())
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// findLibraryDirs returns the directories with libraries below the root of a system image,
+// those for a multiarch triplet first
+func findLibraryDirs(root string) []string {
+	var dirs []string
+	for _, pattern := range []string{"/lib/*-linux-gnu*", "/usr/lib/*-linux-gnu*", "/lib", "/usr/lib", "/lib64", "/usr/lib64"} {
+		matches, _ := filepath.Glob(root + pattern)
+		for _, match := range matches {
+			if helpers.IsDirectory(match) {
+				dirs = helpers.AppendIfMissing(dirs, match)
+			}
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,106 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Name of the file in the top level of the AppDir that describes the data companion,
+// in a form that AppRun can source
+const dataManifestName = ".appimage-data"
+
+// Suffix appended to the name of the AppImage to get the name of its data companion
+const dataCompanionSuffix = ".data"
+
+// buildDataCompanion packs the directory selected with --data-dir into a data companion next to
+// target, the AppImage being built, and writes the manifest that AppRun uses to find and verify it.
+// The companion is made like an AppImage (runtime followed by squashfs) but has no AppRun,
+// so that AppRun can mount it with --appimage-mount without needing any tools on the target system
+func buildDataCompanion(appdir string, target string, runtimefilepath string, offset int64, fstime string) error {
+	dataDir := filepath.Join(appdir, options.dataDir)
+	if helpers.IsDirectory(dataDir) == false {
+		return errors.New("--data-dir " + options.dataDir + " is not a directory in the AppDir")
+	}
+	appRun, _ := ioutil.ReadFile(appdir + "/AppRun")
+	if strings.Contains(string(appRun), dataManifestName) == false {
+		log.Println("WARNING: AppRun does not load the data companion, please deploy with --data-dir", options.dataDir)
+	}
+
+	companion := target + dataCompanionSuffix
+	log.Println("Packing", options.dataDir, "into the data companion", companion+"...")
+	cmd := exec.Command("mksquashfs", dataDir, companion, "-offset", strconv.FormatInt(offset, 10), "-fstime", fstime, "-comp", "gzip", "-root-owned", "-noappend")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + "\n" + string(out))
+	}
+	err = helpers.WriteFileIntoOtherFileAtOffset(runtimefilepath, companion, 0)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(companion, 0755)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(companion)
+	if err != nil {
+		return err
+	}
+	hash, err := fsys.SHA256(fsys.OS, companion)
+	if err != nil {
+		return err
+	}
+	manifest := "DATA_FILE='" + strings.Replace(filepath.Base(companion), "'", `'\''`, -1) + "'\n" +
+		"DATA_SIZE=" + strconv.FormatInt(info.Size(), 10) + "\n" +
+		"DATA_SHA256=" + hash + "\n"
+	log.Println("Data companion:", formatSize(info.Size()), "with SHA-256", hash)
+	return ioutil.WriteFile(appdir+"/"+dataManifestName, []byte(manifest), 0644)
+}
+
+// addDataCompanionSection adds the part of AppRun that locates the data companion next to the AppImage,
+// checks its size and checksum (the latter only once for each version of the file, since it is slow for
+// large files), mounts it and exports its mountpoint as $APPIMAGE_DATA_DIR
+func addDataCompanionSection(ctx *deployContext) {
+	addAppRunSection(ctx, "Mount the data companion next to the AppImage at $APPIMAGE_DATA_DIR", `
+if [ -e "${HERE}/`+dataManifestName+`" ] ; then
+  . "${HERE}/`+dataManifestName+`"
+  DATA="$(dirname "${APPIMAGE:-${HERE}}")/${DATA_FILE}"
+  if [ ! -e "${DATA}" ] ; then
+    echo "${DATA_FILE} is missing, please put it next to $(basename "${APPIMAGE:-${HERE}}")" >&2
+    exit 1
+  fi
+  if [ "$(stat -c %s "${DATA}")" != "${DATA_SIZE}" ] ; then
+    echo "${DATA} is incomplete or does not belong to this version" >&2
+    exit 1
+  fi
+  DATA_STAMP="${XDG_CACHE_HOME:-${HOME}/.cache}/appimage-data/${DATA_SHA256}"
+  if [ "$(cat "${DATA_STAMP}" 2>/dev/null)" != "$(stat -c %Y "${DATA}")" ] ; then
+    if [ "$(sha256sum "${DATA}" | cut -d " " -f 1)" != "${DATA_SHA256}" ] ; then
+      echo "${DATA} is damaged, its checksum does not match" >&2
+      exit 1
+    fi
+    mkdir -p "$(dirname "${DATA_STAMP}")" && stat -c %Y "${DATA}" > "${DATA_STAMP}"
+  fi
+  DATA_FIFO="$(mktemp -u)"
+  mkfifo "${DATA_FIFO}"
+  "${DATA}" --appimage-mount > "${DATA_FIFO}" &
+  DATA_PID=$!
+  read -r APPIMAGE_DATA_DIR < "${DATA_FIFO}"
+  rm -f "${DATA_FIFO}"
+  export APPIMAGE_DATA_DIR
+  # Unmount once the application has exited; it keeps the process ID of AppRun
+  ( while kill -0 $$ 2>/dev/null ; do sleep 2 ; done ; kill "${DATA_PID}" ) &
+fi`)
+}
@@ -0,0 +1,126 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/delta"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/zsync"
+	"github.com/urfave/cli/v2"
+)
+
+var diffFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "Where to write the delta (default: the new AppImage with .delta appended)",
+	},
+}
+
+// bootstrapDiff writes the binary delta from an old to a new version of an AppImage,
+// which mirrors and users who have the old version can apply instead of downloading the new one
+//
+//	Args: c: cli.Context
+func bootstrapDiff(c *cli.Context) error {
+	if c.NArg() != 2 {
+		log.Fatal("Please specify the old and the new AppImage")
+	}
+	oldPath, newPath := c.Args().Get(0), c.Args().Get(1)
+	old, err := ioutil.ReadFile(oldPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	new, err := ioutil.ReadFile(newPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	output := c.String("output")
+	if output == "" {
+		output = newPath + ".delta"
+	}
+
+	var buf bytes.Buffer
+	stats, err := delta.Create(old, new, &buf)
+	if err != nil {
+		helpers.PrintError("Create delta", err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(output, buf.Bytes(), 0644)
+	if err != nil {
+		helpers.PrintError("Write delta", err)
+		os.Exit(1)
+	}
+	log.Printf("Wrote %s (%s, %.1f%% of %s), which takes %s from %s\n", output, formatSize(int64(buf.Len())),
+		100*float64(buf.Len())/float64(len(new)), filepath.Base(newPath), formatSize(stats.Copied), filepath.Base(oldPath))
+	fmt.Println("To make", filepath.Base(newPath), "from", filepath.Base(oldPath)+", run:")
+	fmt.Println("    appimagetool applydelta", filepath.Base(oldPath), filepath.Base(output))
+	if helpers.CheckIfFileExists(newPath + ".zsync") {
+		fmt.Println("Without the delta, the old AppImage can also seed a zsync update:")
+		fmt.Println("    appimagetool applydelta", filepath.Base(oldPath), "https://.../"+filepath.Base(newPath)+".zsync")
+	}
+	setCIOutput("delta", output)
+	return nil
+}
+
+// bootstrapApplyDelta makes the new version of an AppImage from the old one, using a delta written by the diff subcommand,
+// or using a .zsync file (given by its URL) with the old version as the seed, downloading only the blocks it lacks
+//
+//	Args: c: cli.Context
+func bootstrapApplyDelta(c *cli.Context) error {
+	if c.NArg() != 2 && c.NArg() != 3 {
+		log.Fatal("Please specify the old AppImage, the delta or the URL of a .zsync file, and optionally where to write the new AppImage")
+	}
+	oldPath, source, target := c.Args().Get(0), c.Args().Get(1), c.Args().Get(2)
+
+	if strings.HasSuffix(source, ".zsync") {
+		control, err := zsync.Fetch(source)
+		if err != nil {
+			helpers.PrintError("zsync", err)
+			os.Exit(1)
+		}
+		if target == "" {
+			target = control.TargetPath(oldPath)
+		}
+		reused, err := control.Update(oldPath, target)
+		if err != nil {
+			helpers.PrintError("zsync", err)
+			os.Exit(1)
+		}
+		log.Println("Wrote", target+", reusing", formatSize(reused), "of", filepath.Base(oldPath), "and downloading", formatSize(control.Length-reused))
+		return nil
+	}
+
+	old, err := ioutil.ReadFile(oldPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	new, err := delta.Apply(old, f)
+	if err != nil {
+		helpers.PrintError("Apply "+source, err)
+		os.Exit(1)
+	}
+	if target == "" {
+		target = filepath.Join(filepath.Dir(oldPath), strings.TrimSuffix(filepath.Base(source), ".delta"))
+	}
+	err = ioutil.WriteFile(target, new, 0755)
+	if err != nil {
+		helpers.PrintError("Write "+target, err)
+		os.Exit(1)
+	}
+	log.Println("Wrote", target)
+	return nil
+}
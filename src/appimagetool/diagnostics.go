@@ -0,0 +1,120 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// diagnosticCategory groups diagnostics with similar messages in the summary,
+// e.g., "3 warnings: 2 missing optional libraries, 1 absolute path"
+type diagnosticCategory struct {
+	pattern  *regexp.Regexp
+	singular string
+	plural   string
+}
+
+// diagnosticCategories are tried in order, the first one whose pattern matches the message wins
+var diagnosticCategories = []diagnosticCategory{
+	{regexp.MustCompile(`\(optional\) could not be found`), "missing optional library", "missing optional libraries"},
+	{regexp.MustCompile(`(?i)could not be found|not found in the repositories|needs? symbol versions`), "missing library", "missing libraries"},
+	{regexp.MustCompile(`(?i)\brpath\b`), "rpath problem", "rpath problems"},
+	{regexp.MustCompile(`(?i)absolute path|paths to /app|relative to the working directory`), "absolute path", "absolute paths"},
+	{regexp.MustCompile(`loaders\.cache|immodules\.cache|GSettings schema`), "module cache change", "module cache changes"},
+	{regexp.MustCompile(`(?i)AppStream|desktop file|icon`), "metadata problem", "metadata problems"},
+}
+
+const otherDiagnostics = "other"
+
+// categorizeDiagnostic returns the index of the category of the message in diagnosticCategories,
+// or len(diagnosticCategories) if it fits none
+func categorizeDiagnostic(message string) int {
+	for i, category := range diagnosticCategories {
+		if category.pattern.MatchString(message) {
+			return i
+		}
+	}
+	return len(diagnosticCategories)
+}
+
+// pluralize returns "1 warning" or "n warnings"
+func pluralize(n int, singular string, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return strconv.Itoa(n) + " " + plural
+}
+
+// formatDiagnosticsSummary returns one headline per level, with the number of diagnostics per category,
+// followed by the diagnostics grouped by the phase they were logged in
+func formatDiagnosticsSummary(diagnostics []helpers.Diagnostic) string {
+	if len(diagnostics) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, level := range []string{helpers.LevelError, helpers.LevelWarning, helpers.LevelNote} {
+		counts := make([]int, len(diagnosticCategories)+1)
+		total := 0
+		for _, d := range diagnostics {
+			if d.Level == level {
+				counts[categorizeDiagnostic(d.Message)]++
+				total++
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		var parts []string
+		for i, n := range counts {
+			if n == 0 {
+				continue
+			}
+			if i == len(diagnosticCategories) {
+				parts = append(parts, strconv.Itoa(n)+" "+otherDiagnostics)
+			} else {
+				parts = append(parts, pluralize(n, diagnosticCategories[i].singular, diagnosticCategories[i].plural))
+			}
+		}
+		headline := pluralize(total, strings.ToLower(level), strings.ToLower(level)+"s")
+		sb.WriteString(helpers.Colorize(os.Stdout, level, headline) + ": " + strings.Join(parts, ", ") + "\n")
+	}
+
+	var phases []string
+	byPhase := map[string][]helpers.Diagnostic{}
+	for _, d := range diagnostics {
+		if _, ok := byPhase[d.Phase]; ok == false {
+			phases = append(phases, d.Phase)
+		}
+		byPhase[d.Phase] = append(byPhase[d.Phase], d)
+	}
+	for _, phase := range phases {
+		title := phase
+		if title == "" {
+			title = "General"
+		}
+		sb.WriteString("\n" + title + ":\n")
+		for _, d := range byPhase[phase] {
+			sb.WriteString("    " + helpers.Colorize(os.Stdout, d.Level, d.Level) + " " + d.Message + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// printDiagnosticsSummary prints the errors, warnings and notes that were logged so far, so that
+// the critical ones are not buried in the log, and forgets them
+func printDiagnosticsSummary() {
+	summary := formatDiagnosticsSummary(helpers.TakeDiagnostics())
+	if summary == "" {
+		return
+	}
+	fmt.Println("")
+	fmt.Print(summary)
+	fmt.Println("")
+}
@@ -0,0 +1,335 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Location of the .NET runtime in the AppDir for framework-dependent applications
+const dotnetDir = "/usr/lib/dotnet"
+
+// Locations where the .NET runtime is installed on the build system, unless $DOTNET_ROOT is set
+var dotnetRootCandidates = []string{"/usr/share/dotnet", "/usr/lib/dotnet", "/usr/lib64/dotnet", "/opt/dotnet"}
+
+// Files without which a self-contained .NET application does not start
+var dotnetSelfContainedFiles = []string{"libhostfxr.so", "libhostpolicy.so", "libcoreclr.so", "System.Private.CoreLib.dll"}
+
+// Libraries that .NET loads with dlopen() for globalization, which hence do not show up as dependencies
+var dotnetIcuLibraries = []string{"libicuuc.so.", "libicui18n.so."}
+
+// dotnetRuntimeConfig is the part of <application>.runtimeconfig.json that we need
+type dotnetRuntimeConfig struct {
+	RuntimeOptions struct {
+		Framework          dotnetFramework   `json:"framework"`
+		Frameworks         []dotnetFramework `json:"frameworks"`
+		IncludedFrameworks []dotnetFramework `json:"includedFrameworks"`
+	} `json:"runtimeOptions"`
+}
+
+type dotnetFramework struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// dotnetDeployer bundles the .NET runtime for framework-dependent applications,
+// or checks that self-contained applications are complete
+type dotnetDeployer struct{}
+
+// Detect returns true if there is a <application>.runtimeconfig.json inside the AppDir
+func (dotnetDeployer) Detect(ctx *deployContext) bool {
+	return len(helpers.FilesWithSuffixInDirectoryRecursive(ctx.appdir.Path, ".runtimeconfig.json")) > 0
+}
+
+// Deploy bundles the runtime, the native libraries of which get patched like all other ELFs,
+// and points AppRun to it
+func (dotnetDeployer) Deploy(ctx *deployContext) error {
+	appdir := ctx.appdir
+	for _, path := range helpers.FilesWithSuffixInDirectoryRecursive(appdir.Path, ".runtimeconfig.json") {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var config dotnetRuntimeConfig
+		err = json.Unmarshal(data, &config)
+		if err != nil {
+			return errors.New("could not parse " + path + ": " + err.Error())
+		}
+
+		if len(config.RuntimeOptions.IncludedFrameworks) > 0 {
+			log.Println("Checking self-contained .NET application in", filepath.Dir(path)+"...")
+			for _, file := range dotnetSelfContainedFiles {
+				if helpers.Exists(filepath.Dir(path)+"/"+file) == false {
+					return errors.New(file + " is missing next to " + path + ", publish with --self-contained for linux-x64 (or the respective architecture)")
+				}
+			}
+			continue
+		}
+
+		frameworks := config.RuntimeOptions.Frameworks
+		if config.RuntimeOptions.Framework.Name != "" {
+			frameworks = append(frameworks, config.RuntimeOptions.Framework)
+		}
+		err = deployDotnetRuntime(ctx, frameworks)
+		if err != nil {
+			return err
+		}
+	}
+
+	deployDotnetIcu(ctx)
+	return nil
+}
+
+// deployDotnetRuntime copies the host and the frameworks from the .NET installation on
+// the build system into dotnetDir in the AppDir, and sets DOTNET_ROOT in AppRun so that
+// the application host (the executable named like the application) finds them
+func deployDotnetRuntime(ctx *deployContext, frameworks []dotnetFramework) error {
+	appdir := ctx.appdir
+	root := findDotnetRoot()
+	if root == "" {
+		return errors.New("could not find the .NET runtime, set $DOTNET_ROOT to where it is installed")
+	}
+	hostfxr := findDotnetVersion(root+"/host/fxr", "")
+	if hostfxr == "" {
+		return errors.New("could not find host/fxr in " + root)
+	}
+	dirs := []string{"host/fxr/" + hostfxr}
+	for _, framework := range frameworks {
+		version := findDotnetVersion(root+"/shared/"+framework.Name, framework.Version)
+		if version == "" {
+			return errors.New("could not find " + framework.Name + " " + framework.Version + " (or a newer patch release) in " + root)
+		}
+		dirs = append(dirs, "shared/"+framework.Name+"/"+version)
+	}
+
+	for _, dir := range dirs {
+		if helpers.Exists(appdir.Path + dotnetDir + "/" + dir) {
+			continue
+		}
+		log.Println("Bundling .NET", dir, "from", root+"...")
+		err := helpers.CopyTree(root+"/"+dir, appdir.Path+dotnetDir+"/"+dir)
+		if err != nil {
+			return err
+		}
+		determineELFsInDirTree(ctx, appdir.Path+dotnetDir+"/"+dir)
+	}
+	addAppRunSection(ctx, "Use bundled .NET runtime", `apprun_export DOTNET_ROOT "${HERE}`+dotnetDir+`" replace`)
+	return nil
+}
+
+// findDotnetRoot returns the directory in which the .NET runtime is installed on the build system
+func findDotnetRoot() string {
+	candidates := dotnetRootCandidates
+	if os.Getenv("DOTNET_ROOT") != "" {
+		candidates = append([]string{os.Getenv("DOTNET_ROOT")}, candidates...)
+	}
+	if dotnet, err := exec.LookPath("dotnet"); err == nil {
+		if resolved, err := filepath.EvalSymlinks(dotnet); err == nil {
+			candidates = append(candidates, filepath.Dir(resolved))
+		}
+	}
+	for _, candidate := range candidates {
+		if helpers.IsDirectory(candidate + "/host/fxr") {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// findDotnetVersion returns the name of the subdirectory of dir with the highest version
+// that .NET would roll forward to from minimum, i.e., the same major and minor version with
+// the highest patch release, or failing that, the same major version. If minimum is empty,
+// then the highest version is returned
+func findDotnetVersion(dir string, minimum string) string {
+	infos, _ := ioutil.ReadDir(dir)
+	var versions []string
+	for _, info := range infos {
+		if info.IsDir() && compareVersions(info.Name(), minimum) >= 0 {
+			versions = append(versions, info.Name())
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) > 0 })
+	if minimum == "" {
+		if len(versions) > 0 {
+			return versions[0]
+		}
+		return ""
+	}
+	parts := strings.Split(minimum, ".")
+	prefixes := []string{parts[0] + "."}
+	if len(parts) > 1 {
+		prefixes = append([]string{parts[0] + "." + parts[1] + "."}, prefixes...)
+	}
+	for _, prefix := range prefixes {
+		for _, version := range versions {
+			if strings.HasPrefix(version, prefix) {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+// compareVersions compares dotted version numbers such as 6.0.12, ignoring pre-release
+// suffixes, and returns a negative number, zero or a positive number like strings.Compare
+func compareVersions(a string, b string) int {
+	pa := strings.Split(strings.SplitN(a, "-", 2)[0], ".")
+	pb := strings.Split(strings.SplitN(b, "-", 2)[0], ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			return na - nb
+		}
+	}
+	return 0
+}
+
+// deployDotnetIcu bundles ICU, which .NET needs for globalization but loads with dlopen().
+// .NET finds it because the rpath of the runtime libraries points to the bundled libraries.
+// If there is no ICU on the build system, the application runs in globalization-invariant mode
+func deployDotnetIcu(ctx *deployContext) {
+	for _, prefix := range dotnetIcuLibraries {
+		locs, err := findWithPrefixInLibraryLocations(ctx, prefix)
+		if err != nil {
+			log.Println("WARNING: Could not find ICU, the .NET application will run in globalization-invariant mode")
+			addAppRunSection(ctx, "Run .NET without ICU", "apprun_export DOTNET_SYSTEM_GLOBALIZATION_INVARIANT 1 skip-if-set")
+			return
+		}
+		// The highest version, e.g., libicuuc.so.70 rather than libicuuc.so.70.1 which it points to
+		var lib string
+		for _, loc := range locs {
+			version := strings.TrimPrefix(filepath.Base(loc), prefix)
+			if strings.Contains(version, ".") == false && (lib == "" || compareVersions(version, strings.TrimPrefix(filepath.Base(lib), prefix)) > 0) {
+				lib = loc
+			}
+		}
+		if lib == "" {
+			continue
+		}
+		log.Println("Bundling", lib, "for .NET globalization...")
+		determineELFsInDirTree(ctx, lib)
+	}
+}
+
+// Location of the Mono class libraries, the global assembly cache and the configuration,
+// both on the build system and in the AppDir. Mono finds them relative to its executable
+const (
+	monoLibDir    = "/usr/lib/mono"
+	monoConfigDir = "/etc/mono"
+)
+
+// Libraries that Mono loads with dlopen() for Mono.Posix and System.Drawing
+var monoNativeLibraries = []string{"libMonoPosixHelper.so", "libgdiplus.so.0"}
+
+// monoDeployer bundles the Mono runtime and its class libraries
+type monoDeployer struct{}
+
+// Detect returns true if Mono is bundled, or if there are .exe assemblies inside the AppDir
+func (monoDeployer) Detect(ctx *deployContext) bool {
+	appdir := ctx.appdir
+	if findMonoELF(ctx) != "" {
+		return true
+	}
+	for _, exe := range helpers.FilesWithSuffixInDirectoryRecursive(appdir.Path, ".exe") {
+		if isManagedAssembly(exe) {
+			return true
+		}
+	}
+	return false
+}
+
+// Deploy bundles Mono (if it is not bundled yet), the class libraries for the .NET Framework 4.5
+// profile and the global assembly cache, and sets MONO_PATH, MONO_GAC_PREFIX and MONO_CFG_DIR in AppRun
+func (monoDeployer) Deploy(ctx *deployContext) error {
+	appdir := ctx.appdir
+	if findMonoELF(ctx) == "" {
+		mono, err := exec.LookPath("mono")
+		if err != nil {
+			return errors.New("the AppDir contains .exe assemblies but mono is not on the $PATH")
+		}
+		log.Println("Bundling", mono, "to run the .exe assemblies...")
+		determineELFsInDirTree(ctx, mono)
+	}
+
+	for _, dir := range []string{monoLibDir + "/4.5", monoLibDir + "/gac"} {
+		if helpers.IsDirectory(dir) == false || helpers.Exists(appdir.Path+dir) {
+			continue
+		}
+		log.Println("Bundling Mono class libraries in", dir+"...")
+		err := helpers.CopyTree(dir, appdir.Path+dir)
+		if err != nil {
+			return err
+		}
+		determineELFsInDirTree(ctx, appdir.Path+dir)
+	}
+	if helpers.IsDirectory(appdir.Path+monoLibDir+"/4.5") == false {
+		return errors.New("could not find the Mono class libraries in " + monoLibDir + "/4.5")
+	}
+
+	// The configuration maps DllImport names to the native libraries
+	if helpers.IsDirectory(monoConfigDir) && helpers.Exists(appdir.Path+"/usr"+monoConfigDir) == false {
+		err := helpers.CopyTree(monoConfigDir, appdir.Path+"/usr"+monoConfigDir)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range monoNativeLibraries {
+		lib, err := findLibrary(ctx, name)
+		if err != nil {
+			log.Println("Not bundling", name, "because it is not installed on the build system")
+			continue
+		}
+		determineELFsInDirTree(ctx, lib)
+	}
+
+	addAppRunSection(ctx, "Use bundled Mono", `apprun_export MONO_PATH "${HERE}`+monoLibDir+`/4.5" prepend
+apprun_export MONO_GAC_PREFIX "${HERE}/usr" prepend
+apprun_export MONO_CFG_DIR "${HERE}/usr/etc" replace`)
+	return nil
+}
+
+// isManagedAssembly returns true if the PE file at path contains CLI metadata, which starts with
+// the signature "BSJB", as opposed to a native Windows executable (e.g., for Wine)
+func isManagedAssembly(path string) bool {
+	m, err := fsys.Map(fsys.OS, path)
+	if err != nil {
+		return false
+	}
+	defer m.Close()
+	managed := false
+	m.Access(func(data []byte) {
+		managed = bytes.HasPrefix(data, []byte("MZ")) && bytes.Contains(data, []byte("BSJB"))
+	})
+	return managed
+}
+
+// findMonoELF returns the Mono runtime among the ELFs to be deployed, if any
+func findMonoELF(ctx *deployContext) string {
+	for _, lib := range ctx.elfs {
+		name := filepath.Base(lib)
+		if name == "mono" || name == "mono-sgen" || strings.HasPrefix(name, "libmonosgen-2.0.so") || strings.HasPrefix(name, "libmono-2.0.so") {
+			return lib
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+)
+
+// isStaticELF returns true if the ELF at path in appdirFS does not use the dynamic linker,
+// i.e., it is a static executable or a static PIE, such as most Go binaries.
+// These neither need libraries nor have an rpath that could be set
+func isStaticELF(path string) bool {
+	info, err := elfdeps.Classify(appdirFS, path)
+	return err == nil && info.Kind.Static()
+}
+
+// classifyELF returns how the ELF at path in appdirFS is linked, remembering it in ctx
+// so that each ELF is only classified once per deployment
+func classifyELF(ctx *deployContext, path string) (elfdeps.Info, error) {
+	if info, ok := ctx.elfInfos[path]; ok {
+		return info, nil
+	}
+	info, err := elfdeps.Classify(appdirFS, path)
+	if err != nil {
+		return info, err
+	}
+	if ctx.elfInfos == nil {
+		ctx.elfInfos = make(map[string]elfdeps.Info)
+	}
+	ctx.elfInfos[path] = info
+	return info, nil
+}
+
+// isSharedLibrary returns true if the ELF at path is a shared library rather than an executable,
+// judging by its type, interpreter and soname rather than by whether its name contains ".so"
+func isSharedLibrary(ctx *deployContext, path string) bool {
+	info, err := classifyELF(ctx, path)
+	return err == nil && info.Kind == elfdeps.KindSharedLibrary
+}
+
+// checkMainExecutableLinking reports how the main executable of the AppDir is linked and returns false
+// if it is a static executable, which has no interpreter that could be deployed. If it is not a PIE
+// and the interpreter gets bundled, warns that AppRun loads it differently than the system would
+func checkMainExecutableLinking(appdir helpers.AppDir) bool {
+	info, err := elfdeps.Classify(appdirFS, appdir.MainExecutable)
+	if err != nil {
+		// Not an ELF, e.g., a script; the interpreter is looked up by deployInterpreter
+		return true
+	}
+	log.Println("The main executable", appdir.MainExecutable, "is linked as", info.String())
+	if info.Kind.Static() {
+		log.Println("It does not use a dynamic linker, hence not deploying one; AppRun runs it directly")
+		return false
+	}
+	if info.Kind == elfdeps.KindDynamic && (options.libAppRunHooks || isMuslInterpreter(info.Interpreter)) {
+		log.Println("WARNING: The main executable is not a position-independent executable (PIE). AppRun runs it through the bundled",
+			info.Interpreter, "rather than letting the kernel load it, and the dynamic linker then has to map it at its fixed address,",
+			"which fails if that collides with where the kernel put the dynamic linker. Build it with -fPIE -pie to avoid this")
+	}
+	return true
+}
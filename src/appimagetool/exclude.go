@@ -1,3 +1,6 @@
+//go:build linux
+// +build linux
+
 // Code generated by go generate; DO NOT EDIT.
 // This file was generated by robots at
 // 2019-12-08 09:57:22.872554855 +0100 CET m=+0.430504375
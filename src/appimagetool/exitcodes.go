@@ -0,0 +1,13 @@
+package main
+
+// Exit codes of appimagetool, so that scripts can tell why it failed without parsing its output,
+// e.g., to retry a build on another machine when a tool is missing. They are listed in README.md
+// and must not change once released; 1 is used for all other errors, such as invalid arguments
+const (
+	exitMissingTool            = 2 // A helper tool or the runtime is missing, e.g., mksquashfs or objcopy
+	exitInvalidAppDir          = 3 // The AppDir lacks a valid desktop file, icon or AppRun, or was modified since it was deployed
+	exitUnresolvedDependencies = 4 // Libraries or other dependencies could not be found, or not in the versions needed
+	exitPatchFailure           = 5 // ELFs, Qt or other files could not be patched, e.g., their rpath or paths compiled into them, or a plugin failed
+	exitPackingError           = 6 // The squashfs image or the AppImage could not be made, or its sections could not be written
+	exitSignatureFailure       = 7 // The AppImage could not be signed, or its signature or digest does not match
+)
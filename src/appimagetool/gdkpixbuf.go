@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// gdkPixbufDeployer bundles the Gdk pixbuf loaders without which the bundled Gtk does not work
+type gdkPixbufDeployer struct{}
+
+// Detect returns true if there is a .so with the name libgdk_pixbuf inside the AppDir
+func (gdkPixbufDeployer) Detect(ctx *deployContext) bool {
+	return findELFWithPrefix(ctx, "libgdk_pixbuf") != ""
+}
+
+// Deploy does the equivalent of
+// cp /usr/lib/x86_64-linux-gnu/gdk-pixbuf-*/*/loaders/* usr/lib/x86_64-linux-gnu/gdk-pixbuf-*/*/loaders/
+// and writes a loaders.cache that contains only the bundled loaders, without paths
+func (gdkPixbufDeployer) Deploy(ctx *deployContext) error {
+	log.Println("Determining Gdk pixbuf loaders (for GDK_PIXBUF_MODULEDIR and GDK_PIXBUF_MODULE_FILE)...")
+	locs, err := findWithPrefixInLibraryLocations(ctx, "gdk-pixbuf")
+	if err != nil {
+		log.Println("Could not find Gdk pixbuf loaders")
+		return err
+	}
+	for _, loc := range locs {
+		determineELFsInDirTree(ctx, loc)
+
+		// The loaders.cache in the AppDir must not contain paths to the loaders on the build system
+		loadersCaches := helpers.FilesWithSuffixInDirectoryRecursive(loc, "loaders.cache")
+		if len(loadersCaches) < 1 {
+			return errors.New("could not find loaders.cache")
+		}
+
+		err = writeGdkPixbufLoadersCache(ctx, loadersCaches[0])
+		if err != nil {
+			helpers.PrintError("Could not write loaders.cache", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// writeGdkPixbufLoadersCache writes the loaders.cache from the build system at hostCache into the
+// AppDir, containing only the loaders that are bundled. The paths to the loaders are reduced to
+// their file names, which gdk-pixbuf looks up in GDK_PIXBUF_MODULEDIR, so that no entry
+// references the build system
+func writeGdkPixbufLoadersCache(ctx *deployContext, hostCache string) error {
+	appdir := ctx.appdir
+	data, err := ioutil.ReadFile(hostCache)
+	if err != nil {
+		return err
+	}
+	cache, dropped := filterModulesCache(string(data), func(loader string) bool {
+		return helpers.SliceContains(ctx.elfs, loader) || helpers.Exists(appdir.Path+loader)
+	}, true)
+	for _, loader := range dropped {
+		log.Println("NOTE: Not adding", loader, "to loaders.cache because it is not bundled")
+	}
+	log.Println("Writing", appdir.Path+hostCache)
+	err = os.MkdirAll(filepath.Dir(appdir.Path+hostCache), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appdir.Path+hostCache, []byte(cache), 0644)
+}
+
+// filterModulesCache returns the contents of a gdk-pixbuf loaders.cache or a Gtk immodules.cache
+// with only the entries for which isBundled returns true for the path of the module, reducing the
+// paths to the file names if stripPaths is true. Also returns the paths of the modules that were left out.
+// Each entry starts with a line that contains the quoted path to the module
+// and ends with an empty line; comments start with #
+func filterModulesCache(cache string, isBundled func(module string) bool, stripPaths bool) (string, []string) {
+	var result []string
+	var dropped []string
+	keep := true
+	for _, line := range strings.Split(cache, "\n") {
+		if strings.HasPrefix(line, "\"/") {
+			module := strings.Trim(strings.TrimSpace(line), "\"")
+			keep = isBundled(module)
+			if keep == false {
+				dropped = append(dropped, module)
+				continue
+			}
+			if stripPaths {
+				line = "\"" + filepath.Base(module) + "\""
+			}
+		} else if line == "" && keep == false {
+			// End of an entry that was left out
+			keep = true
+			continue
+		}
+		if keep {
+			result = append(result, line)
+		}
+	}
+	return strings.Join(result, "\n"), dropped
+}
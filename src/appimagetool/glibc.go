@@ -0,0 +1,245 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// glibcLibraries are the members of the glibc family that need to come from the
+// very same glibc build as the bundled ld-linux, otherwise the private loader
+// will refuse to work with them (GLIBC_PRIVATE symbols change with every release)
+var glibcLibraries = []string{
+	"libc.so.6",
+	"libm.so.6",
+	"libpthread.so.0",
+	"libdl.so.2",
+	"librt.so.1",
+	"libresolv.so.2",
+	"libutil.so.1",
+	"libnss_files.so.2",
+	"libnss_dns.so.2",
+}
+
+// Location of the bundled gconv modules; must match GCONV_PATH exported in AppRun
+const glibcGconvDir = "/usr/lib/gconv"
+
+// Location of the bundled locale data; must match LOCPATH exported in AppRun
+const glibcLocaleDir = "/usr/lib/locale"
+
+var glibcVersionRegexp = regexp.MustCompile(`release version (\d+\.\d+)`)
+
+// deployGlibc copies the ld-linux interpreter together with the matching libc family
+// of libraries, all gconv modules, and the C.UTF-8 locale (or the locale-archive)
+// into the AppDir, and makes sure that all of them belong to the same glibc release.
+// Unlike the other ELFs, these are copied directly rather than through ctx.elfs
+// because they are on the excludelist and hence would be skipped otherwise
+func deployGlibc(ctx *deployContext, ldLinux string) error {
+	appdir := ctx.appdir
+
+	libc, err := findLibrary(ctx, "libc.so.6")
+	if err != nil {
+		return err
+	}
+	libcResolved, err := filepath.EvalSymlinks(libc)
+	if err != nil {
+		return err
+	}
+	libcDir := filepath.Dir(libcResolved)
+
+	ldLinuxResolved, err := filepath.EvalSymlinks(ldLinux)
+	if err != nil {
+		return err
+	}
+
+	// Make sure that ld-linux and libc are from the same glibc release
+	ldVersion, err := getGlibcVersion(ldLinuxResolved)
+	if err != nil {
+		return err
+	}
+	libcVersion, err := getGlibcVersion(libcResolved)
+	if err != nil {
+		return err
+	}
+	if ldVersion != libcVersion {
+		return errors.New(ldLinux + " is from glibc " + ldVersion + " but " + libc + " is from glibc " + libcVersion)
+	}
+	log.Println("Bundling glibc", libcVersion, "from", libcDir)
+
+	for _, name := range glibcLibraries {
+		lib, err := findLibrary(ctx, name)
+		if err != nil {
+			log.Println("Not bundling", name, "because it could not be found")
+			continue
+		}
+		libResolved, err := filepath.EvalSymlinks(lib)
+		if err != nil {
+			return err
+		}
+		if filepath.Dir(libResolved) != libcDir {
+			return errors.New(lib + " does not belong to the glibc in " + libcDir)
+		}
+		// The libraries keep their location relative to the AppDir so that
+		// ld-linux finds them in the same place as on the build system
+		err = helpers.CopyFile(lib, glibcTargetPath(appdir, lib))
+		if err != nil {
+			return err
+		}
+	}
+
+	// gconv modules
+	err = runDeployers(ctx, stageGlibc)
+	if err != nil {
+		return err
+	}
+
+	return deployLocaleData(appdir)
+}
+
+// glibcTargetPath returns the location in the AppDir to which a file belonging to glibc
+// should be copied. If libapprun_hooks is used, then those go into LibcDir so that
+// they are only used if they are newer than the ones on the target system
+func glibcTargetPath(appdir helpers.AppDir, path string) string {
+	if options.libAppRunHooks {
+		return appdir.Path + "/" + LibcDir + "/" + path
+	}
+	return appdir.Path + "/" + path
+}
+
+// getGlibcVersion returns the glibc release a file (ld-linux or libc) was built from,
+// by looking for the version banner that glibc embeds into both of them
+func getGlibcVersion(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	match := glibcVersionRegexp.FindSubmatch(data)
+	if match == nil {
+		return "", errors.New("could not determine the glibc version of " + path)
+	}
+	return string(match[1]), nil
+}
+
+// gconvDeployer copies all gconv modules, including the gconv-modules configuration
+// files without which none of them are found, into glibcGconvDir in the AppDir
+type gconvDeployer struct{}
+
+// Detect returns true because the bundled glibc, after which this runs, always needs gconv
+// to convert between character sets, e.g., in iconv(3)
+func (gconvDeployer) Detect(ctx *deployContext) bool {
+	return true
+}
+
+// Deploy copies the gconv modules and determines their dependencies
+func (gconvDeployer) Deploy(ctx *deployContext) error {
+	appdir := ctx.appdir
+	log.Println("Determining gconv (for GCONV_PATH)...")
+	// Search in all of the system's library directories for a directory called gconv
+	gconvs, err := findWithPrefixInLibraryLocations(ctx, "gconv")
+	if err != nil {
+		return err
+	}
+	var gconvDir string
+	for _, g := range gconvs {
+		if helpers.IsDirectory(g) && helpers.Exists(g+"/gconv-modules") {
+			gconvDir = g
+			break
+		}
+	}
+	if gconvDir == "" {
+		return errors.New("could not find a gconv directory containing gconv-modules")
+	}
+	log.Println("Bundling all gconv modules from", gconvDir)
+	err = os.MkdirAll(appdir.Path+glibcGconvDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = helpers.CopyTree(gconvDir, appdir.Path+glibcGconvDir)
+	if err != nil {
+		return err
+	}
+
+	// Some gconv modules depend on libraries in the same directory or elsewhere
+	modules := helpers.FilesWithSuffixInDirectoryRecursive(gconvDir, ".so")
+	for _, module := range modules {
+		err = getDeps(ctx, module)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deployLocaleData copies the C.UTF-8 locale into glibcLocaleDir in the AppDir
+// so that the bundled glibc does not need to read the locale-archive of the
+// target system, the format of which may not match. If there is no C.UTF-8 locale
+// on the build system, then the locale-archive is copied instead
+func deployLocaleData(appdir helpers.AppDir) error {
+	for _, name := range []string{"C.UTF-8", "C.utf8"} {
+		src := glibcLocaleDir + "/" + name
+		if helpers.IsDirectory(src) {
+			log.Println("Bundling locale", src)
+			return helpers.CopyTree(src, appdir.Path+src)
+		}
+	}
+	src := glibcLocaleDir + "/locale-archive"
+	if helpers.Exists(src) {
+		log.Println("No C.UTF-8 locale found, bundling", src)
+		return helpers.CopyFile(src, appdir.Path+src)
+	}
+	log.Println("WARNING: Neither C.UTF-8 nor locale-archive found, not bundling locale data")
+	return nil
+}
+
+// Path in the AppDir of the file that lists the directories in the AppDir in which the bundled ld-linux
+// looks for libraries, one per line and relative to the AppDir, in the format of /etc/ld.so.conf
+// (as if the AppDir was the root directory, like ldconfig -r does). ld.so itself only reads the cache that
+// ldconfig generates from /etc/ld.so.conf, hence AppRun passes the directories to it with --library-path
+// together with --inhibit-cache, so that it neither uses the ld.so.cache of the target system.
+// No ld.so.cache is generated from it: ld-linux only reads the one at its compiled-in path, and the cache
+// would contain absolute paths, which change with the mountpoint of the AppImage
+const ldSoConfPath = "etc/ld.so.conf"
+
+// writeLdSoConf writes ldSoConfPath for the bundled ld-linux. It lists the directories of the bundled
+// libc first, since ld-linux only works with the libc it belongs to, and then the directories of the other
+// libraries. Libraries that are not bundled, e.g., because they are on the excludelist, are then found
+// in the built-in default directories of ld-linux (/lib and /usr/lib), which come after the --library-path
+func writeLdSoConf(ctx *deployContext, ldLinux string) error {
+	appdir := ctx.appdir
+	var libcDirs, dirs []string
+	for _, lib := range append([]string{ldLinux}, ctx.elfs...) {
+		target := getTargetPathInAppDir(appdir, lib)
+		if lib == ldLinux {
+			target = glibcTargetPath(appdir, ldLinux)
+		}
+		if helpers.Exists(target) == false || (lib != ldLinux && isSharedLibrary(ctx, target) == false) {
+			continue
+		}
+		dir := strings.TrimPrefix(filepath.Dir(target), appdir.Path)
+		if strings.HasPrefix(target, appdir.Path+"/"+LibcDir+"/") {
+			libcDirs = helpers.AppendIfMissing(libcDirs, dir)
+		} else {
+			dirs = helpers.AppendIfMissing(dirs, dir)
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("# Directories in the AppDir that AppRun passes to the bundled ld-linux with --library-path\n")
+	for _, dir := range append(libcDirs, dirs...) {
+		sb.WriteString(dir + "\n")
+	}
+	log.Println("Writing", appdir.Path+"/"+ldSoConfPath)
+	err := os.MkdirAll(filepath.Dir(appdir.Path+"/"+ldSoConfPath), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appdir.Path+"/"+ldSoConfPath, []byte(sb.String()), 0644)
+}
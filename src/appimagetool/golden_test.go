@@ -0,0 +1,396 @@
+//go:build linux
+// +build linux
+
+package main
+
+// End-to-end tests that build tiny C programs with contrived dependency graphs, deploy them,
+// compare the bundled ELFs with the golden files in testdata/golden, and run the programs
+// in a sandbox in which only the libraries that are deliberately not bundled (the libc family) are visible.
+// They are skipped unless a C compiler ($CC or cc), patchelf, and bwrap or unshare are available.
+// After deliberate changes to the deployment, update the golden files with
+//
+//	go test -run TestGolden -update
+//
+// $LDFLAGS are passed to the compiler in addition, e.g., to use a different linker
+
+import (
+	"bytes"
+	"debug/elf"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+)
+
+var updateGolden = flag.Bool("update", false, "Update the golden files in testdata/golden")
+
+// Set in the environment of the test binary to make it run appimagetool instead of the tests
+const testMainEnv = "GO_APPIMAGETOOL_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// goldenLibrary is a library built for a golden test. Each library has a function named
+// like it that prints its name and calls the functions of the libraries it needs
+type goldenLibrary struct {
+	name    string   // E.g., "foo" for libfoo
+	version string   // E.g., "1.2.3" to build libfoo.so.1.2.3 with the soname libfoo.so.1 and the symlinks to it
+	dir     string   // Where it is put, relative to the prefix, or to the AppDir if starting with "AppDir/"
+	needs   []string // Names of the libraries it links to
+	rpath   string   // Written as DT_RUNPATH or DT_RPATH; $PREFIX is replaced by the prefix
+	runpath bool
+}
+
+// goldenCase is an AppDir with a program in usr/bin that needs libraries built from a prefix outside of it
+type goldenCase struct {
+	name      string
+	libraries []goldenLibrary // In the order in which they are built
+	needs     []string
+	rpath     string
+	runpath   bool
+	dlopen    string // Library that the program loads using dlopen() and whose function it calls
+	output    string // What the program prints when all libraries are found
+}
+
+var goldenCases = []goldenCase{
+	{
+		// DT_RPATH of the program is also used for the libraries it needs
+		name: "rpath",
+		libraries: []goldenLibrary{
+			{name: "bar", version: "2.0.1", dir: "lib2"},
+			{name: "foo", version: "1.2.3", dir: "lib", needs: []string{"bar"}},
+		},
+		needs:  []string{"foo"},
+		rpath:  "$PREFIX/lib:$PREFIX/lib2",
+		output: "foo\nbar\n",
+	},
+	{
+		// DT_RUNPATH is only used for the libraries the ELF itself needs
+		name: "runpath",
+		libraries: []goldenLibrary{
+			{name: "bar", version: "2", dir: "lib2"},
+			{name: "foo", version: "1.0", dir: "lib", needs: []string{"bar"}, rpath: "$PREFIX/lib2", runpath: true},
+		},
+		needs:   []string{"foo"},
+		rpath:   "$PREFIX/lib",
+		runpath: true,
+		output:  "foo\nbar\n",
+	},
+	{
+		// A private library that is already in the AppDir needs a library from the prefix
+		name: "origin",
+		libraries: []goldenLibrary{
+			{name: "foo", version: "1", dir: "lib"},
+			{name: "private", dir: "AppDir/usr/lib/private", needs: []string{"foo"}, rpath: "$PREFIX/lib", runpath: true},
+		},
+		needs:   []string{"private"},
+		rpath:   "$ORIGIN/../lib/private",
+		runpath: true,
+		output:  "private\nfoo\n",
+	},
+	{
+		// A plugin that the program loads by name needs a library from the prefix
+		name: "dlopen",
+		libraries: []goldenLibrary{
+			{name: "foo", version: "1", dir: "lib"},
+			{name: "plugin", dir: "AppDir/usr/lib/plugins", needs: []string{"foo"}, rpath: "$PREFIX/lib", runpath: true},
+		},
+		dlopen: "libplugin.so",
+		output: "plugin\nfoo\n",
+	},
+}
+
+func TestGolden(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping end-to-end tests in short mode")
+	}
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	for _, tool := range []string{cc, "patchelf"} {
+		if helpers.IsCommandAvailable(tool) == false {
+			t.Skip(tool, "is not available")
+		}
+	}
+	sandbox := findSandbox()
+	if sandbox == nil {
+		t.Skip("Neither bwrap nor unshare with user namespaces is available")
+	}
+	for _, c := range goldenCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "golden")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			appdir := dir + "/Test.AppDir"
+			c.build(t, cc, dir+"/prefix", appdir)
+
+			deploy := exec.Command(os.Args[0], "deploy", appdir+"/usr/share/applications/test.desktop")
+			deploy.Env = append(os.Environ(), testMainEnv+"=1")
+			out, err := deploy.CombinedOutput()
+			if err != nil {
+				t.Fatalf("Deployment failed: %v\n%s", err, out)
+			}
+
+			summary := summarizeAppDir(t, appdir, dir+"/prefix")
+			golden := "testdata/golden/" + c.name + ".golden"
+			if *updateGolden {
+				os.MkdirAll(filepath.Dir(golden), 0755)
+				err = ioutil.WriteFile(golden, []byte(summary), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err, "(run with -update to create it)")
+			}
+			if summary != string(expected) {
+				t.Errorf("The deployed AppDir differs from %s:\n%s", golden, summary)
+			}
+
+			root := dir + "/root"
+			makeSandboxRoot(t, appdir, root)
+			out, err = sandbox(root, "/app/usr/bin/test").CombinedOutput()
+			if err != nil || string(out) != c.output {
+				t.Errorf("Running in the sandbox printed %q, expected %q (%v)", out, c.output, err)
+			}
+		})
+	}
+}
+
+// build compiles the libraries into the prefix or the AppDir and the program into the AppDir,
+// and adds what the AppDir needs to be deployed
+func (c goldenCase) build(t *testing.T, cc string, prefix string, appdir string) {
+	dirs := make(map[string]string) // Key: name of a library, value: directory it was put into
+	for _, lib := range c.libraries {
+		dir := prefix + "/" + lib.dir
+		if strings.HasPrefix(lib.dir, "AppDir/") {
+			dir = appdir + strings.TrimPrefix(lib.dir, "AppDir")
+		}
+		dirs[lib.name] = dir
+		file := "lib" + lib.name + ".so"
+		args := []string{"-shared", "-fPIC"}
+		if lib.version != "" {
+			args = append(args, "-Wl,-soname,"+file+"."+strings.Split(lib.version, ".")[0])
+			file = file + "." + lib.version
+		}
+		source := "#include <stdio.h>\n" + declarations(lib.needs) +
+			"void " + lib.name + "(void) {\n  printf(\"" + lib.name + "\\n\");\n" + calls(lib.needs) + "}\n"
+		args = append(args, linkerFlags(lib.needs, dirs, lib.rpath, lib.runpath, prefix)...)
+		compile(t, cc, source, dir+"/"+file, args)
+		// Make the symlinks right away since the libraries built next link to them
+		if lib.version != "" {
+			soname := "lib" + lib.name + ".so." + strings.Split(lib.version, ".")[0]
+			if soname != file {
+				os.Symlink(file, dir+"/"+soname)
+			}
+			os.Symlink(soname, dir+"/lib"+lib.name+".so")
+		}
+	}
+
+	source := "#include <stdio.h>\n#include <dlfcn.h>\n" + declarations(c.needs) + "int main(void) {\n" + calls(c.needs)
+	if c.dlopen != "" {
+		name := strings.TrimSuffix(strings.TrimPrefix(c.dlopen, "lib"), ".so")
+		source = source + "  void *handle = dlopen(\"" + c.dlopen + "\", RTLD_NOW);\n" +
+			"  if (handle == NULL) {\n    printf(\"%s\\n\", dlerror());\n    return 1;\n  }\n" +
+			"  ((void (*)(void))dlsym(handle, \"" + name + "\"))();\n"
+	}
+	source = source + "  return 0;\n}\n"
+	args := append(linkerFlags(c.needs, dirs, c.rpath, c.runpath, prefix), "-ldl")
+	compile(t, cc, source, appdir+"/usr/bin/test", args)
+
+	os.MkdirAll(appdir+"/usr/share/applications", 0755)
+	err := ioutil.WriteFile(appdir+"/usr/share/applications/test.desktop", []byte(
+		"[Desktop Entry]\nType=Application\nName=Test\nExec=test\nIcon=test\nCategories=Utility;\nTerminal=true\n"), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(appdir+"/test.png", []byte("Not an image, but deploying does not care"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// declarations returns the C declarations of the functions of the libraries
+func declarations(libs []string) string {
+	s := ""
+	for _, lib := range libs {
+		s = s + "void " + lib + "(void);\n"
+	}
+	return s
+}
+
+// calls returns the C statements that call the functions of the libraries
+func calls(libs []string) string {
+	s := ""
+	for _, lib := range libs {
+		s = s + "  " + lib + "();\n"
+	}
+	return s
+}
+
+// linkerFlags returns the compiler arguments to link to the libraries that are in dirs
+// and to write rpath, in which $PREFIX is replaced by prefix
+func linkerFlags(libs []string, dirs map[string]string, rpath string, runpath bool, prefix string) []string {
+	var args []string
+	for _, lib := range libs {
+		args = append(args, "-L"+dirs[lib], "-l"+lib)
+	}
+	if rpath != "" {
+		args = append(args, "-Wl,-rpath,"+strings.Replace(rpath, "$PREFIX", prefix, -1))
+		if runpath {
+			args = append(args, "-Wl,--enable-new-dtags")
+		} else {
+			args = append(args, "-Wl,--disable-new-dtags")
+		}
+	}
+	return args
+}
+
+// compile compiles the C source into output
+func compile(t *testing.T, cc string, source string, output string, args []string) {
+	os.MkdirAll(filepath.Dir(output), 0755)
+	cmd := exec.Command(cc, append(append([]string{"-x", "c", "-o", output, "-"}, args...), strings.Fields(os.Getenv("LDFLAGS"))...)...)
+	cmd.Stdin = strings.NewReader(source)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Could not compile %s: %v\n%s\n%s", output, err, out, source)
+	}
+}
+
+// summarizeAppDir returns, for each ELF in the AppDir, its path, the libraries it needs,
+// and the directories in the AppDir that the entries of its rpath point to, sorted and relative to the AppDir,
+// with the prefix replaced by $PREFIX so that the summary does not depend on where the test runs
+func summarizeAppDir(t *testing.T, appdir string, prefix string) string {
+	var lines []string
+	filepath.Walk(appdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode().IsRegular() == false || isELF(path) == false {
+			return nil
+		}
+		f, err := elf.Open(path)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		defer f.Close()
+		relpath := strings.Replace(strings.TrimPrefix(path, appdir+"/"), strings.TrimPrefix(prefix, "/"), "$PREFIX", 1)
+		lines = append(lines, relpath)
+		needed, _ := f.ImportedLibraries()
+		sort.Strings(needed)
+		for _, lib := range needed {
+			lines = append(lines, "  NEEDED "+lib)
+		}
+		for _, tag := range []elf.DynTag{elf.DT_RPATH, elf.DT_RUNPATH} {
+			values, _ := f.DynString(tag)
+			var dirs []string
+			for _, value := range values {
+				for _, entry := range strings.Split(value, ":") {
+					dir := filepath.Clean(strings.Replace(entry, "$ORIGIN", filepath.Dir(path), 1))
+					if strings.HasPrefix(dir, appdir+"/") && helpers.IsDirectory(dir) {
+						dir = strings.Replace(strings.TrimPrefix(dir, appdir+"/"), strings.TrimPrefix(prefix, "/"), "$PREFIX", 1)
+						dirs = helpers.AppendIfMissing(dirs, dir)
+					}
+				}
+			}
+			sort.Strings(dirs)
+			for _, dir := range dirs {
+				lines = append(lines, "  "+tag.String()+" "+dir)
+			}
+		}
+		return nil
+	})
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// makeSandboxRoot makes a root filesystem with the AppDir in /app and the libraries from the host
+// that its ELFs need but that are not bundled, including the dynamic linker, so that the programs
+// in the AppDir can only use what was deployed
+func makeSandboxRoot(t *testing.T, appdir string, root string) {
+	err := helpers.CopyTree(appdir, root+"/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundled := make(map[string]bool)
+	var elfs []string
+	filepath.Walk(appdir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsDir() == false {
+			bundled[info.Name()] = true
+			if info.Mode().IsRegular() && isELF(path) {
+				elfs = append(elfs, path)
+			}
+		}
+		return nil
+	})
+
+	walker := elfdeps.NewWalker(elfdeps.NewDefaultResolver())
+	var hostFiles []string
+	for _, path := range elfs {
+		f, err := elf.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, prog := range f.Progs {
+			if prog.Type == elf.PT_INTERP {
+				interp, _ := ioutil.ReadAll(prog.Open())
+				hostFiles = append(hostFiles, string(bytes.TrimRight(interp, "\x00")))
+			}
+		}
+		needed, _ := f.ImportedLibraries()
+		f.Close()
+		for _, lib := range needed {
+			if bundled[lib] {
+				continue
+			}
+			host, _, err := walker.Resolver.Resolve(lib, path)
+			if err != nil {
+				t.Fatal(lib, "is neither bundled nor on the host")
+			}
+			walker.Walk(host)
+		}
+	}
+	hostFiles = append(hostFiles, walker.Graph.ELFs...)
+	for _, path := range hostFiles {
+		// The copy is a regular file even if the original is a symlink
+		err = helpers.CopyFile(path, root+path)
+		if err == nil {
+			// The dynamic linker needs to be executable
+			err = os.Chmod(root+path, 0755)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// findSandbox returns a function that returns the command that runs program with root as the root filesystem,
+// or nil if neither bwrap nor unshare with unprivileged user namespaces is available.
+// The sandbox has /proc since the dynamic linker needs /proc/self/exe to expand $ORIGIN in the rpath of the program
+func findSandbox() func(root string, program string) *exec.Cmd {
+	if helpers.IsCommandAvailable("bwrap") {
+		return func(root string, program string) *exec.Cmd {
+			return exec.Command("bwrap", "--die-with-parent", "--bind", root, "/", "--proc", "/proc", program)
+		}
+	}
+	if helpers.IsCommandAvailable("unshare") && exec.Command("unshare", "--user", "--map-root-user", "--mount", "true").Run() == nil {
+		return func(root string, program string) *exec.Cmd {
+			return exec.Command("unshare", "--user", "--map-root-user", "--mount", "sh", "-c",
+				"mkdir -p \"$0/proc\" && mount --rbind /proc \"$0/proc\" && exec chroot \"$0\" \"$1\"", root, program)
+		}
+	}
+	return nil
+}
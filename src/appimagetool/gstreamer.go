@@ -0,0 +1,127 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Sets of GStreamer plugins that can be selected with --gstreamer-plugins,
+// identified by the source module name that is compiled into each plugin
+var gstreamerPluginSets = []string{"base", "good", "bad", "ugly", "libav"}
+
+// GStreamer plugins that are always bundled because nothing works without them
+var gstreamerCorePlugins = []string{"libgstcoreelements.so", "libgstcoretracers.so"}
+
+// gstPluginScannerRegexp matches the path to gst-plugin-scanner compiled into libgstreamer
+var gstPluginScannerRegexp = regexp.MustCompile(`/[^\x00]*/gst-plugin-scanner\x00`)
+
+// gstreamerDeployer bundles the GStreamer plugins selected with --gstreamer-plugins,
+// or all of them, and gst-plugin-scanner which GStreamer uses to load them
+type gstreamerDeployer struct{}
+
+// Detect returns true if there is a .so with the name libgstreamer-1.0 inside the AppDir
+func (gstreamerDeployer) Detect(ctx *deployContext) bool {
+	return findELFWithPrefix(ctx, "libgstreamer-1.0") != ""
+}
+
+// Deploy bundles the plugins and gst-plugin-scanner
+func (gstreamerDeployer) Deploy(ctx *deployContext) error {
+	libgstreamer := findELFWithPrefix(ctx, "libgstreamer-1.0")
+
+	log.Println("Bundling GStreamer 1.0 plugins (for GST_PLUGIN_PATH)...")
+	locs, err := findWithPrefixInLibraryLocations(ctx, "gstreamer-1.0")
+	if err != nil {
+		log.Println("Could not find GStreamer 1.0 directory")
+		return err
+	}
+	plugins, err := ioutil.ReadDir(locs[0])
+	if err != nil {
+		helpers.PrintError("Could not read GStreamer 1.0 directory", err)
+		return err
+	}
+	for _, plugin := range plugins {
+		path := locs[0] + "/" + plugin.Name()
+		if strings.HasSuffix(plugin.Name(), ".so") == false || isGStreamerPluginWanted(path) == false {
+			continue
+		}
+		determineELFsInDirTree(ctx, path)
+	}
+
+	gstPluginScanner := findGstPluginScanner(libgstreamer)
+	if gstPluginScanner == "" {
+		log.Println("WARNING: Could not find gst-plugin-scanner, GStreamer will load the plugins in the application process")
+		return nil
+	}
+	log.Println("Determining gst-plugin-scanner...")
+	determineELFsInDirTree(ctx, gstPluginScanner)
+	return nil
+}
+
+// isGStreamerPluginWanted returns true if the GStreamer plugin at path
+// belongs to what was selected with --gstreamer-plugins
+func isGStreamerPluginWanted(path string) bool {
+	name := filepath.Base(path)
+	if len(options.gstreamerPlugins) == 0 || helpers.SliceContains(gstreamerCorePlugins, name) {
+		return true
+	}
+	var data []byte
+	for _, wanted := range options.gstreamerPlugins {
+		// Explicitly selected plugin, e.g., "png" or "libgstpng.so"
+		if name == wanted || name == "libgst"+wanted+".so" {
+			return true
+		}
+		if helpers.SliceContains(gstreamerPluginSets, wanted) == false {
+			continue
+		}
+		if data == nil {
+			var err error
+			data, err = ioutil.ReadFile(path)
+			if err != nil {
+				return false
+			}
+		}
+		// The source module is compiled into the plugin description, e.g., "gst-plugins-good"
+		if bytes.Contains(data, []byte("gst-plugins-"+wanted+"\x00")) || bytes.Contains(data, []byte("gst-"+wanted+"\x00")) {
+			return true
+		}
+	}
+	return false
+}
+
+// findGstPluginScanner returns the path to gst-plugin-scanner as compiled into libgstreamer,
+// which is different on each distribution, or an empty string if it cannot be found
+func findGstPluginScanner(libgstreamer string) string {
+	data, err := ioutil.ReadFile(libgstreamer)
+	if err == nil {
+		for _, match := range gstPluginScannerRegexp.FindAll(data, -1) {
+			candidate := strings.TrimSuffix(string(match), "\x00")
+			if helpers.Exists(candidate) {
+				return candidate
+			}
+		}
+	}
+	// Fallback for unusual builds
+	gstPluginScannerCandidates := []string{"/usr/libexec/gstreamer-1.0/gst-plugin-scanner", // Clear Linux* OS
+		"/usr/lib/x86_64-linux-gnu/gstreamer1.0/gstreamer-1.0/gst-plugin-scanner"} // sic! Ubuntu 18.04
+	for _, candidate := range gstPluginScannerCandidates {
+		if helpers.Exists(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// getGstRegistryName returns the path relative to the per-user cache directory of
+// the GStreamer registry that describes the bundled plugins
+func getGstRegistryName(appdir helpers.AppDir) string {
+	return "gstreamer-1.0/registry.appimage-" + filepath.Base(appdir.MainExecutable) + ".bin"
+}
@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// gtkDeployer bundles the Gtk directory (for GTK_EXE_PREFIX) with the modules/plugins
+// of the Gtk version that is used in the AppDir, and the theme
+type gtkDeployer struct {
+	version int
+}
+
+// Detect returns true if there is a .so with the name libgtk-<version> inside the AppDir
+func (d gtkDeployer) Detect(ctx *deployContext) bool {
+	return findELFWithPrefix(ctx, "libgtk-"+strconv.Itoa(d.version)) != ""
+}
+
+// Deploy bundles the Gtk directory, its modules/plugins and the theme
+func (d gtkDeployer) Deploy(ctx *deployContext) error {
+	version := strconv.Itoa(d.version)
+	log.Println("Bundling Gtk", version, "directory (for GTK_EXE_PREFIX)...")
+	locs, err := findWithPrefixInLibraryLocations(ctx, "gtk-"+version)
+	if err != nil {
+		log.Println("Could not find Gtk", version, "directory")
+		return err
+	}
+	for _, loc := range locs {
+		log.Println("Bundling dependencies of Gtk", version, "directory...")
+		determineELFsInDirTree(ctx, loc)
+		deployGtkModules(ctx, d.version, loc)
+	}
+	deployGtkTheme(ctx, d.version)
+	return nil
+}
+
+// deployGtkModules makes the input method modules and print backends in the Gtk directory
+// at gtkDir, which have been bundled together with it, usable from within the AppDir.
+// Gtk loads the input method modules using the absolute paths in immodules.cache,
+// hence AppRun writes a copy of it with the paths pointing into the AppDir at runtime
+func deployGtkModules(ctx *deployContext, gtkVersion int, gtkDir string) {
+	appdir := ctx.appdir
+	version := strconv.Itoa(gtkVersion)
+
+	// Gtk looks for print backends and other modules in $GTK_PATH/<binary version>/
+	addAppRunSection(ctx, "Use bundled Gtk "+version+" modules",
+		"apprun_export GTK_PATH \"${HERE}"+gtkDir+"\" prepend")
+
+	caches := helpers.FilesWithSuffixInDirectoryRecursive(gtkDir, "immodules.cache")
+	if len(caches) < 1 {
+		log.Println("No immodules.cache found for Gtk", version+", input methods may not work")
+		return
+	}
+	data, err := ioutil.ReadFile(caches[0])
+	if err != nil {
+		helpers.PrintError("Could not read immodules.cache", err)
+		return
+	}
+	cache, dropped := filterModulesCache(string(data), func(module string) bool {
+		return helpers.SliceContains(ctx.elfs, module)
+	}, false)
+	for _, module := range dropped {
+		log.Println("NOTE: Not adding", module, "to immodules.cache because it is not bundled")
+	}
+	log.Println("Writing", appdir.Path+caches[0])
+	err = os.MkdirAll(filepath.Dir(appdir.Path+caches[0]), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(appdir.Path+caches[0], []byte(cache), 0644)
+	}
+	if err != nil {
+		helpers.PrintError("Could not write immodules.cache", err)
+		os.Exit(exitPatchFailure)
+	}
+
+	// The name of the mountpoint makes the file unique for each running AppImage
+	addAppRunSection(ctx, "Use bundled Gtk "+version+" input method modules", `
+GTK_IM_MODULE_FILE="${XDG_RUNTIME_DIR:-/tmp}/appimage-gtk-`+version+`-immodules-$(basename "${HERE}").cache"
+while IFS= read -r line ; do
+  case "${line}" in
+    '"/'*) printf '%s\n' "\"${HERE}${line#\"}" ;;
+    *) printf '%s\n' "${line}" ;;
+  esac
+done < "${HERE}`+strings.Replace(caches[0], `"`, `\"`, -1)+`" > "${GTK_IM_MODULE_FILE}"
+export GTK_IM_MODULE_FILE`)
+}
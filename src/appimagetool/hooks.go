@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"plugin"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Stages of the deployment at which hooks selected with --hook run
+const (
+	// After all ELFs to be bundled and their dependencies have been determined
+	hookAfterResolve = "after-resolve"
+	// After the ELFs have been copied into the AppDir and patched
+	hookAfterCopy = "after-copy"
+	// Right before AppRun is written
+	hookBeforeAppRun = "before-apprun"
+)
+
+var hookStages = []string{hookAfterResolve, hookAfterCopy, hookBeforeAppRun}
+
+// deployHook is a command selected with --hook STAGE=COMMAND, run with sh -c in the AppDir,
+// or a Go plugin (a .so built with go build -buildmode=plugin) that exports
+// func Hook(stage string, manifest string) error
+type deployHook struct {
+	stage   string
+	command string
+}
+
+// hookManifest is written as JSON to the file in $APPIMAGETOOL_MANIFEST for the hooks
+type hookManifest struct {
+	Stage            string         `json:"stage"`
+	AppDir           string         `json:"appdir"`
+	DesktopFile      string         `json:"desktopFile"`
+	MainExecutable   string         `json:"mainExecutable"`
+	ELFs             []string       `json:"elfs"`             // As on the build system, or in the AppDir if already there
+	LibraryLocations []string       `json:"libraryLocations"` // In the AppDir
+	Patches          []appliedPatch `json:"patches"`          // Applied from the patches directory
+}
+
+// parseHooks parses the values of --hook
+func parseHooks(values []string) ([]deployHook, error) {
+	var hooks []deployHook
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.New("invalid --hook " + value + ", expected STAGE=COMMAND")
+		}
+		if helpers.SliceContains(hookStages, parts[0]) == false {
+			return nil, errors.New("unknown stage in --hook " + value + ", available stages: " + strings.Join(hookStages, ", "))
+		}
+		hooks = append(hooks, deployHook{stage: parts[0], command: parts[1]})
+	}
+	return hooks, nil
+}
+
+// runHooks runs the hooks for stage in the order in which they were given
+// and exits if one of them fails, so that projects can rely on their patches being applied
+func runHooks(ctx *deployContext, stage string) {
+	appdir := ctx.appdir
+	var manifestPath string
+	for _, hook := range options.hooks {
+		if hook.stage != stage {
+			continue
+		}
+		if manifestPath == "" {
+			var err error
+			manifestPath, err = writeHookManifest(ctx, stage)
+			if err != nil {
+				helpers.PrintError("Could not write the manifest for the hooks", err)
+				os.Exit(1)
+			}
+			defer os.Remove(manifestPath)
+		}
+		log.Println("Running", stage, "hook", hook.command+"...")
+		var err error
+		if strings.HasSuffix(hook.command, ".so") {
+			err = runPluginHook(hook.command, stage, manifestPath)
+		} else {
+			cmd := exec.Command("sh", "-c", hook.command)
+			cmd.Dir = appdir.Path
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			cmd.Env = append(os.Environ(),
+				"APPDIR="+appdir.Path,
+				"APPIMAGETOOL_STAGE="+stage,
+				"APPIMAGETOOL_MANIFEST="+manifestPath)
+			err = cmd.Run()
+		}
+		if err != nil {
+			helpers.PrintError(stage+" hook "+hook.command, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runPluginHook calls the Hook function of the Go plugin at path
+func runPluginHook(path string, stage string, manifestPath string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	symbol, err := p.Lookup("Hook")
+	if err != nil {
+		return err
+	}
+	hook, ok := symbol.(func(string, string) error)
+	if ok == false {
+		return errors.New(path + " does not export func Hook(stage string, manifest string) error")
+	}
+	return hook(stage, manifestPath)
+}
+
+// writeHookManifest writes the manifest for the hooks of stage to a temporary file and returns its path
+func writeHookManifest(ctx *deployContext, stage string) (string, error) {
+	appdir := ctx.appdir
+	manifest := hookManifest{
+		Stage:            stage,
+		AppDir:           appdir.Path,
+		DesktopFile:      appdir.DesktopFilePath,
+		MainExecutable:   appdir.MainExecutable,
+		ELFs:             ctx.elfs,
+		LibraryLocations: getLibraryLocationsInAppDir(ctx),
+		Patches:          ctx.appliedPatches,
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "appimagetool-manifest-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return f.Name(), err
+}
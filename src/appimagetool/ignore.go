@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the file in the top level of the AppDir that lists paths to be ignored, one glob per line
+const ignoreFileName = ".appdirignore"
+
+// appDirIgnore holds the patterns from .appdirignore and --ignore for the AppDir at root.
+// Patterns without a slash match the name of a file or directory anywhere in the AppDir,
+// e.g., __pycache__ or *.pyc. Patterns with a slash match the path relative to the AppDir,
+// e.g., usr/share/doc. A trailing slash restricts the pattern to directories.
+// Ignored paths are skipped when looking for ELFs and are left out of the AppImage
+type appDirIgnore struct {
+	root     string
+	patterns []string
+}
+
+// loadIgnorePatterns returns the patterns from the .appdirignore file of the AppDir at root
+// (if any) followed by extra, e.g., the values of --ignore
+func loadIgnorePatterns(root string, extra []string) (appDirIgnore, error) {
+	ignore := appDirIgnore{root: root}
+	f, err := os.Open(filepath.Join(root, ignoreFileName))
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ignore.patterns = append(ignore.patterns, line)
+		}
+		err = scanner.Err()
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	ignore.patterns = append(ignore.patterns, extra...)
+	return ignore, err
+}
+
+// matches returns true if path (below root) is ignored; isDir tells whether it is a directory
+func (ignore appDirIgnore) matches(path string, isDir bool) bool {
+	if len(ignore.patterns) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(ignore.root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	for _, pattern := range ignore.patterns {
+		if strings.HasSuffix(pattern, "/") {
+			if isDir == false {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		if strings.Contains(pattern, "/") {
+			if ok, _ := filepath.Match(strings.TrimPrefix(pattern, "/"), rel); ok {
+				return true
+			}
+		} else if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// squashfsExcludes returns the patterns as arguments for mksquashfs -wildcards -e,
+// where patterns without a slash are prefixed with "... " to match in every directory
+func (ignore appDirIgnore) squashfsExcludes() []string {
+	var excludes []string
+	for _, pattern := range ignore.patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if strings.Contains(pattern, "/") {
+			excludes = append(excludes, strings.TrimPrefix(pattern, "/"))
+		} else {
+			excludes = append(excludes, "... "+pattern)
+		}
+	}
+	return excludes
+}
+
+// squashfsLiteral escapes the characters that mksquashfs -wildcards would interpret,
+// so that a path with, e.g., brackets in its name excludes only itself
+func squashfsLiteral(path string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`).Replace(path)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/elfsection"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/signature"
+	"github.com/probonopd/go-appimage/src/goappimage"
+	"github.com/urfave/cli/v2"
+)
+
+var infoFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "Print the information as JSON",
+	},
+}
+
+// appImageInfo is what the info subcommand prints about an AppImage
+type appImageInfo struct {
+	Path              string            `json:"path"`
+	Type              int               `json:"type"`
+	Architecture      string            `json:"architecture"`
+	UpdateInformation string            `json:"updateInformation"`
+	Signature         string            `json:"signature"` // One of the signature.Status constants
+	SignedBy          []string          `json:"signedBy,omitempty"`
+	DesktopEntry      map[string]string `json:"desktopEntry"`
+	HasDirIcon        bool              `json:"hasDirIcon"`
+	HasIcon           bool              `json:"hasIcon"`
+	Compression       string            `json:"compression"`
+	RuntimeSize       int64             `json:"runtimeSize"`
+	PayloadSize       int64             `json:"payloadSize"`
+	BuildInfo         *buildInfo        `json:"buildInfo,omitempty"` // If the AppImage was built with the build metadata
+}
+
+// Compression IDs in the squashfs superblock
+var squashfsCompressions = map[uint16]string{1: "gzip", 2: "lzma", 3: "lzo", 4: "xz", 5: "lz4", 6: "zstd"}
+
+// bootstrapInfo prints information about an AppImage for scripts and bug reports
+//
+//	Args: c: cli.Context
+func bootstrapInfo(c *cli.Context) error {
+	if c.NArg() != 1 {
+		log.Fatal("Please specify the path to an AppImage")
+	}
+	info, err := getAppImageInfo(c.Args().Get(0))
+	if err != nil {
+		helpers.PrintError("info", err)
+		os.Exit(1)
+	}
+	if c.Bool("json") {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Println("Path:               ", info.Path)
+	fmt.Println("Type:               ", info.Type)
+	fmt.Println("Architecture:       ", info.Architecture)
+	fmt.Println("Update information: ", info.UpdateInformation)
+	fmt.Println("Signature:          ", info.Signature, strings.Join(info.SignedBy, ", "))
+	fmt.Println("Name:               ", info.DesktopEntry["Name"])
+	fmt.Println("Version:            ", info.DesktopEntry["X-AppImage-Version"])
+	fmt.Println("Exec:               ", info.DesktopEntry["Exec"])
+	fmt.Println("Icon:               ", info.DesktopEntry["Icon"], "(present:", info.HasIcon, "- .DirIcon present:", info.HasDirIcon, ")")
+	fmt.Println("Categories:         ", info.DesktopEntry["Categories"])
+	fmt.Println("Compression:        ", info.Compression)
+	fmt.Println("Runtime size:       ", formatSize(info.RuntimeSize))
+	fmt.Println("Payload size:       ", formatSize(info.PayloadSize))
+	if info.BuildInfo != nil {
+		fmt.Println("Built with:         ", info.BuildInfo.Tool, info.BuildInfo.ToolVersion, "("+info.BuildInfo.Go+")")
+		fmt.Println("Build flags:        ", strings.Join(info.BuildInfo.Flags, ", "))
+		fmt.Println("Deploy flags:       ", strings.Join(info.BuildInfo.DeployFlags, ", "))
+		fmt.Println("Plugins:            ", strings.Join(info.BuildInfo.Plugins, ", "))
+	}
+	return nil
+}
+
+// getAppImageInfo gathers the information about the AppImage at path
+func getAppImageInfo(path string) (appImageInfo, error) {
+	info := appImageInfo{Path: path, DesktopEntry: make(map[string]string)}
+	ai, err := goappimage.NewAppImage(path)
+	if err != nil {
+		return info, err
+	}
+	info.Type = ai.Type()
+	info.Architecture, _ = helpers.GetElfArchitecture(path)
+
+	if ai.Desktop != nil {
+		for _, key := range ai.Desktop.Section("Desktop Entry").Keys() {
+			// goappimage replaces semicolons so that the desktop file can be parsed
+			info.DesktopEntry[key.Name()] = strings.Replace(key.Value(), "；", ";", -1)
+		}
+	}
+	if r, err := ai.Thumbnail(); err == nil {
+		info.HasDirIcon = true
+		r.Close()
+	}
+	if r, _, err := ai.Icon(); err == nil {
+		info.HasIcon = true
+		r.Close()
+	}
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return info, err
+	}
+	if info.Type == 1 {
+		data, err := readAt(path, goappimage.Type1UpdateInformationOffset, goappimage.Type1UpdateInformationLength)
+		if err == nil {
+			info.UpdateInformation = strings.TrimSpace(string(bytes.Trim(data, "\x00")))
+		}
+		// The ELF is embedded in the system area of the ISO9660 image, which spans the whole file
+		info.Compression = "none (ISO9660)"
+		info.RuntimeSize = 32768
+		info.PayloadSize = fileInfo.Size()
+		info.Signature = "none"
+		return info, nil
+	}
+
+	info.UpdateInformation, _ = elfsection.ReadString(path, ".upd_info")
+	info.BuildInfo, _ = readBuildInfo(path)
+	info.RuntimeSize = helpers.CalculateElfSize(path)
+	superblock, err := readAt(path, info.RuntimeSize, 96)
+	if err == nil && string(superblock[0:4]) == "hsqs" {
+		info.Compression = squashfsCompressions[binary.LittleEndian.Uint16(superblock[20:])]
+		info.PayloadSize = int64(binary.LittleEndian.Uint64(superblock[40:]))
+	} else {
+		info.Compression = "unknown"
+		info.PayloadSize = fileInfo.Size() - info.RuntimeSize
+	}
+	result, err := signature.Verify(path)
+	if err != nil {
+		return info, err
+	}
+	info.Signature, info.SignedBy = result.Status, result.Identities
+	return info, nil
+}
+
+// readAt reads length bytes at offset from the file at path
+func readAt(path string, offset int64, length int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	_, err = f.ReadAt(data, offset)
+	return data, err
+}
+
+// bootstrapVerify checks the digest and signature of an AppImage, or the files of an AppDir
+// against its deployment manifest, and exits with an error if they do not match
+//
+//	Args: c: cli.Context
+func bootstrapVerify(c *cli.Context) error {
+	if c.NArg() != 1 {
+		log.Fatal("Please specify the path to an AppImage or AppDir to verify")
+	}
+	path := c.Args().Get(0)
+	if helpers.IsDirectory(path) {
+		verifyAppDirAndExit(path)
+	}
+	result, err := signature.Verify(path)
+	if err != nil {
+		helpers.PrintError("verify", err)
+		os.Exit(1)
+	}
+	fmt.Println("SHA-256 digest:", result.Digest)
+	switch result.Status {
+	case signature.StatusValid:
+		fmt.Println(path, "has a valid signature made by", strings.Join(result.Identities, ", "), "with the key", result.Fingerprint)
+	case signature.StatusDigestMatches:
+		fmt.Println(path, "is not signed, but its embedded digest matches")
+	case signature.StatusNone:
+		fmt.Println(path, "is neither signed nor does it contain its digest, hence its integrity cannot be checked")
+	case signature.StatusDigestMismatch:
+		fmt.Println(path, "is damaged or has been modified, its embedded digest does not match")
+		os.Exit(exitSignatureFailure)
+	default:
+		fmt.Println(path, "has an invalid signature, it is damaged or has been modified")
+		os.Exit(exitSignatureFailure)
+	}
+	return nil
+}
+
+// formatSize returns bytes in a human-readable form, e.g., 3.2 MiB
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,196 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/appdir"
+	"github.com/urfave/cli/v2"
+)
+
+// Flags of the init subcommand
+var initFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "name",
+		Usage: "Name of the application (default: name of the executable)",
+	},
+	&cli.StringFlag{
+		Name:  "comment",
+		Usage: "Short description of the application",
+	},
+	&cli.StringFlag{
+		Name:  "categories",
+		Usage: "Semicolon-separated desktop menu categories, e.g., Graphics;Viewer (default: Utility)",
+	},
+	&cli.StringFlag{
+		Name:  "icon",
+		Usage: "PNG, JPEG or SVG image to use as the icon, scaled to the required sizes (default: a placeholder)",
+	},
+	&cli.BoolFlag{
+		Name:  "terminal",
+		Usage: "The application runs in a terminal",
+	},
+	&cli.BoolFlag{
+		Name:  "deploy",
+		Usage: "Deploy the dependencies into the AppDir right away, using the global deploy flags",
+	},
+}
+
+// bootstrapInit creates an AppDir from a plain executable, asking for what is not given
+// as flags if running in a terminal
+//
+//	Args: c: cli.Context
+func bootstrapInit(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		log.Fatal("Please specify the path to an executable and optionally the AppDir to create, e.g., " +
+			filepath.Base(os.Args[0]) + " init --icon myapp.png build/myapp MyApp.AppDir")
+	}
+	executable := c.Args().Get(0)
+	if helpers.IsDirectory(executable) || helpers.Exists(executable) == false {
+		log.Fatal(executable + " is not a file")
+	}
+	name := filepath.Base(executable)
+
+	prompter := newPrompter()
+	entry := appdir.DesktopEntry{
+		Name:     prompter.ask("Name of the application", c.String("name"), strings.Title(name)),
+		Exec:     name,
+		Icon:     name,
+		Comment:  prompter.ask("Short description", c.String("comment"), ""),
+		Terminal: c.Bool("terminal"),
+	}
+	for _, category := range strings.Split(prompter.ask("Categories", c.String("categories"), "Utility"), ";") {
+		if category != "" {
+			entry.Categories = append(entry.Categories, category)
+		}
+	}
+	icon := prompter.ask("Icon (PNG, JPEG or SVG; empty for a placeholder)", c.String("icon"), "")
+
+	path := c.Args().Get(1)
+	if path == "" {
+		path = strings.Replace(entry.Name, " ", "_", -1) + ".AppDir"
+	}
+	if helpers.Exists(path) {
+		log.Fatal(path + " already exists")
+	}
+
+	log.Println("Creating", path+"...")
+	ad, err := appdir.Create(path)
+	if err == nil {
+		_, err = ad.AddExecutable(executable)
+	}
+	if err == nil {
+		err = ad.AddDesktopEntry(entry)
+	}
+	if err == nil {
+		err = addInitIcon(ad, name, icon)
+	}
+	if err != nil {
+		helpers.PrintError("Could not create the AppDir", err)
+		os.Exit(1)
+	}
+
+	desktopFile := ad.Path + "/usr/share/applications/" + name + ".desktop"
+	if c.Bool("deploy") {
+		setDeployOptions(c)
+		AppDirDeploy(desktopFile)
+		return nil
+	}
+	log.Println("Created", path+", put the files the application needs below usr/ and then run")
+	log.Println(filepath.Base(os.Args[0]), "deploy", desktopFile)
+	return nil
+}
+
+// addInitIcon adds the image at icon in all sizes as the icon with the given name,
+// or a placeholder if icon is empty
+func addInitIcon(ad appdir.AppDir, name string, icon string) error {
+	if icon != "" {
+		return ad.AddIconInAllSizes(name, icon)
+	}
+	log.Println("WARNING: Using a placeholder icon, use --icon to supply a proper one")
+	tmp, err := ioutil.TempFile("", "icon*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = png.Encode(tmp, placeholderIcon(256))
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	return ad.AddIconInAllSizes(name, tmp.Name())
+}
+
+// placeholderIcon returns a grey rounded square
+func placeholderIcon(size int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	radius := size / 8
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Distance from the nearest corner circle center, if in a corner
+			dx, dy := 0, 0
+			if x < radius {
+				dx = radius - x
+			} else if x >= size-radius {
+				dx = x - (size - radius - 1)
+			}
+			if y < radius {
+				dy = radius - y
+			} else if y >= size-radius {
+				dy = y - (size - radius - 1)
+			}
+			if dx*dx+dy*dy <= radius*radius {
+				img.Set(x, y, color.NRGBA{0x88, 0x8a, 0x85, 0xff})
+			}
+		}
+	}
+	return img
+}
+
+// prompter asks the user for values that were not given as flags,
+// but only if running in a terminal and not with --ci
+type prompter struct {
+	reader *bufio.Reader
+}
+
+func newPrompter() prompter {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 || ciMode {
+		return prompter{}
+	}
+	return prompter{reader: bufio.NewReader(os.Stdin)}
+}
+
+// ask returns given if it is not empty, otherwise asks the question,
+// returning fallback if the answer is empty or if not running in a terminal
+func (p prompter) ask(question string, given string, fallback string) string {
+	if given != "" || p.reader == nil {
+		if given == "" {
+			return fallback
+		}
+		return given
+	}
+	if fallback != "" {
+		fmt.Print(question + " [" + fallback + "]: ")
+	} else {
+		fmt.Print(question + ": ")
+	}
+	answer, _ := p.reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return fallback
+	}
+	return answer
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/probonopd/go-appimage/internal/elfsection"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/zsync"
+	"github.com/probonopd/go-appimage/src/goappimage"
+	"github.com/urfave/cli/v2"
+)
+
+// inspectionCommands returns the commands that only look at existing AppImages. Unlike the deployment,
+// which only builds on Linux, they are also available on macOS and Windows (see main_other.go),
+// so that maintainers can inspect and verify the AppImages that users send them
+func inspectionCommands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:   "info",
+			Usage:  "Print the type, architecture, update information, signature status, desktop entry, and payload of an AppImage",
+			Flags:  infoFlags,
+			Action: bootstrapInfo,
+		},
+		{
+			Name:   "extract",
+			Usage:  "Extract the files of an AppImage into a directory (squashfs-root by default) without running it",
+			Action: bootstrapExtract,
+		},
+		{
+			Name:   "verify",
+			Usage:  "Check the digest and signature of an AppImage natively, or an AppDir against its deployment manifest, exiting with an error if it was modified",
+			Action: bootstrapVerify,
+		},
+		{
+			Name:   "updateinfo",
+			Usage:  "Print the update information of an AppImage, or replace it in place if given as the second argument",
+			Action: bootstrapUpdateInfo,
+		},
+		{
+			Name:   "zsync",
+			Usage:  "Check using the .zsync file given by its update information whether an AppImage is the most recent one",
+			Action: bootstrapZsync,
+		},
+	}
+}
+
+// bootstrapExtract extracts the files of an AppImage like --appimage-extract does,
+// but without running the AppImage, which is not possible on other systems than Linux
+//
+//	Args: c: cli.Context
+func bootstrapExtract(c *cli.Context) error {
+	if c.NArg() != 1 && c.NArg() != 2 {
+		log.Fatal("Please specify the path to an AppImage, and optionally the directory to extract it into")
+	}
+	destination := "squashfs-root"
+	if c.NArg() == 2 {
+		destination = c.Args().Get(1)
+	}
+	err := extractAppImage(c.Args().Get(0), destination)
+	if err != nil {
+		helpers.PrintError("extract", err)
+		os.Exit(1)
+	}
+	fmt.Println("Extracted", c.Args().Get(0), "into", destination)
+	return nil
+}
+
+// extractAppImage extracts all files of the AppImage at path into the directory destination
+func extractAppImage(path string, destination string) error {
+	ai, err := goappimage.NewAppImage(path)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(destination, 0755)
+	if err != nil {
+		return err
+	}
+	for _, name := range ai.ListFiles("/") {
+		err = ai.ExtractFile(name, destination, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapZsync checks whether an AppImage is the most recent one by comparing it with the
+// .zsync file that its update information points to, exiting with an error if it is not
+//
+//	Args: c: cli.Context
+func bootstrapZsync(c *cli.Context) error {
+	if c.NArg() != 1 {
+		log.Fatal("Please specify the path to an AppImage")
+	}
+	path := c.Args().Get(0)
+	updateinformation, err := elfsection.ReadString(path, ".upd_info")
+	if err != nil || updateinformation == "" {
+		fmt.Println(path, "does not contain update information")
+		os.Exit(1)
+	}
+	ui, err := helpers.NewUpdateInformationFromString(updateinformation)
+	if err != nil {
+		helpers.PrintError("zsync", err)
+		os.Exit(1)
+	}
+	zsyncURL, version, err := helpers.GetZsyncURL(ui)
+	if err != nil {
+		helpers.PrintError("zsync", err)
+		os.Exit(1)
+	}
+	fmt.Println("Update information:", updateinformation)
+	fmt.Println("zsync file:        ", zsyncURL)
+	control, err := zsync.Fetch(zsyncURL)
+	if err != nil {
+		helpers.PrintError("zsync", err)
+		os.Exit(1)
+	}
+	if control.UpToDate(path) {
+		fmt.Println(filepath.Base(path), "is up to date")
+		return nil
+	}
+	fmt.Println(filepath.Base(path), "is outdated, the most recent version is", version, "("+formatSize(control.Length)+")")
+	os.Exit(1)
+	return nil
+}
@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// scriptingInterpreter describes an interpreter the module tree of which
+// needs to be bundled along with it
+type scriptingInterpreter struct {
+	name        string         // Name of the interpreter on the $PATH
+	elfs        *regexp.Regexp // Names of the ELFs that indicate that the interpreter is bundled
+	searchPath  []string       // Command that prints the module search path, one directory per line
+	environment string         // Environment variable that AppRun needs to set to the module search path
+}
+
+var scriptingInterpreters = []scriptingInterpreter{
+	{
+		name:        "perl",
+		elfs:        regexp.MustCompile(`^(perl|perl5\.[0-9.]+|libperl\.so.*)$`),
+		searchPath:  []string{"perl", "-e", `print join("\n", @INC)`},
+		environment: "PERL5LIB",
+	},
+	{
+		name:        "ruby",
+		elfs:        regexp.MustCompile(`^(ruby|ruby[0-9.]+|libruby.*\.so.*)$`),
+		searchPath:  []string{"ruby", "-e", `puts $LOAD_PATH`},
+		environment: "RUBYLIB",
+	},
+}
+
+// handleScriptingInterpreters bundles the module trees (including XS modules and
+// native extensions, the rpaths of which get patched like those of all other ELFs)
+// of Perl and Ruby if either is bundled, and exports the variable that makes the
+// interpreter use them in AppRun
+func handleScriptingInterpreters(ctx *deployContext) {
+	appdir := ctx.appdir
+	for _, interpreter := range scriptingInterpreters {
+		if isInterpreterBundled(ctx, interpreter) == false {
+			continue
+		}
+		if helpers.IsCommandAvailable(interpreter.name) == false {
+			log.Println("WARNING:", interpreter.name, "is bundled but not on the $PATH, cannot determine its module tree")
+			continue
+		}
+		out, err := exec.Command(interpreter.searchPath[0], interpreter.searchPath[1:]...).Output()
+		if err != nil {
+			helpers.PrintError("Could not determine the module search path of "+interpreter.name, err)
+			continue
+		}
+
+		var dirs []string
+		for _, dir := range strings.Split(string(out), "\n") {
+			dir = strings.TrimSpace(dir)
+			if filepath.IsAbs(dir) == false || helpers.IsDirectory(dir) == false {
+				continue
+			}
+			log.Println("Bundling", interpreter.name, "modules in", dir+"...")
+			if strings.HasPrefix(dir, appdir.Path) == false {
+				err = helpers.CopyTree(dir, appdir.Path+dir)
+				if err != nil {
+					helpers.PrintError("Could not copy "+dir, err)
+					continue
+				}
+				determineELFsInDirTree(ctx, dir)
+			}
+			dirs = append(dirs, "${HERE}"+strings.TrimPrefix(dir, appdir.Path))
+		}
+
+		if len(dirs) > 0 {
+			addAppRunSection(ctx, "Use bundled "+strings.Title(interpreter.name)+" modules",
+				"apprun_export "+interpreter.environment+` "`+strings.Join(dirs, ":")+`" prepend`)
+		}
+	}
+}
+
+// isInterpreterBundled returns true if the interpreter itself or
+// the library that embeds it are among the ELFs to be bundled
+func isInterpreterBundled(ctx *deployContext, interpreter scriptingInterpreter) bool {
+	for _, lib := range ctx.elfs {
+		if interpreter.elfs.MatchString(filepath.Base(lib)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"debug/elf"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Name of the file next to AppRun from which the compiled AppRun launcher reads the environment
+const appRunEnvFileName = "AppRun.env"
+
+// appRunVariable is an environment variable set by the compiled AppRun launcher.
+// ${HERE} in value is replaced by the AppDir at runtime
+type appRunVariable struct {
+	name   string
+	value  string
+	policy string // One of envPolicies, the default for variables that are not set by the launcher itself
+}
+
+// Names of the compiled AppRun launchers for the architectures of the main executable.
+// They are built from src/apprun and expected on the $PATH or next to appimagetool
+var appRunLaunchers = map[elf.Machine]string{
+	elf.EM_X86_64:  "apprun-amd64",
+	elf.EM_386:     "apprun-386",
+	elf.EM_AARCH64: "apprun-arm64",
+	elf.EM_ARM:     "apprun-arm",
+}
+
+// writeCompiledAppRun puts the compiled AppRun launcher into the AppDir together with
+// AppRun.env, which describes the environment the shell script AppRun would set up.
+// Since the launcher cannot run shell code, the shell script is used instead if
+// sections have been added to it depending on what was bundled
+func writeCompiledAppRun(ctx *deployContext) error {
+	appdir := ctx.appdir
+	if len(ctx.appRunSections) > 0 {
+		log.Println("WARNING: Using the shell script AppRun rather than the compiled one because it needs:")
+		for _, section := range ctx.appRunSections {
+			log.Println("    " + strings.TrimPrefix(strings.Split(section, "\n")[1], "# "))
+		}
+		return ioutil.WriteFile(appdir.Path+"/AppRun", []byte(generateAppRun(ctx)), 0755)
+	}
+
+	launcher, err := findAppRunLauncher(appdir.MainExecutable)
+	if err != nil {
+		return err
+	}
+	log.Println("Adding compiled AppRun from", launcher+"...")
+	// Do not follow a pre-existing AppRun symlink when writing
+	os.Remove(appdir.Path + "/AppRun")
+	err = helpers.CopyFile(launcher, appdir.Path+"/AppRun")
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(appdir.Path+"/AppRun", 0755)
+	if err != nil {
+		return err
+	}
+
+	data := "# Environment for AppRun, generated by appimagetool\n"
+	for _, v := range getAppRunEnvironment(appdir) {
+		if policy, ok := options.envPolicies[v.name]; ok {
+			v.policy = policy
+		}
+		if v.policy != "" {
+			data = data + v.policy + " "
+		}
+		data = data + v.name + "=" + v.value + "\n"
+	}
+	return ioutil.WriteFile(appdir.Path+"/"+appRunEnvFileName, []byte(data), 0644)
+}
+
+// findAppRunLauncher returns the path to the compiled AppRun launcher
+// for the architecture of the ELF at path
+func findAppRunLauncher(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	name, ok := appRunLaunchers[f.Machine]
+	if ok == false {
+		return "", errors.New("no compiled AppRun available for " + f.Machine.String())
+	}
+	launcher, err := exec.LookPath(name)
+	if err == nil {
+		return launcher, nil
+	}
+	self, err := os.Executable()
+	if err == nil && helpers.Exists(filepath.Dir(self)+"/"+name) {
+		return filepath.Dir(self) + "/" + name, nil
+	}
+	return "", errors.New(name + " not found on the $PATH or next to appimagetool")
+}
+
+// getAppRunEnvironment returns the environment that the compiled AppRun launcher sets up.
+// It is the same as the one set up by the shell script AppRun, but what the script
+// finds at runtime is determined here at deployment time
+func getAppRunEnvironment(appdir helpers.AppDir) []appRunVariable {
+	vars := []appRunVariable{
+		{"PATH", "${HERE}/usr/bin/:${HERE}/usr/sbin/:${HERE}/usr/games/:${HERE}/bin/:${HERE}/sbin/", envPolicyPrepend},
+		{"XDG_DATA_DIRS", "${HERE}/usr/share/", envPolicyPrepend},
+		{"PYTHONHOME", "${HERE}/usr/", envPolicyReplace},
+	}
+	if helpers.Exists(appdir.Path + "/usr/share/tcltk/tcl8.6") {
+		vars = append(vars,
+			appRunVariable{"TCL_LIBRARY", "${HERE}/usr/share/tcltk/tcl8.6:${TCL_LIBRARY}:${TK_LIBRARY}", envPolicyReplace},
+			appRunVariable{"TK_LIBRARY", "${HERE}/usr/share/tcltk/tk8.6:${TK_LIBRARY}:${TCL_LIBRARY}", envPolicyReplace})
+	}
+	// The shell script also sets QT_QPA_PLATFORMTHEME=gtk2 on GNOME; this is left to the system here
+
+	gstCoreElements := findFirstInAppDir(appdir, "libgstcoreelements.so")
+	if gstCoreElements != "" {
+		vars = append(vars,
+			appRunVariable{"GST_PLUGIN_PATH", "${HERE}/" + filepath.Dir(gstCoreElements), envPolicyReplace},
+			appRunVariable{"GST_PLUGIN_SCANNER", "${HERE}/" + findFirstInAppDir(appdir, "gst-plugin-scanner"), envPolicyReplace},
+			appRunVariable{"GST_PLUGIN_SYSTEM_PATH", "${HERE}/" + filepath.Dir(gstCoreElements), envPolicyReplace},
+			appRunVariable{"GST_REGISTRY", "${XDG_CACHE_HOME:-${HOME}/.cache}/" + getGstRegistryName(appdir), envPolicyReplace})
+	}
+
+	vars = append(vars, appRunVariable{"APPRUN_EXEC", "${HERE}/" + strings.TrimPrefix(appdir.MainExecutable, appdir.Path+"/"), ""})
+
+	// Run the experimental bundle that bundles everything if a private ld-linux is there
+	ldLinux := findFirstInAppDir(appdir, "ld-*.so.*")
+	if ldLinux == "" {
+		return vars
+	}
+	vars = append(vars,
+		appRunVariable{"APPRUN_INTERPRETER", "${HERE}/" + ldLinux, ""},
+		appRunVariable{"GCONV_PATH", "${HERE}/usr/lib/gconv", envPolicyReplace})
+	if helpers.IsDirectory(appdir.Path+"/usr/lib/locale/C.UTF-8") || helpers.IsDirectory(appdir.Path+"/usr/lib/locale/C.utf8") {
+		vars = append(vars, appRunVariable{"LOCPATH", "${HERE}/usr/lib/locale", envPolicyReplace})
+	}
+	vars = append(vars,
+		appRunVariable{"FONTCONFIG_FILE", "${HERE}/etc/fonts/fonts.conf", envPolicyReplace},
+		appRunVariable{"GTK_EXE_PREFIX", "${HERE}/usr", envPolicyReplace},
+		appRunVariable{"GTK_THEME", "Default", envPolicyReplace})
+	if loaders := findFirstInAppDir(appdir, "loaders.cache"); loaders != "" {
+		vars = append(vars,
+			appRunVariable{"GDK_PIXBUF_MODULEDIR", "${HERE}/" + filepath.Dir(loaders) + "/loaders", envPolicyReplace},
+			appRunVariable{"GDK_PIXBUF_MODULE_FILE", "${HERE}/" + loaders, envPolicyReplace})
+	}
+	vars = append(vars,
+		appRunVariable{"GSETTINGS_SCHEMA_DIR", "${HERE}/usr/share/glib-2.0/runtime-schemas/:${HERE}/usr/share/glib-2.0/schemas/", envPolicyPrepend})
+	var qtPluginPath []string
+	for _, dir := range []string{"usr/lib", "usr/lib/i386-linux-gnu", "usr/lib/x86_64-linux-gnu", "usr/lib32", "usr/lib64"} {
+		for _, qt := range []string{"qt4", "qt5"} {
+			if helpers.IsDirectory(appdir.Path + "/" + dir + "/" + qt + "/plugins") {
+				qtPluginPath = append(qtPluginPath, "${HERE}/"+dir+"/"+qt+"/plugins/")
+			}
+		}
+	}
+	if len(qtPluginPath) == 0 {
+		return vars
+	}
+	return append(vars, appRunVariable{"QT_PLUGIN_PATH", strings.Join(qtPluginPath, ":"), envPolicyPrepend})
+}
+
+// findFirstInAppDir returns the path relative to the AppDir of the first regular file
+// whose name matches pattern, or an empty string if there is none
+func findFirstInAppDir(appdir helpers.AppDir, pattern string) string {
+	var found string
+	filepath.Walk(appdir.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || found != "" {
+			return nil
+		}
+		if info.Mode().IsRegular() == false {
+			return nil
+		}
+		if matched, _ := filepath.Match(pattern, info.Name()); matched {
+			found = strings.TrimPrefix(path, appdir.Path+"/")
+		}
+		return nil
+	})
+	return found
+}
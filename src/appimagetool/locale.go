@@ -0,0 +1,134 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Location of gettext translations, both on the build system and in the AppDir
+const localeDir = "/usr/share/locale"
+
+// handleLocales bundles the gettext translations of the application, i.e., only the .mo files
+// for the gettext domains that the executables in the AppDir actually use,
+// rather than all of usr/share/locale which can easily be hundreds of MB.
+// If options.locales is set, then only translations for those locales are bundled
+// and translations for other locales already in the AppDir are removed.
+// AppRun has to export TEXTDOMAINDIR (and LOCPATH for bundled locale definitions) for this to work
+func handleLocales(ctx *deployContext) {
+	appdir := ctx.appdir
+	domains := getGettextDomains(ctx)
+	if len(domains) > 0 {
+		log.Println("Gettext domains used by the application:", domains)
+	}
+
+	for _, domain := range domains {
+		mos, _ := filepath.Glob(localeDir + "/*/LC_MESSAGES/" + domain + ".mo")
+		for _, mo := range mos {
+			locale := strings.Split(strings.TrimPrefix(mo, localeDir+"/"), "/")[0]
+			if isLocaleWanted(locale) == false {
+				continue
+			}
+			if helpers.Exists(appdir.Path+mo) == true {
+				continue
+			}
+			err := helpers.CopyFile(mo, appdir.Path+mo)
+			if err != nil {
+				helpers.PrintError("Could not copy translation", err)
+			}
+		}
+	}
+
+	// Remove translations for locales that were not requested
+	if len(options.locales) > 0 {
+		infos, _ := ioutil.ReadDir(appdir.Path + localeDir)
+		for _, info := range infos {
+			if info.IsDir() && isLocaleWanted(info.Name()) == false {
+				log.Println("Removing translations for", info.Name(), "because it was not requested")
+				err := os.RemoveAll(appdir.Path + localeDir + "/" + info.Name())
+				if err != nil {
+					helpers.PrintError("Could not remove translations", err)
+				}
+			}
+		}
+		deployLocaleDefinitions(appdir)
+	}
+
+	if len(helpers.FilesWithSuffixInDirectoryRecursive(appdir.Path+localeDir, ".mo")) > 0 {
+		addAppRunSection(ctx, "Use bundled translations", `apprun_export TEXTDOMAINDIR "${HERE}/usr/share/locale/" replace`)
+	}
+}
+
+// getGettextDomains returns the gettext domains available on the build system
+// that are referenced by the executables in usr/bin of the AppDir.
+// Applications pass their domain as a string literal to textdomain() and bindtextdomain(),
+// hence we look for the names of all domains known to the system in the executables
+func getGettextDomains(ctx *deployContext) []string {
+	appdir := ctx.appdir
+	var known []string
+	mos, _ := filepath.Glob(localeDir + "/*/LC_MESSAGES/*.mo")
+	for _, mo := range mos {
+		known = helpers.AppendIfMissing(known, strings.TrimSuffix(filepath.Base(mo), ".mo"))
+	}
+
+	var domains []string
+	executables, _ := findAllExecutablesAndLibraries(ctx, appdir.Path+"/usr/bin")
+	for _, executable := range executables {
+		m, err := fsys.Map(fsys.OS, executable)
+		if err != nil {
+			continue
+		}
+		m.Access(func(data []byte) {
+			for _, domain := range known {
+				if bytes.Contains(data, []byte("\x00"+domain+"\x00")) {
+					domains = helpers.AppendIfMissing(domains, domain)
+				}
+			}
+		})
+		m.Close()
+	}
+	return domains
+}
+
+// isLocaleWanted returns true if translations for locale should be bundled.
+// This is the case if no locales were requested at all, or if locale matches one
+// of the requested locales, e.g., "de_AT" and "de@hebrew" match the requested "de"
+func isLocaleWanted(locale string) bool {
+	if len(options.locales) == 0 {
+		return true
+	}
+	for _, wanted := range options.locales {
+		if locale == wanted ||
+			strings.HasPrefix(locale, wanted+"_") ||
+			strings.HasPrefix(locale, wanted+".") ||
+			strings.HasPrefix(locale, wanted+"@") {
+			return true
+		}
+	}
+	return false
+}
+
+// deployLocaleDefinitions copies the compiled glibc locale definitions for the
+// requested locales, if present on the build system, so that a bundled glibc
+// can switch to them using LOCPATH
+func deployLocaleDefinitions(appdir helpers.AppDir) {
+	infos, _ := ioutil.ReadDir(glibcLocaleDir)
+	for _, info := range infos {
+		if info.IsDir() && isLocaleWanted(info.Name()) {
+			log.Println("Bundling locale definition", info.Name())
+			err := helpers.CopyTree(glibcLocaleDir+"/"+info.Name(), appdir.Path+glibcLocaleDir+"/"+info.Name())
+			if err != nil {
+				helpers.PrintError("Could not copy locale definition", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/probonopd/go-appimage/internal/clidoc"
+	"github.com/urfave/cli/v2"
+)
+
+// main is the entrypoint on systems other than Linux, where AppImages cannot be built
+// but can be inspected and verified, see inspectionCommands
+func main() {
+	app := &cli.App{
+		Name:                 "appimagetool",
+		Authors:              []*cli.Author{{Name: "AppImage Project"}},
+		Version:              appimagetoolVersion(),
+		Usage:                "Inspect and verify AppImages (building them is only possible on Linux)",
+		EnableBashCompletion: true,
+		Copyright:            "MIT License",
+	}
+	app.Commands = append(inspectionCommands(), clidoc.Commands()...)
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// verifyAppDirAndExit exits since AppDirs are deployed on Linux, hence they can only be verified there
+func verifyAppDirAndExit(path string) {
+	log.Println("AppDirs can only be verified on Linux, please give the path to an AppImage")
+	os.Exit(1)
+}
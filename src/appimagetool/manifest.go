@@ -0,0 +1,354 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Name of the file in the top-level directory of the AppDir that records what was deployed.
+// Unlike the deployment cache, it is put into the AppImage so that it can be audited
+const deploymentManifestName = ".appdirtool-manifest.json"
+
+// Version of the format of the deployment manifest, increased when it changes incompatibly
+const deploymentManifestVersion = 2
+
+// How many paths are passed to one invocation of dpkg -S or rpm -qf
+const packageQueryBatchSize = 500
+
+// deploymentManifest records every file in the AppDir after the deployment
+type deploymentManifest struct {
+	Version int                       `json:"version"`
+	Files   []deploymentManifestEntry `json:"files"`             // Sorted by path
+	Patches []appliedPatch            `json:"patches,omitempty"` // Applied to the AppDir, in order
+}
+
+// deploymentManifestEntry describes one file or symlink in the AppDir
+type deploymentManifestEntry struct {
+	Path    string `json:"path"`              // Relative to the AppDir
+	Target  string `json:"target,omitempty"`  // Of a symlink
+	Mode    string `json:"mode,omitempty"`    // Permissions of a file, e.g., "0755"
+	SHA256  string `json:"sha256,omitempty"`  // Of a file
+	Source  string `json:"source,omitempty"`  // Where on the build system it was copied from, if known
+	Package string `json:"package,omitempty"` // Package that owns the source, if known
+	Rpath   string `json:"rpath,omitempty"`   // Rpath that was written into an ELF
+	ELF     string `json:"elf,omitempty"`     // How an ELF is linked, e.g., "pie" or "static (Go)"
+
+	// Since version 2, so that clean can revert the deployment and the patches can be audited
+	Added               bool    `json:"added,omitempty"`               // Was not in the AppDir before it was first deployed
+	OriginalRpath       *string `json:"originalRpath,omitempty"`       // Rpath of an ELF before the deployment patched it, empty if it had none
+	OriginalInterpreter string  `json:"originalInterpreter,omitempty"` // PT_INTERP of an ELF before the deployment patched it
+}
+
+// elfBackup records what an ELF in the AppDir contained before the deployment patched it
+type elfBackup struct {
+	rpath       string
+	interpreter string
+}
+
+// recordOriginalFiles records which files were in the AppDir before it was first deployed, so that the
+// manifest can tell them from the files that the deployment adds. If the AppDir was deployed before,
+// the files that deployment added and the backups of the ELFs it patched are taken from its manifest
+func recordOriginalFiles(ctx *deployContext) error {
+	appdir := ctx.appdir
+	ctx.originalFiles = make(map[string]bool)
+	ctx.elfBackups = make(map[string]elfBackup)
+	ctx.backedUpELFs = make(map[string]bool)
+	added := make(map[string]bool)
+	manifest, err := readDeploymentManifest(appdir.Path)
+	if err == nil && manifest.Version < 2 {
+		log.Println("NOTE:", deploymentManifestName, "was written by an older version of appimagetool, hence all files in the AppDir are considered to be part of it before the deployment")
+	}
+	for _, entry := range manifest.Files {
+		if entry.Added {
+			added[entry.Path] = true
+		}
+		if entry.OriginalRpath != nil {
+			ctx.elfBackups[entry.Path] = elfBackup{rpath: *entry.OriginalRpath, interpreter: entry.OriginalInterpreter}
+		}
+	}
+	return filepath.Walk(appdir.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relpath, _ := filepath.Rel(appdir.Path, path)
+		if info.IsDir() || added[relpath] || relpath == deploymentManifestName || relpath == deployCacheFileName {
+			return nil
+		}
+		ctx.originalFiles[relpath] = true
+		return nil
+	})
+}
+
+// backUpELF records the rpath and interpreter of the ELF at path in the AppDir before it gets patched the first time,
+// so that clean can restore the ELFs that were in the AppDir before, and the manifest shows what was changed.
+// ELFs that were copied into the AppDir are backed up again whenever they are copied anew
+func backUpELF(ctx *deployContext, path string) {
+	appdir := ctx.appdir
+	relpath := strings.TrimPrefix(path, appdir.Path+"/")
+	if ctx.elfBackups == nil || ctx.backedUpELFs[relpath] {
+		return
+	}
+	if _, ok := ctx.elfBackups[relpath]; ok && ctx.originalFiles[relpath] {
+		// Already patched by a previous deployment, which backed it up
+		return
+	}
+	rpath, err := helpers.ReadElfRpathFS(appdirFS, path)
+	if err != nil {
+		return
+	}
+	backup := elfBackup{rpath: rpath}
+	if info, err := elfdeps.Classify(appdirFS, path); err == nil {
+		backup.interpreter = info.Interpreter
+	}
+	ctx.elfBackups[relpath] = backup
+	ctx.backedUpELFs[relpath] = true
+}
+
+// writeDeploymentManifest records every file in the AppDir in deploymentManifestName.
+// The sources and rpaths of the ELFs come from the deployment cache. Other files are assumed to have been
+// copied from the same path on the build system if a file with the same contents is there.
+// The packages owning the sources are looked up with dpkg or rpm, except for the files
+// whose source and contents are unchanged since the previous deployment
+func writeDeploymentManifest(ctx *deployContext, cache *deployCache) error {
+	appdir := ctx.appdir
+	log.Println("Writing", deploymentManifestName+"...")
+	previous := make(map[string]deploymentManifestEntry) // Key: path relative to the AppDir
+	old, err := readDeploymentManifest(appdir.Path)
+	if err == nil {
+		for _, entry := range old.Files {
+			previous[entry.Path] = entry
+		}
+	}
+
+	// Key: path of the ELF in the AppDir
+	deployed := make(map[string]string)
+	rpaths := make(map[string]string)
+	for path, entry := range cache.Files {
+		target := getTargetPathInAppDir(appdir, path)
+		if target != path {
+			deployed[target] = path
+		}
+		rpaths[target] = entry.Rpath
+	}
+
+	manifest := deploymentManifest{Version: deploymentManifestVersion, Patches: ctx.appliedPatches}
+	var unknownPackages []string
+	err = filepath.Walk(appdir.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relpath, _ := filepath.Rel(appdir.Path, path)
+		if relpath == deploymentManifestName || relpath == deployCacheFileName || info.IsDir() {
+			return nil
+		}
+		entry := deploymentManifestEntry{Path: relpath}
+		if ctx.originalFiles != nil {
+			entry.Added = ctx.originalFiles[relpath] == false
+		}
+		if backup, ok := ctx.elfBackups[relpath]; ok {
+			entry.OriginalRpath = &backup.rpath
+			entry.OriginalInterpreter = backup.interpreter
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			entry.Target, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			manifest.Files = append(manifest.Files, entry)
+			return nil
+		}
+		if info.Mode().IsRegular() == false {
+			return nil
+		}
+		entry.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+		entry.SHA256, err = fsys.SHA256(fsys.OS, path)
+		if err != nil {
+			return err
+		}
+		entry.Rpath = rpaths[path]
+		if info, err := elfdeps.Classify(fsys.OS, path); err == nil {
+			entry.ELF = info.String()
+		}
+		entry.Source = deployed[path]
+		if entry.Source == "" {
+			hostInfo, err := os.Stat("/" + relpath)
+			if err == nil && hostInfo.Mode().IsRegular() && hostInfo.Size() == info.Size() {
+				if hash, err := fsys.SHA256(fsys.OS, "/"+relpath); err == nil && hash == entry.SHA256 {
+					entry.Source = "/" + relpath
+				}
+			}
+		}
+		if entry.Source != "" {
+			old, ok := previous[relpath]
+			if ok && old.Source == entry.Source && old.SHA256 == entry.SHA256 {
+				entry.Package = old.Package
+			} else {
+				unknownPackages = helpers.AppendIfMissing(unknownPackages, entry.Source)
+			}
+		}
+		manifest.Files = append(manifest.Files, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	lookUpOwningPackages(ctx, unknownPackages)
+	for i, entry := range manifest.Files {
+		if entry.Package == "" && entry.Source != "" {
+			manifest.Files[i].Package = ctx.packagesContainingFiles[entry.Source]
+		}
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appdir.Path+"/"+deploymentManifestName, data, 0644)
+}
+
+// readDeploymentManifest reads the deployment manifest from the AppDir at appdirPath
+func readDeploymentManifest(appdirPath string) (deploymentManifest, error) {
+	var manifest deploymentManifest
+	data, err := ioutil.ReadFile(appdirPath + "/" + deploymentManifestName)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	if err == nil && manifest.Version > deploymentManifestVersion {
+		err = errors.New(deploymentManifestName + " was written by a newer version of appimagetool")
+	}
+	return manifest, err
+}
+
+// lookUpOwningPackages records the packages that own the files at paths in ctx.packagesContainingFiles,
+// using dpkg -S or rpm -qf, whichever is available. Files that no package owns are left out
+func lookUpOwningPackages(ctx *deployContext, paths []string) {
+	var todo []string
+	for _, path := range paths {
+		if _, ok := ctx.packagesContainingFiles[path]; ok == false {
+			todo = append(todo, path)
+		}
+	}
+	for start := 0; start < len(todo); start += packageQueryBatchSize {
+		end := start + packageQueryBatchSize
+		if end > len(todo) {
+			end = len(todo)
+		}
+		batch := todo[start:end]
+		if helpers.IsCommandAvailable("dpkg") {
+			// Prints "package[:arch][, ...]: path" for the files it knows, and fails if it does not know one of them
+			out, _ := exec.Command("dpkg", append([]string{"-S"}, batch...)...).Output()
+			scanner := bufio.NewScanner(bytes.NewReader(out))
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.HasPrefix(line, "diversion ") {
+					continue
+				}
+				parts := strings.SplitN(line, ": ", 2)
+				if len(parts) == 2 {
+					pkg := strings.Split(strings.Split(parts[0], ", ")[0], ":")[0]
+					ctx.packagesContainingFiles[parts[1]] = pkg
+				}
+			}
+		} else if helpers.IsCommandAvailable("rpm") {
+			// Prints one line for each path, in the same order
+			out, _ := exec.Command("rpm", append([]string{"-qf", "--queryformat", "%{NAME}\\n"}, batch...)...).Output()
+			lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+			if len(lines) != len(batch) {
+				continue
+			}
+			for i, line := range lines {
+				if strings.Contains(line, " ") == false && line != "" {
+					ctx.packagesContainingFiles[batch[i]] = line
+				}
+			}
+		}
+	}
+}
+
+// verifyAppDir compares the files in the AppDir at appdirPath with its deployment manifest
+// and returns the files that were modified, removed or added since the deployment
+func verifyAppDir(appdirPath string) (modified []string, removed []string, added []string, err error) {
+	manifest, err := readDeploymentManifest(appdirPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	listed := make(map[string]bool)
+	for _, entry := range manifest.Files {
+		listed[entry.Path] = true
+		path := appdirPath + "/" + entry.Path
+		info, err := os.Lstat(path)
+		if err != nil {
+			removed = append(removed, entry.Path)
+			continue
+		}
+		if entry.SHA256 == "" {
+			target, err := os.Readlink(path)
+			if err != nil || target != entry.Target {
+				modified = append(modified, entry.Path)
+			}
+			continue
+		}
+		hash, err := fsys.SHA256(fsys.OS, path)
+		if info.Mode().IsRegular() == false || err != nil || hash != entry.SHA256 ||
+			fmt.Sprintf("%04o", info.Mode().Perm()) != entry.Mode {
+			modified = append(modified, entry.Path)
+		}
+	}
+	err = filepath.Walk(appdirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relpath, _ := filepath.Rel(appdirPath, path)
+		if info.IsDir() || listed[relpath] || relpath == deploymentManifestName || relpath == deployCacheFileName {
+			return nil
+		}
+		added = append(added, relpath)
+		return nil
+	})
+	return modified, removed, added, err
+}
+
+// verifyAppDirAndExit prints the files of the AppDir at path that were modified, removed or added
+// since the deployment and exits with an error if files were modified or removed
+func verifyAppDirAndExit(path string) {
+	modified, removed, added, err := verifyAppDir(path)
+	if err != nil {
+		helpers.PrintError("verify", err)
+		os.Exit(1)
+	}
+	for _, file := range modified {
+		fmt.Println("Modified:", file)
+	}
+	for _, file := range removed {
+		fmt.Println("Removed:", file)
+	}
+	for _, file := range added {
+		fmt.Println("Added:", file)
+	}
+	if len(modified) > 0 || len(removed) > 0 {
+		fmt.Println(path, "was modified since it was deployed")
+		os.Exit(exitInvalidAppDir)
+	}
+	fmt.Println(path, "matches", deploymentManifestName)
+	os.Exit(0)
+}
@@ -0,0 +1,145 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+)
+
+// Policies for libraries that cannot be found, selected with --missing
+const (
+	missingPolicyFail   = "fail"   // Report and abort before anything gets copied
+	missingPolicyWarn   = "warn"   // Report and continue
+	missingPolicyIgnore = "ignore" // Continue silently
+)
+
+var missingPolicies = []string{missingPolicyFail, missingPolicyWarn, missingPolicyIgnore}
+
+// Prefixes of the names of libraries that applications can do without and that are
+// only present on some systems. If they cannot be found, this is noted but not fatal.
+// More can be added with --optional
+var optionalLibraries = []string{
+	"libjack.so",      // JACK audio, only installed by users who do pro audio
+	"libcanberra.so",  // Event sounds
+	"libpipewire-0.3", // Only on recent distributions
+	"libsystemd.so",   // Not on distributions without systemd
+	"libelogind.so",   // Instead of libsystemd on distributions without systemd
+}
+
+// isOptionalLibrary returns true if the library with the given name is on
+// the list of optional libraries or was declared optional using --optional
+func isOptionalLibrary(name string) bool {
+	for _, prefix := range append(optionalLibraries, options.optional...) {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportMissingLibraries prints all libraries that could not be found
+// together with the ELFs that need them, and aborts if the policy says so
+func reportMissingLibraries(ctx *deployContext) {
+	missingLibraries := ctx.walker.Graph.Missing
+	if len(missingLibraries) == 0 || options.missing == missingPolicyIgnore {
+		return
+	}
+
+	var names, optionalNames []string
+	for name := range missingLibraries {
+		if isOptionalLibrary(name) {
+			optionalNames = append(optionalNames, name)
+		} else {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(optionalNames)
+
+	if len(optionalNames) > 0 {
+		log.Println("The following optional libraries could not be found, the AppImage will use them from the target system if they are there:")
+		for _, name := range optionalNames {
+			fmt.Println("    " + name + " (needed by " + strings.Join(missingLibraries[name], ", ") + ")")
+			helpers.RecordDiagnostic(helpers.LevelWarning, name+" (optional) could not be found (needed by "+strings.Join(missingLibraries[name], ", ")+")")
+		}
+		fmt.Println("")
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	log.Println("The following libraries could not be found:")
+	level := "error"
+	if options.missing == missingPolicyWarn {
+		level = "warning"
+	}
+	for _, name := range names {
+		fmt.Println("    " + name + " (needed by " + strings.Join(missingLibraries[name], ", ") + ")")
+		helpers.Annotate(level, name+" could not be found (needed by "+strings.Join(missingLibraries[name], ", ")+")")
+		helpers.RecordDiagnostic(strings.ToUpper(level), name+" could not be found (needed by "+strings.Join(missingLibraries[name], ", ")+")")
+	}
+	fmt.Println("")
+
+	if options.missing == missingPolicyWarn {
+		log.Println("WARNING: Continuing without them because of --missing=" + missingPolicyWarn)
+		return
+	}
+	log.Println("Please install them on the build system, declare them as optional using --optional, or use --missing=" + missingPolicyWarn + " to continue without them")
+	printDiagnosticsSummary()
+	os.Exit(exitUnresolvedDependencies)
+}
+
+// reportMissingVersions prints the ELFs that need symbol versions, e.g., GLIBC_2.34, that the libraries found for them
+// do not define, since ld.so refuses to load them, and aborts if the --missing policy says so
+func reportMissingVersions(ctx *deployContext) {
+	missingVersions := ctx.walker.Graph.MissingVersions
+	if len(missingVersions) == 0 || options.missing == missingPolicyIgnore {
+		return
+	}
+	var paths []string
+	for path := range missingVersions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	log.Println("The following ELFs need symbol versions that the libraries found for them do not define:")
+	level := "error"
+	if options.missing == missingPolicyWarn {
+		level = "warning"
+	}
+	for _, path := range paths {
+		fmt.Println("    " + path + " (needs " + strings.Join(missingVersions[path], ", ") + ")")
+		helpers.Annotate(level, path+" needs "+strings.Join(missingVersions[path], ", ")+", which the libraries found do not define")
+		helpers.RecordDiagnostic(strings.ToUpper(level), path+" needs symbol versions "+strings.Join(missingVersions[path], ", ")+", which the libraries found do not define")
+	}
+	fmt.Println("")
+
+	if options.missing == missingPolicyWarn {
+		log.Println("WARNING: Continuing because of --missing=" + missingPolicyWarn + ", but these ELFs will not load")
+		return
+	}
+	log.Println("Please install newer versions of the libraries on the build system, build on an older system, or use --missing=" + missingPolicyWarn + " to continue anyway")
+	printDiagnosticsSummary()
+	os.Exit(exitUnresolvedDependencies)
+}
+
+// reportCPUSpecificLibraries warns about the libraries that are only on the build system in a variant for newer CPUs,
+// see elfdeps.HwcapsSubdirectories, since the AppImage will not run on older CPUs with them
+func reportCPUSpecificLibraries(ctx *deployContext) {
+	for _, lib := range ctx.elfs {
+		for _, subdirectory := range elfdeps.HwcapsSubdirectories {
+			if strings.HasSuffix(filepath.Dir(lib), "/"+subdirectory) {
+				log.Println("WARNING: Bundling", lib, "which needs a CPU supporting", filepath.Base(subdirectory)+", since there is no variant for all CPUs")
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+)
+
+// Explains what to do if an AppDir with musl executables cannot be deployed
+const muslWorkaround = "Deploy it on a musl system such as Alpine Linux, e.g., in an alpine container with the AppDir mounted, " +
+	"or link the executables statically, in which case no libraries need to be deployed"
+
+// isMuslInterpreter returns true if the ELF interpreter at path is the musl dynamic linker,
+// e.g., /lib/ld-musl-x86_64.so.1, which is also the musl libc
+func isMuslInterpreter(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), "ld-musl-")
+}
+
+// handleMusl prepares the deployment of an AppDir whose main executable was linked against musl,
+// as on Alpine Linux or postmarketOS. Libraries are looked for where the musl dynamic linker looks
+// for them, and all of them are bundled since the excludelist assumes glibc systems, on which
+// musl executables cannot use the libraries from the system. deployInterpreter bundles the
+// musl dynamic linker, which AppRun then uses to run the main executable.
+// Exits with a diagnosis if the AppDir cannot be deployed on this system
+func handleMusl(ctx *deployContext) {
+	appdir := ctx.appdir
+	interpreter, err := appdir.ElfInterpreter()
+	if err != nil || isMuslInterpreter(interpreter) == false {
+		return
+	}
+	log.Println("The main executable was linked against musl, using", interpreter)
+	if helpers.Exists(interpreter) == false {
+		log.Println("ERROR: The musl dynamic linker", interpreter, "is not on this system, hence the libraries the AppDir needs cannot be found.", muslWorkaround)
+		os.Exit(exitUnresolvedDependencies)
+	}
+	if options.libAppRunHooks {
+		log.Println("ERROR: --libapprun_hooks only works with glibc, but the AppDir uses musl")
+		os.Exit(1)
+	}
+	elfs, _ := findAllExecutablesAndLibraries(ctx, appdir.Path)
+	for _, path := range elfs {
+		other := readElfInterpreter(path)
+		if other != "" && isMuslInterpreter(other) == false {
+			log.Println("ERROR:", path, "uses", other, "but the main executable uses", interpreter+",", "and glibc and musl cannot be mixed in one AppDir.", muslWorkaround)
+			os.Exit(exitInvalidAppDir)
+		}
+	}
+	locations := elfdeps.MuslLocations(appdirFS, interpreter)
+	if len(locations) == 0 {
+		log.Println("ERROR: There is no /etc/ld-musl-*.path and no musl sysroot on this glibc system, hence the musl libraries the AppDir needs cannot be found.", muslWorkaround)
+		os.Exit(exitUnresolvedDependencies)
+	}
+	for _, location := range locations {
+		addLibraryLocation(ctx, location, "musl search path")
+	}
+	if options.standalone == false {
+		log.Println("Bundling all libraries, including those on the excludelist, since musl executables cannot use the libraries of glibc systems")
+		options.standalone = true
+	}
+}
+
+// deployMuslInterpreter copies the musl dynamic linker into the same path in the AppDir
+func deployMuslInterpreter(appdir helpers.AppDir, interpreter string) error {
+	log.Println("Deploying", interpreter+"...")
+	src, err := filepath.EvalSymlinks(interpreter)
+	if err != nil {
+		return err
+	}
+	return helpers.CopyTree(src, appdir.Path+interpreter)
+}
+
+// readElfInterpreter returns the ELF interpreter of the ELF at path, or an empty string if it has none
+func readElfInterpreter(path string) string {
+	f, err := elf.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			data, err := ioutil.ReadAll(prog.Open())
+			if err != nil {
+				return ""
+			}
+			return string(bytes.TrimRight(data, "\x00"))
+		}
+	}
+	return ""
+}
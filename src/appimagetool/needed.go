@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Replaces a DT_NEEDED entry of the ELF at path; replaced in tests so that no patchelf is needed
+var replaceNeeded = replaceNeededUsingPatchelf
+
+// rewriteNeededPaths handles the DT_NEEDED entries of the ELF lib, given as in ctx.elfs, that contain
+// a slash, which ld.so takes as paths. The libraries have been deployed to the same paths in the AppDir
+// like all others. Absolute paths are replaced by paths relative to $ORIGIN that point to them there.
+// Relative paths are relative to the working directory when the application runs, hence they are left alone.
+// Note that ld.so ignores $ORIGIN in DT_NEEDED for setuid executables
+func rewriteNeededPaths(ctx *deployContext, lib string) {
+	appdir := ctx.appdir
+	target := getTargetPathInAppDir(appdir, lib)
+	if fsys.Exists(appdirFS, target) == false {
+		// Not deployed, e.g., because it is on the excludelist
+		return
+	}
+	for _, name := range ctx.walker.Graph.Needed[lib] {
+		if strings.Contains(name, "/") == false || strings.Contains(name, "$ORIGIN") || strings.Contains(name, "${ORIGIN}") {
+			continue
+		}
+		if filepath.IsAbs(name) == false {
+			log.Println("WARNING:", target, "needs", name, "relative to the working directory, which only works if the application is run from where it expects")
+			continue
+		}
+		dependency := filepath.Clean(name)
+		if helpers.SliceContains(ctx.elfs, dependency) == false {
+			// Not bundled, e.g., because it is on the excludelist
+			continue
+		}
+		relpath, err := filepath.Rel(filepath.Dir(target), getTargetPathInAppDir(appdir, dependency))
+		if err != nil {
+			helpers.PrintError("Could not compute relative path", err)
+			continue
+		}
+		log.Println("Replacing the absolute path", name, "among the libraries", target, "needs by $ORIGIN/"+relpath)
+		backUpELF(ctx, target)
+		err = replaceNeeded(target, name, "$ORIGIN/"+relpath)
+		if err != nil {
+			helpers.PrintError("Could not replace "+name+" among the libraries "+target+" needs", err)
+			os.Exit(exitPatchFailure)
+		}
+	}
+}
+
+// replaceNeededUsingPatchelf replaces the DT_NEEDED entry old of the ELF at path by new using patchelf
+func replaceNeededUsingPatchelf(path string, old string, new string) error {
+	requireTool("patchelf", "replacing absolute paths to libraries in the bundled ELF files")
+	cmd := exec.Command("patchelf", "--replace-needed", old, new, path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Println(cmd.String())
+		return errors.New("patchelf --replace-needed " + path + ": " + string(out) + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// handleOpenSSL bundles the engines (OpenSSL 1.1 and 3) and providers (OpenSSL 3) directories
+// that belong to a bundled libcrypto, because OpenSSL loads those at runtime from the
+// location compiled into libcrypto. AppRun has to export OPENSSL_ENGINES and
+// OPENSSL_MODULES for the bundled ones to be used
+func handleOpenSSL(ctx *deployContext) {
+	appdir := ctx.appdir
+	var majors []string
+	for _, lib := range ctx.elfs {
+		if strings.HasPrefix(filepath.Base(lib), "libcrypto.so.") == false {
+			continue
+		}
+		major := strings.TrimPrefix(filepath.Base(lib), "libcrypto.so.")
+		majors = helpers.AppendIfMissing(majors, major)
+		if strings.HasPrefix(lib, appdir.Path) {
+			continue
+		}
+		libdir := filepath.Dir(lib)
+
+		for _, dir := range []string{"engines-" + major, "engines-" + strings.Split(major, ".")[0]} {
+			if helpers.IsDirectory(libdir + "/" + dir) {
+				log.Println("Bundling OpenSSL", major, "engines directory (for OPENSSL_ENGINES)...")
+				determineELFsInDirTree(ctx, libdir+"/"+dir)
+				addAppRunSection(ctx, "Use bundled OpenSSL engines", `apprun_export OPENSSL_ENGINES "${HERE}`+libdir+"/"+dir+`" replace`)
+				break
+			}
+		}
+
+		if helpers.IsDirectory(libdir + "/ossl-modules") {
+			log.Println("Bundling OpenSSL", major, "providers directory (for OPENSSL_MODULES)...")
+			determineELFsInDirTree(ctx, libdir+"/ossl-modules")
+			addAppRunSection(ctx, "Use bundled OpenSSL providers", `apprun_export OPENSSL_MODULES "${HERE}`+libdir+`/ossl-modules" replace`)
+		}
+	}
+
+	// Each OpenSSL major version has its own engines/providers and its own configuration,
+	// but only one OPENSSL_ENGINES and OPENSSL_MODULES can be set, hence one of them will break
+	if len(majors) > 1 {
+		log.Println("WARNING: The application loads multiple major versions of OpenSSL:", strings.Join(majors, ", "))
+		log.Println("         This is known to break in subtle ways at runtime, e.g., when loading engines or providers.")
+		log.Println("         Please make sure that all libraries in the AppDir are built against the same OpenSSL.")
+	}
+}
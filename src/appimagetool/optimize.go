@@ -0,0 +1,137 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// optimizeCategory is a kind of content that is only needed at build time (or not at all)
+// and can be removed from the AppDir. The patterns are matched like those in .appdirignore
+type optimizeCategory struct {
+	name     string
+	patterns []string
+}
+
+// Content that is only needed for building against the libraries in the AppDir
+var buildFileCategories = []optimizeCategory{
+	{"static archives", []string{"*.a"}},
+	{"libtool archives", []string{"*.la"}},
+	{"headers", []string{"usr/include/", "usr/local/include/"}},
+	{"pkg-config files", []string{"usr/lib/pkgconfig/", "usr/lib/*/pkgconfig/", "usr/lib64/pkgconfig/", "usr/share/pkgconfig/"}},
+	{"CMake files", []string{"usr/lib/cmake/", "usr/lib/*/cmake/", "usr/lib64/cmake/"}},
+	{"Autoconf macros", []string{"usr/share/aclocal/"}},
+}
+
+// Documentation that is not used by the application. usr/share/doc is kept
+// because it contains the copyright files of the bundled libraries
+var documentationCategories = []optimizeCategory{
+	{"man pages", []string{"usr/share/man/", "usr/local/share/man/"}},
+	{"info pages", []string{"usr/share/info/"}},
+	{"API documentation", []string{"usr/share/gtk-doc/", "usr/share/devhelp/"}},
+	{"debug symbols", []string{"usr/lib/debug/"}},
+}
+
+// optimizeProfiles contains what can be selected with --optimize,
+// similar to the "delete blacklisted" step of pkg2appimage
+var optimizeProfiles = map[string][]optimizeCategory{
+	"build": buildFileCategories,
+	"full":  append(append([]optimizeCategory{}, buildFileCategories...), documentationCategories...),
+}
+
+// getOptimizeProfileNames returns the names of all optimization profiles, sorted
+func getOptimizeProfileNames() []string {
+	var names []string
+	for name := range optimizeProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// optimizeSavings is how much was removed for one category
+type optimizeSavings struct {
+	files int
+	bytes int64
+}
+
+// optimizeAppDir removes the content matched by the optimization profile selected with --optimize
+// from the AppDir and reports how much space this saved
+func optimizeAppDir(appdir helpers.AppDir) {
+	categories, ok := optimizeProfiles[options.optimize]
+	if ok == false {
+		return
+	}
+	log.Println("Removing content that is not needed at runtime according to the", options.optimize, "optimization profile...")
+	savings, err := removeOptimizedContent(appdir.Path, categories)
+	if err != nil {
+		helpers.PrintError("Could not optimize the AppDir", err)
+	}
+	var total optimizeSavings
+	for _, category := range categories {
+		s, ok := savings[category.name]
+		if ok == false {
+			continue
+		}
+		log.Println("Removed", s.files, "files of", category.name, "("+formatSize(s.bytes)+")")
+		total.files += s.files
+		total.bytes += s.bytes
+	}
+	log.Println("Optimization saved", formatSize(total.bytes), "in", total.files, "files")
+}
+
+// removeOptimizedContent removes everything below root that matches one of the categories
+// and returns the savings by category name
+func removeOptimizedContent(root string, categories []optimizeCategory) (map[string]optimizeSavings, error) {
+	savings := make(map[string]optimizeSavings)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return nil
+		}
+		for _, category := range categories {
+			if (appDirIgnore{root: root, patterns: category.patterns}).matches(path, d.IsDir()) == false {
+				continue
+			}
+			files, bytes := measure(path)
+			err := os.RemoveAll(path)
+			if err != nil {
+				return err
+			}
+			s := savings[category.name]
+			s.files += files
+			s.bytes += bytes
+			savings[category.name] = s
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		return nil
+	})
+	return savings, err
+}
+
+// measure returns the number of regular files at or below path and their total size
+func measure(path string) (int, int64) {
+	var files int
+	var bytes int64
+	_ = filepath.WalkDir(path, func(path string, d fs.DirEntry, e error) error {
+		if e != nil || d.Type().IsRegular() == false {
+			return nil
+		}
+		info, err := d.Info()
+		if err == nil {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return files, bytes
+}
@@ -0,0 +1,129 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Files smaller than this are not worth recompressing
+const minRecompressSize = 4096
+
+// dataFilter recompresses a kind of data file in place and returns how many bytes this saved.
+// Filters must be lossless and leave files they cannot improve untouched
+type dataFilter struct {
+	name   string
+	suffix string
+	apply  func(path string) (int64, error)
+}
+
+// dataFilters are run on every file with a matching suffix when --optimize-data is given
+var dataFilters = []dataFilter{
+	{"PNG images", ".png", recompressPNG},
+}
+
+// optimizeData recompresses the large data files in the AppDir at path with the dataFilters, leaving out the ignored paths.
+// squashfs compresses each file on its own with a general-purpose compressor, which cannot undo
+// the poor compression of already compressed formats such as PNG. Identical files need no help,
+// since mksquashfs stores their data only once
+func optimizeData(path string, ignored appDirIgnore) {
+	log.Println("Optimizing the data files in the AppDir...")
+	savings := make(map[string]optimizeSavings)
+	_ = filepath.WalkDir(path, func(path string, d fs.DirEntry, e error) error {
+		if e != nil || d.Type().IsRegular() == false || ignored.matches(path, false) {
+			return nil
+		}
+		for _, filter := range dataFilters {
+			if strings.HasSuffix(strings.ToLower(path), filter.suffix) == false {
+				continue
+			}
+			if info, err := d.Info(); err != nil || info.Size() < minRecompressSize {
+				continue
+			}
+			saved, err := filter.apply(path)
+			if err != nil {
+				log.Println("Could not recompress", path+":", err)
+				continue
+			}
+			if saved > 0 {
+				s := savings[filter.name]
+				s.files++
+				s.bytes += saved
+				savings[filter.name] = s
+			}
+		}
+		return nil
+	})
+
+	var total int64
+	for _, filter := range dataFilters {
+		if s := savings[filter.name]; s.files > 0 {
+			log.Println("Optimized", s.files, "files of", filter.name, "("+formatSize(s.bytes)+" saved)")
+			total += s.bytes
+		}
+	}
+	log.Println("Data optimization saved", formatSize(total))
+}
+
+// PNG chunks that the Go encoder would drop although they affect how the image looks,
+// and the chunks of animated PNGs, of which the Go decoder only reads the first frame
+var pngKeepChunks = []string{"acTL", "iCCP", "sRGB", "gAMA", "cHRM"}
+
+// recompressPNG re-encodes the PNG at path with the best compression
+// and replaces it if the result is smaller
+func recompressPNG(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, chunk := range getPNGChunkTypes(data) {
+		if helpers.SliceContains(pngKeepChunks, chunk) {
+			return 0, nil
+		}
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	err = encoder.Encode(&buf, img)
+	if err != nil {
+		return 0, err
+	}
+	if buf.Len() >= len(data) {
+		return 0, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	err = ioutil.WriteFile(path, buf.Bytes(), info.Mode())
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data) - buf.Len()), nil
+}
+
+// getPNGChunkTypes returns the types of the chunks in the PNG data
+func getPNGChunkTypes(data []byte) []string {
+	var types []string
+	position := 8 // Signature
+	for position+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[position:]))
+		types = append(types, string(data[position+4:position+8]))
+		position += 12 + length // Length, type, data, CRC
+	}
+	return types
+}
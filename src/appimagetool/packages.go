@@ -0,0 +1,268 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/download"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/pkgrepo"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/openpgp"
+)
+
+// Architecture names of deb and rpm packages by the architecture appimagetool was built for
+var debArchitectures = map[string]string{"amd64": "amd64", "386": "i386", "arm64": "arm64", "arm": "armhf"}
+var rpmArchitectures = map[string]string{"amd64": "x86_64", "386": "i686", "arm64": "aarch64", "arm": "armv7hl"}
+
+// Flags of the packages subcommand
+var packagesFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:  "source",
+		Usage: "Repository to download from, either a line of sources.list (deb URI SUITE COMPONENT...) or rpm BASEURL; deb repositories must be signed with a key trusted by apt or use https, rpm repositories must use https (default: the deb repositories of this system)",
+	},
+	&cli.StringSliceFlag{
+		Name:  "exclude",
+		Usage: "Package that is not bundled, together with what only it needs, because the target systems have it",
+	},
+	packageArchFlag,
+	proxyFlag,
+}
+
+// Flag for the architecture of the packages, shared by the subcommands that download packages
+var packageArchFlag = &cli.StringFlag{
+	Name:  "arch",
+	Usage: "Architecture of the packages, e.g., amd64 or x86_64 (default: that of this system)",
+}
+
+// Flag for the HTTP proxy, shared by the subcommands that download
+var proxyFlag = &cli.StringFlag{
+	Name:  "proxy",
+	Usage: "HTTP proxy to download through, e.g., http://proxy:3128 (default: $https_proxy or $http_proxy)",
+}
+
+// newDownloadCache returns the cache in the user's cache directory that downloads through the --proxy
+func newDownloadCache(c *cli.Context) *download.Cache {
+	dir, err := download.DefaultDir()
+	if err != nil {
+		helpers.PrintError("Could not determine the download cache", err)
+		os.Exit(1)
+	}
+	cache, err := download.NewCache(dir, c.String("proxy"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cache
+}
+
+// packageSpec says which packages go into an AppDir and where they come from
+type packageSpec struct {
+	sources  []string // Lines of sources.list, or rpm followed by the base URL of an rpm-md repository
+	packages []string
+	exclude  []string
+	debs     []string // Local .deb files, which are put into the AppDir together with the packages
+	arch     string   // Empty for the architecture of this system
+	cache    *download.Cache
+}
+
+// bootstrapPackages creates an AppDir from deb or rpm packages and their dependencies and deploys it
+//
+//	Args: c: cli.Context
+func bootstrapPackages(c *cli.Context) error {
+	if c.NArg() < 2 {
+		log.Fatal("Please specify the AppDir to create and the packages to put into it, e.g., " +
+			filepath.Base(os.Args[0]) + " packages --source 'deb https://deb.debian.org/debian bookworm main' Hello.AppDir hello")
+	}
+	path := c.Args().Get(0)
+	if helpers.Exists(path) {
+		log.Fatal(path + " already exists")
+	}
+	spec := packageSpec{
+		sources:  c.StringSlice("source"),
+		packages: c.Args().Slice()[1:],
+		exclude:  c.StringSlice("exclude"),
+		arch:     c.String("arch"),
+		cache:    newDownloadCache(c),
+	}
+	_, err := fetchPackagesIntoAppDir(spec, path)
+	if err != nil {
+		helpers.PrintError("Could not put the packages into "+path, err)
+		os.Exit(exitUnresolvedDependencies)
+	}
+	desktopFile, err := findDesktopFileOfPackages(path, spec.packages[0])
+	if err != nil {
+		helpers.PrintError("AppDir", err)
+		os.Exit(exitInvalidAppDir)
+	}
+	setDeployOptions(c)
+	AppDirDeploy(desktopFile)
+	return nil
+}
+
+// fetchPackagesIntoAppDir downloads the packages in spec together with their dependencies,
+// except for those that every installation of the distribution has, and extracts them into the AppDir at path.
+// Downloaded packages are kept in the download cache. Returns the packages that were put into the AppDir
+func fetchPackagesIntoAppDir(spec packageSpec, path string) ([]*pkgrepo.Package, error) {
+	sources := spec.sources
+	if len(sources) == 0 {
+		sources = readSystemDebSources()
+		if len(sources) == 0 {
+			return nil, errors.New("no repository given and none found in /etc/apt/sources.list")
+		}
+	}
+	client := spec.cache.Client
+	idx := pkgrepo.NewIndex()
+	format := ""
+	for _, line := range sources {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if format != "" && format != fields[0] {
+			return nil, errors.New("deb and rpm repositories cannot be mixed")
+		}
+		format = fields[0]
+		var packages []*pkgrepo.Package
+		var err error
+		switch format {
+		case pkgrepo.FormatDeb:
+			var source pkgrepo.DebSource
+			source, err = pkgrepo.ParseDebSource(line)
+			if err == nil {
+				source.Keyring = readAptKeyring(source.SignedBy)
+				log.Println("Reading the index of", line+"...")
+				packages, err = source.Fetch(client, packageArchitecture(spec.arch, debArchitectures))
+			}
+		case pkgrepo.FormatRpm:
+			if len(fields) != 2 {
+				return nil, errors.New("invalid rpm source " + line + ", expected rpm BASEURL")
+			}
+			log.Println("Reading the index of", fields[1]+"...")
+			packages, err = pkgrepo.RpmSource{BaseURL: fields[1]}.Fetch(client, packageArchitecture(spec.arch, rpmArchitectures))
+		default:
+			return nil, errors.New("invalid source " + line + ", expected deb ... or rpm ...")
+		}
+		if err != nil {
+			return nil, err
+		}
+		idx.Add(packages...)
+	}
+	names := append([]string{}, spec.packages...)
+	for _, deb := range spec.debs {
+		if format != pkgrepo.FormatDeb {
+			return nil, errors.New("the local package " + deb + " cannot be used with rpm repositories")
+		}
+		p, err := pkgrepo.ReadDeb(deb)
+		if err != nil {
+			return nil, err
+		}
+		idx.Add(p)
+		names = append(names, p.Name)
+	}
+	log.Println("Found", idx.Len(), "packages")
+
+	resolved, missing, err := idx.Resolve(names, func(name string) bool {
+		return helpers.SliceContains(spec.exclude, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, dependency := range missing {
+		log.Println("WARNING: Dependency", dependency, "was not found in the repositories, the target system needs to have it")
+	}
+
+	for _, p := range resolved {
+		log.Println("Downloading", p.Name, p.Version+"...")
+		file, err := pkgrepo.Download(spec.cache, p)
+		if err != nil {
+			return nil, err
+		}
+		err = pkgrepo.Extract(file, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// packageArchitecture returns arch if it is given, and otherwise how packages name the architecture of this system
+func packageArchitecture(arch string, names map[string]string) string {
+	if arch != "" {
+		return arch
+	}
+	if name, ok := names[runtime.GOARCH]; ok {
+		return name
+	}
+	return runtime.GOARCH
+}
+
+// readSystemDebSources returns the deb lines in /etc/apt/sources.list and /etc/apt/sources.list.d/*.list
+func readSystemDebSources() []string {
+	var lines []string
+	files, _ := filepath.Glob("/etc/apt/sources.list.d/*.list")
+	for _, file := range append([]string{"/etc/apt/sources.list"}, files...) {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "deb ") {
+				lines = append(lines, line)
+			}
+		}
+		f.Close()
+	}
+	return lines
+}
+
+// readAptKeyring returns the keys in the keyrings given in the signed-by option of a deb source,
+// or if there are none, the keys that apt trusts for all repositories. Keyrings that cannot be read are skipped
+func readAptKeyring(signedBy []string) openpgp.EntityList {
+	paths := signedBy
+	if len(paths) == 0 {
+		paths, _ = filepath.Glob("/etc/apt/trusted.gpg.d/*.gpg")
+		asc, _ := filepath.Glob("/etc/apt/trusted.gpg.d/*.asc")
+		paths = append(append([]string{"/etc/apt/trusted.gpg"}, paths...), asc...)
+	}
+	var keyring openpgp.EntityList
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil && len(signedBy) == 0 {
+			continue
+		}
+		keys, err := pkgrepo.ReadKeyring([]string{path})
+		if err != nil {
+			log.Println("Skipping the keyring", err)
+			continue
+		}
+		keyring = append(keyring, keys...)
+	}
+	return keyring
+}
+
+// findDesktopFileOfPackages returns the desktop file in usr/share/applications of the AppDir at path,
+// preferring the one named after the package name if there are several
+func findDesktopFileOfPackages(path string, name string) (string, error) {
+	desktopFiles, _ := filepath.Glob(path + "/usr/share/applications/*.desktop")
+	if len(desktopFiles) == 1 {
+		return desktopFiles[0], nil
+	}
+	for _, desktopFile := range desktopFiles {
+		if strings.HasPrefix(filepath.Base(desktopFile), name) {
+			return desktopFile, nil
+		}
+	}
+	if len(desktopFiles) == 0 {
+		return "", errors.New("the packages contain no desktop file in usr/share/applications, please add one to " + path + " and deploy it")
+	}
+	return "", errors.New("the packages contain several desktop files in usr/share/applications, please deploy " + path + " with one of them")
+}
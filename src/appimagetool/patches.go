@@ -0,0 +1,120 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/internal/patch"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Directory with the patches for the AppDir if --patches is not given
+const defaultPatchesDir = "appdir-patches"
+
+// appliedPatch records a patch that was applied to the AppDir
+type appliedPatch struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// patchSeriesEntry is a patch to be applied and the number of leading components to strip from
+// its paths like patch -pN does, or -1 to remove the a/ or b/ prefix of git and quilt
+type patchSeriesEntry struct {
+	name  string
+	strip int
+}
+
+// applyAppDirPatches applies the unified diffs in the directory given with --patches,
+// or in appdir-patches in the current directory, to the files in the AppDir, e.g., to fix
+// absolute paths in .pc or .ini files. Paths in the patches are relative to the AppDir.
+// Like with quilt, the patches are applied in the order given in the file called series, which can give -pN
+// after the name, or in alphabetical order if there is none. Patches that are already applied are skipped.
+// Patches can also create and delete files
+func applyAppDirPatches(ctx *deployContext) {
+	appdir := ctx.appdir
+	dir := options.patchesDir
+	if dir == "" {
+		if helpers.IsDirectory(defaultPatchesDir) == false {
+			return
+		}
+		dir = defaultPatchesDir
+	}
+	series, err := getPatchSeries(dir)
+	if err != nil {
+		helpers.PrintError("Could not read the patches in "+dir, err)
+		os.Exit(exitPatchFailure)
+	}
+	for _, entry := range series {
+		name := entry.name
+		data, err := ioutil.ReadFile(dir + "/" + name)
+		if err != nil {
+			helpers.PrintError("Could not read patch", err)
+			os.Exit(exitPatchFailure)
+		}
+		filePatches, err := patch.ParseStrip(string(data), entry.strip)
+		if err != nil {
+			helpers.PrintError("Could not parse patch "+name, err)
+			os.Exit(exitPatchFailure)
+		}
+		log.Println("Applying patch", name+"...")
+		for _, filePatch := range filePatches {
+			err = filePatch.Apply(appdir.Path)
+			if err != nil && filePatch.IsApplied(appdir.Path) {
+				log.Println(filePatch.Path, "already contains the changes of", name)
+				continue
+			}
+			if err != nil {
+				helpers.PrintError("Could not apply patch "+name, err)
+				os.Exit(exitPatchFailure)
+			}
+		}
+		hash, _ := fsys.SHA256(fsys.OS, dir+"/"+name)
+		ctx.appliedPatches = append(ctx.appliedPatches, appliedPatch{Name: name, SHA256: hash})
+	}
+}
+
+// getPatchSeries returns the patches in dir in the order in which they are to be applied.
+// Of the options that quilt allows after the name of a patch in the series file, only -pN is supported
+func getPatchSeries(dir string) ([]patchSeriesEntry, error) {
+	var series []patchSeriesEntry
+	data, err := ioutil.ReadFile(dir + "/series")
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(strings.SplitN(line, "#", 2)[0])
+			if len(fields) == 0 {
+				continue
+			}
+			entry := patchSeriesEntry{name: fields[0], strip: -1}
+			for _, option := range fields[1:] {
+				strip, err := strconv.Atoi(strings.TrimPrefix(option, "-p"))
+				if strings.HasPrefix(option, "-p") == false || err != nil || strip < 0 {
+					return nil, errors.New("unsupported option " + option + " of " + entry.name + " in the series file")
+				}
+				entry.strip = strip
+			}
+			series = append(series, entry)
+		}
+		return series, nil
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if filepath.Ext(info.Name()) == ".patch" || filepath.Ext(info.Name()) == ".diff" {
+			series = append(series, patchSeriesEntry{name: info.Name(), strip: -1})
+		}
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].name < series[j].name })
+	return series, nil
+}
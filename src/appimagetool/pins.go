@@ -0,0 +1,151 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+)
+
+// Name of the file in the top level of the AppDir that pins libraries to the file that gets bundled for them,
+// one name=path per line, e.g., libssl.so.3=/opt/openssl/lib/libssl.so.3. Relative paths are relative to the AppDir
+const pinsFileName = ".appdirpins"
+
+// libraryPin makes the library with the given name resolve to path
+type libraryPin struct {
+	name string
+	path string
+	rule string // Where the pin comes from
+}
+
+// loadPins returns the pins from the .appdirpins file of the AppDir at root (if any) followed by extra,
+// e.g., the values of --pin, whose relative paths are relative to the working directory
+func loadPins(root string, extra []string) ([]libraryPin, error) {
+	var pins []libraryPin
+	f, err := os.Open(filepath.Join(root, pinsFileName))
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			pin, err := parsePin(line, root, "pinned in "+pinsFileName)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, pin)
+		}
+		err = scanner.Err()
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	workingDir, _ := os.Getwd()
+	for _, value := range extra {
+		pin, err := parsePin(value, workingDir, "pinned with --pin")
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// parsePin parses name=path, making a relative path absolute using base
+func parsePin(value string, base string, rule string) (libraryPin, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return libraryPin{}, errors.New("invalid pin " + value + ", expected name=path, e.g., libssl.so.3=/opt/openssl/lib/libssl.so.3")
+	}
+	pin := libraryPin{name: strings.TrimSpace(parts[0]), path: strings.TrimSpace(parts[1]), rule: rule}
+	if filepath.IsAbs(pin.path) == false {
+		pin.path = filepath.Join(base, pin.path)
+	}
+	if helpers.Exists(pin.path) == false {
+		return libraryPin{}, errors.New(pin.path + ", to which " + pin.name + " is pinned, does not exist")
+	}
+	return pin, nil
+}
+
+// applyPins makes the resolver of ctx use the pinned files for the libraries
+func applyPins(ctx *deployContext, pins []libraryPin) {
+	for _, pin := range pins {
+		log.Println("Using", pin.path, "for", pin.name, "("+pin.rule+")")
+		ctx.resolver.Pin(pin.name, pin.path, pin.rule)
+	}
+}
+
+// reportLibraryConflicts warns about the bundled libraries for which different files were found, which one
+// of them was bundled, and how the others differ from it in their symbol versions and exported symbols
+func reportLibraryConflicts(ctx *deployContext) {
+	conflicts := ctx.resolver.Conflicts()
+	bundled := make(map[string]string)
+	var names []string
+	for name, files := range conflicts {
+		for _, file := range files {
+			if helpers.SliceContains(ctx.elfs, file) {
+				bundled[name] = file
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		chosen := bundled[name]
+		log.Println("WARNING: Found", len(conflicts[name]), "different files for", name+", bundling", chosen)
+		chosenVersions, _ := elfdeps.ReadVersionDefinitions(appdirFS, chosen)
+		chosenSymbols, _ := elfdeps.ReadExportedSymbols(appdirFS, chosen)
+		for _, file := range conflicts[name] {
+			description := file
+			if resolved, err := filepath.EvalSymlinks(file); err == nil && filepath.Base(resolved) != filepath.Base(file) {
+				description += " -> " + filepath.Base(resolved)
+			}
+			versions, _ := elfdeps.ReadVersionDefinitions(appdirFS, file)
+			symbols, _ := elfdeps.ReadExportedSymbols(appdirFS, file)
+			description += fmt.Sprintf(" (%s, %d symbols", ctx.resolver.Rule(strings.TrimSuffix(filepath.Dir(file), "/tls")), len(symbols))
+			if file == chosen {
+				description += ", bundled"
+			} else {
+				if extra := missingFrom(versions, chosenVersions); len(extra) > 0 {
+					description += ", also defines " + strings.Join(extra, " ")
+				}
+				if lacking := missingFrom(chosenVersions, versions); len(lacking) > 0 {
+					description += ", lacks " + strings.Join(lacking, " ")
+				}
+				description += fmt.Sprintf(", %d symbols more and %d less than the bundled one", len(missingFrom(symbols, chosenSymbols)), len(missingFrom(chosenSymbols, symbols)))
+			}
+			fmt.Println("    " + description + ")")
+		}
+		log.Println("To bundle another one, pin it with --pin " + name + "=PATH or in " + pinsFileName)
+	}
+}
+
+// missingFrom returns the entries of a that are not in b
+func missingFrom(a []string, b []string) []string {
+	in := make(map[string]bool)
+	for _, entry := range b {
+		in[entry] = true
+	}
+	var missing []string
+	for _, entry := range a {
+		if in[entry] == false {
+			missing = append(missing, entry)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,126 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Stages of the deployment at which deployers run
+const (
+	// After the ELFs in the AppDir and their dependencies have been determined
+	// and the profile has been applied
+	stageFrameworks = "frameworks"
+	// After the bundled glibc has been copied into the AppDir (only with -s)
+	stageGlibc = "glibc"
+)
+
+// deployer is a plugin that bundles what a certain framework
+// (e.g., Gtk or GStreamer) needs at runtime in addition to its libraries.
+// New deployers are registered in init() without touching AppDirDeploy
+type deployer interface {
+	// Detect returns true if the framework is used in the AppDir
+	Detect(ctx *deployContext) bool
+	// Deploy bundles what the framework needs
+	Deploy(ctx *deployContext) error
+}
+
+// registeredDeployer is a deployer as registered with registerDeployer
+type registeredDeployer struct {
+	name  string
+	stage string
+	// Disabled deployers only run if requested with --enable-plugin
+	disabled bool
+	deployer deployer
+}
+
+// deployers contains all registered deployers in the order in which they run
+var deployers []registeredDeployer
+
+func init() {
+	// The order matters; e.g., Gtk needs to see the gdk-pixbuf loaders
+	registerDeployer("gdk-pixbuf", stageFrameworks, gdkPixbufDeployer{})
+	registerDeployer("gstreamer", stageFrameworks, gstreamerDeployer{})
+	registerDeployer("gtk3", stageFrameworks, gtkDeployer{version: 3})
+	registerDeployer("gtk2", stageFrameworks, gtkDeployer{version: 2})
+	registerDeployer("wxwidgets", stageFrameworks, wxWidgetsDeployer{})
+	registerDeployer("dotnet", stageFrameworks, dotnetDeployer{})
+	registerDeployer("mono", stageFrameworks, monoDeployer{})
+	registerDeployer("gconv", stageGlibc, gconvDeployer{})
+}
+
+// registerDeployer adds a deployer that runs at stage
+func registerDeployer(name string, stage string, d deployer) {
+	deployers = append(deployers, registeredDeployer{name: name, stage: stage, deployer: d})
+}
+
+// getDeployerNames returns the names of all deployers in the order in which they run
+func getDeployerNames() []string {
+	var names []string
+	for _, d := range deployers {
+		names = append(names, d.name)
+	}
+	return names
+}
+
+// setDeployersEnabled applies --enable-plugin and --disable-plugin
+func setDeployersEnabled(enabled []string, disabled []string) {
+	for _, name := range append(append([]string{}, enabled...), disabled...) {
+		if helpers.SliceContains(getDeployerNames(), name) == false {
+			log.Fatal("Unknown plugin " + name + ", available plugins: " + strings.Join(getDeployerNames(), ", "))
+		}
+		if helpers.SliceContains(enabled, name) && helpers.SliceContains(disabled, name) {
+			log.Fatal("Plugin " + name + " cannot be both enabled and disabled")
+		}
+	}
+	for i := range deployers {
+		if helpers.SliceContains(enabled, deployers[i].name) {
+			deployers[i].disabled = false
+		}
+		if helpers.SliceContains(disabled, deployers[i].name) {
+			deployers[i].disabled = true
+		}
+	}
+}
+
+// runDeployers runs the enabled deployers of stage that detect their framework in the AppDir
+func runDeployers(ctx *deployContext, stage string) error {
+	for _, d := range deployers {
+		if d.stage != stage {
+			continue
+		}
+		if d.disabled {
+			log.Println("Not running plugin", d.name, "because it is disabled")
+			continue
+		}
+		if d.deployer.Detect(ctx) == false {
+			continue
+		}
+		phase := helpers.Phase()
+		helpers.SetPhase("Plugin " + d.name)
+		err := d.deployer.Deploy(ctx)
+		helpers.SetPhase(phase)
+		if err != nil {
+			helpers.PrintError("Plugin "+d.name, err)
+			return err
+		}
+		ctx.deployersRun = append(ctx.deployersRun, d.name)
+	}
+	return nil
+}
+
+// findELFWithPrefix returns the first ELF to be deployed whose name starts with prefix,
+// or an empty string if there is none. Used by deployers to detect their framework
+func findELFWithPrefix(ctx *deployContext, prefix string) string {
+	for _, lib := range ctx.elfs {
+		if strings.HasPrefix(filepath.Base(lib), prefix) {
+			return lib
+		}
+	}
+	return ""
+}
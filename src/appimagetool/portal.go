@@ -0,0 +1,76 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// Policies for using XDG Desktop Portals for file dialogs and the like, selected with --portal.
+// The policy is only the default; it can be overridden at runtime by setting APPDIR_PORTAL_POLICY
+const (
+	portalPolicyAuto   = "auto"   // Use portals on Wayland and in sandboxes if the host has xdg-desktop-portal
+	portalPolicyAlways = "always" // Use portals whenever possible
+	portalPolicyNever  = "never"  // Use the dialogs of the bundled toolkit
+)
+
+var portalPolicies = []string{portalPolicyAuto, portalPolicyAlways, portalPolicyNever}
+
+// The D-Bus service files that exist if xdg-desktop-portal is installed on the host
+var portalServiceFiles = []string{
+	"/usr/share/dbus-1/services/org.freedesktop.portal.Desktop.service",
+	"/usr/local/share/dbus-1/services/org.freedesktop.portal.Desktop.service",
+}
+
+// The Qt platform theme plugin that talks to the portals; bundled by handleQt
+const qtPortalPlugin = "libqxdgdesktopportal.so"
+
+// handlePortals adds AppRun logic that makes bundled Gtk 3 and Qt use XDG Desktop Portals,
+// so that file choosers come from the host and work on Wayland and in sandboxes,
+// where the bundled dialogs cannot see the files of the user.
+// Gtk 4 uses the portals by itself, Gtk 3 needs GTK_USE_PORTAL, Qt needs the xdgdesktopportal platform theme
+func handlePortals(ctx *deployContext) {
+	if options.portal == portalPolicyNever {
+		return
+	}
+	gtk := findELFWithPrefix(ctx, "libgtk-3") != ""
+	qt := findELFWithPrefix(ctx, "libQt5Core.so") != "" || findELFWithPrefix(ctx, "libQt6Core.so") != ""
+	if gtk == false && qt == false {
+		return
+	}
+	log.Println("Adding AppRun logic to use XDG Desktop Portals (--portal=" + options.portal + ")...")
+
+	code := `
+: "${APPDIR_PORTAL_POLICY:=` + options.portal + `}"
+use_portal=0
+case "${APPDIR_PORTAL_POLICY}" in
+  always) use_portal=1 ;;
+  auto)
+    if [ -n "${WAYLAND_DISPLAY}" ] || [ "${XDG_SESSION_TYPE}" = wayland ] || [ -e /.flatpak-info ] || [ -n "${SNAP}" ] ; then
+      for PORTAL in ` + strings.Join(portalServiceFiles, " ") + ` ; do
+        if [ -e "${PORTAL}" ] ; then
+          use_portal=1
+        fi
+      done
+    fi ;;
+esac
+if [ "${use_portal}" = 1 ] ; then`
+	if gtk {
+		code = code + `
+  apprun_export GTK_USE_PORTAL 1 skip-if-set`
+	}
+	if qt {
+		code = code + `
+  for PLUGIN in "${HERE}"/usr/lib/qt*/plugins "${HERE}"/usr/lib/*/qt*/plugins "${HERE}"/usr/lib64/qt*/plugins "${HERE}"/usr/plugins ; do
+    if [ -e "${PLUGIN}"/platformthemes/` + qtPortalPlugin + ` ] ; then
+      apprun_export QT_QPA_PLATFORMTHEME xdgdesktopportal replace
+      break
+    fi
+  done`
+	}
+	code = code + `
+fi`
+	addAppRunSection(ctx, "Use XDG Desktop Portals for file dialogs on Wayland and in sandboxes", code)
+}
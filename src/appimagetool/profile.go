@@ -0,0 +1,123 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// deployProfile is a preset for a certain kind of application
+// that adjusts what gets deployed into the AppDir
+type deployProfile struct {
+	// Prefixes of library names that are never bundled, not even in standalone mode
+	excludedLibraries []string
+	// Called after the ELFs in the AppDir and their dependencies have been determined
+	apply func(ctx *deployContext)
+}
+
+// profiles contains the presets that can be selected with --profile
+var profiles = make(map[string]deployProfile)
+
+// Graphics drivers and the libraries that talk to them must come from the target system,
+// otherwise games will not work with the GPU there (or crash)
+var graphicsDriverLibraries = []string{"libGL.so", "libGLX", "libGLdispatch", "libglapi", "libEGL", "libGLES",
+	"libOpenGL", "libvulkan", "libdrm", "libgbm", "libnvidia", "libxcb-dri2", "libxcb-dri3",
+	"libxcb-glx", "libxcb-present", "libxshmfence", "libX11-xcb"}
+
+func init() {
+	// Populated here rather than in the declaration because the profiles
+	// refer to functions that in turn consult the profiles
+	profiles["game"] = deployProfile{
+		excludedLibraries: graphicsDriverLibraries,
+		apply:             applyGameProfile,
+	}
+	// Windows applications, which are mostly games, run with a bundled Wine or Proton build
+	profiles["wine"] = deployProfile{
+		excludedLibraries: graphicsDriverLibraries,
+		apply:             applyWineProfile,
+	}
+}
+
+// getProfileNames returns the names of all profiles, sorted
+func getProfileNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isExcludedByProfile returns true if the library at path must not be bundled
+// according to the profile selected with --profile
+func isExcludedByProfile(path string) bool {
+	profile, ok := profiles[options.profile]
+	if ok == false {
+		return false
+	}
+	for _, prefix := range profile.excludedLibraries {
+		if strings.HasPrefix(filepath.Base(path), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyProfile applies the profile selected with --profile, if any
+func applyProfile(ctx *deployContext) {
+	profile, ok := profiles[options.profile]
+	if ok == false {
+		return
+	}
+	log.Println("Applying profile", options.profile+"...")
+	if profile.apply != nil {
+		profile.apply(ctx)
+	}
+}
+
+// applyGameProfile takes care of the things that typically go wrong when
+// games using SDL2 and OpenAL are bundled
+func applyGameProfile(ctx *deployContext) {
+	appdir := ctx.appdir
+	for _, lib := range ctx.elfs {
+		if strings.HasPrefix(filepath.Base(lib), "libSDL2-2.0.so") {
+			// SDL2 can be replaced at runtime by the one given in SDL_DYNAMIC_API, e.g., to use a newer SDL2
+			// with support for more input devices; but this only works if SDL2 was built with the dynamic API
+			f, err := ioutil.ReadFile(lib)
+			if err == nil && strings.Contains(string(f), "SDL_DYNAMIC_API") == false {
+				log.Println("WARNING:", lib, "was built without the dynamic API, SDL_DYNAMIC_API will not work")
+			}
+			// AppRun changes the working directory, hence relative paths would no longer work
+			addAppRunSection(ctx, "Keep the bundled SDL2 overridable using SDL_DYNAMIC_API", `
+if [ ! -z "${SDL_DYNAMIC_API}" ] ; then
+  export SDL_DYNAMIC_API="$(readlink -f "${SDL_DYNAMIC_API}")"
+fi`)
+			break
+		}
+	}
+
+	// Many games load OpenAL using dlopen() rather than linking to it, so we would not see it otherwise
+	openal, err := findLibrary(ctx, "libopenal.so.1")
+	if err == nil {
+		if helpers.SliceContains(ctx.elfs, openal) == false {
+			log.Println("Bundling OpenAL which games frequently load at runtime...")
+			determineELFsInDirTree(ctx, openal)
+		}
+		// OpenAL Soft needs its HRTF data for 3D sound on headphones
+		if helpers.IsDirectory("/usr/share/openal") {
+			err = helpers.CopyTree("/usr/share/openal", appdir.Path+"/usr/share/openal")
+			if err != nil {
+				helpers.PrintError("Could not copy OpenAL data", err)
+			}
+		}
+	} else {
+		log.Println("OpenAL not found on the build system, not bundling it")
+	}
+}
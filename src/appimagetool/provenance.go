@@ -0,0 +1,223 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Name of the file in the top-level directory of the AppDir that records the environment it was deployed in,
+// so that bug reports can be traced back to it
+const provenanceName = ".appimage-provenance"
+
+// Name of the ELF note in the runtime of the AppImage that contains the provenance, see --provenance-note
+const provenanceNoteSection = ".note.appimage.provenance"
+
+// Version of the format of the provenance, increased when it changes incompatibly
+const provenanceVersion = 1
+
+// provenance describes the build system an AppDir was deployed on
+type provenance struct {
+	Version      int                 `json:"version"`
+	Created      string              `json:"created"`                // RFC 3339, from $SOURCE_DATE_EPOCH if set
+	Distribution string              `json:"distribution,omitempty"` // PRETTY_NAME in /etc/os-release
+	Kernel       string              `json:"kernel,omitempty"`
+	Architecture string              `json:"architecture"`
+	GitCommit    string              `json:"gitCommit,omitempty"` // Of the project in the current directory
+	Tools        map[string]string   `json:"tools"`               // Versions by name of the tool
+	Flags        []string            `json:"flags,omitempty"`     // Names of the flags given to deploy, without their values
+	Plugins      []string            `json:"plugins,omitempty"`   // Names of the plugins that ran
+	Packages     []provenancePackage `json:"packages,omitempty"`  // Owning the libraries that were bundled
+}
+
+// provenancePackage is a package of the build system that bundled files were copied from
+type provenancePackage struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture,omitempty"`
+}
+
+// writeProvenance records the build system, the versions of the tools used for the deployment,
+// and the packages that the libraries in the deployment cache were copied from in provenanceName
+func writeProvenance(ctx *deployContext, cache *deployCache) error {
+	appdir := ctx.appdir
+	log.Println("Writing", provenanceName+"...")
+	p := provenance{
+		Version:      provenanceVersion,
+		Created:      time.Now().UTC().Format(time.RFC3339),
+		Distribution: readOSReleaseValue("PRETTY_NAME"),
+		Architecture: runtime.GOARCH,
+		Tools:        map[string]string{"appimagetool": commit, "go": runtime.Version()},
+		Flags:        options.flags,
+		Plugins:      ctx.deployersRun,
+	}
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		p.Created = time.Unix(epoch, 0).UTC().Format(time.RFC3339)
+	}
+	if p.Tools["appimagetool"] == "" {
+		p.Tools["appimagetool"] = "unsupported custom build"
+	}
+	if kernel, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		p.Kernel = strings.TrimSpace(string(kernel))
+	}
+	if gitRepo, err := helpers.GetGitRepository(); err == nil {
+		if head, err := gitRepo.Head(); err == nil {
+			p.GitCommit = head.Hash().String()
+		}
+	}
+	for _, tool := range []string{"patchelf", "mksquashfs"} {
+		if version := toolVersion(tool); version != "" {
+			p.Tools[tool] = version
+		}
+	}
+
+	var sources []string
+	for path := range cache.Files {
+		if getTargetPathInAppDir(appdir, path) != path {
+			sources = append(sources, path)
+		}
+	}
+	lookUpOwningPackages(ctx, sources)
+	var names []string
+	for _, source := range sources {
+		if name := ctx.packagesContainingFiles[source]; name != "" {
+			names = helpers.AppendIfMissing(names, name)
+		}
+	}
+	sort.Strings(names)
+	p.Packages = lookUpPackageVersions(names)
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appdir.Path+"/"+provenanceName, data, 0644)
+}
+
+// toolVersion returns the first line that tool prints about its version, or an empty string if it is not available
+func toolVersion(tool string) string {
+	if helpers.IsCommandAvailable(tool) == false {
+		return ""
+	}
+	flag := "--version"
+	if tool == "mksquashfs" {
+		flag = "-version"
+	}
+	out, _ := exec.Command(tool, flag).CombinedOutput()
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+}
+
+// lookUpPackageVersions returns the installed versions of the packages with the given names,
+// using dpkg-query or rpm, whichever is available
+func lookUpPackageVersions(names []string) []provenancePackage {
+	if len(names) == 0 {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if helpers.IsCommandAvailable("dpkg-query") {
+		cmd = exec.Command("dpkg-query", append([]string{"-W", "-f", "${Package} ${Version} ${Architecture}\\n"}, names...)...)
+	} else if helpers.IsCommandAvailable("rpm") {
+		cmd = exec.Command("rpm", append([]string{"-q", "--queryformat", "%{NAME} %{VERSION}-%{RELEASE} %{ARCH}\\n"}, names...)...)
+	} else {
+		return nil
+	}
+	// Fails if one of the packages is not installed, but still prints the others
+	out, _ := cmd.Output()
+	var packages []provenancePackage
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && helpers.SliceContains(names, fields[0]) {
+			packages = append(packages, provenancePackage{Name: fields[0], Version: fields[1], Architecture: fields[2]})
+		}
+	}
+	return packages
+}
+
+// addRuntimeNotes returns the path of a copy of the runtime with an ELF note, owned by "AppImage", in each of the
+// given sections, so that their contents can be read without mounting the AppImage
+func addRuntimeNotes(runtimePath string, notes map[string][]byte) (string, error) {
+	var sections []string
+	for section := range notes {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	runtimeCopy, err := ioutil.TempFile("", "runtime")
+	if err != nil {
+		return "", err
+	}
+	runtimeCopy.Close()
+	args := []string{}
+	for _, section := range sections {
+		noteFile, err := ioutil.TempFile("", "note")
+		if err != nil {
+			os.Remove(runtimeCopy.Name())
+			return "", err
+		}
+		defer os.Remove(noteFile.Name())
+		_, err = noteFile.Write(buildELFNote("AppImage", 1, notes[section]))
+		noteFile.Close()
+		if err != nil {
+			os.Remove(runtimeCopy.Name())
+			return "", err
+		}
+		args = append(args, "--add-section", section+"="+noteFile.Name())
+	}
+	out, err := exec.Command("objcopy", append(args, runtimePath, runtimeCopy.Name())...).CombinedOutput()
+	if err != nil {
+		os.Remove(runtimeCopy.Name())
+		return "", errors.New("objcopy: " + string(out) + err.Error())
+	}
+	return runtimeCopy.Name(), os.Chmod(runtimeCopy.Name(), 0755)
+}
+
+// buildELFNote returns an ELF note: the sizes of the NUL-terminated owner name and of the description, the type,
+// and the name and the description, each padded to 4 bytes
+func buildELFNote(name string, noteType uint32, desc []byte) []byte {
+	var buf bytes.Buffer
+	pad := func() {
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	binary.Write(&buf, binary.LittleEndian, []uint32{uint32(len(name) + 1), uint32(len(desc)), noteType})
+	buf.WriteString(name + "\x00")
+	pad()
+	buf.Write(desc)
+	pad()
+	return buf.Bytes()
+}
+
+// newBuildInfo returns the build metadata for the AppImage being built from appdir
+func newBuildInfo(appdir string) buildInfo {
+	info := buildInfo{
+		Version:     buildInfoVersion,
+		Tool:        "appimagetool",
+		ToolVersion: appimagetoolVersion(),
+		Go:          runtime.Version(),
+		Flags:       options.flags,
+	}
+	if data, err := ioutil.ReadFile(appdir + "/" + provenanceName); err == nil {
+		var p provenance
+		if json.Unmarshal(data, &p) == nil {
+			info.DeployFlags, info.Plugins = p.Flags, p.Plugins
+		}
+	}
+	return info
+}
@@ -0,0 +1,292 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/urfave/cli/v2"
+)
+
+// Tag of the pre-release that builds of branches are published to, like uploadtool does.
+// The update information of AppImages built on CI points there, too
+const continuousTag = "continuous"
+
+var publishFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "backend",
+		Usage: "Where to publish to: github, webdav, s3 or obs (default: github)",
+	},
+	&cli.StringFlag{
+		Name:  "url",
+		Usage: "URL to upload to for the webdav, s3 and obs backends, e.g., https://dav.example.org/myapp, https://s3.eu-central-1.amazonaws.com/bucket/myapp or https://api.opensuse.org/source/home:user/MyApp",
+	},
+	&cli.StringFlag{
+		Name:  "region",
+		Usage: "Region of the S3 bucket (default: $AWS_REGION or us-east-1)",
+	},
+	&cli.StringFlag{
+		Name:  "user",
+		Usage: "User name for the webdav and obs backends (default: $PUBLISH_USER); the password is taken from $PUBLISH_PASSWORD",
+	},
+	&cli.StringFlag{
+		Name:  "config",
+		Usage: "File with the settings as name = value lines, e.g., backend = s3, which flags override (default: " + publishConfigName + " if it exists)",
+	},
+	&cli.StringFlag{
+		Name:  "repo",
+		Usage: "GitHub repository to publish to, e.g., owner/project (default: $GITHUB_REPOSITORY or $TRAVIS_REPO_SLUG)",
+	},
+	&cli.StringFlag{
+		Name:  "tag",
+		Usage: "Tag of the release (default: the tag being built, or " + continuousTag + " for builds of branches)",
+	},
+	&cli.StringFlag{
+		Name:  "commit",
+		Usage: "Commit to tag (default: $GITHUB_SHA or $TRAVIS_COMMIT)",
+	},
+}
+
+// publishTarget is the GitHub release that files are published to
+type publishTarget struct {
+	owner  string
+	repo   string
+	tag    string
+	commit string
+	// Pre-releases are replaced when a different commit is published to them
+	prerelease bool
+	// Link to the CI build for the description of the release
+	buildURL string
+}
+
+// publishTargetFromEnvironment determines the release to publish to from the environment of GitHub Actions
+// or Travis CI. Returns an error for builds of pull requests, which are not published
+func publishTargetFromEnvironment() (publishTarget, error) {
+	var target publishTarget
+	var slug, ref string
+	switch {
+	case os.Getenv("GITHUB_REPOSITORY") != "":
+		if os.Getenv("GITHUB_EVENT_NAME") == "pull_request" || strings.HasPrefix(os.Getenv("GITHUB_REF"), "refs/pull/") {
+			return target, errors.New("this is a build of a pull request")
+		}
+		slug = os.Getenv("GITHUB_REPOSITORY")
+		target.commit = os.Getenv("GITHUB_SHA")
+		if strings.HasPrefix(os.Getenv("GITHUB_REF"), "refs/tags/") {
+			ref = strings.TrimPrefix(os.Getenv("GITHUB_REF"), "refs/tags/")
+		}
+		if os.Getenv("GITHUB_RUN_ID") != "" {
+			server := os.Getenv("GITHUB_SERVER_URL")
+			if server == "" {
+				server = "https://github.com"
+			}
+			target.buildURL = server + "/" + slug + "/actions/runs/" + os.Getenv("GITHUB_RUN_ID")
+		}
+	case os.Getenv("TRAVIS_REPO_SLUG") != "":
+		if os.Getenv("TRAVIS_PULL_REQUEST") != "" && os.Getenv("TRAVIS_PULL_REQUEST") != "false" {
+			return target, errors.New("this is a build of a pull request")
+		}
+		slug = os.Getenv("TRAVIS_REPO_SLUG")
+		target.commit = os.Getenv("TRAVIS_COMMIT")
+		ref = os.Getenv("TRAVIS_TAG")
+		target.buildURL = os.Getenv("TRAVIS_BUILD_WEB_URL")
+	}
+	parts := strings.Split(slug, "/")
+	if len(parts) == 2 {
+		target.owner, target.repo = parts[0], parts[1]
+	}
+	if ref == "" || ref == continuousTag {
+		target.tag = continuousTag
+		target.prerelease = true
+	} else {
+		target.tag = ref
+	}
+	return target, nil
+}
+
+// filesToPublish returns the given files together with the .zsync files and detached signatures next to them
+func filesToPublish(paths []string) []string {
+	var files []string
+	for _, path := range paths {
+		files = append(files, path)
+		for _, suffix := range []string{".zsync", ".sig", ".asc"} {
+			if helpers.CheckIfFileExists(path + suffix) {
+				files = append(files, path+suffix)
+			}
+		}
+	}
+	return files
+}
+
+// tokenTransport authenticates requests to the GitHub API with a token
+type tokenTransport struct {
+	token string
+}
+
+func (t tokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.Header.Set("Authorization", "token "+t.token)
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// newGitHubClient returns a GitHub client that authenticates with token
+func newGitHubClient(token string) *github.Client {
+	return github.NewClient(&http.Client{Transport: tokenTransport{token}})
+}
+
+// publishToGitHub uploads the files to the release of target, creating it if needed, and returns its URL.
+// A pre-release such as continuous that was made for another commit is deleted together with its tag first,
+// so that it always has the files of the latest build. Assets with the same names as the files are replaced
+func publishToGitHub(client *github.Client, target publishTarget, files []string) (string, error) {
+	ctx := context.Background()
+	release, response, err := client.Repositories.GetReleaseByTag(ctx, target.owner, target.repo, target.tag)
+	if err != nil && (response == nil || response.StatusCode != http.StatusNotFound) {
+		return "", err
+	}
+	if err == nil && target.prerelease && target.commit != "" && release.GetTargetCommitish() != target.commit {
+		log.Println("Deleting the release", target.tag, "of", release.GetTargetCommitish())
+		_, err = client.Repositories.DeleteRelease(ctx, target.owner, target.repo, release.GetID())
+		if err != nil {
+			return "", err
+		}
+		// The tag needs to move to the new commit
+		response, err = client.Git.DeleteRef(ctx, target.owner, target.repo, "tags/"+target.tag)
+		if err != nil && (response == nil || response.StatusCode != http.StatusUnprocessableEntity) {
+			return "", err
+		}
+		release = nil
+	} else if err != nil {
+		release = nil
+	}
+
+	if release == nil {
+		name := target.tag
+		if target.prerelease {
+			name = "Continuous build"
+		}
+		body := ""
+		if target.buildURL != "" {
+			body = "Build log: " + target.buildURL
+		}
+		log.Println("Creating the release", target.tag, "in", target.owner+"/"+target.repo)
+		release, _, err = client.Repositories.CreateRelease(ctx, target.owner, target.repo, &github.RepositoryRelease{
+			TagName:         github.String(target.tag),
+			TargetCommitish: stringOrNil(target.commit),
+			Name:            github.String(name),
+			Body:            github.String(body),
+			Prerelease:      github.Bool(target.prerelease),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	assets, _, err := client.Repositories.ListReleaseAssets(ctx, target.owner, target.repo, release.GetID(), &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		for _, asset := range assets {
+			if asset.GetName() == name {
+				log.Println("Replacing", name)
+				_, err = client.Repositories.DeleteReleaseAsset(ctx, target.owner, target.repo, asset.GetID())
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		log.Println("Uploading", file+"...")
+		_, _, err = client.Repositories.UploadReleaseAsset(ctx, target.owner, target.repo, release.GetID(), &github.UploadOptions{Name: name}, f)
+		f.Close()
+		if err != nil {
+			return "", errors.New("could not upload " + file + ": " + err.Error())
+		}
+	}
+	return release.GetHTMLURL(), nil
+}
+
+func stringOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// publishGitHubRelease is the publisher for the github backend, which uploads to the release determined
+// by publishTargetFromEnvironment, or by the repo, tag and commit settings
+func publishGitHubRelease(settings publishSettings, files []string) (string, error) {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return "", errors.New("$GITHUB_TOKEN is needed to publish to GitHub, you can get one from https://github.com/settings/tokens")
+	}
+	target, err := publishTargetFromEnvironment()
+	if err != nil {
+		return "", err
+	}
+	if settings.repo != "" {
+		parts := strings.Split(settings.repo, "/")
+		if len(parts) != 2 {
+			return "", errors.New("invalid repository " + settings.repo + ", expected owner/project")
+		}
+		target.owner, target.repo = parts[0], parts[1]
+	}
+	if target.owner == "" {
+		return "", errors.New("could not determine the GitHub repository, please specify it with --repo owner/project")
+	}
+	if settings.tag != "" {
+		target.tag = settings.tag
+		target.prerelease = target.tag == continuousTag
+	}
+	if settings.commit != "" {
+		target.commit = settings.commit
+	}
+	return publishToGitHub(newGitHubClient(token), target, files)
+}
+
+// bootstrapPublish uploads AppImages with their .zsync files and signatures to where releases are published,
+// a GitHub release like uploadtool does unless another backend is configured
+//
+//	Args: c: cli.Context
+func bootstrapPublish(c *cli.Context) error {
+	if c.NArg() == 0 {
+		log.Fatal("Please specify the AppImages to publish")
+	}
+	for _, path := range c.Args().Slice() {
+		if helpers.CheckIfFileExists(path) == false {
+			log.Fatal(path + " does not exist")
+		}
+	}
+	settings, err := loadPublishSettings(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	publish, ok := publishers[settings.backend]
+	if ok == false {
+		log.Fatal("Unknown backend " + settings.backend + ", expected one of " + strings.Join(publisherNames(), ", "))
+	}
+	if _, err := publishTargetFromEnvironment(); err != nil {
+		log.Println("Not publishing because", err)
+		return nil
+	}
+	url, err := publish(settings, filesToPublish(c.Args().Slice()))
+	if err != nil {
+		helpers.PrintError("Publish", err)
+		os.Exit(1)
+	}
+	log.Println("Published to", url)
+	setCIOutput("release", url)
+	return nil
+}
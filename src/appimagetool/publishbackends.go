@@ -0,0 +1,306 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/ini.v1"
+)
+
+// Name of the file in the working directory from which the publish subcommand reads its settings if --config is not given
+const publishConfigName = "appimagetool-publish.ini"
+
+// publisher uploads files to where releases are published and returns the URL at which they can be found
+type publisher func(settings publishSettings, files []string) (string, error)
+
+// Backends of the publish subcommand by the names used with --backend
+var publishers = map[string]publisher{
+	"github": publishGitHubRelease,
+	"webdav": publishWebDAV,
+	"s3":     publishS3,
+	"obs":    publishOBS,
+}
+
+func publisherNames() []string {
+	var names []string
+	for name := range publishers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// publishSettings configure the publish subcommand. Secrets are only taken from the environment
+type publishSettings struct {
+	backend string
+	url     string
+	region  string
+	user    string
+	repo    string
+	tag     string
+	commit  string
+}
+
+// loadPublishSettings returns the settings from the flags of the publish subcommand
+// and, for flags that are not given, from the configuration file
+func loadPublishSettings(c *cli.Context) (publishSettings, error) {
+	config := make(map[string]string)
+	path := c.String("config")
+	if path == "" && helpers.CheckIfFileExists(publishConfigName) {
+		path = publishConfigName
+	}
+	if path != "" {
+		f, err := ini.Load(path)
+		if err != nil {
+			return publishSettings{}, errors.New("could not read " + path + ": " + err.Error())
+		}
+		for _, key := range f.Section("").Keys() {
+			config[key.Name()] = key.String()
+		}
+	}
+	setting := func(name string) string {
+		if c.IsSet(name) {
+			return c.String(name)
+		}
+		return config[name]
+	}
+	settings := publishSettings{
+		backend: setting("backend"),
+		url:     strings.TrimSuffix(setting("url"), "/"),
+		region:  setting("region"),
+		user:    setting("user"),
+		repo:    setting("repo"),
+		tag:     setting("tag"),
+		commit:  setting("commit"),
+	}
+	if settings.backend == "" {
+		settings.backend = "github"
+	}
+	if settings.user == "" {
+		settings.user = os.Getenv("PUBLISH_USER")
+	}
+	if settings.region == "" {
+		settings.region = os.Getenv("AWS_REGION")
+	}
+	if settings.region == "" {
+		settings.region = "us-east-1"
+	}
+	if settings.backend != "github" && settings.url == "" {
+		return settings, errors.New("the " + settings.backend + " backend needs the URL to upload to, please specify it with --url")
+	}
+	return settings, nil
+}
+
+// uploadFile sends the file at path as the body of a request with the given method to url,
+// after prepare (if not nil) has had the chance to add headers, and returns an error unless the server accepts it
+func uploadFile(method string, url string, path string, prepare func(request *http.Request) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(method, url, f)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = info.Size()
+	if prepare != nil {
+		err = prepare(request)
+		if err != nil {
+			return err
+		}
+	}
+	return doPublishRequest(request)
+}
+
+// doPublishRequest sends request and returns an error unless the server answers with 2xx
+func doPublishRequest(request *http.Request) error {
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return errors.New(request.Method + " " + request.URL.String() + ": " + response.Status + " " + strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// basicAuth adds the user of the settings and $PUBLISH_PASSWORD to requests
+func basicAuth(settings publishSettings) func(request *http.Request) error {
+	return func(request *http.Request) error {
+		if settings.user != "" {
+			request.SetBasicAuth(settings.user, os.Getenv("PUBLISH_PASSWORD"))
+		}
+		return nil
+	}
+}
+
+// publishWebDAV uploads the files into the WebDAV collection at the URL of the settings, creating it if needed
+func publishWebDAV(settings publishSettings, files []string) (string, error) {
+	request, err := http.NewRequest("MKCOL", settings.url+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	basicAuth(settings)(request)
+	// Fails with 405 Method Not Allowed if the collection exists already
+	if err := doPublishRequest(request); err != nil && strings.Contains(err.Error(), " 405 ") == false {
+		log.Println("WARNING: Could not create the collection:", err)
+	}
+	for _, file := range files {
+		log.Println("Uploading", file+"...")
+		err := uploadFile("PUT", settings.url+"/"+url.PathEscape(filepath.Base(file)), file, basicAuth(settings))
+		if err != nil {
+			return "", err
+		}
+	}
+	return settings.url + "/", nil
+}
+
+// publishOBS uploads the files into the package of the openSUSE Build Service (or another instance of OBS)
+// whose source URL is given by the settings, e.g., https://api.opensuse.org/source/home:user/MyApp,
+// and commits them as one revision, e.g., for building appliances from them
+func publishOBS(settings publishSettings, files []string) (string, error) {
+	for _, file := range files {
+		log.Println("Uploading", file+"...")
+		err := uploadFile("PUT", settings.url+"/"+url.PathEscape(filepath.Base(file))+"?rev=upload", file, basicAuth(settings))
+		if err != nil {
+			return "", err
+		}
+	}
+	comment := "Published by appimagetool"
+	if target, err := publishTargetFromEnvironment(); err == nil && target.commit != "" {
+		comment += " from " + target.commit
+	}
+	request, err := http.NewRequest("POST", settings.url+"?cmd=commit&comment="+url.QueryEscape(comment), nil)
+	if err != nil {
+		return "", err
+	}
+	basicAuth(settings)(request)
+	return settings.url, doPublishRequest(request)
+}
+
+// publishS3 uploads the files to S3-compatible object storage, e.g., Amazon S3 or MinIO, at the URL of the settings,
+// which addresses the bucket by path, e.g., https://s3.eu-central-1.amazonaws.com/bucket/prefix.
+// The requests are signed with $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and, if set, $AWS_SESSION_TOKEN
+func publishS3(settings publishSettings, files []string) (string, error) {
+	keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if keyID == "" || secret == "" {
+		return "", errors.New("$AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY are needed to publish to S3")
+	}
+	for _, file := range files {
+		digest, err := fsys.SHA256(fsys.OS, file)
+		if err != nil {
+			return "", err
+		}
+		log.Println("Uploading", file+"...")
+		err = uploadFile("PUT", settings.url+"/"+url.PathEscape(filepath.Base(file)), file, func(request *http.Request) error {
+			request.Header.Set("X-Amz-Content-Sha256", digest)
+			if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+				request.Header.Set("X-Amz-Security-Token", token)
+			}
+			signS3Request(request, keyID, secret, settings.region, time.Now())
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return settings.url + "/", nil
+}
+
+// signS3Request adds the AWS Signature Version 4 of request to it, signing the host and all headers of the request.
+// The hex-encoded SHA-256 digest of the body must be in the X-Amz-Content-Sha256 header already
+func signS3Request(request *http.Request, keyID string, secret string, region string, now time.Time) {
+	timestamp := now.UTC().Format("20060102T150405Z")
+	date := timestamp[:8]
+	request.Header.Set("X-Amz-Date", timestamp)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		awsURIEscape(request.URL.Path),
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		request.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalDigest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + hex.EncodeToString(canonicalDigest[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+keyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEscape percent-encodes everything in path but the unreserved characters and slashes, as AWS expects
+func awsURIEscape(path string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(path) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || strings.IndexByte("-_.~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			escaped.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return escaped.String()
+}
+
+// canonicalQuery returns the query parameters sorted by name and encoded as AWS expects
+func canonicalQuery(query url.Values) string {
+	var parameters []string
+	for name, values := range query {
+		for _, value := range values {
+			parameters = append(parameters, awsURIEscape(name)+"="+strings.ReplaceAll(awsURIEscape(value), "/", "%2F"))
+		}
+	}
+	sort.Strings(parameters)
+	return strings.Join(parameters, "&")
+}
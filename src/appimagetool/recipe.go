@@ -0,0 +1,305 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// recipe is a recipe in the format of pkg2appimage, which describes
+// which packages an AppImage is made of and how they are adapted
+type recipe struct {
+	App         string `yaml:"app"`
+	Binpatch    bool   `yaml:"binpatch"` // Patch /usr in the files to ././ so that they find their data relative to usr/
+	Union       bool   `yaml:"union"`
+	Ingredients struct {
+		Package    string           `yaml:"package"` // The package the version of the AppImage is taken from
+		Dist       string           `yaml:"dist"`
+		Sources    []string         `yaml:"sources"`
+		PPAs       []string         `yaml:"ppas"`
+		Packages   []string         `yaml:"packages"`
+		Exclude    []string         `yaml:"exclude"`
+		Pretend    []string         `yaml:"pretend"` // Packages in the form NAME VERSION that are treated as installed
+		Debs       []string         `yaml:"debs"`
+		Downloads  []recipeDownload `yaml:"downloads"`
+		Script     scriptLines      `yaml:"script"`
+		PostScript scriptLines      `yaml:"post_script"`
+	} `yaml:"ingredients"`
+	Script scriptLines `yaml:"script"`
+}
+
+// recipeDownload is a file that is downloaded into the build directory before the ingredients script runs
+type recipeDownload struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+	Name   string `yaml:"name"` // Of the file in the build directory, by default the last part of the URL
+}
+
+// scriptLines are the lines of a script in a recipe,
+// which can be given as a list or as a single (multi-line) string
+type scriptLines []string
+
+// UnmarshalYAML accepts both a list of lines and a string
+func (s *scriptLines) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if unmarshal(&text) == nil {
+		*s = scriptLines{text}
+		return nil
+	}
+	var lines []string
+	err := unmarshal(&lines)
+	*s = lines
+	return err
+}
+
+// recipeFunctions are the functions of pkg2appimage's functions.sh that recipe scripts commonly use,
+// reduced to what is still needed because deploying takes care of AppRun, libraries and the excludelist
+const recipeFunctions = `
+get_apprun() { :; }
+get_desktopintegration() { :; }
+delete_blacklisted() { :; }
+copy_deps() { :; }
+move_lib() { :; }
+fix_desktop() { :; }
+get_desktop() { find usr/share/applications -iname "*${LOWERAPP}.desktop" -exec cp {} . \; || true; }
+get_icon() {
+	find ./usr/share/pixmaps/${LOWERAPP}.png -exec cp {} . \; 2>/dev/null || true
+	find ./usr/share/icons -path "*256*" -name "${LOWERAPP}.png" -exec cp {} . \; 2>/dev/null || true
+	find ./usr/share/icons -path "*48*" -name "${LOWERAPP}.png" -exec cp {} . \; 2>/dev/null || true
+	find ./usr/share/icons -name "${LOWERAPP}.svg" -exec cp {} . \; 2>/dev/null || true
+}
+`
+
+// Flags of the recipe subcommand
+var recipeFlags = []cli.Flag{packageArchFlag, proxyFlag}
+
+// readRecipe reads and checks the recipe at path
+func readRecipe(path string) (recipe, error) {
+	var r recipe
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	err = yaml.Unmarshal(data, &r)
+	if err != nil {
+		return r, err
+	}
+	if r.App == "" {
+		return r, errors.New(path + " has no app key")
+	}
+	if r.Ingredients.Package == "" {
+		r.Ingredients.Package = strings.ToLower(r.App)
+		if len(r.Ingredients.Packages) > 0 {
+			r.Ingredients.Package = r.Ingredients.Packages[0]
+		}
+	}
+	return r, nil
+}
+
+// bootstrapRecipe builds the AppImage described by a pkg2appimage recipe like pkg2appimage does:
+// the ingredients are downloaded and run in <app>/, put into <app>/<app>.AppDir, adapted by the script
+// of the recipe, deployed, and turned into an AppImage in out/ in the current directory
+//
+//	Args: c: cli.Context
+func bootstrapRecipe(c *cli.Context) error {
+	if c.NArg() != 1 {
+		log.Fatal("Please specify a pkg2appimage recipe, e.g., " + filepath.Base(os.Args[0]) + " recipe recipes/Hello.yml")
+	}
+	r, err := readRecipe(c.Args().Get(0))
+	if err != nil {
+		helpers.PrintError("Could not read the recipe", err)
+		os.Exit(1)
+	}
+	buildDir, err := filepath.Abs(r.App)
+	if err != nil {
+		helpers.PrintError("Could not determine the build directory", err)
+		os.Exit(1)
+	}
+	path := buildDir + "/" + r.App + ".AppDir"
+	if helpers.Exists(path) {
+		log.Fatal(path + " already exists")
+	}
+	err = os.MkdirAll(buildDir, 0755)
+	if err != nil {
+		helpers.PrintError("Could not create "+buildDir, err)
+		os.Exit(1)
+	}
+	if r.Union {
+		log.Println("WARNING: union is ignored, the AppImage contains the libraries it needs instead")
+	}
+
+	cache := newDownloadCache(c)
+	for _, d := range r.Ingredients.Downloads {
+		name := d.Name
+		if name == "" {
+			name = filepath.Base(strings.SplitN(d.URL, "?", 2)[0])
+		}
+		log.Println("Downloading", d.URL+"...")
+		file, err := cache.Fetch(d.URL, d.SHA256)
+		if err == nil {
+			err = helpers.CopyFile(file, buildDir+"/"+filepath.Base(name))
+		}
+		if err != nil {
+			helpers.PrintError("Could not download the ingredient "+d.URL, err)
+			os.Exit(1)
+		}
+	}
+
+	env := []string{"APP=" + r.App, "LOWERAPP=" + strings.ToLower(r.App), "ARCH=" + packageArchitecture("", rpmArchitectures)}
+	runRecipeScript("ingredients script", r.Ingredients.Script, buildDir, env)
+
+	spec := packageSpec{
+		sources:  r.Ingredients.Sources,
+		packages: r.Ingredients.Packages,
+		exclude:  r.Ingredients.Exclude,
+		arch:     c.String("arch"),
+		cache:    cache,
+	}
+	if len(spec.sources) == 0 {
+		spec.sources = readSystemDebSources()
+	}
+	if len(r.Ingredients.PPAs) > 0 {
+		dist := r.Ingredients.Dist
+		if dist == "" {
+			dist = readOSReleaseValue("VERSION_CODENAME")
+		}
+		if dist == "" {
+			log.Fatal("The recipe uses PPAs but has no dist key, and the distribution of this system is unknown")
+		}
+		for _, ppa := range r.Ingredients.PPAs {
+			spec.sources = append(spec.sources, "deb https://ppa.launchpadcontent.net/"+strings.TrimPrefix(ppa, "ppa:")+"/ubuntu "+dist+" main")
+		}
+	}
+	for _, pretend := range r.Ingredients.Pretend {
+		if fields := strings.Fields(pretend); len(fields) > 0 {
+			spec.exclude = append(spec.exclude, fields[0])
+		}
+	}
+	// Like pkg2appimage, use the debs of the recipe and those the ingredients script put into the build directory
+	for _, pattern := range append(r.Ingredients.Debs, "*.deb") {
+		if filepath.IsAbs(pattern) == false {
+			pattern = buildDir + "/" + pattern
+		}
+		debs, _ := filepath.Glob(pattern)
+		for _, deb := range debs {
+			if helpers.SliceContains(spec.debs, deb) == false {
+				spec.debs = append(spec.debs, deb)
+			}
+		}
+	}
+	if len(spec.packages) == 0 && len(spec.debs) == 0 {
+		// pkg2appimage installs the package named like the app in this case, package names being lowercase
+		spec.packages = []string{r.Ingredients.Package}
+	}
+
+	packages, err := fetchPackagesIntoAppDir(spec, path)
+	if err != nil {
+		helpers.PrintError("Could not put the packages into "+path, err)
+		os.Exit(exitUnresolvedDependencies)
+	}
+	version := os.Getenv("VERSION")
+	for _, p := range packages {
+		if version == "" && p.Name == r.Ingredients.Package {
+			// Like pkg2appimage, leave out the epoch
+			version = p.Version[strings.Index(p.Version, ":")+1:]
+			os.Setenv("VERSION", version)
+		}
+	}
+	if version != "" {
+		env = append(env, "VERSION="+version)
+	}
+	runRecipeScript("ingredients post_script", r.Ingredients.PostScript, buildDir, env)
+	runRecipeScript("script", r.Script, path, env)
+
+	desktopFile, err := findDesktopFileOfRecipe(path, r.App)
+	if err != nil {
+		helpers.PrintError("AppDir", err)
+		os.Exit(exitInvalidAppDir)
+	}
+	setDeployOptions(c)
+	if r.Binpatch {
+		options.relocations = append(options.relocations, "/usr=././")
+	}
+	AppDirDeploy(desktopFile)
+
+	err = os.MkdirAll("out", 0755)
+	if err == nil {
+		err = os.Chdir("out")
+	}
+	if err != nil {
+		helpers.PrintError("Could not change into out", err)
+		os.Exit(1)
+	}
+	GenerateAppImage(path)
+	return nil
+}
+
+// runRecipeScript runs the lines of a script in a recipe with bash in dir, like pkg2appimage does,
+// with the functions of pkg2appimage that recipes commonly use. Exits if the script fails
+func runRecipeScript(name string, lines scriptLines, dir string, env []string) {
+	if len(lines) == 0 {
+		return
+	}
+	shell := "bash"
+	if _, err := exec.LookPath(shell); err != nil {
+		shell = "sh"
+	}
+	log.Println("Running the", name, "of the recipe...")
+	cmd := exec.Command(shell, "-ex", "-c", recipeFunctions+strings.Join(lines, "\n"))
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	err := cmd.Run()
+	if err != nil {
+		helpers.PrintError("The "+name+" of the recipe failed", err)
+		os.Exit(1)
+	}
+}
+
+// findDesktopFileOfRecipe returns the desktop file in usr/share/applications of the AppDir at path to deploy.
+// If the script of the recipe put a desktop file into the top level of the AppDir, as pkg2appimage recipes do,
+// it replaces the one in usr/share/applications so that the changes the script made to it are kept
+func findDesktopFileOfRecipe(path string, app string) (string, error) {
+	topLevel, _ := filepath.Glob(path + "/*.desktop")
+	if len(topLevel) > 1 {
+		return "", errors.New("the script of the recipe put several desktop files into " + path)
+	}
+	if len(topLevel) == 1 {
+		desktopFile := path + "/usr/share/applications/" + filepath.Base(topLevel[0])
+		err := os.MkdirAll(filepath.Dir(desktopFile), 0755)
+		if err == nil {
+			err = helpers.CopyFile(topLevel[0], desktopFile)
+		}
+		return desktopFile, err
+	}
+	return findDesktopFileOfPackages(path, strings.ToLower(app))
+}
+
+// readOSReleaseValue returns the value of key in /etc/os-release, or an empty string
+func readOSReleaseValue(key string) string {
+	f, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), key+"=") {
+			return strings.Trim(strings.TrimPrefix(scanner.Text(), key+"="), `"`)
+		}
+	}
+	return ""
+}
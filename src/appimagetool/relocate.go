@@ -0,0 +1,180 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// absolutePathRegexp matches absolute paths below the directories in which
+// applications typically expect their data and configuration to be
+var absolutePathRegexp = regexp.MustCompile(`/(usr|etc|opt|var|srv)(/[A-Za-z0-9._+@%-]+)+`)
+
+// absolutePathReference is an absolute path hardcoded in a file in the AppDir
+type absolutePathReference struct {
+	file     string // File in the AppDir that contains the path
+	path     string // The absolute path as found in the file
+	inAppDir bool   // True if the path also exists inside the AppDir
+}
+
+// handleAbsolutePaths reports the hardcoded absolute paths in the files that were put into the
+// AppDir by the user (as opposed to the libraries deployed from the build system), applies the
+// same-length replacements requested with --relocate, and patches the references of the main
+// executable to files that are inside the AppDir below usr/ to be relative to usr/.
+// In the latter case, AppRun has to change into usr/ for the relative paths to resolve
+func handleAbsolutePaths(ctx *deployContext) {
+	appdir := ctx.appdir
+	log.Println("Scanning the AppDir for hardcoded absolute paths...")
+	refs := scanForAbsolutePaths(ctx)
+	printRelocationReport(appdir, refs)
+
+	for _, relocation := range options.relocations {
+		parts := strings.SplitN(relocation, "=", 2)
+		if len(parts) != 2 {
+			log.Println("Ignoring --relocate", relocation, "because it is not in the form OLD=NEW")
+			continue
+		}
+		for _, ref := range refs {
+			if strings.HasPrefix(ref.path, parts[0]) == false {
+				continue
+			}
+			err := patchSameLength(ref.file, ref.path, parts[1]+strings.TrimPrefix(ref.path, parts[0]))
+			if err != nil {
+				helpers.PrintError("Could not relocate "+ref.path+" in "+ref.file, err)
+				os.Exit(exitPatchFailure)
+			}
+			if strings.HasPrefix(parts[1], "./") {
+				addAppRunSection(ctx, "Change into usr/ because absolute paths were patched to be relative to it", `cd "${HERE}/usr"`)
+			}
+		}
+	}
+
+	for _, ref := range refs {
+		if ref.file != appdir.MainExecutable || ref.inAppDir == false || strings.HasPrefix(ref.path, "/usr/") == false {
+			continue
+		}
+		// "/usr/share/myapp/main.ui" becomes "././/share/myapp/main.ui", which has the same length
+		err := patchSameLength(ref.file, ref.path, "././"+strings.TrimPrefix(ref.path, "/usr"))
+		if err != nil {
+			helpers.PrintError("Could not relocate "+ref.path+" in "+ref.file, err)
+			os.Exit(exitPatchFailure)
+		}
+		addAppRunSection(ctx, "Change into usr/ because absolute paths were patched to be relative to it", `cd "${HERE}/usr"`)
+	}
+}
+
+// scanForAbsolutePaths returns the hardcoded absolute paths in the ELF and text files in the AppDir,
+// not counting the libraries that were deployed into the AppDir from the build system
+func scanForAbsolutePaths(ctx *deployContext) []absolutePathReference {
+	appdir := ctx.appdir
+	var refs []absolutePathReference
+	filepath.Walk(appdir.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode().IsRegular() == false {
+			return nil
+		}
+		if isDeployedFromBuildSystem(ctx, path) {
+			return nil
+		}
+		// Mapped rather than read, since the AppDir may contain binaries of hundreds of MB
+		m, err := fsys.Map(fsys.OS, path)
+		if err != nil {
+			return nil
+		}
+		defer m.Close()
+		var found []string
+		err = m.Access(func(data []byte) {
+			if bytes.HasPrefix(data, []byte("\x7fELF")) == false && isTextData(data) == false {
+				return
+			}
+			for _, match := range absolutePathRegexp.FindAll(data, -1) {
+				found = helpers.AppendIfMissing(found, string(match))
+			}
+		})
+		if err != nil {
+			log.Println("WARNING: Could not scan", path+":", err)
+			return nil
+		}
+		for _, p := range found {
+			refs = append(refs, absolutePathReference{
+				file:     path,
+				path:     p,
+				inAppDir: helpers.Exists(appdir.Path + p),
+			})
+		}
+		return nil
+	})
+	return refs
+}
+
+// isDeployedFromBuildSystem returns true if the file at path in the AppDir
+// is one of the ELFs that were copied into it from the build system,
+// or belongs to the glibc family of files which are deployed separately
+func isDeployedFromBuildSystem(ctx *deployContext, path string) bool {
+	appdir := ctx.appdir
+	if checkWhetherPartOfLibc(path) {
+		return true
+	}
+	for _, lib := range ctx.elfs {
+		if strings.HasPrefix(lib, appdir.Path) == false && appdir.Path+lib == path {
+			return true
+		}
+	}
+	return false
+}
+
+// isTextData returns true if data looks like text, using the same heuristic as git:
+// text does not contain NUL bytes near the beginning
+func isTextData(data []byte) bool {
+	n := len(data)
+	if n > 8000 {
+		n = 8000
+	}
+	return bytes.IndexByte(data[:n], 0) == -1
+}
+
+// printRelocationReport prints the hardcoded absolute paths,
+// pointing out the ones that will most likely not work from within the AppImage
+func printRelocationReport(appdir helpers.AppDir, refs []absolutePathReference) {
+	if len(refs) == 0 {
+		log.Println("No hardcoded absolute paths found")
+		return
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].file < refs[j].file })
+	log.Println("Hardcoded absolute paths found:")
+	var current string
+	for _, ref := range refs {
+		if ref.file != current {
+			current = ref.file
+			fmt.Println(strings.TrimPrefix(ref.file, appdir.Path+"/") + ":")
+		}
+		if ref.inAppDir {
+			fmt.Println("    " + ref.path + " (exists in the AppDir; will not be found when running from the AppImage)")
+		} else {
+			fmt.Println("    " + ref.path)
+		}
+		helpers.RecordDiagnostic(helpers.LevelWarning, strings.TrimPrefix(ref.file, appdir.Path+"/")+" contains the absolute path "+ref.path)
+	}
+	log.Println("Use --relocate OLD=NEW to replace paths by ones of the same length, e.g., /usr/share/foo=././/share/foo")
+}
+
+// patchSameLength replaces all occurrences of search in the file at path by replace, which must
+// have the same length so that offsets in binaries do not change
+func patchSameLength(path string, search string, replace string) error {
+	if len(search) != len(replace) {
+		return errors.New("'" + replace + "' does not have the same length as '" + search + "'")
+	}
+	log.Println("Patching", search, "to", replace, "in", path)
+	return PatchFile(path, search, replace)
+}
@@ -0,0 +1,291 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+	"github.com/probonopd/go-appimage/pkg/fsys"
+)
+
+// Policies for the rpaths written into the ELFs in the AppDir, selected with --rpath
+const (
+	rpathPolicyMinimal = "minimal" // Only the directories of the libraries an ELF needs, and those of libraries that may be loaded with dlopen()
+	rpathPolicyFull    = "full"    // All library locations in the AppDir
+)
+
+var rpathPolicies = []string{rpathPolicyMinimal, rpathPolicyFull}
+
+// Longer rpaths are reported. patchelf has no fixed limit, but it has to grow .dynstr and
+// rewrite the program headers for them, which is where it tends to break ELFs, and ld.so
+// expands every entry for every library it looks up
+const maxRpathLength = 4096
+
+// computeRpath returns the rpath for the ELF lib, given as in ctx.elfs, once it is in the AppDir.
+// It starts with the entries of the original rpath of the ELF that point to directories in the AppDir
+// using $ORIGIN, see keptRpathEntries, followed by the library locations in the AppDir.
+// With --rpath=minimal, these are the directories in the AppDir that the libraries it needs are deployed to,
+// followed by the directories in which there are libraries that no ELF needs, since these are probably
+// loaded with dlopen(), which looks in the rpath of the ELF calling it. ELFs that have not been walked get
+// all libraryLocationsInAppDir, as with --rpath=full.
+// Directories with ELFs of other architectures only, e.g., the 32-bit libraries in an AppDir
+// that also has 64-bit ones, are left out so that rpaths never mix architectures
+func computeRpath(ctx *deployContext, libraryLocationsInAppDir []string, lib string) string {
+	appdir := ctx.appdir
+	target := getTargetPathInAppDir(appdir, lib)
+	locations := libraryLocationsInAppDir
+	if options.rpath != rpathPolicyFull && ctx.walker.Graph.Contains(lib) {
+		locations = nil
+		for _, dependency := range ctx.walker.Graph.Dependencies[lib] {
+			if helpers.SliceContains(ctx.elfs, dependency) {
+				locations = helpers.AppendIfMissing(locations, filepath.Dir(getTargetPathInAppDir(appdir, dependency)))
+			}
+		}
+		for _, location := range dlopenLocationsInAppDir(ctx) {
+			locations = helpers.AppendIfMissing(locations, location)
+		}
+	}
+	newRpathStrings := keptRpathEntries(ctx, target)
+	arch, err := elfdeps.ReadArch(appdirFS, lib)
+	for _, libloc := range locations {
+		if err == nil && hasOtherArchitecturesOnly(ctx, libloc, arch) {
+			continue
+		}
+		relpath, err := filepath.Rel(filepath.Dir(target), libloc)
+		if err != nil {
+			helpers.PrintError("Could not compute relative path", err)
+		}
+		// The dynamic linker splits the rpath at colons and substitutes tokens that start with $,
+		// other characters such as spaces are fine
+		if strings.ContainsAny(relpath, ":$") {
+			log.Println("WARNING: Cannot add", libloc, "to the rpath of", target, "because its path contains ':' or '$'")
+			continue
+		}
+		newRpathStrings = helpers.AppendIfMissing(newRpathStrings, "$ORIGIN/"+filepath.Clean(relpath))
+	}
+	return strings.Join(newRpathStrings, ":")
+}
+
+// keptRpathEntries returns the entries of the rpath of the ELF at path in the AppDir that use $ORIGIN
+// to point to existing directories in the AppDir, in the form computeRpath writes them.
+// Other entries are dropped: absolute ones point to the build system, and others would not work elsewhere.
+// The rpath is the one the ELF had before it was first patched, see backUpELF, so that the library
+// locations a deployment added are not kept when deploying again
+func keptRpathEntries(ctx *deployContext, path string) []string {
+	appdir := ctx.appdir
+	if fsys.Exists(appdirFS, path) == false {
+		return nil
+	}
+	var rpaths []string
+	if backup, ok := ctx.elfBackups[strings.TrimPrefix(path, appdir.Path+"/")]; ok {
+		if backup.rpath != "" {
+			rpaths = strings.Split(backup.rpath, ":")
+		}
+	} else {
+		rpaths, _ = readRpaths(path)
+	}
+	var kept []string
+	for _, entry := range rpaths {
+		normalized := strings.Replace(entry, "${ORIGIN}", "$ORIGIN", -1)
+		if strings.HasPrefix(normalized, "$ORIGIN") == false || strings.Count(normalized, "$") > 1 {
+			if entry != "" {
+				log.Println("Dropping", entry, "from the rpath of", path)
+			}
+			continue
+		}
+		dir := filepath.Clean(strings.Replace(normalized, "$ORIGIN", filepath.Dir(path), 1))
+		if strings.HasPrefix(dir+"/", appdir.Path+"/") == false || fsys.IsDirectory(appdirFS, dir) == false {
+			log.Println("Dropping", entry, "from the rpath of", path, "since it does not point to a directory in the AppDir")
+			continue
+		}
+		relpath, _ := filepath.Rel(filepath.Dir(path), dir)
+		kept = helpers.AppendIfMissing(kept, "$ORIGIN/"+relpath)
+	}
+	return kept
+}
+
+// dlopenLocationsInAppDir returns the directories in the AppDir to which libraries
+// are deployed that no ELF needs
+func dlopenLocationsInAppDir(ctx *deployContext) []string {
+	appdir := ctx.appdir
+	if ctx.dlopenLocationsComputed {
+		return ctx.dlopenLocations
+	}
+	needed := make(map[string]bool)
+	for _, dependencies := range ctx.walker.Graph.Dependencies {
+		for _, dependency := range dependencies {
+			needed[dependency] = true
+		}
+	}
+	ctx.dlopenLocations = nil
+	for _, lib := range ctx.elfs {
+		if needed[lib] == false && isSharedLibrary(ctx, lib) {
+			ctx.dlopenLocations = helpers.AppendIfMissing(ctx.dlopenLocations, filepath.Dir(getTargetPathInAppDir(appdir, lib)))
+		}
+	}
+	ctx.dlopenLocationsComputed = true
+	return ctx.dlopenLocations
+}
+
+// hasOtherArchitecturesOnly returns true if all ELFs deployed to the directory location in the AppDir
+// have another architecture than arch. Directories without deployed ELFs are not known to
+func hasOtherArchitecturesOnly(ctx *deployContext, location string, arch elfdeps.Arch) bool {
+	archs := architecturesInAppDir(ctx)[location]
+	return len(archs) > 0 && containsArch(archs, arch) == false
+}
+
+// architecturesInAppDir returns the architectures of the ELFs in ctx.elfs by the directory in the AppDir they are deployed to
+func architecturesInAppDir(ctx *deployContext) map[string][]elfdeps.Arch {
+	appdir := ctx.appdir
+	if ctx.locationArchitectures != nil {
+		return ctx.locationArchitectures
+	}
+	ctx.locationArchitectures = make(map[string][]elfdeps.Arch)
+	for _, lib := range ctx.elfs {
+		arch, err := elfdeps.ReadArch(appdirFS, lib)
+		if err != nil {
+			continue
+		}
+		location := filepath.Dir(getTargetPathInAppDir(appdir, lib))
+		if containsArch(ctx.locationArchitectures[location], arch) == false {
+			ctx.locationArchitectures[location] = append(ctx.locationArchitectures[location], arch)
+		}
+	}
+	var all []elfdeps.Arch
+	for _, archs := range ctx.locationArchitectures {
+		for _, arch := range archs {
+			if containsArch(all, arch) == false {
+				all = append(all, arch)
+			}
+		}
+	}
+	if len(all) > 1 {
+		log.Println("The AppDir contains ELFs of", len(all), "architectures, the rpath of each only points to directories with libraries of its own")
+	}
+	return ctx.locationArchitectures
+}
+
+func containsArch(archs []elfdeps.Arch, arch elfdeps.Arch) bool {
+	for _, a := range archs {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// resetRpathPlanning makes computeRpath take ELFs added to ctx.elfs since it was last called into account
+func resetRpathPlanning(ctx *deployContext) {
+	ctx.dlopenLocationsComputed = false
+	ctx.locationArchitectures = nil
+}
+
+// validateRpath reports an rpath of the ELF at path in the AppDir that is too long,
+// or that has entries pointing outside the AppDir, which break once the AppImage is run elsewhere.
+// Returns false if it did
+func validateRpath(appdir helpers.AppDir, path string, rpath string) bool {
+	valid := true
+	if len(rpath) > maxRpathLength {
+		log.Println("WARNING: The rpath of", path, "is", len(rpath), "characters long, more than", maxRpathLength)
+		valid = false
+	}
+	for _, entry := range strings.Split(rpath, ":") {
+		if entry == "" {
+			continue
+		}
+		resolved := strings.Replace(strings.Replace(entry, "${ORIGIN}", "$ORIGIN", -1), "$ORIGIN", filepath.Dir(path), -1)
+		if strings.HasPrefix(resolved, "$") {
+			// $LIB or $PLATFORM, which depend on the system
+			continue
+		}
+		if filepath.IsAbs(entry) {
+			log.Println("WARNING: The rpath of", path, "contains the absolute path", entry, "which is outside of the AppDir")
+			valid = false
+		} else if resolved = filepath.Clean(resolved); resolved != appdir.Path && strings.HasPrefix(resolved, appdir.Path+"/") == false {
+			log.Println("WARNING: The rpath of", path, "contains", entry, "which escapes the AppDir to", resolved)
+			valid = false
+		}
+	}
+	return valid
+}
+
+// checkDeployedRpaths reads the rpaths back from the ELFs deployed into the AppDir after patching and reports
+// entries that point outside of the AppDir or to directories that do not exist, which would make libraries
+// be loaded from the system the AppImage runs on, or not at all. With --prune-rpaths, these entries are removed.
+// Returns the ELFs, given as in ctx.elfs, whose rpath was changed
+func checkDeployedRpaths(ctx *deployContext) []string {
+	appdir := ctx.appdir
+	log.Println("Checking the rpaths of the deployed ELFs...")
+	var changed []string
+	problems := 0
+	for _, lib := range ctx.elfs {
+		path := getTargetPathInAppDir(appdir, lib)
+		if fsys.Exists(appdirFS, path) == false {
+			continue
+		}
+		rpath, err := helpers.ReadElfRpathFS(appdirFS, path)
+		if err != nil || rpath == "" {
+			continue
+		}
+		pruned, reasons := pruneRpath(appdir, path, rpath)
+		if len(reasons) == 0 {
+			continue
+		}
+		problems += len(reasons)
+		for _, reason := range reasons {
+			log.Println("WARNING: The rpath of", path, reason)
+		}
+		if options.pruneRpaths == false {
+			continue
+		}
+		log.Println("Setting the rpath of", path, "to", "'"+pruned+"'")
+		backUpELF(ctx, path)
+		err = setRpath(path, pruned)
+		if err != nil {
+			helpers.PrintError("Could not set the rpath of "+path, err)
+			continue
+		}
+		changed = append(changed, lib)
+	}
+	if problems > 0 && options.pruneRpaths == false {
+		log.Println("Found", problems, "rpath entries that do not point to directories in the AppDir, use --prune-rpaths to remove them")
+	}
+	return changed
+}
+
+// pruneRpath returns rpath, the rpath of the ELF at path in the AppDir, without the entries that resolve
+// to a location outside of the AppDir or to a directory that does not exist, and the reasons for removing them
+func pruneRpath(appdir helpers.AppDir, path string, rpath string) (string, []string) {
+	var kept []string
+	var reasons []string
+	for _, entry := range strings.Split(rpath, ":") {
+		if entry == "" {
+			continue
+		}
+		resolved := strings.Replace(strings.Replace(entry, "${ORIGIN}", "$ORIGIN", -1), "$ORIGIN", filepath.Dir(path), -1)
+		if strings.Contains(resolved, "$") {
+			// $LIB or $PLATFORM, which depend on the system
+			kept = append(kept, entry)
+			continue
+		}
+		if filepath.IsAbs(entry) == false && strings.HasPrefix(entry, "$") == false {
+			// Relative to the working directory of the process
+			reasons = append(reasons, "contains "+entry+" which is relative to the current directory rather than to $ORIGIN")
+			continue
+		}
+		resolved = filepath.Clean(resolved)
+		if resolved != appdir.Path && strings.HasPrefix(resolved, appdir.Path+"/") == false {
+			reasons = append(reasons, "contains "+entry+" which points outside of the AppDir to "+resolved)
+		} else if fsys.IsDirectory(appdirFS, resolved) == false {
+			reasons = append(reasons, "contains "+entry+" which points to "+resolved+", a directory that does not exist")
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	return strings.Join(kept, ":"), reasons
+}
@@ -0,0 +1,100 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/gschema"
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Where GLib looks for the schemas of the host system
+const hostSchemasDir = "/usr/share/glib-2.0/schemas"
+
+// handleGlibSchemas compiles GLib schemas if the subdirectory is present in the AppImage,
+// after adding the override files given with --gschema-override.
+// If glib-compile-schemas is not available on the build system, a built-in compiler is used.
+// AppRun has to export GSETTINGS_SCHEMA_DIR for this to work
+func handleGlibSchemas(appdir helpers.AppDir) error {
+	schemasDir := appdir.Path + "/usr/share/glib-2.0/schemas"
+	if helpers.Exists(schemasDir) == false {
+		if len(options.gschemaOverrides) > 0 {
+			log.Println("WARNING: Not adding GSettings schema overrides, the AppDir does not contain", schemasDir)
+		}
+		return nil
+	}
+
+	for _, override := range options.gschemaOverrides {
+		if strings.HasSuffix(override, ".gschema.override") == false {
+			return errors.New(override + " is not a .gschema.override file")
+		}
+		log.Println("Adding GSettings schema override", override)
+		err := helpers.CopyFile(override, schemasDir+"/"+filepath.Base(override))
+		if err != nil {
+			return err
+		}
+	}
+
+	checkSchemaConflicts(schemasDir)
+
+	// Existing compiled schemas do not contain the overrides that were just added
+	if helpers.Exists(schemasDir+"/gschemas.compiled") && len(options.gschemaOverrides) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath("glib-compile-schemas"); err != nil {
+		log.Println("glib-compile-schemas not found, compiling glib-2.0 schemas with the built-in compiler...")
+		return gschema.Compile(schemasDir)
+	}
+	log.Println("Compiling glib-2.0 schemas...")
+	cmd := exec.Command("glib-compile-schemas", ".")
+	cmd.Dir = schemasDir
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// checkSchemaConflicts reports schema IDs that are defined more than once in the AppDir,
+// and schema IDs that are also installed on the build system. Since AppRun puts the bundled
+// schemas first in GSETTINGS_SCHEMA_DIR, those shadow the schemas of the target system,
+// while both versions share the same settings in dconf
+func checkSchemaConflicts(schemasDir string) {
+	ids, err := gschema.SchemaIDs(schemasDir)
+	if err != nil {
+		log.Println("WARNING: Could not read the bundled GSettings schemas:", err)
+		return
+	}
+	hostIDs, err := gschema.SchemaIDs(hostSchemasDir)
+	if err != nil {
+		log.Println("WARNING: Could not read the GSettings schemas in", hostSchemasDir+":", err)
+	}
+
+	var shadowed []string
+	for id, files := range ids {
+		if len(files) > 1 {
+			for i := range files {
+				files[i] = filepath.Base(files[i])
+			}
+			log.Println("ERROR: GSettings schema", id, "is defined more than once, in", strings.Join(files, ", "))
+		}
+		if _, ok := hostIDs[id]; ok {
+			shadowed = append(shadowed, id)
+		}
+	}
+	if len(shadowed) == 0 {
+		return
+	}
+	sort.Strings(shadowed)
+	log.Println("WARNING: These bundled GSettings schemas are also installed on the build system.")
+	log.Println("They take precedence over the schemas of the target system and share their settings in dconf,")
+	log.Println("which can break either application if the schemas differ between versions:")
+	for _, id := range shadowed {
+		log.Println("   ", id)
+	}
+}
@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Policies for setuid/setgid files and files with capabilities in the AppDir, selected with --setuid
+const (
+	setuidPolicyWarn      = "warn"       // Report them and continue
+	setuidPolicyFail      = "fail"       // Report them and abort
+	setuidPolicyNoSandbox = "no-sandbox" // Report them and make Chromium-based applications run without the setuid sandbox if needed
+)
+
+var setuidPolicies = []string{setuidPolicyWarn, setuidPolicyFail, setuidPolicyNoSandbox}
+
+// Names of the setuid helpers that Chromium and Electron use for sandboxing
+// if unprivileged user namespaces are not available
+var sandboxHelpers = []string{"chrome-sandbox", "chrome_sandbox"}
+
+// handleSetuidFiles finds files in the AppDir that need the setuid or setgid bit or file capabilities.
+// AppImages are mounted with nosuid and squashfs images made by us do not carry extended attributes,
+// hence these files will not work as intended when running from the AppImage
+func handleSetuidFiles(ctx *deployContext) {
+	appdir := ctx.appdir
+	var privileged []string
+	isSandboxHelperBundled := false
+	filepath.Walk(appdir.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode().IsRegular() == false {
+			return nil
+		}
+		var reasons []string
+		if info.Mode()&os.ModeSetuid != 0 {
+			reasons = append(reasons, "setuid")
+		}
+		if info.Mode()&os.ModeSetgid != 0 {
+			reasons = append(reasons, "setgid")
+		}
+		if hasFileCapabilities(path) {
+			reasons = append(reasons, "file capabilities")
+		}
+		if helpers.SliceContains(sandboxHelpers, info.Name()) {
+			// Usually not setuid in the build output yet, but it is meant to be
+			isSandboxHelperBundled = true
+			reasons = helpers.AppendIfMissing(reasons, "setuid")
+		}
+		if len(reasons) > 0 {
+			privileged = append(privileged, strings.TrimPrefix(path, appdir.Path+"/")+" ("+strings.Join(reasons, ", ")+")")
+		}
+		return nil
+	})
+	if len(privileged) == 0 {
+		return
+	}
+
+	log.Println("WARNING: The following files need privileges that cannot be preserved in an AppImage:")
+	for _, p := range privileged {
+		log.Println("    " + p)
+	}
+
+	switch options.setuid {
+	case setuidPolicyFail:
+		log.Println("Aborting because of --setuid=" + setuidPolicyFail)
+		os.Exit(1)
+	case setuidPolicyNoSandbox:
+		if isSandboxHelperBundled == false {
+			log.Println("No sandbox helper found, nothing to mitigate")
+			return
+		}
+		log.Println("Adding --no-sandbox to the arguments in AppRun for systems without unprivileged user namespaces")
+		// Chromium prefers its user namespace sandbox and only falls back to the setuid
+		// helper if unprivileged user namespaces are not available on the system
+		addAppRunSection(ctx, "Run without the setuid sandbox helper, which cannot work from within an AppImage", `
+if [ "$(cat /proc/sys/kernel/unprivileged_userns_clone 2>/dev/null)" = "0" ] || [ "$(cat /proc/sys/user/max_user_namespaces 2>/dev/null)" = "0" ] ; then
+  set -- --no-sandbox "$@"
+fi`)
+	default:
+		log.Println("Use --setuid=" + setuidPolicyNoSandbox + " to make Chromium-based applications work on systems without unprivileged user namespaces")
+	}
+}
+
+// hasFileCapabilities returns true if the file at path has file capabilities set
+func hasFileCapabilities(path string) bool {
+	size, err := syscall.Getxattr(path, "security.capability", nil)
+	return err == nil && size > 0
+}
@@ -1,3 +1,6 @@
+//go:build linux
+// +build linux
+
 // Encrypting and uploading a private key
 // for signing AppImages with Travis CI
 // without needing the travis command line tool
@@ -14,6 +17,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"log"
 	"math/rand"
 	"os"
 
@@ -27,6 +31,9 @@ import (
 
 
 func setupSigning(overwriteSecretFiles bool) error {
+	if ciMode {
+		log.Fatal("setupsigning asks for a GitHub token and cannot run with --ci")
+	}
 
 	// Check if we are on a clean git repository. Exit as fast as possible if we are not.
 	var gitRepo *git.Repository
@@ -61,7 +68,7 @@ func setupSigning(overwriteSecretFiles bool) error {
 	tools := []string{"sh", "git", "openssl"}
 	err = helpers.CheckForNeededTools(tools)
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitMissingTool)
 	}
 
 	// Exit if the repo already contains the files we are about to add
@@ -135,12 +142,16 @@ func setupSigning(overwriteSecretFiles bool) error {
 	// But it gets messy real quick (see that empty argument?), hence we use native Go instead.
 
 	fmt.Println("Generating key pair...")
-	helpers.CreateAndValidateKeyPair()
+	err = helpers.CreateAndValidateKeyPair()
+	if err != nil {
+		helpers.PrintError("Could not create the signing key", err)
+		os.Exit(exitSignatureFailure)
+	}
 
 	// Check if we succeeded until here
 	if _, err := os.Stat(helpers.PrivkeyFileName); err != nil {
 		fmt.Println("Could not create private key, exiting")
-		os.Exit(1)
+		os.Exit(exitSignatureFailure)
 	}
 
 	// Check if password/secret already exists, delete it if -o was specified, exit otherwise
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/probonopd/go-appimage/internal/elfsection"
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/signature"
+	"github.com/urfave/cli/v2"
+)
+
+// bootstrapSign signs an existing AppImage in place with the private key in the current directory,
+// and embeds the public key next to the signature, without rebuilding the AppImage
+//
+//	Args: c: cli.Context
+func bootstrapSign(c *cli.Context) error {
+	if c.NArg() != 1 {
+		log.Fatal("Please specify the path to an AppImage to sign")
+	}
+	path := c.Args().Get(0)
+	if !helpers.CheckIfFileExists(helpers.PrivkeyFileName) || !helpers.CheckIfFileExists(helpers.PubkeyFileName) {
+		log.Println(helpers.PrivkeyFileName + " and " + helpers.PubkeyFileName + " are needed in the current directory, see 'appimagetool setupsigning'")
+		os.Exit(exitSignatureFailure)
+	}
+	// The signature and key sections do not count towards the digest, so an AppImage can be signed again
+	digest, err := signature.Digest(path)
+	if err != nil {
+		helpers.PrintError("Digest", err)
+		os.Exit(exitSignatureFailure)
+	}
+	err = helpers.SignAppImage(path, digest)
+	if err != nil {
+		helpers.PrintError("SignAppImage", err)
+		os.Exit(exitSignatureFailure)
+	}
+	buf, err := ioutil.ReadFile(helpers.PubkeyFileName)
+	if err != nil {
+		helpers.PrintError("ReadFile", err)
+		os.Exit(1)
+	}
+	err = elfsection.Write(path, ".sig_key", buf)
+	if err != nil {
+		helpers.PrintError("Embed public key", err)
+		os.Exit(exitSignatureFailure)
+	}
+	fmt.Println("Signed", path)
+	return nil
+}
+
+// bootstrapUpdateInfo prints the update information of an AppImage,
+// or replaces it in place if new update information is given
+//
+//	Args: c: cli.Context
+func bootstrapUpdateInfo(c *cli.Context) error {
+	if c.NArg() != 1 && c.NArg() != 2 {
+		log.Fatal("Please specify the path to an AppImage, and optionally the new update information")
+	}
+	path := c.Args().Get(0)
+	if c.NArg() == 1 {
+		updateinformation, err := elfsection.ReadString(path, ".upd_info")
+		if err != nil {
+			helpers.PrintError("updateinfo", err)
+			os.Exit(1)
+		}
+		fmt.Println(updateinformation)
+		return nil
+	}
+
+	updateinformation := c.Args().Get(1)
+	if updateinformation != "" {
+		err := helpers.ValidateUpdateInformation(updateinformation)
+		if err != nil {
+			helpers.PrintError("ValidateUpdateInformation", err)
+			os.Exit(1)
+		}
+	}
+	before, err := signature.Verify(path)
+	if err != nil {
+		helpers.PrintError("Verify", err)
+		os.Exit(1)
+	}
+	err = elfsection.Write(path, ".upd_info", []byte(updateinformation))
+	if err != nil {
+		helpers.PrintError("updateinfo", err)
+		os.Exit(1)
+	}
+	fmt.Println("Embedded update information:", updateinformation)
+
+	// The update information is part of the digest, so whatever was embedded for it is outdated now
+	switch before.Status {
+	case signature.StatusDigestMatches:
+		digest, err := signature.Digest(path)
+		if err != nil {
+			helpers.PrintError("Digest", err)
+			os.Exit(exitSignatureFailure)
+		}
+		err = elfsection.Write(path, ".sha256_sig", []byte(digest))
+		if err != nil {
+			helpers.PrintError("Embed digest", err)
+			os.Exit(exitSignatureFailure)
+		}
+		fmt.Println("Embedded the new digest", digest)
+	case signature.StatusValid:
+		fmt.Println("The signature of", path, "is no longer valid, please sign it again using 'appimagetool sign'")
+	}
+	return nil
+}
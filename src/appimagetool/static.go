@@ -0,0 +1,89 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"debug/elf"
+	"log"
+	"path/filepath"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// isStaticAppDir returns true if none of the ELFs in the AppDir needs a library that would get
+// bundled, i.e., if they are all linked statically (as is common for Go and Rust applications)
+// or only need libc and the other libraries on the excludelist. In this case there is
+// nothing to gain from walking the library dependencies, bundling frameworks or patching rpaths
+func isStaticAppDir(ctx *deployContext) bool {
+	appdir := ctx.appdir
+	if options.standalone || options.profile != "" {
+		return false
+	}
+	elfs, err := findAllExecutablesAndLibraries(ctx, appdir.Path)
+	if err != nil || len(elfs) == 0 {
+		return false
+	}
+	for _, path := range elfs {
+		f, err := elf.Open(path)
+		if err != nil {
+			return false
+		}
+		needed, err := f.ImportedLibraries()
+		f.Close()
+		if err != nil {
+			return false
+		}
+		for _, lib := range needed {
+			if helpers.SliceContains(ExcludedLibraries, lib) == false {
+				log.Println(filepath.Base(path), "needs", lib+", hence deploying its dependencies")
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// deployStaticAppDir deploys an AppDir for which isStaticAppDir returned true.
+// It only handles the data files and AppRun, skipping everything that has to do with libraries
+func deployStaticAppDir(ctx *deployContext) {
+	appdir := ctx.appdir
+	helpers.SetPhase("Finishing the AppDir")
+	log.Println("All ELFs in the AppDir are static or only need libc, skipping the library deployment")
+	runHooks(ctx, hookAfterResolve)
+	applyAppDirPatches(ctx)
+	optimizeAppDir(appdir)
+	if options.optimizeData {
+		optimizeData(appdir.Path, ctx.ignored)
+	}
+	runHooks(ctx, hookAfterCopy)
+
+	// Glib 2 schemas
+	if helpers.Exists(appdir.Path + "/usr/share/glib-2.0/schemas") {
+		err := handleGlibSchemas(appdir)
+		if err != nil {
+			helpers.PrintError("Could not deploy GLib schemas", err)
+		}
+	}
+	// Translations
+	handleLocales(ctx)
+
+	// Hardcoded absolute paths
+	handleAbsolutePaths(ctx)
+
+	// Files that need privileges
+	handleSetuidFiles(ctx)
+
+	runHooks(ctx, hookBeforeAppRun)
+	writeAppRun(ctx)
+
+	cache := &deployCache{Files: make(map[string]deployCacheEntry)}
+	err := writeProvenance(ctx, cache)
+	if err != nil {
+		helpers.PrintError("Could not write "+provenanceName, err)
+	}
+	err = writeDeploymentManifest(ctx, cache)
+	if err != nil {
+		helpers.PrintError("Could not write "+deploymentManifestName, err)
+	}
+}
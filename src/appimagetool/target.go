@@ -0,0 +1,137 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/appdir"
+)
+
+// findDeployDesktopFile returns the desktop file in usr/share/applications of the AppDir
+// to be deployed. Besides such a desktop file, path can be the AppDir itself or an executable
+// in it. If there is no desktop file for the executable (or the only executable in usr/bin),
+// one is generated, along with a placeholder icon if the AppDir has no icon for it
+func findDeployDesktopFile(path string) (string, error) {
+	if strings.HasSuffix(path, ".desktop") {
+		return path, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	appdirPath := path
+	var executable string
+	if info.IsDir() == false {
+		appdirPath = findAppDirOf(path)
+		if appdirPath == "" {
+			return "", errors.New(path + " is not below usr/ of an AppDir, use init to create an AppDir for it")
+		}
+		executable = filepath.Base(path)
+	}
+	applications := appdirPath + "/usr/share/applications"
+
+	desktopFiles, _ := filepath.Glob(applications + "/*.desktop")
+	if executable != "" {
+		// The desktop file that launches the executable
+		for _, desktopFile := range desktopFiles {
+			entry, err := appdir.ReadDesktopEntry(desktopFile)
+			if err == nil && len(strings.Fields(entry.Exec)) > 0 && filepath.Base(strings.Fields(entry.Exec)[0]) == executable {
+				return desktopFile, nil
+			}
+		}
+	} else if len(desktopFiles) == 1 {
+		return desktopFiles[0], nil
+	}
+
+	// A desktop file that was only put into the top level of the AppDir
+	topLevel, _ := filepath.Glob(appdirPath + "/*.desktop")
+	if len(topLevel) == 1 {
+		entry, err := appdir.ReadDesktopEntry(topLevel[0])
+		if err == nil && (executable == "" || (len(strings.Fields(entry.Exec)) > 0 && filepath.Base(strings.Fields(entry.Exec)[0]) == executable)) {
+			desktopFile := applications + "/" + filepath.Base(topLevel[0])
+			if helpers.Exists(desktopFile) == false {
+				log.Println("Copying", topLevel[0], "to", applications)
+				err = os.MkdirAll(applications, 0755)
+				if err == nil {
+					err = helpers.CopyFile(topLevel[0], desktopFile)
+				}
+				if err != nil {
+					return "", err
+				}
+			}
+			return desktopFile, nil
+		}
+	}
+	if executable == "" && len(desktopFiles) > 1 {
+		return "", errors.New("there is more than one desktop file in " + applications + ", please specify which one to use")
+	}
+
+	if executable == "" {
+		executables := findExecutablesInDirectory(appdirPath + "/usr/bin")
+		if len(executables) != 1 {
+			return "", errors.New("cannot determine the main executable in " + appdirPath + "/usr/bin, please specify it or a desktop file")
+		}
+		executable = executables[0]
+	}
+	return generateDesktopFile(appdirPath, executable)
+}
+
+// findAppDirOf returns the AppDir containing the file at path, i.e., the parent of the usr directory
+// path is in, or an empty string if there is none. The system's /usr never counts as an AppDir
+func findAppDirOf(path string) string {
+	for dir := filepath.Dir(path); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == "usr" && filepath.Dir(dir) != "/" {
+			return filepath.Dir(dir)
+		}
+	}
+	return ""
+}
+
+// findExecutablesInDirectory returns the names of the executable files in dir
+func findExecutablesInDirectory(dir string) []string {
+	var executables []string
+	matches, _ := filepath.Glob(dir + "/*")
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			executables = append(executables, filepath.Base(match))
+		}
+	}
+	return executables
+}
+
+// generateDesktopFile writes a minimal desktop file for executable into the AppDir at appdirPath
+// and returns its path, adding a placeholder icon if the AppDir has no icon named like executable
+func generateDesktopFile(appdirPath string, executable string) (string, error) {
+	log.Println("WARNING: Generating a desktop file for", executable+", please supply a proper one")
+	ad := appdir.AppDir{Path: appdirPath}
+	err := ad.AddDesktopEntry(appdir.DesktopEntry{
+		Name:       strings.Title(executable),
+		Exec:       executable,
+		Icon:       executable,
+		Categories: []string{"Utility"},
+	})
+	if err != nil {
+		return "", err
+	}
+	icons, _ := filepath.Glob(appdirPath + "/usr/share/icons/hicolor/*/apps/" + executable + ".*")
+	pixmaps, _ := filepath.Glob(appdirPath + "/usr/share/pixmaps/" + executable + ".*")
+	if len(icons) == 0 && len(pixmaps) == 0 {
+		err = addInitIcon(ad, executable, "")
+		if err != nil {
+			return "", err
+		}
+	}
+	return appdirPath + "/usr/share/applications/" + executable + ".desktop", nil
+}
@@ -0,0 +1,92 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// How much of the icon theme to bundle for Gtk applications, selected with --icon-theme
+const (
+	iconThemeNone    = "none"    // Rely on the icon theme of the target system
+	iconThemeMinimal = "minimal" // Only the symbolic icons that Gtk widgets use
+	iconThemeFull    = "full"    // The complete icon theme
+)
+
+var iconThemeModes = []string{iconThemeNone, iconThemeMinimal, iconThemeFull}
+
+// Icon theme that Gtk falls back to, and the theme that all icon themes fall back to
+const (
+	gtkIconTheme      = "Adwaita"
+	fallbackIconTheme = "hicolor"
+)
+
+// deployGtkTheme bundles the Default theme for Gtk gtkVersion (for GTK_THEME=Default),
+// and the icon theme that Gtk uses as selected with --icon-theme.
+// Missing themes are reported but do not abort the deployment, since
+// many distributions do not ship the Default theme
+func deployGtkTheme(ctx *deployContext, gtkVersion int) {
+	version := strconv.Itoa(gtkVersion)
+	themeDir := "/usr/share/themes/Default/gtk-" + version + ".0"
+	if helpers.IsDirectory(themeDir) {
+		log.Println("Bundling Default theme for Gtk", version, "(for GTK_THEME=Default)...")
+		bundleThemeFiles(ctx, "Default", themeDir, func(path string) bool { return true })
+	} else {
+		log.Println("WARNING:", themeDir, "not found, not bundling the Default theme for Gtk", version)
+	}
+
+	if options.iconTheme == iconThemeNone {
+		return
+	}
+	iconThemeDir := "/usr/share/icons/" + gtkIconTheme
+	if helpers.IsDirectory(iconThemeDir) == false {
+		log.Println("WARNING:", iconThemeDir, "not found, not bundling the", gtkIconTheme, "icon theme")
+		return
+	}
+	if _, ok := ctx.bundledThemes[gtkIconTheme]; ok {
+		return // Already bundled for the other Gtk version
+	}
+	log.Println("Bundling", gtkIconTheme, "icon theme ("+options.iconTheme+")...")
+	bundleThemeFiles(ctx, gtkIconTheme, iconThemeDir, func(path string) bool {
+		if options.iconTheme == iconThemeFull || filepath.Base(path) == "index.theme" {
+			return true
+		}
+		// Gtk widgets only use symbolic icons, which are small and scale to any size
+		name := filepath.Base(path)
+		return strings.Contains(name, "-symbolic.") || strings.Contains(filepath.ToSlash(path), "/symbolic/")
+	})
+	// Without the index.theme of the fallback theme, icon lookups may fail
+	fallbackIndex := "/usr/share/icons/" + fallbackIconTheme + "/index.theme"
+	if helpers.Exists(fallbackIndex) {
+		bundleThemeFiles(ctx, fallbackIconTheme, fallbackIndex, func(path string) bool { return true })
+	}
+}
+
+// bundleThemeFiles copies the files below src for which include returns true into the same
+// location in the AppDir, records them as belonging to the theme with the given name,
+// and reports how much was bundled
+func bundleThemeFiles(ctx *deployContext, name string, src string, include func(path string) bool) {
+	appdir := ctx.appdir
+	var size int64
+	filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || include(path) == false {
+			return nil
+		}
+		err = helpers.CopyTree(path, appdir.Path+path)
+		if err != nil {
+			helpers.PrintError("Could not copy "+path, err)
+			return nil
+		}
+		ctx.bundledThemes[name] = append(ctx.bundledThemes[name], appdir.Path+path)
+		size = size + info.Size()
+		return nil
+	})
+	log.Println("Bundled", len(ctx.bundledThemes[name]), "files of the", name, "theme,", size/1024, "KiB")
+}
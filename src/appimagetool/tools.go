@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Helper tools that were found on the $PATH, so that each one is only looked up once
+var availableTools = make(map[string]bool) // Need to use 'make', otherwise we can't add to it
+
+// requireTool exits if tool is not on the $PATH.
+// Helper tools are only required once a feature that needs them is used,
+// so that the build system needs no more tools than the AppDir at hand requires
+func requireTool(tool string, feature string) {
+	if availableTools[tool] {
+		return
+	}
+	if helpers.IsCommandAvailable(tool) == false {
+		log.Println("Required helper tool", tool, "missing, it is needed for", feature)
+		os.Exit(exitMissingTool)
+	}
+	availableTools[tool] = true
+}
@@ -0,0 +1,108 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"golang.org/x/sys/unix"
+)
+
+// deployTransaction makes the deployment all-or-nothing: everything is copied and patched
+// into a staging copy of the AppDir next to it, which replaces the AppDir only at the end.
+// If the deployment is interrupted or fails, the AppDir is left as it was
+// and the staging copy is discarded by the next deployment
+type deployTransaction struct {
+	appdirPath  string // The AppDir as given by the user
+	stagingPath string // The copy that is being deployed into
+}
+
+// beginDeployTransaction copies the AppDir containing the desktop file at desktopFilePath
+// into the staging directory, after having cleaned up after an interrupted deployment
+func beginDeployTransaction(desktopFilePath string) (*deployTransaction, error) {
+	abs, err := filepath.Abs(desktopFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if helpers.Exists(abs) == false {
+		return nil, errors.New("Desktop file not found")
+	}
+	// The desktop file is in <AppDir>/usr/share/applications/, see helpers.NewAppDir
+	appdirPath := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(abs))))
+	if appdirPath == "/" {
+		return nil, errors.New(desktopFilePath + " is installed on this system rather than in an AppDir")
+	}
+	// Next to the AppDir so that it is on the same filesystem and can be renamed,
+	// and with a fixed name so that paths recorded in the deployment cache stay the same
+	tx := &deployTransaction{
+		appdirPath:  appdirPath,
+		stagingPath: filepath.Dir(appdirPath) + "/." + filepath.Base(appdirPath) + ".staging",
+	}
+
+	// Interrupted between the two renames in commit, where renameat2 is not supported
+	if helpers.Exists(tx.appdirPath) == false && helpers.Exists(tx.stagingPath+".old") {
+		log.Println("Restoring", tx.appdirPath, "which a previous deployment had moved away")
+		err = os.Rename(tx.stagingPath+".old", tx.appdirPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if helpers.IsDirectory(tx.appdirPath+"/usr/bin") == false {
+		return nil, errors.New("AppDir could not be identified: " + tx.appdirPath + "/usr/bin does not exist")
+	}
+	for _, leftover := range []string{tx.stagingPath, tx.stagingPath + ".old"} {
+		if helpers.Exists(leftover) {
+			log.Println("Removing", leftover, "left over from an interrupted deployment")
+			err = os.RemoveAll(leftover)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	log.Println("Staging the deployment in", tx.stagingPath+"...")
+	err = helpers.CopyTree(tx.appdirPath, tx.stagingPath)
+	if err != nil {
+		os.RemoveAll(tx.stagingPath)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// stagedPath returns the path in the staging directory that corresponds to path in the AppDir
+func (tx *deployTransaction) stagedPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil || strings.HasPrefix(abs, tx.appdirPath+"/") == false {
+		return path
+	}
+	return tx.stagingPath + strings.TrimPrefix(abs, tx.appdirPath)
+}
+
+// commit replaces the AppDir with the staging directory, atomically where the kernel
+// and the filesystem support exchanging two directories, and removes the old AppDir
+func (tx *deployTransaction) commit() error {
+	log.Println("Replacing", tx.appdirPath, "with the staged deployment...")
+	err := unix.Renameat2(unix.AT_FDCWD, tx.stagingPath, unix.AT_FDCWD, tx.appdirPath, unix.RENAME_EXCHANGE)
+	if err == nil {
+		// The staging path now holds the old AppDir
+		return os.RemoveAll(tx.stagingPath)
+	}
+	log.Println("Cannot exchange the directories atomically (" + err.Error() + "), renaming them one after the other")
+	err = os.Rename(tx.appdirPath, tx.stagingPath+".old")
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tx.stagingPath, tx.appdirPath)
+	if err != nil {
+		// Put the AppDir back
+		os.Rename(tx.stagingPath+".old", tx.appdirPath)
+		return err
+	}
+	return os.RemoveAll(tx.stagingPath + ".old")
+}
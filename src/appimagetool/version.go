@@ -0,0 +1,152 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// appStreamReleases is the part of an AppStream metainfo file that lists the releases, newest first
+type appStreamReleases struct {
+	Releases []struct {
+		Version string `xml:"version,attr"`
+	} `xml:"releases>release"`
+}
+
+// versionSource is a place the version of an application can be taken from
+type versionSource struct {
+	description string // How the version was found, for the notes printed when using it
+	find        func() string
+}
+
+// determineVersion returns the version of the application in the AppDir, taken from the first of --app-version,
+// $VERSION, the newest release in the AppStream metainfo, git describe --tags, the build number on Travis CI
+// or GitHub Actions, the abbreviated hash of the git commit, and X-AppImage-Version in the desktop file.
+// The latter comes last since it is written into the desktop file of the AppDir, hence it may be left over
+// from an earlier build. Exits if none of them gives a version
+func determineVersion(appdir string, desktopfile string, gitRepo *git.Repository) string {
+	sources := []versionSource{
+		{"", func() string { return options.appVersion }},
+		{"", func() string { return os.Getenv("VERSION") }},
+		{"the AppStream metainfo", func() string { return appStreamVersion(appdir, desktopfile) }},
+		{"'git describe --tags'", func() string { return gitDescribe(gitRepo) }},
+		{"$TRAVIS_BUILD_NUMBER", func() string { return os.Getenv("TRAVIS_BUILD_NUMBER") }},
+		{"$GITHUB_RUN_NUMBER", func() string { return os.Getenv("GITHUB_RUN_NUMBER") }},
+		{"'git rev-parse --short HEAD'", func() string {
+			if gitRepo == nil {
+				return ""
+			}
+			gitHead, err := gitRepo.Head()
+			if err != nil {
+				return ""
+			}
+			return gitHead.Hash().String()[:7]
+		}},
+		{"X-AppImage-Version in " + filepath.Base(desktopfile), func() string {
+			d, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, desktopfile)
+			if err != nil {
+				return ""
+			}
+			return d.Section("Desktop Entry").Key("X-AppImage-Version").String()
+		}},
+	}
+	for _, source := range sources {
+		version := sanitizeVersion(source.find())
+		if version == "" {
+			continue
+		}
+		if source.description != "" {
+			log.Println("NOTE: Using", version, "from", source.description, "as the version")
+			log.Println("      Please set the $VERSION environment variable or use --app-version if this is not intended")
+		}
+		return version
+	}
+	log.Fatal("Version not found, aborting. Set it with VERSION=... " + os.Args[0] + "\n")
+	return ""
+}
+
+// sanitizeVersion makes version usable in the filename of the AppImage
+func sanitizeVersion(version string) string {
+	return strings.NewReplacer(" ", "_", "/", "-").Replace(strings.TrimSpace(version))
+}
+
+// appStreamVersion returns the version of the newest release in the AppStream metainfo of the application
+// in the AppDir, preferring the file named after the desktop file, or an empty string
+func appStreamVersion(appdir string, desktopfile string) string {
+	for _, file := range appStreamMetainfoCandidates(appdir, desktopfile) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var metainfo appStreamReleases
+		if xml.Unmarshal(data, &metainfo) == nil && len(metainfo.Releases) > 0 {
+			return metainfo.Releases[0].Version
+		}
+	}
+	return ""
+}
+
+// gitDescribe returns the nearest tag reachable from HEAD like git describe --tags does, i.e., the tag
+// if it points to HEAD, and otherwise the tag followed by the number of commits since then and the abbreviated
+// hash of HEAD, e.g., 1.2-5-gabc1234. A leading v of the tag is left out. Returns an empty string if there is no tag
+func gitDescribe(gitRepo *git.Repository) string {
+	if gitRepo == nil {
+		return ""
+	}
+	head, err := gitRepo.Head()
+	if err != nil {
+		return ""
+	}
+	tags := make(map[plumbing.Hash]string) // Key: commit
+	refs, err := gitRepo.Tags()
+	if err != nil {
+		return ""
+	}
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		hash := ref.Hash()
+		// Annotated tags point to a tag object rather than to the commit
+		if tag, err := gitRepo.TagObject(hash); err == nil {
+			commit, err := tag.Commit()
+			if err != nil {
+				return nil
+			}
+			hash = commit.Hash
+		}
+		tags[hash] = ref.Name().Short()
+		return nil
+	})
+	if len(tags) == 0 {
+		return ""
+	}
+	commits, err := gitRepo.Log(&git.LogOptions{From: head.Hash(), Order: git.LogOrderBSF})
+	if err != nil {
+		return ""
+	}
+	describe := ""
+	distance := 0
+	commits.ForEach(func(commit *object.Commit) error {
+		if tag, ok := tags[commit.Hash]; ok {
+			describe = strings.TrimPrefix(tag, "v")
+			if distance > 0 {
+				describe += "-" + strconv.Itoa(distance) + "-g" + head.Hash().String()[:7]
+			}
+			return storer.ErrStop
+		}
+		distance++
+		return nil
+	})
+	return describe
+}
@@ -0,0 +1,134 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/rjeczalik/notify"
+)
+
+// How long to wait after the last change before redeploying, so that
+// a build writing many files results in only one redeployment
+const watchSettleTime = 2 * time.Second
+
+// watchAppDir watches watchDir recursively after the AppDir has been deployed and redeploys
+// the dependencies of changed ELFs only, which is much faster than a full deployment.
+// If watchDir is not the AppDir itself, then it is treated like an installation prefix
+// (e.g., a build output directory containing bin/ and lib/) and changed files are
+// copied into usr/ in the AppDir first. ctx is the context of the deployment,
+// which knows the ELFs that have already been deployed
+func watchAppDir(ctx *deployContext, desktopFilePath string, watchDir string) {
+	appdir, err := helpers.NewAppDir(desktopFilePath)
+	if err != nil {
+		helpers.PrintError("AppDir", err)
+		os.Exit(exitInvalidAppDir)
+	}
+	// The deployment may have happened in a staging directory
+	ctx.appdir = appdir
+	if watchDir == "" {
+		watchDir = appdir.Path
+	}
+	watchDir, err = filepath.Abs(watchDir)
+	if err != nil {
+		helpers.PrintError("watch", err)
+		os.Exit(1)
+	}
+
+	// Make the channel buffered to ensure no event is dropped while we are busy redeploying
+	c := make(chan notify.EventInfo, 1024)
+	err = notify.Watch(watchDir+"/...", c, notify.InCloseWrite, notify.InMovedTo)
+	if err != nil {
+		helpers.PrintError("watch", err)
+		os.Exit(1)
+	}
+	defer notify.Stop(c)
+
+	// Files we have patched ourselves, and their modification time after patching,
+	// so that we do not redeploy endlessly in reaction to our own changes
+	selfModified := make(map[string]time.Time)
+
+	var changed []string
+	var mutex sync.Mutex
+	watchdog := helpers.NewWatchdog(watchSettleTime, func() {
+		mutex.Lock()
+		paths := changed
+		changed = nil
+		for _, path := range paths {
+			redeployChangedFile(ctx, watchDir, path, selfModified)
+		}
+		mutex.Unlock()
+		log.Println("Watching", watchDir, "for changes, press Ctrl+C to stop...")
+	})
+	watchdog.Stop()
+
+	log.Println("Watching", watchDir, "for changes, press Ctrl+C to stop...")
+	for ei := range c {
+		mutex.Lock()
+		changed = helpers.AppendIfMissing(changed, ei.Path())
+		mutex.Unlock()
+		watchdog.Kick()
+	}
+}
+
+// redeployChangedFile copies path from watchDir into the AppDir if needed
+// and, if it is an ELF, deploys its dependencies and patches its rpath
+func redeployChangedFile(ctx *deployContext, watchDir string, path string, selfModified map[string]time.Time) {
+	appdir := ctx.appdir
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().IsRegular() == false {
+		return
+	}
+	if modTime, ok := selfModified[path]; ok && modTime.Equal(info.ModTime()) {
+		return
+	}
+
+	if watchDir != appdir.Path {
+		rel, err := filepath.Rel(watchDir, path)
+		if err != nil {
+			helpers.PrintError("watch", err)
+			return
+		}
+		target := appdir.Path + "/usr/" + rel
+		log.Println("Copying", path, "to", target)
+		err = helpers.CopyFile(path, target)
+		if err == nil {
+			err = os.Chmod(target, info.Mode().Perm())
+		}
+		if err != nil {
+			helpers.PrintError("watch", err)
+			return
+		}
+		path = target
+	}
+
+	if isELF(path) == false || isDeployedFromBuildSystem(ctx, path) {
+		return
+	}
+
+	log.Println("Redeploying", path+"...")
+	before := len(ctx.elfs)
+	determineELFsInDirTree(ctx, path)
+	libraryLocationsInAppDir := getLibraryLocationsInAppDir(ctx)
+	libs := append([]string{path}, ctx.elfs[before:]...)
+	resetRpathPlanning(ctx)
+	for _, lib := range libs {
+		deployElf(lib, appdir, nil)
+		rewriteNeededPaths(ctx, lib)
+		patchRpathsInElf(ctx, libraryLocationsInAppDir, lib)
+		if strings.HasPrefix(lib, appdir.Path) == false {
+			lib = filepath.Clean(appdir.Path + "/" + lib)
+		}
+		if info, err := os.Stat(lib); err == nil {
+			selfModified[lib] = info.ModTime()
+		}
+	}
+	log.Println("Redeployed", path, "with", len(ctx.elfs)-before, "new libraries")
+}
@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/urfave/cli/v2"
+)
+
+// Dependency chains longer than this are not followed, in case of cycles
+const maxDependencyChainLength = 32
+
+// bootstrapWhy explains why a library was bundled into an AppDir,
+// using the dependency graph recorded in the deployment cache
+//
+//	Args: c: cli.Context
+func bootstrapWhy(c *cli.Context) error {
+	if c.NArg() != 2 {
+		log.Fatal("Please specify the path to a deployed AppDir and the name of a library, e.g., " +
+			filepath.Base(os.Args[0]) + " why Some.AppDir libfoo.so.2")
+	}
+	appdirPath, err := filepath.Abs(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	cache := readDeployCache(appdirPath)
+	if len(cache.Files) == 0 {
+		log.Fatal("No deployment information found in " + appdirPath + ", please deploy it first")
+	}
+
+	// Reverse the dependency graph
+	neededBy := make(map[string][]string)
+	for path, entry := range cache.Files {
+		for _, dependency := range entry.Dependencies {
+			neededBy[dependency] = helpers.AppendIfMissing(neededBy[dependency], path)
+		}
+	}
+
+	found := false
+	for path, entry := range cache.Files {
+		if path != c.Args().Get(1) && filepath.Base(path) != c.Args().Get(1) {
+			continue
+		}
+		found = true
+		fmt.Println(path)
+		if entry.Rule != "" {
+			fmt.Println("    resolved using:", entry.Rule)
+		}
+		chains := getDependencyChains(neededBy, path, []string{path})
+		sort.Strings(chains)
+		if len(chains) == 0 {
+			fmt.Println("    not needed by any other ELF, it was bundled because it is in the AppDir or explicitly deployed")
+		}
+		for _, chain := range chains {
+			fmt.Println("    needed through:", strings.Replace(chain, appdirPath, "", -1))
+		}
+	}
+	if found == false {
+		log.Fatal(c.Args().Get(1) + " was not bundled into " + appdirPath)
+	}
+	return nil
+}
+
+// getDependencyChains returns all chains of ELFs, from an ELF that is not needed by
+// anything else down to path, through which path gets pulled in.
+// chain contains the ELFs already walked, starting from path
+func getDependencyChains(neededBy map[string][]string, path string, chain []string) []string {
+	var chains []string
+	parents := neededBy[path]
+	if len(parents) == 0 || len(chain) > maxDependencyChainLength {
+		if len(chain) < 2 {
+			return chains
+		}
+		var names []string
+		for i := len(chain) - 1; i >= 0; i-- {
+			names = append(names, chain[i])
+		}
+		return append(chains, strings.Join(names, " -> "))
+	}
+	for _, parent := range parents {
+		if helpers.SliceContains(chain, parent) {
+			continue
+		}
+		chains = append(chains, getDependencyChains(neededBy, parent, append(append([]string{}, chain...), parent))...)
+	}
+	return chains
+}
@@ -0,0 +1,244 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+	"github.com/probonopd/go-appimage/pkg/elfdeps"
+)
+
+// Loader binaries in the bin directory of a Wine build
+var wineLoaders = []string{"wine", "wine64", "wine-preloader", "wine64-preloader", "wineserver"}
+
+// Libraries that Wine loads with dlopen() rather than linking to them, hence the dependency walker does not see them.
+// Graphics drivers are not among them since they must come from the target system, see the game profile
+var wineDlopenedLibraries = []string{"libfreetype.so.6", "libfontconfig.so.1", "libgnutls.so.30",
+	"libX11.so.6", "libXext.so.6", "libXrender.so.1", "libXrandr.so.2", "libXi.so.6", "libXcursor.so.1",
+	"libXinerama.so.1", "libXcomposite.so.1", "libXxf86vm.so.1", "libXfixes.so.3", "libxkbcommon.so.0",
+	"libasound.so.2", "libpulse.so.0", "libSDL2-2.0.so.0", "libudev.so.1", "libusb-1.0.so.0", "libdbus-1.so.3",
+	"libcups.so.2", "libkrb5.so.3", "libgssapi_krb5.so.2", "libodbc.so.2", "libgstreamer-1.0.so.0", "libv4l2.so.0"}
+
+// wineBuild is a Wine build, as installed with make install or unpacked from Proton
+type wineBuild struct {
+	prefix string   // Directory with bin/wineserver, e.g., /usr or files in Proton
+	trees  []string // Library trees, e.g., prefix/lib/wine and prefix/lib64/wine
+}
+
+// applyWineProfile bundles a Wine build, or makes the one already in the AppDir work:
+// the libraries of both of its architectures, including those Wine loads at runtime,
+// and AppRun that tells Wine where its loader and its DLLs are. Exits if there is no Wine build,
+// or if the libraries of one of its architectures are incomplete
+func applyWineProfile(ctx *deployContext) {
+	appdir := ctx.appdir
+	build, ok := findWineBuild(appdir.Path)
+	if ok == false {
+		system, ok := findSystemWineBuild()
+		if ok == false {
+			log.Println("ERROR: The wine profile needs a Wine build, but there is none in the AppDir and wineserver was not found on the $PATH")
+			os.Exit(exitUnresolvedDependencies)
+		}
+		log.Println("Bundling the Wine build in", system.prefix+"...")
+		build = copyWineBuild(ctx, system)
+	}
+	log.Println("Using the Wine build in", build.prefix)
+
+	for _, dir := range wineUnixModuleDirs(build) {
+		modules, _ := findAllExecutablesAndLibraries(ctx, dir)
+		if len(modules) == 0 {
+			continue
+		}
+		for _, name := range wineDlopenedLibraries {
+			// Resolved for one of the modules so that the library has the architecture of this tree
+			path, _, err := ctx.resolver.Resolve(name, modules[0])
+			if err == nil && helpers.SliceContains(ctx.elfs, path) == false {
+				determineELFsInDirTree(ctx, path)
+			}
+		}
+	}
+
+	// The preloaders are static executables at fixed addresses which must not be modified
+	var elfs []string
+	for _, lib := range ctx.elfs {
+		if strings.HasSuffix(filepath.Base(lib), "-preloader") == false {
+			elfs = append(elfs, lib)
+		}
+	}
+	ctx.elfs = elfs
+
+	if validateWineBuild(ctx, build) == false {
+		os.Exit(exitUnresolvedDependencies)
+	}
+
+	prefix := strings.TrimPrefix(build.prefix, appdir.Path)
+	loader := prefix + "/bin/wine"
+	if helpers.Exists(build.prefix+"/bin/wine") == false {
+		loader = prefix + "/bin/wine64"
+	}
+	var dllPath []string
+	for _, tree := range build.trees {
+		dllPath = append(dllPath, "${HERE}"+strings.TrimPrefix(tree, appdir.Path))
+	}
+	addAppRunSection(ctx, "Use bundled Wine", `apprun_export WINELOADER "${HERE}`+loader+`" replace
+apprun_export WINESERVER "${HERE}`+prefix+`/bin/wineserver" replace
+apprun_export WINEDLLPATH "`+strings.Join(dllPath, ":")+`" prepend`)
+}
+
+// findWineBuild returns the Wine build below dir, recognized by bin/wineserver
+func findWineBuild(dir string) (wineBuild, bool) {
+	var build wineBuild
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || build.prefix != "" {
+			return nil
+		}
+		if d.Name() == "wineserver" && d.IsDir() == false && filepath.Base(filepath.Dir(path)) == "bin" {
+			build.prefix = filepath.Dir(filepath.Dir(path))
+		}
+		return nil
+	})
+	if build.prefix == "" {
+		return build, false
+	}
+	build.trees = findWineLibraryTrees(build.prefix)
+	return build, len(build.trees) > 0
+}
+
+// findWineLibraryTrees returns the directories named wine with the libraries and DLLs of the
+// Wine build in prefix, one for each architecture in older builds and in Proton (lib/wine, lib64/wine),
+// or one with subdirectories for each architecture (lib/wine/x86_64-unix, lib/wine/i386-windows, ...)
+func findWineLibraryTrees(prefix string) []string {
+	var trees []string
+	for _, pattern := range []string{"lib*/wine", "lib/*/wine"} {
+		matches, _ := filepath.Glob(prefix + "/" + pattern)
+		for _, match := range matches {
+			if helpers.IsDirectory(match) {
+				trees = helpers.AppendIfMissing(trees, match)
+			}
+		}
+	}
+	sort.Strings(trees)
+	return trees
+}
+
+// wineUnixModuleDirs returns the directories of the Wine build with its ELFs, one for each architecture
+func wineUnixModuleDirs(build wineBuild) []string {
+	var dirs []string
+	for _, tree := range build.trees {
+		matches, _ := filepath.Glob(tree + "/*-unix")
+		if len(matches) == 0 {
+			// Older layout in which the ELFs are named *.dll.so and are next to the DLLs
+			matches = []string{tree}
+		}
+		dirs = append(dirs, matches...)
+	}
+	return dirs
+}
+
+// findSystemWineBuild returns the Wine build on the system whose wineserver is on the $PATH
+func findSystemWineBuild() (wineBuild, bool) {
+	wineserver, err := exec.LookPath("wineserver")
+	if err != nil {
+		return wineBuild{}, false
+	}
+	wineserver, err = filepath.EvalSymlinks(wineserver)
+	if err != nil || filepath.Base(filepath.Dir(wineserver)) != "bin" {
+		return wineBuild{}, false
+	}
+	prefix := filepath.Dir(filepath.Dir(wineserver))
+	build := wineBuild{prefix: prefix, trees: findWineLibraryTrees(prefix)}
+	return build, len(build.trees) > 0
+}
+
+// copyWineBuild copies the loaders, the library trees and the data of the Wine build
+// on the system into usr in the AppDir and adds its ELFs, returning the build in the AppDir
+func copyWineBuild(ctx *deployContext, system wineBuild) wineBuild {
+	appdir := ctx.appdir
+	build := wineBuild{prefix: appdir.Path + "/usr"}
+	var paths []string
+	for _, loader := range wineLoaders {
+		paths = append(paths, system.prefix+"/bin/"+loader)
+	}
+	paths = append(paths, system.trees...)
+	paths = append(paths, system.prefix+"/share/wine")
+	for _, path := range paths {
+		if helpers.Exists(path) == false {
+			continue
+		}
+		target := build.prefix + strings.TrimPrefix(path, system.prefix)
+		err := helpers.CopyTree(path, target)
+		if err != nil {
+			helpers.PrintError("Could not copy "+path, err)
+			os.Exit(1)
+		}
+		if helpers.SliceContains(system.trees, path) {
+			build.trees = append(build.trees, target)
+		}
+		if filepath.Base(filepath.Dir(path)) == "bin" || helpers.SliceContains(system.trees, path) {
+			determineELFsInDirTree(ctx, target)
+		}
+	}
+	return build
+}
+
+// validateWineBuild checks that all libraries needed by the ELFs of each architecture of the Wine build,
+// and by the libraries they need, were found with that architecture.
+// Returns false and reports what is missing otherwise
+func validateWineBuild(ctx *deployContext, build wineBuild) bool {
+	valid := true
+	archs := make(map[string]bool)
+	for _, dir := range wineUnixModuleDirs(build) {
+		modules, _ := findAllExecutablesAndLibraries(ctx, dir)
+		if len(modules) == 0 {
+			continue
+		}
+		arch, err := elfdeps.ReadArch(appdirFS, modules[0])
+		if err != nil {
+			continue
+		}
+		archs[arch.String()] = true
+		closure := make(map[string]bool)
+		todo := modules
+		for len(todo) > 0 {
+			path := todo[0]
+			todo = todo[1:]
+			if closure[path] {
+				continue
+			}
+			closure[path] = true
+			todo = append(todo, ctx.walker.Graph.Dependencies[path]...)
+		}
+		var paths []string
+		for path := range closure {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			other, err := elfdeps.ReadArch(appdirFS, path)
+			if err == nil && other != arch {
+				log.Println("ERROR:", path, "is", other, "but the Wine modules in", dir, "are", arch)
+				valid = false
+			}
+		}
+		for name, needers := range ctx.walker.Graph.Missing {
+			for _, needer := range needers {
+				if closure[needer] {
+					log.Println("ERROR: The", arch, "Wine modules in", dir, "need", name, "(for "+needer+"), which was not found for", arch.String()+". Install the", arch, "version of it")
+					valid = false
+					break
+				}
+			}
+		}
+	}
+	if len(archs) == 1 {
+		log.Println("WARNING: The Wine build has ELFs of only one architecture; unless it was built with WoW64 (--enable-archs), 32-bit Windows applications will not run")
+	}
+	return valid
+}
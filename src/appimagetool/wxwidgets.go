@@ -0,0 +1,102 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// wxLibraryRegexp matches the names of the wxWidgets libraries, e.g., libwx_gtk3u_core-3.0.so.0
+// or libwx_baseu-3.2.so.0, and captures the version
+var wxLibraryRegexp = regexp.MustCompile(`^libwx_[a-z0-9]+(?:_[a-z0-9]+)?-([0-9]+\.[0-9]+)\.so`)
+
+// Names of the gdk-pixbuf SVG loader, which librsvg installs (and which is
+// hence often missing), in the old and the new naming scheme
+var gdkPixbufSvgLoaders = []string{"libpixbufloader-svg.so", "libpixbufloader_svg.so"}
+
+// Libraries of the GNOME 2 platform that old wxGTK builds pull in
+// but which do not work without the matching daemons on the target system
+var gnome2Libraries = []string{"libgnomevfs-2.so", "libgnomeprintui-2-2.so", "libgnomeui-2.so", "libgconf-2.so", "libbonobo"}
+
+// wxWidgetsDeployer takes care of the things that applications using wxGTK
+// need in addition to what the Gtk and gdk-pixbuf plugins bundle
+type wxWidgetsDeployer struct{}
+
+// Detect returns true if there is a .so with the name libwx_ inside the AppDir
+func (wxWidgetsDeployer) Detect(ctx *deployContext) bool {
+	return findELFWithPrefix(ctx, "libwx_") != ""
+}
+
+// Deploy bundles the wxWidgets plugins and translations, and reports what is missing
+// or should not be bundled
+func (wxWidgetsDeployer) Deploy(ctx *deployContext) error {
+	var version string
+	for _, lib := range ctx.elfs {
+		match := wxLibraryRegexp.FindStringSubmatch(filepath.Base(lib))
+		if match != nil {
+			version = match[1]
+			break
+		}
+	}
+	if version == "" {
+		log.Println("Could not determine the wxWidgets version, not bundling its plugins and translations")
+		return nil
+	}
+	log.Println("Bundling wxWidgets", version, "plugins and translations...")
+
+	// E.g., the WebKit extension of wxWebView in lib/wx/3.0/web-extensions/
+	locs, _ := findWithPrefixInLibraryLocations(ctx, "wx")
+	for _, loc := range locs {
+		if helpers.IsDirectory(loc+"/"+version) == false {
+			continue
+		}
+		log.Println("Bundling dependencies of", loc+"/"+version, "directory...")
+		determineELFsInDirTree(ctx, loc+"/"+version)
+	}
+
+	// wxWidgets uses its own translations for the standard dialogs, in the wxstd domain
+	// (wxstd-3.0 on Debian). handleLocales does not find them because the domain is
+	// only referenced from the library. wxWidgets looks for them below its install prefix,
+	// which it derives from the location of the executable, i.e., in the AppDir
+	mos, _ := filepath.Glob(localeDir + "/*/LC_MESSAGES/wxstd*.mo")
+	for _, mo := range mos {
+		locale := strings.Split(strings.TrimPrefix(mo, localeDir+"/"), "/")[0]
+		if isLocaleWanted(locale) == false || helpers.Exists(ctx.appdir.Path+mo) {
+			continue
+		}
+		err := helpers.CopyFile(mo, ctx.appdir.Path+mo)
+		if err != nil {
+			helpers.PrintError("Could not copy translation", err)
+		}
+	}
+
+	// Without the SVG loader, the wxArtProvider icons of many applications are missing
+	if findELFWithPrefix(ctx, "libgdk_pixbuf") != "" {
+		var haveSvgLoader bool
+		for _, loader := range gdkPixbufSvgLoaders {
+			if findELFWithPrefix(ctx, loader) != "" {
+				haveSvgLoader = true
+			}
+		}
+		if haveSvgLoader == false {
+			log.Println("WARNING: The gdk-pixbuf SVG loader is not bundled because it is not installed on the build system,")
+			log.Println("SVG icons will not load. Install librsvg (e.g., librsvg2-common) and deploy again")
+		}
+	}
+
+	// wxGTK 2.8 was often built against GNOME 2; these libraries get bundled
+	// if the application links them, but talk to GConf and ORBit on the target system
+	for _, lib := range gnome2Libraries {
+		if found := findELFWithPrefix(ctx, lib); found != "" {
+			log.Println("WARNING:", filepath.Base(found), "is part of the GNOME 2 platform which is no longer")
+			log.Println("available on many target systems; consider building wxWidgets without GNOME support")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/probonopd/go-appimage/internal/helpers"
+)
+
+// Locations in which distributions put the XKB data
+var xkbDataCandidates = []string{"/usr/share/X11/xkb", "/usr/local/share/X11/xkb", "/usr/lib/X11/xkb"}
+
+// Locations in which distributions put the X11 locale data including the Compose tables
+var xLocaleCandidates = []string{"/usr/share/X11/locale", "/usr/local/share/X11/locale", "/usr/lib/X11/locale"}
+
+// handleXkb bundles the XKB data and the X11 Compose tables if libxkbcommon is bundled,
+// and adds AppRun logic that prefers the data of the host system and falls back to the bundled data.
+// The bundled libxkbcommon has the path to the data of the build system compiled in, which may not
+// exist on the target system, and then the keyboard does not work at all in the application
+func handleXkb(ctx *deployContext) {
+	appdir := ctx.appdir
+	for _, lib := range ctx.elfs {
+		if strings.HasPrefix(filepath.Base(lib), "libxkbcommon.so") {
+			log.Println("Bundling XKB data (for XKB_CONFIG_ROOT)...")
+			xkb := findFirstExisting(xkbDataCandidates, "/rules/evdev")
+			if xkb == "" {
+				log.Println("Could not find XKB data, not bundling it")
+			} else {
+				err := helpers.CopyTree(xkb, appdir.Path+"/usr/share/X11/xkb")
+				if err != nil {
+					helpers.PrintError("Could not copy XKB data", err)
+				}
+			}
+
+			log.Println("Bundling X11 Compose tables (for XLOCALEDIR)...")
+			xlocale := findFirstExisting(xLocaleCandidates, "/compose.dir")
+			if xlocale == "" {
+				log.Println("Could not find X11 Compose tables, not bundling them")
+			} else {
+				err := helpers.CopyTree(xlocale, appdir.Path+"/usr/share/X11/locale")
+				if err != nil {
+					helpers.PrintError("Could not copy X11 Compose tables", err)
+				}
+			}
+
+			addAppRunSection(ctx, "Use XKB data and Compose tables of the host system if available, bundled ones otherwise", `
+if [ -z "${XKB_CONFIG_ROOT}" ] ; then
+  for XKB in `+strings.Join(xkbDataCandidates, " ")+` "${HERE}"/usr/share/X11/xkb ; do
+    if [ -e "${XKB}"/rules/evdev ] ; then
+      export XKB_CONFIG_ROOT="${XKB}"
+      break
+    fi
+  done
+fi
+if [ -z "${XLOCALEDIR}" ] ; then
+  for XLOCALE in `+strings.Join(xLocaleCandidates, " ")+` "${HERE}"/usr/share/X11/locale ; do
+    if [ -e "${XLOCALE}"/compose.dir ] ; then
+      export XLOCALEDIR="${XLOCALE}"
+      break
+    fi
+  done
+fi`)
+			break
+		}
+	}
+}
+
+// findFirstExisting returns the first of the candidate directories
+// that contains the file at the relative path marker, or an empty string
+func findFirstExisting(candidates []string, marker string) string {
+	for _, candidate := range candidates {
+		if helpers.Exists(candidate + marker) {
+			return candidate
+		}
+	}
+	return ""
+}
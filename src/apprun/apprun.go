@@ -0,0 +1,231 @@
+// apprun is a small launcher that can be used as the AppRun of an AppDir instead of a shell script.
+// It sets up the environment as described in AppRun.env next to it and then executes the main
+// executable. Unlike the shell script, it does not depend on /bin/sh and the tools it calls,
+// and paths containing spaces or other special characters are passed on unchanged.
+// It must not use cgo so that it is built as a static executable
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Name of the file next to the launcher from which the environment is read.
+// Each line is NAME=VALUE or POLICY NAME=VALUE, in which ${HERE} is replaced by the
+// directory of the launcher and ${NAME} by the current value of the environment variable NAME.
+// POLICY says how VALUE is combined with the value the variable already has, and can be
+// overridden by setting APPDIR_<NAME>_POLICY. It is one of prepend, append, replace
+// (the default), and skip-if-set. Lines starting with # are ignored.
+// The following names have a special meaning:
+//
+//	APPRUN_EXEC         The executable to be launched
+//	APPRUN_INTERPRETER  The ELF interpreter to launch the executable with, if bundled
+const envFileName = "AppRun.env"
+
+// Path relative to the launcher of the file that lists the directories in which the bundled
+// ld-linux looks for libraries, one per line and relative to the AppDir, like /etc/ld.so.conf.
+// They are passed to it with --library-path, together with --inhibit-cache so that it does not
+// use the ld.so.cache of the system
+const ldSoConfPath = "etc/ld.so.conf"
+
+func main() {
+	self, err := os.Executable()
+	if err != nil {
+		fail(err)
+	}
+	self, err = filepath.EvalSymlinks(self)
+	if err != nil {
+		fail(err)
+	}
+	here := filepath.Dir(self)
+
+	executable, interpreter, err := setupEnvironment(here)
+	if err != nil {
+		fail(err)
+	}
+	if executable == "" {
+		fail(fmt.Errorf("APPRUN_EXEC is missing in %s", filepath.Join(here, envFileName)))
+	}
+
+	args := append([]string{executable}, os.Args[1:]...)
+	if interpreter != "" {
+		if libraryPath := readLibraryPath(here); libraryPath != "" {
+			args = append([]string{"--inhibit-cache", "--library-path", libraryPath}, args...)
+		}
+		args = append([]string{interpreter}, args...)
+	}
+	err = syscall.Exec(args[0], args, os.Environ())
+	fail(fmt.Errorf("could not execute %s: %v", args[0], err))
+}
+
+// setupEnvironment sets the environment variables from the file envFileName in here,
+// in the order in which they are in the file, and returns the executable and interpreter
+func setupEnvironment(here string) (string, string, error) {
+	f, err := os.Open(filepath.Join(here, envFileName))
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var executable, interpreter string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy := "replace"
+		if fields := strings.SplitN(line, " ", 2); len(fields) == 2 && strings.Contains(fields[0], "=") == false {
+			policy = fields[0]
+			line = strings.TrimSpace(fields[1])
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("invalid line in %s: %s", envFileName, line)
+		}
+		value := expand(parts[1], here)
+		switch parts[0] {
+		case "APPRUN_EXEC":
+			executable = value
+		case "APPRUN_INTERPRETER":
+			interpreter = value
+		default:
+			err = setenv(parts[0], value, policy)
+			if err != nil {
+				return "", "", err
+			}
+		}
+	}
+	return executable, interpreter, scanner.Err()
+}
+
+// readLibraryPath returns the directories listed in the file ldSoConfPath in here as a
+// colon-separated list of absolute paths, or an empty string if there is no such file
+func readLibraryPath(here string) string {
+	data, err := ioutil.ReadFile(filepath.Join(here, ldSoConfPath))
+	if err != nil {
+		return ""
+	}
+	var dirs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "/") {
+			dirs = append(dirs, here+line)
+		}
+	}
+	return strings.Join(dirs, ":")
+}
+
+// setenv sets the environment variable name to value according to policy,
+// unless the policy is overridden by APPDIR_<name>_POLICY.
+// Unknown policies, e.g., misspelled overrides, are treated as prepend like the shell script AppRun does
+func setenv(name string, value string, policy string) error {
+	if override := os.Getenv("APPDIR_" + name + "_POLICY"); override != "" {
+		policy = override
+	}
+	switch policy {
+	case "prepend", "append", "replace", "skip-if-set":
+	default:
+		fmt.Fprintln(os.Stderr, "AppRun: Unknown policy", policy, "for", name+", using prepend")
+		policy = "prepend"
+	}
+	current := os.Getenv(name)
+	if current == "" {
+		return os.Setenv(name, value)
+	}
+	switch policy {
+	case "append":
+		return os.Setenv(name, current+":"+value)
+	case "replace":
+		return os.Setenv(name, value)
+	case "skip-if-set":
+		return nil
+	}
+	return os.Setenv(name, value+":"+current)
+}
+
+// expand replaces ${HERE} and references to environment variables in value.
+// If value is a colon-separated list, then empty elements resulting from
+// unset variables are removed, because they would mean the current directory
+func expand(value string, here string) string {
+	value = expandVariables(value, func(name string) string {
+		if name == "HERE" {
+			return here
+		}
+		return os.Getenv(name)
+	})
+	if strings.Contains(value, ":") == false {
+		return value
+	}
+	var elements []string
+	for _, element := range strings.Split(value, ":") {
+		if element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return strings.Join(elements, ":")
+}
+
+// expandVariables replaces $NAME, ${NAME} and ${NAME:-default} in value like the shell script AppRun does,
+// using lookup for the values of the variables. default may contain references itself
+func expandVariables(value string, lookup func(string) string) string {
+	var expanded strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			expanded.WriteByte(value[i])
+			continue
+		}
+		if value[i+1] == '{' {
+			end, depth := -1, 0
+			for j := i + 1; j < len(value) && end < 0; j++ {
+				switch value[j] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+					if depth == 0 {
+						end = j
+					}
+				}
+			}
+			if end < 0 {
+				expanded.WriteString(value[i:])
+				break
+			}
+			reference := value[i+2 : end]
+			if k := strings.Index(reference, ":-"); k >= 0 {
+				v := lookup(reference[:k])
+				if v == "" {
+					v = expandVariables(reference[k+2:], lookup)
+				}
+				expanded.WriteString(v)
+			} else {
+				expanded.WriteString(lookup(reference))
+			}
+			i = end
+			continue
+		}
+		j := i + 1
+		for j < len(value) && (value[j] == '_' || value[j] >= '0' && value[j] <= '9' ||
+			value[j] >= 'a' && value[j] <= 'z' || value[j] >= 'A' && value[j] <= 'Z') {
+			j++
+		}
+		if j == i+1 {
+			expanded.WriteByte('$')
+			continue
+		}
+		expanded.WriteString(lookup(value[i+1 : j]))
+		i = j - 1
+	}
+	return expanded.String()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "AppRun:", err)
+	os.Exit(1)
+}
@@ -48,6 +48,7 @@ func NewAppImage(path string) (*AppImage, error) {
 		strings.HasSuffix(path, ".part") ||
 		strings.HasSuffix(path, ".partial") ||
 		strings.HasSuffix(path, ".zs-old") ||
+		strings.HasSuffix(path, ".zs-part") ||
 		strings.HasSuffix(path, ".crdownload") {
 		return &ai, errors.New("Given path is a temporary file")
 	}
@@ -150,6 +151,12 @@ func (ai AppImage) ExtractFile(filepath string, destinationdirpath string, resol
 	return ai.reader.ExtractTo(filepath, destinationdirpath, resolveSymlinks)
 }
 
+//ListFiles returns the names of the files and folders in the folder at path in the AppImage.
+//Returns nil if the path is not a folder.
+func (ai AppImage) ListFiles(path string) []string {
+	return ai.reader.ListFiles(path)
+}
+
 //ExtractFileReader tries to get an io.ReadCloser for the file at filepath.
 //Returns an error if the path is pointing to a folder. If the path is pointing to a symlink,
 //it will try to return the file being pointed to, but only if it's within the AppImage.
@@ -221,8 +228,8 @@ func runCommand(cmd *exec.Cmd) (bytes.Buffer, error) {
 //it will try to get that information from the squashfs, if not, it returns the file's ModTime.
 func (ai AppImage) ModTime() time.Time {
 	if ai.imageType == 2 {
-		if ai.reader != nil {
-			return ai.reader.(*type2Reader).rdr.ModTime()
+		if r, ok := ai.reader.(*type2Reader); ok && r.rdr != nil {
+			return r.rdr.ModTime()
 		}
 		result, err := exec.Command("unsquashfs", "-q", "-fstime", "-o", strconv.FormatInt(ai.offset, 10), ai.Path).Output()
 		resstr := strings.TrimSpace(string(bytes.TrimSpace(result)))
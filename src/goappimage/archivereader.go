@@ -12,7 +12,6 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/CalebQ42/squashfs"
 	ioutilextra "gopkg.in/src-d/go-git.v4/utils/ioutil"
 )
 
@@ -53,7 +52,7 @@ func (ai *AppImage) populateReader(allowFallback, forceFallback bool) (err error
 
 //TODO: Implement command based fallback here.
 type type2Reader struct {
-	rdr             *squashfs.Reader
+	rdr             *squashfsReader
 	structure       map[string][]string
 	path            string
 	folders         []string
@@ -79,7 +78,7 @@ func newType2Reader(ai *AppImage, fallbackAllowed, forceFallback bool) (*type2Re
 	}
 	stat, _ := aiFil.Stat()
 	aiRdr := io.NewSectionReader(aiFil, ai.offset, stat.Size()-ai.offset)
-	squashRdr, err := squashfs.NewSquashfsReader(aiRdr)
+	squashRdr, err := newSquashfsReader(aiRdr)
 	if err != nil {
 		if fallbackAllowed {
 			//If there are errors, we force the use of unsquashfs.
@@ -430,9 +429,21 @@ type type1Reader struct {
 	structure map[string][]string //[folder]File
 	path      string
 	folders   []string
+	iso       *isoImage //nil if the ISO9660 image could not be read natively, then bsdtar is used
 }
 
 func newType1Reader(filepath string) (*type1Reader, error) {
+	iso, err := openISO(filepath)
+	if err == nil {
+		rdr := type1Reader{path: filepath, iso: iso, structure: iso.children}
+		for dir := range iso.children {
+			if dir != "/" {
+				rdr.folders = append(rdr.folders, dir)
+			}
+		}
+		sort.Strings(rdr.folders)
+		return &rdr, nil
+	}
 	cmd := exec.Command("bsdtar", "-f", filepath, "-t")
 	wrt, err := runCommand(cmd)
 	if err != nil {
@@ -518,6 +529,9 @@ func (r *type1Reader) FileReader(filepath string) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
+	if r.iso != nil {
+		return r.iso.reader(r.SymlinkPathRecursive(filepath))
+	}
 	cmd := exec.Command("bsdtar", "-f", r.path, "-xO", filepath)
 	var out bytes.Buffer
 	cmd.Stdout = &out
@@ -541,6 +555,12 @@ func (r *type1Reader) SymlinkPath(filepath string) string {
 	if err != nil {
 		return filepath
 	}
+	if r.iso != nil {
+		if entry, ok := r.iso.entries[filepath]; ok && entry.link != "" {
+			return entry.link
+		}
+		return filepath
+	}
 	cmd := exec.Command("bsdtar", "-f", r.path, "-tv", filepath)
 	wrt, err := runCommand(cmd)
 	if err != nil {
@@ -559,6 +579,16 @@ func (r *type1Reader) SymlinkPathRecursive(filepath string) string {
 	if err != nil {
 		return filepath
 	}
+	if r.iso != nil {
+		for i := 0; i < 40; i++ { // Guard against symlink loops
+			entry, ok := r.iso.entries[filepath]
+			if ok == false || entry.link == "" || strings.HasPrefix(entry.link, "/") {
+				break //we can't help with absolute symlinks...
+			}
+			filepath = strings.TrimPrefix(path.Clean(path.Dir(filepath)+"/"+entry.link), "/")
+		}
+		return filepath
+	}
 	cmd := exec.Command("bsdtar", "-f", r.path, "-tv", filepath)
 	wrt, err := runCommand(cmd)
 	if err != nil {
@@ -624,6 +654,13 @@ func (r *type1Reader) ExtractTo(filepath, destination string, resolveSymlinks bo
 	if resolveSymlinks {
 		filepath = r.SymlinkPathRecursive(filepath)
 	}
+	if r.iso != nil {
+		err = r.iso.extract(filepath, tmpDir+"/"+name)
+		if err != nil {
+			return err
+		}
+		return os.Rename(tmpDir+"/"+name, destination+"/"+name)
+	}
 	cmd := exec.Command("bsdtar", "-C", tmpDir, "-f", r.path, "-x", filepath)
 	_, err = runCommand(cmd)
 	if err != nil {
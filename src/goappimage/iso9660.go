@@ -0,0 +1,367 @@
+package goappimage
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Type-1 AppImages are ISO9660 images with Rock Ridge extensions (for long names, permissions and symlinks)
+// that start with an ELF. isoImage reads them natively, so that no external tools are needed
+
+// Offset of the primary volume descriptor (sector 16 with 2048 bytes per sector)
+const isoPrimaryVolumeDescriptorOffset = 16 * 2048
+
+// Offset and length of the update information in type-1 AppImages,
+// in the application use area of the primary volume descriptor
+const (
+	Type1UpdateInformationOffset = 33651
+	Type1UpdateInformationLength = 512
+)
+
+// Maximum number of files, directories and symlinks in an ISO9660 image, so that crafted images
+// cannot make reading the tree take forever, far more than AppImages have
+const isoMaxEntries = 1 << 20
+
+// isoEntry is a file, directory or symlink in an ISO9660 image
+type isoEntry struct {
+	isDir  bool
+	mode   os.FileMode // Only if the image has Rock Ridge extensions
+	extent int64       // Offset of the data in the image
+	size   int64
+	link   string // Target if this is a symlink
+}
+
+// isoImage is the directory tree of an ISO9660 image
+type isoImage struct {
+	path      string
+	file      *os.File // Only open while reading the tree or extracting
+	size      int64    // Of the file, which nothing in the image can be larger than
+	blockSize int64
+	entries   map[string]*isoEntry // Paths relative to the root, without leading slash
+	children  map[string][]string  // Names in each directory, "/" for the root
+	visited   map[int64]bool       // Extents of the directories read so far, only while reading the tree
+	rockRidge bool
+	suspSkip  int // Bytes to skip at the beginning of each system use area, from the SP entry
+}
+
+// openISO reads the directory tree of the ISO9660 image at path.
+// The file is not kept open, so that many images can be handled at once
+func openISO(filepath string) (*isoImage, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pvd := make([]byte, 2048)
+	_, err = f.ReadAt(pvd, isoPrimaryVolumeDescriptorOffset)
+	if err != nil {
+		return nil, err
+	}
+	if pvd[0] != 1 || string(pvd[1:6]) != "CD001" {
+		return nil, errors.New("no ISO9660 primary volume descriptor found")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	iso := &isoImage{
+		path:      filepath,
+		file:      f,
+		size:      info.Size(),
+		blockSize: int64(binary.LittleEndian.Uint16(pvd[128:])),
+		entries:   make(map[string]*isoEntry),
+		children:  make(map[string][]string),
+		visited:   make(map[int64]bool),
+	}
+	if iso.blockSize != 512 && iso.blockSize != 1024 && iso.blockSize != 2048 {
+		return nil, errors.New("invalid block size in the ISO9660 image")
+	}
+	root := pvd[156 : 156+34]
+	rootExtent := int64(binary.LittleEndian.Uint32(root[2:])) * iso.blockSize
+	rootSize := int64(binary.LittleEndian.Uint32(root[10:]))
+	err = iso.readDirectory("", rootExtent, rootSize, 0)
+	iso.file = nil
+	iso.visited = nil
+	if err != nil {
+		return nil, err
+	}
+	for dir := range iso.children {
+		sort.Strings(iso.children[dir])
+	}
+	return iso, nil
+}
+
+// readDirectory adds the records of the directory at extent to the tree
+func (iso *isoImage) readDirectory(dir string, extent int64, size int64, depth int) error {
+	if depth > 64 {
+		return errors.New("directories in the ISO9660 image are nested too deeply")
+	}
+	if size > 0 && (size > iso.size || extent > iso.size-size) {
+		return errors.New("directory record in the ISO9660 image points outside of it")
+	}
+	// Directories whose records point back at themselves or their parents would be read again and again
+	if size > 0 && iso.visited[extent] {
+		return errors.New("directory " + dir + " in the ISO9660 image contains itself")
+	}
+	iso.visited[extent] = true
+	data := make([]byte, size)
+	_, err := iso.file.ReadAt(data, extent)
+	if err != nil {
+		return err
+	}
+	var subdirectories []string
+	for position := 0; position < len(data); {
+		length := int(data[position])
+		if length == 0 {
+			// Records do not span sectors, the rest of this one is padding
+			position = (position/int(iso.blockSize) + 1) * int(iso.blockSize)
+			continue
+		}
+		if length < 34 || position+length > len(data) {
+			return errors.New("invalid directory record in the ISO9660 image")
+		}
+		record := data[position : position+length]
+		position += length
+
+		nameLength := int(record[32])
+		if 33+nameLength > len(record) {
+			return errors.New("invalid directory record in the ISO9660 image")
+		}
+		name := string(record[33 : 33+nameLength])
+		systemUse := record[33+nameLength:]
+		if nameLength%2 == 0 && len(systemUse) > 0 {
+			systemUse = systemUse[1:] // Padding
+		}
+		if nameLength == 1 && (name == "\x00" || name == "\x01") {
+			// "." and ".."; the SP entry in "." of the root tells whether there are Rock Ridge extensions
+			if dir == "" && name == "\x00" && len(systemUse) >= 7 && string(systemUse[0:2]) == "SP" {
+				iso.rockRidge = true
+				iso.suspSkip = int(systemUse[6])
+			}
+			continue
+		}
+
+		entry := &isoEntry{
+			isDir:  record[25]&2 != 0,
+			extent: int64(binary.LittleEndian.Uint32(record[2:])) * iso.blockSize,
+			size:   int64(binary.LittleEndian.Uint32(record[10:])),
+		}
+		// Empty files and symlinks may have any extent
+		if entry.size > 0 && (entry.size > iso.size || entry.extent > iso.size-entry.size) {
+			return errors.New("directory record in the ISO9660 image points outside of it")
+		}
+		if iso.rockRidge && len(systemUse) > iso.suspSkip {
+			rrName, relocated := iso.readRockRidge(systemUse[iso.suspSkip:], entry)
+			if relocated {
+				continue
+			}
+			if rrName != "" {
+				name = rrName
+			}
+		} else {
+			// Plain ISO9660 names have a version and may end with a dot
+			name = strings.TrimSuffix(strings.SplitN(name, ";", 2)[0], ".")
+		}
+		// Such names would make the extraction write outside of the destination
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+			return errors.New("invalid name " + name + " in the ISO9660 image")
+		}
+		entryPath := name
+		if dir != "" {
+			entryPath = dir + "/" + name
+		}
+		if len(iso.entries) >= isoMaxEntries {
+			return errors.New("the ISO9660 image has too many files")
+		}
+		if _, ok := iso.entries[entryPath]; ok {
+			return errors.New(entryPath + " is in the ISO9660 image more than once")
+		}
+		parent := dir
+		if parent == "" {
+			parent = "/"
+		}
+		iso.entries[entryPath] = entry
+		iso.children[parent] = append(iso.children[parent], name)
+		if entry.isDir {
+			subdirectories = append(subdirectories, entryPath)
+		}
+	}
+	for _, subdirectory := range subdirectories {
+		entry := iso.entries[subdirectory]
+		if _, ok := iso.children[subdirectory]; ok == false {
+			iso.children[subdirectory] = []string{}
+		}
+		err = iso.readDirectory(subdirectory, entry.extent, entry.size, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRockRidge reads the System Use Sharing Protocol entries of a directory record into entry
+// and returns the Rock Ridge name, and whether the record is a relocated directory to be skipped
+func (iso *isoImage) readRockRidge(systemUse []byte, entry *isoEntry) (string, bool) {
+	var name string
+	var link []string
+	linkContinues := false
+	for areas := 0; len(systemUse) >= 4 && areas < 16; {
+		signature := string(systemUse[0:2])
+		length := int(systemUse[2])
+		if length < 4 || length > len(systemUse) {
+			break
+		}
+		body := systemUse[4:length]
+		switch signature {
+		case "NM":
+			if len(body) >= 1 && body[0]&6 == 0 {
+				name += string(body[1:])
+			}
+		case "PX":
+			if len(body) >= 4 {
+				entry.mode = os.FileMode(binary.LittleEndian.Uint32(body[0:])) & os.ModePerm
+			}
+		case "SL":
+			if len(body) >= 1 {
+				link, linkContinues = appendSymlinkComponents(link, linkContinues, body[1:])
+				entry.link = strings.Join(link, "/")
+				if entry.link == "" && len(link) > 0 {
+					entry.link = "/"
+				}
+			}
+		case "RE":
+			return "", true
+		case "CL":
+			// Deep directory relocated elsewhere, which AppImages do not have
+		case "CE":
+			// The entries continue in another block
+			if len(body) >= 24 {
+				block := int64(binary.LittleEndian.Uint32(body[0:]))
+				offset := int64(binary.LittleEndian.Uint32(body[8:]))
+				size := int64(binary.LittleEndian.Uint32(body[16:]))
+				if size > iso.blockSize || offset > iso.blockSize-size {
+					// Continuation areas are within one block
+					break
+				}
+				continuation := make([]byte, size)
+				if _, err := iso.file.ReadAt(continuation, block*iso.blockSize+offset); err == nil {
+					systemUse = continuation
+					areas++
+					continue
+				}
+			}
+		case "ST":
+			return name, false
+		}
+		systemUse = systemUse[length:]
+	}
+	return name, false
+}
+
+// appendSymlinkComponents appends the components of the body of an SL entry to link.
+// A component that continues in the next SL entry is joined with the next one
+func appendSymlinkComponents(link []string, continues bool, components []byte) ([]string, bool) {
+	for len(components) >= 2 {
+		flags := components[0]
+		length := int(components[1])
+		if 2+length > len(components) {
+			break
+		}
+		var component string
+		switch {
+		case flags&2 != 0:
+			component = "."
+		case flags&4 != 0:
+			component = ".."
+		case flags&8 != 0:
+			component = "" // Root, joined into a leading slash
+		default:
+			component = string(components[2 : 2+length])
+		}
+		if continues && len(link) > 0 {
+			link[len(link)-1] += component
+		} else {
+			link = append(link, component)
+		}
+		continues = flags&1 != 0
+		components = components[2+length:]
+	}
+	return link, continues
+}
+
+// reader returns a reader for the contents of the file at p
+func (iso *isoImage) reader(p string) (io.ReadCloser, error) {
+	entry, ok := iso.entries[p]
+	if ok == false {
+		return nil, errors.New("File not found in the archive")
+	}
+	if entry.isDir {
+		return nil, errors.New("Path is a directory")
+	}
+	if entry.link != "" {
+		return nil, errors.New("Path is a symlink")
+	}
+	f, err := os.Open(iso.path)
+	if err != nil {
+		return nil, err
+	}
+	return sectionReadCloser{io.NewSectionReader(f, entry.extent, entry.size), f}, nil
+}
+
+// sectionReadCloser reads a part of a file and closes the file when done
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// extract writes the file, symlink or directory (recursively) at p to destination
+func (iso *isoImage) extract(p string, destination string) error {
+	f, err := os.Open(iso.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return iso.extractFrom(f, p, destination)
+}
+
+func (iso *isoImage) extractFrom(f *os.File, p string, destination string) error {
+	entry, ok := iso.entries[p]
+	if ok == false {
+		return errors.New("File not found in the archive")
+	}
+	mode := entry.mode
+	if entry.isDir {
+		if mode == 0 {
+			mode = 0755
+		}
+		err := os.MkdirAll(destination, mode)
+		if err != nil {
+			return err
+		}
+		for _, child := range iso.children[p] {
+			err = iso.extractFrom(f, path.Join(p, child), destination+"/"+child)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if entry.link != "" {
+		return os.Symlink(entry.link, destination)
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, io.NewSectionReader(f, entry.extent, entry.size))
+	return err
+}
@@ -0,0 +1,124 @@
+package goappimage
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestType1Reader(t *testing.T) {
+	if _, err := exec.LookPath("bsdtar"); err != nil {
+		t.Skip("bsdtar is needed to create the ISO9660 image")
+	}
+	dir, err := ioutil.TempDir("", "iso9660")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir+"/AppDir/usr/share/applications", 0755)
+	os.MkdirAll(dir+"/AppDir/usr/bin", 0755)
+	ioutil.WriteFile(dir+"/AppDir/usr/share/applications/some-application.desktop", []byte("[Desktop Entry]\nName=Some\n"), 0644)
+	ioutil.WriteFile(dir+"/AppDir/usr/bin/some-application", []byte("#!/bin/sh\n"), 0755)
+	os.Symlink("usr/share/applications/some-application.desktop", dir+"/AppDir/some-application.desktop")
+	err = exec.Command("bsdtar", "-C", dir+"/AppDir", "--format", "iso9660", "-cf", dir+"/Some.iso", ".").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newType1Reader(dir + "/Some.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.iso == nil {
+		t.Fatal("The ISO9660 image was not read natively")
+	}
+	if r.IsDir("usr/bin") == false || r.Contains("usr/bin/some-application") == false {
+		t.Errorf("Unexpected structure: %v", r.structure)
+	}
+	if link := r.SymlinkPath("*.desktop"); link != "usr/share/applications/some-application.desktop" {
+		t.Errorf("Unexpected symlink target %s", link)
+	}
+	rdr, err := r.FileReader("*.desktop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(rdr)
+	rdr.Close()
+	if string(data) != "[Desktop Entry]\nName=Some\n" {
+		t.Errorf("Unexpected contents: %q", data)
+	}
+	err = r.ExtractTo("usr/bin", dir+"/extracted", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir + "/extracted/bin/some-application")
+	if err != nil || info.Mode()&0111 == 0 {
+		t.Errorf("Unexpected extracted file: %v, %v", info, err)
+	}
+}
+
+// writeISO writes an ISO9660 image whose root directory at block 17 consists of records,
+// and returns its path. rootSize is the size of the root directory given in the primary volume descriptor
+func writeISO(t *testing.T, rootSize uint32, records ...[]byte) string {
+	image := make([]byte, 18*2048)
+	pvd := image[isoPrimaryVolumeDescriptorOffset:]
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	binary.LittleEndian.PutUint16(pvd[128:], 2048)
+	binary.LittleEndian.PutUint32(pvd[156+2:], 17)
+	binary.LittleEndian.PutUint32(pvd[156+10:], rootSize)
+	position := 17 * 2048
+	for _, record := range records {
+		position += copy(image[position:], record)
+	}
+	path := t.TempDir() + "/test.iso"
+	if err := ioutil.WriteFile(path, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// isoRecord returns a directory record with name, whose length is given by length if not zero
+func isoRecord(name string, length int) []byte {
+	if length == 0 {
+		length = 33 + len(name) + (len(name)+1)%2
+	}
+	record := make([]byte, length)
+	record[0] = byte(length)
+	record[32] = byte(len(name))
+	copy(record[33:], name)
+	return record
+}
+
+// isoDirectoryRecord returns a directory record with name for the directory at block of size bytes
+func isoDirectoryRecord(name string, block uint32, size uint32) []byte {
+	record := isoRecord(name, 0)
+	binary.LittleEndian.PutUint32(record[2:], block)
+	binary.LittleEndian.PutUint32(record[10:], size)
+	record[25] = 2
+	return record
+}
+
+func TestMalformedISO(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		path        string
+	}{
+		{"a name longer than the record", writeISO(t, 2048, isoRecord("\x00", 0), isoRecord("\x01", 0), []byte{34, 31: 0, 32: 200, 33: 0})},
+		{"a root directory larger than the image", writeISO(t, 0xffffffff)},
+		{"a name with a slash", writeISO(t, 2048, isoRecord("../evil", 0))},
+		{"a name that occurs twice", writeISO(t, 2048, isoRecord("FOO", 0), isoRecord("FOO", 0))},
+		{"a directory that contains itself", writeISO(t, 2048, isoDirectoryRecord("A", 17, 2048), isoDirectoryRecord("B", 17, 2048))},
+	} {
+		if _, err := openISO(tc.path); err == nil {
+			t.Error("Accepted an image with", tc.description)
+		}
+	}
+	// An even name length without the padding byte leaves no system use area
+	iso, err := openISO(writeISO(t, 2048, isoRecord("FO", 35)))
+	if err != nil || iso.entries["FO"] == nil {
+		t.Error("Did not read a record without a system use area:", err)
+	}
+}
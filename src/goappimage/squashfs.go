@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package goappimage
+
+import (
+	"io"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+// squashfsReader reads type-2 AppImages natively, see squashfs_windows.go for Windows
+type squashfsReader = squashfs.Reader
+
+func newSquashfsReader(r io.ReaderAt) (*squashfsReader, error) {
+	return squashfs.NewSquashfsReader(r)
+}
@@ -0,0 +1,31 @@
+package goappimage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// The squashfs library does not build on Windows, hence unsquashfs is always used there.
+// squashfsReader and squashfsFile only exist so that type2Reader compiles, they are never used
+type squashfsReader struct{}
+
+type squashfsFile struct{}
+
+func newSquashfsReader(r io.ReaderAt) (*squashfsReader, error) {
+	return nil, errors.New("squashfs cannot be read natively on Windows, please install unsquashfs")
+}
+
+func (r *squashfsReader) GetFileAtPath(filepath string) *squashfsFile { return nil }
+func (r *squashfsReader) ModTime() time.Time                          { return time.Time{} }
+
+func (f *squashfsFile) Name() string                           { return "" }
+func (f *squashfsFile) Path() string                           { return "" }
+func (f *squashfsFile) IsDir() bool                            { return false }
+func (f *squashfsFile) IsSymlink() bool                        { return false }
+func (f *squashfsFile) SymlinkPath() string                    { return "" }
+func (f *squashfsFile) GetSymlinkFileRecursive() *squashfsFile { return nil }
+func (f *squashfsFile) GetChildren() ([]*squashfsFile, error)  { return nil, nil }
+func (f *squashfsFile) Read(p []byte) (int, error)             { return 0, io.EOF }
+func (f *squashfsFile) ExtractTo(path string) []error          { return nil }
+func (f *squashfsFile) ExtractSymlink(path string) []error     { return nil }